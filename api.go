@@ -19,7 +19,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -84,6 +86,76 @@ type FileMatch struct {
 	// RepositoryID is a Sourcegraph extension. This is the ID of Repository in
 	// Sourcegraph.
 	RepositoryID uint32 `json:",omitempty"`
+
+	// Secrets are the possible secrets in the file, found at index time. It
+	// is only set if SearchOptions.Secrets is true.
+	Secrets []SecretAnnotation `json:",omitempty"`
+
+	// Encoding is the encoding the file was transcoded to UTF-8 from at index
+	// time, eg. "Shift_JIS". It is empty for UTF-8 files. Content and matches
+	// are always UTF-8.
+	Encoding string `json:",omitempty"`
+
+	// SymbolRole is SymbolRoleDefinition if any of the matches is on a symbol
+	// definition.
+	SymbolRole SymbolRole `json:",omitempty"`
+}
+
+// DirectoryMatch aggregates the file matches in a directory. It is returned
+// instead of FileMatch for queries with type:dir.
+type DirectoryMatch struct {
+	Repository string
+
+	// RepositoryID is a Sourcegraph extension. This is the ID of Repository in
+	// Sourcegraph.
+	RepositoryID uint32 `json:",omitempty"`
+
+	// Directory is the repository-relative path of the directory, without
+	// trailing slash. It is "." for the root of the repository.
+	Directory string
+
+	// FileCount is the number of matching files directly in Directory. Files in
+	// subdirectories are counted by the DirectoryMatch of their subdirectory.
+	FileCount int
+
+	// MatchCount is the number of matches in those files.
+	MatchCount int
+}
+
+func (m *DirectoryMatch) sizeBytes() (sz uint64) {
+	// Repository, Directory
+	sz += 2*stringHeaderBytes + uint64(len(m.Repository)+len(m.Directory))
+
+	// RepositoryID
+	sz += 4
+
+	// FileCount, MatchCount
+	sz += 2 * 8
+
+	return
+}
+
+// SymbolRole says how a match relates to the symbols found by ctags.
+type SymbolRole string
+
+const (
+	// SymbolRoleNone is for matches which aren't on a symbol.
+	SymbolRoleNone SymbolRole = ""
+
+	// SymbolRoleDefinition is for matches which coincide with a symbol
+	// definition. ctags only finds definitions, so other occurrences of a
+	// symbol are plain text matches.
+	SymbolRoleDefinition SymbolRole = "definition"
+)
+
+// SecretAnnotation is a line of a file that looks like it contains a
+// credential, like an API key.
+type SecretAnnotation struct {
+	// RuleID is the rule that matched, eg. "aws-access-key-id".
+	RuleID string
+
+	// LineNumber is the 1-based line number of the match.
+	LineNumber int
 }
 
 func (m *FileMatch) sizeBytes() (sz uint64) {
@@ -95,6 +167,8 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 		m.FileName,
 		m.Repository,
 		m.Language,
+		m.Encoding,
+		string(m.SymbolRole),
 		m.SubRepositoryName,
 		m.SubRepositoryPath,
 		m.Version,
@@ -132,6 +206,12 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 	// Checksum
 	sz += sliceHeaderBytes + uint64(len(m.Checksum))
 
+	// Secrets
+	sz += sliceHeaderBytes
+	for _, s := range m.Secrets {
+		sz += stringHeaderBytes + uint64(len(s.RuleID)) + 8
+	}
+
 	return
 }
 
@@ -162,6 +242,14 @@ type ChunkMatch struct {
 	ContentStart Location
 
 	Score float64
+
+	// SymbolRole is SymbolRoleDefinition if one of Ranges matched a symbol
+	// definition.
+	SymbolRole SymbolRole `json:",omitempty"`
+
+	// Tokens is the syntax highlighting of Content. It is only set if
+	// SearchOptions.Highlight is true.
+	Tokens []HighlightToken `json:",omitempty"`
 }
 
 func (cm *ChunkMatch) sizeBytes() (sz uint64) {
@@ -195,6 +283,15 @@ func (cm *ChunkMatch) sizeBytes() (sz uint64) {
 	// DebugScore
 	sz += stringHeaderBytes + uint64(len(cm.DebugScore))
 
+	// SymbolRole
+	sz += stringHeaderBytes + uint64(len(cm.SymbolRole))
+
+	// Tokens
+	sz += sliceHeaderBytes
+	for _, t := range cm.Tokens {
+		sz += t.sizeBytes()
+	}
+
 	return
 }
 
@@ -247,6 +344,14 @@ type LineMatch struct {
 	DebugScore string
 
 	LineFragments []LineFragmentMatch
+
+	// SymbolRole is SymbolRoleDefinition if one of LineFragments matched a
+	// symbol definition.
+	SymbolRole SymbolRole `json:",omitempty"`
+
+	// Tokens is the syntax highlighting of Line. It is only set if
+	// SearchOptions.Highlight is true.
+	Tokens []HighlightToken `json:",omitempty"`
 }
 
 func (lm *LineMatch) sizeBytes() (sz uint64) {
@@ -271,15 +376,40 @@ func (lm *LineMatch) sizeBytes() (sz uint64) {
 	// DebugScore
 	sz += stringHeaderBytes + uint64(len(lm.DebugScore))
 
+	// SymbolRole
+	sz += stringHeaderBytes + uint64(len(lm.SymbolRole))
+
 	// LineFragments
 	sz += sliceHeaderBytes
 	for _, lf := range lm.LineFragments {
 		sz += lf.sizeBytes()
 	}
 
+	// Tokens
+	sz += sliceHeaderBytes
+	for _, t := range lm.Tokens {
+		sz += t.sizeBytes()
+	}
+
 	return
 }
 
+// HighlightToken is a syntax highlighted token of the Line of a LineMatch or
+// the Content of a ChunkMatch.
+type HighlightToken struct {
+	// Start and End are the byte offsets of the token in the line or
+	// content. End is exclusive.
+	Start, End uint32
+
+	// Class is the Pygments CSS class of the token, eg. "k" for keywords,
+	// "s" for strings or "c" for comments. Plain text isn't tokenized.
+	Class string
+}
+
+func (t *HighlightToken) sizeBytes() uint64 {
+	return 2*4 + stringHeaderBytes + uint64(len(t.Class))
+}
+
 type Symbol struct {
 	Sym        string
 	Kind       string
@@ -330,12 +460,50 @@ const (
 	FlushReasonTimerExpired FlushReason = 1 << iota
 	FlushReasonFinalFlush
 	FlushReasonMaxSize
+
+	// FlushReasonBudgetExceeded is set if the search was aborted because it
+	// exceeded SearchOptions.MaxContentBytesLoaded or MaxRegexpTime.
+	FlushReasonBudgetExceeded
+
+	// FlushReasonMemoryExceeded is set if the search was cut short because
+	// its results would have exceeded the memory the server reserves for
+	// the results of concurrent searches.
+	FlushReasonMemoryExceeded
+
+	// FlushReasonTimeout is set if documents or shards were skipped because
+	// the deadline of the search passed.
+	FlushReasonTimeout
+
+	// FlushReasonShardUnavailable is set if the results of a shard are
+	// missing because it crashed or wasn't loaded yet.
+	FlushReasonShardUnavailable
+
+	// FlushReasonMatchLimit is set if the search stopped early because it
+	// found SearchOptions.ShardMaxMatchCount or TotalMaxMatchCount matches.
+	FlushReasonMatchLimit
 )
 
 var FlushReasonStrings = map[FlushReason]string{
-	FlushReasonTimerExpired: "timer_expired",
-	FlushReasonFinalFlush:   "final_flush",
-	FlushReasonMaxSize:      "max_size_reached",
+	FlushReasonTimerExpired:     "timer_expired",
+	FlushReasonFinalFlush:       "final_flush",
+	FlushReasonMaxSize:          "max_size_reached",
+	FlushReasonBudgetExceeded:   "budget_exceeded",
+	FlushReasonMemoryExceeded:   "memory_exceeded",
+	FlushReasonTimeout:          "timeout",
+	FlushReasonShardUnavailable: "shard_unavailable",
+	FlushReasonMatchLimit:       "match_limit",
+}
+
+// Partial returns true if fr says the search gave up early, so there may be
+// more matches than it returned. The other flush reasons only say when
+// results were sent.
+func (fr FlushReason) Partial() bool {
+	switch fr {
+	case FlushReasonBudgetExceeded, FlushReasonMemoryExceeded, FlushReasonTimeout,
+		FlushReasonShardUnavailable, FlushReasonMatchLimit:
+		return true
+	}
+	return false
 }
 
 func (fr FlushReason) String() string {
@@ -410,6 +578,9 @@ type Stats struct {
 	// Number of times regexp was called on files that we evaluated.
 	RegexpsConsidered int
 
+	// Aggregate wall clock time spent matching regular expressions.
+	RegexpTime time.Duration
+
 	// FlushReason explains why results were flushed.
 	FlushReason FlushReason
 }
@@ -440,14 +611,24 @@ func (s *Stats) Add(o Stats) {
 	s.MatchTreeConstruction += o.MatchTreeConstruction
 	s.MatchTreeSearch += o.MatchTreeSearch
 	s.RegexpsConsidered += o.RegexpsConsidered
+	s.RegexpTime += o.RegexpTime
 
 	// We want the first non-zero FlushReason to be sticky. This is a useful
-	// property when aggregating stats from several Zoekts.
-	if s.FlushReason == 0 {
+	// property when aggregating stats from several Zoekts. A reason for
+	// giving up early replaces one which only says when results were sent,
+	// so clients can tell partial results from complete ones.
+	if s.FlushReason == 0 || (!s.FlushReason.Partial() && o.FlushReason.Partial()) {
 		s.FlushReason = o.FlushReason
 	}
 }
 
+// Partial returns true if the search may have missed matches, because it
+// gave up early or couldn't search all shards. Searches without matches
+// which aren't partial found everything there is.
+func (s *Stats) Partial() bool {
+	return s.FlushReason.Partial() || s.Crashes > 0 || s.ShardsSkipped > 0
+}
+
 // Zero returns true if stats is empty.
 func (s *Stats) Zero() bool {
 	if s == nil {
@@ -471,7 +652,8 @@ func (s *Stats) Zero() bool {
 		s.Wait > 0 ||
 		s.MatchTreeConstruction > 0 ||
 		s.MatchTreeSearch > 0 ||
-		s.RegexpsConsidered > 0)
+		s.RegexpsConsidered > 0 ||
+		s.RegexpTime > 0)
 }
 
 // Progress contains information about the global progress of the running search query.
@@ -502,6 +684,9 @@ type SearchResult struct {
 
 	Files []FileMatch
 
+	// Directories is set instead of Files for queries with type:dir.
+	Directories []DirectoryMatch `json:",omitempty"`
+
 	// RepoURLs holds a repo => template string map.
 	RepoURLs map[string]string
 
@@ -523,6 +708,12 @@ func (sr *SearchResult) SizeBytes() (sz uint64) {
 		sz += f.sizeBytes()
 	}
 
+	// Directories
+	sz += sliceHeaderBytes
+	for _, d := range sr.Directories {
+		sz += d.sizeBytes()
+	}
+
 	// RepoURLs
 	sz += mapHeaderBytes
 	for k, v := range sr.RepoURLs {
@@ -620,6 +811,25 @@ type Repository struct {
 	// FileTombstones is a set of file paths that should be ignored across all branches
 	// in this shard.
 	FileTombstones map[string]struct{} `json:",omitempty"`
+
+	// Metadata is arbitrary key/value metadata, eg. team or service, attached
+	// to the repository at index time. It can be searched with meta: queries.
+	Metadata map[string]string `json:",omitempty"`
+
+	// ForkOf is the name of the repository this repository was indexed as a
+	// fork of. Its shards only hold the files which differ from ForkOf, and
+	// searches see the other files of ForkOf as files of this repository, see
+	// NewForkSearcher.
+	ForkOf string `json:",omitempty"`
+
+	// ForkOfID is the ID of ForkOf, or 0 if the repositories don't have IDs.
+	// Forks and parents are matched by ID if they have one, and always
+	// belong to the same tenant.
+	ForkOfID uint32 `json:",omitempty"`
+
+	// ForkTombstones is the set of files of ForkOf which this repository
+	// changed or deleted.
+	ForkTombstones map[string]struct{} `json:",omitempty"`
 }
 
 func (r *Repository) UnmarshalJSON(data []byte) error {
@@ -722,6 +932,13 @@ func (r *Repository) MergeMutable(x *Repository) (mutated bool, err error) {
 		}
 	}
 
+	// Unlike RawConfig, Metadata is replaced so that keys can be removed. A nil
+	// map leaves it unchanged, since not every indexer sets it.
+	if x.Metadata != nil && !maps.Equal(r.Metadata, x.Metadata) {
+		mutated = true
+		r.Metadata = maps.Clone(x.Metadata)
+	}
+
 	if r.URL != x.URL {
 		mutated = true
 		r.URL = x.URL
@@ -753,6 +970,21 @@ type IndexMetadata struct {
 	LanguageMap           map[string]uint16
 	ZoektVersion          string
 	ID                    string
+
+	// NgramSize is the number of runes per indexed ngram. Zero means the
+	// default of 3, which is what shards written before this field existed
+	// use. Readers that predate this field would search shards of other
+	// sizes with trigrams, so those shards require NgramSizeFeatureVersion,
+	// see IndexMinReaderVersion.
+	NgramSize int `json:",omitempty"`
+
+	// CJKBigrams is true if the shard has postings for adjacent CJK runes, in
+	// addition to its regular ngrams.
+	CJKBigrams bool `json:",omitempty"`
+
+	// SymbolsOnly is true if the documents of the shard were reduced to their
+	// symbols at index time, see IndexBuilder.SymbolsOnly.
+	SymbolsOnly bool `json:",omitempty"`
 }
 
 // Statistics of a (collection of) repositories.
@@ -817,6 +1049,54 @@ type RepoListEntry struct {
 	Repository    Repository
 	IndexMetadata IndexMetadata
 	Stats         RepoStats
+
+	// Branches holds index information per indexed branch, in the order of
+	// Repository.Branches. Freshness monitoring can use it to find a single
+	// stale branch in a multi-branch repository.
+	Branches []BranchListEntry `json:",omitempty"`
+}
+
+// BranchListEntry describes the index of a single branch of a repository.
+type BranchListEntry struct {
+	Name string
+
+	// Version is the indexed commit.
+	Version string
+
+	// IndexTime is when the branch was indexed. If the repository is split
+	// over several shards, it is the oldest index time of those shards.
+	IndexTime time.Time
+
+	// Documents is the number of documents on the branch. Documents that are
+	// identical on several branches count for each of them.
+	Documents int
+}
+
+// Add merges the statistics of o, another shard of the same repository, into
+// r.
+func (r *RepoListEntry) Add(o *RepoListEntry) {
+	r.Stats.Add(&o.Stats)
+	if len(o.Branches) == 0 {
+		return
+	}
+
+	// Copy, since r.Branches may be shared with the shard that produced r.
+	branches := make([]BranchListEntry, len(r.Branches), len(r.Branches)+len(o.Branches))
+	copy(branches, r.Branches)
+	for _, ob := range o.Branches {
+		i := slices.IndexFunc(branches, func(b BranchListEntry) bool { return b.Name == ob.Name })
+		if i < 0 {
+			branches = append(branches, ob)
+			continue
+		}
+		b := &branches[i]
+		b.Documents += ob.Documents
+		if ob.IndexTime.Before(b.IndexTime) {
+			b.IndexTime = ob.IndexTime
+			b.Version = ob.Version
+		}
+	}
+	r.Branches = branches
 }
 
 // MinimalRepoListEntry is a subset of RepoListEntry. It was added after
@@ -897,9 +1177,41 @@ const (
 	RepoListFieldReposMap               = 2
 )
 
+// RepoListSort is the order of RepoList.Repos.
+type RepoListSort int
+
+const (
+	// RepoListSortNone leaves the order unspecified.
+	RepoListSortNone RepoListSort = iota
+	// RepoListSortName orders by repository name, ascending.
+	RepoListSortName
+	// RepoListSortIndexTime puts the most recently indexed repositories first.
+	RepoListSortIndexTime
+	// RepoListSortCommitDate puts the repositories with the latest commits
+	// first.
+	RepoListSortCommitDate
+	// RepoListSortSize puts the largest repositories, by index and content
+	// bytes, first.
+	RepoListSortSize
+)
+
 type ListOptions struct {
 	// Field decides which field to populate in RepoList response.
 	Field RepoListField
+
+	// Sort, Offset and Limit select a page of RepoList.Repos. They don't
+	// apply to ReposMap. RepoList.Stats still describes all matching
+	// repositories, so Stats.Repos is the number of entries to page through.
+	Sort   RepoListSort
+	Offset int
+	// Limit is the maximum number of repositories to return, 0 for all.
+	Limit int
+
+	// Minimal drops the fields of RepoListEntry which UIs listing
+	// repositories rarely need: everything but the ID, name, URL, branches
+	// and latest commit date of the repository, its index time and its
+	// stats.
+	Minimal bool
 }
 
 func (o *ListOptions) GetField() (RepoListField, error) {
@@ -916,6 +1228,66 @@ func (o *ListOptions) GetField() (RepoListField, error) {
 	}
 }
 
+// Apply sorts, pages and projects rl.Repos as asked for by o. Searchers which
+// merge the lists of several shards call it once on the merged list.
+func (o *ListOptions) Apply(rl *RepoList) {
+	if o == nil {
+		return
+	}
+
+	var less func(a, b *RepoListEntry) bool
+	switch o.Sort {
+	case RepoListSortName:
+		less = func(a, b *RepoListEntry) bool { return a.Repository.Name < b.Repository.Name }
+	case RepoListSortIndexTime:
+		less = func(a, b *RepoListEntry) bool { return a.IndexMetadata.IndexTime.After(b.IndexMetadata.IndexTime) }
+	case RepoListSortCommitDate:
+		less = func(a, b *RepoListEntry) bool {
+			return a.Repository.LatestCommitDate.After(b.Repository.LatestCommitDate)
+		}
+	case RepoListSortSize:
+		less = func(a, b *RepoListEntry) bool {
+			return a.Stats.IndexBytes+a.Stats.ContentBytes > b.Stats.IndexBytes+b.Stats.ContentBytes
+		}
+	}
+	if less != nil {
+		// Ties are broken by name, so pages are stable.
+		slices.SortFunc(rl.Repos, func(a, b *RepoListEntry) int {
+			switch {
+			case less(a, b):
+				return -1
+			case less(b, a):
+				return 1
+			}
+			return strings.Compare(a.Repository.Name, b.Repository.Name)
+		})
+	}
+
+	if o.Offset > 0 {
+		rl.Repos = rl.Repos[min(o.Offset, len(rl.Repos)):]
+	}
+	if o.Limit > 0 && o.Limit < len(rl.Repos) {
+		rl.Repos = rl.Repos[:o.Limit]
+	}
+
+	if o.Minimal {
+		for i, r := range rl.Repos {
+			rl.Repos[i] = &RepoListEntry{
+				Repository: Repository{
+					TenantID:         r.Repository.TenantID,
+					ID:               r.Repository.ID,
+					Name:             r.Repository.Name,
+					URL:              r.Repository.URL,
+					Branches:         r.Repository.Branches,
+					LatestCommitDate: r.Repository.LatestCommitDate,
+				},
+				IndexMetadata: IndexMetadata{IndexTime: r.IndexMetadata.IndexTime},
+				Stats:         r.Stats,
+			}
+		}
+	}
+}
+
 func (o *ListOptions) String() string {
 	return fmt.Sprintf("%#v", o)
 }
@@ -946,6 +1318,14 @@ type SearchOptions struct {
 	// Abort the search after this much time has passed.
 	MaxWallTime time.Duration
 
+	// Budget of a search: abort it once it loaded MaxContentBytesLoaded bytes
+	// of file contents, or spent MaxRegexpTime matching regular expressions,
+	// summed over all shards. Aborted searches have FlushReasonBudgetExceeded
+	// set. Since shards are searched in parallel, the budget may be overshot
+	// by what the shards in flight use. Zero means no limit.
+	MaxContentBytesLoaded int64
+	MaxRegexpTime         time.Duration
+
 	// FlushWallTime if non-zero will stop streaming behaviour at first and
 	// instead will collate and sort results. At FlushWallTime the results will
 	// be sent and then the behaviour will revert to the normal streaming.
@@ -986,10 +1366,35 @@ type SearchOptions struct {
 	// If set, the search results will contain debug information for scoring.
 	DebugScore bool
 
+	// Profile captures a CPU profile and allocation statistics of this search
+	// and attaches them to its trace. Profiles cover the whole process, so
+	// servers only honor it for admins, and if query profiles are enabled.
+	Profile bool
+
+	// If true, the Line of LineMatches and the Content of ChunkMatches are
+	// syntax highlighted, see HighlightToken. The lexer is chosen by the
+	// language detected at index time. Snippets are highlighted on their own,
+	// so constructs spanning lines outside of them, like block comments, may
+	// be misclassified. Only the results left after applying
+	// MaxDocDisplayCount and MaxMatchDisplayCount are highlighted, so
+	// searchers of single shards leave it to the sharded searcher.
+	Highlight bool
+
+	// If true, file matches include the possible secrets found at index time,
+	// see FileMatch.Secrets. Servers only honor it for admins, since the
+	// annotations point at credentials.
+	Secrets bool
+
 	// SpanContext is the opentracing span context, if it exists, from the zoekt client
 	SpanContext map[string]string
 }
 
+// BudgetExceeded returns true if stats exceed the budget of the search.
+func (s *SearchOptions) BudgetExceeded(stats *Stats) bool {
+	return (s.MaxContentBytesLoaded > 0 && stats.ContentBytesLoaded > s.MaxContentBytesLoaded) ||
+		(s.MaxRegexpTime > 0 && stats.RegexpTime > s.MaxRegexpTime)
+}
+
 // String returns a succinct representation of the options. This is meant for
 // human consumption in logs and traces.
 //
@@ -1031,8 +1436,10 @@ func (s *SearchOptions) String() string {
 	addInt("MaxDocDisplayCount", s.MaxDocDisplayCount)
 	addInt("MaxMatchDisplayCount", s.MaxMatchDisplayCount)
 	addInt("NumContextLines", s.NumContextLines)
+	addInt("MaxContentBytesLoaded", int(s.MaxContentBytesLoaded))
 
 	addDuration("MaxWallTime", s.MaxWallTime)
+	addDuration("MaxRegexpTime", s.MaxRegexpTime)
 	addDuration("FlushWallTime", s.FlushWallTime)
 
 	addBool("EstimateDocCount", s.EstimateDocCount)
@@ -1041,6 +1448,9 @@ func (s *SearchOptions) String() string {
 	addBool("UseBM25Scoring", s.UseBM25Scoring)
 	addBool("Trace", s.Trace)
 	addBool("DebugScore", s.DebugScore)
+	addBool("Profile", s.Profile)
+	addBool("Highlight", s.Highlight)
+	addBool("Secrets", s.Secrets)
 
 	for k, v := range s.SpanContext {
 		add("SpanContext."+k, strconv.Quote(v))
@@ -1069,3 +1479,40 @@ type Streamer interface {
 	Searcher
 	StreamSearch(ctx context.Context, q query.Q, opts *SearchOptions, sender Sender) (err error)
 }
+
+// SearchError is returned by Search and StreamSearch for searches which
+// failed for one of the reasons that otherwise cut a search short, like
+// FlushReasonTimeout for a search which timed out before it searched any
+// shard. Searches which get partial results set Stats.FlushReason instead.
+type SearchError struct {
+	Reason FlushReason
+	Err    error
+}
+
+func (e *SearchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitReason is the reason of rate limited searches in the errors of
+// the gRPC and JSON APIs, which otherwise carry the names of FlushReasons.
+const RateLimitReason = "RATE_LIMITED"
+
+// RateLimitError is returned by Search and StreamSearch for searches which
+// were rejected because their client exceeded the search rate of its class,
+// see the --rate_limits flag of zoekt-webserver.
+type RateLimitError struct {
+	// Class is the rate limit class of the client.
+	Class string
+
+	// RetryAfter is how long the client must wait before its next search
+	// is admitted.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of class %q exceeded, retry after %s", e.Class, e.RetryAfter)
+}
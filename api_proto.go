@@ -35,6 +35,11 @@ func FileMatchFromProto(p *proto.FileMatch) FileMatch {
 		chunkMatches[i] = ChunkMatchFromProto(chunkMatch)
 	}
 
+	secrets := make([]SecretAnnotation, len(p.GetSecrets()))
+	for i, s := range p.GetSecrets() {
+		secrets[i] = SecretAnnotation{RuleID: s.GetRuleId(), LineNumber: int(s.GetLineNumber())}
+	}
+
 	return FileMatch{
 		Score:              p.GetScore(),
 		Debug:              p.GetDebug(),
@@ -51,6 +56,9 @@ func FileMatchFromProto(p *proto.FileMatch) FileMatch {
 		SubRepositoryName:  p.GetSubRepositoryName(),
 		SubRepositoryPath:  p.GetSubRepositoryPath(),
 		Version:            p.GetVersion(),
+		Secrets:            secrets,
+		Encoding:           p.GetEncoding(),
+		SymbolRole:         SymbolRole(p.GetSymbolRole()),
 	}
 }
 
@@ -65,6 +73,11 @@ func (m *FileMatch) ToProto() *proto.FileMatch {
 		chunkMatches[i] = cm.ToProto()
 	}
 
+	secrets := make([]*proto.SecretAnnotation, len(m.Secrets))
+	for i, s := range m.Secrets {
+		secrets[i] = &proto.SecretAnnotation{RuleId: s.RuleID, LineNumber: int64(s.LineNumber)}
+	}
+
 	return &proto.FileMatch{
 		Score:              m.Score,
 		Debug:              m.Debug,
@@ -81,6 +94,29 @@ func (m *FileMatch) ToProto() *proto.FileMatch {
 		SubRepositoryName:  m.SubRepositoryName,
 		SubRepositoryPath:  m.SubRepositoryPath,
 		Version:            m.Version,
+		Secrets:            secrets,
+		Encoding:           m.Encoding,
+		SymbolRole:         string(m.SymbolRole),
+	}
+}
+
+func DirectoryMatchFromProto(p *proto.DirectoryMatch) DirectoryMatch {
+	return DirectoryMatch{
+		Repository:   p.GetRepository(),
+		RepositoryID: p.GetRepositoryId(),
+		Directory:    string(p.GetDirectory()), // Note: 🚨Warning, this directory may be a non-UTF8 string.
+		FileCount:    int(p.GetFileCount()),
+		MatchCount:   int(p.GetMatchCount()),
+	}
+}
+
+func (m *DirectoryMatch) ToProto() *proto.DirectoryMatch {
+	return &proto.DirectoryMatch{
+		Repository:   m.Repository,
+		RepositoryId: m.RepositoryID,
+		Directory:    []byte(m.Directory),
+		FileCount:    int64(m.FileCount),
+		MatchCount:   int64(m.MatchCount),
 	}
 }
 
@@ -95,6 +131,11 @@ func ChunkMatchFromProto(p *proto.ChunkMatch) ChunkMatch {
 		symbols[i] = SymbolFromProto(r)
 	}
 
+	tokens := make([]HighlightToken, len(p.GetTokens()))
+	for i, token := range p.GetTokens() {
+		tokens[i] = HighlightTokenFromProto(token)
+	}
+
 	return ChunkMatch{
 		Content:      p.GetContent(),
 		ContentStart: LocationFromProto(p.GetContentStart()),
@@ -103,6 +144,8 @@ func ChunkMatchFromProto(p *proto.ChunkMatch) ChunkMatch {
 		SymbolInfo:   symbols,
 		Score:        p.GetScore(),
 		DebugScore:   p.GetDebugScore(),
+		SymbolRole:   SymbolRole(p.GetSymbolRole()),
+		Tokens:       tokens,
 	}
 }
 
@@ -117,6 +160,11 @@ func (cm *ChunkMatch) ToProto() *proto.ChunkMatch {
 		symbolInfo[i] = si.ToProto()
 	}
 
+	tokens := make([]*proto.HighlightToken, len(cm.Tokens))
+	for i, token := range cm.Tokens {
+		tokens[i] = token.ToProto()
+	}
+
 	return &proto.ChunkMatch{
 		Content:      cm.Content,
 		ContentStart: cm.ContentStart.ToProto(),
@@ -125,6 +173,24 @@ func (cm *ChunkMatch) ToProto() *proto.ChunkMatch {
 		SymbolInfo:   symbolInfo,
 		Score:        cm.Score,
 		DebugScore:   cm.DebugScore,
+		SymbolRole:   string(cm.SymbolRole),
+		Tokens:       tokens,
+	}
+}
+
+func HighlightTokenFromProto(p *proto.HighlightToken) HighlightToken {
+	return HighlightToken{
+		Start: p.GetStart(),
+		End:   p.GetEnd(),
+		Class: p.GetClass(),
+	}
+}
+
+func (t *HighlightToken) ToProto() *proto.HighlightToken {
+	return &proto.HighlightToken{
+		Start: t.Start,
+		End:   t.End,
+		Class: t.Class,
 	}
 }
 
@@ -164,6 +230,11 @@ func LineMatchFromProto(p *proto.LineMatch) LineMatch {
 		lineFragments[i] = LineFragmentMatchFromProto(lineFragment)
 	}
 
+	tokens := make([]HighlightToken, len(p.GetTokens()))
+	for i, token := range p.GetTokens() {
+		tokens[i] = HighlightTokenFromProto(token)
+	}
+
 	return LineMatch{
 		Line:          p.GetLine(),
 		LineStart:     int(p.GetLineStart()),
@@ -175,6 +246,8 @@ func LineMatchFromProto(p *proto.LineMatch) LineMatch {
 		Score:         p.GetScore(),
 		DebugScore:    p.GetDebugScore(),
 		LineFragments: lineFragments,
+		SymbolRole:    SymbolRole(p.GetSymbolRole()),
+		Tokens:        tokens,
 	}
 }
 
@@ -184,6 +257,11 @@ func (lm *LineMatch) ToProto() *proto.LineMatch {
 		fragments[i] = fragment.ToProto()
 	}
 
+	tokens := make([]*proto.HighlightToken, len(lm.Tokens))
+	for i, token := range lm.Tokens {
+		tokens[i] = token.ToProto()
+	}
+
 	return &proto.LineMatch{
 		Line:          lm.Line,
 		LineStart:     int64(lm.LineStart),
@@ -195,6 +273,8 @@ func (lm *LineMatch) ToProto() *proto.LineMatch {
 		Score:         lm.Score,
 		DebugScore:    lm.DebugScore,
 		LineFragments: fragments,
+		SymbolRole:    string(lm.SymbolRole),
+		Tokens:        tokens,
 	}
 }
 
@@ -250,11 +330,28 @@ func FlushReasonFromProto(p proto.FlushReason) FlushReason {
 		return FlushReasonFinalFlush
 	case proto.FlushReason_FLUSH_REASON_MAX_SIZE:
 		return FlushReasonMaxSize
+	case proto.FlushReason_FLUSH_REASON_BUDGET_EXCEEDED:
+		return FlushReasonBudgetExceeded
+	case proto.FlushReason_FLUSH_REASON_MEMORY_EXCEEDED:
+		return FlushReasonMemoryExceeded
+	case proto.FlushReason_FLUSH_REASON_TIMEOUT:
+		return FlushReasonTimeout
+	case proto.FlushReason_FLUSH_REASON_SHARD_UNAVAILABLE:
+		return FlushReasonShardUnavailable
+	case proto.FlushReason_FLUSH_REASON_MATCH_LIMIT:
+		return FlushReasonMatchLimit
 	default:
 		return FlushReason(0)
 	}
 }
 
+// FlushReasonFromProtoName returns the FlushReason of the name of a
+// proto.FlushReason value, eg. "FLUSH_REASON_TIMEOUT". It returns 0 for
+// unknown names.
+func FlushReasonFromProtoName(name string) FlushReason {
+	return FlushReasonFromProto(proto.FlushReason(proto.FlushReason_value[name]))
+}
+
 func (fr FlushReason) ToProto() proto.FlushReason {
 	switch fr {
 	case FlushReasonTimerExpired:
@@ -263,6 +360,16 @@ func (fr FlushReason) ToProto() proto.FlushReason {
 		return proto.FlushReason_FLUSH_REASON_FINAL_FLUSH
 	case FlushReasonMaxSize:
 		return proto.FlushReason_FLUSH_REASON_MAX_SIZE
+	case FlushReasonBudgetExceeded:
+		return proto.FlushReason_FLUSH_REASON_BUDGET_EXCEEDED
+	case FlushReasonMemoryExceeded:
+		return proto.FlushReason_FLUSH_REASON_MEMORY_EXCEEDED
+	case FlushReasonTimeout:
+		return proto.FlushReason_FLUSH_REASON_TIMEOUT
+	case FlushReasonShardUnavailable:
+		return proto.FlushReason_FLUSH_REASON_SHARD_UNAVAILABLE
+	case FlushReasonMatchLimit:
+		return proto.FlushReason_FLUSH_REASON_MATCH_LIMIT
 	default:
 		return proto.FlushReason_FLUSH_REASON_UNKNOWN_UNSPECIFIED
 	}
@@ -270,7 +377,17 @@ func (fr FlushReason) ToProto() proto.FlushReason {
 
 // Generate valid reasons for quickchecks
 func (fr FlushReason) Generate(rand *rand.Rand, size int) reflect.Value {
-	switch rand.Int() % 4 {
+	switch rand.Int() % 9 {
+	case 8:
+		return reflect.ValueOf(FlushReasonMatchLimit)
+	case 7:
+		return reflect.ValueOf(FlushReasonShardUnavailable)
+	case 6:
+		return reflect.ValueOf(FlushReasonTimeout)
+	case 5:
+		return reflect.ValueOf(FlushReasonMemoryExceeded)
+	case 4:
+		return reflect.ValueOf(FlushReasonBudgetExceeded)
 	case 1:
 		return reflect.ValueOf(FlushReasonMaxSize)
 	case 2:
@@ -303,6 +420,7 @@ func StatsFromProto(p *proto.Stats) Stats {
 		MatchTreeConstruction: p.GetMatchTreeConstruction().AsDuration(),
 		MatchTreeSearch:       p.GetMatchTreeSearch().AsDuration(),
 		RegexpsConsidered:     int(p.GetRegexpsConsidered()),
+		RegexpTime:            p.GetRegexpTime().AsDuration(),
 		FlushReason:           FlushReasonFromProto(p.GetFlushReason()),
 	}
 }
@@ -328,6 +446,7 @@ func (s *Stats) ToProto() *proto.Stats {
 		MatchTreeConstruction: durationpb.New(s.MatchTreeConstruction),
 		MatchTreeSearch:       durationpb.New(s.MatchTreeSearch),
 		RegexpsConsidered:     int64(s.RegexpsConsidered),
+		RegexpTime:            durationpb.New(s.RegexpTime),
 		FlushReason:           s.FlushReason.ToProto(),
 	}
 }
@@ -364,11 +483,17 @@ func SearchResultFromProto(p *proto.SearchResponse, repoURLs, lineFragments map[
 		files[i] = FileMatchFromProto(file)
 	}
 
+	dirs := make([]DirectoryMatch, len(p.GetDirectories()))
+	for i, dir := range p.GetDirectories() {
+		dirs[i] = DirectoryMatchFromProto(dir)
+	}
+
 	return &SearchResult{
 		Stats:    StatsFromProto(p.GetStats()),
 		Progress: ProgressFromProto(p.GetProgress()),
 
-		Files: files,
+		Files:       files,
+		Directories: dirs,
 
 		RepoURLs:      repoURLs,
 		LineFragments: lineFragments,
@@ -385,11 +510,17 @@ func (sr *SearchResult) ToProto() *proto.SearchResponse {
 		files[i] = file.ToProto()
 	}
 
+	dirs := make([]*proto.DirectoryMatch, len(sr.Directories))
+	for i, dir := range sr.Directories {
+		dirs[i] = dir.ToProto()
+	}
+
 	return &proto.SearchResponse{
 		Stats:    sr.Stats.ToProto(),
 		Progress: sr.Progress.ToProto(),
 
-		Files: files,
+		Files:       files,
+		Directories: dirs,
 	}
 }
 
@@ -432,6 +563,11 @@ func RepositoryFromProto(p *proto.Repository) Repository {
 		fileTombstones[file] = struct{}{}
 	}
 
+	forkTombstones := make(map[string]struct{}, len(p.GetForkTombstones()))
+	for _, file := range p.GetForkTombstones() {
+		forkTombstones[file] = struct{}{}
+	}
+
 	return Repository{
 		TenantID:             int(p.GetTenantId()),
 		ID:                   p.GetId(),
@@ -451,6 +587,10 @@ func RepositoryFromProto(p *proto.Repository) Repository {
 		Tombstone:            p.GetTombstone(),
 		LatestCommitDate:     p.GetLatestCommitDate().AsTime(),
 		FileTombstones:       fileTombstones,
+		Metadata:             p.GetMetadata(),
+		ForkOf:               p.GetForkOf(),
+		ForkOfID:             p.GetForkOfId(),
+		ForkTombstones:       forkTombstones,
 	}
 }
 
@@ -474,6 +614,11 @@ func (r *Repository) ToProto() *proto.Repository {
 		fileTombstones = append(fileTombstones, file)
 	}
 
+	forkTombstones := make([]string, 0, len(r.ForkTombstones))
+	for file := range r.ForkTombstones {
+		forkTombstones = append(forkTombstones, file)
+	}
+
 	return &proto.Repository{
 		TenantId:             int64(r.TenantID),
 		Id:                   r.ID,
@@ -493,6 +638,10 @@ func (r *Repository) ToProto() *proto.Repository {
 		Tombstone:            r.Tombstone,
 		LatestCommitDate:     timestamppb.New(r.LatestCommitDate),
 		FileTombstones:       fileTombstones,
+		Metadata:             r.Metadata,
+		ForkOf:               r.ForkOf,
+		ForkOfId:             r.ForkOfID,
+		ForkTombstones:       forkTombstones,
 	}
 }
 
@@ -567,10 +716,19 @@ func RepoListEntryFromProto(p *proto.RepoListEntry) *RepoListEntry {
 		return nil
 	}
 
+	var branches []BranchListEntry
+	if len(p.GetBranches()) > 0 {
+		branches = make([]BranchListEntry, len(p.GetBranches()))
+		for i, b := range p.GetBranches() {
+			branches[i] = BranchListEntryFromProto(b)
+		}
+	}
+
 	return &RepoListEntry{
 		Repository:    RepositoryFromProto(p.GetRepository()),
 		IndexMetadata: IndexMetadataFromProto(p.GetIndexMetadata()),
 		Stats:         RepoStatsFromProto(p.GetStats()),
+		Branches:      branches,
 	}
 }
 
@@ -579,10 +737,37 @@ func (r *RepoListEntry) ToProto() *proto.RepoListEntry {
 		return nil
 	}
 
+	var branches []*proto.BranchListEntry
+	if len(r.Branches) > 0 {
+		branches = make([]*proto.BranchListEntry, len(r.Branches))
+		for i, b := range r.Branches {
+			branches[i] = b.ToProto()
+		}
+	}
+
 	return &proto.RepoListEntry{
 		Repository:    r.Repository.ToProto(),
 		IndexMetadata: r.IndexMetadata.ToProto(),
 		Stats:         r.Stats.ToProto(),
+		Branches:      branches,
+	}
+}
+
+func BranchListEntryFromProto(p *proto.BranchListEntry) BranchListEntry {
+	return BranchListEntry{
+		Name:      p.GetName(),
+		Version:   p.GetVersion(),
+		IndexTime: p.GetIndexTime().AsTime(),
+		Documents: int(p.GetDocuments()),
+	}
+}
+
+func (b *BranchListEntry) ToProto() *proto.BranchListEntry {
+	return &proto.BranchListEntry{
+		Name:      b.Name,
+		Version:   b.Version,
+		IndexTime: timestamppb.New(b.IndexTime),
+		Documents: int64(b.Documents),
 	}
 }
 
@@ -661,8 +846,24 @@ func (l *ListOptions) ToProto() *proto.ListOptions {
 		field = proto.ListOptions_REPO_LIST_FIELD_REPOS_MAP
 	}
 
+	var sort proto.ListOptions_RepoListSort
+	switch l.Sort {
+	case RepoListSortName:
+		sort = proto.ListOptions_REPO_LIST_SORT_NAME
+	case RepoListSortIndexTime:
+		sort = proto.ListOptions_REPO_LIST_SORT_INDEX_TIME
+	case RepoListSortCommitDate:
+		sort = proto.ListOptions_REPO_LIST_SORT_COMMIT_DATE
+	case RepoListSortSize:
+		sort = proto.ListOptions_REPO_LIST_SORT_SIZE
+	}
+
 	return &proto.ListOptions{
-		Field: field,
+		Field:   field,
+		Sort:    sort,
+		Offset:  int64(l.Offset),
+		Limit:   int64(l.Limit),
+		Minimal: l.Minimal,
 	}
 }
 
@@ -677,8 +878,25 @@ func ListOptionsFromProto(p *proto.ListOptions) *ListOptions {
 	case proto.ListOptions_REPO_LIST_FIELD_REPOS_MAP:
 		field = RepoListFieldReposMap
 	}
+
+	var sort RepoListSort
+	switch p.GetSort() {
+	case proto.ListOptions_REPO_LIST_SORT_NAME:
+		sort = RepoListSortName
+	case proto.ListOptions_REPO_LIST_SORT_INDEX_TIME:
+		sort = RepoListSortIndexTime
+	case proto.ListOptions_REPO_LIST_SORT_COMMIT_DATE:
+		sort = RepoListSortCommitDate
+	case proto.ListOptions_REPO_LIST_SORT_SIZE:
+		sort = RepoListSortSize
+	}
+
 	return &ListOptions{
-		Field: field,
+		Field:   field,
+		Sort:    sort,
+		Offset:  int(p.GetOffset()),
+		Limit:   int(p.GetLimit()),
+		Minimal: p.GetMinimal(),
 	}
 }
 
@@ -702,6 +920,11 @@ func SearchOptionsFromProto(p *proto.SearchOptions) *SearchOptions {
 		Trace:                  p.GetTrace(),
 		DebugScore:             p.GetDebugScore(),
 		UseBM25Scoring:         p.GetUseBm25Scoring(),
+		Profile:                p.GetProfile(),
+		MaxContentBytesLoaded:  p.GetMaxContentBytesLoaded(),
+		MaxRegexpTime:          p.GetMaxRegexpTime().AsDuration(),
+		Highlight:              p.GetHighlight(),
+		Secrets:                p.GetSecrets(),
 	}
 }
 
@@ -725,5 +948,63 @@ func (s *SearchOptions) ToProto() *proto.SearchOptions {
 		Trace:                  s.Trace,
 		DebugScore:             s.DebugScore,
 		UseBm25Scoring:         s.UseBM25Scoring,
+		Profile:                s.Profile,
+		MaxContentBytesLoaded:  s.MaxContentBytesLoaded,
+		MaxRegexpTime:          durationpb.New(s.MaxRegexpTime),
+		Highlight:              s.Highlight,
+		Secrets:                s.Secrets,
+	}
+}
+
+func FileRequestFromProto(p *proto.GetFileRequest) FileRequest {
+	return FileRequest{
+		Repository: p.GetRepository(),
+		Branch:     p.GetBranch(),
+		Path:       string(p.GetPath()), // Note: 🚨Warning, this path may be a non-UTF8 string.
+		Start:      p.GetRange().GetStart(),
+		End:        p.GetRange().GetEnd(),
+	}
+}
+
+func (r *FileRequest) ToProto() *proto.GetFileRequest {
+	p := &proto.GetFileRequest{
+		Repository: r.Repository,
+		Branch:     r.Branch,
+		Path:       []byte(r.Path),
+	}
+	if r.Start != 0 || r.End != 0 {
+		p.Range = &proto.ByteRange{Start: r.Start, End: r.End}
+	}
+	return p
+}
+
+func FileFromProto(p *proto.GetFileResponse) *File {
+	return &File{
+		Repository: p.GetRepository(),
+		Path:       string(p.GetPath()), // Note: 🚨Warning, this path may be a non-UTF8 string.
+		Branches:   p.GetBranches(),
+		Version:    p.GetVersion(),
+		Language:   p.GetLanguage(),
+		Encoding:   p.GetEncoding(),
+		Checksum:   p.GetChecksum(),
+		Size:       p.GetSize(),
+		Start:      p.GetRange().GetStart(),
+		End:        p.GetRange().GetEnd(),
+		Content:    p.GetContent(),
+	}
+}
+
+func (f *File) ToProto() *proto.GetFileResponse {
+	return &proto.GetFileResponse{
+		Repository: f.Repository,
+		Path:       []byte(f.Path),
+		Branches:   f.Branches,
+		Version:    f.Version,
+		Language:   f.Language,
+		Encoding:   f.Encoding,
+		Checksum:   f.Checksum,
+		Size:       f.Size,
+		Range:      &proto.ByteRange{Start: f.Start, End: f.End},
+		Content:    f.Content,
 	}
 }
@@ -238,6 +238,7 @@ func TestProtoRoundtrip(t *testing.T) {
 						Branches:       []RepositoryBranch{},
 						SubRepoMap:     map[string]*Repository{},
 						FileTombstones: map[string]struct{}{},
+						ForkTombstones: map[string]struct{}{},
 					},
 				},
 				CommitURLTemplate:    "committemplate",
@@ -255,6 +256,14 @@ func TestProtoRoundtrip(t *testing.T) {
 				FileTombstones: map[string]struct{}{
 					"test1": {},
 				},
+				Metadata: map[string]string{
+					"team": "payments",
+				},
+				ForkOf:   "parent",
+				ForkOfID: 7,
+				ForkTombstones: map[string]struct{}{
+					"test2": {},
+				},
 			},
 			IndexMetadata: IndexMetadata{
 				IndexFormatVersion:    32,
@@ -278,6 +287,12 @@ func TestProtoRoundtrip(t *testing.T) {
 				DefaultBranchNewLinesCount: 9,
 				OtherBranchesNewLinesCount: 10,
 			},
+			Branches: []BranchListEntry{{
+				Name:      "branch",
+				Version:   "version",
+				IndexTime: time.Now(),
+				Documents: 11,
+			}},
 		}
 
 		p1 := r1.ToProto()
@@ -386,6 +401,10 @@ func (*Repository) Generate(rng *rand.Rand, _ int) reflect.Value {
 		Tombstone:            gen(r.Tombstone, rng),
 		LatestCommitDate:     latestCommitDate,
 		FileTombstones:       gen(r.FileTombstones, rng),
+		Metadata:             gen(r.Metadata, rng),
+		ForkOf:               gen(r.ForkOf, rng),
+		ForkOfID:             gen(r.ForkOfID, rng),
+		ForkTombstones:       gen(r.ForkTombstones, rng),
 	}
 	return reflect.ValueOf(v)
 }
@@ -398,6 +417,10 @@ func (RepoListField) Generate(rng *rand.Rand, _ int) reflect.Value {
 	}
 }
 
+func (RepoListSort) Generate(rng *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(RepoListSort(rng.Intn(int(RepoListSortSize) + 1)))
+}
+
 func gen[T any](sample T, r *rand.Rand) T {
 	var t T
 	v, _ := quick.Value(reflect.TypeOf(t), r)
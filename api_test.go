@@ -88,14 +88,14 @@ func TestSizeBytesSearchResult(t *testing.T) {
 	sr := SearchResult{
 		Stats:    Stats{},    // 129 bytes
 		Progress: Progress{}, // 16 bytes
-		Files: []FileMatch{{ // 24 bytes + 460 bytes
+		Files: []FileMatch{{ // 24 bytes + 556 bytes
 			Score:       0,   // 8 bytes
 			Debug:       "",  // 16 bytes
 			FileName:    "",  // 16 bytes
 			Repository:  "",  // 16 bytes
 			Branches:    nil, // 24 bytes
 			LineMatches: nil, // 24 bytes
-			ChunkMatches: []ChunkMatch{{ // 24 bytes + 208 bytes (see TestSizeByteChunkMatches)
+			ChunkMatches: []ChunkMatch{{ // 24 bytes + 248 bytes (see TestSizeByteChunkMatches)
 				Content:      []byte("foo"),
 				ContentStart: Location{},
 				FileName:     false,
@@ -112,12 +112,16 @@ func TestSizeBytesSearchResult(t *testing.T) {
 			SubRepositoryName:  "",  // 16 bytes
 			SubRepositoryPath:  "",  // 16 bytes
 			Version:            "",  // 16 bytes
+			Secrets:            nil, // 24 bytes
+			Encoding:           "",  // 16 bytes
+			SymbolRole:         "",  // 16 bytes
 		}},
+		Directories:   nil, // 24 bytes
 		RepoURLs:      nil, // 48 bytes
 		LineFragments: nil, // 48 bytes
 	}
 
-	var wantBytes uint64 = 725
+	var wantBytes uint64 = 845
 	if sr.SizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, sr.SizeBytes())
 	}
@@ -132,9 +136,11 @@ func TestSizeBytesChunkMatches(t *testing.T) {
 		SymbolInfo:   []*Symbol{{}}, // 24 bytes (slice header) + 4 * 16 bytes (string header) + 8 bytes (pointer)
 		Score:        0,             // 8 byte
 		DebugScore:   "",            // 16 bytes (string header)
+		SymbolRole:   "",            // 16 bytes (string header)
+		Tokens:       nil,           // 24 bytes (slice header)
 	}
 
-	var wantBytes uint64 = 208
+	var wantBytes uint64 = 248
 	if cm.sizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, cm.sizeBytes())
 	}
@@ -146,10 +152,10 @@ func TestMatchSize(t *testing.T) {
 		size int
 	}{{
 		v:    FileMatch{},
-		size: 256,
+		size: 312,
 	}, {
 		v:    ChunkMatch{},
-		size: 112,
+		size: 152,
 	}, {
 		v:    candidateMatch{},
 		size: 80,
@@ -298,6 +304,31 @@ func TestRepositoryMergeMutable(t *testing.T) {
 			t.Fatalf("got different RawConfig, %v vs %v", a.RawConfig, b.RawConfig)
 		}
 	})
+	t.Run("different Metadata", func(t *testing.T) {
+		b := a
+		b.Metadata = map[string]string{"team": "payments"}
+		mutated, err := a.MergeMutable(&b)
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		if !mutated {
+			t.Fatalf("want mutated=true, got false")
+		}
+		if !reflect.DeepEqual(a.Metadata, b.Metadata) {
+			t.Fatalf("got different Metadata, %v vs %v", a.Metadata, b.Metadata)
+		}
+
+		// A nil map leaves Metadata unchanged.
+		c := a
+		c.Metadata = nil
+		mutated, err = a.MergeMutable(&c)
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		if mutated || len(a.Metadata) != 1 {
+			t.Fatalf("want unchanged Metadata, got mutated=%t %v", mutated, a.Metadata)
+		}
+	})
 	t.Run("different URL", func(t *testing.T) {
 		b := a
 		b.URL = "otherURL"
@@ -369,6 +400,120 @@ func TestRepositoryMergeMutable(t *testing.T) {
 	})
 }
 
+func TestRepoListEntryAdd(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	shard1 := []BranchListEntry{
+		{Name: "main", Version: "v2", IndexTime: newer, Documents: 10},
+		{Name: "dev", Version: "d1", IndexTime: newer, Documents: 5},
+	}
+	a := RepoListEntry{Stats: RepoStats{Shards: 1, Documents: 12}, Branches: shard1}
+	b := RepoListEntry{
+		Stats: RepoStats{Shards: 1, Documents: 3},
+		Branches: []BranchListEntry{
+			{Name: "main", Version: "v1", IndexTime: older, Documents: 3},
+			{Name: "release", Version: "r1", IndexTime: older, Documents: 1},
+		},
+	}
+
+	a.Add(&b)
+
+	want := []BranchListEntry{
+		{Name: "main", Version: "v1", IndexTime: older, Documents: 13},
+		{Name: "dev", Version: "d1", IndexTime: newer, Documents: 5},
+		{Name: "release", Version: "r1", IndexTime: older, Documents: 1},
+	}
+	if !reflect.DeepEqual(a.Branches, want) {
+		t.Errorf("got branches %v, want %v", a.Branches, want)
+	}
+	if a.Stats.Shards != 2 || a.Stats.Documents != 15 {
+		t.Errorf("got stats %+v, want 2 shards and 15 documents", a.Stats)
+	}
+	if shard1[0].Documents != 10 {
+		t.Error("Add mutated the branches of the first shard")
+	}
+}
+
+func TestStatsAddFlushReason(t *testing.T) {
+	var s Stats
+	s.Add(Stats{FlushReason: FlushReasonTimerExpired})
+	s.Add(Stats{FlushReason: FlushReasonFinalFlush})
+	if s.FlushReason != FlushReasonTimerExpired || s.Partial() {
+		t.Fatalf("got flush reason %s, want the first one", s.FlushReason)
+	}
+
+	s.Add(Stats{FlushReason: FlushReasonTimeout})
+	s.Add(Stats{FlushReason: FlushReasonMatchLimit})
+	s.Add(Stats{FlushReason: FlushReasonFinalFlush})
+	if s.FlushReason != FlushReasonTimeout || !s.Partial() {
+		t.Fatalf("got flush reason %s, want %s", s.FlushReason, FlushReasonTimeout)
+	}
+
+	if crashed := (Stats{Crashes: 1}); !crashed.Partial() {
+		t.Error("stats with crashes aren't partial")
+	}
+}
+
+func TestListOptionsApply(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(name string, indexed, committed time.Duration, size int64) *RepoListEntry {
+		return &RepoListEntry{
+			Repository: Repository{
+				Name:             name,
+				LatestCommitDate: now.Add(committed),
+				RawConfig:        map[string]string{"public": "1"},
+			},
+			IndexMetadata: IndexMetadata{IndexTime: now.Add(indexed), ZoektVersion: "v1"},
+			Stats:         RepoStats{ContentBytes: size},
+		}
+	}
+	repos := func() []*RepoListEntry {
+		return []*RepoListEntry{
+			entry("b", 2*time.Hour, time.Hour, 10),
+			entry("c", time.Hour, 3*time.Hour, 30),
+			entry("a", 3*time.Hour, 2*time.Hour, 10),
+		}
+	}
+	names := func(rl *RepoList) string {
+		var s []string
+		for _, r := range rl.Repos {
+			s = append(s, r.Repository.Name)
+		}
+		return strings.Join(s, ",")
+	}
+
+	for _, tc := range []struct {
+		opts ListOptions
+		want string
+	}{
+		{ListOptions{}, "b,c,a"},
+		{ListOptions{Sort: RepoListSortName}, "a,b,c"},
+		{ListOptions{Sort: RepoListSortIndexTime}, "a,b,c"},
+		{ListOptions{Sort: RepoListSortCommitDate}, "c,a,b"},
+		{ListOptions{Sort: RepoListSortSize}, "c,a,b"},
+		{ListOptions{Sort: RepoListSortName, Offset: 1, Limit: 1}, "b"},
+		{ListOptions{Sort: RepoListSortName, Offset: 2, Limit: 5}, "c"},
+		{ListOptions{Sort: RepoListSortName, Offset: 5}, ""},
+	} {
+		rl := &RepoList{Repos: repos()}
+		tc.opts.Apply(rl)
+		if got := names(rl); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.opts, got, tc.want)
+		}
+	}
+
+	rl := &RepoList{Repos: repos()}
+	(&ListOptions{Minimal: true}).Apply(rl)
+	r := rl.Repos[0]
+	if r.Repository.RawConfig != nil || r.IndexMetadata.ZoektVersion != "" {
+		t.Errorf("Minimal kept fields: %+v", r)
+	}
+	if r.Repository.Name != "b" || r.IndexMetadata.IndexTime.IsZero() || r.Stats.ContentBytes != 10 {
+		t.Errorf("Minimal dropped fields: %+v", r)
+	}
+}
+
 func TestMonthsSince1970(t *testing.T) {
 	tests := []struct {
 		name     string
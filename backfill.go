@@ -0,0 +1,335 @@
+package zoekt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// backfillSectionPrefix is prepended to the tag of every section written by
+// Backfill, so readers can tell them apart from sections they don't know
+// about.
+const backfillSectionPrefix = "backfill:"
+
+// BackfillDocument is the view of a shard document that is passed to a
+// SectionGenerator.
+type BackfillDocument struct {
+	Name     string
+	Content  []byte
+	Language string
+	Branches []string
+
+	// Repository is the repository the document belongs to. It must not be
+	// modified.
+	Repository *Repository
+}
+
+// SectionGenerator computes an optional section for an existing shard from
+// the documents stored in it.
+type SectionGenerator interface {
+	// Name identifies the section. It is used as the section tag in the
+	// shard, so it must be stable across runs.
+	Name() string
+
+	// Generate is called once per shard with an iterator over all documents
+	// in document order, including documents of tombstoned repositories. It
+	// returns the raw section content. The documents are read as they are
+	// iterated, so Generate shouldn't hold on to them.
+	Generate(docs *BackfillDocuments) ([]byte, error)
+}
+
+// Backfill appends the sections computed by gens to the shard f without
+// reindexing its content. Sections previously written by a generator of the
+// same name are replaced. Backfill returns tmpName and dstName. It is the
+// responsibility of the caller to rename tmpName to dstName.
+//
+// Sections written by Backfill are dropped when the shard is rewritten, for
+// example by merging or exploding compound shards.
+func Backfill(f IndexFile, gens ...SectionGenerator) (tmpName, dstName string, _ error) {
+	rd := &reader{r: f}
+	tocSection, sectionCount, err := rd.readHeader()
+	if err != nil {
+		return "", "", err
+	}
+	if sectionCount != 0 {
+		return "", "", fmt.Errorf("%s: backfill requires a shard with tagged sections, reindex it first", f.Name())
+	}
+
+	replace := map[string]bool{}
+	for _, g := range gens {
+		if replace[g.Name()] {
+			return "", "", fmt.Errorf("duplicate section generator %q", g.Name())
+		}
+		replace[g.Name()] = true
+	}
+
+	// Read the existing TOC verbatim. We keep sections we don't recognize, since
+	// they point into the part of the file we copy unchanged.
+	var secs []taggedSection
+	var oldChecksums simpleSection
+	for rd.off < tocSection.off+tocSection.sz {
+		tag, err := rd.Str()
+		if err != nil {
+			return "", "", err
+		}
+		kind, err := rd.Varint()
+		if err != nil {
+			return "", "", err
+		}
+
+		var sec section
+		switch sectionKind(kind) {
+		case sectionKindSimple:
+			sec = &simpleSection{}
+		case sectionKindCompound:
+			sec = &compoundSection{}
+		case sectionKindCompoundLazy:
+			sec = &lazyCompoundSection{}
+		default:
+			return "", "", fmt.Errorf("unknown section kind %d", kind)
+		}
+		if err := sec.read(rd); err != nil {
+			return "", "", err
+		}
+
+		if tag == "sectionChecksums" && sec.kind() == sectionKindSimple {
+			oldChecksums = *sec.(*simpleSection)
+			continue
+		}
+		if strings.HasPrefix(tag, backfillSectionPrefix) && replace[strings.TrimPrefix(tag, backfillSectionPrefix)] {
+			continue
+		}
+		secs = append(secs, taggedSection{tag: tag, sec: sec})
+	}
+
+	// The checksums of the sections we keep carry over, so the new checksum
+	// section doesn't vouch for data that was corrupted before the backfill.
+	sums := map[simpleSection]uint32{}
+	if oldChecksums.sz > 0 {
+		blob, err := f.Read(oldChecksums.off, oldChecksums.sz)
+		if err != nil {
+			return "", "", err
+		}
+		decoded, err := decodeSectionChecksums(blob)
+		if err != nil {
+			return "", "", err
+		}
+		crcs := map[string][]uint32{}
+		for _, sum := range decoded {
+			crcs[sum.tag] = sum.crcs
+		}
+		for _, s := range secs {
+			ranges := sectionRanges(s.sec)
+			if len(ranges) != len(crcs[s.tag]) {
+				continue
+			}
+			for i, r := range ranges {
+				sums[r] = crcs[s.tag][i]
+			}
+		}
+	}
+
+	// The previous backfilled sections and the checksum section are at the end
+	// of the shard. We copy everything before them and rewrite the backfilled
+	// sections we keep, so replaced sections don't leave dead bytes behind.
+	var headSize uint32
+	for _, s := range secs {
+		if isBackfilledSection(s) {
+			continue
+		}
+		for _, r := range sectionRanges(s.sec) {
+			headSize = max(headSize, r.off+r.sz)
+		}
+	}
+
+	searcher, err := NewSearcher(noCloseIndexFile{f})
+	if err != nil {
+		return "", "", err
+	}
+	defer searcher.Close()
+	d := searcher.(*indexData)
+
+	// Every generator gets its own pass over the documents, so we only hold
+	// one document in memory at a time.
+	blobs := make([][]byte, len(gens))
+	for i, g := range gens {
+		docs := &BackfillDocuments{d: d}
+		if blobs[i], err = g.Generate(docs); err == nil {
+			err = docs.Err()
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("section generator %q: %w", g.Name(), err)
+		}
+	}
+
+	// The sections in the head stay where they are, so their offsets remain
+	// valid.
+	head, err := f.Read(0, headSize)
+	if err != nil {
+		return "", "", err
+	}
+
+	dstName = f.Name()
+	tmpName = dstName + ".tmp"
+
+	out, err := os.CreateTemp(filepath.Dir(dstName), filepath.Base(tmpName)+".*.tmp")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if runtime.GOOS != "windows" {
+		if err := out.Chmod(0o666); err != nil {
+			return "", "", err
+		}
+	}
+
+	buffered := bufio.NewWriterSize(out, 1<<20)
+	w := &writer{w: buffered}
+	w.Write(head)
+
+	for i, s := range secs {
+		if !isBackfilledSection(s) {
+			continue
+		}
+		old := *s.sec.(*simpleSection)
+		blob, err := f.Read(old.off, old.sz)
+		if err != nil {
+			return "", "", err
+		}
+		var sec simpleSection
+		sec.start(w)
+		w.Write(blob)
+		sec.end(w)
+		if crc, ok := sums[old]; ok {
+			sums[sec] = crc
+		}
+		secs[i].sec = &sec
+	}
+
+	for i, g := range gens {
+		var sec simpleSection
+		sec.start(w)
+		w.Write(blobs[i])
+		sec.end(w)
+		sums[sec] = w.sums[sec]
+		secs = append(secs, taggedSection{tag: backfillSectionPrefix + g.Name(), sec: &sec})
+	}
+
+	var checksums simpleSection
+	checksums.start(w)
+	w.Write(encodeSectionChecksums(secs, sums))
+	checksums.end(w)
+	secs = append(secs, taggedSection{tag: "sectionChecksums", sec: &checksums})
+
+	var newTOC simpleSection
+	newTOC.start(w)
+	w.U32(0)
+	for _, s := range secs {
+		w.String(s.tag)
+		w.Varint(uint32(s.sec.kind()))
+		s.sec.write(w)
+	}
+	newTOC.end(w)
+	newTOC.write(w)
+
+	if w.err != nil {
+		return "", "", w.err
+	}
+	if err := buffered.Flush(); err != nil {
+		return "", "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(out.Name(), tmpName); err != nil {
+		return "", "", err
+	}
+
+	return tmpName, dstName, nil
+}
+
+// isBackfilledSection returns true for sections written by Backfill.
+func isBackfilledSection(s taggedSection) bool {
+	return strings.HasPrefix(s.tag, backfillSectionPrefix) && s.sec.kind() == sectionKindSimple
+}
+
+// BackfillDocuments iterates over the documents of a shard, in document
+// order. Like bufio.Scanner, call Next until it returns false, and then check
+// Err.
+type BackfillDocuments struct {
+	d     *indexData
+	docID uint32
+	doc   BackfillDocument
+	err   error
+}
+
+// Next advances to the next document. It returns false at the end of the
+// shard or if reading the document failed.
+func (it *BackfillDocuments) Next() bool {
+	if it.err != nil || int(it.docID) >= len(it.d.fileBranchMasks) {
+		return false
+	}
+	d, docID := it.d, it.docID
+	it.docID++
+
+	repoID := d.repos[docID]
+	it.doc = BackfillDocument{
+		Name:       string(d.fileName(docID)),
+		Language:   d.languageMap[d.getLanguage(docID)],
+		Repository: &d.repoMetaData[repoID],
+	}
+	if it.doc.Content, it.err = d.readContents(docID); it.err != nil {
+		return false
+	}
+
+	mask := d.fileBranchMasks[docID]
+	id := uint32(1)
+	for mask != 0 {
+		if mask&0x1 != 0 {
+			it.doc.Branches = append(it.doc.Branches, d.branchNames[repoID][uint(id)])
+		}
+		id <<= 1
+		mask >>= 1
+	}
+	return true
+}
+
+// Doc returns the current document. It is only valid until the next call to
+// Next, and must not be modified.
+func (it *BackfillDocuments) Doc() *BackfillDocument {
+	return &it.doc
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *BackfillDocuments) Err() error {
+	return it.err
+}
+
+// noCloseIndexFile keeps a searcher from closing an IndexFile owned by the
+// caller.
+type noCloseIndexFile struct {
+	IndexFile
+}
+
+func (noCloseIndexFile) Close() {}
+
+// ReadBackfilledSection returns the content of the section written to f by the
+// section generator with the given name. It returns a nil slice and no error
+// if f has no such section.
+func ReadBackfilledSection(f IndexFile, name string) ([]byte, error) {
+	rd := &reader{r: f}
+	var toc indexTOC
+	if err := rd.readTOCSections(&toc, []string{backfillSectionPrefix + name}); err != nil {
+		return nil, err
+	}
+	sec, ok := toc.backfilled[name]
+	if !ok {
+		return nil, nil
+	}
+	return f.Read(sec.off, sec.sz)
+}
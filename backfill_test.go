@@ -0,0 +1,188 @@
+package zoekt
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type docCountGenerator struct {
+	name string
+}
+
+func (g docCountGenerator) Name() string { return g.name }
+
+func (g docCountGenerator) Generate(docs *BackfillDocuments) ([]byte, error) {
+	var buf bytes.Buffer
+	for docs.Next() {
+		d := docs.Doc()
+		buf.WriteString(d.Name + ":" + strconv.Itoa(len(d.Content)) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func openTestIndexFile(t *testing.T, fn string) IndexFile {
+	t.Helper()
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	indexFile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(indexFile.Close)
+	return indexFile
+}
+
+func TestBackfill(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"},
+		Document{Name: "f1", Content: []byte("needle haystack")},
+		Document{Name: "f2", Content: []byte("hay")},
+	)
+
+	fn := filepath.Join(t.TempDir(), "repo_v16.00000.zoekt")
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backfill := func(gens ...SectionGenerator) {
+		t.Helper()
+		tmpName, dstName, err := Backfill(openTestIndexFile(t, fn), gens...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dstName != fn {
+			t.Fatalf("got dstName %q, want %q", dstName, fn)
+		}
+		if err := os.Rename(tmpName, dstName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	backfill(docCountGenerator{name: "sizes"}, docCountGenerator{name: "other"})
+	want := size()
+	// Running a generator again replaces its section without growing the
+	// shard.
+	backfill(docCountGenerator{name: "sizes"})
+	if got := size(); got != want {
+		t.Errorf("got size %d after replacing a section, want %d", got, want)
+	}
+
+	// The checksums cover the backfilled sections.
+	if err := VerifyShard(fn, VerifyOptions{}); err != nil {
+		t.Fatalf("VerifyShard: %v", err)
+	}
+
+	f := openTestIndexFile(t, fn)
+	for _, name := range []string{"sizes", "other"} {
+		got, err := ReadBackfilledSection(f, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "f1:15\nf2:3\n"; string(got) != want {
+			t.Errorf("section %s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if got, err := ReadBackfilledSection(f, "missing"); err != nil || got != nil {
+		t.Errorf("got %q, %v for missing section", got, err)
+	}
+
+	// The existing sections must still be readable.
+	s, err := NewSearcher(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	res, err := s.Search(context.Background(), &query.Substring{Pattern: "needle"}, &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "f1" {
+		t.Fatalf("got %v, want 1 match in f1", res.Files)
+	}
+}
+
+func TestBackfillChecksums(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"}, Document{Name: "f1", Content: []byte("abc")})
+
+	fn := filepath.Join(t.TempDir(), "repo_v16.00000.zoekt")
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpName, dstName, err := Backfill(openTestIndexFile(t, fn), docCountGenerator{name: "sizes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+
+	var toc indexTOC
+	rd := &reader{r: openTestIndexFile(t, fn)}
+	if err := rd.readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	sec := toc.backfilled["sizes"]
+	if sec == nil {
+		t.Fatal("no backfilled section")
+	}
+
+	content, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[sec.off] ^= 0xff
+	if err := os.WriteFile(fn, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyShard(fn, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "backfill:sizes") {
+		t.Errorf("got %v for a corrupt backfilled section, want a checksum mismatch", err)
+	}
+}
+
+func TestBackfillDuplicateGenerator(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"}, Document{Name: "f1", Content: []byte("abc")})
+
+	fn := filepath.Join(t.TempDir(), "repo_v16.00000.zoekt")
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := Backfill(openTestIndexFile(t, fn), docCountGenerator{name: "a"}, docCountGenerator{name: "a"})
+	if err == nil {
+		t.Fatal("expected error for duplicate generator names")
+	}
+}
@@ -18,18 +18,21 @@ import (
 	"cmp"
 	"encoding/binary"
 	"math"
+	"slices"
 	"sort"
 	"unicode"
 	"unicode/utf8"
 )
 
-func generateCaseNgrams(g ngram) []ngram {
-	asRunes := ngramToRunes(g)
+// generateCaseNgrams returns all case variants of the ngram g of the given
+// size, including g itself.
+func generateCaseNgrams(g ngram, size int) []ngram {
+	asRunes := ngramToRunesN(g, size)
 
 	variants := make([]ngram, 0, 8)
-	cur := asRunes
+	cur := slices.Clone(asRunes)
 	for {
-		for i := 0; i < 3; i++ {
+		for i := range cur {
 			next := unicode.SimpleFold(cur[i])
 			cur[i] = next
 			if next != asRunes[i] {
@@ -37,8 +40,8 @@ func generateCaseNgrams(g ngram) []ngram {
 			}
 		}
 
-		variants = append(variants, runesToNGram(cur))
-		if cur == asRunes {
+		variants = append(variants, runesToNGramN(cur))
+		if slices.Equal(cur, asRunes) {
 			break
 		}
 	}
@@ -77,6 +80,36 @@ func caseFoldingEqualsRunes(lower, mixed []byte) (int, bool) {
 	return matchTotal, len(lower) == 0
 }
 
+// isASCII returns true if b has no bytes above 0x7f.
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// asciiEqualFold compares lower, an ASCII needle in lower case, to the start
+// of mixed, folding the ASCII upper case letters of mixed in place. Unlike
+// caseFoldingEqualsRunes it doesn't decode runes, so it is only correct if
+// mixed is ASCII too: the Kelvin sign also folds to 'k'.
+func asciiEqualFold(lower, mixed []byte) bool {
+	if len(mixed) < len(lower) {
+		return false
+	}
+	for i, c := range lower {
+		m := mixed[i]
+		if 'A' <= m && m <= 'Z' {
+			m += 'a' - 'A'
+		}
+		if m != c {
+			return false
+		}
+	}
+	return true
+}
+
 type ngram uint64
 
 func runesToNGram(b [ngramSize]rune) ngram {
@@ -102,9 +135,95 @@ func ngramToRunes(n ngram) [ngramSize]rune {
 	return [ngramSize]rune{rune((n >> 42) & runeMask), rune((n >> 21) & runeMask), rune(n & runeMask)}
 }
 
-func (n ngram) String() string {
-	rs := ngramToRunes(n)
-	return string(rs[:])
+// ngramRuneBits returns the number of bits each rune takes in an ngram of the
+// given size. Up to 3 runes, every codepoint is stored exactly. 4-grams only
+// have room for the basic multilingual plane, see ngramRune.
+func ngramRuneBits(size int) int {
+	if size > 3 {
+		return 16
+	}
+	return 21
+}
+
+// ngramRune returns r as stored in an ngram of the given size. Runes outside
+// the basic multilingual plane don't fit into 4-grams, so they are lowercased
+// and truncated. Lowercasing first keeps case insensitive lookups working.
+// Collisions only cost performance, since candidates are always verified
+// against the content.
+func ngramRune(r rune, size int) rune {
+	if size > 3 && r > 0xffff {
+		return unicode.ToLower(r) & 0xffff
+	}
+	return r
+}
+
+// runesToNGramN packs len(rs) runes into an ngram. For 3 runes it is
+// equivalent to runesToNGram.
+func runesToNGramN(rs []rune) ngram {
+	bits := ngramRuneBits(len(rs))
+	var n ngram
+	for _, r := range rs {
+		n = n<<bits | ngram(ngramRune(r, len(rs)))
+	}
+	return n
+}
+
+// ngramToRunesN is the inverse of runesToNGramN.
+func ngramToRunesN(n ngram, size int) []rune {
+	bits := ngramRuneBits(size)
+	mask := ngram(1)<<bits - 1
+	rs := make([]rune, size)
+	for i := size - 1; i >= 0; i-- {
+		rs[i] = rune(n & mask)
+		n >>= bits
+	}
+	return rs
+}
+
+// isCJK returns true for runes of the Han, Hiragana, Katakana and Hangul
+// scripts. These are written without spaces between words, and many words are
+// only one or two runes long.
+func isCJK(r rune) bool {
+	return r >= 0x1100 && unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// cjkBigram returns the key under which the CJK bigram r0 r1 is stored in an
+// index of ngrams of the given size: the bigram, left padded with NUL runes.
+// Documents containing NUL are not indexed, so the keys don't collide with
+// regular ngrams in practice. Collisions would only cost performance anyway.
+func cjkBigram(r0, r1 rune, size int) ngram {
+	rs := make([]rune, size)
+	rs[size-2], rs[size-1] = r0, r1
+	return runesToNGramN(rs)
+}
+
+// splitCJKBigrams returns the CJK bigram keys of str, see cjkBigram. It
+// returns nil unless str consists of at least 2 CJK runes.
+func splitCJKBigrams(str string, size int) []runeNgramOff {
+	runes := []rune(str)
+	if len(runes) < 2 {
+		return nil
+	}
+	for _, r := range runes {
+		if !isCJK(r) {
+			return nil
+		}
+	}
+
+	result := make([]runeNgramOff, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		result = append(result, runeNgramOff{
+			ngram: cjkBigram(runes[i], runes[i+1], size),
+			index: i,
+		})
+	}
+	return result
+}
+
+// format returns n as a string. ngrams don't know their size, so it has to be
+// passed in, see indexData.shardNgramSize.
+func (n ngram) format(size int) string {
+	return string(ngramToRunesN(n, size))
 }
 
 type runeNgramOff struct {
@@ -123,33 +242,18 @@ func (a runeNgramOff) Compare(b runeNgramOff) int {
 	}
 }
 
-func splitNGrams(str []byte) []runeNgramOff {
-	var runeGram [3]rune
-	var off [3]uint32
-	var runeCount int
-
-	result := make([]runeNgramOff, 0, len(str))
-	var i uint32
-
-	for len(str) > 0 {
-		r, sz := utf8.DecodeRune(str)
-		str = str[sz:]
-		runeGram[0] = runeGram[1]
-		off[0] = off[1]
-		runeGram[1] = runeGram[2]
-		off[1] = off[2]
-		runeGram[2] = r
-		off[2] = uint32(i)
-		i += uint32(sz)
-		runeCount++
-		if runeCount < ngramSize {
-			continue
-		}
+// splitNGrams returns the ngrams of the given size in str, in order.
+func splitNGrams(str []byte, size int) []runeNgramOff {
+	runes := []rune(string(str))
+	if len(runes) < size {
+		return nil
+	}
 
-		ng := runesToNGram(runeGram)
+	result := make([]runeNgramOff, 0, len(runes)-size+1)
+	for i := 0; i+size <= len(runes); i++ {
 		result = append(result, runeNgramOff{
-			ngram: ng,
-			index: len(result),
+			ngram: runesToNGramN(runes[i : i+size]),
+			index: i,
 		})
 	}
 
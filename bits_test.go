@@ -32,8 +32,8 @@ var _ = log.Println
 func TestNgram(t *testing.T) {
 	in := "abc"
 	n := stringToNGram(in)
-	if n.String() != "abc" {
-		t.Errorf("got %q, want %q", n, "abc")
+	if got := n.format(ngramSize); got != "abc" {
+		t.Errorf("got %q, want %q", got, "abc")
 	}
 
 	f := func(b ngramRunes) bool {
@@ -50,6 +50,31 @@ func TestNgram(t *testing.T) {
 	}
 }
 
+func TestNgramN(t *testing.T) {
+	f := func(b ngramRunes) bool {
+		return runesToNGramN(b[:]) == runesToNGram(b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	for size := minNgramSize; size <= maxNgramSize; size++ {
+		in := []rune("a日本é")[:size]
+		got := ngramToRunesN(runesToNGramN(in), size)
+		if !reflect.DeepEqual(in, got) {
+			t.Errorf("size %d: got %q, want %q", size, got, in)
+		}
+	}
+
+	// Runes outside the BMP don't fit into 4-grams, but both cases of a letter
+	// must end up as the same ngram.
+	upper := runesToNGramN([]rune("a\U00010400bc"))
+	lower := runesToNGramN([]rune("a\U00010428bc"))
+	if upper != lower {
+		t.Errorf("got different ngrams %x and %x for both cases", upper, lower)
+	}
+}
+
 type ngramRunes [ngramSize]rune
 
 func (ngramRunes) Generate(rand *rand.Rand, size int) reflect.Value {
@@ -73,7 +98,7 @@ func TestDocSection(t *testing.T) {
 
 func TestGenerateCaseNgrams(t *testing.T) {
 	ng := stringToNGram("aB1")
-	gotNG := generateCaseNgrams(ng)
+	gotNG := generateCaseNgrams(ng, ngramSize)
 
 	got := map[string]bool{}
 	for _, n := range gotNG {
@@ -90,6 +115,15 @@ func TestGenerateCaseNgrams(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got %v, want %v", got, want)
 	}
+
+	got = map[string]bool{}
+	for _, n := range generateCaseNgrams(runesToNGramN([]rune("aB")), 2) {
+		got[string(ngramToRunesN(n, 2))] = true
+	}
+	want = map[string]bool{"aB": true, "AB": true, "ab": true, "Ab": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bigrams: got %v, want %v", got, want)
+	}
 }
 
 func TestNextFileIndex(t *testing.T) {
@@ -148,7 +182,7 @@ func TestCompressedPostingIterator(t *testing.T) {
 		}
 
 		var nums []uint32
-		i := newCompressedPostingIterator(data, stringToNGram("abc"))
+		i := newCompressedPostingIterator(data, stringToNGram("abc"), ngramSize)
 		for i.first() != maxUInt32 {
 			nums = append(nums, i.first())
 			i.next(i.first())
@@ -315,3 +349,44 @@ func BenchmarkUnmarshalDocSections(b *testing.B) {
 		})
 	}
 }
+
+func TestASCIIEqualFold(t *testing.T) {
+	for _, tc := range []struct {
+		lower, mixed string
+		want         bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "ABCdef", true},
+		{"abc", "aBd", false},
+		{"ab", "a", false},
+		{"a[", "A[", true},
+		{"a{", "A[", false},
+		{"@x", "`X", false},
+	} {
+		if got := asciiEqualFold([]byte(tc.lower), []byte(tc.mixed)); got != tc.want {
+			t.Errorf("asciiEqualFold(%q, %q): got %v, want %v", tc.lower, tc.mixed, got, tc.want)
+		}
+		_, want := caseFoldingEqualsRunes([]byte(tc.lower), []byte(tc.mixed))
+		if want != tc.want {
+			t.Errorf("caseFoldingEqualsRunes(%q, %q): got %v, want %v", tc.lower, tc.mixed, want, tc.want)
+		}
+	}
+}
+
+func BenchmarkCaseFoldingEquals(b *testing.B) {
+	lower := []byte("newsearcher")
+	mixed := []byte("NewSearcher(")
+
+	b.Run("runes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			caseFoldingEqualsRunes(lower, mixed)
+		}
+	})
+	b.Run("ascii", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			asciiEqualFold(lower, mixed)
+		}
+	})
+}
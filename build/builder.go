@@ -17,7 +17,9 @@
 package build
 
 import (
+	"bytes"
 	"cmp"
+	"context"
 	"crypto/sha1"
 	"flag"
 	"fmt"
@@ -30,6 +32,7 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/pprof"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -40,9 +43,14 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/go-enry/go-enry/v2"
 	"github.com/rs/xid"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/ctags"
+	"github.com/sourcegraph/zoekt/internal/charset"
+	"github.com/sourcegraph/zoekt/internal/generated"
+	"github.com/sourcegraph/zoekt/internal/languages"
+	"github.com/sourcegraph/zoekt/internal/traffic"
 )
 
 var DefaultDir = filepath.Join(os.Getenv("HOME"), ".zoekt")
@@ -64,12 +72,72 @@ type Options struct {
 	// Parallelism is the maximum number of shards to index in parallel
 	Parallelism int
 
+	// MemoryBudget, if set, bounds the memory in bytes of the shards being
+	// built in parallel. Shards wait for their share of the budget, which
+	// is estimated from the size of their content, before they are built,
+	// and spill their posting lists to temporary files when these grow
+	// beyond their share.
+	MemoryBudget int64
+
+	// CTagsWorkers is the number of ctags processes parsing the symbols of
+	// a shard in parallel. Zero means one.
+	CTagsWorkers int
+
 	// ShardMax sets the maximum corpus size for a single shard
 	ShardMax int
 
+	// HotShardMax, if set, replaces ShardMax for repositories with at least
+	// HotThreshold searches per hour, according to the traffic statistics
+	// the webserver writes to IndexDir. Smaller shards are searched in
+	// parallel.
+	HotShardMax int
+
+	// HotThreshold is the number of searches per hour from which on a
+	// repository is hot. Zero means the default of 10.
+	HotThreshold float64
+
+	// ShardByDirectory splits the repository along its top-level
+	// directories: each shard only holds the files of one top-level
+	// directory, or the files at the root. Shards of different directories
+	// are built in parallel, and the manifest of the shard set records the
+	// directory of each shard, see zoekt.ShardManifest. This suits large
+	// monorepos.
+	ShardByDirectory bool
+
 	// TrigramMax sets the maximum number of distinct trigrams per document.
 	TrigramMax int
 
+	// LineMax, if set, is the maximum length of a line in bytes. Documents
+	// with longer lines, typically minified or generated data, are skipped
+	// unless they match LargeFiles.
+	LineMax int
+
+	// NgramSize is the number of runes per indexed ngram, between 2 and 4.
+	// Zero means the default of 3. Bigrams improve recall for CJK-heavy
+	// corpora, at the cost of larger and less selective posting lists.
+	NgramSize int
+
+	// FileMetrics enables computing per-file metrics (non-blank lines,
+	// nesting depth and TODO markers), searchable with loc:, nesting: and
+	// todos: queries.
+	FileMetrics bool
+
+	// BloomFilter enables writing a bloom filter of the content n-grams of
+	// each shard, so searches for rare literals skip shards without reading
+	// their ngram index. It costs about half a byte per byte of content.
+	BloomFilter bool
+
+	// SymbolsOnly builds symbol-only shards, which hold file names and the
+	// symbols ctags finds instead of the full content of files. They are
+	// many times smaller and serve file name and sym: searches, for
+	// repositories which don't need full-text search, like archived ones.
+	SymbolsOnly bool
+
+	// DisableSecrets disables scanning documents for strings that look like
+	// credentials, see internal/secrets. The scan runs on every document, so
+	// this also makes indexing a little cheaper.
+	DisableSecrets bool
+
 	// RepositoryDescription holds names and URLs for the repository.
 	RepositoryDescription zoekt.Repository
 
@@ -95,6 +163,17 @@ type Options struct {
 	// https://github.com/bmatcuk/doublestar/tree/v1#patterns.
 	LargeFiles []string
 
+	// ExcludePatterns are patterns of file paths which are not indexed, eg.
+	// "**/node_modules/**" or "**/*.min.js". Patterns are globs with the syntax
+	// of LargeFiles, or regular expressions if they are prefixed with
+	// "regex:", eg. "regex:\.pb\.go$".
+	ExcludePatterns []string
+
+	// IncludePatterns, if set, restricts indexing to the file paths which
+	// match one of the patterns, with the syntax of ExcludePatterns.
+	// ExcludePatterns take precedence.
+	IncludePatterns []string
+
 	// IsDelta is true if this run contains only the changed documents since the
 	// last run.
 	IsDelta bool
@@ -106,6 +185,13 @@ type Options struct {
 
 	LanguageMap ctags.LanguageMap
 
+	// LanguageOverrides maps glob patterns (same syntax as LargeFiles) to a
+	// language name. Documents matching a pattern are tagged with that
+	// language instead of the one detected by go-enry. If several patterns
+	// match, the longest pattern wins. Names are resolved like the lang: query
+	// atom, so both "golang" and "Go" map to "Go".
+	LanguageOverrides map[string]string
+
 	// ShardMerging is true if builder should respect compound shards. This is a
 	// Sourcegraph specific option.
 	ShardMerging bool
@@ -124,20 +210,40 @@ type Options struct {
 
 // HashOptions contains only the options in Options that upon modification leads to IndexState of IndexStateMismatch during the next index building.
 type HashOptions struct {
-	sizeMax          int
-	disableCTags     bool
-	ctagsPath        string
-	cTagsMustSucceed bool
-	largeFiles       []string
+	sizeMax           int
+	trigramMax        int
+	lineMax           int
+	disableCTags      bool
+	ctagsPath         string
+	cTagsMustSucceed  bool
+	largeFiles        []string
+	excludePatterns   []string
+	includePatterns   []string
+	languageOverrides map[string]string
+	ngramSize         int
+	fileMetrics       bool
+	bloomFilter       bool
+	symbolsOnly       bool
+	disableSecrets    bool
 }
 
 func (o *Options) HashOptions() HashOptions {
 	return HashOptions{
-		sizeMax:          o.SizeMax,
-		disableCTags:     o.DisableCTags,
-		ctagsPath:        o.CTagsPath,
-		cTagsMustSucceed: o.CTagsMustSucceed,
-		largeFiles:       o.LargeFiles,
+		sizeMax:           o.SizeMax,
+		trigramMax:        o.TrigramMax,
+		lineMax:           o.LineMax,
+		disableCTags:      o.DisableCTags,
+		ctagsPath:         o.CTagsPath,
+		cTagsMustSucceed:  o.CTagsMustSucceed,
+		largeFiles:        o.LargeFiles,
+		excludePatterns:   o.ExcludePatterns,
+		includePatterns:   o.IncludePatterns,
+		languageOverrides: o.LanguageOverrides,
+		ngramSize:         o.NgramSize,
+		fileMetrics:       o.FileMetrics,
+		bloomFilter:       o.BloomFilter,
+		symbolsOnly:       o.SymbolsOnly,
+		disableSecrets:    o.DisableSecrets,
 	}
 }
 
@@ -151,6 +257,45 @@ func (o *Options) GetHash() string {
 	hasher.Write([]byte(fmt.Sprintf("%q", h.largeFiles)))
 	hasher.Write([]byte(fmt.Sprintf("%t", h.disableCTags)))
 
+	// Only hash overrides if present, so adding the option doesn't change the
+	// hash of existing indexes.
+	if len(h.languageOverrides) > 0 {
+		// fmt prints maps sorted by key.
+		hasher.Write([]byte(fmt.Sprintf("%q", h.languageOverrides)))
+	}
+
+	if len(h.excludePatterns) > 0 || len(h.includePatterns) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("exclude%q include%q", h.excludePatterns, h.includePatterns)))
+	}
+
+	if h.ngramSize != 0 {
+		hasher.Write([]byte(fmt.Sprintf("ngram%d", h.ngramSize)))
+	}
+
+	if h.trigramMax != 0 && h.trigramMax != defaultTrigramMax {
+		hasher.Write([]byte(fmt.Sprintf("trigramMax%d", h.trigramMax)))
+	}
+
+	if h.lineMax != 0 {
+		hasher.Write([]byte(fmt.Sprintf("lineMax%d", h.lineMax)))
+	}
+
+	if h.fileMetrics {
+		hasher.Write([]byte("fileMetrics"))
+	}
+
+	if h.bloomFilter {
+		hasher.Write([]byte("bloomFilter"))
+	}
+
+	if h.symbolsOnly {
+		hasher.Write([]byte("symbolsOnly"))
+	}
+
+	if h.disableSecrets {
+		hasher.Write([]byte("disableSecrets"))
+	}
+
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
@@ -173,18 +318,110 @@ func (f largeFilesFlag) Set(value string) error {
 	return nil
 }
 
+// pathPatternsFlag is a repeatable flag for ExcludePatterns or
+// IncludePatterns.
+type pathPatternsFlag struct{ patterns *[]string }
+
+func (f pathPatternsFlag) String() string {
+	if f.patterns == nil {
+		return ""
+	}
+	return strings.Join(*f.patterns, ",")
+}
+
+func (f pathPatternsFlag) Set(value string) error {
+	*f.patterns = append(*f.patterns, value)
+	return nil
+}
+
+type languageOverridesFlag struct{ *Options }
+
+func (f languageOverridesFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	patterns := make([]string, 0, len(f.LanguageOverrides))
+	for pattern := range f.LanguageOverrides {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	s := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		s = append(s, pattern+"="+f.LanguageOverrides[pattern])
+	}
+	return strings.Join(s, ",")
+}
+
+func (f languageOverridesFlag) Set(value string) error {
+	pattern, language, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" || language == "" {
+		return fmt.Errorf("language override %q must be of the form GLOB=LANGUAGE", value)
+	}
+	if f.LanguageOverrides == nil {
+		f.LanguageOverrides = make(map[string]string)
+	}
+	f.LanguageOverrides[pattern] = language
+	return nil
+}
+
+type repoMetaFlag struct{ *Options }
+
+func (f repoMetaFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(f.RepositoryDescription.Metadata))
+	for key := range f.RepositoryDescription.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s := make([]string, 0, len(keys))
+	for _, key := range keys {
+		s = append(s, key+"="+f.RepositoryDescription.Metadata[key])
+	}
+	return strings.Join(s, ",")
+}
+
+func (f repoMetaFlag) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("repository metadata %q must be of the form KEY=VALUE", value)
+	}
+	if f.RepositoryDescription.Metadata == nil {
+		f.RepositoryDescription.Metadata = make(map[string]string)
+	}
+	f.RepositoryDescription.Metadata[key] = v
+	return nil
+}
+
 // Flags adds flags for build options to fs. It is the "inverse" of Args.
 func (o *Options) Flags(fs *flag.FlagSet) {
 	x := *o
 	x.SetDefaults()
 	fs.IntVar(&o.SizeMax, "file_limit", x.SizeMax, "maximum file size")
 	fs.IntVar(&o.TrigramMax, "max_trigram_count", x.TrigramMax, "maximum number of trigrams per document")
+	fs.IntVar(&o.LineMax, "max_line_length", x.LineMax, "If set, documents with a line longer than this many bytes are not indexed, unless they match -large_file.")
 	fs.IntVar(&o.ShardMax, "shard_limit", x.ShardMax, "maximum corpus size for a shard")
+	fs.IntVar(&o.HotShardMax, "hot_shard_limit", x.HotShardMax, "If set, maximum corpus size for a shard of a repository with at least -hot_threshold searches per hour, according to the traffic statistics of zoekt-webserver -traffic_stats_interval.")
+	fs.Float64Var(&o.HotThreshold, "hot_threshold", x.HotThreshold, "number of searches per hour from which on a repository uses -hot_shard_limit. Defaults to 10.")
+	fs.BoolVar(&o.ShardByDirectory, "shard_by_directory", x.ShardByDirectory, "If set, each shard only holds the files of one top-level directory, so that large monorepos are indexed in parallel along directories.")
+	fs.IntVar(&o.NgramSize, "ngram_size", x.NgramSize, "number of runes per indexed ngram, between 2 and 4. Defaults to 3.")
+	fs.BoolVar(&o.FileMetrics, "file_metrics", x.FileMetrics, "If set, compute per-file metrics for loc:, nesting: and todos: queries.")
+	fs.BoolVar(&o.BloomFilter, "bloom_filter", x.BloomFilter, "If set, write a bloom filter of the content of each shard, so searches for rare literals skip shards which can't contain them.")
+	fs.BoolVar(&o.SymbolsOnly, "symbols_only", x.SymbolsOnly, "If set, build symbol-only shards which hold file names and symbols instead of file contents. They are much smaller, but content searches only find symbols.")
+	fs.BoolVar(&o.DisableSecrets, "disable_secrets", x.DisableSecrets, "If set, don't scan documents for strings that look like credentials, so has:secret finds nothing.")
 	fs.IntVar(&o.Parallelism, "parallelism", x.Parallelism, "maximum number of parallel indexing processes.")
+	fs.Int64Var(&o.MemoryBudget, "memory_budget", x.MemoryBudget, "If set, the approximate maximum memory in bytes used for building shards in parallel. Large posting lists are spilled to temporary files to stay within the budget.")
+	fs.IntVar(&o.CTagsWorkers, "ctags_workers", x.CTagsWorkers, "number of ctags processes per shard. Defaults to 1.")
 	fs.StringVar(&o.IndexDir, "index", x.IndexDir, "directory for search indices")
 	fs.BoolVar(&o.CTagsMustSucceed, "require_ctags", x.CTagsMustSucceed, "If set, ctags calls must succeed.")
 	fs.Var(largeFilesFlag{o}, "large_file", "A glob pattern where matching files are to be index regardless of their size. You can add multiple patterns by setting this more than once.")
+	fs.Var(pathPatternsFlag{&o.ExcludePatterns}, "exclude_path", "A glob pattern, or a regular expression prefixed with \"regex:\", of file paths which are not indexed. You can add multiple patterns by setting this more than once.")
+	fs.Var(pathPatternsFlag{&o.IncludePatterns}, "include_path", "If set, only file paths matching this pattern, or another -include_path, are indexed. The syntax is that of -exclude_path, which takes precedence.")
 	fs.StringVar(&o.ShardPrefix, "shard_prefix", x.ShardPrefix, "the prefix of the shard. Defaults to repository name")
+	fs.Var(languageOverridesFlag{o}, "language_override", "A GLOB=LANGUAGE pair overriding the detected language of matching files. You can add multiple overrides by setting this more than once.")
+	fs.Var(repoMetaFlag{o}, "repo_meta", "A KEY=VALUE pair of repository metadata, searchable with meta:KEY=VALUE. You can add multiple pairs by setting this more than once.")
+	fs.StringVar(&o.RepositoryDescription.ForkOf, "fork_of", x.RepositoryDescription.ForkOf, "If set, index the repository as a fork of this repository, which must be indexed first with the same branch. Files with the same content as in the parent are only indexed in the parent. The fork must be reindexed whenever the parent is.")
 
 	// Sourcegraph specific
 	fs.BoolVar(&o.DisableCTags, "disable_ctags", x.DisableCTags, "If set, ctags will not be called.")
@@ -203,14 +440,58 @@ func (o *Options) Args() []string {
 		args = append(args, "-max_trigram_count", strconv.Itoa(o.TrigramMax))
 	}
 
+	if o.LineMax != 0 {
+		args = append(args, "-max_line_length", strconv.Itoa(o.LineMax))
+	}
+
 	if o.ShardMax != 0 {
 		args = append(args, "-shard_limit", strconv.Itoa(o.ShardMax))
 	}
 
+	if o.HotShardMax != 0 {
+		args = append(args, "-hot_shard_limit", strconv.Itoa(o.HotShardMax))
+	}
+
+	if o.HotThreshold != 0 {
+		args = append(args, "-hot_threshold", strconv.FormatFloat(o.HotThreshold, 'g', -1, 64))
+	}
+
+	if o.ShardByDirectory {
+		args = append(args, "-shard_by_directory")
+	}
+
+	if o.NgramSize != 0 {
+		args = append(args, "-ngram_size", strconv.Itoa(o.NgramSize))
+	}
+
+	if o.FileMetrics {
+		args = append(args, "-file_metrics")
+	}
+
+	if o.BloomFilter {
+		args = append(args, "-bloom_filter")
+	}
+
+	if o.SymbolsOnly {
+		args = append(args, "-symbols_only")
+	}
+
+	if o.DisableSecrets {
+		args = append(args, "-disable_secrets")
+	}
+
 	if o.Parallelism != 0 {
 		args = append(args, "-parallelism", strconv.Itoa(o.Parallelism))
 	}
 
+	if o.MemoryBudget != 0 {
+		args = append(args, "-memory_budget", strconv.FormatInt(o.MemoryBudget, 10))
+	}
+
+	if o.CTagsWorkers != 0 {
+		args = append(args, "-ctags_workers", strconv.Itoa(o.CTagsWorkers))
+	}
+
 	if o.IndexDir != "" {
 		args = append(args, "-index", o.IndexDir)
 	}
@@ -223,6 +504,36 @@ func (o *Options) Args() []string {
 		args = append(args, "-large_file", a)
 	}
 
+	for _, p := range o.ExcludePatterns {
+		args = append(args, "-exclude_path", p)
+	}
+
+	for _, p := range o.IncludePatterns {
+		args = append(args, "-include_path", p)
+	}
+
+	patterns := make([]string, 0, len(o.LanguageOverrides))
+	for pattern := range o.LanguageOverrides {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		args = append(args, "-language_override", pattern+"="+o.LanguageOverrides[pattern])
+	}
+
+	keys := make([]string, 0, len(o.RepositoryDescription.Metadata))
+	for key := range o.RepositoryDescription.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-repo_meta", key+"="+o.RepositoryDescription.Metadata[key])
+	}
+
+	if o.RepositoryDescription.ForkOf != "" {
+		args = append(args, "-fork_of", o.RepositoryDescription.ForkOf)
+	}
+
 	// Sourcegraph specific
 	if o.DisableCTags {
 		args = append(args, "-disable_ctags")
@@ -246,11 +557,23 @@ type Builder struct {
 	opts     Options
 	throttle chan int
 
+	// memory holds the MemoryBudget, if set, which shards being built
+	// acquire according to their estimated memory.
+	memory *semaphore.Weighted
+
 	nextShardNum int
 	todo         []*zoekt.Document
 	docChecker   zoekt.DocChecker
+	pathFilter   *pathFilter
 	size         int
 
+	// fork is the parent of the repository, if it is indexed as a fork.
+	fork *forkParent
+
+	// byName indexes the documents in todo by name, to merge documents with
+	// the same content on different branches.
+	byName map[string][]*zoekt.Document
+
 	parserBins ctags.ParserBinMap
 	building   sync.WaitGroup
 
@@ -261,6 +584,10 @@ type Builder struct {
 	// them once all shards succeed to avoid Frankstein corpuses.
 	finishedShards map[string]string
 
+	// shardDirs maps the final names of finished shards to their top-level
+	// directory, if ShardByDirectory is set.
+	shardDirs map[string]string
+
 	// indexTime is set by tests for doing reproducible builds.
 	indexTime time.Time
 
@@ -322,7 +649,7 @@ func (o *Options) SetDefaults() {
 		o.ShardMax = 100 << 20
 	}
 	if o.TrigramMax == 0 {
-		o.TrigramMax = 20000
+		o.TrigramMax = defaultTrigramMax
 	}
 
 	if o.RepositoryDescription.Name == "" && o.RepositoryDescription.URL != "" {
@@ -532,6 +859,30 @@ func (o *Options) IgnoreSizeMax(name string) bool {
 	return false
 }
 
+// LanguageOverride returns the language configured in LanguageOverrides for
+// name. ok is false if no pattern matches.
+func (o *Options) LanguageOverride(name string) (language string, ok bool) {
+	best := ""
+	for pattern, lang := range o.LanguageOverrides {
+		if m, _ := doublestar.PathMatch(pattern, name); !m {
+			continue
+		}
+		// Prefer the most specific (longest) pattern. Break ties on the pattern
+		// itself so the result doesn't depend on map iteration order.
+		if ok && (len(pattern) < len(best) || len(pattern) == len(best) && pattern > best) {
+			continue
+		}
+		best, language, ok = pattern, lang, true
+	}
+	if !ok {
+		return "", false
+	}
+	if canonical, found := languages.GetLanguageByAlias(language); found {
+		language = canonical
+	}
+	return language, true
+}
+
 func checkIsNegatePattern(pattern string) (bool, string) {
 	negate := "!"
 
@@ -543,6 +894,12 @@ func checkIsNegatePattern(pattern string) (bool, string) {
 	return false, pattern
 }
 
+// defaultHotThreshold is the default of Options.HotThreshold.
+const defaultHotThreshold = 10
+
+// defaultTrigramMax is the default of Options.TrigramMax.
+const defaultTrigramMax = 20000
+
 // NewBuilder creates a new Builder instance.
 func NewBuilder(opts Options) (*Builder, error) {
 	opts.SetDefaults()
@@ -550,10 +907,55 @@ func NewBuilder(opts Options) (*Builder, error) {
 		return nil, fmt.Errorf("builder: must set Name")
 	}
 
+	if opts.HotShardMax > 0 {
+		threshold := opts.HotThreshold
+		if threshold == 0 {
+			threshold = defaultHotThreshold
+		}
+		if stats, err := traffic.Read(opts.IndexDir); err != nil {
+			log.Printf("ignoring traffic statistics: %v", err)
+		} else if stats.Hot(opts.RepositoryDescription.Name, threshold) {
+			opts.ShardMax = opts.HotShardMax
+		}
+	}
+
+	pathFilter, err := newPathFilter(opts.ExcludePatterns, opts.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var fork *forkParent
+	if opts.RepositoryDescription.ForkOf != "" {
+		if opts.IsDelta {
+			return nil, fmt.Errorf("builder: delta builds don't support forks")
+		}
+
+		// Inherited files carry the branch masks of the parent, which only
+		// match for a single branch.
+		if len(opts.RepositoryDescription.Branches) != 1 {
+			err = fmt.Errorf("forks must have exactly one branch")
+		} else {
+			fork, err = opts.loadForkParent()
+		}
+		if err != nil {
+			log.Printf("indexing fork %q in full: %v", opts.RepositoryDescription.Name, err)
+			opts.RepositoryDescription.ForkOf = ""
+			opts.RepositoryDescription.ForkOfID = 0
+		} else {
+			opts.RepositoryDescription.ForkOfID = fork.id
+		}
+	}
+
 	b := &Builder{
 		opts:           opts,
 		throttle:       make(chan int, opts.Parallelism),
 		finishedShards: map[string]string{},
+		shardDirs:      map[string]string{},
+		pathFilter:     pathFilter,
+		fork:           fork,
+	}
+	if opts.MemoryBudget > 0 {
+		b.memory = semaphore.NewWeighted(opts.MemoryBudget)
 	}
 
 	parserBins, err := ctags.NewParserBinMap(
@@ -600,27 +1002,29 @@ func (b *Builder) Add(doc zoekt.Document) error {
 		return nil
 	}
 
-	allowLargeFile := b.opts.IgnoreSizeMax(doc.Name)
-	if len(doc.Content) > b.opts.SizeMax && !allowLargeFile {
-		// We could pass the document on to the shardbuilder, but if
-		// we pass through a part of the source tree with binary/large
-		// files, the corresponding shard would be mostly empty, so
-		// insert a reason here too.
-		doc.SkipReason = fmt.Sprintf("document size %d larger than limit %d", len(doc.Content), b.opts.SizeMax)
-	} else if err := b.docChecker.Check(doc.Content, b.opts.TrigramMax, allowLargeFile); err != nil {
-		doc.SkipReason = err.Error()
-		doc.Language = "binary"
+	if !b.pathFilter.keep(doc.Name) {
+		return nil
 	}
 
+	b.opts.prepareDocument(&b.docChecker, &doc)
+	if b.fork != nil && b.fork.inherit(&doc) {
+		return nil
+	}
+	if b.mergeBranches(&doc) {
+		return nil
+	}
 	b.todo = append(b.todo, &doc)
+	if len(doc.Branches) > 0 {
+		if b.byName == nil {
+			b.byName = map[string][]*zoekt.Document{}
+		}
+		b.byName[doc.Name] = append(b.byName[doc.Name], &doc)
+	}
 
 	if doc.SkipReason == "" {
 		b.size += len(doc.Name) + len(doc.Content)
 	} else {
 		b.size += len(doc.Name) + len(doc.SkipReason)
-		// Drop the content if we are skipping the document. Skipped content is not counted towards the
-		// shard size limit, so otherwise we might buffer too much data in memory before flushing.
-		doc.Content = nil
 	}
 
 	if b.size > b.opts.ShardMax {
@@ -630,6 +1034,93 @@ func (b *Builder) Add(doc zoekt.Document) error {
 	return nil
 }
 
+// mergeBranches adds the branches of doc to a document in b.todo with the
+// same name and content, so that content which is identical on several
+// branches is stored once, with a branch mask covering all of them. It returns
+// false if there is no such document.
+//
+// Only documents buffered for the current shard are considered. Content is
+// never shared across repositories, so the repositories of a compound shard
+// still store their own copies of identical files.
+func (b *Builder) mergeBranches(doc *zoekt.Document) bool {
+	if len(doc.Branches) == 0 {
+		return false
+	}
+
+	for _, d := range b.byName[doc.Name] {
+		if !sameIndexedDocument(d, doc) {
+			continue
+		}
+		for _, br := range doc.Branches {
+			if !slices.Contains(d.Branches, br) {
+				// Clip, so that we don't write to the caller's slice.
+				d.Branches = append(slices.Clip(d.Branches), br)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sameIndexedDocument returns true if a and b only differ in their branches.
+// Documents with precomputed symbols are never the same.
+func sameIndexedDocument(a, b *zoekt.Document) bool {
+	return a.Name == b.Name &&
+		a.SkipReason == b.SkipReason &&
+		a.Language == b.Language &&
+		a.Encoding == b.Encoding &&
+		a.SubRepositoryPath == b.SubRepositoryPath &&
+		len(a.Symbols) == 0 && len(b.Symbols) == 0 &&
+		bytes.Equal(a.Content, b.Content)
+}
+
+// prepareDocument transcodes doc to UTF-8, sets its SkipReason if it is too
+// large, has too long lines or is binary and applies LanguageOverrides.
+func (o *Options) prepareDocument(checker *zoekt.DocChecker, doc *zoekt.Document) {
+	// Transcode before checking the content, since UTF-16 looks like binary.
+	if doc.SkipReason == "" && doc.Encoding == "" {
+		doc.Content, doc.Encoding = charset.ToUTF8(doc.Content)
+	}
+
+	allowLargeFile := o.IgnoreSizeMax(doc.Name)
+	if len(doc.Content) > o.SizeMax && !allowLargeFile {
+		// We could pass the document on to the shardbuilder, but if
+		// we pass through a part of the source tree with binary/large
+		// files, the corresponding shard would be mostly empty, so
+		// insert a reason here too.
+		doc.SkipReason = fmt.Sprintf("document size %d larger than limit %d", len(doc.Content), o.SizeMax)
+	} else if o.LineMax > 0 && !allowLargeFile && hasLineLongerThan(doc.Content, o.LineMax) {
+		doc.SkipReason = fmt.Sprintf("line longer than limit %d", o.LineMax)
+	} else if err := checker.Check(doc.Content, o.TrigramMax, allowLargeFile); err != nil {
+		doc.SkipReason = err.Error()
+		doc.Language = "binary"
+	}
+
+	if doc.Language == "" {
+		if lang, ok := o.LanguageOverride(doc.Name); ok {
+			doc.Language = lang
+		}
+	}
+
+	if doc.SkipReason != "" {
+		// Drop the content if we are skipping the document. Skipped content is not counted towards the
+		// shard size limit, so otherwise we might buffer too much data in memory before flushing.
+		doc.Content = nil
+	}
+}
+
+// hasLineLongerThan returns true if content has a line of more than n bytes.
+func hasLineLongerThan(content []byte, n int) bool {
+	for len(content) > n {
+		i := bytes.IndexByte(content[:n+1], '\n')
+		if i < 0 {
+			return true
+		}
+		content = content[i+1:]
+	}
+	return false
+}
+
 // MarkFileAsChangedOrRemoved indicates that the file specified by the given path
 // has been changed or removed since the last indexing job for this repository.
 //
@@ -668,6 +1159,26 @@ func (b *Builder) Finish() error {
 		artifactPaths[tmp] = final
 	}
 
+	if b.fork != nil {
+		// The files the fork doesn't inherit are only known now, so we store
+		// them in the metadata files of the new shards.
+		tombstones := b.fork.tombstones()
+		for tmp, final := range b.finishedShards {
+			repositories, _, err := zoekt.ReadMetadataPath(tmp)
+			if err != nil {
+				return fmt.Errorf("reading metadata from shard %q: %w", tmp, err)
+			}
+			repository := repositories[0]
+			repository.ForkTombstones = tombstones
+
+			tempPath, finalPath, err := zoekt.JsonMarshalRepoMetaTemp(final, repository)
+			if err != nil {
+				return fmt.Errorf("writing repository metadata for shard %q: %w", final, err)
+			}
+			artifactPaths[tempPath] = finalPath
+		}
+	}
+
 	oldShards := b.opts.FindAllShards()
 
 	if b.opts.IsDelta {
@@ -757,6 +1268,24 @@ func (b *Builder) Finish() error {
 		}
 	}
 
+	// The manifest goes first: until all new shards are renamed into place,
+	// they don't match it, and the shard watcher keeps serving the old ones.
+	published := make(map[string]string, len(b.finishedShards))
+	for tmp, final := range b.finishedShards {
+		published[final] = tmp
+	}
+	if b.opts.IsDelta {
+		for _, name := range oldShards {
+			published[name] = name
+		}
+	}
+	if b.buildError == nil {
+		if err := writeShardManifest(published, b.shardDirs); err != nil {
+			b.buildError = err
+			return b.buildError
+		}
+	}
+
 	for tmp, final := range artifactPaths {
 		if err := os.Rename(tmp, final); err != nil {
 			b.buildError = err
@@ -787,6 +1316,55 @@ func (b *Builder) Finish() error {
 	return b.buildError
 }
 
+// writeShardManifest writes the manifest for a repository that is split across
+// several shards, see zoekt.ShardManifest. shards maps the final path of each
+// shard to the path we can currently read it from, and dirs the final path of
+// new shards to their top-level directory, if the repository is sharded by
+// directory. Once a repository has a manifest, we keep it up to date even if
+// the repository shrinks to a single shard.
+func writeShardManifest(shards, dirs map[string]string) error {
+	var path string
+	for final := range shards {
+		path = zoekt.ShardManifestPath(final)
+		break
+	}
+	if path == "" {
+		return nil
+	}
+	// We rewrite a manifest we can't read.
+	old, err := zoekt.ReadShardManifest(path)
+	if os.IsNotExist(err) && len(shards) == 1 && len(dirs) == 0 {
+		return nil
+	}
+
+	m := &zoekt.ShardManifest{Shards: make(map[string]string, len(shards))}
+	for final, fn := range shards {
+		if zoekt.ShardManifestPath(final) != path {
+			return fmt.Errorf("shard %s does not belong to the shard set of %s", final, path)
+		}
+		_, md, err := zoekt.ReadMetadataPath(fn)
+		if err != nil {
+			return fmt.Errorf("reading metadata for manifest %s: %w", path, err)
+		}
+		base := filepath.Base(final)
+		m.Shards[base] = md.ID
+
+		// Shards which are already in place, like the older shards of a
+		// delta build, keep their directory.
+		dir, ok := dirs[final]
+		if !ok && fn == final && old != nil {
+			dir, ok = old.Directories[base]
+		}
+		if ok {
+			if m.Directories == nil {
+				m.Directories = map[string]string{}
+			}
+			m.Directories[base] = dir
+		}
+	}
+	return zoekt.WriteShardManifest(path, m)
+}
+
 // BranchNamesEqual compares the given zoekt.RepositoryBranch slices, and returns true
 // iff both slices specify the same set of branch names in the same order.
 func BranchNamesEqual(a, b []zoekt.RepositoryBranch) bool {
@@ -807,6 +1385,7 @@ func BranchNamesEqual(a, b []zoekt.RepositoryBranch) bool {
 func (b *Builder) flush() error {
 	todo := b.todo
 	b.todo = nil
+	b.byName = nil
 	b.size = 0
 	b.errMu.Lock()
 	defer b.errMu.Unlock()
@@ -819,14 +1398,34 @@ func (b *Builder) flush() error {
 		return nil
 	}
 
+	if !b.opts.ShardByDirectory || len(todo) == 0 {
+		return b.flushShard(todo, "")
+	}
+	dirs, groups := groupByDirectory(todo)
+	for i, dir := range dirs {
+		if err := b.flushShard(groups[i], dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushShard builds the next shard from todo, which holds the files of the
+// top-level directory dir if ShardByDirectory is set. The caller must hold
+// b.errMu.
+func (b *Builder) flushShard(todo []*zoekt.Document, dir string) error {
 	shard := b.nextShardNum
 	b.nextShardNum++
 
 	if b.opts.Parallelism > 1 {
 		b.building.Add(1)
 		b.throttle <- 1
+		weight := b.acquireMemory(todo)
 		go func() {
 			done, err := b.buildShard(todo, shard)
+			if weight > 0 {
+				b.memory.Release(weight)
+			}
 			<-b.throttle
 
 			b.errMu.Lock()
@@ -835,7 +1434,7 @@ func (b *Builder) flush() error {
 				b.buildError = err
 			}
 			if err == nil {
-				b.finishedShards[done.temp] = done.final
+				b.finishShard(done, dir)
 			}
 			b.building.Done()
 		}()
@@ -845,7 +1444,7 @@ func (b *Builder) flush() error {
 		done, err := b.buildShard(todo, shard)
 		b.buildError = err
 		if err == nil {
-			b.finishedShards[done.temp] = done.final
+			b.finishShard(done, dir)
 		}
 
 		return b.buildError
@@ -854,6 +1453,59 @@ func (b *Builder) flush() error {
 	return nil
 }
 
+// acquireMemory waits for the share of the MemoryBudget needed to build a
+// shard from todo, and returns it. The share is a rough estimate: the content
+// of the documents, their posting lists and the shard being written, each
+// about as large as the content.
+func (b *Builder) acquireMemory(todo []*zoekt.Document) int64 {
+	if b.memory == nil {
+		return 0
+	}
+	var size int64
+	for _, d := range todo {
+		size += int64(len(d.Content))
+	}
+	weight := min(3*size, b.opts.MemoryBudget)
+	if weight <= 0 {
+		return 0
+	}
+	// Acquire can only fail if the context is canceled.
+	_ = b.memory.Acquire(context.Background(), weight)
+	return weight
+}
+
+// finishShard records a shard built by flushShard. The caller must hold
+// b.errMu.
+func (b *Builder) finishShard(done *finishedShard, dir string) {
+	b.finishedShards[done.temp] = done.final
+	if b.opts.ShardByDirectory {
+		b.shardDirs[done.final] = dir
+	}
+}
+
+// groupByDirectory groups docs by their top-level directory, in the order the
+// directories first appear. Files at the root have the directory "".
+func groupByDirectory(docs []*zoekt.Document) ([]string, [][]*zoekt.Document) {
+	var dirs []string
+	var groups [][]*zoekt.Document
+	index := map[string]int{}
+	for _, d := range docs {
+		dir, _, ok := strings.Cut(d.Name, "/")
+		if !ok {
+			dir = ""
+		}
+		i, ok := index[dir]
+		if !ok {
+			i = len(dirs)
+			index[dir] = i
+			dirs = append(dirs, dir)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+	return dirs, groups
+}
+
 // map [0,inf) to [0,1) monotonically
 func squashRange(j int) float64 {
 	x := float64(j)
@@ -867,7 +1519,7 @@ func squashRange(j int) float64 {
 // These 'priority' criteria affects how documents are ordered within a shard. It's
 // also used to help guess a file's rank when we're missing ranking information.
 func IsLowPriority(path string, content []byte) bool {
-	return enry.IsTest(path) || enry.IsVendor(path) || enry.IsGenerated(path, content)
+	return enry.IsTest(path) || enry.IsVendor(path) || generated.Is(path, content)
 }
 
 type rankedDoc struct {
@@ -885,9 +1537,9 @@ func rank(d *zoekt.Document, origIdx int) []float64 {
 		skipped = 1.0
 	}
 
-	generated := 0.0
-	if enry.IsGenerated(d.Name, d.Content) {
-		generated = 1.0
+	gen := 0.0
+	if generated.Is(d.Name, d.Content) {
+		gen = 1.0
 	}
 
 	vendor := 0.0
@@ -906,7 +1558,7 @@ func rank(d *zoekt.Document, origIdx int) []float64 {
 		skipped,
 
 		// Prefer docs that are not generated
-		generated,
+		gen,
 
 		// Prefer docs that are not vendored
 		vendor,
@@ -958,7 +1610,7 @@ func sortDocuments(todo []*zoekt.Document) {
 
 func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishedShard, error) {
 	if !b.opts.DisableCTags && (b.opts.CTagsPath != "" || b.opts.ScipCTagsPath != "") {
-		err := parseSymbols(todo, b.opts.LanguageMap, b.parserBins)
+		err := parseSymbols(todo, b.opts.LanguageMap, b.parserBins, b.opts.CTagsWorkers)
 		if b.opts.CTagsMustSucceed && err != nil {
 			return nil, err
 		}
@@ -1021,17 +1673,35 @@ func (b *Builder) CheckMemoryUsage() {
 }
 
 func (b *Builder) newShardBuilder() (*zoekt.IndexBuilder, error) {
-	desc := b.opts.RepositoryDescription
-	desc.HasSymbols = !b.opts.DisableCTags && b.opts.CTagsPath != ""
-	desc.SubRepoMap = b.opts.SubRepositories
-	desc.IndexOptions = b.opts.GetHash()
+	return b.opts.newShardBuilder(b.indexTime, b.id)
+}
+
+func (o *Options) newShardBuilder(indexTime time.Time, id string) (*zoekt.IndexBuilder, error) {
+	desc := o.RepositoryDescription
+	desc.HasSymbols = !o.DisableCTags && o.CTagsPath != ""
+	desc.SubRepoMap = o.SubRepositories
+	desc.IndexOptions = o.GetHash()
 
 	shardBuilder, err := zoekt.NewIndexBuilder(&desc)
 	if err != nil {
 		return nil, err
 	}
-	shardBuilder.IndexTime = b.indexTime
-	shardBuilder.ID = b.id
+	if o.NgramSize != 0 {
+		if err := shardBuilder.SetNgramSize(o.NgramSize); err != nil {
+			return nil, err
+		}
+	}
+	shardBuilder.FileMetrics = o.FileMetrics
+	shardBuilder.BloomFilter = o.BloomFilter
+	shardBuilder.SymbolsOnly = o.SymbolsOnly
+	shardBuilder.DisableSecrets = o.DisableSecrets
+	if o.MemoryBudget > 0 {
+		// Half of the budget goes to posting lists, shared by the shards
+		// built in parallel.
+		shardBuilder.PostingsSpillBytes = int(o.MemoryBudget / int64(2*max(o.Parallelism, 1)))
+	}
+	shardBuilder.IndexTime = indexTime
+	shardBuilder.ID = id
 	return shardBuilder, nil
 }
 
@@ -1068,6 +1738,9 @@ func (b *Builder) writeShard(fn string, ib *zoekt.IndexBuilder) (*finishedShard,
 		fi.Size(),
 		float64(fi.Size())/float64(ib.ContentSize()+1),
 		ib.NumFiles())
+	if n := ib.LockfileErrors(); n > 0 {
+		log.Printf("shard %s: ignored the dependencies of %d lockfiles which could not be parsed", fn, n)
+	}
 
 	return &finishedShard{f.Name(), fn}, nil
 }
@@ -1,12 +1,15 @@
 package build
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -16,6 +19,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 var update = flag.Bool("update", false, "update golden file")
@@ -49,7 +53,9 @@ func TestBuildv16(t *testing.T) {
 		}
 	}
 
-	wantP := filepath.Join("../testdata/shards", "repo_v16.00000.zoekt")
+	// repo_v16.00000.zoekt was written before section checksums, and stays
+	// as it is to check that we can still read it.
+	wantP := filepath.Join("../testdata/shards", "repo_checksums_v16.00000.zoekt")
 
 	// fields indexTime and id depend on time. For this test, we copy the fields from
 	// the old shard.
@@ -129,6 +135,91 @@ func TestFlags(t *testing.T) {
 		want: Options{
 			LargeFiles: []string{"*.md", "\\!*.yaml"},
 		},
+	}, {
+		// language overrides
+		args: []string{"-language_override", "*.tpl=html", "-language_override", "BUILD=Starlark"},
+		want: Options{
+			LanguageOverrides: map[string]string{"*.tpl": "html", "BUILD": "Starlark"},
+		},
+	}, {
+		// path patterns
+		args: []string{"-exclude_path", "**/node_modules/**", "-exclude_path", "regex:\\.pb\\.go$", "-include_path", "src/**"},
+		want: Options{
+			ExcludePatterns: []string{"**/node_modules/**", "regex:\\.pb\\.go$"},
+			IncludePatterns: []string{"src/**"},
+		},
+	}, {
+		// ngram size
+		args: []string{"-ngram_size", "2"},
+		want: Options{
+			NgramSize: 2,
+		},
+	}, {
+		// file metrics
+		args: []string{"-file_metrics"},
+		want: Options{
+			FileMetrics: true,
+		},
+	}, {
+		// bloom filter
+		args: []string{"-bloom_filter"},
+		want: Options{
+			BloomFilter: true,
+		},
+	}, {
+		// symbol-only shards
+		args: []string{"-symbols_only"},
+		want: Options{
+			SymbolsOnly: true,
+		},
+	}, {
+		// no secret scanning
+		args: []string{"-disable_secrets"},
+		want: Options{
+			DisableSecrets: true,
+		},
+	}, {
+		// traffic based shard size
+		args: []string{"-hot_shard_limit", "1048576", "-hot_threshold", "2.5"},
+		want: Options{
+			HotShardMax:  1 << 20,
+			HotThreshold: 2.5,
+		},
+	}, {
+		// repository metadata
+		args: []string{"-repo_meta", "team=payments", "-repo_meta", "tier=1"},
+		want: Options{
+			RepositoryDescription: zoekt.Repository{
+				Metadata: map[string]string{"team": "payments", "tier": "1"},
+			},
+		},
+	}, {
+		// directory sharding
+		args: []string{"-shard_by_directory"},
+		want: Options{
+			ShardByDirectory: true,
+		},
+	}, {
+		// memory budget
+		args: []string{"-memory_budget", "1073741824", "-ctags_workers", "4"},
+		want: Options{
+			MemoryBudget: 1 << 30,
+			CTagsWorkers: 4,
+		},
+	}, {
+		// line length limit
+		args: []string{"-max_line_length", "1000"},
+		want: Options{
+			LineMax: 1000,
+		},
+	}, {
+		// fork
+		args: []string{"-fork_of", "github.com/org/repo"},
+		want: Options{
+			RepositoryDescription: zoekt.Repository{
+				ForkOf: "github.com/org/repo",
+			},
+		},
 	}}
 
 	ignored := []cmp.Option{
@@ -253,6 +344,266 @@ func TestDontCountContentOfSkippedFiles(t *testing.T) {
 	}
 }
 
+func TestPrepareDocument_LineMax(t *testing.T) {
+	o := Options{LineMax: 10, LargeFiles: []string{"*.min.js"}}
+	o.SetDefaults()
+
+	for _, tc := range []struct {
+		name, content string
+		skip          bool
+	}{
+		{"a.go", "0123456789\n0123456789", false},
+		{"b.go", "short\n0123456789a\nshort", true},
+		{"c.go", "short\n0123456789a", true},
+		{"d.min.js", "0123456789a", false},
+	} {
+		doc := zoekt.Document{Name: tc.name, Content: []byte(tc.content)}
+		o.prepareDocument(&zoekt.DocChecker{}, &doc)
+		if got := doc.SkipReason != ""; got != tc.skip {
+			t.Errorf("%s: got skipped %t (%q), want %t", tc.name, got, doc.SkipReason, tc.skip)
+		}
+	}
+}
+
+func TestBuilder_MergeBranches(t *testing.T) {
+	b, err := NewBuilder(Options{RepositoryDescription: zoekt.Repository{
+		Name:     "foo",
+		Branches: []zoekt.RepositoryBranch{{Name: "main"}, {Name: "release"}, {Name: "dev"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainBranches := []string{"main"}
+	for _, doc := range []zoekt.Document{
+		{Name: "a.go", Content: []byte("package a"), Branches: mainBranches},
+		{Name: "a.go", Content: []byte("package a"), Branches: []string{"release", "main"}},
+		{Name: "a.go", Content: []byte("package a // dev"), Branches: []string{"dev"}},
+		{Name: "b.go", Content: []byte("package a"), Branches: []string{"dev"}},
+		{Name: "a.go", Content: []byte("package a"), Branches: []string{"dev"}},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, d := range b.todo {
+		got = append(got, fmt.Sprintf("%s %q %v", d.Name, d.Content, d.Branches))
+	}
+	want := []string{
+		`a.go "package a" [main release dev]`,
+		`a.go "package a // dev" [dev]`,
+		`b.go "package a" [dev]`,
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("-want, +got:\n%s", d)
+	}
+	if len(mainBranches) != 1 {
+		t.Fatalf("modified the branches of the caller: %v", mainBranches)
+	}
+	if b.size != 3*len("a.go")+len("package a")*2+len("package a // dev") {
+		t.Fatalf("merged documents should not count towards the shard size, got %d", b.size)
+	}
+}
+
+func TestBuilder_Fork(t *testing.T) {
+	dir := t.TempDir()
+	branches := []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}}
+
+	build := func(repo zoekt.Repository, files map[string]string) {
+		t.Helper()
+		opts := Options{IndexDir: dir, RepositoryDescription: repo, DisableCTags: true}
+		opts.SetDefaults()
+		b, err := NewBuilder(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := b.Add(zoekt.Document{Name: name, Content: []byte(content), Branches: []string{"main"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open := func(name string) (zoekt.Searcher, *zoekt.Repository) {
+		t.Helper()
+		o := Options{IndexDir: dir, RepositoryDescription: zoekt.Repository{Name: name}}
+		shards := o.FindAllShards()
+		if len(shards) != 1 {
+			t.Fatalf("got shards %v for %s", shards, name)
+		}
+		repos, _, err := zoekt.ReadMetadataPath(shards[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(shards[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		iFile, err := zoekt.NewIndexFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := zoekt.NewSearcher(iFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s, repos[0]
+	}
+
+	files := func(s zoekt.Searcher) []string {
+		t.Helper()
+		sr, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fm := range sr.Files {
+			names = append(names, fm.Repository+":"+fm.FileName)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	build(zoekt.Repository{TenantID: 1, ID: 10, Name: "parent", Branches: branches}, map[string]string{
+		"same.go":    "package same",
+		"changed.go": "package parent",
+		"removed.go": "package removed",
+	})
+	build(zoekt.Repository{TenantID: 1, ID: 11, Name: "fork", ForkOf: "parent", Branches: branches}, map[string]string{
+		"same.go":    "package same",
+		"changed.go": "package fork",
+		"added.go":   "package added",
+	})
+
+	forkShard, fork := open("fork")
+	if got, want := files(forkShard), []string{"fork:added.go", "fork:changed.go"}; !cmp.Equal(got, want) {
+		t.Fatalf("fork shard: got %v, want %v", got, want)
+	}
+	wantTombstones := map[string]struct{}{"changed.go": {}, "removed.go": {}}
+	if d := cmp.Diff(wantTombstones, fork.ForkTombstones); d != "" {
+		t.Fatalf("ForkTombstones (-want +got):\n%s", d)
+	}
+	if fork.ForkOfID != 10 {
+		t.Fatalf("got ForkOfID %d, want the ID of the parent", fork.ForkOfID)
+	}
+
+	parentShard, _ := open("parent")
+	view, ok := zoekt.NewForkSearcher(parentShard, fork)
+	if !ok {
+		t.Fatal("NewForkSearcher failed")
+	}
+	if got, want := files(view), []string{"fork:same.go"}; !cmp.Equal(got, want) {
+		t.Fatalf("fork view: got %v, want %v", got, want)
+	}
+
+	// Forks never inherit the files of another tenant.
+	build(zoekt.Repository{TenantID: 2, ID: 12, Name: "other", ForkOf: "parent", Branches: branches}, map[string]string{
+		"same.go": "package same",
+	})
+	otherShard, other := open("other")
+	if other.ForkOf != "" || other.ForkOfID != 0 {
+		t.Fatalf("fork of another tenant's repository was indexed as a fork of %q (%d)", other.ForkOf, other.ForkOfID)
+	}
+	if got, want := files(otherShard), []string{"other:same.go"}; !cmp.Equal(got, want) {
+		t.Fatalf("other shard: got %v, want %v", got, want)
+	}
+	spoofed := *fork
+	spoofed.TenantID = 2
+	if _, ok := zoekt.NewForkSearcher(parentShard, &spoofed); ok {
+		t.Fatal("NewForkSearcher returned a view on the parent of another tenant")
+	}
+
+	// Parents are matched by ID, not by name.
+	renamed := *fork
+	renamed.ForkOf = "renamed"
+	if _, ok := zoekt.NewForkSearcher(parentShard, &renamed); !ok {
+		t.Fatal("NewForkSearcher didn't find the parent by ID")
+	}
+	renamed.ForkOf, renamed.ForkOfID = "parent", 99
+	if _, ok := zoekt.NewForkSearcher(parentShard, &renamed); ok {
+		t.Fatal("NewForkSearcher matched a parent with another ID")
+	}
+}
+
+func TestBuilder_ShardByDirectory(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{Name: "monorepo"},
+		ShardByDirectory:      true,
+		DisableCTags:          true,
+	}
+	opts.SetDefaults()
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/1.go", "b/2.go", "a/sub/3.go", "README.md"} {
+		if err := b.AddFile(name, []byte("package "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := opts.FindAllShards()
+	if len(shards) != 3 {
+		t.Fatalf("got shards %v, want 3", shards)
+	}
+	m, err := zoekt.ReadShardManifest(zoekt.ShardManifestPath(shards[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	for _, shard := range shards {
+		d, ok := m.Directories[filepath.Base(shard)]
+		if !ok {
+			t.Fatalf("no directory for %s in manifest %+v", shard, m)
+		}
+
+		f, err := os.Open(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iFile, err := zoekt.NewIndexFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := zoekt.NewSearcher(iFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sr, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+		s.Close()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fm := range sr.Files {
+			got[d] = append(got[d], fm.FileName)
+		}
+		sort.Strings(got[d])
+	}
+
+	want := map[string][]string{
+		"":  {"README.md"},
+		"a": {"a/1.go", "a/sub/3.go"},
+		"b": {"b/2.go"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("files by directory (-want +got):\n%s", d)
+	}
+}
+
 func TestOptions_FindAllShards(t *testing.T) {
 	type simpleShard struct {
 		Repository zoekt.Repository
@@ -1000,3 +1351,30 @@ func TestIgnoreSizeMax(t *testing.T) {
 		})
 	}
 }
+
+func TestLanguageOverride(t *testing.T) {
+	o := Options{
+		LanguageOverrides: map[string]string{
+			"**/*.tpl":           "html",
+			"templates/**/*.tpl": "Go Template",
+			"BUILD":              "starlark",
+		},
+	}
+
+	for _, tc := range []struct {
+		path   string
+		want   string
+		wantOk bool
+	}{
+		{path: "web/index.tpl", want: "HTML", wantOk: true},
+		{path: "templates/mail/welcome.tpl", want: "Go Template", wantOk: true},
+		{path: "BUILD", want: "Starlark", wantOk: true},
+		{path: "pkg/BUILD", wantOk: false},
+		{path: "main.go", wantOk: false},
+	} {
+		got, ok := o.LanguageOverride(tc.path)
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("LanguageOverride(%q) = %q, %t, want %q, %t", tc.path, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
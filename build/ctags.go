@@ -41,7 +41,60 @@ func normalizeLanguage(filetype string) string {
 	return normalized
 }
 
-func parseSymbols(todo []*zoekt.Document, languageMap ctags.LanguageMap, parserBins ctags.ParserBinMap) error {
+// parseSymbols sets the symbols of the documents in todo, using up to workers
+// ctags parsers in parallel.
+func parseSymbols(todo []*zoekt.Document, languageMap ctags.LanguageMap, parserBins ctags.ParserBinMap, workers int) error {
+	if workers <= 1 || len(todo) < 2 {
+		i := 0
+		return parseSymbolsWorker(func() (*zoekt.Document, bool) {
+			if i == len(todo) {
+				return nil, false
+			}
+			i++
+			return todo[i-1], true
+		}, languageMap, parserBins)
+	}
+
+	docs := make(chan *zoekt.Document)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range min(workers, len(todo)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			next := func() (*zoekt.Document, bool) {
+				doc, ok := <-docs
+				return doc, ok
+			}
+			if err := parseSymbolsWorker(next, languageMap, parserBins); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					close(stop)
+				})
+			}
+		}()
+	}
+
+feed:
+	for _, doc := range todo {
+		select {
+		case docs <- doc:
+		case <-stop:
+			break feed
+		}
+	}
+	close(docs)
+	wg.Wait()
+	return firstErr
+}
+
+// parseSymbolsWorker sets the symbols of the documents returned by next, with
+// its own ctags parser.
+func parseSymbolsWorker(next func() (*zoekt.Document, bool), languageMap ctags.LanguageMap, parserBins ctags.ParserBinMap) error {
 	monitor := newMonitor()
 	defer monitor.Stop()
 
@@ -50,42 +103,48 @@ func parseSymbols(todo []*zoekt.Document, languageMap ctags.LanguageMap, parserB
 	parser := ctags.NewCTagsParser(parserBins)
 	defer parser.Close()
 
-	for _, doc := range todo {
-		if len(doc.Content) == 0 || doc.Symbols != nil {
-			continue
+	for doc, ok := next(); ok; doc, ok = next() {
+		if err := parseDocumentSymbols(doc, languageMap, &parser, monitor, &tagsToSections); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		zoekt.DetermineLanguageIfUnknown(doc)
+func parseDocumentSymbols(doc *zoekt.Document, languageMap ctags.LanguageMap, parser *ctags.CTagsParser, monitor *monitor, tagsToSections *tagsToSections) error {
+	if len(doc.Content) == 0 || doc.Symbols != nil {
+		return nil
+	}
 
-		parserType := languageMap[normalizeLanguage(doc.Language)]
-		if parserType == ctags.NoCTags {
-			continue
-		}
+	zoekt.DetermineLanguageIfUnknown(doc)
 
-		// If the parser type is unknown, default to universal-ctags
-		if parserType == ctags.UnknownCTags {
-			parserType = ctags.UniversalCTags
-		}
+	parserType := languageMap[normalizeLanguage(doc.Language)]
+	if parserType == ctags.NoCTags {
+		return nil
+	}
 
-		monitor.BeginParsing(doc)
-		es, err := parser.Parse(doc.Name, doc.Content, parserType)
-		monitor.EndParsing(es)
+	// If the parser type is unknown, default to universal-ctags
+	if parserType == ctags.UnknownCTags {
+		parserType = ctags.UniversalCTags
+	}
 
-		if err != nil {
-			return err
-		}
-		if len(es) == 0 {
-			continue
-		}
+	monitor.BeginParsing(doc)
+	es, err := parser.Parse(doc.Name, doc.Content, parserType)
+	monitor.EndParsing(es)
 
-		symOffsets, symMetaData, err := tagsToSections.Convert(doc.Content, es)
-		if err != nil {
-			return fmt.Errorf("%s: %v", doc.Name, err)
-		}
-		doc.Symbols = symOffsets
-		doc.SymbolsMetaData = symMetaData
+	if err != nil {
+		return err
+	}
+	if len(es) == 0 {
+		return nil
 	}
 
+	symOffsets, symMetaData, err := tagsToSections.Convert(doc.Content, es)
+	if err != nil {
+		return fmt.Errorf("%s: %v", doc.Name, err)
+	}
+	doc.Symbols = symOffsets
+	doc.SymbolsMetaData = symMetaData
 	return nil
 }
 
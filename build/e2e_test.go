@@ -383,6 +383,73 @@ func TestLargeFileOption(t *testing.T) {
 	defer ss.Close()
 }
 
+func TestPathPatterns(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir:        dir,
+		ExcludePatterns: []string{"**/node_modules/**", "regex:\\.pb\\.go$"},
+		IncludePatterns: []string{"**/*.go", "**/*.js"},
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder: %v", err)
+	}
+
+	for _, name := range []string{
+		"main.go",
+		"web/app.js",
+		"web/node_modules/lib/index.js",
+		"api/api.pb.go",
+		"README.md",
+	} {
+		if err := b.AddFile(name, []byte("needle")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Finish(); err != nil {
+		t.Errorf("Finish: %v", err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatalf("NewDirectorySearcher(%s): %v", dir, err)
+	}
+	defer ss.Close()
+
+	result, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range result.Files {
+		got = append(got, f.FileName)
+	}
+	sort.Strings(got)
+	if want := []string{"main.go", "web/app.js"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
+
+func TestNewBuilderInvalidPathPattern(t *testing.T) {
+	_, err := NewBuilder(Options{
+		IndexDir:        t.TempDir(),
+		ExcludePatterns: []string{"regex:("},
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid regular expression")
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	dir := t.TempDir()
 
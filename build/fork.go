@@ -0,0 +1,152 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc64"
+	"math"
+	"os"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// forkParent holds the files of the parent of a fork, see
+// zoekt.Repository.ForkOf. Files of the fork with the same content as in the
+// parent aren't indexed, but inherited from the index of the parent.
+type forkParent struct {
+	// checksums maps the names of the files of the parent to the checksums
+	// of their content.
+	checksums map[string][]byte
+
+	// inherited are the files the fork inherits from the parent.
+	inherited map[string]struct{}
+
+	// id is the ID of the parent, see zoekt.Repository.ForkOfID.
+	id uint32
+}
+
+// loadForkParent reads the file checksums of the parent of the fork from the
+// shards of the parent in o.IndexDir. The parent must be indexed with the same
+// branches as the fork, and belong to the same tenant, see
+// zoekt.IsForkParent.
+func (o *Options) loadForkParent() (*forkParent, error) {
+	parentOpts := Options{
+		IndexDir: o.IndexDir,
+		RepositoryDescription: zoekt.Repository{
+			TenantID: o.RepositoryDescription.TenantID,
+			ID:       o.RepositoryDescription.ForkOfID,
+			Name:     o.RepositoryDescription.ForkOf,
+		},
+	}
+
+	p := &forkParent{
+		checksums: map[string][]byte{},
+		inherited: map[string]struct{}{},
+	}
+	found := false
+	for _, shard := range parentOpts.FindAllShards() {
+		ok, err := p.readShard(shard, &o.RepositoryDescription)
+		if err != nil {
+			return nil, fmt.Errorf("reading parent shard %q: %w", shard, err)
+		}
+		found = found || ok
+	}
+	if !found {
+		return nil, fmt.Errorf("parent %q is not indexed with branches %v", o.RepositoryDescription.ForkOf, o.RepositoryDescription.Branches)
+	}
+	return p, nil
+}
+
+// readShard adds the files of the parent of fork in shard to p. It returns
+// false if shard doesn't hold the parent with the branches of fork.
+func (p *forkParent) readShard(shard string, fork *zoekt.Repository) (bool, error) {
+	f, err := os.Open(shard)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	iFile, err := zoekt.NewIndexFile(f)
+	if err != nil {
+		return false, err
+	}
+	repos, _, err := zoekt.ReadMetadata(iFile)
+	if err != nil {
+		iFile.Close()
+		return false, err
+	}
+	var parent *zoekt.Repository
+	for _, r := range repos {
+		if zoekt.IsForkParent(r, fork) && !r.Tombstone && BranchNamesEqual(r.Branches, fork.Branches) {
+			parent = r
+		}
+	}
+	if parent == nil {
+		iFile.Close()
+		return false, nil
+	}
+	p.id = parent.ID
+
+	searcher, err := zoekt.NewSearcher(iFile)
+	if err != nil {
+		iFile.Close()
+		return false, err
+	}
+	defer searcher.Close()
+
+	// Tombstoned files of the parent aren't returned.
+	var q query.Q = query.NewRepoSet(parent.Name)
+	if parent.ID != 0 {
+		q = query.NewRepoIDs(parent.ID)
+	}
+	// The parent belongs to the tenant of the fork, which the index
+	// builder doesn't act as.
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+	sr, err := searcher.Search(ctx, q, &zoekt.SearchOptions{
+		ShardMaxMatchCount: math.MaxInt32,
+		TotalMaxMatchCount: math.MaxInt32,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, fm := range sr.Files {
+		// The checksum points into the shard, which we close.
+		p.checksums[fm.FileName] = bytes.Clone(fm.Checksum)
+	}
+	return true, nil
+}
+
+// inherit returns true if doc has the same content as the file with the same
+// name in the parent. It records doc as inherited. Empty and skipped documents
+// are never inherited, since the index doesn't tell them apart.
+func (p *forkParent) inherit(doc *zoekt.Document) bool {
+	if doc.SkipReason != "" || len(doc.Content) == 0 {
+		return false
+	}
+	want, ok := p.checksums[doc.Name]
+	if !ok {
+		return false
+	}
+	// Same checksum as zoekt.IndexBuilder.
+	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
+	hasher.Write(doc.Content)
+	if !bytes.Equal(hasher.Sum(nil), want) {
+		return false
+	}
+	p.inherited[doc.Name] = struct{}{}
+	return true
+}
+
+// tombstones returns the files of the parent which the fork doesn't inherit.
+func (p *forkParent) tombstones() map[string]struct{} {
+	t := make(map[string]struct{}, len(p.checksums)-len(p.inherited))
+	for name := range p.checksums {
+		if _, ok := p.inherited[name]; !ok {
+			t[name] = struct{}{}
+		}
+	}
+	return t
+}
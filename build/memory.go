@@ -0,0 +1,151 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/ctags"
+)
+
+// InMemoryBuilder builds an index which is only held in memory. It lets Go
+// programs embed zoekt to search small corpora, eg. documentation or
+// configuration, without managing shard files.
+//
+// Documents are prepared like with Builder: large and binary files are
+// skipped, the path filters and language overrides of the options apply, and
+// documents are ranked before they are indexed. The index is a single shard,
+// so ShardMax and the options about the index directory don't apply.
+type InMemoryBuilder struct {
+	opts       Options
+	docChecker zoekt.DocChecker
+	pathFilter *pathFilter
+	parserBins ctags.ParserBinMap
+
+	todo     []*zoekt.Document
+	finished bool
+}
+
+// defaultInMemoryName is the repository name of in-memory indexes if the
+// options don't name it.
+const defaultInMemoryName = "memory"
+
+// NewInMemoryBuilder returns a builder for an in-memory index. The zero
+// Options are valid. Symbols are only extracted if opts.CTagsPath is set.
+func NewInMemoryBuilder(opts Options) (*InMemoryBuilder, error) {
+	// SetDefaults looks for ctags in the PATH. Embedders should get the same
+	// index regardless of the machine they run on, so they opt in to ctags.
+	if opts.CTagsPath == "" && opts.ScipCTagsPath == "" {
+		opts.DisableCTags = true
+	}
+	opts.SetDefaults()
+	if opts.RepositoryDescription.Name == "" {
+		opts.RepositoryDescription.Name = defaultInMemoryName
+	}
+
+	pathFilter, err := newPathFilter(opts.ExcludePatterns, opts.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &InMemoryBuilder{
+		opts:       opts,
+		pathFilter: pathFilter,
+	}
+	if !opts.DisableCTags {
+		b.parserBins, err = ctags.NewParserBinMap(opts.CTagsPath, opts.ScipCTagsPath, opts.LanguageMap, opts.CTagsMustSucceed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// AddFile is a convenience wrapper for the Add method
+func (b *InMemoryBuilder) AddFile(name string, content []byte) error {
+	return b.Add(zoekt.Document{Name: name, Content: content})
+}
+
+// Add adds a document to the index.
+func (b *InMemoryBuilder) Add(doc zoekt.Document) error {
+	if b.finished {
+		return fmt.Errorf("build: Add called after Finish")
+	}
+	if !b.pathFilter.keep(doc.Name) {
+		return nil
+	}
+
+	b.opts.prepareDocument(&b.docChecker, &doc)
+	b.todo = append(b.todo, &doc)
+	return nil
+}
+
+// Finish indexes the documents and returns a searcher for them. The builder
+// can't be used afterwards. The searcher keeps the index in memory until it
+// is closed.
+func (b *InMemoryBuilder) Finish() (zoekt.Searcher, error) {
+	if b.finished {
+		return nil, fmt.Errorf("build: Finish called twice")
+	}
+	b.finished = true
+
+	todo := b.todo
+	b.todo = nil
+
+	if !b.opts.DisableCTags {
+		err := parseSymbols(todo, b.opts.LanguageMap, b.parserBins, b.opts.CTagsWorkers)
+		if b.opts.CTagsMustSucceed && err != nil {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("ignoring universal:%s or scip:%s error: %v", b.opts.CTagsPath, b.opts.ScipCTagsPath, err)
+		}
+	}
+
+	now := time.Now()
+	shardBuilder, err := b.opts.newShardBuilder(now, xid.NewWithTime(now).String())
+	if err != nil {
+		return nil, err
+	}
+
+	sortDocuments(todo)
+	for _, d := range todo {
+		if err := shardBuilder.Add(*d); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := shardBuilder.Write(&buf); err != nil {
+		return nil, err
+	}
+	return zoekt.NewSearcher(&memoryIndexFile{data: buf.Bytes()})
+}
+
+// memoryIndexFile is a zoekt.IndexFile backed by memory.
+type memoryIndexFile struct {
+	data []byte
+}
+
+func (f *memoryIndexFile) Read(off, sz uint32) ([]byte, error) {
+	if uint64(off)+uint64(sz) > uint64(len(f.data)) {
+		return nil, fmt.Errorf("read %d bytes at %d beyond end of index (%d bytes)", sz, off, len(f.data))
+	}
+	return f.data[off : off+sz], nil
+}
+
+func (f *memoryIndexFile) Size() (uint32, error) {
+	return uint32(len(f.data)), nil
+}
+
+func (f *memoryIndexFile) Close() {
+	f.data = nil
+}
+
+func (f *memoryIndexFile) Name() string {
+	return "memory"
+}
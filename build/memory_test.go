@@ -0,0 +1,75 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestInMemoryBuilder(t *testing.T) {
+	b, err := NewInMemoryBuilder(Options{
+		ExcludePatterns:   []string{"vendor/**"},
+		LanguageOverrides: map[string]string{"*.conf": "ini"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"docs/intro.md":   "# Needle\nsome docs\n",
+		"app.conf":        "needle = true\n",
+		"binary":          "needle\x00",
+		"vendor/lib.go":   "package needle\n",
+		"docs/unrelated":  "haystack\n",
+		"config/app.yaml": "key: needle\n",
+	} {
+		if err := b.AddFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := b.Finish(); err == nil {
+		t.Fatal("expected error for second Finish")
+	}
+	if err := b.AddFile("late", nil); err == nil {
+		t.Fatal("expected error for Add after Finish")
+	}
+
+	sr, err := s.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range sr.Files {
+		got[f.FileName] = f.Language
+		if f.Repository != defaultInMemoryName {
+			t.Errorf("got repository %q, want %q", f.Repository, defaultInMemoryName)
+		}
+	}
+	want := map[string]string{
+		"docs/intro.md":   "Markdown",
+		"app.conf":        "INI",
+		"config/app.yaml": "YAML",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	rl, err := s.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 || rl.Repos[0].Repository.Name != defaultInMemoryName {
+		t.Fatalf("got %+v, want a single repository", rl.Repos)
+	}
+}
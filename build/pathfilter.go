@@ -0,0 +1,80 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/grafana/regexp"
+)
+
+// regexPatternPrefix marks patterns in ExcludePatterns and IncludePatterns
+// which are regular expressions instead of globs.
+const regexPatternPrefix = "regex:"
+
+// pathFilter decides which documents are indexed, according to
+// Options.ExcludePatterns and Options.IncludePatterns.
+type pathFilter struct {
+	exclude []func(string) bool
+	include []func(string) bool
+}
+
+func newPathFilter(exclude, include []string) (*pathFilter, error) {
+	f := &pathFilter{}
+	for _, p := range exclude {
+		m, err := compilePathPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, m)
+	}
+	for _, p := range include {
+		m, err := compilePathPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, m)
+	}
+	return f, nil
+}
+
+// compilePathPattern returns a function which reports whether a path matches
+// pattern, which is either a doublestar glob or a regular expression
+// prefixed with "regex:".
+func compilePathPattern(pattern string) (func(string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexPatternPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("path pattern %q: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	// Like for LargeFiles, malformed globs don't match anything.
+	return func(name string) bool {
+		m, _ := doublestar.PathMatch(pattern, name)
+		return m
+	}, nil
+}
+
+// keep returns true if the document name should be indexed: it must not match
+// an exclude pattern, and it must match an include pattern if there are any.
+func (f *pathFilter) keep(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, m := range f.exclude {
+		if m(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, m := range f.include {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,466 @@
+// Package client is a Go client for zoekt-webserver. A Client implements
+// zoekt.Streamer on top of the gRPC or the JSON API of one or more
+// webservers. It balances requests across the webservers and retries
+// requests which failed because a webserver was unavailable. A Fanout
+// searches several sets of shards, each served by replicas behind a Client,
+// and merges their results.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/sourcegraph/zoekt"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Options configures a Client.
+type Options struct {
+	// Endpoints are the webservers to send requests to. Endpoints with an
+	// http:// or https:// scheme use the JSON API, eg.
+	// "http://zoekt-webserver:6070". All others are gRPC targets, eg.
+	// "zoekt-webserver:6070".
+	Endpoints []string
+
+	// HTTPClient is used for the JSON API. Its transport pools connections
+	// to the endpoints. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// DialOptions are added to the options for dialing gRPC endpoints. By
+	// default, connections are not encrypted.
+	DialOptions []grpc.DialOption
+
+	// Token, if set, is sent as bearer token with every request, see the
+	// -auth_tokens flag of zoekt-webserver. Use it with TLS only, ie.
+	// https:// endpoints or DialOptions with transport credentials.
+	Token string
+
+	// Compress, if set, compresses gRPC requests and responses with gzip.
+	// It saves bandwidth on large results, eg. with file contents, at the
+	// cost of CPU on both ends.
+	Compress bool
+
+	// MaxAttempts is the maximum number of times a request is sent before
+	// giving up. Defaults to 3.
+	MaxAttempts int
+
+	// Backoff is the initial wait between attempts. It doubles with every
+	// attempt up to MaxBackoff. Defaults to 100ms and 5s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// HedgeDelay, if set, sends a request which hasn't been answered after
+	// this long to a second endpoint as well, and takes whichever answer
+	// comes first. Streams are hedged until they send their first result.
+	// It cuts the tail latency of replicas which are slow for a while, eg.
+	// during garbage collection or while loading shards, at the cost of
+	// the extra requests. Set it to about the p95 latency of searches.
+	HedgeDelay time.Duration
+}
+
+// backend sends requests to a single webserver.
+type backend interface {
+	search(ctx context.Context, req *proto.SearchRequest) (*proto.SearchResponse, error)
+	// streamSearch calls f for every response of the stream.
+	streamSearch(ctx context.Context, req *proto.StreamSearchRequest, f func(*proto.StreamSearchResponse)) error
+	list(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error)
+	getFile(ctx context.Context, req *proto.GetFileRequest) (*proto.GetFileResponse, error)
+	close() error
+	String() string
+}
+
+type endpoint struct {
+	backend
+	// downUntil is the time in unix nanoseconds until which the endpoint
+	// is skipped, because requests to it failed.
+	downUntil atomic.Int64
+}
+
+// Client searches one or more zoekt webservers. It is safe for concurrent
+// use.
+type Client struct {
+	opts      Options
+	endpoints []*endpoint
+	next      atomic.Uint64
+}
+
+var _ zoekt.Streamer = (*Client)(nil)
+
+// New returns a client for the webservers in opts.Endpoints. gRPC
+// connections are established lazily, so New doesn't fail if a webserver is
+// down.
+func New(opts Options) (*Client, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, errors.New("client: no endpoints")
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.Backoff {
+		opts.MaxBackoff = max(5*time.Second, opts.Backoff)
+	}
+
+	c := &Client{opts: opts}
+	for _, addr := range opts.Endpoints {
+		var b backend
+		if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+			b = newJSONBackend(addr, opts.HTTPClient, opts.Token)
+		} else {
+			var err error
+			if b, err = newGRPCBackend(addr, opts); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("client: %s: %w", addr, err)
+			}
+		}
+		c.endpoints = append(c.endpoints, &endpoint{backend: b})
+	}
+	return c, nil
+}
+
+// Search implements zoekt.Searcher.
+func (c *Client) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	pq, err := queryToProto(q)
+	if err != nil {
+		return nil, err
+	}
+	req := &proto.SearchRequest{Query: pq, Opts: searchOptions(opts).ToProto()}
+
+	resp, err := call(ctx, c, func(ctx context.Context, b backend) (*proto.SearchResponse, error) {
+		return b.search(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.SearchResultFromProto(resp, nil, nil), nil
+}
+
+// StreamSearch implements zoekt.Streamer. Requests are only retried until
+// the first result was sent, so sender never sees results twice.
+func (c *Client) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	pq, err := queryToProto(q)
+	if err != nil {
+		return err
+	}
+	req := &proto.StreamSearchRequest{
+		Request: &proto.SearchRequest{Query: pq, Opts: searchOptions(opts).ToProto()},
+		// The gRPC backend joins the parts of file matches too large for a
+		// message. The JSON API never splits them.
+		SplitFileMatches: true,
+	}
+
+	sent := false
+	return c.do(ctx, func(b backend) error {
+		err := c.hedgeStream(ctx, b, req, func(resp *proto.StreamSearchResponse) {
+			sent = true
+			sender.Send(zoekt.SearchResultFromStreamProto(resp, nil, nil))
+		})
+		if err != nil && sent {
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+// List implements zoekt.Searcher.
+func (c *Client) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	pq, err := queryToProto(q)
+	if err != nil {
+		return nil, err
+	}
+	req := &proto.ListRequest{Query: pq, Opts: opts.ToProto()}
+
+	resp, err := call(ctx, c, func(ctx context.Context, b backend) (*proto.ListResponse, error) {
+		return b.list(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.RepoListFromProto(resp), nil
+}
+
+// GetFile returns the indexed content of a file, see zoekt.GetFile.
+func (c *Client) GetFile(ctx context.Context, req zoekt.FileRequest) (*zoekt.File, error) {
+	protoReq := req.ToProto()
+
+	resp, err := call(ctx, c, func(ctx context.Context, b backend) (*proto.GetFileResponse, error) {
+		return b.getFile(ctx, protoReq)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.FileFromProto(resp), nil
+}
+
+// queryToProto converts q for the wire. query.QToProto panics on the query
+// nodes which only exist inside of a searcher, which callers of a client
+// shouldn't be able to trigger.
+func queryToProto(q query.Q) (pq *proto.Q, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("client: %v", r)
+		}
+	}()
+	return query.QToProto(q), nil
+}
+
+// Close closes the connections to all endpoints.
+func (c *Client) Close() {
+	for _, e := range c.endpoints {
+		_ = e.close()
+	}
+}
+
+func (c *Client) String() string {
+	var names []string
+	for _, e := range c.endpoints {
+		names = append(names, e.String())
+	}
+	return fmt.Sprintf("client(%s)", strings.Join(names, ", "))
+}
+
+// searchOptions returns opts, or the default options if opts is nil.
+func searchOptions(opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	if opts == nil {
+		return &zoekt.SearchOptions{}
+	}
+	return opts
+}
+
+// pick returns the next endpoint round robin, skipping endpoints which
+// recently failed. If all endpoints failed, it tries them anyway.
+func (c *Client) pick() *endpoint {
+	start := c.next.Add(1) - 1
+	now := time.Now().UnixNano()
+	for i := range c.endpoints {
+		e := c.endpoints[(start+uint64(i))%uint64(len(c.endpoints))]
+		if e.downUntil.Load() <= now {
+			return e
+		}
+	}
+	return c.endpoints[start%uint64(len(c.endpoints))]
+}
+
+// do calls f with an endpoint until it succeeds, fails with an error that
+// isn't retryable or runs out of attempts. Every retry goes to another
+// endpoint, if possible.
+func (c *Client) do(ctx context.Context, f func(backend) error) error {
+	backoff := c.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		e := c.pick()
+		err := f(e.backend)
+		if err == nil {
+			return nil
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.error
+		}
+		if ctx.Err() != nil || !retryable(err) || attempt >= c.opts.MaxAttempts {
+			return err
+		}
+		e.downUntil.Store(time.Now().Add(c.opts.MaxBackoff).UnixNano())
+
+		// Full jitter, so clients don't retry in lockstep.
+		t := time.NewTimer(rand.N(backoff) + 1)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+	}
+}
+
+// call is do for requests with a single response, which it hedges, see
+// Options.HedgeDelay.
+func call[T any](ctx context.Context, c *Client, f func(context.Context, backend) (T, error)) (T, error) {
+	var resp T
+	err := c.do(ctx, func(b backend) (err error) {
+		resp, err = hedge(ctx, c, b, f)
+		return err
+	})
+	return resp, err
+}
+
+// hedge calls f with b. If that takes longer than Options.HedgeDelay, it
+// also calls f with another endpoint. It returns the first answer which
+// isn't an error, or the first error once both failed.
+func hedge[T any](ctx context.Context, c *Client, b backend, f func(context.Context, backend) (T, error)) (T, error) {
+	if c.opts.HedgeDelay <= 0 || len(c.endpoints) < 2 {
+		return f(ctx, b)
+	}
+
+	// Canceling ctx on return stops the request which lost.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		resp T
+		err  error
+	}
+	answers := make(chan answer, 2)
+	send := func(b backend) {
+		go func() {
+			resp, err := f(ctx, b)
+			answers <- answer{resp, err}
+		}()
+	}
+
+	timer := time.NewTimer(c.opts.HedgeDelay)
+	defer timer.Stop()
+
+	send(b)
+	pending := 1
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if other := c.pickOther(b); other != nil {
+				send(other)
+				pending++
+			}
+		case a := <-answers:
+			pending--
+			if a.err == nil {
+				return a.resp, nil
+			}
+			if firstErr == nil {
+				firstErr = a.err
+			}
+		}
+	}
+	var zero T
+	return zero, firstErr
+}
+
+// hedgeStream is hedge for streams. The stream which sends the first
+// response wins, and the other one is canceled, so f only sees the responses
+// of one stream.
+func (c *Client) hedgeStream(ctx context.Context, b backend, req *proto.StreamSearchRequest, f func(*proto.StreamSearchResponse)) error {
+	if c.opts.HedgeDelay <= 0 || len(c.endpoints) < 2 {
+		return b.streamSearch(ctx, req, f)
+	}
+
+	var (
+		mu      sync.Mutex
+		winner  = -1
+		cancels []context.CancelFunc
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	type done struct {
+		stream int
+		err    error
+	}
+	dones := make(chan done, 2)
+	start := func(b backend) {
+		streamCtx, cancel := context.WithCancel(ctx)
+		mu.Lock()
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		mu.Unlock()
+
+		go func() {
+			err := b.streamSearch(streamCtx, req, func(resp *proto.StreamSearchResponse) {
+				mu.Lock()
+				if winner == -1 {
+					winner = i
+					for j, cancel := range cancels {
+						if j != i {
+							cancel()
+						}
+					}
+				}
+				won := winner == i
+				mu.Unlock()
+				if won {
+					f(resp)
+				}
+			})
+			dones <- done{i, err}
+		}()
+	}
+
+	timer := time.NewTimer(c.opts.HedgeDelay)
+	defer timer.Stop()
+
+	start(b)
+	pending := 1
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			mu.Lock()
+			decided := winner != -1
+			mu.Unlock()
+			if decided {
+				continue
+			}
+			if other := c.pickOther(b); other != nil {
+				start(other)
+				pending++
+			}
+		case d := <-dones:
+			pending--
+			mu.Lock()
+			if winner == -1 && d.err == nil {
+				// The stream ended without responses.
+				winner = d.stream
+			}
+			won := winner == d.stream
+			decided := winner != -1
+			mu.Unlock()
+			if won {
+				return d.err
+			}
+			if !decided && firstErr == nil {
+				firstErr = d.err
+			}
+		}
+	}
+	return firstErr
+}
+
+// pickOther returns an endpoint other than b to hedge a request to, or nil
+// if there is none.
+func (c *Client) pickOther(b backend) backend {
+	for range c.endpoints {
+		if e := c.pick(); e.backend != b {
+			return e.backend
+		}
+	}
+	return nil
+}
+
+// permanentError marks errors which must not be retried.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
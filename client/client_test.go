@@ -0,0 +1,489 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/cmd/zoekt-webserver/grpc/server"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	zjson "github.com/sourcegraph/zoekt/json"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// fakeStreamer returns the same results for every query and streams them one
+// file at a time.
+type fakeStreamer struct {
+	result *zoekt.SearchResult
+	list   *zoekt.RepoList
+
+	// err, if set, fails all searches.
+	err error
+
+	// delay, if set, delays all searches.
+	delay time.Duration
+}
+
+func (s *fakeStreamer) wait(ctx context.Context) error {
+	if s.delay == 0 {
+		return s.err
+	}
+	select {
+	case <-time.After(s.delay):
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fakeStreamer) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.result, nil
+}
+
+func (s *fakeStreamer) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	for _, f := range s.result.Files {
+		sender.Send(&zoekt.SearchResult{Files: []zoekt.FileMatch{f}})
+	}
+	sender.Send(&zoekt.SearchResult{Stats: s.result.Stats})
+	return nil
+}
+
+func (s *fakeStreamer) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.list, nil
+}
+
+func (*fakeStreamer) Close()         {}
+func (*fakeStreamer) String() string { return "fakeStreamer" }
+
+func newFakeStreamer() *fakeStreamer {
+	content := []byte("package a\n")
+	checksum := binary.BigEndian.AppendUint64(nil, crc64.Checksum(content, crc64.MakeTable(crc64.ISO)))
+	return &fakeStreamer{
+		result: &zoekt.SearchResult{
+			Stats: zoekt.Stats{FileCount: 2, MatchCount: 2},
+			Files: []zoekt.FileMatch{
+				{Repository: "foo/bar", FileName: "a.go", Content: content, Checksum: checksum},
+				{Repository: "foo/bar", FileName: "b.go"},
+			},
+		},
+		list: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{ID: 1, Name: "foo/bar"}}},
+		},
+	}
+}
+
+func grpcServer(t *testing.T, s zoekt.Streamer, opts ...grpc.ServerOption) string {
+	gs := grpc.NewServer(opts...)
+	proto.RegisterWebserverServiceServer(gs, server.NewServer(s))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	t.Cleanup(func() {
+		ts.Close()
+		gs.Stop()
+	})
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func jsonServer(t *testing.T, s zoekt.Streamer) string {
+	ts := httptest.NewServer(http.StripPrefix("/api", zjson.JSONServer(s)))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestClient(t *testing.T) {
+	s := newFakeStreamer()
+
+	for name, endpoint := range map[string]string{
+		"grpc":      grpcServer(t, s),
+		"grpc-gzip": grpcServer(t, s),
+		"json":      jsonServer(t, s),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(Options{Endpoints: []string{endpoint}, Compress: name == "grpc-gzip"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			ctx := context.Background()
+			q := &query.Substring{Pattern: "foo"}
+
+			sr, err := c.Search(ctx, q, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sr.Files) != 2 || sr.Files[1].FileName != "b.go" || sr.Stats.MatchCount != 2 {
+				t.Fatalf("unexpected search result %+v", sr)
+			}
+
+			var files []string
+			var stats zoekt.Stats
+			err = c.StreamSearch(ctx, q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+				for _, f := range sr.Files {
+					files = append(files, f.FileName)
+				}
+				stats.Add(sr.Stats)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 2 || files[0] != "a.go" || stats.FileCount != 2 {
+				t.Fatalf("unexpected stream %v %+v", files, stats)
+			}
+
+			rl, err := c.List(ctx, &query.Const{Value: true}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rl.Repos) != 1 || rl.Repos[0].Repository.Name != "foo/bar" {
+				t.Fatalf("unexpected repo list %+v", rl)
+			}
+
+			f, err := c.GetFile(ctx, zoekt.FileRequest{Repository: "foo/bar", Path: "a.go", Start: 8})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(f.Content) != "a\n" || f.Size != 10 || f.End != 10 {
+				t.Fatalf("unexpected file %+v", f)
+			}
+		})
+	}
+}
+
+func TestClientSearchError(t *testing.T) {
+	s := newFakeStreamer()
+	s.err = &zoekt.SearchError{Reason: zoekt.FlushReasonTimeout, Err: context.DeadlineExceeded}
+
+	for name, endpoint := range map[string]string{
+		"grpc": grpcServer(t, s),
+		"json": jsonServer(t, s),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(Options{Endpoints: []string{endpoint}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			check := func(err error) {
+				t.Helper()
+				var searchErr *zoekt.SearchError
+				if !errors.As(err, &searchErr) || searchErr.Reason != zoekt.FlushReasonTimeout {
+					t.Fatalf("got error %v, want a search error with reason %s", err, zoekt.FlushReasonTimeout)
+				}
+				if retryable(err) {
+					t.Fatalf("timeouts must not be retried")
+				}
+			}
+
+			ctx := context.Background()
+			q := &query.Substring{Pattern: "foo"}
+			_, err = c.Search(ctx, q, nil)
+			check(err)
+			check(c.StreamSearch(ctx, q, nil, zoekt.SenderFunc(func(*zoekt.SearchResult) {})))
+		})
+	}
+}
+
+// unknownQ is a query node without a proto form.
+type unknownQ struct{}
+
+func (unknownQ) String() string { return "unknown" }
+
+func TestClientUnknownQuery(t *testing.T) {
+	c, err := New(Options{Endpoints: []string{grpcServer(t, newFakeStreamer())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	q := query.NewAnd(&query.Substring{Pattern: "foo"}, unknownQ{})
+	if _, err := c.Search(ctx, q, nil); err == nil {
+		t.Error("Search succeeded for a query without a proto form")
+	}
+	if err := c.StreamSearch(ctx, q, nil, zoekt.SenderFunc(func(*zoekt.SearchResult) {})); err == nil {
+		t.Error("StreamSearch succeeded for a query without a proto form")
+	}
+	if _, err := c.List(ctx, q, nil); err == nil {
+		t.Error("List succeeded for a query without a proto form")
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	s := newFakeStreamer()
+	s.err = &zoekt.RateLimitError{Class: "batch", RetryAfter: 2 * time.Second}
+
+	for name, endpoint := range map[string]string{
+		"grpc": grpcServer(t, s),
+		"json": jsonServer(t, s),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(Options{Endpoints: []string{endpoint}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			check := func(err error) {
+				t.Helper()
+				var rateLimitErr *zoekt.RateLimitError
+				if !errors.As(err, &rateLimitErr) || *rateLimitErr != *s.err.(*zoekt.RateLimitError) {
+					t.Fatalf("got error %v, want %v", err, s.err)
+				}
+				if retryable(err) {
+					t.Fatalf("rate limited searches must not be retried")
+				}
+			}
+
+			ctx := context.Background()
+			q := &query.Substring{Pattern: "foo"}
+			_, err = c.Search(ctx, q, nil)
+			check(err)
+			check(c.StreamSearch(ctx, q, nil, zoekt.SenderFunc(func(*zoekt.SearchResult) {})))
+		})
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	s := newFakeStreamer()
+
+	var got atomic.Value
+	unary := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		got.Store(metadata.ValueFromIncomingContext(ctx, "authorization"))
+		return handler(ctx, req)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Values("Authorization"))
+		http.StripPrefix("/api", zjson.JSONServer(s)).ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	for name, endpoint := range map[string]string{
+		"grpc": grpcServer(t, s, grpc.UnaryInterceptor(unary)),
+		"json": ts.URL,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(Options{Endpoints: []string{endpoint}, Token: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			got.Store([]string(nil))
+			if _, err := c.Search(context.Background(), &query.Substring{Pattern: "foo"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if vals := got.Load().([]string); len(vals) != 1 || vals[0] != "Bearer secret" {
+				t.Fatalf("got authorization %q, want [\"Bearer secret\"]", vals)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var unavailable, bad atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailable.Add(1)
+		http.Error(w, `{"Error": "shutting down"}`, http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bad.Add(1)
+		http.Error(w, `{"Error": "invalid query"}`, http.StatusBadRequest)
+	}))
+	defer badRequest.Close()
+
+	up := jsonServer(t, newFakeStreamer())
+	ctx := context.Background()
+
+	c, err := New(Options{
+		Endpoints: []string{down.URL, up},
+		Backoff:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := c.Search(ctx, &query.Const{Value: true}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The endpoint is skipped after it failed once.
+	if unavailable.Load() != 1 {
+		t.Fatalf("got %d requests to the unavailable endpoint, want 1", unavailable.Load())
+	}
+
+	c, err = New(Options{
+		Endpoints: []string{badRequest.URL, up},
+		Backoff:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Search(ctx, &query.Const{Value: true}, nil)
+	if httpErr, ok := err.(*HTTPError); !ok || httpErr.StatusCode != http.StatusBadRequest || httpErr.Message != "invalid query" {
+		t.Fatalf("got error %v, want HTTP 400", err)
+	}
+	if bad.Load() != 1 {
+		t.Fatalf("got %d requests to the failing endpoint, want 1", bad.Load())
+	}
+
+	c, err = New(Options{
+		Endpoints:   []string{down.URL},
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	unavailable.Store(0)
+	if _, err := c.List(ctx, &query.Const{Value: true}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if unavailable.Load() != 2 {
+		t.Fatalf("got %d attempts, want 2", unavailable.Load())
+	}
+}
+
+func TestClientHedging(t *testing.T) {
+	slow := newFakeStreamer()
+	slow.delay = time.Minute
+	fast := newFakeStreamer()
+
+	for name, endpoints := range map[string][]string{
+		"grpc": {grpcServer(t, slow), grpcServer(t, fast)},
+		"json": {jsonServer(t, slow), jsonServer(t, fast)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(Options{Endpoints: endpoints, HedgeDelay: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			q := &query.Substring{Pattern: "foo"}
+
+			// Round robin sends every other request to the slow
+			// endpoint first.
+			for i := 0; i < 2; i++ {
+				sr, err := c.Search(ctx, q, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(sr.Files) != 2 {
+					t.Fatalf("unexpected search result %+v", sr)
+				}
+
+				var files []string
+				err = c.StreamSearch(ctx, q, nil, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+					for _, f := range sr.Files {
+						files = append(files, f.FileName)
+					}
+				}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(files) != 2 {
+					t.Fatalf("got files %v, want the files of a single stream", files)
+				}
+
+				if _, err := c.List(ctx, q, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFanout(t *testing.T) {
+	a := newFakeStreamer()
+	b := &fakeStreamer{
+		result: &zoekt.SearchResult{
+			Stats: zoekt.Stats{FileCount: 1, MatchCount: 1},
+			Files: []zoekt.FileMatch{{Repository: "foo/baz", FileName: "c.go", Score: 10}},
+		},
+		list: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{ID: 2, Name: "foo/baz"}}},
+		},
+	}
+	down := &fakeStreamer{err: errors.New("connection refused")}
+
+	ctx := context.Background()
+	q := &query.Substring{Pattern: "foo"}
+
+	f := NewFanout(a, b, down)
+	sr, err := f.Search(ctx, q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 3 || sr.Files[0].FileName != "c.go" || sr.Stats.MatchCount != 3 {
+		t.Fatalf("unexpected search result %+v", sr)
+	}
+	if sr.Stats.Crashes != 1 || sr.Stats.FlushReason != zoekt.FlushReasonShardUnavailable {
+		t.Fatalf("got %d crashes (%s), want 1 for the failed set", sr.Stats.Crashes, sr.Stats.FlushReason)
+	}
+
+	var stats zoekt.Stats
+	files := map[string]bool{}
+	err = f.StreamSearch(ctx, q, nil, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		for _, f := range sr.Files {
+			files[f.FileName] = true
+		}
+		stats.Add(sr.Stats)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || stats.FileCount != 3 || stats.Crashes != 1 {
+		t.Fatalf("unexpected stream %v %+v", files, stats)
+	}
+
+	rl, err := f.List(ctx, q, &zoekt.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 || rl.Crashes != 1 {
+		t.Fatalf("unexpected repo list %+v", rl)
+	}
+
+	// Searches fail if no set answers.
+	f = NewFanout(down, down)
+	if _, err := f.Search(ctx, q, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// retryable returns true if err means the request didn't reach a healthy
+// webserver, so sending it again, preferably elsewhere, may succeed.
+func retryable(err error) bool {
+	// Of the searches which fail for a reason, only those missing shards
+	// may succeed elsewhere.
+	var searchErr *zoekt.SearchError
+	if errors.As(err, &searchErr) {
+		return searchErr.Reason == zoekt.FlushReasonShardUnavailable
+	}
+
+	// Rate limits apply per webserver, so retrying elsewhere would evade
+	// them.
+	var rateLimitErr *zoekt.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.Aborted:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Fanout searches several sets of shards and merges their results. The sets
+// hold different repositories, like the index directories of webservers
+// which split the repositories between them. Each set is usually a Client
+// for the replicas serving it, whose Options.HedgeDelay hedges the sets which
+// straggle. It is safe for concurrent use.
+type Fanout struct {
+	sets []zoekt.Streamer
+}
+
+var _ zoekt.Streamer = (*Fanout)(nil)
+
+// NewFanout returns a Fanout over sets. Closing it closes the sets.
+func NewFanout(sets ...zoekt.Streamer) *Fanout {
+	return &Fanout{sets: sets}
+}
+
+// Search implements zoekt.Searcher. It returns an error if all sets failed.
+// If only some failed, the result holds the matches of the others and counts
+// a crash for each failed set, with zoekt.FlushReasonShardUnavailable.
+func (f *Fanout) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	start := time.Now()
+
+	results := make([]*zoekt.SearchResult, len(f.sets))
+	errs := f.each(func(i int, s zoekt.Streamer) (err error) {
+		results[i], err = s.Search(ctx, q, opts)
+		return err
+	})
+	crashes, err := failures(errs)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := &zoekt.SearchResult{}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		agg.Stats.Add(r.Stats)
+		agg.Files = append(agg.Files, r.Files...)
+		agg.Directories = append(agg.Directories, r.Directories...)
+		agg.RepoURLs = mergeMap(agg.RepoURLs, r.RepoURLs)
+		agg.LineFragments = mergeMap(agg.LineFragments, r.LineFragments)
+	}
+	agg.Stats.Add(unavailableStats(crashes))
+	agg.Files = zoekt.SortAndTruncateFiles(agg.Files, searchOptions(opts))
+	agg.Stats.Duration = time.Since(start)
+	return agg, nil
+}
+
+// StreamSearch implements zoekt.Streamer. It streams the results of all
+// sets as they arrive, so unlike Search it doesn't apply the display limits
+// of opts across sets. Failed sets are reported as in Search, in a last
+// result.
+func (f *Fanout) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	var mu sync.Mutex
+	send := zoekt.SenderFunc(func(r *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		sender.Send(r)
+	})
+
+	errs := f.each(func(_ int, s zoekt.Streamer) error {
+		return s.StreamSearch(ctx, q, opts, send)
+	})
+	crashes, err := failures(errs)
+	if err != nil {
+		return err
+	}
+	if crashes > 0 {
+		sender.Send(&zoekt.SearchResult{Stats: unavailableStats(crashes)})
+	}
+	return nil
+}
+
+// List implements zoekt.Searcher. Failed sets count as crashes, as in Search.
+func (f *Fanout) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	// Pages are cut from the merged list, so we need the first
+	// Offset+Limit repositories of every set.
+	setOpts := opts
+	if opts != nil && (opts.Offset > 0 || opts.Limit > 0) {
+		o := *opts
+		o.Offset = 0
+		if o.Limit > 0 {
+			o.Limit = opts.Offset + opts.Limit
+		}
+		setOpts = &o
+	}
+
+	lists := make([]*zoekt.RepoList, len(f.sets))
+	errs := f.each(func(i int, s zoekt.Streamer) (err error) {
+		lists[i], err = s.List(ctx, q, setOpts)
+		return err
+	})
+	crashes, err := failures(errs)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := &zoekt.RepoList{Crashes: crashes}
+	for _, rl := range lists {
+		if rl == nil {
+			continue
+		}
+		agg.Repos = append(agg.Repos, rl.Repos...)
+		if rl.ReposMap != nil {
+			if agg.ReposMap == nil {
+				agg.ReposMap = zoekt.ReposMap{}
+			}
+			maps.Copy(agg.ReposMap, rl.ReposMap)
+		}
+		agg.Crashes += rl.Crashes
+		agg.Stats.Add(&rl.Stats)
+		// The sets hold different repositories, so unlike shards their
+		// repositories add up.
+		agg.Stats.Repos += rl.Stats.Repos
+	}
+	opts.Apply(agg)
+	return agg, nil
+}
+
+// Close closes all sets.
+func (f *Fanout) Close() {
+	for _, s := range f.sets {
+		s.Close()
+	}
+}
+
+func (f *Fanout) String() string {
+	var names []string
+	for _, s := range f.sets {
+		names = append(names, s.String())
+	}
+	return fmt.Sprintf("fanout(%s)", strings.Join(names, ", "))
+}
+
+// each calls fn for every set concurrently and returns their errors.
+func (f *Fanout) each(fn func(int, zoekt.Streamer) error) []error {
+	errs := make([]error, len(f.sets))
+	var wg sync.WaitGroup
+	for i, s := range f.sets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, s)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// failures returns the number of errors in errs, and the first of them if
+// all sets failed.
+func failures(errs []error) (int, error) {
+	var (
+		n     int
+		first error
+	)
+	for _, err := range errs {
+		if err != nil {
+			n++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if n > 0 && n == len(errs) {
+		return n, first
+	}
+	return n, nil
+}
+
+// unavailableStats returns the stats of a search which couldn't reach
+// crashes sets.
+func unavailableStats(crashes int) zoekt.Stats {
+	if crashes == 0 {
+		return zoekt.Stats{}
+	}
+	return zoekt.Stats{Crashes: crashes, FlushReason: zoekt.FlushReasonShardUnavailable}
+}
+
+func mergeMap(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	maps.Copy(dst, src)
+	return dst
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+
+	"github.com/sourcegraph/zoekt/grpc/chunk"
+	"github.com/sourcegraph/zoekt/grpc/grpcutil"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+// defaultGRPCMessageReceiveSizeBytes matches the limit of the webserver,
+// since search results can be large.
+const defaultGRPCMessageReceiveSizeBytes = 90 * 1024 * 1024 // 90 MB
+
+type grpcBackend struct {
+	addr   string
+	cc     *grpc.ClientConn
+	client proto.WebserverServiceClient
+}
+
+func newGRPCBackend(addr string, opts Options) (*grpcBackend, error) {
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(defaultGRPCMessageReceiveSizeBytes)),
+	}
+	if opts.Token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(tokenCredentials(opts.Token)))
+	}
+	if opts.Compress {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	dialOpts = append(dialOpts, opts.DialOptions...)
+
+	cc, err := grpc.NewClient(addr, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcBackend{
+		addr:   addr,
+		cc:     cc,
+		client: proto.NewWebserverServiceClient(cc),
+	}, nil
+}
+
+func (b *grpcBackend) search(ctx context.Context, req *proto.SearchRequest) (*proto.SearchResponse, error) {
+	resp, err := b.client.Search(ctx, req)
+	if err != nil {
+		return nil, grpcutil.SearchErrorFromStatus(err)
+	}
+	return resp, nil
+}
+
+func (b *grpcBackend) streamSearch(ctx context.Context, req *proto.StreamSearchRequest, f func(*proto.StreamSearchResponse)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := b.client.StreamSearch(ctx, req)
+	if err != nil {
+		return grpcutil.SearchErrorFromStatus(err)
+	}
+	var files chunk.FileMatchJoiner
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return grpcutil.SearchErrorFromStatus(err)
+		}
+		if r := resp.GetResponseChunk(); r != nil {
+			r.Files = files.Join(r.Files)
+		}
+		f(resp)
+	}
+}
+
+func (b *grpcBackend) list(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	return b.client.List(ctx, req)
+}
+
+func (b *grpcBackend) getFile(ctx context.Context, req *proto.GetFileRequest) (*proto.GetFileResponse, error) {
+	return b.client.GetFile(ctx, req)
+}
+
+func (b *grpcBackend) close() error {
+	return b.cc.Close()
+}
+
+func (b *grpcBackend) String() string {
+	return "grpc://" + b.addr
+}
+
+// tokenCredentials sends a bearer token with every request.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity returns false, since gRPC can't tell whether a
+// connection through a TLS terminating proxy is secure.
+func (tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
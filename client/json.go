@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/sourcegraph/zoekt"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+// HTTPError is returned for requests to the JSON API which failed with a
+// status other than 200 OK. Searches which failed for a reason return a
+// *zoekt.SearchError wrapping the HTTPError.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// jsonErrorReply is the reply of the JSON API for failed requests. Reason is
+// the name of the FlushReason of searches which failed for a reason, eg.
+// "FLUSH_REASON_TIMEOUT", or zoekt.RateLimitReason.
+type jsonErrorReply struct {
+	Error  string
+	Reason string
+	Class  string
+}
+
+// err returns err as a *zoekt.SearchError if the reply has a reason, or as a
+// *zoekt.RateLimitError if the search was rate limited. retryAfter is the
+// Retry-After header of the reply.
+func (r *jsonErrorReply) err(err error, retryAfter string) error {
+	if r.Reason == zoekt.RateLimitReason {
+		seconds, _ := strconv.Atoi(retryAfter)
+		return &zoekt.RateLimitError{Class: r.Class, RetryAfter: time.Duration(seconds) * time.Second}
+	}
+	if reason := zoekt.FlushReasonFromProtoName(r.Reason); reason != 0 {
+		return &zoekt.SearchError{Reason: reason, Err: err}
+	}
+	return err
+}
+
+// jsonUnmarshal ignores unknown fields, so newer webservers can add fields.
+var jsonUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// jsonBackend uses the /api/v1 endpoints of the webserver, which take the
+// protojson encoding of the gRPC messages.
+type jsonBackend struct {
+	base   string
+	client *http.Client
+	token  string
+}
+
+func newJSONBackend(base string, client *http.Client, token string) *jsonBackend {
+	return &jsonBackend{base: strings.TrimSuffix(base, "/"), client: client, token: token}
+}
+
+// post sends req to the endpoint at path. The caller must close the body of
+// the response.
+func (b *jsonBackend) post(ctx context.Context, path string, req protobuf.Message) (*http.Response, error) {
+	body, err := protojson.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", b.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if b.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var reply jsonErrorReply
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply)
+		return nil, reply.err(&HTTPError{StatusCode: resp.StatusCode, Message: reply.Error}, resp.Header.Get("Retry-After"))
+	}
+	return resp, nil
+}
+
+// call sends req to the endpoint at path and decodes the reply into resp.
+func (b *jsonBackend) call(ctx context.Context, path string, req, resp protobuf.Message) error {
+	httpResp, err := b.post(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	return jsonUnmarshal.Unmarshal(body, resp)
+}
+
+func (b *jsonBackend) search(ctx context.Context, req *proto.SearchRequest) (*proto.SearchResponse, error) {
+	var resp proto.SearchResponse
+	if err := b.call(ctx, "/api/v1/search", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *jsonBackend) streamSearch(ctx context.Context, req *proto.StreamSearchRequest, f func(*proto.StreamSearchResponse)) error {
+	httpResp, err := b.post(ctx, "/api/v1/stream", req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	// The reply has one response per line. Errors after the stream started
+	// are sent as a last line {"Error": "..."}.
+	r := bufio.NewReader(httpResp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if bytes.HasPrefix(line, []byte(`{"Error"`)) {
+				var reply jsonErrorReply
+				if err := json.Unmarshal(line, &reply); err != nil {
+					return err
+				}
+				return reply.err(errors.New(reply.Error), "")
+			}
+
+			var resp proto.StreamSearchResponse
+			if err := jsonUnmarshal.Unmarshal(line, &resp); err != nil {
+				return err
+			}
+			f(&resp)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *jsonBackend) list(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	var resp proto.ListResponse
+	if err := b.call(ctx, "/api/v1/list", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *jsonBackend) getFile(ctx context.Context, req *proto.GetFileRequest) (*proto.GetFileResponse, error) {
+	var resp proto.GetFileResponse
+	if err := b.call(ctx, "/api/v1/file", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *jsonBackend) close() error {
+	return nil
+}
+
+func (b *jsonBackend) String() string {
+	return b.base
+}
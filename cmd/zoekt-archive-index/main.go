@@ -5,6 +5,11 @@
 //	zoekt-archive-index -incremental -commit b57cb1605fd11ba2ecfa7f68992b4b9cc791934d -name github.com/gorilla/mux -strip_components 1 https://codeload.github.com/gorilla/mux/legacy.tar.gz/b57cb1605fd11ba2ecfa7f68992b4b9cc791934d
 //
 //	zoekt-archive-index -branch master https://github.com/gorilla/mux/commit/b57cb1605fd11ba2ecfa7f68992b4b9cc791934d
+//
+// Example via a release artifact, a tar (optionally gzip or bzip2
+// compressed) or zip file at a local path or URL:
+//
+//	zoekt-archive-index -name sdk.example.com/sdk -branch v1.2.3 -strip_prefix sdk-1.2.3/ -repo_meta team=platform sdk-1.2.3.zip
 package main
 
 import (
@@ -21,11 +26,12 @@ func main() {
 	var (
 		incremental = flag.Bool("incremental", true, "only index changed repositories")
 
-		name   = flag.String("name", "", "The repository name for the archive")
-		urlRaw = flag.String("url", "", "The repository URL for the archive")
-		branch = flag.String("branch", "", "The branch name for the archive")
-		commit = flag.String("commit", "", "The commit sha for the archive. If incremental this will avoid updating shards already at commit")
-		strip  = flag.Int("strip_components", 0, "Remove the specified number of leading path elements. Pathnames with fewer elements will be silently skipped.")
+		name        = flag.String("name", "", "The repository name for the archive")
+		urlRaw      = flag.String("url", "", "The repository URL for the archive")
+		branch      = flag.String("branch", "", "The branch name for the archive. Defaults to the ref in code host URLs, or else HEAD")
+		commit      = flag.String("commit", "", "The commit sha for the archive. If incremental this will avoid updating shards already at commit. Defaults to the SHA-256 checksum of local archives")
+		strip       = flag.Int("strip_components", 0, "Remove the specified number of leading path elements. Pathnames with fewer elements will be silently skipped.")
+		stripPrefix = flag.String("strip_prefix", "", "Remove this prefix from paths, after -strip_components. Paths without the prefix will be silently skipped.")
 
 		downloadLimitMbps = flag.Int64("download-limit-mbps", 0, "If non-zero, limit archive downloads to specified amount in megabits per second")
 	)
@@ -50,6 +56,8 @@ func main() {
 		Branch:  *branch,
 		Commit:  *commit,
 		Strip:   *strip,
+
+		StripPrefix: *stripPrefix,
 	}
 
 	// Sourcegraph specific: Limit HTTP traffic
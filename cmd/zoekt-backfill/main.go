@@ -0,0 +1,128 @@
+// zoekt-backfill computes optional sections for existing shards and appends
+// them without reindexing the content.
+//
+//	zoekt-backfill -sections lines /data/index/*.zoekt
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// generators maps section names to the section generators zoekt-backfill
+// knows about. Add new generators here.
+var generators = map[string]zoekt.SectionGenerator{
+	lineCountGenerator{}.Name(): lineCountGenerator{},
+}
+
+// lineCountGenerator stores the number of lines of every document as a
+// uvarint.
+type lineCountGenerator struct{}
+
+func (lineCountGenerator) Name() string { return "lines" }
+
+func (lineCountGenerator) Generate(docs *zoekt.BackfillDocuments) ([]byte, error) {
+	var out []byte
+	for docs.Next() {
+		out = binary.AppendUvarint(out, lineCount(docs.Doc().Content))
+	}
+	return out, nil
+}
+
+// lineCount returns the number of lines of content, counting a final line
+// without a newline.
+func lineCount(content []byte) uint64 {
+	n := bytes.Count(content, []byte{'\n'})
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++
+	}
+	return uint64(n)
+}
+
+func generatorNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseGenerators(s string) ([]zoekt.SectionGenerator, error) {
+	var gens []zoekt.SectionGenerator
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		g, ok := generators[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q, available: %s", name, strings.Join(generatorNames(), ", "))
+		}
+		gens = append(gens, g)
+	}
+	if len(gens) == 0 {
+		return nil, fmt.Errorf("no sections given, available: %s", strings.Join(generatorNames(), ", "))
+	}
+	return gens, nil
+}
+
+func backfill(path string, gens []zoekt.SectionGenerator) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	indexFile, err := zoekt.NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+
+	tmpName, dstName, err := zoekt.Backfill(indexFile, gens...)
+	if err != nil {
+		return fmt.Errorf("zoekt.Backfill: %w", err)
+	}
+
+	if err := os.Rename(tmpName, dstName); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+func main() {
+	sections := flag.String("sections", "", "comma separated list of sections to compute. Available: "+strings.Join(generatorNames(), ", "))
+	flag.Parse()
+
+	gens, err := parseGenerators(*sections)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: zoekt-backfill -sections NAME[,NAME] SHARD...")
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if err := backfill(path, gens); err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+		log.Printf("backfilled %s", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLineCount(t *testing.T) {
+	for content, want := range map[string]uint64{
+		"":        0,
+		"a":       1,
+		"a\nb\n":  2,
+		"a\nb\nc": 3,
+		"\n\n":    2,
+	} {
+		if got := lineCount([]byte(content)); got != want {
+			t.Errorf("%q: got %d lines, want %d", content, got, want)
+		}
+	}
+}
+
+func TestParseGenerators(t *testing.T) {
+	if _, err := parseGenerators(""); err == nil {
+		t.Error("expected error for empty sections")
+	}
+	if _, err := parseGenerators("lines,unknown"); err == nil {
+		t.Error("expected error for unknown section")
+	}
+	gens, err := parseGenerators("lines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 1 || gens[0].Name() != "lines" {
+		t.Errorf("got %v, want [lines]", gens)
+	}
+}
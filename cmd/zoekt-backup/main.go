@@ -0,0 +1,31 @@
+// zoekt-backup snapshots an index directory into a backup store. Only the
+// shards which changed since the last backup are uploaded. Restore snapshots
+// with zoekt-restore.
+//
+//	zoekt-backup -index /data/index -store /mnt/backups/zoekt
+//	zoekt-backup -index /data/index -store https://storage.googleapis.com/bucket/zoekt
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/sourcegraph/zoekt/internal/backup"
+)
+
+func main() {
+	index := flag.String("index", "", "index directory to back up")
+	store := flag.String("store", "", "backup store: a directory, or an http(s) URL accepting PUT, GET and HEAD. The environment variable ZOEKT_BACKUP_AUTHORIZATION is sent as Authorization header.")
+	flag.Parse()
+
+	if *index == "" || *store == "" {
+		log.Fatal("usage: zoekt-backup -index DIR -store DIR|URL")
+	}
+
+	snap, err := backup.Backup(context.Background(), backup.NewStore(*store), *index)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote snapshot %s with %d files", snap.ID, len(snap.Files))
+}
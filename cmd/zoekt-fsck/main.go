@@ -0,0 +1,130 @@
+// zoekt-fsck checks shards for corruption, like bit rot on disk or truncated
+// copies. It exits with status 1 if a shard has problems.
+//
+//	zoekt-fsck /data/index/*.zoekt
+//
+// With -source, it also compares the content of every document with the
+// repository the shard was built from, see zoekt.Repository.Source.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// sourceReader reads documents from the source repositories of shards. Git
+// repositories are read at the commit recorded for the branch, other
+// directories from the working tree.
+type sourceReader struct {
+	repos map[string]*git.Repository
+	trees map[string]*object.Tree
+}
+
+func newSourceReader() *sourceReader {
+	return &sourceReader{
+		repos: map[string]*git.Repository{},
+		trees: map[string]*object.Tree{},
+	}
+}
+
+func (s *sourceReader) content(repo *zoekt.Repository, branch, name string) ([]byte, bool, error) {
+	if repo.Source == "" {
+		return nil, false, errors.New("shard has no source")
+	}
+
+	gitRepo, ok := s.repos[repo.Source]
+	if !ok {
+		var err error
+		if gitRepo, err = git.PlainOpen(repo.Source); err != nil && !errors.Is(err, git.ErrRepositoryNotExists) {
+			return nil, false, err
+		}
+		s.repos[repo.Source] = gitRepo
+	}
+	if gitRepo == nil {
+		b, err := os.ReadFile(filepath.Join(repo.Source, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return b, err == nil, err
+	}
+
+	tree, err := s.tree(gitRepo, repo, branch)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := tree.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return b, err == nil, err
+}
+
+func (s *sourceReader) tree(gitRepo *git.Repository, repo *zoekt.Repository, branch string) (*object.Tree, error) {
+	var version string
+	for _, b := range repo.Branches {
+		if b.Name == branch {
+			version = b.Version
+		}
+	}
+	key := repo.Source + "\x00" + version
+	if tree, ok := s.trees[key]; ok {
+		return tree, nil
+	}
+
+	commit, err := gitRepo.CommitObject(plumbing.NewHash(version))
+	if err != nil {
+		return nil, fmt.Errorf("commit %s of branch %s: %w", version, branch, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	s.trees[key] = tree
+	return tree, nil
+}
+
+func main() {
+	source := flag.Bool("source", false, "also compare the content of documents with the source repository of the shard")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: zoekt-fsck [-source] SHARD...")
+	}
+
+	var opts zoekt.VerifyOptions
+	if *source {
+		opts.SourceContent = newSourceReader().content
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if err := zoekt.VerifyShard(path, opts); err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+		log.Printf("%s: ok", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
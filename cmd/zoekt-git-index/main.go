@@ -34,7 +34,8 @@ import (
 
 func run() int {
 	allowMissing := flag.Bool("allow_missing_branches", false, "allow missing branches.")
-	submodules := flag.Bool("submodules", true, "if set to false, do not recurse into submodules")
+	submodules := flag.Bool("submodules", true, "if set to false, do not recurse into submodules. Submodules are read from the modules directory of the repository (see git clone --recurse-submodules) or else from -repo_cache, and indexed under their path with their own commit as version.")
+	resolveLFS := flag.Bool("lfs", false, "index the content of Git LFS objects instead of their pointer files. Fetch the objects first, eg. with git lfs fetch --all.")
 	branchesStr := flag.String("branches", "HEAD", "git branches to index.")
 	branchPrefix := flag.String("prefix", "refs/heads/", "prefix for branch names")
 
@@ -125,6 +126,7 @@ func run() int {
 			BranchPrefix:                      *branchPrefix,
 			Incremental:                       *incremental,
 			Submodules:                        *submodules,
+			ResolveLFS:                        *resolveLFS,
 			RepoCacheDir:                      *repoCacheDir,
 			AllowMissingBranch:                *allowMissing,
 			BuildOptions:                      *opts,
@@ -0,0 +1,62 @@
+// Command zoekt-hg-index indexes a Mercurial repository.
+//
+// It runs the hg command line client to read files at the tips of the given
+// branches or bookmarks. Files that are the same on several branches are
+// stored once.
+//
+// Example:
+//
+//	zoekt-hg-index -branches default,stable ~/repos/project
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"go.uber.org/automaxprocs/maxprocs"
+
+	"github.com/sourcegraph/zoekt/cmd"
+	"github.com/sourcegraph/zoekt/internal/hgindex"
+)
+
+func main() {
+	var (
+		incremental = flag.Bool("incremental", true, "only index changed repositories")
+
+		branchesStr = flag.String("branches", "default", "comma separated list of branches or bookmarks to index")
+		name        = flag.String("name", "", "The repository name. Defaults to the host and path of the default path, or the directory name")
+		urlRaw      = flag.String("url", "", "The repository URL")
+		hg          = flag.String("hg", "hg", "The hg binary")
+	)
+	flag.Parse()
+
+	// Tune GOMAXPROCS to match Linux container CPU quota.
+	_, _ = maxprocs.Set()
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if len(flag.Args()) != 1 {
+		log.Fatal("expected argument for repository directory")
+	}
+
+	var branches []string
+	if *branchesStr != "" {
+		branches = strings.Split(*branchesStr, ",")
+	}
+
+	bopts := cmd.OptionsFromFlags()
+	opts := hgindex.Options{
+		Incremental: *incremental,
+
+		RepoDir:  flag.Arg(0),
+		Branches: branches,
+		Name:     *name,
+		RepoURL:  *urlRaw,
+		Hg:       *hg,
+	}
+
+	if err := hgindex.IndexHgRepo(opts, *bopts); err != nil {
+		log.Fatal(err)
+	}
+}
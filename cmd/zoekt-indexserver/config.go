@@ -52,7 +52,14 @@ type ConfigEntry struct {
 	NoArchived             bool
 	GerritFetchMetaConfig  bool
 	GerritRepoNameFormat   string
+	GerritIndexChanges     bool
 	ExcludeUserRepos       bool
+	BitbucketURL           string
+	BitbucketWorkspace     string
+	BitbucketProject       string
+	GiteaURL               string
+	GiteaOrg               string
+	GiteaUser              string
 }
 
 func randomize(entries []ConfigEntry) []ConfigEntry {
@@ -71,6 +78,14 @@ func isHTTP(u string) bool {
 	return err == nil && (asURL.Scheme == "http" || asURL.Scheme == "https")
 }
 
+func isBitbucketCloud(u string) bool {
+	if u == "" {
+		return true
+	}
+	asURL, err := url.Parse(u)
+	return err == nil && asURL.Host == "bitbucket.org"
+}
+
 func readConfigURL(u string) ([]ConfigEntry, error) {
 	var body []byte
 	var readErr error
@@ -129,7 +144,7 @@ func watchFile(path string) (<-chan struct{}, error) {
 	return out, nil
 }
 
-func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- string) {
+func periodicMirrorFile(repoDir string, opts *Options, queue *indexQueue) {
 	ticker := time.NewTicker(opts.mirrorInterval)
 
 	var watcher <-chan struct{}
@@ -150,7 +165,7 @@ func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- strin
 			lastCfg = cfg
 		}
 
-		executeMirror(lastCfg, repoDir, pendingRepos)
+		executeMirror(lastCfg, repoDir, queue)
 
 		select {
 		case <-watcher:
@@ -160,7 +175,7 @@ func periodicMirrorFile(repoDir string, opts *Options, pendingRepos chan<- strin
 	}
 }
 
-func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string) {
+func executeMirror(cfg []ConfigEntry, repoDir string, queue *indexQueue) {
 	// Randomize the ordering in which we query
 	// things. This is to ensure that quota limits don't
 	// always hit the last one in the list.
@@ -232,6 +247,61 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 			if c.CredentialPath != "" {
 				cmd.Args = append(cmd.Args, "-credentials", c.CredentialPath)
 			}
+		} else if c.BitbucketURL != "" || c.BitbucketWorkspace != "" {
+			cmd = exec.Command("zoekt-mirror-bitbucket",
+				"-dest", repoDir)
+			if c.BitbucketURL != "" {
+				cmd.Args = append(cmd.Args, "-url", c.BitbucketURL)
+			}
+			// Bitbucket Cloud stores all projects of a workspace in the same
+			// directory, so we can't delete the repos of a single project.
+			if c.BitbucketProject == "" || !isBitbucketCloud(c.BitbucketURL) {
+				cmd.Args = append(cmd.Args, "-delete")
+			}
+			if c.BitbucketWorkspace != "" {
+				cmd.Args = append(cmd.Args, "-workspace", c.BitbucketWorkspace)
+			}
+			if c.BitbucketProject != "" {
+				cmd.Args = append(cmd.Args, "-project", c.BitbucketProject)
+			}
+			if c.Name != "" {
+				cmd.Args = append(cmd.Args, "-name", c.Name)
+			}
+			if c.Exclude != "" {
+				cmd.Args = append(cmd.Args, "-exclude", c.Exclude)
+			}
+			if c.OnlyPublic {
+				cmd.Args = append(cmd.Args, "-no_private")
+			}
+			if c.CredentialPath != "" {
+				cmd.Args = append(cmd.Args, "-credentials", c.CredentialPath)
+			}
+		} else if c.GiteaURL != "" {
+			cmd = exec.Command("zoekt-mirror-gitea",
+				"-dest", repoDir, "-url", c.GiteaURL, "-delete")
+			if c.GiteaUser != "" {
+				cmd.Args = append(cmd.Args, "-user", c.GiteaUser)
+			} else if c.GiteaOrg != "" {
+				cmd.Args = append(cmd.Args, "-org", c.GiteaOrg)
+			}
+			if c.Name != "" {
+				cmd.Args = append(cmd.Args, "-name", c.Name)
+			}
+			if c.Exclude != "" {
+				cmd.Args = append(cmd.Args, "-exclude", c.Exclude)
+			}
+			if c.CredentialPath != "" {
+				cmd.Args = append(cmd.Args, "-token", c.CredentialPath)
+			}
+			for _, topic := range c.Topics {
+				cmd.Args = append(cmd.Args, "-topic", topic)
+			}
+			for _, topic := range c.ExcludeTopics {
+				cmd.Args = append(cmd.Args, "-exclude_topic", topic)
+			}
+			if c.NoArchived {
+				cmd.Args = append(cmd.Args, "-no_archived")
+			}
 		} else if c.GitLabURL != "" {
 			cmd = exec.Command("zoekt-mirror-gitlab",
 				"-dest", repoDir, "-url", c.GitLabURL)
@@ -271,6 +341,9 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 			if c.GerritRepoNameFormat != "" {
 				cmd.Args = append(cmd.Args, "-repo-name-format", c.GerritRepoNameFormat)
 			}
+			if c.GerritIndexChanges {
+				cmd.Args = append(cmd.Args, "-index-changes")
+			}
 			cmd.Args = append(cmd.Args, c.GerritApiURL)
 		} else {
 			log.Printf("executeMirror: ignoring config, because it does not contain any valid repository definition: %v", c)
@@ -284,7 +357,7 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 				continue
 			}
 
-			pendingRepos <- string(fn)
+			queue.Push(string(fn))
 		}
 
 	}
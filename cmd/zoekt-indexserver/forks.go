@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// forkIndex maps repositories to their forks, ie. the repositories with `git
+// config zoekt.fork-of NAME`. Forks are indexed relative to the current index
+// of their parent (see zoekt.Repository.ForkOf), so they must be reindexed
+// whenever their parent is. It is safe to use concurrently.
+type forkIndex struct {
+	repoDir string
+
+	mu sync.Mutex
+	// forks maps the directory of a parent to the directories of its
+	// forks.
+	forks map[string][]string
+}
+
+func newForkIndex(repoDir string) *forkIndex {
+	return &forkIndex{repoDir: repoDir, forks: map[string][]string{}}
+}
+
+// Update replaces the index with the forks among the repositories in dirs.
+// Forks of forks aren't reindexed with their parent, which rules out cycles.
+func (f *forkIndex) Update(dirs []string) {
+	byName := map[string]string{}
+	forkOf := map[string]string{}
+	for _, dir := range dirs {
+		name, parent := f.repoConfig(dir)
+		byName[name] = dir
+		if parent != "" {
+			forkOf[dir] = parent
+		}
+	}
+
+	forks := map[string][]string{}
+	for dir, parent := range forkOf {
+		p, ok := byName[parent]
+		if _, isFork := forkOf[p]; ok && !isFork {
+			forks[p] = append(forks[p], dir)
+		}
+	}
+
+	f.mu.Lock()
+	f.forks = forks
+	f.mu.Unlock()
+}
+
+// Forks returns the directories of the forks of the repository in dir.
+func (f *forkIndex) Forks(dir string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.forks[dir]
+}
+
+// repoConfig returns the name of the repository in dir, and the name of its
+// parent if it is a fork. Without `git config zoekt.name`, the name is the
+// path of dir relative to the repository directory, as the mirror commands
+// lay them out, eg. github.com/org/repo.git for github.com/org/repo.
+func (f *forkIndex) repoConfig(dir string) (name, forkOf string) {
+	if rel, err := filepath.Rel(f.repoDir, dir); err == nil {
+		name = strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return name, ""
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return name, ""
+	}
+	sec := cfg.Raw.Section("zoekt")
+	if n := sec.Option("name"); n != "" {
+		name = n
+	}
+	return name, sec.Option("fork-of")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestForkIndex(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	newRepo := func(name string, config ...string) string {
+		repo := filepath.Join(dir, name+".git")
+		if out, err := exec.Command("git", "init", "--bare", repo).CombinedOutput(); err != nil {
+			t.Fatalf("git init: %v: %s", err, out)
+		}
+		for i := 0; i < len(config); i += 2 {
+			if out, err := exec.Command("git", "--git-dir", repo, "config", config[i], config[i+1]).CombinedOutput(); err != nil {
+				t.Fatalf("git config: %v: %s", err, out)
+			}
+		}
+		return repo
+	}
+
+	parent := newRepo("github.com/org/repo")
+	named := newRepo("mirror", "zoekt.name", "example.com/named")
+	fork := newRepo("github.com/alice/repo", "zoekt.fork-of", "github.com/org/repo")
+	fork2 := newRepo("github.com/bob/repo", "zoekt.fork-of", "github.com/org/repo")
+	namedFork := newRepo("github.com/carol/named", "zoekt.fork-of", "example.com/named")
+	forkOfFork := newRepo("github.com/dave/repo", "zoekt.fork-of", "github.com/alice/repo")
+	orphan := newRepo("github.com/erin/repo", "zoekt.fork-of", "github.com/missing/repo")
+
+	f := newForkIndex(dir)
+	f.Update([]string{parent, named, fork, fork2, namedFork, forkOfFork, orphan})
+
+	got := f.Forks(parent)
+	sort.Strings(got)
+	if d := cmp.Diff([]string{fork, fork2}, got); d != "" {
+		t.Errorf("forks of parent (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff([]string{namedFork}, f.Forks(named)); d != "" {
+		t.Errorf("forks of named (-want +got):\n%s", d)
+	}
+	// Forks of forks aren't reindexed with their parent.
+	if got := f.Forks(fork); len(got) != 0 {
+		t.Errorf("got forks %v of a fork, want none", got)
+	}
+}
@@ -16,6 +16,8 @@
 // * recycling logs
 // * periodically fetching new data.
 // * periodically reindexing all git repos.
+// * fetching and reindexing repos on push webhooks.
+// * indexing high priority repos ahead of bulk backfills.
 
 package main
 
@@ -26,6 +28,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -33,6 +36,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/gitindex"
 )
@@ -64,6 +68,10 @@ type Options struct {
 	mirrorConfigFile string
 	maxLogAge        time.Duration
 	indexTimeout     time.Duration
+	listen           string
+	webhookSecret    string
+	priorityStars    int
+	sla              [numPriorities]time.Duration
 }
 
 func (o *Options) validate() {
@@ -78,6 +86,9 @@ func (o *Options) validate() {
 	if o.indexFlagsStr != "" {
 		o.indexFlags = strings.Split(o.indexFlagsStr, " ")
 	}
+	if o.listen != "" && o.webhookSecret == "" {
+		log.Fatal("--listen requires --webhook_secret")
+	}
 }
 
 func (o *Options) defineFlags() {
@@ -91,11 +102,17 @@ func (o *Options) defineFlags() {
 	flag.Float64Var(&o.cpuFraction, "cpu_fraction", 0.25,
 		"use this fraction of the cores for indexing.")
 	flag.StringVar(&o.indexFlagsStr, "git_index_flags", "", "space separated list of flags passed through to zoekt-git-index (e.g. -git_index_flags='-symbols=false -submodules=false'")
+	flag.StringVar(&o.listen, "listen", "", "if set, serve metrics at /metrics on this address, eg. :6072, and accept GitHub and GitLab push webhooks at /webhook to fetch and reindex pushed repositories right away.")
+	flag.StringVar(&o.webhookSecret, "webhook_secret", "", "path to a file holding the webhook secret, required with --listen. GitHub webhooks must be signed with it, and GitLab webhooks must send it as their token.")
+	flag.IntVar(&o.priorityStars, "priority_stars", 0, "index repos with at least this many GitHub stars with high priority. 0 disables.")
+	flag.DurationVar(&o.sla[priorityHigh], "sla_high", 5*time.Minute, "aim to index high priority repos within this duration of queueing them.")
+	flag.DurationVar(&o.sla[priorityNormal], "sla_normal", time.Hour, "aim to index normal priority repos within this duration of queueing them.")
+	flag.DurationVar(&o.sla[priorityLow], "sla_low", day, "aim to index low priority repos, and reindex repos without new commits, within this duration of queueing them.")
 }
 
 // periodicFetch runs git-fetch every once in a while. Results are
-// posted on pendingRepos.
-func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<- string) {
+// pushed on queue.
+func periodicFetch(repoDir, indexDir string, opts *Options, queue *indexQueue, forks *forkIndex) {
 	t := time.NewTicker(opts.fetchInterval)
 	for {
 		repos, err := gitindex.FindGitRepos(repoDir)
@@ -106,6 +123,7 @@ func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<-
 		if len(repos) == 0 {
 			log.Printf("no repos found under %s", repoDir)
 		}
+		forks.Update(repos)
 
 		// TODO: Randomize to make sure quota throttling hits everyone.
 
@@ -114,12 +132,12 @@ func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<-
 			if ok := fetchGitRepo(dir); !ok {
 				later[dir] = struct{}{}
 			} else {
-				pendingRepos <- dir
+				queue.Push(dir)
 			}
 		}
 
 		for r := range later {
-			pendingRepos <- r
+			queue.Backfill(r)
 		}
 
 		<-t.C
@@ -141,12 +159,19 @@ func fetchGitRepo(dir string) bool {
 	return len(output) != 0
 }
 
-// indexPendingRepos consumes the directories on the repos channel and
-// indexes them, sequentially.
-func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan string) {
-	for dir := range repos {
+// indexPendingRepos pops the directories on queue and indexes them,
+// sequentially.
+func indexPendingRepos(indexDir, repoDir string, opts *Options, queue *indexQueue, forks *forkIndex) {
+	for {
+		dir := queue.Pop()
 		indexPendingRepo(dir, indexDir, repoDir, opts)
 
+		// Forks only index the files which differ from the current index of
+		// their parent.
+		for _, fork := range forks.Forks(dir) {
+			queue.Push(fork)
+		}
+
 		// Failures (eg. timeout) will leave temp files
 		// around. We have to clean them, or they will fill up the indexing volume.
 		if failures, err := filepath.Glob(filepath.Join(indexDir, "*.tmp")); err != nil {
@@ -226,7 +251,10 @@ func deleteIfOrphan(repoDir string, fn string) error {
 	_, err = os.Stat(repo.Source)
 	if os.IsNotExist(err) {
 		log.Printf("deleting orphan shard %s; source %q not found", fn, repo.Source)
-		return os.Remove(fn)
+		if err := os.Remove(fn); err != nil {
+			return err
+		}
+		return zoekt.PruneShardManifest(fn)
 	}
 
 	return err
@@ -251,6 +279,28 @@ func deleteOrphanIndexes(indexDir, repoDir string, watchInterval time.Duration)
 	}
 }
 
+func serveHTTP(repoDir string, opts *Options, queue *indexQueue) {
+	b, err := os.ReadFile(opts.webhookSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		log.Fatalf("--webhook_secret %s is empty", opts.webhookSecret)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", &webhookHandler{
+		repoDir: repoDir,
+		secret:  secret,
+		queue:   queue,
+		fetch:   fetchGitRepo,
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("serving HTTP on %s", opts.listen)
+	log.Fatal(http.ListenAndServe(opts.listen, mux))
+}
+
 func main() {
 	var opts Options
 	opts.defineFlags()
@@ -290,10 +340,16 @@ func main() {
 		log.Fatalf("readConfigURL(%s): %v", opts.mirrorConfigFile, err)
 	}
 
-	pendingRepos := make(chan string, 10)
-	go periodicMirrorFile(repoDir, &opts, pendingRepos)
+	queue := newIndexQueue(opts.sla, func(dir string) priority {
+		return repoPriority(dir, opts.priorityStars)
+	})
+	if opts.listen != "" {
+		go serveHTTP(repoDir, &opts, queue)
+	}
+	go periodicMirrorFile(repoDir, &opts, queue)
 	go deleteLogsLoop(logDir, opts.maxLogAge)
 	go deleteOrphanIndexes(*indexDir, repoDir, opts.fetchInterval)
-	go indexPendingRepos(*indexDir, repoDir, &opts, pendingRepos)
-	periodicFetch(repoDir, *indexDir, &opts, pendingRepos)
+	forks := newForkIndex(repoDir)
+	go indexPendingRepos(*indexDir, repoDir, &opts, queue, forks)
+	periodicFetch(repoDir, *indexDir, &opts, queue, forks)
 }
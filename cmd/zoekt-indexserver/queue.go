@@ -0,0 +1,227 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// priority is the scheduling class of a repository. Each class has an SLA:
+// the time we aim to index a repository in, after it was queued.
+type priority int
+
+const (
+	priorityHigh priority = iota
+	priorityNormal
+	priorityLow
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"high", "normal", "low"}
+
+func (p priority) String() string {
+	return priorityNames[p]
+}
+
+func parsePriority(s string) (priority, error) {
+	for p, name := range priorityNames {
+		if s == name {
+			return priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, want one of %s", s, strings.Join(priorityNames[:], ", "))
+}
+
+type queueItem struct {
+	dir      string
+	priority priority
+	// added is when dir was first pushed since it was last popped.
+	added time.Time
+	// deadline is added plus the SLA of priority.
+	deadline time.Time
+	// heapIdx is the index of the item in the heap.
+	heapIdx int
+	// seq is a sequence number used as a tiebreaker, so that we act like a
+	// FIFO queue for equal deadlines.
+	seq int64
+}
+
+// indexQueue holds the repositories that need indexing. It pops the
+// repository with the earliest deadline first, where a repository's deadline
+// is the time it was queued plus the SLA of its priority. High priority
+// repositories thus overtake bulk backfills, but low priority repositories
+// are not starved: once they have waited for their SLA, they go before high
+// priority repositories that were queued after that.
+//
+// A repository is queued at most once. It is safe to use concurrently.
+type indexQueue struct {
+	sla [numPriorities]time.Duration
+	// priority returns the priority of the repository in dir.
+	priority func(dir string) priority
+
+	mu       sync.Mutex
+	nonEmpty *sync.Cond
+	items    map[string]*queueItem
+	pq       pqueue
+	seq      int64
+}
+
+func newIndexQueue(sla [numPriorities]time.Duration, priority func(dir string) priority) *indexQueue {
+	q := &indexQueue{
+		sla:      sla,
+		priority: priority,
+		items:    map[string]*queueItem{},
+	}
+	q.nonEmpty = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues the repository in dir with its priority. If it already is
+// queued, it keeps the earlier deadline.
+func (q *indexQueue) Push(dir string) {
+	q.push(dir, q.priority(dir))
+}
+
+// Backfill queues the repository in dir with low priority, for reindexing it
+// when nothing changed, eg. to pick up new indexer versions.
+func (q *indexQueue) Backfill(dir string) {
+	q.push(dir, priorityLow)
+}
+
+func (q *indexQueue) push(dir string, p priority) {
+	now := time.Now()
+	deadline := now.Add(q.sla[p])
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if item, ok := q.items[dir]; ok {
+		if deadline.Before(item.deadline) {
+			metricQueueLen.WithLabelValues(item.priority.String()).Dec()
+			metricQueueLen.WithLabelValues(p.String()).Inc()
+			item.priority = p
+			item.deadline = deadline
+			heap.Fix(&q.pq, item.heapIdx)
+		}
+		return
+	}
+
+	q.seq++
+	item := &queueItem{dir: dir, priority: p, added: now, deadline: deadline, seq: q.seq}
+	q.items[dir] = item
+	heap.Push(&q.pq, item)
+	metricQueueLen.WithLabelValues(p.String()).Inc()
+	q.nonEmpty.Signal()
+}
+
+// Pop blocks until a repository is queued, and returns the one with the
+// earliest deadline.
+func (q *indexQueue) Pop() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pq) == 0 {
+		q.nonEmpty.Wait()
+	}
+
+	item := heap.Pop(&q.pq).(*queueItem)
+	delete(q.items, item.dir)
+
+	name := item.priority.String()
+	now := time.Now()
+	metricQueueLen.WithLabelValues(name).Dec()
+	metricQueueWait.WithLabelValues(name).Observe(now.Sub(item.added).Seconds())
+	if now.After(item.deadline) {
+		metricSLAMisses.WithLabelValues(name).Inc()
+	}
+	return item.dir
+}
+
+// Len returns the number of queued repositories.
+func (q *indexQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pq)
+}
+
+// repoPriority returns the priority of the repository in dir. It is set
+// explicitly with `git config zoekt.priority {high,normal,low}`. Otherwise,
+// repositories with at least stars GitHub stars are high priority, and others
+// are normal priority.
+func repoPriority(dir string, stars int) priority {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return priorityNormal
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return priorityNormal
+	}
+	sec := cfg.Raw.Section("zoekt")
+
+	if p, err := parsePriority(sec.Option("priority")); err == nil {
+		return p
+	} else if sec.Option("priority") != "" {
+		log.Printf("%s: %v", dir, err)
+	}
+
+	if n, err := strconv.Atoi(sec.Option("github-stars")); err == nil && stars > 0 && n >= stars {
+		return priorityHigh
+	}
+	return priorityNormal
+}
+
+// pqueue implements a priority queue via the interface for container/heap
+type pqueue []*queueItem
+
+func (pq pqueue) Len() int { return len(pq) }
+
+func (pq pqueue) Less(i, j int) bool {
+	if !pq[i].deadline.Equal(pq[j].deadline) {
+		return pq[i].deadline.Before(pq[j].deadline)
+	}
+	return pq[i].seq < pq[j].seq
+}
+
+func (pq pqueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].heapIdx = i
+	pq[j].heapIdx = j
+}
+
+func (pq *pqueue) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.heapIdx = len(*pq)
+	*pq = append(*pq, item)
+}
+
+func (pq *pqueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	item.heapIdx = -1
+	*pq = old[:n-1]
+	return item
+}
+
+var (
+	metricQueueLen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "index_queue_len",
+		Help: "The number of repositories in the index queue, by priority.",
+	}, []string{"priority"})
+	metricQueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "index_queue_wait_seconds",
+		Help:    "The time repositories spent in the index queue, by priority.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10), // 1s -> 3d
+	}, []string{"priority"})
+	metricSLAMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "index_queue_sla_misses_total",
+		Help: "The number of repositories that waited longer than the SLA of their priority, by priority.",
+	}, []string{"priority"})
+)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexQueue(t *testing.T) {
+	priorities := map[string]priority{
+		"high":    priorityHigh,
+		"high2":   priorityHigh,
+		"normal":  priorityNormal,
+		"low":     priorityLow,
+		"overdue": priorityLow,
+	}
+	sla := [numPriorities]time.Duration{time.Minute, time.Hour, -time.Hour}
+	q := newIndexQueue(sla, func(dir string) priority { return priorities[dir] })
+
+	// An overdue low priority repo goes first. Otherwise high priority repos
+	// overtake the ones queued before them.
+	q.Backfill("overdue")
+	q.sla[priorityLow] = 24 * time.Hour
+	q.Push("low")
+	q.Push("normal")
+	q.Push("high")
+	q.Push("high2")
+	// Already queued, so this keeps its place.
+	q.Push("high")
+	// A backfill does not delay a queued repo.
+	q.Backfill("normal")
+
+	if got := q.Len(); got != 5 {
+		t.Fatalf("got %d queued repos, want 5", got)
+	}
+	for _, want := range []string{"overdue", "high", "high2", "normal", "low"} {
+		if got := q.Pop(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+
+	// Pop blocks until a repo is pushed.
+	done := make(chan string)
+	go func() { done <- q.Pop() }()
+	q.Push("normal")
+	if got := <-done; got != "normal" {
+		t.Errorf("got %s, want normal", got)
+	}
+}
+
+func TestRepoPriority(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	newRepo := func(name string, config ...string) string {
+		repo := filepath.Join(dir, name+".git")
+		if out, err := exec.Command("git", "init", "--bare", repo).CombinedOutput(); err != nil {
+			t.Fatalf("git init: %v: %s", err, out)
+		}
+		for i := 0; i < len(config); i += 2 {
+			if out, err := exec.Command("git", "--git-dir", repo, "config", config[i], config[i+1]).CombinedOutput(); err != nil {
+				t.Fatalf("git config: %v: %s", err, out)
+			}
+		}
+		return repo
+	}
+
+	for _, tc := range []struct {
+		repo string
+		want priority
+	}{
+		{newRepo("plain"), priorityNormal},
+		{newRepo("popular", "zoekt.github-stars", "1000"), priorityHigh},
+		{newRepo("unpopular", "zoekt.github-stars", "10"), priorityNormal},
+		{newRepo("explicit", "zoekt.github-stars", "1000", "zoekt.priority", "low"), priorityLow},
+		{newRepo("invalid", "zoekt.priority", "urgent"), priorityNormal},
+		{filepath.Join(dir, "missing.git"), priorityNormal},
+	} {
+		if got := repoPriority(tc.repo, 100); got != tc.want {
+			t.Errorf("%s: got %s, want %s", filepath.Base(tc.repo), got, tc.want)
+		}
+	}
+}
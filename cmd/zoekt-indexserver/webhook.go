@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// pushEvent is the part of a push webhook we need to find the repository.
+type pushEvent struct {
+	// Ref is the updated ref, eg. refs/heads/main.
+	Ref string
+	// WebURL is the repository URL, eg. https://github.com/sourcegraph/zoekt.
+	WebURL string
+}
+
+// parsePushEvent parses GitHub and GitLab push webhooks. It returns a nil
+// event for other events, such as GitHub's ping, and an error if the request
+// does not carry the right secret.
+func parsePushEvent(r *http.Request, body []byte, secret string) (*pushEvent, error) {
+	if secret == "" {
+		// Without a secret, anyone could make us fetch repositories.
+		return nil, fmt.Errorf("no webhook secret configured")
+	}
+	switch {
+	case r.Header.Get("X-GitHub-Event") != "":
+		// GitHub signs the body with HMAC-SHA256.
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+			return nil, fmt.Errorf("invalid signature")
+		}
+		if r.Header.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+		var p struct {
+			Ref        string `json:"ref"`
+			Repository struct {
+				HTMLURL string `json:"html_url"`
+			} `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		return &pushEvent{Ref: p.Ref, WebURL: p.Repository.HTMLURL}, nil
+
+	case r.Header.Get("X-Gitlab-Event") != "":
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, fmt.Errorf("invalid token")
+		}
+		if r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+			return nil, nil
+		}
+		var p struct {
+			Ref     string `json:"ref"`
+			Project struct {
+				WebURL string `json:"web_url"`
+			} `json:"project"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		return &pushEvent{Ref: p.Ref, WebURL: p.Project.WebURL}, nil
+	}
+	return nil, fmt.Errorf("unsupported webhook, want a GitHub or GitLab push event")
+}
+
+// repoDirFor returns the directory that the zoekt-mirror-* commands clone the
+// repository at webURL into, eg. $repoDir/github.com/sourcegraph/zoekt.git.
+func repoDirFor(repoDir, webURL string) (string, error) {
+	u, err := url.Parse(webURL)
+	if err != nil {
+		return "", err
+	}
+	p := strings.Trim(u.Path, "/")
+	if u.Host == "" || strings.ContainsAny(u.Host, `/\`) || strings.Contains(u.Host, "..") ||
+		p == "" || strings.Contains(p, "..") {
+		return "", fmt.Errorf("invalid repository URL %q", webURL)
+	}
+	dir := filepath.Join(repoDir, u.Host, filepath.FromSlash(p)+".git")
+
+	// Webhooks come from outside, so make sure that nothing we missed above
+	// leads out of repoDir.
+	if rel, err := filepath.Rel(repoDir, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid repository URL %q", webURL)
+	}
+	return dir, nil
+}
+
+// webhookHandler fetches and reindexes repositories when it receives push
+// webhooks, rather than waiting for the next periodic fetch.
+type webhookHandler struct {
+	repoDir string
+	secret  string
+	queue   *indexQueue
+
+	// fetch fetches the repository in dir, and returns true if there was an
+	// update.
+	fetch func(dir string) bool
+
+	mu       sync.Mutex
+	fetching map[string]bool
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev, err := parsePushEvent(r, body, h.secret)
+	if err != nil {
+		log.Printf("webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ev == nil || !strings.HasPrefix(ev.Ref, "refs/heads/") {
+		// Pings, other events and tags.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	dir, err := repoDirFor(h.repoDir, ev.WebURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(dir); err != nil {
+		// New repositories are cloned by the periodic mirror.
+		http.Error(w, fmt.Sprintf("unknown repository %s", ev.WebURL), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("webhook: push to %s %s", ev.WebURL, ev.Ref)
+	h.schedule(dir)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// schedule fetches dir in the background, and queues it for indexing if the
+// fetch found updates. Pushes that arrive while dir is being fetched are
+// picked up by that fetch, or the one after it.
+func (h *webhookHandler) schedule(dir string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.fetching == nil {
+		h.fetching = map[string]bool{}
+	}
+	if _, ok := h.fetching[dir]; ok {
+		h.fetching[dir] = true
+		return
+	}
+	h.fetching[dir] = false
+
+	go func() {
+		for {
+			if h.fetch(dir) {
+				h.queue.Push(dir)
+			}
+
+			h.mu.Lock()
+			again := h.fetching[dir]
+			if !again {
+				delete(h.fetching, dir)
+			} else {
+				h.fetching[dir] = false
+			}
+			h.mu.Unlock()
+			if !again {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func githubRequest(event, body, secret string) *http.Request {
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func gitlabRequest(event, body, token string) *http.Request {
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Event", event)
+	req.Header.Set("X-Gitlab-Token", token)
+	return req
+}
+
+func TestWebhook(t *testing.T) {
+	repoDir := t.TempDir()
+	want := filepath.Join(repoDir, "github.com", "sourcegraph", "zoekt.git")
+	if err := os.MkdirAll(want, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "gitlab.com", "group", "sub", "project.git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := newIndexQueue([numPriorities]time.Duration{}, func(string) priority { return priorityNormal })
+	fetched := make(chan string, 10)
+	h := &webhookHandler{
+		repoDir: repoDir,
+		secret:  "s3cret",
+		queue:   queue,
+		fetch: func(dir string) bool {
+			fetched <- dir
+			return true
+		},
+	}
+
+	githubPush := `{"ref": "refs/heads/main", "repository": {"html_url": "https://github.com/sourcegraph/zoekt"}}`
+	gitlabPush := `{"ref": "refs/heads/main", "project": {"web_url": "https://gitlab.com/group/sub/project"}}`
+
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+		code int
+		dir  string
+	}{
+		{"github push", githubRequest("push", githubPush, "s3cret"), http.StatusAccepted, want},
+		{"github bad signature", githubRequest("push", githubPush, "wrong"), http.StatusBadRequest, ""},
+		{"github ping", githubRequest("ping", `{}`, "s3cret"), http.StatusNoContent, ""},
+		{"github tag", githubRequest("push", strings.Replace(githubPush, "refs/heads/main", "refs/tags/v1", 1), "s3cret"), http.StatusNoContent, ""},
+		{"github unknown repo", githubRequest("push", strings.Replace(githubPush, "zoekt", "other", 1), "s3cret"), http.StatusNotFound, ""},
+		{"gitlab push", gitlabRequest("Push Hook", gitlabPush, "s3cret"), http.StatusAccepted, filepath.Join(repoDir, "gitlab.com", "group", "sub", "project.git")},
+		{"gitlab bad token", gitlabRequest("Push Hook", gitlabPush, "wrong"), http.StatusBadRequest, ""},
+		{"unsupported", httptest.NewRequest("POST", "/webhook", strings.NewReader(githubPush)), http.StatusBadRequest, ""},
+		{"github host outside repoDir", githubRequest("push", strings.Replace(githubPush, "github.com", "..", 1), "s3cret"), http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tc.req)
+			if w.Code != tc.code {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.code, w.Body.String())
+			}
+			if tc.dir == "" {
+				return
+			}
+			if got := <-fetched; got != tc.dir {
+				t.Errorf("fetched %s, want %s", got, tc.dir)
+			}
+			if got := queue.Pop(); got != tc.dir {
+				t.Errorf("queued %s, want %s", got, tc.dir)
+			}
+		})
+	}
+}
+
+func TestRepoDirFor(t *testing.T) {
+	for _, u := range []string{"https://github.com/", "https://github.com/a/../../etc", "not a url", "https://../x", "https://..", `https://a\..\..\x/y`} {
+		if dir, err := repoDirFor("/repos", u); err == nil {
+			t.Errorf("repoDirFor(%q) = %s, want error", u, dir)
+		}
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	h := &webhookHandler{
+		repoDir: t.TempDir(),
+		fetch: func(dir string) bool {
+			t.Errorf("fetched %s without a secret", dir)
+			return false
+		},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, gitlabRequest("Push Hook", `{"ref": "refs/heads/main", "project": {"web_url": "https://gitlab.com/group/project"}}`, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
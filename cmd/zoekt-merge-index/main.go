@@ -47,6 +47,9 @@ func merge(dstDir string, names []string) (string, error) {
 				return "", fmt.Errorf("zoekt-merge-index: failed to remove simple shard: %w", err)
 			}
 		}
+		if err := zoekt.PruneShardManifest(name); err != nil {
+			return "", fmt.Errorf("zoekt-merge-index: %w", err)
+		}
 	}
 
 	// We only rename the compound shard if all simple shards could be deleted in the
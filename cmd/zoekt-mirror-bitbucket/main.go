@@ -0,0 +1,382 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This binary fetches all repos of a Bitbucket Cloud workspace, or of a
+// Bitbucket Server (Data Center) instance, and clones them.
+//
+// Credentials are read from the file given with --credentials. It holds
+// either a user name and an app password (Bitbucket Cloud) or HTTP access
+// token (Bitbucket Server), separated by whitespace, or a single OAuth access
+// token.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/gitindex"
+)
+
+func main() {
+	dest := flag.String("dest", "", "destination directory")
+	serverURL := flag.String("url", "https://bitbucket.org", "Bitbucket URL: https://bitbucket.org for Bitbucket Cloud, or the URL of a Bitbucket Server instance.")
+	workspace := flag.String("workspace", "", "Bitbucket Cloud workspace to mirror. If not set, mirror all repos the user is a member of.")
+	project := flag.String("project", "", "only mirror repos of the project with this key.")
+	credentialsFile := flag.String("credentials", "", "file holding the user name and app password or access token, or a single OAuth token.")
+	deleteRepos := flag.Bool("delete", false, "delete missing repos")
+	namePattern := flag.String("name", "", "only clone repos whose name matches the given regexp.")
+	excludePattern := flag.String("exclude", "", "don't mirror repos whose names match this regexp.")
+	noPrivate := flag.Bool("no_private", false, "don't mirror private repos.")
+	flag.Parse()
+
+	if *dest == "" {
+		log.Fatal("must set --dest")
+	}
+
+	rootURL, err := url.Parse(*serverURL)
+	if err != nil {
+		log.Fatalf("url.Parse(): %v", err)
+	}
+
+	if *deleteRepos && isCloud(rootURL) && *project != "" {
+		// Other projects of the workspace are stored in the same directory.
+		log.Fatal("can't use --delete with --project for Bitbucket Cloud")
+	}
+
+	var creds credentials
+	if *credentialsFile != "" {
+		content, err := os.ReadFile(*credentialsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		creds, err = parseCredentials(string(content))
+		if err != nil {
+			log.Fatalf("%s: %v", *credentialsFile, err)
+		}
+	}
+
+	c := &client{
+		http:  &http.Client{Timeout: time.Minute},
+		creds: creds,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var repos []repo
+	if isCloud(rootURL) {
+		repos, err = c.listCloudRepos(ctx, cloudAPIURL(rootURL), *workspace)
+	} else {
+		repos, err = c.listServerRepos(ctx, rootURL, *project)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	trimmed := repos[:0]
+	for _, r := range repos {
+		if !filter.Include(r.Slug) {
+			continue
+		}
+		if *project != "" && r.Project != *project {
+			continue
+		}
+		if *noPrivate && r.Private {
+			continue
+		}
+		trimmed = append(trimmed, r)
+	}
+	repos = trimmed
+
+	destDir := filepath.Join(*dest, rootURL.Host)
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if err := cloneRepos(destDir, rootURL, repos, creds); err != nil {
+		log.Fatalf("cloneRepos: %v", err)
+	}
+
+	if *deleteRepos {
+		// Repos are stored as HOST/WORKSPACE/SLUG.git for Bitbucket Cloud and
+		// HOST/PROJECT/SLUG.git for Bitbucket Server.
+		prefix := url.URL{Scheme: rootURL.Scheme, Host: rootURL.Host, Path: *workspace}
+		if !isCloud(rootURL) {
+			prefix.Path = *project
+		}
+		names := map[string]struct{}{}
+		for _, r := range repos {
+			names[filepath.Join(rootURL.Host, r.FullName+".git")] = struct{}{}
+		}
+		if err := gitindex.DeleteRepos(*dest, &prefix, names, filter); err != nil {
+			log.Fatalf("deleteRepos: %v", err)
+		}
+	}
+}
+
+// repo is a Bitbucket repository, from either the Cloud or Server API.
+type repo struct {
+	// Project is the key of the project of the repo.
+	Project string
+	Slug    string
+	// FullName is eg. workspace/slug for Bitbucket Cloud, and PROJECT/slug for
+	// Bitbucket Server.
+	FullName string
+	Private  bool
+	WebURL   string
+	CloneURL string
+}
+
+type credentials struct {
+	user     string
+	password string
+	token    string
+}
+
+func parseCredentials(s string) (credentials, error) {
+	switch f := strings.Fields(s); len(f) {
+	case 1:
+		return credentials{token: f[0]}, nil
+	case 2:
+		return credentials{user: f[0], password: f[1]}, nil
+	default:
+		return credentials{}, fmt.Errorf("want USER PASSWORD or a single TOKEN, got %d fields", len(f))
+	}
+}
+
+func isCloud(u *url.URL) bool {
+	return u.Host == "bitbucket.org" || u.Host == "api.bitbucket.org"
+}
+
+func cloudAPIURL(u *url.URL) *url.URL {
+	return &url.URL{Scheme: u.Scheme, Host: "api.bitbucket.org", Path: "/2.0"}
+}
+
+type client struct {
+	http  *http.Client
+	creds credentials
+}
+
+// get fetches u and decodes the JSON response into v.
+func (c *client) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.creds.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.creds.token)
+	} else if c.creds.user != "" {
+		req.SetBasicAuth(c.creds.user, c.creds.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type link struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// listCloudRepos lists the repos of workspace using the Bitbucket Cloud 2.0
+// API. If workspace is empty, it lists the repos the user is a member of.
+func (c *client) listCloudRepos(ctx context.Context, api *url.URL, workspace string) ([]repo, error) {
+	u := *api
+	q := url.Values{"pagelen": {"100"}}
+	if workspace != "" {
+		u.Path = path.Join(u.Path, "repositories", workspace)
+	} else {
+		u.Path = path.Join(u.Path, "repositories")
+		q.Set("role", "member")
+	}
+	u.RawQuery = q.Encode()
+
+	var repos []repo
+	for next := u.String(); next != ""; {
+		var page struct {
+			Values []struct {
+				Slug      string `json:"slug"`
+				FullName  string `json:"full_name"`
+				IsPrivate bool   `json:"is_private"`
+				Project   struct {
+					Key string `json:"key"`
+				} `json:"project"`
+				Links struct {
+					HTML  link   `json:"html"`
+					Clone []link `json:"clone"`
+				} `json:"links"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		if err := c.get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+		for _, v := range page.Values {
+			r := repo{
+				Project:  v.Project.Key,
+				Slug:     v.Slug,
+				FullName: v.FullName,
+				Private:  v.IsPrivate,
+				WebURL:   v.Links.HTML.Href,
+			}
+			for _, l := range v.Links.Clone {
+				if l.Name == "https" {
+					r.CloneURL = l.Href
+				}
+			}
+			repos = append(repos, r)
+		}
+		next = page.Next
+	}
+	return repos, nil
+}
+
+// listServerRepos lists the repos of project using the Bitbucket Server REST
+// API. If project is empty, it lists all repos visible to the user.
+func (c *client) listServerRepos(ctx context.Context, root *url.URL, project string) ([]repo, error) {
+	u := *root
+	if project != "" {
+		u.Path = path.Join(u.Path, "rest/api/1.0/projects", project, "repos")
+	} else {
+		u.Path = path.Join(u.Path, "rest/api/1.0/repos")
+	}
+
+	var repos []repo
+	for start, last := 0, false; !last; {
+		u.RawQuery = url.Values{"limit": {"1000"}, "start": {strconv.Itoa(start)}}.Encode()
+		var page struct {
+			Values []struct {
+				Slug    string `json:"slug"`
+				Public  bool   `json:"public"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+				Links struct {
+					Self  []link `json:"self"`
+					Clone []link `json:"clone"`
+				} `json:"links"`
+			} `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+		if err := c.get(ctx, u.String(), &page); err != nil {
+			return nil, err
+		}
+		for _, v := range page.Values {
+			r := repo{
+				Project:  v.Project.Key,
+				Slug:     v.Slug,
+				FullName: path.Join(v.Project.Key, v.Slug),
+				Private:  !v.Public,
+			}
+			if len(v.Links.Self) > 0 {
+				r.WebURL = v.Links.Self[0].Href
+			}
+			for _, l := range v.Links.Clone {
+				// The "http" clone link may also be https.
+				if l.Name == "http" {
+					r.CloneURL = l.Href
+				}
+			}
+			repos = append(repos, r)
+		}
+		start, last = page.NextPageStart, page.IsLastPage || len(page.Values) == 0
+	}
+	return repos, nil
+}
+
+// cloneURL returns the clone URL of r, with credentials to fetch it.
+func cloneURL(r repo, creds credentials, cloud bool) (string, error) {
+	u, err := url.Parse(r.CloneURL)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case creds.user != "":
+		u.User = url.UserPassword(creds.user, creds.password)
+	case creds.token != "" && cloud:
+		u.User = url.UserPassword("x-token-auth", creds.token)
+	default:
+		u.User = nil
+	}
+	return u.String(), nil
+}
+
+func cloneRepos(destDir string, root *url.URL, repos []repo, creds credentials) error {
+	cloud := isCloud(root)
+	for _, r := range repos {
+		if r.CloneURL == "" {
+			log.Printf("%s: no https clone URL, skipping", r.FullName)
+			continue
+		}
+
+		webURLType := "bitbucket-server"
+		if cloud {
+			webURLType = "bitbucket"
+		}
+		config := map[string]string{
+			"zoekt.web-url-type": webURLType,
+			"zoekt.web-url":      r.WebURL,
+			"zoekt.name":         filepath.Join(root.Host, r.FullName),
+			"zoekt.public":       marshalBool(!r.Private),
+		}
+		if creds.token != "" && !cloud {
+			// Bitbucket Server does not accept tokens without a user name in
+			// the URL.
+			config["http.extraHeader"] = "Authorization: Bearer " + creds.token
+		}
+
+		u, err := cloneURL(r, creds, cloud)
+		if err != nil {
+			return err
+		}
+		dest, err := gitindex.CloneRepo(destDir, r.FullName, u, config)
+		if err != nil {
+			return err
+		}
+		if dest != "" {
+			fmt.Println(dest)
+		}
+	}
+	return nil
+}
+
+func marshalBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
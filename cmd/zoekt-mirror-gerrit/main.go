@@ -98,6 +98,8 @@ func main() {
 	fetchMetaConfig := flag.Bool("fetch-meta-config", false, "fetch gerrit meta/config branch")
 	httpCrendentialsPath := flag.String("http-credentials", "", "path to a file containing http credentials stored like 'user:password'.")
 	active := flag.Bool("active", false, "mirror only active projects")
+	indexChanges := flag.Bool("index-changes", false, "fetch the current patch set of open changes as branches changes/<number>. Index them by passing -branches=HEAD,changes/* to zoekt-git-index.")
+	maxChanges := flag.Int("max-changes", 50, "fetch at most this many open changes per project, most recently updated first. Zoekt indexes at most 64 branches per repository.")
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
@@ -227,6 +229,15 @@ func main() {
 				log.Fatalf("addMetaConfigFetch: %v", err)
 			}
 		}
+		if *indexChanges {
+			changes, err := openChanges(ctx, client, k, *maxChanges)
+			if err != nil {
+				log.Fatalf("openChanges(%s): %v", k, err)
+			}
+			if err := setChangeFetches(filepath.Join(*dest, name+".git"), changes); err != nil {
+				log.Fatalf("setChangeFetches: %v", err)
+			}
+		}
 	}
 	if *deleteRepos {
 		if err := deleteStaleRepos(*dest, filter, projects, projectURL); err != nil {
@@ -299,3 +310,63 @@ func addMetaConfigFetch(repoDir string) error {
 
 	return nil
 }
+
+// openChanges returns the open changes of project, most recently updated
+// first.
+func openChanges(ctx context.Context, client *gerrit.Client, project string, limit int) ([]gerrit.ChangeInfo, error) {
+	changes, _, err := client.Changes.QueryChanges(ctx, &gerrit.QueryChangeOptions{
+		QueryOptions: gerrit.QueryOptions{
+			Query: []string{fmt.Sprintf("status:open project:%q", project)},
+			Limit: limit,
+		},
+		ChangeOptions: gerrit.ChangeOptions{
+			AdditionalFields: []string{"CURRENT_REVISION"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return *changes, nil
+}
+
+// changeRefPrefix is where we store the current patch set of open changes.
+// These refs live below refs/heads/, so "git fetch --prune" removes them
+// once we stop fetching them.
+const changeRefPrefix = "refs/heads/changes/"
+
+// setChangeFetches replaces the refspecs for changes in the origin remote of
+// the repository at repoDir with refspecs for the current patch set of each of
+// changes.
+func setChangeFetches(repoDir string, changes []gerrit.ChangeInfo) error {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+
+	rm := cfg.Remotes["origin"]
+	if rm == nil {
+		return nil
+	}
+
+	var fetch []config.RefSpec
+	for _, rs := range rm.Fetch {
+		if !strings.Contains(string(rs), ":"+changeRefPrefix) {
+			fetch = append(fetch, rs)
+		}
+	}
+	for _, c := range changes {
+		rev, ok := c.Revisions[c.CurrentRevision]
+		if !ok || rev.Ref == "" {
+			continue
+		}
+		fetch = append(fetch, config.RefSpec(fmt.Sprintf("+%s:%s%d", rev.Ref, changeRefPrefix, c.Number)))
+	}
+	rm.Fetch = fetch
+
+	return repo.Storer.SetConfig(cfg)
+}
@@ -12,10 +12,11 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// This binary fetches all repos of a user or organization and clones
-// them.  It is strongly recommended to get a personal API token from
-// https://gitea.com/user/settings/applications, save the token in a
-// file, and point the --token option to it.
+// This binary fetches all repos of a user or organization on a Gitea or
+// Forgejo instance and clones them.  It is strongly recommended to get a
+// personal API token from https://gitea.com/user/settings/applications,
+// save the token in a file, and point the --token option to it. Without
+// --org and --user, it mirrors all repos the token has access to.
 package main
 
 import (
@@ -45,7 +46,9 @@ func (f *topicsFlag) Set(value string) error {
 }
 
 type reposFilters struct {
-	noArchived *bool
+	topics        []string
+	excludeTopics []string
+	noArchived    *bool
 }
 
 func main() {
@@ -71,25 +74,31 @@ func main() {
 	if *dest == "" {
 		log.Fatal("must set --dest")
 	}
-	if *giteaURL == "" && *org == "" && *user == "" {
-		log.Fatal("must set either --org or --user when gitea.com is used as host")
-	}
 
-	var host string
-	var client *gitea.Client
-	clientOptions := []gitea.ClientOption{}
+	rootURL, err := url.Parse(*giteaURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rootURL.Host == "" {
+		log.Fatalf("--url %q has no host", *giteaURL)
+	}
 
-	destDir := filepath.Join(*dest, host)
+	destDir := filepath.Join(*dest, rootURL.Host)
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
 		log.Fatal(err)
 	}
 
+	var apiToken string
+	clientOptions := []gitea.ClientOption{}
 	if *token != "" {
 		content, err := os.ReadFile(*token)
-		if err != nil {
+		if err != nil && !(os.IsNotExist(err) && !isFlagSet("token")) {
 			log.Fatal(err)
 		}
-		clientOptions = append(clientOptions, gitea.SetToken(string(content)))
+		apiToken = strings.TrimSpace(string(content))
+	}
+	if apiToken != "" {
+		clientOptions = append(clientOptions, gitea.SetToken(apiToken))
 	}
 	client, err := gitea.NewClient(*giteaURL, clientOptions...)
 	if err != nil {
@@ -97,7 +106,9 @@ func main() {
 	}
 
 	reposFilters := reposFilters{
-		noArchived: noArchived,
+		topics:        topics,
+		excludeTopics: excludeTopics,
+		noArchived:    noArchived,
 	}
 	var repos []*gitea.Repository
 	switch {
@@ -108,8 +119,11 @@ func main() {
 		log.Printf("fetch repos for user: %s", *user)
 		repos, err = getUserRepos(client, *user, reposFilters)
 	default:
-		log.Printf("no user or org specified, cloning all repos.")
-		repos, err = getUserRepos(client, "", reposFilters)
+		if apiToken == "" {
+			log.Fatal("must set --org or --user, or --token to mirror all repos the token has access to")
+		}
+		log.Printf("no user or org specified, cloning all accessible repos.")
+		repos, err = getMyRepos(client, reposFilters)
 	}
 
 	if err != nil {
@@ -136,7 +150,6 @@ func main() {
 		trimmed := []*gitea.Repository{}
 		for _, r := range repos {
 			if !filter.Include(r.Name) {
-				log.Println(r.Name)
 				continue
 			}
 			trimmed = append(trimmed, r)
@@ -144,7 +157,7 @@ func main() {
 		repos = trimmed
 	}
 
-	if err := cloneRepos(destDir, repos); err != nil {
+	if err := cloneRepos(destDir, repos, apiToken); err != nil {
 		log.Fatalf("cloneRepos: %v", err)
 	}
 
@@ -183,29 +196,60 @@ func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*gitea.Re
 	return nil
 }
 
-func filterRepositories(repos []*gitea.Repository, noArchived bool) (filteredRepos []*gitea.Repository) {
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func hasIntersection(s1, s2 []string) bool {
+	hash := make(map[string]bool)
+	for _, e := range s1 {
+		hash[e] = true
+	}
+	for _, e := range s2 {
+		if hash[e] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRepositories drops archived repos if noArchived is set, and repos
+// that don't match the topic filters. The list APIs don't return topics, so
+// we only fetch them if there are topic filters.
+func filterRepositories(client *gitea.Client, repos []*gitea.Repository, reposFilters reposFilters) ([]*gitea.Repository, error) {
+	var filteredRepos []*gitea.Repository
 	for _, repo := range repos {
-		if noArchived && repo.Archived {
+		if *reposFilters.noArchived && repo.Archived {
 			continue
 		}
+		if len(reposFilters.topics) > 0 || len(reposFilters.excludeTopics) > 0 {
+			topics, _, err := client.ListRepoTopics(repo.Owner.UserName, repo.Name, gitea.ListRepoTopicsOptions{ListOptions: gitea.ListOptions{Page: -1}})
+			if err != nil {
+				return nil, fmt.Errorf("ListRepoTopics(%s): %w", repo.FullName, err)
+			}
+			if (len(reposFilters.topics) > 0 && !hasIntersection(reposFilters.topics, topics)) ||
+				hasIntersection(reposFilters.excludeTopics, topics) {
+				continue
+			}
+		}
 		filteredRepos = append(filteredRepos, repo)
 	}
-	return
+	return filteredRepos, nil
 }
 
-func getOrgRepos(client *gitea.Client, org string, reposFilters reposFilters) ([]*gitea.Repository, error) {
+// listAll calls list for each page until there are no more pages, and returns
+// the filtered repos.
+func listAll(client *gitea.Client, reposFilters reposFilters, list func(gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error)) ([]*gitea.Repository, error) {
 	var allRepos []*gitea.Repository
-	searchOptions := &gitea.SearchRepoOptions{}
-	// OwnerID
-	organization, _, err := client.GetOrg(org)
-	if err != nil {
-		return nil, err
-	}
-
-	searchOptions.OwnerID = organization.ID
-
+	opt := gitea.ListOptions{Page: 1, PageSize: 50}
 	for {
-		repos, resp, err := client.SearchRepos(*searchOptions)
+		repos, resp, err := list(opt)
 		if err != nil {
 			return nil, err
 		}
@@ -213,43 +257,39 @@ func getOrgRepos(client *gitea.Client, org string, reposFilters reposFilters) ([
 			break
 		}
 
-		searchOptions.Page = resp.NextPage
-		repos = filterRepositories(repos, *reposFilters.noArchived)
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
-		}
-	}
-	return allRepos, nil
-}
-
-func getUserRepos(client *gitea.Client, user string, reposFilters reposFilters) ([]*gitea.Repository, error) {
-	var allRepos []*gitea.Repository
-	searchOptions := &gitea.SearchRepoOptions{}
-	u, _, err := client.GetUserInfo(user)
-	if err != nil {
-		return nil, err
-	}
-	searchOptions.OwnerID = u.ID
-	for {
-		repos, resp, err := client.SearchRepos(*searchOptions)
+		repos, err = filterRepositories(client, repos, reposFilters)
 		if err != nil {
 			return nil, err
 		}
-		if len(repos) == 0 {
-			break
-		}
-		repos = filterRepositories(repos, *reposFilters.noArchived)
 		allRepos = append(allRepos, repos...)
-		searchOptions.Page = resp.NextPage
 		if resp.NextPage == 0 {
 			break
 		}
+		opt.Page = resp.NextPage
 	}
 	return allRepos, nil
 }
 
-func cloneRepos(destDir string, repos []*gitea.Repository) error {
+func getOrgRepos(client *gitea.Client, org string, reposFilters reposFilters) ([]*gitea.Repository, error) {
+	return listAll(client, reposFilters, func(opt gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+		return client.ListOrgRepos(org, gitea.ListOrgReposOptions{ListOptions: opt})
+	})
+}
+
+func getUserRepos(client *gitea.Client, user string, reposFilters reposFilters) ([]*gitea.Repository, error) {
+	return listAll(client, reposFilters, func(opt gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+		return client.ListUserRepos(user, gitea.ListReposOptions{ListOptions: opt})
+	})
+}
+
+// getMyRepos returns all repos the authenticated user has access to.
+func getMyRepos(client *gitea.Client, reposFilters reposFilters) ([]*gitea.Repository, error) {
+	return listAll(client, reposFilters, func(opt gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+		return client.ListMyRepos(gitea.ListReposOptions{ListOptions: opt})
+	})
+}
+
+func cloneRepos(destDir string, repos []*gitea.Repository, token string) error {
 	for _, r := range repos {
 		host, err := url.Parse(r.HTMLURL)
 		if err != nil {
@@ -269,7 +309,11 @@ func cloneRepos(destDir string, repos []*gitea.Repository) error {
 
 			"zoekt.archived": marshalBool(r.Archived),
 			"zoekt.fork":     marshalBool(r.Fork),
-			"zoekt.public":   marshalBool(r.Private || r.Internal), // count internal repos as private
+			"zoekt.public":   marshalBool(!r.Private && !r.Internal), // count internal repos as private
+		}
+		if token != "" && (r.Private || r.Internal) {
+			// Keep the token out of the remote URL, which ends up in logs.
+			config["http.extraHeader"] = "Authorization: token " + token
 		}
 		dest, err := gitindex.CloneRepo(destDir, r.FullName, r.CloneURL, config)
 		if err != nil {
@@ -0,0 +1,57 @@
+// Command zoekt-perforce-index indexes a Perforce (Helix Core) depot path.
+//
+// It runs the p4 command line client, so P4PORT, P4USER and a ticket must be
+// set up in the environment. The branch version is the number of the indexed
+// changelist.
+//
+// Example:
+//
+//	zoekt-perforce-index -incremental -name perforce.example.com/project //depot/project/main/...
+package main
+
+import (
+	"flag"
+	"log"
+
+	"go.uber.org/automaxprocs/maxprocs"
+
+	"github.com/sourcegraph/zoekt/cmd"
+	"github.com/sourcegraph/zoekt/internal/perforce"
+)
+
+func main() {
+	var (
+		incremental = flag.Bool("incremental", true, "only index changed depot paths")
+
+		name       = flag.String("name", "", "The repository name for the depot path. Defaults to the depot path without the leading slashes")
+		urlRaw     = flag.String("url", "", "The repository URL for the depot path")
+		branch     = flag.String("branch", "HEAD", "The branch name for the depot path")
+		changelist = flag.Int("changelist", 0, "The changelist to index. Defaults to the latest submitted changelist. If incremental this will avoid updating shards already at this changelist")
+		p4         = flag.String("p4", "p4", "The p4 binary")
+	)
+	flag.Parse()
+
+	// Tune GOMAXPROCS to match Linux container CPU quota.
+	_, _ = maxprocs.Set()
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if len(flag.Args()) != 1 {
+		log.Fatal("expected argument for depot path, eg. //depot/project/...")
+	}
+	bopts := cmd.OptionsFromFlags()
+	opts := perforce.Options{
+		Incremental: *incremental,
+
+		Depot:      flag.Arg(0),
+		Name:       *name,
+		RepoURL:    *urlRaw,
+		Branch:     *branch,
+		Changelist: *changelist,
+		P4:         *p4,
+	}
+
+	if err := perforce.Index(opts, *bopts); err != nil {
+		log.Fatal(err)
+	}
+}
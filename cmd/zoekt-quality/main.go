@@ -0,0 +1,93 @@
+// Command zoekt-quality evaluates search ranking against labeled judgments.
+//
+// It runs each query of a judgments file against an index and reports the
+// NDCG and reciprocal rank per query, and their means. Compare the reports of
+// two builds, or of different ranking flags, to evaluate ranking changes.
+//
+// Example:
+//
+//	zoekt-quality -index_dir ~/.zoekt -k 10 -bm25 judgments.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/quality"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func main() {
+	index := flag.String("index_dir", filepath.Join(os.Getenv("HOME"), ".zoekt"), "search for index files in `directory`")
+	k := flag.Int("k", 10, "score the top k results of each query")
+	bm25 := flag.Bool("bm25", false, "rank with BM25 instead of the default scoring")
+	jsonOut := flag.Bool("json", false, "write the report as JSON")
+	verbose := flag.Bool("v", false, "print some background data")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] JUDGMENTS\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "JUDGMENTS is a JSON array of queries with their relevant documents:\n\n"+
+			`  [{"query": "func main", "relevant": [{"repo": "github.com/a/b", "file": "main.go", "grade": 2}]}]`+"\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	judgments, err := quality.ReadJudgments(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(*index)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer searcher.Close()
+
+	report, err := quality.Evaluate(context.Background(), searcher, judgments, quality.Options{
+		K: *k,
+		SearchOptions: zoekt.SearchOptions{
+			UseBM25Scoring: *bm25,
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "QUERY\tNDCG@%d\tRR\tRANKS\n", report.K)
+	for _, q := range report.Queries {
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%v\n", q.Query, q.NDCG, q.ReciprocalRank, q.Ranks)
+	}
+	fmt.Fprintf(w, "MEAN\t%.3f\t%.3f\t\n", report.NDCG, report.MRR)
+	_ = w.Flush()
+}
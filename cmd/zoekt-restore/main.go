@@ -0,0 +1,69 @@
+// zoekt-restore restores an index directory from a snapshot written by
+// zoekt-backup. Without -snapshot or -at, it restores the latest snapshot.
+//
+//	zoekt-restore -store /mnt/backups/zoekt -list
+//	zoekt-restore -store /mnt/backups/zoekt -index /data/index -at 2024-05-01T00:00:00Z
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sourcegraph/zoekt/internal/backup"
+)
+
+func main() {
+	index := flag.String("index", "", "index directory to restore into")
+	storeFlag := flag.String("store", "", "backup store: a directory, or an http(s) URL. The environment variable ZOEKT_BACKUP_AUTHORIZATION is sent as Authorization header.")
+	snapshot := flag.String("snapshot", "", "ID of the snapshot to restore")
+	at := flag.String("at", "", "restore the latest snapshot taken at or before this RFC 3339 time")
+	list := flag.Bool("list", false, "list the snapshots in the store and exit")
+	flag.Parse()
+
+	if *storeFlag == "" {
+		log.Fatal("usage: zoekt-restore -store DIR|URL (-list | -index DIR [-snapshot ID | -at TIME])")
+	}
+	ctx := context.Background()
+	store := backup.NewStore(*storeFlag)
+
+	if *list {
+		infos, err := backup.ListSnapshots(ctx, store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, info := range infos {
+			fmt.Printf("%s\t%s\n", info.ID, info.Time.Format(time.RFC3339))
+		}
+		return
+	}
+
+	if *index == "" {
+		log.Fatal("-index is required")
+	}
+	if *snapshot != "" && *at != "" {
+		log.Fatal("-snapshot and -at are mutually exclusive")
+	}
+
+	id := *snapshot
+	if id == "" {
+		t := time.Now()
+		if *at != "" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, *at); err != nil {
+				log.Fatalf("-at: %v", err)
+			}
+		}
+		var err error
+		if id, err = backup.SnapshotAt(ctx, store, t); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := backup.Restore(ctx, store, id, *index); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("restored snapshot %s into %s", id, *index)
+}
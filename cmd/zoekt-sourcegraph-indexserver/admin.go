@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobRegistry tracks the index jobs in progress so they can be canceled. The
+// zero value is ready to use.
+type jobRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	// cancels maps repository IDs to the jobs in progress for them, by job
+	// ID. Several jobs may index the same repository, eg. one started by
+	// /debug/reindex next to one from the queue.
+	cancels map[uint32]map[uint64]context.CancelFunc
+}
+
+// start registers an index job for repoID. The job must call done once it
+// finishes.
+func (r *jobRegistry) start(repoID uint32) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	if r.cancels == nil {
+		r.cancels = map[uint32]map[uint64]context.CancelFunc{}
+	}
+	if r.cancels[repoID] == nil {
+		r.cancels[repoID] = map[uint64]context.CancelFunc{}
+	}
+	r.nextID++
+	jobID := r.nextID
+	r.cancels[repoID][jobID] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels[repoID], jobID)
+		if len(r.cancels[repoID]) == 0 {
+			delete(r.cancels, repoID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels all index jobs for repoID. It returns false if no job is in
+// progress for repoID.
+func (r *jobRegistry) cancel(repoID uint32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := r.cancels[repoID]
+	for _, cancel := range jobs {
+		cancel()
+	}
+	return len(jobs) > 0
+}
+
+// addAdminHandlers adds the admin API, which lets operators control indexing
+// without restarting indexserver or touching the index directory. All
+// endpoints take POST requests and require the admin token as bearer token:
+//
+//	/admin/reindex  reindex repo from scratch as the next job
+//	/admin/cancel   cancel the index jobs in progress for repo
+//	/admin/pause    stop starting index jobs
+//	/admin/resume   start index jobs again
+//
+// The admin API is only served if s.adminToken is set.
+func (s *Server) addAdminHandlers(mux *http.ServeMux) {
+	if s.adminToken == "" {
+		return
+	}
+
+	mux.Handle("/admin/reindex", s.requireAdminToken(http.HandlerFunc(s.handleAdminReindex)))
+	mux.Handle("/admin/cancel", s.requireAdminToken(http.HandlerFunc(s.handleAdminCancel)))
+	mux.Handle("/admin/pause", s.requireAdminToken(http.HandlerFunc(s.handleAdminPause)))
+	mux.Handle("/admin/resume", s.requireAdminToken(http.HandlerFunc(s.handleAdminResume)))
+}
+
+// requireAdminToken only passes POST requests with the admin token on to h.
+func (s *Server) requireAdminToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminReindex moves the repository with the ID given by the parameter
+// repo to the front of the queue, and responds with 202. Unlike
+// /debug/reindex, the job runs on the queue, so it counts towards the index
+// concurrency and can be canceled with /admin/cancel.
+func (s *Server) handleAdminReindex(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRepoID(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.queue.Force(id) {
+		http.Error(w, fmt.Sprintf("repository %d not found", id), http.StatusNotFound)
+		return
+	}
+	infoLog.Printf("admin: reindex %d", id)
+
+	// 202 Accepted
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleAdminCancel cancels the index jobs in progress for the repository
+// with the ID given by the parameter repo. It responds with 404 if there are
+// none. Canceled jobs count as failed.
+func (s *Server) handleAdminCancel(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRepoID(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.jobs.cancel(id) {
+		http.Error(w, fmt.Sprintf("no index job running for repository %d", id), http.StatusNotFound)
+		return
+	}
+	infoLog.Printf("admin: canceled index job for %d", id)
+}
+
+// handleAdminPause pauses indexing by creating the PAUSE file in the index
+// directory, so the pause outlasts restarts. Index jobs in progress run to
+// completion.
+func (s *Server) handleAdminPause(w http.ResponseWriter, r *http.Request) {
+	msg := fmt.Sprintf("paused via admin API at %s\n", time.Now().UTC().Format(time.RFC3339))
+	if err := os.WriteFile(filepath.Join(s.IndexDir, pauseFileName), []byte(msg), 0o644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infoLog.Print("admin: paused indexing")
+}
+
+// handleAdminResume resumes indexing by removing the PAUSE file from the index
+// directory.
+func (s *Server) handleAdminResume(w http.ResponseWriter, r *http.Request) {
+	if err := os.Remove(filepath.Join(s.IndexDir, pauseFileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infoLog.Print("admin: resumed indexing")
+}
+
+// parseRepoID returns the repository ID given by the form parameter repo. If
+// it is missing or invalid, parseRepoID responds with 400 and returns false.
+func parseRepoID(w http.ResponseWriter, r *http.Request) (uint32, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(r.Form.Get("repo"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return uint32(id), true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/log/logtest"
+)
+
+func TestAdminAPI(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{IndexDir: dir, adminToken: "secret", queue: *NewQueue(0, 0, logtest.Scoped(t))}
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "foo"})
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 2, Name: "bar"})
+
+	mux := http.NewServeMux()
+	s.addAdminHandlers(mux)
+
+	do := func(method, target, token, form string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("auth", func(t *testing.T) {
+		for _, token := range []string{"", "wrong", "secret2"} {
+			if w := do(http.MethodPost, "/admin/pause", token, ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("token %q: got %d, want %d", token, w.Code, http.StatusUnauthorized)
+			}
+		}
+		if w := do(http.MethodGet, "/admin/pause", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET: got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+		if _, err := os.Stat(filepath.Join(dir, pauseFileName)); err == nil {
+			t.Fatal("unauthorized request paused indexing")
+		}
+	})
+
+	t.Run("reindex", func(t *testing.T) {
+		if w := do(http.MethodPost, "/admin/reindex", "secret", "repo=2"); w.Code != http.StatusAccepted {
+			t.Fatalf("got %d %q", w.Code, w.Body.String())
+		}
+		item, ok := s.queue.Pop()
+		if !ok || item.Opts.RepoID != 2 || !item.Force {
+			t.Fatalf("got %+v, want forced repo 2 first", item)
+		}
+
+		for form, want := range map[string]int{
+			"repo=3":   http.StatusNotFound,
+			"repo=foo": http.StatusBadRequest,
+		} {
+			if w := do(http.MethodPost, "/admin/reindex", "secret", form); w.Code != want {
+				t.Errorf("%s: got %d, want %d", form, w.Code, want)
+			}
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		if w := do(http.MethodPost, "/admin/cancel", "secret", "repo=1"); w.Code != http.StatusNotFound {
+			t.Fatalf("no job: got %d", w.Code)
+		}
+
+		ctx, done := s.jobs.start(1)
+		defer done()
+		if w := do(http.MethodPost, "/admin/cancel", "secret", "repo=1"); w.Code != http.StatusOK {
+			t.Fatalf("got %d %q", w.Code, w.Body.String())
+		}
+		if ctx.Err() == nil {
+			t.Fatal("job was not canceled")
+		}
+	})
+
+	t.Run("cancel concurrent jobs", func(t *testing.T) {
+		// Finishing one job doesn't forget the other job for the same
+		// repository.
+		_, done1 := s.jobs.start(2)
+		ctx2, done2 := s.jobs.start(2)
+		defer done2()
+		done1()
+		if w := do(http.MethodPost, "/admin/cancel", "secret", "repo=2"); w.Code != http.StatusOK {
+			t.Fatalf("got %d %q", w.Code, w.Body.String())
+		}
+		if ctx2.Err() == nil {
+			t.Fatal("second job was not canceled")
+		}
+
+		// Both jobs are canceled.
+		ctx3, done3 := s.jobs.start(2)
+		defer done3()
+		ctx4, done4 := s.jobs.start(2)
+		defer done4()
+		if w := do(http.MethodPost, "/admin/cancel", "secret", "repo=2"); w.Code != http.StatusOK {
+			t.Fatalf("got %d %q", w.Code, w.Body.String())
+		}
+		if ctx3.Err() == nil || ctx4.Err() == nil {
+			t.Fatal("not all jobs were canceled")
+		}
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		pauseFile := filepath.Join(dir, pauseFileName)
+
+		if w := do(http.MethodPost, "/admin/pause", "secret", ""); w.Code != http.StatusOK {
+			t.Fatalf("pause: got %d %q", w.Code, w.Body.String())
+		}
+		if _, err := os.Stat(pauseFile); err != nil {
+			t.Fatalf("pause file missing: %v", err)
+		}
+
+		// Resuming twice is fine.
+		for i := 0; i < 2; i++ {
+			if w := do(http.MethodPost, "/admin/resume", "secret", ""); w.Code != http.StatusOK {
+				t.Fatalf("resume: got %d %q", w.Code, w.Body.String())
+			}
+		}
+		if _, err := os.Stat(pauseFile); !os.IsNotExist(err) {
+			t.Fatalf("pause file still present: %v", err)
+		}
+	})
+}
+
+func TestAdminAPIDisabled(t *testing.T) {
+	s := &Server{IndexDir: t.TempDir()}
+	mux := http.NewServeMux()
+	s.addAdminHandlers(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -294,6 +294,9 @@ func removeAll(shards ...shard) {
 				debugLog.Printf("failed to remove shard file %s: %v", p, err)
 			}
 		}
+		if err := zoekt.PruneShardManifest(shard.Path); err != nil {
+			debugLog.Printf("failed to prune manifest of shard %s: %v", shard.Path, err)
+		}
 	}
 }
 
@@ -336,6 +339,10 @@ func moveAll(dstDir string, shards []shard) {
 			return
 		}
 
+		if err := zoekt.PruneShardManifest(shard.Path); err != nil {
+			debugLog.Printf("failed to prune manifest of shard %s: %v", shard.Path, err)
+		}
+
 		// update shards so partial failure removes the dst path
 		shards[i] = dstShard
 	}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/internal/lease"
+)
+
+var metricCoordinationReplicas = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "index_coordination_replicas",
+	Help: "The number of indexserver replicas the repositories are split between, or 0 if this replica doesn't hold its lease.",
+})
+
+// coordinator splits the repositories listed by Sourcegraph between the
+// indexserver replicas which share a lease prefix. Each replica holds a lease
+// named after its hostname, and a repository is owned by the replica which
+// ranks highest for it by rendezvous hashing over the holders of the
+// unexpired leases. When a replica joins or leaves, only the repositories it
+// gains or loses move.
+type coordinator struct {
+	leaser   lease.Leaser
+	prefix   string
+	hostname string
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// replicas are the holders of the leases as of the last refresh. It is
+	// nil if we didn't hold our own lease.
+	replicas []string
+}
+
+// Run refreshes the coordinator three times per lease duration, see refresh.
+// It blocks forever.
+func (c *coordinator) Run() {
+	for {
+		time.Sleep(c.ttl / 3)
+		if err := c.refresh(context.Background()); err != nil {
+			errorLog.Printf("coordination: %s", err)
+		}
+	}
+}
+
+// refresh renews the lease of this replica and fetches the holders of all
+// leases. If the lease server fails, we keep the replicas we knew, so a
+// short outage doesn't move repositories around.
+func (c *coordinator) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.ttl/3)
+	defer cancel()
+
+	name := c.prefix + c.hostname
+	ok, err := c.leaser.Acquire(ctx, name, c.hostname, c.ttl)
+	if err != nil {
+		return fmt.Errorf("renewing lease %s: %w", name, err)
+	}
+	if !ok {
+		c.setReplicas(nil)
+		return fmt.Errorf("lease %s is held by another replica", name)
+	}
+
+	replicas, err := c.leaser.Holders(ctx, c.prefix)
+	if err != nil {
+		return fmt.Errorf("listing leases: %w", err)
+	}
+	if !slices.Contains(replicas, c.hostname) {
+		replicas = append(replicas, c.hostname)
+		slices.Sort(replicas)
+	}
+	c.setReplicas(replicas)
+	return nil
+}
+
+func (c *coordinator) setReplicas(replicas []string) {
+	c.mu.Lock()
+	changed := !slices.Equal(c.replicas, replicas)
+	c.replicas = replicas
+	c.mu.Unlock()
+
+	metricCoordinationReplicas.Set(float64(len(replicas)))
+	if changed {
+		infoLog.Printf("coordination: splitting repositories between %d replica(s): %v", len(replicas), replicas)
+	}
+}
+
+// assign returns the repositories of ids which this replica owns. It returns
+// false if this replica doesn't hold its lease, in which case it owns
+// nothing but shouldn't drop the repositories it has indexed either.
+func (c *coordinator) assign(ids []uint32) ([]uint32, bool) {
+	c.mu.Lock()
+	replicas := c.replicas
+	c.mu.Unlock()
+
+	if replicas == nil {
+		return nil, false
+	}
+
+	owned := make([]uint32, 0, len(ids)/len(replicas)+1)
+	for _, id := range ids {
+		if rendezvousOwner(id, replicas) == c.hostname {
+			owned = append(owned, id)
+		}
+	}
+	return owned, true
+}
+
+// rendezvousOwner returns the replica with the highest score for id.
+func rendezvousOwner(id uint32, replicas []string) string {
+	var (
+		owner string
+		best  uint64
+	)
+	for _, r := range replicas {
+		h := fnv.New64a()
+		_ = binary.Write(h, binary.LittleEndian, id)
+		h.Write([]byte(r))
+		// FNV mixes the last bytes poorly into the high bits, so we
+		// finish with the finalizer of splitmix64.
+		score := h.Sum64()
+		score = (score ^ (score >> 30)) * 0xbf58476d1ce4e5b9
+		score = (score ^ (score >> 27)) * 0x94d049bb133111eb
+		score ^= score >> 31
+		if owner == "" || score > best {
+			owner, best = r, score
+		}
+	}
+	return owner
+}
+
+// restrict returns the repositories of res which are in ids.
+func (res *SourcegraphListResult) restrict(ids []uint32) *SourcegraphListResult {
+	set := make(map[uint32]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	iterate := res.IterateIndexOptions
+	return &SourcegraphListResult{
+		IDs: ids,
+		IterateIndexOptions: func(f func(IndexOptions)) {
+			iterate(func(opts IndexOptions) {
+				if _, ok := set[opts.RepoID]; ok {
+					f(opts)
+				}
+			})
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt/internal/lease"
+)
+
+func TestCoordinator(t *testing.T) {
+	leaser := lease.NewMemoryLeaser()
+	newReplica := func(hostname string) *coordinator {
+		return &coordinator{leaser: leaser, prefix: "zoekt-", hostname: hostname, ttl: time.Minute}
+	}
+
+	var ids []uint32
+	for id := uint32(1); id <= 1000; id++ {
+		ids = append(ids, id)
+	}
+
+	// assign returns the owner of each repository, as seen by replicas.
+	assign := func(replicas ...*coordinator) map[uint32]string {
+		t.Helper()
+		owners := map[uint32]string{}
+		// The first round joins, the second one sees all replicas.
+		for round := 0; round < 2; round++ {
+			for _, c := range replicas {
+				if err := c.refresh(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for _, c := range replicas {
+			owned, ok := c.assign(ids)
+			if !ok {
+				t.Fatalf("%s doesn't hold its lease", c.hostname)
+			}
+			if len(owned) < len(ids)/len(replicas)/2 {
+				t.Errorf("%s owns only %d of %d repositories", c.hostname, len(owned), len(ids))
+			}
+			for _, id := range owned {
+				if owner, ok := owners[id]; ok {
+					t.Fatalf("repository %d is owned by %s and %s", id, owner, c.hostname)
+				}
+				owners[id] = c.hostname
+			}
+		}
+		if len(owners) != len(ids) {
+			t.Fatalf("%d of %d repositories are owned", len(owners), len(ids))
+		}
+		return owners
+	}
+
+	a, b, c := newReplica("a"), newReplica("b"), newReplica("c")
+
+	// Replicas own nothing until they hold their lease.
+	if _, ok := a.assign(ids); ok {
+		t.Fatal("assigned repositories before joining")
+	}
+
+	before := assign(a, b)
+	after := assign(a, b, c)
+
+	// Only repositories which the new replica takes over move.
+	for id, owner := range after {
+		if owner != before[id] && owner != "c" {
+			t.Errorf("repository %d moved from %s to %s", id, before[id], owner)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha1"
 	"errors"
@@ -34,6 +35,12 @@ type IndexOptions struct {
 	// here: https://golang.org/pkg/path/filepath/#Match.
 	LargeFiles []string
 
+	// ExcludePatterns and IncludePatterns select the file paths which are
+	// indexed, see build.Options. The -exclude_paths of the indexserver are
+	// added to ExcludePatterns.
+	ExcludePatterns []string
+	IncludePatterns []string
+
 	// Symbols if true will make zoekt index the output of ctags.
 	Symbols bool
 
@@ -64,12 +71,28 @@ type IndexOptions struct {
 	// Map from language to scip-ctags, universal-ctags, or neither
 	LanguageMap ctags.LanguageMap
 
+	// LanguageOverrides maps glob patterns to the language files matching
+	// them should be tagged with, overriding go-enry's detection.
+	LanguageOverrides map[string]string
+
+	// NgramSize is the number of runes per indexed ngram. Zero means the
+	// default of 3.
+	NgramSize int
+
 	// The number of threads to use for indexing shards. Defaults to the number of available
 	// CPUs. If the server flag -cpu_fraction is set, then this value overrides it.
 	ShardConcurrency int32
 
 	// TenantID is the tenant ID for the repository.
 	TenantID int
+
+	// MaxFileSize, MaxLineLength and MaxTrigramCount override the limits of
+	// build.Options for the repository: SizeMax, LineMax and TrigramMax. Zero
+	// means the default. Configuration repositories can be indexed with
+	// tight limits, and data repositories with generous ones.
+	MaxFileSize     int
+	MaxLineLength   int
+	MaxTrigramCount int
 }
 
 // indexArgs represents the arguments we pass to zoekt-git-index
@@ -131,13 +154,19 @@ func (o *indexArgs) BuildOptions() *build.Options {
 		},
 		IndexDir:         o.IndexDir,
 		Parallelism:      o.Parallelism,
-		SizeMax:          o.FileLimit,
+		SizeMax:          cmp.Or(o.MaxFileSize, o.FileLimit),
+		TrigramMax:       o.MaxTrigramCount,
+		LineMax:          o.MaxLineLength,
 		LargeFiles:       o.LargeFiles,
+		ExcludePatterns:  o.ExcludePatterns,
+		IncludePatterns:  o.IncludePatterns,
 		CTagsMustSucceed: o.Symbols,
 		DisableCTags:     !o.Symbols,
 		IsDelta:          o.UseDelta,
 
-		LanguageMap: o.LanguageMap,
+		LanguageMap:       o.LanguageMap,
+		LanguageOverrides: o.LanguageOverrides,
+		NgramSize:         o.NgramSize,
 
 		ShardMerging: o.ShardMerging,
 
@@ -181,7 +210,7 @@ type gitIndexConfig struct {
 	timeout time.Duration
 }
 
-func gitIndex(c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.Logger) error {
+func gitIndex(ctx context.Context, c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.Logger) error {
 	logger := l.Scoped("gitIndex")
 
 	if len(o.Branches) == 0 {
@@ -196,7 +225,7 @@ func gitIndex(c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.L
 		return errors.New("findRepositoryMetadata in provided configuration was nil - a function must be provided")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
 	gitDir, err := tmpGitDir(o.Name)
@@ -263,7 +292,11 @@ func fetchRepo(ctx context.Context, gitDir string, o *indexArgs, c gitIndexConfi
 
 		// If there are no exceptions to MaxFileSize (1MB), we can avoid fetching these large files.
 		if len(o.LargeFiles) == 0 {
-			fetchArgs = append(fetchArgs, "--filter=blob:limit=1m")
+			limit := "1m"
+			if o.MaxFileSize > MaxFileSize {
+				limit = strconv.Itoa(o.MaxFileSize)
+			}
+			fetchArgs = append(fetchArgs, "--filter=blob:limit="+limit)
 		}
 
 		fetchArgs = append(fetchArgs, o.CloneURL)
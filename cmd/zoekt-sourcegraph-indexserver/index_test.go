@@ -535,7 +535,7 @@ func TestIndexTenant(t *testing.T) {
 				findRepositoryMetadata: findRepositoryMetadata,
 			}
 
-			if err := gitIndex(c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
+			if err := gitIndex(context.Background(), c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
 				t.Fatal(err)
 			}
 			if !cmp.Equal(got, tc.want) {
@@ -600,6 +600,36 @@ func TestIndex(t *testing.T) {
 			"git -C $TMPDIR/test%2Frepo.git config zoekt.tenantID 1",
 			"zoekt-git-index -submodules=false -branches HEAD -disable_ctags $TMPDIR/test%2Frepo.git",
 		},
+	}, {
+		name: "limits",
+		args: indexArgs{
+			FileLimit: 1 << 20,
+			IndexOptions: IndexOptions{
+				Name:            "test/repo",
+				CloneURL:        "http://api.test/.internal/git/test/repo",
+				Branches:        []zoekt.RepositoryBranch{{Name: "HEAD", Version: "deadbeef"}},
+				TenantID:        1,
+				MaxFileSize:     4 << 20,
+				MaxLineLength:   1000,
+				MaxTrigramCount: 50000,
+			},
+		},
+		want: []string{
+			"git -c init.defaultBranch=nonExistentBranchBB0FOFCH32 init --bare $TMPDIR/test%2Frepo.git",
+			"git -C $TMPDIR/test%2Frepo.git -c protocol.version=2 -c http.extraHeader=X-Sourcegraph-Actor-UID: internal -c http.extraHeader=X-Sourcegraph-Tenant-ID: 1 fetch --depth=1 --no-tags --filter=blob:limit=4194304 http://api.test/.internal/git/test/repo deadbeef",
+			"git -C $TMPDIR/test%2Frepo.git update-ref HEAD deadbeef",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.archived 0",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.fork 0",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.latestCommitDate 1",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.name test/repo",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.priority 0",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.public 0",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.repoid 0",
+			"git -C $TMPDIR/test%2Frepo.git config zoekt.tenantID 1",
+			"zoekt-git-index -submodules=false -branches HEAD " +
+				"-file_limit 4194304 -max_trigram_count 50000 -max_line_length 1000 -disable_ctags " +
+				"$TMPDIR/test%2Frepo.git",
+		},
 	}, {
 		name: "all",
 		args: indexArgs{
@@ -711,7 +741,7 @@ func TestIndex(t *testing.T) {
 				findRepositoryMetadata: findRepositoryMetadata,
 			}
 
-			if err := gitIndex(c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
+			if err := gitIndex(context.Background(), c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
 				t.Fatal(err)
 			}
 			if !cmp.Equal(got, tc.want) {
@@ -24,6 +24,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -52,6 +53,7 @@ import (
 	"github.com/sourcegraph/zoekt/debugserver"
 	"github.com/sourcegraph/zoekt/grpc/internalerrs"
 	"github.com/sourcegraph/zoekt/grpc/messagesize"
+	"github.com/sourcegraph/zoekt/internal/lease"
 	"github.com/sourcegraph/zoekt/internal/profiler"
 	"github.com/sourcegraph/zoekt/internal/tenant"
 )
@@ -195,6 +197,10 @@ type Server struct {
 	// If true, shard merging is enabled.
 	shardMerging bool
 
+	// excludePatterns are file path patterns which are not indexed in any
+	// repository, in addition to IndexOptions.ExcludePatterns.
+	excludePatterns []string
+
 	// deltaBuildRepositoriesAllowList is an allowlist for repositories that we
 	// use delta-builds for instead of normal builds
 	deltaBuildRepositoriesAllowList map[string]struct{}
@@ -213,6 +219,20 @@ type Server struct {
 
 	// timeout defines how long the index server waits before killing an indexing job.
 	timeout time.Duration
+
+	// tenantDiskQuota limits the size of the shards of each tenant.
+	tenantDiskQuota tenantDiskQuota
+
+	// adminToken is the bearer token required by the admin API. The admin API
+	// is disabled if it is empty.
+	adminToken string
+
+	// jobs are the index jobs in progress, which the admin API can cancel.
+	jobs jobRegistry
+
+	// coordinator, if set, splits the listed repositories between the
+	// replicas of the indexserver.
+	coordinator *coordinator
 }
 
 var (
@@ -348,6 +368,16 @@ func (s *Server) Run() {
 				continue
 			}
 
+			if s.coordinator != nil {
+				owned, ok := s.coordinator.assign(repos.IDs)
+				if !ok {
+					errorLog.Printf("not updating the index queue: this replica doesn't hold its coordination lease")
+					continue
+				}
+				debugLog.Printf("coordination: this replica owns %d of %d repositories", len(owned), len(repos.IDs))
+				repos = repos.restrict(owned)
+			}
+
 			debugLog.Printf("updating index queue with %d repositories", len(repos.IDs))
 
 			// Stop indexing repos we don't need to track anymore
@@ -363,6 +393,7 @@ func (s *Server) Run() {
 				s.muIndexDir.Global(func() {
 					cleanup(s.IndexDir, repos.IDs, time.Now(), s.shardMerging)
 				})
+				s.tenantDiskQuota.update(s.IndexDir)
 			}()
 
 			repos.IterateIndexOptions(s.queue.AddOrUpdate)
@@ -439,6 +470,9 @@ func (s *Server) processQueue() {
 
 		opts := item.Opts
 		args := s.indexArgs(opts)
+		if item.Force {
+			args.Incremental = false
+		}
 
 		ran := s.muIndexDir.With(opts.Name, func() {
 			// only record time taken once we hold the lock. This avoids us
@@ -475,6 +509,7 @@ func (s *Server) processQueue() {
 				infoLog.Printf("updated meta %s in %v", args.String(), elapsed)
 			}
 			s.queue.SetIndexed(opts, state)
+			s.queue.SetResult(opts.RepoID, err)
 		})
 
 		if !ran {
@@ -615,6 +650,11 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		}
 	}
 
+	// Updates of the metadata are cheap, so we only stop full builds.
+	if err := s.tenantDiskQuota.check(args.TenantID); err != nil {
+		return indexStateFail, err
+	}
+
 	infoLog.Printf("updating index %s reason=%s", args.String(), reason)
 
 	metricIndexingTotal.Inc()
@@ -629,8 +669,14 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		timeout: s.timeout,
 	}
 
-	err = gitIndex(c, args, s.Sourcegraph, s.logger)
+	ctx, done := s.jobs.start(args.RepoID)
+	defer done()
+
+	err = gitIndex(ctx, c, args, s.Sourcegraph, s.logger)
 	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return indexStateFail, fmt.Errorf("index job canceled: %w", err)
+		}
 		return indexStateFail, err
 	}
 
@@ -680,6 +726,8 @@ func sglogBranches(key string, branches []zoekt.RepositoryBranch) sglog.Field {
 
 func (s *Server) indexArgs(opts IndexOptions) *indexArgs {
 	parallelism := s.parallelism(opts, runtime.GOMAXPROCS(0))
+	// Clip, so that appending copies the server's patterns.
+	opts.ExcludePatterns = append(slices.Clip(s.excludePatterns), opts.ExcludePatterns...)
 	return &indexArgs{
 		IndexOptions: opts,
 		IndexDir:     s.IndexDir,
@@ -738,11 +786,14 @@ func (s *Server) addDebugHandlers(mux *http.ServeMux) {
 	mux.Handle("/", http.HandlerFunc(s.handleRoot))
 
 	mux.Handle("/debug/reindex", http.HandlerFunc(s.handleReindex))
+	mux.Handle("/debug/tombstone", http.HandlerFunc(s.handleTombstone))
 	mux.Handle("/debug/indexed", http.HandlerFunc(s.handleDebugIndexed))
 	mux.Handle("/debug/list", http.HandlerFunc(s.handleDebugList))
 	mux.Handle("/debug/merge", http.HandlerFunc(s.handleDebugMerge))
 	mux.Handle("/debug/queue", http.HandlerFunc(s.queue.handleDebugQueue))
 	mux.Handle("/debug/host", http.HandlerFunc(s.handleHost))
+	mux.Handle("/debug/status", http.HandlerFunc(s.handleStatus))
+	mux.Handle("/debug/pause", http.HandlerFunc(s.handlePause))
 }
 
 func (s *Server) handleHost(w http.ResponseWriter, r *http.Request) {
@@ -773,6 +824,7 @@ var rootTmpl = template.Must(template.New("name").Parse(`
     <body>
         <a href="debug">Debug</a><br />
         <a href="debug/requests">Traces</a><br />
+        <a href="debug/status">Status</a><br />
         {{.IndexMsg}}<br />
         <br />
         <h3>Reindex</h3>
@@ -873,6 +925,75 @@ func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// handleTombstone tombstones (tombstone=true, the default) or resurrects
+// (tombstone=false) the repository with the ID given by the parameter repo in
+// the compound shards of the index directory. It responds with the list of
+// changed shards as JSON, or 404 if no compound shard contains the repository.
+// Searchers honor the change as soon as they reload the shards.
+//
+// Note that cleanup resurrects tombstoned repositories which Sourcegraph still
+// assigns to this indexserver, unless they have been reindexed in the
+// meantime.
+func (s *Server) handleTombstone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.Form.Get("repo"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tombstone := true
+	if v := r.Form.Get("tombstone"); v != "" {
+		if tombstone, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var changed []string
+	s.muIndexDir.Global(func() {
+		if tombstone {
+			changed, err = zoekt.TombstoneRepo(s.IndexDir, uint32(id))
+		} else {
+			changed, err = zoekt.ResurrectRepo(s.IndexDir, uint32(id))
+		}
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infoLog.Printf("set tombstone=%t for %d in %v", tombstone, id, changed)
+
+	response := struct {
+		Shards []string
+	}{
+		Shards: changed,
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
 func (s *Server) handleDebugList(w http.ResponseWriter, r *http.Request) {
 	withIndexed := true
 	if b, err := strconv.ParseBool(r.URL.Query().Get("indexed")); err == nil {
@@ -1017,6 +1138,7 @@ func (s *Server) forceIndex(id uint32) (string, error) {
 	var state indexState
 	ran := s.muIndexDir.With(opts.Name, func() {
 		state, err = s.Index(args)
+		s.queue.SetResult(opts.RepoID, err)
 	})
 	if !ran {
 		return fmt.Sprintf("index job for repository already running: %s", args), nil
@@ -1247,6 +1369,7 @@ type rootConfig struct {
 	listen           string
 	hostname         string
 	cpuFraction      float64
+	excludePaths     string
 
 	// config values related to shard merging
 	disableShardMerging bool
@@ -1255,10 +1378,22 @@ type rootConfig struct {
 	targetSize          int64
 	minSize             int64
 	minAgeDays          int
+	hotThreshold        float64
 
 	// config values related to backoff indexing repos with one or more consecutive failures
 	backoffDuration    time.Duration
 	maxBackoffDuration time.Duration
+
+	// tenantDiskQuota is the maximum size of the shards of a tenant in MiB.
+	tenantDiskQuota int64
+
+	// adminToken is the bearer token of the admin API.
+	adminToken string
+
+	// config values related to splitting repositories between replicas
+	coordination       string
+	coordinationPrefix string
+	coordinationTTL    time.Duration
 }
 
 func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
@@ -1269,8 +1404,16 @@ func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.StringVar(&rc.listen, "listen", ":6072", "listen on this address.")
 	fs.StringVar(&rc.hostname, "hostname", zoekt.HostnameBestEffort(), "the name we advertise to Sourcegraph when asking for the list of repositories to index. Can also be set via the NODE_NAME environment variable.")
 	fs.Float64Var(&rc.cpuFraction, "cpu_fraction", 1.0, "use this fraction of the cores for indexing.")
+	fs.StringVar(&rc.excludePaths, "exclude_paths", getEnvWithDefaultString("SRC_EXCLUDE_PATHS", ""), "space separated file path patterns which are not indexed in any repository, eg. \"**/node_modules/** regex:\\.pb\\.go$\". See -exclude_path of zoekt-git-index for the syntax.")
 	fs.DurationVar(&rc.backoffDuration, "backoff_duration", getEnvWithDefaultDuration("BACKOFF_DURATION", 10*time.Minute), "for the given duration we backoff from enqueue operations for a repository that's failed its previous indexing attempt. Consecutive failures increase the duration of the delay linearly up to the maxBackoffDuration. A negative value disables indexing backoff.")
 	fs.DurationVar(&rc.maxBackoffDuration, "max_backoff_duration", getEnvWithDefaultDuration("MAX_BACKOFF_DURATION", 120*time.Minute), "the maximum duration to backoff from enqueueing a repo for indexing.  A negative value disables indexing backoff.")
+	fs.StringVar(&rc.adminToken, "admin_token", "", "if set, serve the admin API under /admin/ to clients sending this token in an \"Authorization: Bearer\" header. The admin API reindexes repositories, cancels index jobs and pauses indexing. Can also be set via the SRC_INDEXSERVER_ADMIN_TOKEN environment variable.")
+	fs.Int64Var(&rc.tenantDiskQuota, "tenant_disk_quota", getEnvWithDefaultInt64("SRC_TENANT_DISK_QUOTA", 0), "if set, the maximum size of the shards of a tenant in MiB. Repositories of tenants over quota are not reindexed until their usage drops, but metadata updates still apply.")
+
+	// flags related to splitting repositories between replicas
+	fs.StringVar(&rc.coordination, "coordination", getEnvWithDefaultString("SRC_INDEXSERVER_COORDINATION", ""), "if set to \"kubernetes\", split the listed repositories between the replicas of the indexserver, for sources which list every repository to every replica, like a directory passed as -sourcegraph_url. Each replica renews a Lease object named -coordination_prefix followed by -hostname in the namespace of its pod, and indexes the repositories it owns among the holders of unexpired leases. The service account of the pod needs permission to get, list, create and update leases.")
+	fs.StringVar(&rc.coordinationPrefix, "coordination_prefix", getEnvWithDefaultString("SRC_INDEXSERVER_COORDINATION_PREFIX", "zoekt-indexserver-"), "if using -coordination, the name prefix of the leases of the replicas which split the repositories between them.")
+	fs.DurationVar(&rc.coordinationTTL, "coordination_ttl", getEnvWithDefaultDuration("SRC_INDEXSERVER_COORDINATION_TTL", time.Minute), "if using -coordination, the duration of the leases. The repositories of a replica which stopped renewing its lease move to the others once it expires.")
 
 	// flags related to shard merging
 	fs.BoolVar(&rc.disableShardMerging, "shard_merging", getEnvWithDefaultBool("SRC_DISABLE_SHARD_MERGING", false), "disable shard merging")
@@ -1278,6 +1421,7 @@ func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.DurationVar(&rc.mergeInterval, "merge_interval", getEnvWithDefaultDuration("SRC_MERGE_INTERVAL", 8*time.Hour), "run merge this often")
 	fs.Int64Var(&rc.targetSize, "merge_target_size", getEnvWithDefaultInt64("SRC_MERGE_TARGET_SIZE", 1000), "the target size of compound shards in MiB")
 	fs.Int64Var(&rc.minSize, "merge_min_size", getEnvWithDefaultInt64("SRC_MERGE_MIN_SIZE", 800), "the minimum size of a compound shard in MiB")
+	fs.Float64Var(&rc.hotThreshold, "merge_hot_threshold", getEnvWithDefaultFloat64("SRC_MERGE_HOT_THRESHOLD", 0), "if set, repositories with at least this many searches per hour are excluded from merging, according to the traffic statistics of zoekt-webserver -traffic_stats_interval.")
 	fs.IntVar(&rc.minAgeDays, "merge_min_age", getEnvWithDefaultInt("SRC_MERGE_MIN_AGE", 7), "the time since the last commit in days. Shards with newer commits are excluded from merging.")
 }
 
@@ -1289,6 +1433,7 @@ func startServer(conf rootConfig) error {
 
 	profiler.Init("zoekt-sourcegraph-indexserver")
 	setCompoundShardCounter(s.IndexDir)
+	s.tenantDiskQuota.update(s.IndexDir)
 
 	if conf.listen != "" {
 
@@ -1300,6 +1445,7 @@ func startServer(conf rootConfig) error {
 			{Href: "debug/queue", Text: "Indexing Queue State", Description: "list of all repositories in the indexing queue, sorted by descending priority"},
 		}...)
 		s.addDebugHandlers(mux)
+		s.addAdminHandlers(mux)
 
 		go func() {
 			debugLog.Printf("serving HTTP on %s", conf.listen)
@@ -1347,6 +1493,15 @@ func startServer(conf rootConfig) error {
 	}
 	go oc.Run()
 
+	if s.coordinator != nil {
+		// Join before the first update of the index queue, which is skipped
+		// until we hold our lease.
+		if err := s.coordinator.refresh(context.Background()); err != nil {
+			errorLog.Printf("coordination: %s", err)
+		}
+		go s.coordinator.Run()
+	}
+
 	logger := sglog.Scoped("metricsRegistration")
 	opts := mountinfo.CollectorOpts{Namespace: "zoekt_indexserver"}
 
@@ -1468,6 +1623,32 @@ func newServer(conf rootConfig) (*Server, error) {
 
 	q := NewQueue(conf.backoffDuration, conf.maxBackoffDuration, logger)
 
+	var coord *coordinator
+	switch conf.coordination {
+	case "":
+	case "kubernetes":
+		leaser, err := lease.NewKubernetesLeaser("")
+		if err != nil {
+			return nil, fmt.Errorf("-coordination: %w", err)
+		}
+		if conf.coordinationTTL <= 0 {
+			return nil, fmt.Errorf("-coordination_ttl must be positive")
+		}
+		coord = &coordinator{
+			leaser:   leaser,
+			prefix:   conf.coordinationPrefix,
+			hostname: conf.hostname,
+			ttl:      conf.coordinationTTL,
+		}
+	default:
+		return nil, fmt.Errorf("-coordination: unknown value %q", conf.coordination)
+	}
+
+	adminToken := conf.adminToken
+	if adminToken == "" {
+		adminToken = os.Getenv("SRC_INDEXSERVER_ADMIN_TOKEN")
+	}
+
 	return &Server{
 		logger:                            logger,
 		Sourcegraph:                       sg,
@@ -1477,6 +1658,7 @@ func newServer(conf rootConfig) (*Server, error) {
 		CPUCount:                          cpuCount,
 		queue:                             *q,
 		shardMerging:                      !conf.disableShardMerging,
+		excludePatterns:                   strings.Fields(conf.excludePaths),
 		deltaBuildRepositoriesAllowList:   deltaBuildRepositoriesAllowList,
 		deltaShardNumberFallbackThreshold: deltaShardNumberFallbackThreshold,
 		repositoriesSkipSymbolsCalculationAllowList: reposShouldSkipSymbolsCalculation,
@@ -1487,8 +1669,12 @@ func newServer(conf rootConfig) (*Server, error) {
 			targetSizeBytes: conf.targetSize * 1024 * 1024,
 			minSizeBytes:    conf.minSize * 1024 * 1024,
 			minAgeDays:      conf.minAgeDays,
+			hotThreshold:    conf.hotThreshold,
 		},
-		timeout: indexingTimeout,
+		timeout:         indexingTimeout,
+		tenantDiskQuota: tenantDiskQuota{limit: conf.tenantDiskQuota * 1024 * 1024},
+		adminToken:      adminToken,
+		coordinator:     coord,
 	}, err
 }
 
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -242,6 +244,53 @@ func TestCreateEmptyShard(t *testing.T) {
 	}
 }
 
+func TestHandleTombstone(t *testing.T) {
+	dir := t.TempDir()
+	cs := createCompoundShard(t, dir, []uint32{1, 2})
+	s := &Server{IndexDir: dir}
+
+	post := func(form string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/debug/tombstone", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleTombstone(w, req)
+		return w
+	}
+	alive := func() []uint32 {
+		t.Helper()
+		repos, _, err := zoekt.ReadMetadataPathAlive(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint32
+		for _, r := range repos {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	if w := post("repo=2"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), filepath.Base(cs)) {
+		t.Fatalf("tombstone: got %d %q", w.Code, w.Body.String())
+	}
+	require.Equal(t, []uint32{1}, alive())
+
+	if w := post("repo=2&tombstone=false"); w.Code != http.StatusOK {
+		t.Fatalf("resurrect: got %d %q", w.Code, w.Body.String())
+	}
+	require.Equal(t, []uint32{1, 2}, alive())
+
+	for form, want := range map[string]int{
+		"repo=3":                 http.StatusNotFound,
+		"repo=foo":               http.StatusBadRequest,
+		"repo=1&tombstone=maybe": http.StatusBadRequest,
+	} {
+		if w := post(form); w.Code != want {
+			t.Errorf("%s: got %d, want %d", form, w.Code, want)
+		}
+	}
+}
+
 func TestFormatListUint32(t *testing.T) {
 	cases := []struct {
 		in   []uint32
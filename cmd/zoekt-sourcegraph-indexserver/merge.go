@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/grafana/regexp"
@@ -14,6 +15,7 @@ import (
 	"go.uber.org/atomic"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/traffic"
 )
 
 var metricShardMergingRunning = promauto.NewGauge(prometheus.GaugeOpts{
@@ -40,6 +42,32 @@ func pickCandidates(shards []candidate, targetSizeBytes int64) compound {
 
 var mergeRunning atomic.Bool
 
+// mergeStatus describes the merge of 1 compound shard in progress.
+type mergeStatus struct {
+	Started   time.Time
+	Shards    []string
+	SizeBytes int64
+}
+
+var (
+	muRunningMerge sync.Mutex
+	runningMerge   *mergeStatus
+)
+
+// setRunningMerge records the merge in progress, or that none is if m is nil.
+func setRunningMerge(m *mergeStatus) {
+	muRunningMerge.Lock()
+	runningMerge = m
+	muRunningMerge.Unlock()
+}
+
+// getRunningMerge returns the merge in progress, or nil.
+func getRunningMerge() *mergeStatus {
+	muRunningMerge.Lock()
+	defer muRunningMerge.Unlock()
+	return runningMerge
+}
+
 func defaultMergeCmd(args ...string) *exec.Cmd {
 	cmd := exec.Command("zoekt-merge-index", "merge")
 	cmd.Args = append(cmd.Args, args...)
@@ -89,6 +117,9 @@ func (s *Server) merge(mergeCmd func(args ...string) *exec.Cmd) {
 
 			start := time.Now()
 
+			setRunningMerge(&mergeStatus{Started: start, Shards: paths, SizeBytes: c.size})
+			defer setRunningMerge(nil)
+
 			cmd := mergeCmd(paths...)
 
 			// zoekt-merge-index writes the full path of the new compound shard to stdout.
@@ -132,6 +163,13 @@ func loadCandidates(dir string, opts mergeOpts) ([]candidate, int) {
 	defer d.Close()
 	names, _ := d.Readdirnames(-1)
 
+	var stats *traffic.Stats
+	if opts.hotThreshold > 0 {
+		if stats, err = traffic.Read(dir); err != nil {
+			debugLog.Printf("failed to read traffic statistics: %s", err)
+		}
+	}
+
 	candidates := make([]candidate, 0, len(names))
 	for _, n := range names {
 		path := filepath.Join(dir, n)
@@ -146,7 +184,7 @@ func loadCandidates(dir string, opts mergeOpts) ([]candidate, int) {
 			continue
 		}
 
-		if isExcluded(path, fi, opts) {
+		if isExcluded(path, fi, opts, stats) {
 			excluded++
 			continue
 		}
@@ -192,13 +230,19 @@ type mergeOpts struct {
 	// merging. For example, a value of 7 means that only repos that have been
 	// inactive for 7 days will be considered for merging.
 	minAgeDays int
+
+	// repositories with at least hotThreshold searches per hour, according to
+	// the traffic statistics of the webserver, are excluded from merging. They
+	// keep their own shards, which are searched in parallel. Zero disables
+	// the check.
+	hotThreshold float64
 }
 
 // isExcluded returns true if a shard should not be merged, false otherwise.
 //
 // We need path and FileInfo because FileInfo does not contain the full path, see
 // discussion here https://github.com/golang/go/issues/32300.
-func isExcluded(path string, fi os.FileInfo, opts mergeOpts) bool {
+func isExcluded(path string, fi os.FileInfo, opts mergeOpts, stats *traffic.Stats) bool {
 	if hasMultipleShards(path) {
 		return true
 	}
@@ -222,6 +266,10 @@ func isExcluded(path string, fi os.FileInfo, opts mergeOpts) bool {
 		return true
 	}
 
+	if opts.hotThreshold > 0 && stats.Hot(repos[0].Name, opts.hotThreshold) {
+		return true
+	}
+
 	return false
 }
 
@@ -9,9 +9,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/internal/traffic"
 )
 
 func TestHasMultipleShards(t *testing.T) {
@@ -227,3 +229,34 @@ func copyFile(src, dst string) (err error) {
 	}
 	return d.Close()
 }
+
+func TestMergeExcludesHotRepos(t *testing.T) {
+	dir := t.TempDir()
+	_, err := copyTestShards(dir, []string{
+		"../../testdata/shards/repo_v16.00000.zoekt",
+		"../../testdata/shards/repo2_v16.00000.zoekt",
+		"../../testdata/shards/ctagsrepo_v16.00000.zoekt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &traffic.Stats{Rates: map[string]float64{"repo2": 100}, Updated: time.Now()}
+	if err := stats.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		IndexDir:  dir,
+		mergeOpts: mergeOpts{targetSizeBytes: 4 * 1024, hotThreshold: 10},
+	}
+	s.merge(helperCallMerge)
+
+	have, err := filepath.Glob(filepath.Join(dir, "*_v16.00000.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != 1 || filepath.Base(have[0]) != "repo2_v16.00000.zoekt" {
+		t.Fatalf("want only the shard of the hot repository left, have %v", have)
+	}
+}
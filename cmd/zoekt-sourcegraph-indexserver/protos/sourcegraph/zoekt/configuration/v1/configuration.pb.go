@@ -353,6 +353,28 @@ type ZoektIndexOptions struct {
 	ShardConcurrency int32 `protobuf:"varint,13,opt,name=shard_concurrency,json=shardConcurrency,proto3" json:"shard_concurrency,omitempty"`
 	// tenant_id is the tenant ID of the repository.
 	TenantId int64 `protobuf:"varint,14,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	// max_file_size, if non-zero, overrides the maximum size in bytes of an
+	// indexed file.
+	MaxFileSize int64 `protobuf:"varint,15,opt,name=max_file_size,json=maxFileSize,proto3" json:"max_file_size,omitempty"`
+	// max_line_length, if non-zero, is the maximum length in bytes of a line.
+	// Files with longer lines are not indexed.
+	MaxLineLength int64 `protobuf:"varint,16,opt,name=max_line_length,json=maxLineLength,proto3" json:"max_line_length,omitempty"`
+	// max_trigram_count, if non-zero, overrides the maximum number of distinct
+	// trigrams of an indexed file.
+	MaxTrigramCount int64 `protobuf:"varint,17,opt,name=max_trigram_count,json=maxTrigramCount,proto3" json:"max_trigram_count,omitempty"`
+	// language_overrides maps glob patterns of file paths to the language
+	// files matching them are tagged with, overriding the detected language.
+	LanguageOverrides map[string]string `protobuf:"bytes,18,rep,name=language_overrides,json=languageOverrides,proto3" json:"language_overrides,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// ngram_size, if non-zero, is the number of runes per indexed ngram,
+	// between 2 and 4. Zero means the default of 3.
+	NgramSize int64 `protobuf:"varint,19,opt,name=ngram_size,json=ngramSize,proto3" json:"ngram_size,omitempty"`
+	// exclude_patterns are glob patterns, or regular expressions prefixed with
+	// "regex:", of file paths which are not indexed.
+	ExcludePatterns []string `protobuf:"bytes,20,rep,name=exclude_patterns,json=excludePatterns,proto3" json:"exclude_patterns,omitempty"`
+	// include_patterns, if set, restrict indexing to the file paths which
+	// match one of them, with the syntax of exclude_patterns. exclude_patterns
+	// take precedence.
+	IncludePatterns []string `protobuf:"bytes,21,rep,name=include_patterns,json=includePatterns,proto3" json:"include_patterns,omitempty"`
 }
 
 func (x *ZoektIndexOptions) Reset() {
@@ -485,6 +507,55 @@ func (x *ZoektIndexOptions) GetTenantId() int64 {
 	return 0
 }
 
+func (x *ZoektIndexOptions) GetMaxFileSize() int64 {
+	if x != nil {
+		return x.MaxFileSize
+	}
+	return 0
+}
+
+func (x *ZoektIndexOptions) GetMaxLineLength() int64 {
+	if x != nil {
+		return x.MaxLineLength
+	}
+	return 0
+}
+
+func (x *ZoektIndexOptions) GetMaxTrigramCount() int64 {
+	if x != nil {
+		return x.MaxTrigramCount
+	}
+	return 0
+}
+
+func (x *ZoektIndexOptions) GetLanguageOverrides() map[string]string {
+	if x != nil {
+		return x.LanguageOverrides
+	}
+	return nil
+}
+
+func (x *ZoektIndexOptions) GetNgramSize() int64 {
+	if x != nil {
+		return x.NgramSize
+	}
+	return 0
+}
+
+func (x *ZoektIndexOptions) GetExcludePatterns() []string {
+	if x != nil {
+		return x.ExcludePatterns
+	}
+	return nil
+}
+
+func (x *ZoektIndexOptions) GetIncludePatterns() []string {
+	if x != nil {
+		return x.IncludePatterns
+	}
+	return nil
+}
+
 // ZoektRepositoryBranch describes an indexed branch of a repository.
 type ZoektRepositoryBranch struct {
 	state         protoimpl.MessageState
@@ -753,7 +824,7 @@ type UpdateIndexStatusRequest_Repository struct {
 func (x *UpdateIndexStatusRequest_Repository) Reset() {
 	*x = UpdateIndexStatusRequest_Repository{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_configuration_proto_msgTypes[10]
+		mi := &file_configuration_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -766,7 +837,7 @@ func (x *UpdateIndexStatusRequest_Repository) String() string {
 func (*UpdateIndexStatusRequest_Repository) ProtoMessage() {}
 
 func (x *UpdateIndexStatusRequest_Repository) ProtoReflect() protoreflect.Message {
-	mi := &file_configuration_proto_msgTypes[10]
+	mi := &file_configuration_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -848,7 +919,7 @@ var file_configuration_proto_rawDesc = []byte{
 	0x72, 0x61, 0x70, 0x68, 0x2e, 0x7a, 0x6f, 0x65, 0x6b, 0x74, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69,
 	0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x54, 0x61, 0x67,
 	0x73, 0x50, 0x61, 0x72, 0x73, 0x65, 0x72, 0x54, 0x79, 0x70, 0x65, 0x52, 0x05, 0x63, 0x74, 0x61,
-	0x67, 0x73, 0x22, 0xa4, 0x04, 0x0a, 0x11, 0x5a, 0x6f, 0x65, 0x6b, 0x74, 0x49, 0x6e, 0x64, 0x65,
+	0x67, 0x73, 0x22, 0xd4, 0x07, 0x0a, 0x11, 0x5a, 0x6f, 0x65, 0x6b, 0x74, 0x49, 0x6e, 0x64, 0x65,
 	0x78, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65,
 	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x17, 0x0a, 0x07,
 	0x72, 0x65, 0x70, 0x6f, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x72,
@@ -882,7 +953,34 @@ var file_configuration_proto_rawDesc = []byte{
 	0x65, 0x6e, 0x63, 0x79, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x05, 0x52, 0x10, 0x73, 0x68, 0x61, 0x72,
 	0x64, 0x43, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x1b, 0x0a, 0x09,
 	0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x49, 0x64, 0x22, 0x45, 0x0a, 0x15, 0x5a, 0x6f, 0x65,
+	0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x61, 0x78,
+	0x5f, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0b, 0x6d, 0x61, 0x78, 0x46, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x26, 0x0a,
+	0x0f, 0x6d, 0x61, 0x78, 0x5f, 0x6c, 0x69, 0x6e, 0x65, 0x5f, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68,
+	0x18, 0x10, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x6d, 0x61, 0x78, 0x4c, 0x69, 0x6e, 0x65, 0x4c,
+	0x65, 0x6e, 0x67, 0x74, 0x68, 0x12, 0x2a, 0x0a, 0x11, 0x6d, 0x61, 0x78, 0x5f, 0x74, 0x72, 0x69,
+	0x67, 0x72, 0x61, 0x6d, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x11, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0f, 0x6d, 0x61, 0x78, 0x54, 0x72, 0x69, 0x67, 0x72, 0x61, 0x6d, 0x43, 0x6f, 0x75, 0x6e,
+	0x74, 0x12, 0x7b, 0x0a, 0x12, 0x6c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x5f, 0x6f, 0x76,
+	0x65, 0x72, 0x72, 0x69, 0x64, 0x65, 0x73, 0x18, 0x12, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x4c, 0x2e,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x67, 0x72, 0x61, 0x70, 0x68, 0x2e, 0x7a, 0x6f, 0x65, 0x6b,
+	0x74, 0x2e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e,
+	0x76, 0x31, 0x2e, 0x5a, 0x6f, 0x65, 0x6b, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x4f, 0x70, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x4c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x4f, 0x76, 0x65,
+	0x72, 0x72, 0x69, 0x64, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x11, 0x6c, 0x61, 0x6e,
+	0x67, 0x75, 0x61, 0x67, 0x65, 0x4f, 0x76, 0x65, 0x72, 0x72, 0x69, 0x64, 0x65, 0x73, 0x12, 0x1d,
+	0x0a, 0x0a, 0x6e, 0x67, 0x72, 0x61, 0x6d, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x13, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x09, 0x6e, 0x67, 0x72, 0x61, 0x6d, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x29, 0x0a,
+	0x10, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x70, 0x61, 0x74, 0x74, 0x65, 0x72, 0x6e,
+	0x73, 0x18, 0x14, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0f, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65,
+	0x50, 0x61, 0x74, 0x74, 0x65, 0x72, 0x6e, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x69, 0x6e, 0x63, 0x6c,
+	0x75, 0x64, 0x65, 0x5f, 0x70, 0x61, 0x74, 0x74, 0x65, 0x72, 0x6e, 0x73, 0x18, 0x15, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x0f, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x50, 0x61, 0x74, 0x74, 0x65,
+	0x72, 0x6e, 0x73, 0x1a, 0x44, 0x0a, 0x16, 0x4c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x4f,
+	0x76, 0x65, 0x72, 0x72, 0x69, 0x64, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x45, 0x0a, 0x15, 0x5a, 0x6f, 0x65,
 	0x6b, 0x74, 0x52, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x6f, 0x72, 0x79, 0x42, 0x72, 0x61, 0x6e,
 	0x63, 0x68, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
 	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f,
@@ -975,7 +1073,7 @@ func file_configuration_proto_rawDescGZIP() []byte {
 }
 
 var file_configuration_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_configuration_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_configuration_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_configuration_proto_goTypes = []interface{}{
 	(CTagsParserType)(0),                        // 0: sourcegraph.zoekt.configuration.v1.CTagsParserType
 	(*SearchConfigurationRequest)(nil),          // 1: sourcegraph.zoekt.configuration.v1.SearchConfigurationRequest
@@ -988,30 +1086,32 @@ var file_configuration_proto_goTypes = []interface{}{
 	(*ListResponse)(nil),                        // 8: sourcegraph.zoekt.configuration.v1.ListResponse
 	(*UpdateIndexStatusRequest)(nil),            // 9: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest
 	(*UpdateIndexStatusResponse)(nil),           // 10: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusResponse
-	(*UpdateIndexStatusRequest_Repository)(nil), // 11: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository
-	(*timestamppb.Timestamp)(nil),               // 12: google.protobuf.Timestamp
+	nil,                                         // 11: sourcegraph.zoekt.configuration.v1.ZoektIndexOptions.LanguageOverridesEntry
+	(*UpdateIndexStatusRequest_Repository)(nil), // 12: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository
+	(*timestamppb.Timestamp)(nil),               // 13: google.protobuf.Timestamp
 }
 var file_configuration_proto_depIdxs = []int32{
 	3,  // 0: sourcegraph.zoekt.configuration.v1.SearchConfigurationRequest.fingerprint:type_name -> sourcegraph.zoekt.configuration.v1.Fingerprint
 	3,  // 1: sourcegraph.zoekt.configuration.v1.SearchConfigurationResponse.fingerprint:type_name -> sourcegraph.zoekt.configuration.v1.Fingerprint
 	5,  // 2: sourcegraph.zoekt.configuration.v1.SearchConfigurationResponse.updated_options:type_name -> sourcegraph.zoekt.configuration.v1.ZoektIndexOptions
-	12, // 3: sourcegraph.zoekt.configuration.v1.Fingerprint.generated_at:type_name -> google.protobuf.Timestamp
+	13, // 3: sourcegraph.zoekt.configuration.v1.Fingerprint.generated_at:type_name -> google.protobuf.Timestamp
 	0,  // 4: sourcegraph.zoekt.configuration.v1.LanguageMapping.ctags:type_name -> sourcegraph.zoekt.configuration.v1.CTagsParserType
 	6,  // 5: sourcegraph.zoekt.configuration.v1.ZoektIndexOptions.branches:type_name -> sourcegraph.zoekt.configuration.v1.ZoektRepositoryBranch
 	4,  // 6: sourcegraph.zoekt.configuration.v1.ZoektIndexOptions.language_map:type_name -> sourcegraph.zoekt.configuration.v1.LanguageMapping
-	11, // 7: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.repositories:type_name -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository
-	6,  // 8: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository.branches:type_name -> sourcegraph.zoekt.configuration.v1.ZoektRepositoryBranch
-	1,  // 9: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.SearchConfiguration:input_type -> sourcegraph.zoekt.configuration.v1.SearchConfigurationRequest
-	7,  // 10: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.List:input_type -> sourcegraph.zoekt.configuration.v1.ListRequest
-	9,  // 11: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.UpdateIndexStatus:input_type -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest
-	2,  // 12: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.SearchConfiguration:output_type -> sourcegraph.zoekt.configuration.v1.SearchConfigurationResponse
-	8,  // 13: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.List:output_type -> sourcegraph.zoekt.configuration.v1.ListResponse
-	10, // 14: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.UpdateIndexStatus:output_type -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusResponse
-	12, // [12:15] is the sub-list for method output_type
-	9,  // [9:12] is the sub-list for method input_type
-	9,  // [9:9] is the sub-list for extension type_name
-	9,  // [9:9] is the sub-list for extension extendee
-	0,  // [0:9] is the sub-list for field type_name
+	11, // 7: sourcegraph.zoekt.configuration.v1.ZoektIndexOptions.language_overrides:type_name -> sourcegraph.zoekt.configuration.v1.ZoektIndexOptions.LanguageOverridesEntry
+	12, // 8: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.repositories:type_name -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository
+	6,  // 9: sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest.Repository.branches:type_name -> sourcegraph.zoekt.configuration.v1.ZoektRepositoryBranch
+	1,  // 10: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.SearchConfiguration:input_type -> sourcegraph.zoekt.configuration.v1.SearchConfigurationRequest
+	7,  // 11: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.List:input_type -> sourcegraph.zoekt.configuration.v1.ListRequest
+	9,  // 12: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.UpdateIndexStatus:input_type -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusRequest
+	2,  // 13: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.SearchConfiguration:output_type -> sourcegraph.zoekt.configuration.v1.SearchConfigurationResponse
+	8,  // 14: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.List:output_type -> sourcegraph.zoekt.configuration.v1.ListResponse
+	10, // 15: sourcegraph.zoekt.configuration.v1.ZoektConfigurationService.UpdateIndexStatus:output_type -> sourcegraph.zoekt.configuration.v1.UpdateIndexStatusResponse
+	13, // [13:16] is the sub-list for method output_type
+	10, // [10:13] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_configuration_proto_init() }
@@ -1140,7 +1240,7 @@ func file_configuration_proto_init() {
 				return nil
 			}
 		}
-		file_configuration_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+		file_configuration_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*UpdateIndexStatusRequest_Repository); i {
 			case 0:
 				return &v.state
@@ -1159,7 +1259,7 @@ func file_configuration_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_configuration_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   11,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
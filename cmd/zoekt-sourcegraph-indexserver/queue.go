@@ -40,6 +40,16 @@ type queueItem struct {
 	dateAddedToQueue time.Time
 	// backoff will handle backing off of future indexing requests for a duration of time based on previous failures
 	backoff backoff
+	// paused is true if repoID must not be added to the queue, see Pause.
+	paused bool
+	// force is true if repoID was added to the queue with Force.
+	force bool
+	// lastIndexed is the time the last successful index job for repoID
+	// finished.
+	lastIndexed time.Time
+	// lastError is the error of the last index job for repoID, or empty if
+	// it succeeded.
+	lastError string
 }
 
 // Queue is a priority queue which returns the next repo to index. It is safe
@@ -87,6 +97,8 @@ type QueueItem struct {
 	Opts IndexOptions
 	// DateAddedToQueue is the time when this indexing job was added to the queue, used for telemetry.
 	DateAddedToQueue time.Time
+	// Force is true if the repo must be reindexed from scratch, see Queue.Force.
+	Force bool
 }
 
 // Pop returns options and metadata for the next repo to index. If the queue is empty ok is false.
@@ -105,9 +117,12 @@ func (q *Queue) Pop() (result QueueItem, ok bool) {
 	dateAdded := item.dateAddedToQueue
 	item.dateAddedToQueue = time.Unix(0, 0)
 
+	force := item.force
+	item.force = false
+
 	q.mu.Unlock()
 
-	return QueueItem{item.opts, dateAdded}, true
+	return QueueItem{item.opts, dateAdded, force}, true
 }
 
 // Len returns the number of items in the queue.
@@ -128,7 +143,7 @@ func (q *Queue) AddOrUpdate(opts IndexOptions) {
 		item.opts = opts
 	}
 	if item.heapIdx < 0 {
-		if item.backoff.Allow(time.Now()) {
+		if !item.paused && item.backoff.Allow(time.Now()) {
 			q.seq++
 			item.seq = q.seq
 			item.dateAddedToQueue = time.Now()
@@ -156,7 +171,7 @@ func (q *Queue) Bump(ids []uint32) []uint32 {
 		if !ok {
 			missing = append(missing, id)
 		} else if item.heapIdx < 0 {
-			if item.backoff.Allow(time.Now()) {
+			if !item.paused && item.backoff.Allow(time.Now()) {
 				q.seq++
 				item.seq = q.seq
 				item.dateAddedToQueue = time.Now()
@@ -300,6 +315,79 @@ func (q *Queue) SetIndexed(opts IndexOptions, state indexState) {
 	q.mu.Unlock()
 }
 
+// SetResult records the outcome of an index job for repoID, as shown by the
+// status page. err is nil if the job succeeded. Repositories unknown to the
+// queue are ignored.
+func (q *Queue) SetResult(repoID uint32, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := q.get(repoID)
+	if item == nil {
+		return
+	}
+	if err != nil {
+		item.lastError = err.Error()
+	} else {
+		item.lastIndexed = time.Now()
+		item.lastError = ""
+	}
+}
+
+// Pause removes repoID from the queue and keeps it from being added again
+// until it is resumed by calling Pause with paused false. A resumed repository
+// is added back to the queue. Pause returns false if repoID is unknown to the
+// queue.
+func (q *Queue) Pause(repoID uint32, paused bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := q.get(repoID)
+	if item == nil {
+		return false
+	}
+
+	item.paused = paused
+	if paused && item.heapIdx >= 0 {
+		heap.Remove(&q.pq, item.heapIdx)
+	} else if !paused && item.heapIdx < 0 {
+		q.seq++
+		item.seq = q.seq
+		item.dateAddedToQueue = time.Now()
+		heap.Push(&q.pq, item)
+	}
+
+	metricQueueLen.Set(float64(len(q.pq)))
+	return true
+}
+
+// Force moves repoID to the front of the queue, adding it if necessary, and
+// marks it to be reindexed from scratch once popped. Unlike AddOrUpdate and
+// Bump, Force ignores Pause and backoff. Force returns false if repoID is
+// unknown to the queue.
+func (q *Queue) Force(repoID uint32) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := q.get(repoID)
+	if item == nil {
+		return false
+	}
+
+	item.force = true
+	if item.heapIdx < 0 {
+		q.seq++
+		item.seq = q.seq
+		item.dateAddedToQueue = time.Now()
+		heap.Push(&q.pq, item)
+	} else {
+		heap.Fix(&q.pq, item.heapIdx)
+	}
+
+	metricQueueLen.Set(float64(len(q.pq)))
+	return true
+}
+
 // MaybeRemoveMissing will remove all queue items not in ids and return the
 // ids of items removed from the queue. It will heuristically not run to
 // conserve resources.
@@ -407,6 +495,11 @@ func (pq *pqueue) Pop() interface{} {
 
 // lessQueueItemPriority returns true if indexing x should be prioritized over indexing y
 func lessQueueItemPriority(x, y *queueItem) bool {
+	// Repos forced by an operator come first.
+	if x.force != y.force {
+		return x.force
+	}
+
 	// If we know x needs an update and y doesn't, then return true. Otherwise
 	// they are either equal priority or y is more urgent.
 	if x.indexed != y.indexed {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -134,6 +135,61 @@ func TestQueue_Bump(t *testing.T) {
 	}
 }
 
+func TestQueue_Pause(t *testing.T) {
+	queue := NewQueue(0, 0, logtest.Scoped(t))
+
+	queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "foo"})
+	queue.AddOrUpdate(IndexOptions{RepoID: 2, Name: "bar"})
+
+	if queue.Pause(3, true) {
+		t.Fatal("paused unknown repository 3")
+	}
+	if !queue.Pause(1, true) {
+		t.Fatal("failed to pause repository 1")
+	}
+
+	// Neither updates nor bumps add a paused repository back to the queue.
+	queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "foo", Priority: 1})
+	queue.Bump([]uint32{1})
+	if l := queue.Len(); l != 1 {
+		t.Fatalf("got queue length %d, want 1", l)
+	}
+
+	queue.Pause(1, false)
+	var got []uint32
+	for {
+		item, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, item.Opts.RepoID)
+	}
+	if d := cmp.Diff([]uint32{2, 1}, got); d != "" {
+		t.Errorf("unexpected items popped (-want, +got):\n%s", d)
+	}
+}
+
+func TestQueue_SetResult(t *testing.T) {
+	queue := NewQueue(0, 0, logtest.Scoped(t))
+	queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "foo"})
+
+	// Unknown repositories are not added.
+	queue.SetResult(2, nil)
+	if _, ok := queue.items[2]; ok {
+		t.Fatal("SetResult added repository 2")
+	}
+
+	queue.SetResult(1, errors.New("boom"))
+	if item := queue.items[1]; item.lastError != "boom" || !item.lastIndexed.IsZero() {
+		t.Fatalf("unexpected item after failure: %+v", item)
+	}
+
+	queue.SetResult(1, nil)
+	if item := queue.items[1]; item.lastError != "" || item.lastIndexed.IsZero() {
+		t.Fatalf("unexpected item after success: %+v", item)
+	}
+}
+
 func TestQueue_Integration_DebugQueue(t *testing.T) {
 	// helper function to normalize the queue's debug output - this makes the test less brittle
 	// + makes it much less annoying to make edits to the expected output in a way that doesn't
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/plumbing/format/config"
 	"golang.org/x/net/trace"
 
 	proto "github.com/sourcegraph/zoekt/cmd/zoekt-sourcegraph-indexserver/protos/sourcegraph/zoekt/configuration/v1"
@@ -292,6 +293,9 @@ func (o *indexOptionsItem) FromProto(x *proto.ZoektIndexOptions) {
 		Branches:   branches,
 		Name:       x.GetName(),
 
+		ExcludePatterns: x.GetExcludePatterns(),
+		IncludePatterns: x.GetIncludePatterns(),
+
 		Priority: x.GetPriority(),
 
 		Public:   x.GetPublic(),
@@ -302,6 +306,13 @@ func (o *indexOptionsItem) FromProto(x *proto.ZoektIndexOptions) {
 		ShardConcurrency: x.GetShardConcurrency(),
 
 		TenantID: int(x.TenantId),
+
+		MaxFileSize:     int(x.GetMaxFileSize()),
+		MaxLineLength:   int(x.GetMaxLineLength()),
+		MaxTrigramCount: int(x.GetMaxTrigramCount()),
+
+		LanguageOverrides: x.GetLanguageOverrides(),
+		NgramSize:         int(x.GetNgramSize()),
 	}
 
 	item.Error = x.GetError()
@@ -334,6 +345,9 @@ func (o *indexOptionsItem) ToProto() *proto.ZoektIndexOptions {
 		Branches:   branches,
 		Name:       o.Name,
 
+		ExcludePatterns: o.ExcludePatterns,
+		IncludePatterns: o.IncludePatterns,
+
 		Priority: o.Priority,
 
 		Public:   o.Public,
@@ -346,6 +360,13 @@ func (o *indexOptionsItem) ToProto() *proto.ZoektIndexOptions {
 		ShardConcurrency: o.ShardConcurrency,
 
 		TenantId: int64(o.TenantID),
+
+		MaxFileSize:     int64(o.MaxFileSize),
+		MaxLineLength:   int64(o.MaxLineLength),
+		MaxTrigramCount: int64(o.MaxTrigramCount),
+
+		LanguageOverrides: o.LanguageOverrides,
+		NgramSize:         int64(o.NgramSize),
 	}
 }
 
@@ -591,10 +612,40 @@ func (sf sourcegraphFake) getIndexOptions(name string) (IndexOptions, error) {
 	}
 	opts.Branches = branches
 
+	sec, err := sf.zoektConfig(name)
+	if err != nil {
+		return opts, err
+	}
+	for _, o := range sec.Options.GetAll("languageOverride") {
+		pattern, lang, ok := strings.Cut(o, "=")
+		if !ok {
+			return opts, fmt.Errorf("zoekt.languageOverride %q of %s: want GLOB=LANGUAGE", o, name)
+		}
+		if opts.LanguageOverrides == nil {
+			opts.LanguageOverrides = map[string]string{}
+		}
+		opts.LanguageOverrides[pattern] = lang
+	}
+	opts.ExcludePatterns = sec.Options.GetAll("excludePath")
+	opts.IncludePatterns = sec.Options.GetAll("includePath")
+	if v := sec.Options.Get("ngramSize"); v != "" {
+		if opts.NgramSize, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("zoekt.ngramSize of %s: %w", name, err)
+		}
+	}
+
 	return opts, nil
 }
 
-func (sf sourcegraphFake) getBranches(name string) ([]zoekt.RepositoryBranch, error) {
+// zoektConfig returns the zoekt section of the git config of the repository,
+// which can set the index options Sourcegraph would return for it, eg.
+//
+//	[zoekt]
+//		branch = main
+//		languageOverride = *.inc=PHP
+//		ngramSize = 2
+//		excludePath = vendor/**
+func (sf sourcegraphFake) zoektConfig(name string) (*gitconfig.Section, error) {
 	dir := filepath.Join(sf.RootDir, filepath.FromSlash(name))
 	repo, err := git.PlainOpen(dir)
 	if err != nil {
@@ -606,7 +657,16 @@ func (sf sourcegraphFake) getBranches(name string) ([]zoekt.RepositoryBranch, er
 		return nil, err
 	}
 
-	sec := cfg.Raw.Section("zoekt")
+	return cfg.Raw.Section("zoekt"), nil
+}
+
+func (sf sourcegraphFake) getBranches(name string) ([]zoekt.RepositoryBranch, error) {
+	sec, err := sf.zoektConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(sf.RootDir, filepath.FromSlash(name))
 	branches := sec.Options.GetAll("branch")
 	if len(branches) == 0 {
 		branches = append(branches, "HEAD")
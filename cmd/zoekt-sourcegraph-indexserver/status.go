@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// repoStatus is the indexing status of a repository known to the queue.
+type repoStatus struct {
+	ID   uint32
+	Name string
+
+	// Position is the position of the repository in the queue, or -1 if it
+	// isn't queued.
+	Position int
+
+	// Indexed is true if the index is up to date with the latest index options.
+	Indexed bool
+
+	// State is the indexState of the last index job.
+	State indexState
+
+	// Paused is true if the repository was paused with /debug/pause.
+	Paused bool
+
+	// LastIndexed is the time the last successful index job finished. It is
+	// zero if the repository hasn't been indexed since indexserver started.
+	LastIndexed time.Time
+	LastError   string `json:",omitempty"`
+
+	// Shards is the number of shards containing the repository, and
+	// ShardBytes their size on disk. A compound shard is counted in full for
+	// each of its repositories.
+	Shards     int
+	ShardBytes int64
+}
+
+// serverStatus is the status of indexserver, as served by /debug/status.
+type serverStatus struct {
+	// Paused is true if indexing is paused with the PAUSE file in the index
+	// directory.
+	Paused bool
+
+	// Merge is the merge in progress, or nil.
+	Merge *mergeStatus `json:",omitempty"`
+
+	// Repos are sorted by indexing priority, queued repositories first.
+	Repos []repoStatus
+}
+
+// status returns a snapshot of the queue, the shards in the index directory
+// and the merge in progress.
+func (s *Server) status() serverStatus {
+	var st serverStatus
+
+	if _, err := os.Stat(filepath.Join(s.IndexDir, pauseFileName)); err == nil {
+		st.Paused = true
+	}
+	st.Merge = getRunningMerge()
+
+	shards := getShards(s.IndexDir)
+	sizes := map[string]int64{}
+	for _, ss := range shards {
+		for _, sh := range ss {
+			if _, ok := sizes[sh.Path]; ok {
+				continue
+			}
+			if fi, err := os.Stat(sh.Path); err == nil {
+				sizes[sh.Path] = fi.Size()
+			}
+		}
+	}
+
+	position := 0
+	s.queue.debugIteratedOrdered(func(item *queueItem) {
+		rs := repoStatus{
+			ID:          item.repoID,
+			Name:        item.opts.Name,
+			Position:    -1,
+			Indexed:     item.indexed,
+			State:       item.indexState,
+			Paused:      item.paused,
+			LastIndexed: item.lastIndexed,
+			LastError:   item.lastError,
+			Shards:      len(shards[item.repoID]),
+		}
+		if item.heapIdx >= 0 {
+			rs.Position = position
+			position++
+		}
+		for _, sh := range shards[item.repoID] {
+			rs.ShardBytes += sizes[sh.Path]
+		}
+		st.Repos = append(st.Repos, rs)
+	})
+
+	return st
+}
+
+var statusTmpl = template.Must(template.New("status").Funcs(template.FuncMap{
+	"mib": func(n int64) string {
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1024*1024))
+	},
+}).Parse(`
+<html>
+    <body>
+        <a href="status?format=json">JSON</a><br />
+        {{if .Paused}}<p><b>Indexing is paused by the PAUSE file in the index directory.</b></p>{{end}}
+        <h3>Merge</h3>
+        {{with .Merge}}
+            Merging {{len .Shards}} shards ({{mib .SizeBytes}}) since {{.Started.Format "2006-01-02 15:04:05"}}
+        {{else}}
+            No merge running.
+        {{end}}
+        <h3>Repositories</h3>
+        <table>
+            <tr>
+                <th style="text-align:left">Position</th>
+                <th style="text-align:left">Name</th>
+                <th style="text-align:left">ID</th>
+                <th style="text-align:left">State</th>
+                <th style="text-align:left">Last indexed</th>
+                <th style="text-align:left">Last error</th>
+                <th style="text-align:left">Shards</th>
+                <th style="text-align:left">Size</th>
+                <th></th>
+            </tr>
+            {{range .Repos}}
+                <tr>
+                    <td>{{if .Paused}}paused{{else if ge .Position 0}}{{.Position}}{{else}}-{{end}}</td>
+                    <td>{{.Name}}</td>
+                    <td>{{.ID}}</td>
+                    <td>{{.State}}</td>
+                    <td>{{if .LastIndexed.IsZero}}-{{else}}{{.LastIndexed.Format "2006-01-02 15:04:05"}}{{end}}</td>
+                    <td>{{.LastError}}</td>
+                    <td>{{.Shards}}</td>
+                    <td>{{mib .ShardBytes}}</td>
+                    <td>
+                        <form method="post" action="status" style="display:inline">
+                            <input type="hidden" name="repo" value="{{.ID}}" />
+                            <button name="action" value="reindex">reindex</button>
+                            {{if .Paused}}
+                                <button name="action" value="resume">resume</button>
+                            {{else}}
+                                <button name="action" value="pause">pause</button>
+                            {{end}}
+                        </form>
+                    </td>
+                </tr>
+            {{end}}
+        </table>
+    </body>
+</html>
+`))
+
+// handleStatus serves the status of the queue, the shards and the merge in
+// progress as HTML, or as JSON with format=json. POST requests with the
+// parameters repo and action (reindex, pause or resume) act on a repository
+// and redirect back to the status page.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.handleStatusAction(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.status()
+
+	if r.URL.Query().Get("format") == "json" {
+		b, err := json.Marshal(st)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(b)
+		return
+	}
+
+	_ = statusTmpl.Execute(w, st)
+}
+
+func (s *Server) handleStatusAction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.Form.Get("repo"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch action := r.Form.Get("action"); action {
+	case "reindex":
+		go func() { s.forceIndex(uint32(id)) }()
+	case "pause", "resume":
+		if !s.queue.Pause(uint32(id), action == "pause") {
+			http.Error(w, fmt.Sprintf("repository %d not found", id), http.StatusNotFound)
+			return
+		}
+		infoLog.Printf("set paused=%t for %d", action == "pause", id)
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "status", http.StatusSeeOther)
+}
+
+// handlePause pauses (pause=true, the default) or resumes (pause=false)
+// indexing of the repository with the ID given by the parameter repo. A paused
+// repository is taken off the queue until it is resumed, but it can still be
+// reindexed with /debug/reindex. It responds with 404 if the repository is
+// unknown to the queue.
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.Form.Get("repo"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	paused := true
+	if v := r.Form.Get("pause"); v != "" {
+		if paused, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !s.queue.Pause(uint32(id), paused) {
+		http.Error(w, fmt.Sprintf("repository %d not found", id), http.StatusNotFound)
+		return
+	}
+	infoLog.Printf("set paused=%t for %d", paused, id)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/log/logtest"
+)
+
+func TestHandleStatus(t *testing.T) {
+	dir := t.TempDir()
+	createCompoundShard(t, dir, []uint32{1, 2})
+
+	s := &Server{IndexDir: dir, queue: *NewQueue(0, 0, logtest.Scoped(t))}
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "repo1"})
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 2, Name: "repo2"})
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 3, Name: "repo3"})
+	s.queue.SetResult(1, errors.New("boom"))
+
+	do := func(method, target, form string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(target, "/debug/pause") {
+			s.handlePause(w, req)
+		} else {
+			s.handleStatus(w, req)
+		}
+		return w
+	}
+
+	if w := do(http.MethodPost, "/debug/pause", "repo=2"); w.Code != http.StatusOK {
+		t.Fatalf("pause: got %d %q", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/debug/status", "repo=3&action=pause"); w.Code != http.StatusSeeOther {
+		t.Fatalf("pause action: got %d %q", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/debug/status", "repo=3&action=resume"); w.Code != http.StatusSeeOther {
+		t.Fatalf("resume action: got %d %q", w.Code, w.Body.String())
+	}
+
+	w := do(http.MethodGet, "/debug/status?format=json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: got %d %q", w.Code, w.Body.String())
+	}
+	var st serverStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Paused || st.Merge != nil || len(st.Repos) != 3 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	got := map[uint32]repoStatus{}
+	for _, rs := range st.Repos {
+		got[rs.ID] = rs
+	}
+	if rs := got[1]; rs.Position != 0 || rs.LastError != "boom" || rs.Shards != 1 || rs.ShardBytes == 0 {
+		t.Errorf("unexpected status for repo1: %+v", rs)
+	}
+	if rs := got[2]; rs.Position != -1 || !rs.Paused || rs.Shards != 1 {
+		t.Errorf("unexpected status for repo2: %+v", rs)
+	}
+	if rs := got[3]; rs.Position != 1 || rs.Paused || rs.Shards != 0 {
+		t.Errorf("unexpected status for repo3: %+v", rs)
+	}
+
+	if w := do(http.MethodGet, "/debug/status", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "boom") {
+		t.Fatalf("status page: got %d %q", w.Code, w.Body.String())
+	}
+
+	for form, want := range map[string]int{
+		"repo=4":          http.StatusNotFound,
+		"repo=foo":        http.StatusBadRequest,
+		"repo=1&pause=no": http.StatusBadRequest,
+	} {
+		if w := do(http.MethodPost, "/debug/pause", form); w.Code != want {
+			t.Errorf("%s: got %d, want %d", form, w.Code, want)
+		}
+	}
+	if w := do(http.MethodPost, "/debug/status", "repo=1&action=delete"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown action: got %d", w.Code)
+	}
+}
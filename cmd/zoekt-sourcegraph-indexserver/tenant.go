@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+var metricTenantDiskUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "index_tenant_disk_usage_bytes",
+	Help: "The size of the shards of each tenant in the index directory, as of the last sync with Sourcegraph.",
+}, []string{"tenant"})
+
+// errTenantDiskQuota is returned by Index for repositories of tenants which
+// exceed their disk quota.
+var errTenantDiskQuota = fmt.Errorf("tenant disk quota exceeded")
+
+// tenantDiskQuota limits the disk usage of each tenant in the index
+// directory. Usage is computed on every sync with Sourcegraph, so a tenant can
+// exceed its quota by the repositories indexed in between.
+type tenantDiskQuota struct {
+	// limit is the quota in bytes. 0 disables the quota.
+	limit int64
+
+	mu    sync.Mutex
+	usage map[int]int64
+}
+
+// update recomputes the disk usage of all tenants in indexDir.
+func (q *tenantDiskQuota) update(indexDir string) {
+	usage := tenantDiskUsage(indexDir)
+
+	metricTenantDiskUsage.Reset()
+	for id, bytes := range usage {
+		metricTenantDiskUsage.WithLabelValues(strconv.Itoa(id)).Set(float64(bytes))
+	}
+
+	q.mu.Lock()
+	q.usage = usage
+	q.mu.Unlock()
+}
+
+// check returns errTenantDiskQuota if tenantID uses at least its quota.
+func (q *tenantDiskQuota) check(tenantID int) error {
+	if q.limit <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	used := q.usage[tenantID]
+	q.mu.Unlock()
+	if used >= q.limit {
+		return fmt.Errorf("%w: tenant %d uses %d of %d bytes", errTenantDiskQuota, tenantID, used, q.limit)
+	}
+	return nil
+}
+
+// tenantDiskUsage returns the size of the shards in dir per tenant. The size
+// of a compound shard is split evenly between its live repositories.
+func tenantDiskUsage(dir string) map[int]int64 {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if err != nil {
+		return nil
+	}
+
+	usage := map[int]int64{}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		repos, _, err := zoekt.ReadMetadataPathAlive(p)
+		if err != nil {
+			debugLog.Printf("tenantDiskUsage: failed to read shard %s: %v", p, err)
+			continue
+		}
+		for _, r := range repos {
+			usage[r.TenantID] += fi.Size() / int64(len(repos))
+		}
+	}
+	return usage
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestTenantDiskQuota(t *testing.T) {
+	dir := t.TempDir()
+	withTenant := func(id int) func(*zoekt.Repository) {
+		return func(r *zoekt.Repository) { r.TenantID = id }
+	}
+	createTestShard(t, "repo1", 1, filepath.Join(dir, "repo1.zoekt"), withTenant(1))
+	createTestShard(t, "repo2", 2, filepath.Join(dir, "repo2.zoekt"), withTenant(1))
+	createTestShard(t, "repo3", 3, filepath.Join(dir, "repo3.zoekt"), withTenant(2))
+
+	size := func(name string) int64 {
+		t.Helper()
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	usage := tenantDiskUsage(dir)
+	if got, want := usage[1], size("repo1.zoekt")+size("repo2.zoekt"); got != want {
+		t.Fatalf("tenant 1: got %d bytes, want %d", got, want)
+	}
+	if got, want := usage[2], size("repo3.zoekt"); got != want {
+		t.Fatalf("tenant 2: got %d bytes, want %d", got, want)
+	}
+
+	q := tenantDiskQuota{limit: usage[1]}
+	q.update(dir)
+	if err := q.check(1); !errors.Is(err, errTenantDiskQuota) {
+		t.Fatalf("tenant 1: got %v, want %v", err, errTenantDiskQuota)
+	}
+	if err := q.check(2); err != nil {
+		t.Fatalf("tenant 2: %v", err)
+	}
+
+	// A quota of 0 is unlimited.
+	q.limit = 0
+	if err := q.check(1); err != nil {
+		t.Fatalf("no quota: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+// zoekt-upgrade-index rewrites shards in the current index format, without
+// reindexing them from source. Shards which are already current are left
+// alone, unless -force is given.
+//
+//	zoekt-upgrade-index /data/index
+//	zoekt-upgrade-index /data/index/repo_v16.00000.zoekt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// upgrade rewrites the shard at path and replaces it with the result. It
+// returns false if the shard is current.
+func upgrade(path string, force bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	indexFile, err := zoekt.NewIndexFile(f)
+	if err != nil {
+		return false, err
+	}
+	defer indexFile.Close()
+
+	if !force {
+		_, md, err := zoekt.ReadMetadata(indexFile)
+		if err != nil {
+			return false, err
+		}
+		if !zoekt.NeedsUpgrade(md) {
+			return false, nil
+		}
+	}
+
+	tmpName, dstName, err := zoekt.Upgrade(filepath.Dir(path), indexFile)
+	if err != nil {
+		return false, fmt.Errorf("zoekt.Upgrade: %w", err)
+	}
+	defer os.Remove(tmpName)
+
+	// Like the builder, we update the manifest before the shard is in place.
+	if err := moveManifestEntry(path, dstName); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		return false, err
+	}
+	if dstName == path {
+		// The new shard has the same repositories as the old one, so its
+		// ".meta" file still fits.
+		return true, nil
+	}
+
+	// The new shard includes the metadata overrides of the ".meta" file.
+	paths, err := zoekt.IndexFilePaths(path)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil {
+			return false, err
+		}
+	}
+	return true, zoekt.PruneShardManifest(path)
+}
+
+// moveManifestEntry adds the shard at newPath to the manifest of its shard
+// set with the ID and directory of the shard at oldPath, if the latter is
+// listed in a manifest, see zoekt.ShardManifest. Upgrade keeps the ID of
+// shards, so there is nothing to do if the name doesn't change.
+func moveManifestEntry(oldPath, newPath string) error {
+	if oldPath == newPath {
+		return nil
+	}
+	old, err := zoekt.ReadShardManifest(zoekt.ShardManifestPath(oldPath))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	oldName := filepath.Base(oldPath)
+	id, ok := old.Shards[oldName]
+	if !ok {
+		return nil
+	}
+
+	mp := zoekt.ShardManifestPath(newPath)
+	if mp == "" {
+		return fmt.Errorf("%s: not a shard name", newPath)
+	}
+	m, err := zoekt.ReadShardManifest(mp)
+	if os.IsNotExist(err) {
+		m = &zoekt.ShardManifest{Shards: map[string]string{}}
+	} else if err != nil {
+		return err
+	}
+	name := filepath.Base(newPath)
+	m.Shards[name] = id
+	if dir, ok := old.Directories[oldName]; ok {
+		if m.Directories == nil {
+			m.Directories = map[string]string{}
+		}
+		m.Directories[name] = dir
+	}
+	return zoekt.WriteShardManifest(mp, m)
+}
+
+func main() {
+	force := flag.Bool("force", false, "also rewrite shards which are in the current format")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: zoekt-upgrade-index [-force] (INDEX_DIR | SHARD)...")
+	}
+
+	var paths []string
+	for _, arg := range flag.Args() {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		shards, err := filepath.Glob(filepath.Join(arg, "*.zoekt"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		paths = append(paths, shards...)
+	}
+
+	failed := false
+	for _, path := range paths {
+		upgraded, err := upgrade(path, *force)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+		if upgraded {
+			log.Printf("upgraded %s", path)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
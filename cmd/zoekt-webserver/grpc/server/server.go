@@ -2,9 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"math"
 
 	"github.com/sourcegraph/zoekt/grpc/chunk"
+	"github.com/sourcegraph/zoekt/grpc/grpcutil"
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -32,7 +34,7 @@ func (s *Server) Search(ctx context.Context, req *proto.SearchRequest) (*proto.S
 
 	res, err := s.streamer.Search(ctx, q, zoekt.SearchOptionsFromProto(req.GetOpts()))
 	if err != nil {
-		return nil, err
+		return nil, grpcutil.SearchErrorStatus(err)
 	}
 
 	return res.ToProto(), nil
@@ -46,14 +48,14 @@ func (s *Server) StreamSearch(req *proto.StreamSearchRequest, ss proto.Webserver
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	sender := gRPCChunkSender(ss)
+	sender := gRPCChunkSender(ss, req.GetSplitFileMatches())
 	sampler := newSamplingSender(sender)
 
 	err = s.streamer.StreamSearch(ss.Context(), q, zoekt.SearchOptionsFromProto(request.GetOpts()), sampler)
 	if err == nil {
 		sampler.Flush()
 	}
-	return err
+	return grpcutil.SearchErrorStatus(err)
 }
 
 func (s *Server) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
@@ -70,12 +72,39 @@ func (s *Server) List(ctx context.Context, req *proto.ListRequest) (*proto.ListR
 	return repoList.ToProto(), nil
 }
 
+func (s *Server) GetFile(ctx context.Context, req *proto.GetFileRequest) (*proto.GetFileResponse, error) {
+	f, err := zoekt.GetFile(ctx, s.streamer, zoekt.FileRequestFromProto(req))
+	switch {
+	case errors.Is(err, zoekt.ErrInvalidFileRequest):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, zoekt.ErrFileNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, zoekt.ErrFileNotIndexed):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, zoekt.ErrChecksumMismatch):
+		return nil, status.Error(codes.DataLoss, err.Error())
+	case err != nil:
+		return nil, err
+	}
+
+	return f.ToProto(), nil
+}
+
 // gRPCChunkSender is a zoekt.Sender that sends small chunks of FileMatches to the provided gRPC stream.
-func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender {
+// If splitFileMatches is set, FileMatches too large for a chunk are split into several parts.
+func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer, splitFileMatches bool) zoekt.Sender {
 	f := func(r *zoekt.SearchResult) {
 		result := r.ToStreamProto().GetResponseChunk()
 
-		if len(result.GetFiles()) == 0 { // stats-only result, send it immediately
+		files := result.GetFiles()
+		if splitFileMatches {
+			files = nil
+			for _, fm := range result.GetFiles() {
+				files = append(files, chunk.SplitFileMatch(fm)...)
+			}
+		}
+
+		if len(files) == 0 { // stats-only result, send it immediately
 			_ = ss.Send(&proto.StreamSearchResponse{
 				ResponseChunk: result,
 			})
@@ -91,14 +120,16 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 			numFilesSent += len(filesChunk)
 
 			var stats *proto.Stats
-			if !statsSent { // We only send stats back on the first chunk
+			var directories []*proto.DirectoryMatch
+			if !statsSent { // We only send stats and directories back on the first chunk
 				statsSent = true
 				stats = result.GetStats()
+				directories = result.GetDirectories()
 			}
 
 			progress := result.GetProgress()
 
-			if numFilesSent < len(result.GetFiles()) { // more chunks to come
+			if numFilesSent < len(files) { // more chunks to come
 				progress = &proto.Progress{
 					Priority: result.GetProgress().GetPriority(),
 
@@ -113,7 +144,8 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 
 			return ss.Send(&proto.StreamSearchResponse{
 				ResponseChunk: &proto.SearchResponse{
-					Files: filesChunk,
+					Files:       filesChunk,
+					Directories: directories,
 
 					Stats:    stats,
 					Progress: progress,
@@ -121,7 +153,7 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 			})
 		}
 
-		_ = chunk.SendAll(sendFunc, result.GetFiles()...)
+		_ = chunk.SendAll(sendFunc, files...)
 	}
 
 	return zoekt.SenderFunc(f)
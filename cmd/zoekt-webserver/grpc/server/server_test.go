@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sourcegraph/zoekt/grpc/chunk"
 	"github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"go.uber.org/atomic"
 	"golang.org/x/net/http2"
@@ -110,7 +112,7 @@ func TestClientServer(t *testing.T) {
 func TestFuzzGRPCChunkSender(t *testing.T) {
 	validateResult := func(input zoekt.SearchResult) error {
 		clientStream, serverStream := newPairedSearchStream(t)
-		sender := gRPCChunkSender(serverStream)
+		sender := gRPCChunkSender(serverStream, false)
 
 		sender.Send(&input)
 
@@ -146,9 +148,10 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			opts := []cmp.Option{
 				protocmp.Transform(),
 				protocmp.IgnoreFields(&v1.SearchResponse{},
-					"progress", // progress is tested above
-					"stats",    // aggregated stats are tested below
-					"files",    // files are tested separately
+					"progress",    // progress is tested above
+					"stats",       // aggregated stats are tested below
+					"files",       // files are tested separately
+					"directories", // directories are tested separately
 				),
 			}
 
@@ -160,9 +163,11 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 		receivedStats := &zoekt.Stats{}
 
 		var receivedFileMatches []*v1.FileMatch
+		var receivedDirectories []*v1.DirectoryMatch
 		for _, r := range allResponses {
 			receivedStats.Add(zoekt.StatsFromProto(r.GetStats()))
 			receivedFileMatches = append(receivedFileMatches, r.GetFiles()...)
+			receivedDirectories = append(receivedDirectories, r.GetDirectories()...)
 		}
 
 		// Check to make sure that we get one set of stats back
@@ -181,6 +186,12 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			return fmt.Errorf("unexpected difference in file matches (-want +got):\n%s", diff)
 		}
 
+		// Directories are only sent once.
+		if diff := cmp.Diff(expectedResult.GetDirectories(), receivedDirectories,
+			protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
+			return fmt.Errorf("unexpected difference in directories (-want +got):\n%s", diff)
+		}
+
 		return nil
 	}
 
@@ -194,6 +205,48 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 	}
 }
 
+func TestGRPCChunkSenderSplitFileMatches(t *testing.T) {
+	const maxMessageSize = 1024 * 1024
+
+	var chunks []zoekt.ChunkMatch
+	for i := 0; i < 1000; i++ {
+		chunks = append(chunks, zoekt.ChunkMatch{
+			Content: bytes.Repeat([]byte("x"), 2000),
+			Ranges:  []zoekt.Range{{Start: zoekt.Location{LineNumber: uint32(i + 1)}, End: zoekt.Location{LineNumber: uint32(i + 1), Column: 1}}},
+		})
+	}
+	input := zoekt.SearchResult{
+		Files: []zoekt.FileMatch{
+			{FileName: "small.go", Repository: "foo/bar"},
+			{FileName: "huge.min.js", Repository: "foo/bar", ChunkMatches: chunks, Content: bytes.Repeat([]byte("y"), 3*maxMessageSize)},
+		},
+		Progress: zoekt.Progress{Priority: 1},
+	}
+
+	clientStream, serverStream := newPairedSearchStream(t)
+	gRPCChunkSender(serverStream, true).Send(&input)
+
+	var j chunk.FileMatchJoiner
+	var files []*v1.FileMatch
+	for _, r := range readAllStream(t, clientStream) {
+		if size := proto.Size(r); size > maxMessageSize {
+			t.Errorf("got a response of %d bytes, want at most %d", size, maxMessageSize)
+		}
+		files = append(files, j.Join(r.GetFiles())...)
+	}
+
+	// cmp.Diff takes seconds to compare megabytes of content.
+	want := input.ToProto().GetFiles()
+	if len(files) != len(want) {
+		t.Fatalf("got %d joined file matches, want %d", len(files), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(files[i], want[i]) {
+			t.Errorf("joined file match %s differs from the original", want[i].GetFileName())
+		}
+	}
+}
+
 // newPairedSearchStream returns a pair of client and server search streams that are connected to each other.
 func newPairedSearchStream(t *testing.T) (v1.WebserverService_StreamSearchClient, v1.WebserverService_StreamSearchServer) {
 	client := &mockSearchStreamClient{t: t}
@@ -242,7 +295,9 @@ type mockSearchStreamServer struct {
 }
 
 func (m *mockSearchStreamServer) Send(r *v1.StreamSearchResponse) error {
-	m.pairedClient.storeResponse(r)
+	// gRPC serializes the message before Send returns, so the sender may
+	// reuse its parts.
+	m.pairedClient.storeResponse(proto.Clone(r).(*v1.StreamSearchResponse))
 	return nil
 }
 
@@ -34,17 +34,22 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/grafana/regexp"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/sourcegraph/mountinfo"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	// Registers gzip, so clients can compress requests and get compressed
+	// responses, see client.Options.Compress.
+	_ "google.golang.org/grpc/encoding/gzip"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/build"
@@ -54,9 +59,22 @@ import (
 	"github.com/sourcegraph/zoekt/grpc/messagesize"
 	"github.com/sourcegraph/zoekt/grpc/propagator"
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/accesslog"
+	"github.com/sourcegraph/zoekt/internal/audit"
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/canary"
+	"github.com/sourcegraph/zoekt/internal/listener"
+	"github.com/sourcegraph/zoekt/internal/priority"
 	"github.com/sourcegraph/zoekt/internal/profiler"
+	"github.com/sourcegraph/zoekt/internal/queryprofile"
+	"github.com/sourcegraph/zoekt/internal/ratelimit"
+	"github.com/sourcegraph/zoekt/internal/replication"
+	"github.com/sourcegraph/zoekt/internal/resultcache"
+	"github.com/sourcegraph/zoekt/internal/searchcontext"
 	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/tracer"
+	"github.com/sourcegraph/zoekt/internal/traffic"
+	"github.com/sourcegraph/zoekt/quality"
 	"github.com/sourcegraph/zoekt/query"
 	"github.com/sourcegraph/zoekt/shards"
 	"github.com/sourcegraph/zoekt/trace"
@@ -139,15 +157,23 @@ func main() {
 	logDir := flag.String("log_dir", "", "log to this directory rather than stderr.")
 	logRefresh := flag.Duration("log_refresh", 24*time.Hour, "if using --log_dir, start writing a new file this often.")
 
-	listen := flag.String("listen", ":6070", "listen on this address.")
+	listen := flag.String("listen", ":6070", "listen on this address. Use unix:PATH to listen on a unix domain socket.")
+	proxyProtocol := flag.Bool("proxy_protocol", false, "expect a PROXY protocol (v1 or v2) header on every connection, as sent by L4 load balancers, and use its source address as the client address. Only set this if all clients connect through such a proxy.")
 	index := flag.String("index", build.DefaultDir, "set index directory to use")
 	html := flag.Bool("html", true, "enable HTML interface")
 	enableRPC := flag.Bool("rpc", false, "enable go/net RPC")
+	enableGraphQL := flag.Bool("graphql", false, "enable the GraphQL API at /graphql")
 	enableIndexserverProxy := flag.Bool("indexserver_proxy", false, "proxy requests with URLs matching the path /indexserver/ to <index>/indexserver.sock")
 	print := flag.Bool("print", false, "enable local result URLs")
 	enablePprof := flag.Bool("pprof", false, "set to enable remote profiling.")
+	trafficInterval := flag.Duration("traffic_stats_interval", 0, "if set, record which repositories searches find results in, and write search rates per repository to --index this often. Indexing uses them to size shards, see -hot_shard_limit.")
+	warmupRepos := flag.Int("warmup_repos", 0, "if set, before reporting ready after a restart, read the ngram index and file names of the shards of this many repositories with the highest search rates in the traffic statistics of --index (see --traffic_stats_interval) into the page cache, so the first searches aren't slow.")
+	queryProfiles := flag.Int("query_profiles", 0, "if set with --pprof and --auth_admins, honor SearchOptions.Profile (the profile=1 URL parameter) of admins and keep this many query profiles at /debug/queryprofiles, which only admins may read.")
 	sslCert := flag.String("ssl_cert", "", "set path to SSL .pem holding certificate.")
 	sslKey := flag.String("ssl_key", "", "set path to SSL .pem holding key.")
+	tlsClientCA := flag.String("tls_client_ca", "", "if set with --ssl_cert, accept client certificates signed by the CAs in this .pem file, and require a client certificate or a token (see --auth_tokens) for all requests but health checks. Certificates and tokens are read again on SIGHUP.")
+	authTokens := flag.String("auth_tokens", "", "if set, require a bearer token of this file, one per line and optionally followed by the name of the client, or a client certificate (see --tls_client_ca) for all requests but health checks. Clients send tokens in the Authorization header or gRPC metadata. Tokens are read again on SIGHUP, so they can be rotated.")
+	authAdmins := flag.String("auth_admins", "", "if using --auth_tokens or --tls_client_ca, a comma separated list of the names of the clients (the common names of their certificates or the names of their tokens) which may use the admin endpoints.")
 	hostCustomization := flag.String(
 		"host_customization", "",
 		"specify host customization, as HOST1=QUERY,HOST2=QUERY")
@@ -155,6 +181,32 @@ func main() {
 	templateDir := flag.String("template_dir", "", "set directory from which to load custom .html.tpl template files")
 	dumpTemplates := flag.Bool("dump_templates", false, "dump templates into --template_dir and exit.")
 	version := flag.Bool("version", false, "Print version number")
+	replicationPrimary := flag.Bool("replication_primary", false, "serve the shards in --index at /replication/ to standbys started with --standby_of. Requires --auth_admins, standbys must authenticate as an admin.")
+	standbyOf := flag.String("standby_of", "", "run as a warm standby of the zoekt-webserver at this URL (eg. http://primary:6070), which must run with --replication_primary. Its shards are copied into --index, and searches are only served while it is unavailable.")
+	standbyInterval := flag.Duration("standby_interval", 30*time.Second, "if using --standby_of, how often to copy changed shards.")
+	accessLog := flag.String("access_log", "", "if set, write an access log of searches to this file, for ingestion by log pipelines. Queries are hashed, not logged.")
+	accessLogFormat := flag.String("access_log_format", string(accesslog.W3C), "format of --access_log: \"w3c\" (W3C extended log file format) or \"json\".")
+	accessLogMaxSize := flag.Int64("access_log_max_size", 100<<20, "if using --access_log, rotate the file once it reaches this many bytes. 0 disables rotation.")
+	accessLogMaxFiles := flag.Int("access_log_max_files", 10, "if using --access_log, keep this many rotated files. 0 keeps all of them.")
+	auditLog := flag.String("audit_log", "", "if set, write an audit event with the query, the authenticated principal, who it searched on behalf of (the unverified X-Zoekt-Principal header), the latency and the number of matching repositories of every search to these comma separated sinks: file:PATH, syslog:, syslog://HOST:PORT, syslog+tcp://HOST:PORT or http(s)://URL.")
+	auditSampleRate := flag.Float64("audit_sample_rate", 1, "if using --audit_log, the fraction of searches which are audited.")
+	auditRedact := flag.String("audit_redact", "", "if using --audit_log, replace matches of this regular expression in audited queries with [REDACTED].")
+	searchContexts := flag.Bool("search_contexts", false, "store named search contexts in --index, managed at /api/contexts, and expand context:NAME in queries.")
+	canaryQueries := flag.String("canary_queries", "", "if set, continuously run the queries of this judgments file (see zoekt-quality) against the index, one every --canary_interval, export their latency and recall as metrics and show the last results at /debug/canary.")
+	canaryInterval := flag.Duration("canary_interval", time.Minute, "if using --canary_queries, the time between two canary queries.")
+	maxContentBytes := flag.Int64("max_content_bytes_loaded", 0, "if set, abort searches which load more than this many bytes of file contents, see SearchOptions.MaxContentBytesLoaded.")
+	maxRegexpTime := flag.Duration("max_regexp_time", 0, "if set, abort searches which spend more than this much time matching regular expressions, see SearchOptions.MaxRegexpTime.")
+	rateLimits := flag.String("rate_limits", "", "if set, limit the searches of each client to the rate of its class: a comma separated list of CLASS=RATE[:BURST], where RATE is in searches per second, eg. default=10:20,batch=1. Clients are identified by their authenticated principal (see --auth_tokens and --tls_client_ca), else by --rate_limit_header if set, else by their IP address. Rejected searches fail with HTTP 429 or gRPC RESOURCE_EXHAUSTED.")
+	rateLimitClasses := flag.String("rate_limit_classes", "", "if using --rate_limits, a comma separated list of PATTERN=CLASS assigning the clients whose identity matches the glob PATTERN to CLASS. The first match wins, and other clients are in the class \"default\". Clients of classes without a rate are not limited.")
+	rateLimitHeader := flag.String("rate_limit_header", "", "if using --rate_limits, the header identifying unauthenticated clients, eg. X-Zoekt-Principal. It is trusted, so only set it if the webserver is only reachable through a frontend which sets it.")
+	maxResultBytes := flag.Int64("max_result_bytes", 0, "if set, limit the estimated memory held by the results of all concurrent searches to this many bytes. Searches which would exceed it return partial results with the flush reason memory_exceeded.")
+	resultCacheBytes := flag.Int64("result_cache_bytes", 0, "if set, cache search results in memory up to this many bytes. Cached results are dropped when the index changes.")
+	resultCacheRedis := flag.String("result_cache_redis", "", "if set, cache search results in the Redis server at this host:port instead of in memory, so replicas share them.")
+	resultCacheTTL := flag.Duration("result_cache_ttl", time.Hour, "if using --result_cache_redis, how long Redis keeps results.")
+	mmapAdvice := flag.String("mmap_advice", "", "mmap advice for shards: a comma separated list of ADVICE for whole shards, or SECTION=ADVICE pairs for sections like postings or fileContents. ADVICE is one of normal, random, sequential and willneed. On network filesystems, random avoids wasted readahead.")
+	shardIO := flag.String("shard_io", "mmap", "how to read shards: \"mmap\", \"pread\" to read with pread(2) instead of mapping shards, or \"direct\" to also bypass the page cache with O_DIRECT (Linux only), for cold storage.")
+	standbyMaxFailures := flag.Int("standby_max_failures", 3, "if using --standby_of, the number of consecutive failures to reach the primary after which the standby serves searches.")
+	standbyTokenFile := flag.String("standby_token_file", "", "if using --standby_of, the file holding the bearer token of an admin of the primary.")
 
 	flag.Parse()
 
@@ -212,20 +264,190 @@ func main() {
 	// Do not block on loading shards so we can become partially available
 	// sooner. Otherwise on large instances zoekt can be unavailable on the
 	// order of minutes.
-	searcher, err := shards.NewDirectorySearcherFast(*index)
+	var indexFileOpts zoekt.IndexFileOptions
+	if err := indexFileOpts.ParseAdvice(*mmapAdvice); err != nil {
+		log.Fatalf("-mmap_advice: %v", err)
+	}
+	switch *shardIO {
+	case "mmap":
+	case "pread":
+		indexFileOpts.Pread = true
+	case "direct":
+		indexFileOpts.Pread = true
+		indexFileOpts.Direct = true
+	default:
+		log.Fatalf("-shard_io: unknown value %q", *shardIO)
+	}
+
+	var warmRepos []string
+	if *warmupRepos > 0 {
+		stats, err := traffic.Read(*index)
+		if err != nil {
+			log.Printf("not warming shards: %v", err)
+		}
+		warmRepos = stats.Top(*warmupRepos)
+	}
+
+	searcher, err := shards.NewDirectorySearcherWithOptions(*index, shards.SearcherOptions{
+		Fast:           true,
+		MaxResultBytes: *maxResultBytes,
+		IndexFile:      indexFileOpts,
+		WarmRepos:      warmRepos,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	shardSearcher := searcher
+
+	// The cache wraps the shards directly, so it is keyed on queries after
+	// contextSearcher expands them and on options after budgetSearcher caps
+	// them.
+	var resultStore resultcache.Store
+	if *resultCacheRedis != "" {
+		resultStore = resultcache.NewRedisStore(*resultCacheRedis, *resultCacheTTL)
+	} else if *resultCacheBytes > 0 {
+		resultStore = resultcache.NewMemoryStore(*resultCacheBytes)
+	}
+	if resultStore != nil {
+		searcher = &resultcache.Searcher{
+			Streamer: searcher,
+			Store:    resultStore,
+			Epoch: func(ctx context.Context, q query.Q) (uint64, bool) {
+				return shards.Epoch(ctx, shardSearcher, q)
+			},
+		}
+	}
+
+	if *maxContentBytes > 0 || *maxRegexpTime > 0 {
+		searcher = &budgetSearcher{
+			Streamer:              searcher,
+			MaxContentBytesLoaded: *maxContentBytes,
+			MaxRegexpTime:         *maxRegexpTime,
+		}
+	}
+
+	var contexts *searchcontext.Store
+	if *searchContexts {
+		contexts, err = searchcontext.Open(*index)
+		if err != nil {
+			log.Fatalf("opening search contexts: %v", err)
+		}
+		searcher = &contextSearcher{
+			Streamer: searcher,
+			Store:    contexts,
+		}
+	}
+
+	// The canary bypasses query profiles, the access log and traffic
+	// statistics, which are about users.
+	canarySearcher := searcher
+
+	// Query profiles cover the whole process and expose what other searches
+	// are doing, so they are gated like the pprof endpoints, and only admins
+	// may capture and read them (see adminSearcher).
+	var profiles *queryprofile.Recorder
+	if *queryProfiles > 0 && *authAdmins == "" {
+		log.Fatal("--query_profiles requires --auth_admins")
+	}
+	if *queryProfiles > 0 && *enablePprof {
+		profiles = queryprofile.NewRecorder(*queryProfiles)
+		searcher = &profiledSearcher{
+			Streamer: searcher,
+			Recorder: profiles,
+		}
+	} else if *queryProfiles > 0 {
+		log.Fatal("--query_profiles requires --pprof")
+	}
+
+	if *trafficInterval > 0 {
+		stats, err := traffic.Read(*index)
+		if err != nil {
+			log.Printf("ignoring traffic statistics: %v", err)
+		}
+		rec := traffic.NewRecorder(stats, time.Now())
+		go writeTrafficStats(rec, *index, *trafficInterval)
+		searcher = &trafficSearcher{
+			Streamer: searcher,
+			Recorder: rec,
+		}
+	}
+
+	// Rejected searches are still logged and audited, so runaway clients
+	// show up there.
+	if *rateLimits != "" {
+		limits, err := ratelimit.ParseLimits(*rateLimits, *rateLimitClasses)
+		if err != nil {
+			log.Fatalf("--rate_limits: %v", err)
+		}
+		searcher = &ratelimit.Searcher{
+			Streamer: searcher,
+			Limiter:  ratelimit.New(limits),
+		}
+	}
+
+	var al *accesslog.Logger
+	if *accessLog != "" {
+		format, err := accesslog.ParseFormat(*accessLogFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		al, err = accesslog.Open(accesslog.Options{
+			Path:     *accessLog,
+			Format:   format,
+			MaxSize:  *accessLogMaxSize,
+			MaxFiles: *accessLogMaxFiles,
+		})
+		if err != nil {
+			log.Fatalf("opening access log: %v", err)
+		}
+		defer al.Close()
+	}
+
+	var auditLogger *audit.Logger
+	if *auditLog != "" {
+		opts := audit.Options{
+			SampleRate: *auditSampleRate,
+			OnError: func(err error) {
+				sglog.Scoped("audit").Warn("writing audit event failed", sglog.Error(err))
+			},
+		}
+		for _, spec := range strings.Split(*auditLog, ",") {
+			sink, err := audit.ParseSink(strings.TrimSpace(spec))
+			if err != nil {
+				log.Fatalf("--audit_log %s: %v", spec, err)
+			}
+			opts.Sinks = append(opts.Sinks, sink)
+		}
+		if *auditRedact != "" {
+			re, err := regexp.Compile(*auditRedact)
+			if err != nil {
+				log.Fatalf("--audit_redact: %v", err)
+			}
+			opts.Redact = audit.RedactPattern(re)
+		}
+		auditLogger = audit.New(opts)
+		defer auditLogger.Close()
+	}
 
 	searcher = &loggedSearcher{
-		Streamer: searcher,
-		Logger:   sglog.Scoped("searcher"),
+		Streamer:  searcher,
+		Logger:    sglog.Scoped("searcher"),
+		AccessLog: al,
+		Audit:     auditLogger,
 	}
 
+	// Clients can ask for any options, so those which only admins may use
+	// are cleared first.
+	searcher = &adminSearcher{Streamer: searcher}
+
 	s := &web.Server{
 		Searcher: searcher,
 		Top:      web.Top,
 		Version:  zoekt.Version,
+		LoadStatus: func() shards.LoadStatus {
+			st, _ := shards.Status(shardSearcher)
+			return st
+		},
 	}
 
 	if *templateDir != "" {
@@ -237,6 +459,7 @@ func main() {
 	s.Print = *print
 	s.HTML = *html
 	s.RPC = *enableRPC
+	s.GraphQL = *enableGraphQL
 
 	if *hostCustomization != "" {
 		s.HostCustomQueries = map[string]string{}
@@ -258,7 +481,31 @@ func main() {
 		log.Fatal(err)
 	}
 
-	debugserver.AddHandlers(serveMux, *enablePprof)
+	var debugPages []debugserver.DebugPage
+	if *canaryQueries != "" {
+		f, err := os.Open(*canaryQueries)
+		if err != nil {
+			log.Fatal(err)
+		}
+		judgments, err := quality.ReadJudgments(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", *canaryQueries, err)
+		}
+		runner := &canary.Runner{
+			Searcher:  canarySearcher,
+			Judgments: judgments,
+			Interval:  *canaryInterval,
+		}
+		go runner.Run(context.Background())
+		serveMux.Handle("/debug/canary", runner.Handler())
+		debugPages = append(debugPages, debugserver.DebugPage{Href: "debug/canary", Text: "Canary queries"})
+	}
+	if profiles != nil {
+		serveMux.Handle("/debug/queryprofiles", auth.RequireAdmin(profiles.Handler()))
+		debugPages = append(debugPages, debugserver.DebugPage{Href: "debug/queryprofiles", Text: "Query profiles"})
+	}
+	debugserver.AddHandlers(serveMux, *enablePprof, debugPages...)
 
 	if *enableIndexserverProxy {
 		socket := filepath.Join(*index, "indexserver.sock")
@@ -266,7 +513,55 @@ func main() {
 		addProxyHandler(serveMux, socket)
 	}
 
-	handler := trace.Middleware(serveMux)
+	if contexts != nil {
+		h := http.StripPrefix("/api", contexts.Handler())
+		serveMux.Handle("/api/contexts", h)
+		serveMux.Handle("/api/contexts/", h)
+	}
+
+	// Standbys download every shard, so only admins may replicate.
+	if *replicationPrimary {
+		serveMux.Handle("/replication/", auth.RequireAdmin(http.StripPrefix("/replication", replication.Handler(*index))))
+	}
+
+	handler := audit.Middleware(priority.Middleware(trace.Middleware(serveMux)))
+	var grpcOpts []grpc.ServerOption
+	if *rateLimits != "" {
+		handler = ratelimit.Middleware(*rateLimitHeader, handler)
+		grpcOpts = append(grpcOpts,
+			grpc.ChainStreamInterceptor(ratelimit.StreamServerInterceptor(*rateLimitHeader)),
+			grpc.ChainUnaryInterceptor(ratelimit.UnaryServerInterceptor(*rateLimitHeader)),
+		)
+	}
+
+	authn, err := auth.New(auth.Options{
+		CertFile:     *sslCert,
+		KeyFile:      *sslKey,
+		ClientCAFile: *tlsClientCA,
+		TokensFile:   *authTokens,
+		Admins:       splitList(*authAdmins),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *authAdmins != "" && !authn.Required() {
+		log.Fatal("--auth_admins requires --auth_tokens or --tls_client_ca")
+	}
+	if *replicationPrimary && *authAdmins == "" {
+		log.Fatal("--replication_primary requires --auth_admins")
+	}
+	if *sslCert != "" || authn.Required() {
+		go reloadOnSignal(authn)
+	}
+	// Unauthenticated requests are rejected before they are rate limited or
+	// searched. newGRPCServer runs grpcOpts before its own interceptors.
+	if authn.Required() {
+		handler = authn.Middleware(handler)
+		grpcOpts = append([]grpc.ServerOption{
+			grpc.ChainStreamInterceptor(authn.StreamServerInterceptor),
+			grpc.ChainUnaryInterceptor(authn.UnaryServerInterceptor),
+		}, grpcOpts...)
+	}
 
 	// Sourcegraph: We use environment variables to configure watchdog since
 	// they are more convenient than flags in containerized environments.
@@ -282,37 +577,74 @@ func main() {
 		log.Printf("custom ZOEKT_WATCHDOG_ERRORS=%d", watchdogErrCount)
 	}
 
-	watchdogAddr := "http://" + *listen
+	// The watchdog dials the listener directly, so the host of the URL only
+	// matters for TLS.
+	watchdogHost := *listen
+	if network, _ := listener.Split(*listen); network == "unix" {
+		watchdogHost = "localhost"
+	}
+	watchdogAddr := "http://" + watchdogHost
 	if *sslCert != "" || *sslKey != "" {
-		watchdogAddr = "https://" + *listen
+		watchdogAddr = "https://" + watchdogHost
 	}
 	watchdogAddr += "/healthz"
 
 	if watchdogErrCount > 0 && watchdogTick > 0 {
-		go watchdog(watchdogTick, watchdogErrCount, watchdogAddr)
+		go watchdog(watchdogTick, watchdogErrCount, watchdogAddr, listener.Dial(*listen, *proxyProtocol))
 	} else {
 		log.Println("watchdog disabled")
 	}
 
 	logger := sglog.Scoped("ZoektWebserverGRPCServer")
 
+	// The standby answers with 503 or UNAVAILABLE until the primary is
+	// unavailable.
+	if *standbyOf != "" {
+		standby := &replication.Standby{
+			Primary:     strings.TrimSuffix(*standbyOf, "/") + "/replication",
+			Dir:         *index,
+			Interval:    *standbyInterval,
+			MaxFailures: *standbyMaxFailures,
+		}
+		if *standbyTokenFile != "" {
+			standby.Client = &http.Client{Transport: &auth.TokenTransport{File: *standbyTokenFile}}
+		}
+		go standby.Run(context.Background())
+		handler = standby.Middleware(handler)
+		grpcOpts = append(grpcOpts,
+			grpc.ChainStreamInterceptor(standby.StreamServerInterceptor),
+			grpc.ChainUnaryInterceptor(standby.UnaryServerInterceptor),
+		)
+	}
+
 	streamer := web.NewTraceAwareSearcher(s.Searcher)
-	grpcServer := newGRPCServer(logger, streamer)
+	grpcServer := newGRPCServer(logger, streamer, grpcOpts...)
 
 	handler = multiplexGRPC(grpcServer, handler)
 
 	srv := &http.Server{
-		Addr:    *listen,
-		Handler: handler,
+		Addr:      *listen,
+		Handler:   handler,
+		TLSConfig: authn.TLSConfig(),
+	}
+
+	l, err := listener.Listen(*listen)
+	if err != nil {
+		log.Fatalf("Listen: %v", err)
+	}
+	if *proxyProtocol {
+		l = listener.ProxyProtocol(l, 10*time.Second)
 	}
 
 	go func() {
-		sglog.Scoped("server").Info("starting server", sglog.Stringp("address", listen))
+		sglog.Scoped("server").Info("starting server", sglog.Stringp("address", listen), sglog.Bool("proxyProtocol", *proxyProtocol))
 		var err error
-		if *sslCert != "" || *sslKey != "" {
-			err = srv.ListenAndServeTLS(*sslCert, *sslKey)
+		if srv.TLSConfig != nil {
+			// The certificate comes from the TLS config, so it can be
+			// reloaded.
+			err = srv.ServeTLS(l, "", "")
 		} else {
-			err = srv.ListenAndServe()
+			err = srv.Serve(l)
 		}
 
 		if err != http.ErrServerClosed {
@@ -371,6 +703,30 @@ func addProxyHandler(mux *http.ServeMux, socket string) {
 	mux.Handle("/indexserver/", http.StripPrefix("/indexserver/", http.HandlerFunc(proxy.ServeHTTP)))
 }
 
+// reloadOnSignal reloads the certificates and tokens of a on SIGHUP.
+func reloadOnSignal(a *auth.Authenticator) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, PLATFORM_SIGHUP)
+	for range c {
+		if err := a.Reload(); err != nil {
+			log.Printf("keeping the previous credentials: %v", err)
+			continue
+		}
+		log.Printf("reloaded credentials")
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // shutdownSignalChan returns a channel which is listening for shutdown
 // signals from the operating system. maxReads is an upper bound on how many
 // times you will read the channel (used as buffer for signal.Notify).
@@ -449,8 +805,9 @@ func watchdogOnce(ctx context.Context, client *http.Client, addr string) error {
 	return nil
 }
 
-func watchdog(dt time.Duration, maxErrCount int, addr string) {
+func watchdog(dt time.Duration, maxErrCount int, addr string, dial func(context.Context, string, string) (net.Conn, error)) {
 	tr := &http.Transport{
+		DialContext:     dial,
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{
@@ -524,9 +881,186 @@ func mustRegisterDiskMonitor(path string) {
 	}))
 }
 
+// profiledSearcher captures a query profile for searches with
+// SearchOptions.Profile set, and attaches it to the search trace.
+type profiledSearcher struct {
+	zoekt.Streamer
+	Recorder *queryprofile.Recorder
+}
+
+func (s *profiledSearcher) profile(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (context.Context, func()) {
+	if !opts.Profile {
+		return ctx, func() {}
+	}
+	tr, ctx := trace.New(ctx, "profiledSearcher.Search", "")
+	ctx, stop := s.Recorder.Start(ctx, q.String())
+	return ctx, func() {
+		p := stop()
+		tr.LazyPrintf("profile: /debug/queryprofiles?id=%d cpu=%t duration=%s alloc_bytes=%d mallocs=%d", p.ID, p.CPU != nil, p.Duration, p.TotalAlloc, p.Mallocs)
+		tr.Finish()
+	}
+}
+
+func (s *profiledSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	ctx, done := s.profile(ctx, q, opts)
+	defer done()
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *profiledSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	ctx, done := s.profile(ctx, q, opts)
+	defer done()
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+// adminSearcher clears the search options which only admins may use, like
+// SearchOptions.Secrets and SearchOptions.Profile, unless the principal of
+// the search is an admin.
+type adminSearcher struct {
+	zoekt.Streamer
+}
+
+func (s *adminSearcher) opts(ctx context.Context, opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	if !(opts.Secrets || opts.Profile) || auth.IsAdmin(ctx) {
+		return opts
+	}
+	cleared := *opts
+	cleared.Secrets = false
+	cleared.Profile = false
+	return &cleared
+}
+
+func (s *adminSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return s.Streamer.Search(ctx, q, s.opts(ctx, opts))
+}
+
+func (s *adminSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return s.Streamer.StreamSearch(ctx, q, s.opts(ctx, opts), sender)
+}
+
+// budgetSearcher caps the budget of searches, so that clients can ask for a
+// smaller budget but not for a larger one.
+type budgetSearcher struct {
+	zoekt.Streamer
+	MaxContentBytesLoaded int64
+	MaxRegexpTime         time.Duration
+}
+
+func (s *budgetSearcher) opts(opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	capped := *opts
+	if s.MaxContentBytesLoaded > 0 && (capped.MaxContentBytesLoaded == 0 || capped.MaxContentBytesLoaded > s.MaxContentBytesLoaded) {
+		capped.MaxContentBytesLoaded = s.MaxContentBytesLoaded
+	}
+	if s.MaxRegexpTime > 0 && (capped.MaxRegexpTime == 0 || capped.MaxRegexpTime > s.MaxRegexpTime) {
+		capped.MaxRegexpTime = s.MaxRegexpTime
+	}
+	return &capped
+}
+
+func (s *budgetSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return s.Streamer.Search(ctx, q, s.opts(opts))
+}
+
+func (s *budgetSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return s.Streamer.StreamSearch(ctx, q, s.opts(opts), sender)
+}
+
+// contextSearcher expands the context:NAME atoms of queries with the search
+// contexts in Store.
+type contextSearcher struct {
+	zoekt.Streamer
+	Store *searchcontext.Store
+}
+
+func (s *contextSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, err := s.Store.Expand(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *contextSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, err := s.Store.Expand(ctx, q)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s *contextSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, err := s.Store.Expand(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+// trafficSearcher records which repositories searches find results in.
+type trafficSearcher struct {
+	zoekt.Streamer
+	Recorder *traffic.Recorder
+}
+
+func (s *trafficSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	sr, err := s.Streamer.Search(ctx, q, opts)
+	if sr != nil {
+		repos := map[string]struct{}{}
+		for _, fm := range sr.Files {
+			repos[fm.Repository] = struct{}{}
+		}
+		for _, dm := range sr.Directories {
+			repos[dm.Repository] = struct{}{}
+		}
+		s.record(repos)
+	}
+	return sr, err
+}
+
+func (s *trafficSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	var mu sync.Mutex
+	repos := map[string]struct{}{}
+	err := s.Streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(event *zoekt.SearchResult) {
+		mu.Lock()
+		for _, fm := range event.Files {
+			repos[fm.Repository] = struct{}{}
+		}
+		for _, dm := range event.Directories {
+			repos[dm.Repository] = struct{}{}
+		}
+		mu.Unlock()
+		sender.Send(event)
+	}))
+	s.record(repos)
+	return err
+}
+
+func (s *trafficSearcher) record(repos map[string]struct{}) {
+	names := make([]string, 0, len(repos))
+	for name := range repos {
+		names = append(names, name)
+	}
+	s.Recorder.Record(names...)
+}
+
+// writeTrafficStats periodically writes the statistics of rec to indexDir.
+func writeTrafficStats(rec *traffic.Recorder, indexDir string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := rec.Flush(time.Now()).Write(indexDir); err != nil {
+			log.Printf("writing traffic statistics: %v", err)
+		}
+	}
+}
+
 type loggedSearcher struct {
 	zoekt.Streamer
 	Logger sglog.Logger
+
+	// AccessLog, if non-nil, gets a record of every search.
+	AccessLog *accesslog.Logger
+
+	// Audit, if non-nil, gets an audit event of every search.
+	Audit *audit.Logger
 }
 
 func (s *loggedSearcher) Search(
@@ -534,12 +1068,27 @@ func (s *loggedSearcher) Search(
 	q query.Q,
 	opts *zoekt.SearchOptions,
 ) (sr *zoekt.SearchResult, err error) {
+	start := time.Now()
 	defer func() {
 		var stats *zoekt.Stats
 		if sr != nil {
 			stats = &sr.Stats
 		}
 		s.log(ctx, q, opts, stats, err)
+		s.logAccess(ctx, "Search", q, opts, start, stats, err)
+		if s.Audit != nil {
+			// Failed searches have no result, and neither repositories nor
+			// files.
+			repos := map[string]struct{}{}
+			files := 0
+			if sr != nil {
+				for _, f := range sr.Files {
+					repos[f.Repository] = struct{}{}
+				}
+				files = sr.Stats.FileCount
+			}
+			s.Audit.Log(audit.NewEvent(ctx, "Search", q, start, len(repos), files, err))
+		}
 	}()
 
 	metricSearchRequestsTotal.Inc()
@@ -553,18 +1102,44 @@ func (s *loggedSearcher) StreamSearch(
 	sender zoekt.Sender,
 ) error {
 	var stats zoekt.Stats
+	start := time.Now()
+
+	// Senders may be called concurrently.
+	var reposMu sync.Mutex
+	repos := map[string]struct{}{}
 
 	metricSearchRequestsTotal.Inc()
 	err := s.Streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(event *zoekt.SearchResult) {
 		stats.Add(event.Stats)
+		if s.Audit != nil {
+			reposMu.Lock()
+			for _, f := range event.Files {
+				repos[f.Repository] = struct{}{}
+			}
+			reposMu.Unlock()
+		}
 		sender.Send(event)
 	}))
 
 	s.log(ctx, q, opts, &stats, err)
+	s.logAccess(ctx, "StreamSearch", q, opts, start, &stats, err)
+	if s.Audit != nil {
+		s.Audit.Log(audit.NewEvent(ctx, "StreamSearch", q, start, len(repos), stats.FileCount, err))
+	}
 
 	return err
 }
 
+func (s *loggedSearcher) logAccess(ctx context.Context, method string, q query.Q, opts *zoekt.SearchOptions, start time.Time, st *zoekt.Stats, err error) {
+	if s.AccessLog == nil {
+		return
+	}
+	r := accesslog.NewRecord(ctx, method, q, opts, start, st, err)
+	if err := s.AccessLog.Log(r); err != nil {
+		s.Logger.Warn("writing access log failed", sglog.Error(err))
+	}
+}
+
 func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, st *zoekt.Stats, err error) {
 	logger := s.Logger.
 		WithTrace(traceContext(ctx)).
@@ -640,12 +1215,18 @@ func traceContext(ctx context.Context) sglog.TraceContext {
 	return sglog.TraceContext{}
 }
 
+// newGRPCServer returns the gRPC server for streamer. The interceptors of
+// additionalOpts run before the base interceptors, so they can reject
+// requests, eg. unauthenticated ones, before anything else sees them.
 func newGRPCServer(logger sglog.Logger, streamer zoekt.Streamer, additionalOpts ...grpc.ServerOption) *grpc.Server {
 	metrics := serverMetricsOnce()
 
-	opts := []grpc.ServerOption{
+	// Clip, so that appending doesn't write to the caller's slice.
+	opts := append(slices.Clip(additionalOpts),
 		grpc.ChainStreamInterceptor(
 			propagator.StreamServerPropagator(tenant.Propagator{}),
+			propagator.StreamServerPropagator(priority.Propagator{}),
+			propagator.StreamServerPropagator(audit.Propagator{}),
 			tenant.StreamServerInterceptor,
 			otelgrpc.StreamServerInterceptor(),
 			metrics.StreamServerInterceptor(),
@@ -654,15 +1235,15 @@ func newGRPCServer(logger sglog.Logger, streamer zoekt.Streamer, additionalOpts
 		),
 		grpc.ChainUnaryInterceptor(
 			propagator.UnaryServerPropagator(tenant.Propagator{}),
+			propagator.UnaryServerPropagator(priority.Propagator{}),
+			propagator.UnaryServerPropagator(audit.Propagator{}),
 			tenant.UnaryServerInterceptor,
 			otelgrpc.UnaryServerInterceptor(),
 			metrics.UnaryServerInterceptor(),
 			messagesize.UnaryServerInterceptor,
 			internalerrs.LoggingUnaryServerInterceptor(logger),
 		),
-	}
-
-	opts = append(opts, additionalOpts...)
+	)
 
 	// Ensure that the message size options are set last, so they override any other
 	// server-specific options that tweak the message size.
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sourcegraph/log/logtest"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/audit"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// failingStreamer fails every search with err.
+type failingStreamer struct {
+	zoekt.Streamer
+	err error
+}
+
+func (s *failingStreamer) Search(context.Context, query.Q, *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return nil, s.err
+}
+
+func (s *failingStreamer) StreamSearch(context.Context, query.Q, *zoekt.SearchOptions, zoekt.Sender) error {
+	return s.err
+}
+
+type memorySink struct {
+	events []audit.Event
+}
+
+func (s *memorySink) Write(_ context.Context, e *audit.Event) error {
+	s.events = append(s.events, *e)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestLoggedSearcherAuditsErrors(t *testing.T) {
+	sink := &memorySink{}
+	auditLogger := audit.New(audit.Options{Sinks: []audit.Sink{sink}})
+	s := &loggedSearcher{
+		Streamer: &failingStreamer{err: errors.New("boom")},
+		Logger:   logtest.Scoped(t),
+		Audit:    auditLogger,
+	}
+
+	q := &query.Substring{Pattern: "foo"}
+	if _, err := s.Search(context.Background(), q, &zoekt.SearchOptions{}); err == nil {
+		t.Fatal("Search: want error")
+	}
+	if err := s.StreamSearch(context.Background(), q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(*zoekt.SearchResult) {})); err == nil {
+		t.Fatal("StreamSearch: want error")
+	}
+	if err := auditLogger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(sink.events))
+	}
+	for _, e := range sink.events {
+		if e.Error != "boom" || e.RepoCount != 0 || e.FileCount != 0 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+}
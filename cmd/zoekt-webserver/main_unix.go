@@ -6,4 +6,7 @@ import (
 	platform "golang.org/x/sys/unix"
 )
 
-const PLATFORM_SIGTERM = platform.SIGTERM
+const (
+	PLATFORM_SIGTERM = platform.SIGTERM
+	PLATFORM_SIGHUP  = platform.SIGHUP
+)
@@ -4,4 +4,7 @@ import (
 	platform "golang.org/x/sys/windows"
 )
 
-const PLATFORM_SIGTERM = platform.SIGTERM
+const (
+	PLATFORM_SIGTERM = platform.SIGTERM
+	PLATFORM_SIGHUP  = platform.SIGHUP
+)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// indexDir indexes the files below dir in memory, without writing shards,
+// and returns a searcher for them. The files form a repository named after
+// dir. Like zoekt-index, it skips large and binary files and the directories
+// in ignoreDirs.
+func indexDir(dir string, ignoreDirs map[string]struct{}) (zoekt.Searcher, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := build.Options{
+		RepositoryDescription: zoekt.Repository{
+			Name:   filepath.Base(dir),
+			Source: dir,
+		},
+	}
+	b, err := build.NewInMemoryBuilder(opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetDefaults()
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if _, ok := ignoreDirs[d.Name()]; ok && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		// Don't read files which are skipped anyway.
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > int64(opts.SizeMax) {
+			return b.Add(zoekt.Document{
+				Name:       name,
+				SkipReason: fmt.Sprintf("document size %d larger than limit %d", info.Size(), opts.SizeMax),
+			})
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return b.AddFile(name, content)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
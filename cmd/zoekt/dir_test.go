@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestIndexDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"sub/needle.txt":  "a needle in a haystack\n",
+		"binary.bin":      "needle\x00\x01\x02",
+		".git/HEAD":       "needle\n",
+		"ignored/foo.txt": "needle\n",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	searcher, err := indexDir(dir, map[string]struct{}{".git": {}, "ignored": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	sr, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range sr.Files {
+		got = append(got, f.Repository+":"+f.FileName)
+	}
+	sort.Strings(got)
+	if want := []string{filepath.Base(dir) + ":sub/needle.txt"}; !cmp.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
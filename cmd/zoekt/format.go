@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/sarif"
+)
+
+// Output formats for -format. Everything but formatText includes chunk
+// matches, scores and repository metadata.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatSARIF = "sarif"
+)
+
+// jsonRepo is the metadata of a repository with results.
+type jsonRepo struct {
+	ID        uint32
+	Name      string
+	URL       string                   `json:",omitempty"`
+	Branches  []zoekt.RepositoryBranch `json:",omitempty"`
+	Metadata  map[string]string        `json:",omitempty"`
+	RawConfig map[string]string        `json:",omitempty"`
+}
+
+type jsonChunk struct {
+	Content      string
+	ContentStart zoekt.Location
+	Ranges       []zoekt.Range
+	FileName     bool    `json:",omitempty"`
+	Score        float64 `json:",omitempty"`
+	DebugScore   string  `json:",omitempty"`
+}
+
+type jsonFile struct {
+	Repository   string
+	RepositoryID uint32    `json:",omitempty"`
+	Repo         *jsonRepo `json:",omitempty"`
+	FileName     string
+	Branches     []string `json:",omitempty"`
+	Version      string   `json:",omitempty"`
+	Language     string   `json:",omitempty"`
+	Score        float64
+	Debug        string `json:",omitempty"`
+	Chunks       []jsonChunk
+}
+
+type jsonResult struct {
+	Query string
+	Stats zoekt.Stats
+	Files []jsonFile
+}
+
+// repoMetadata lists the repositories of files.
+func repoMetadata(ctx context.Context, searcher zoekt.Searcher, files []zoekt.FileMatch) (map[string]*zoekt.Repository, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, f := range files {
+		if !seen[f.Repository] {
+			seen[f.Repository] = true
+			names = append(names, f.Repository)
+		}
+	}
+	repos := map[string]*zoekt.Repository{}
+	if len(names) == 0 {
+		return repos, nil
+	}
+
+	rl, err := searcher.List(ctx, query.NewRepoSet(names...), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range rl.Repos {
+		repos[e.Repository.Name] = &e.Repository
+	}
+	return repos, nil
+}
+
+func toJSONFiles(files []zoekt.FileMatch, repos map[string]*zoekt.Repository) []jsonFile {
+	jsonRepos := map[string]*jsonRepo{}
+	for name, r := range repos {
+		jsonRepos[name] = &jsonRepo{
+			ID:        r.ID,
+			Name:      r.Name,
+			URL:       r.URL,
+			Branches:  r.Branches,
+			Metadata:  r.Metadata,
+			RawConfig: r.RawConfig,
+		}
+	}
+
+	out := make([]jsonFile, 0, len(files))
+	for _, f := range files {
+		jf := jsonFile{
+			Repository:   f.Repository,
+			RepositoryID: f.RepositoryID,
+			Repo:         jsonRepos[f.Repository],
+			FileName:     f.FileName,
+			Branches:     f.Branches,
+			Version:      f.Version,
+			Language:     f.Language,
+			Score:        f.Score,
+			Debug:        f.Debug,
+			Chunks:       []jsonChunk{},
+		}
+		for _, c := range f.ChunkMatches {
+			jf.Chunks = append(jf.Chunks, jsonChunk{
+				Content:      string(c.Content),
+				ContentStart: c.ContentStart,
+				Ranges:       c.Ranges,
+				FileName:     c.FileName,
+				Score:        c.Score,
+				DebugScore:   c.DebugScore,
+			})
+		}
+		out = append(out, jf)
+	}
+	return out
+}
+
+// writeResult writes sres to w in the given machine readable format.
+func writeResult(ctx context.Context, w io.Writer, format, pat string, searcher zoekt.Searcher, sres *zoekt.SearchResult) error {
+	repos, err := repoMetadata(ctx, searcher, sres.Files)
+	if err != nil {
+		return err
+	}
+
+	if format == formatSARIF {
+		var rs []*zoekt.Repository
+		for _, r := range repos {
+			rs = append(rs, r)
+		}
+		return sarif.Write(w, sres, sarif.Options{Query: pat, Repositories: rs})
+	}
+
+	files := toJSONFiles(sres.Files, repos)
+	enc := json.NewEncoder(w)
+	switch format {
+	case formatJSON:
+		enc.SetIndent("", "  ")
+		return enc.Encode(jsonResult{Query: pat, Stats: sres.Stats, Files: files})
+	case formatJSONL:
+		for _, f := range files {
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
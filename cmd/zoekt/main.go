@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"sort"
 	"strings"
 	"time"
 
@@ -51,6 +52,50 @@ func displayMatches(files []zoekt.FileMatch, pat string, withRepo bool, list boo
 	}
 }
 
+// displayChunks prints the chunk matches of files like grep -C: matching
+// lines as "name:line:text", context lines as "name-line-text" and "--"
+// between chunks.
+func displayChunks(files []zoekt.FileMatch, withRepo bool) {
+	first := true
+	for _, f := range files {
+		r := ""
+		if withRepo {
+			r = f.Repository + "/"
+		}
+
+		// Chunks are ordered by score, but read better in file order.
+		chunks := append([]zoekt.ChunkMatch{}, f.ChunkMatches...)
+		sort.Slice(chunks, func(i, j int) bool {
+			return chunks[i].ContentStart.LineNumber < chunks[j].ContentStart.LineNumber
+		})
+		for _, c := range chunks {
+			if c.FileName {
+				continue
+			}
+			if !first {
+				fmt.Println("--")
+			}
+			first = false
+
+			matching := map[uint32]bool{}
+			for _, rg := range c.Ranges {
+				for l := rg.Start.LineNumber; l <= rg.End.LineNumber; l++ {
+					matching[l] = true
+				}
+			}
+			lines := bytes.Split(bytes.TrimSuffix(c.Content, []byte{'\n'}), []byte{'\n'})
+			for i, l := range lines {
+				num := c.ContentStart.LineNumber + uint32(i)
+				sep := "-"
+				if matching[num] {
+					sep = ":"
+				}
+				fmt.Printf("%s%s%s%d%s%s\n", r, f.FileName, sep, num, sep, l)
+			}
+		}
+	}
+}
+
 func addTabIfNonEmpty(s string) string {
 	if s != "" {
 		return "\t" + s
@@ -153,6 +198,8 @@ func toSymbolQuery(q query.Q) query.Q {
 
 func main() {
 	shard := flag.String("shard", "", "search in a specific shard")
+	dir := flag.String("dir", "", "index the files below `directory` in memory and search them, instead of searching index files")
+	ignoreDirs := flag.String("ignore_dirs", ".git,.hg,.svn", "comma separated list of directories to ignore with -dir.")
 	index := flag.String("index_dir",
 		filepath.Join(os.Getenv("HOME"), ".zoekt"), "search for index files in `directory`")
 	cpuProfile := flag.String("cpu_profile", "", "write cpu profile to `file`")
@@ -163,11 +210,14 @@ func main() {
 	withRepo := flag.Bool("r", false, "print the repo before the file name")
 	list := flag.Bool("l", false, "print matching filenames only")
 	sym := flag.Bool("sym", false, "do experimental symbol search")
+	syntax := flag.String("syntax", query.SyntaxZoekt, "query syntax, zoekt or github")
+	numContextLines := flag.Int("context", 0, "print `N` lines of context around matches. Overlapping context merges, like with grep -C.")
+	format := flag.String("format", formatText, "output format: text, json, jsonl (one file per line) or sarif. -r and -l only apply to text.")
 
 	flag.Usage = func() {
 		name := os.Args[0]
 		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] QUERY\n"+
-			"for example\n\n  %s byte file:java -file:test\n\n", name, name)
+			"for example\n\n  %s byte file:java -file:test\n  %s -dir . 'func main'\n\n", name, name, name)
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n")
 	}
@@ -180,13 +230,35 @@ func main() {
 	}
 	pat := strings.Join(flag.Args(), " ")
 
+	if *numContextLines < 0 {
+		fmt.Fprintf(os.Stderr, "Context lines must not be negative.\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch *format {
+	case formatText, formatJSON, formatJSONL, formatSARIF:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q.\n", *format)
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	if !*verbose {
 		log.SetOutput(io.Discard)
 	}
 
 	var searcher zoekt.Searcher
 	var err error
-	if *shard != "" {
+	if *dir != "" {
+		ignore := map[string]struct{}{}
+		for _, d := range strings.Split(*ignoreDirs, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				ignore[d] = struct{}{}
+			}
+		}
+		searcher, err = indexDir(*dir, ignore)
+	} else if *shard != "" {
 		searcher, err = loadShard(*shard, *verbose)
 	} else {
 		searcher, err = shards.NewDirectorySearcher(*index)
@@ -196,7 +268,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	q, err := query.Parse(pat)
+	q, err := query.ParseSyntax(*syntax, pat)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -211,6 +283,10 @@ func main() {
 
 	sOpts := zoekt.SearchOptions{
 		DebugScore: *debug,
+		// Machine readable formats report chunks instead of lines. So does
+		// text with context, which merges overlapping context.
+		ChunkMatches:    *format != formatText || *numContextLines > 0,
+		NumContextLines: *numContextLines,
 	}
 	sres, err := searcher.Search(context.Background(), q, &sOpts)
 	if err != nil {
@@ -226,7 +302,13 @@ func main() {
 		sres, _ = searcher.Search(context.Background(), q, &sOpts)
 	}
 
-	displayMatches(sres.Files, pat, *withRepo, *list)
+	if *format == formatText && sOpts.ChunkMatches && !*list {
+		displayChunks(sres.Files, *withRepo)
+	} else if *format == formatText {
+		displayMatches(sres.Files, pat, *withRepo, *list)
+	} else if err := writeResult(context.Background(), os.Stdout, *format, pat, searcher, sres); err != nil {
+		log.Fatal(err)
+	}
 	if *verbose {
 		log.Printf("stats: %#v", sres.Stats)
 	}
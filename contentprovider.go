@@ -300,6 +300,7 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 		score, debugScore, symbolInfo := p.candidateMatchScore(lineCands, language, debug)
 		finalMatch.Score = score
 		finalMatch.DebugScore = debugScore
+		finalMatch.SymbolRole = p.symbolRole(lineCands)
 
 		for i, m := range lineCands {
 			fragment := LineFragmentMatch{
@@ -376,6 +377,7 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 			SymbolInfo: symbolInfo,
 			Score:      score,
 			DebugScore: debugScore,
+			SymbolRole: p.symbolRole(chunk.candidates),
 		})
 	}
 	return chunkMatches
@@ -532,6 +534,13 @@ const (
 	// equal weight with the query-dependent signals.
 	scoreFileRankFactor = 9000.0
 
+	// Subtracted from the score of files which look generated, so they rank
+	// below handwritten files with similar matches.
+	scoreGeneratedPenalty = 2000.0
+
+	// Multiplies the BM25 score of files which look generated.
+	bm25GeneratedFactor = 0.5
+
 	// Used for ordering line and chunk matches within a file.
 	scoreLineOrderFactor = 1.0
 
@@ -604,6 +613,16 @@ func (p *contentProvider) matchesSymbol(cm *candidateMatch) bool {
 	return ok
 }
 
+// symbolRole returns SymbolRoleDefinition if one of ms matches a symbol.
+func (p *contentProvider) symbolRole(ms []*candidateMatch) SymbolRole {
+	for _, m := range ms {
+		if p.matchesSymbol(m) {
+			return SymbolRoleDefinition
+		}
+	}
+	return SymbolRoleNone
+}
+
 func (p *contentProvider) findSymbol(cm *candidateMatch) (DocumentSection, *Symbol, bool) {
 	if cm.fileName {
 		return DocumentSection{}, nil, false
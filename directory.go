@@ -0,0 +1,51 @@
+package zoekt
+
+import (
+	"path"
+	"sort"
+)
+
+// groupByDirectory aggregates file matches into the directories containing
+// them. The result is sorted by repository and directory.
+func groupByDirectory(files []FileMatch) []DirectoryMatch {
+	dirs := make([]DirectoryMatch, 0, len(files))
+	for i := range files {
+		f := &files[i]
+		matchCount := len(f.LineMatches)
+		for _, cm := range f.ChunkMatches {
+			matchCount += len(cm.Ranges)
+		}
+		dirs = append(dirs, DirectoryMatch{
+			Repository:   f.Repository,
+			RepositoryID: f.RepositoryID,
+			Directory:    path.Dir(f.FileName),
+			FileCount:    1,
+			MatchCount:   matchCount,
+		})
+	}
+	return MergeDirectories(dirs)
+}
+
+// MergeDirectories sorts dirs by repository and directory, and sums up the
+// counts of the entries for the same directory. Use it to combine the
+// Directories of several SearchResults, like the ones of a stream. It
+// modifies dirs.
+func MergeDirectories(dirs []DirectoryMatch) []DirectoryMatch {
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Repository != dirs[j].Repository {
+			return dirs[i].Repository < dirs[j].Repository
+		}
+		return dirs[i].Directory < dirs[j].Directory
+	})
+
+	out := dirs[:0]
+	for _, d := range dirs {
+		if n := len(out); n > 0 && out[n-1].Repository == d.Repository && out[n-1].Directory == d.Directory {
+			out[n-1].FileCount += d.FileCount
+			out[n-1].MatchCount += d.MatchCount
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
@@ -16,6 +16,7 @@ package zoekt
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"regexp/syntax"
@@ -28,62 +29,45 @@ import (
 
 	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/trace"
 )
 
-// simplifyMultiRepo takes a query and a predicate. It returns Const(true) if all
-// repository names fulfill the predicate, Const(false) if none of them do, and q
-// otherwise.
-func (d *indexData) simplifyMultiRepo(q query.Q, predicate func(*Repository) bool) query.Q {
-	count := 0
-	alive := len(d.repoMetaData)
-	for i := range d.repoMetaData {
-		if d.repoMetaData[i].Tombstone {
-			alive--
-		} else if predicate(&d.repoMetaData[i]) {
-			count++
-		}
-	}
-	if count == alive {
-		return &query.Const{Value: true}
-	}
-	if count > 0 {
-		return q
-	}
-	return &query.Const{Value: false}
-}
-
+// simplify evaluates the atoms of in which are decided by the shard as a
+// whole, like repository filters, before we build a matchTree. The result is
+// Const(false) if the shard can't match.
 func (d *indexData) simplify(in query.Q) query.Q {
 	eval := query.Map(in, func(q query.Q) query.Q {
-		switch r := q.(type) {
-		case *query.Repo:
-			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
-				return r.Regexp.MatchString(repo.Name)
-			})
-		case *query.RepoRegexp:
-			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
-				return r.Regexp.MatchString(repo.Name)
-			})
-		case *query.BranchesRepos:
-			for i := range d.repoMetaData {
-				for _, br := range r.List {
-					if br.Repos.Contains(d.repoMetaData[i].ID) {
-						return q
-					}
+		if filter := newRepoFilter(q); filter != nil {
+			return evalRepoFilter(q, filter, func(visit func(*Repository)) {
+				for i := range d.repoMetaData {
+					visit(&d.repoMetaData[i])
 				}
-			}
-			return &query.Const{Value: false}
-		case *query.RepoSet:
-			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
-				return r.Set[repo.Name]
-			})
-		case query.RawConfig:
-			return d.simplifyMultiRepo(q, func(repo *Repository) bool { return uint8(r)&encodeRawConfig(repo.RawConfig) == uint8(r) })
-		case *query.RepoIDs:
-			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
-				return r.Repos.Contains(repo.ID)
 			})
+		}
+
+		switch r := q.(type) {
+		case *query.Dependency:
+			if len(d.dependenciesIndex) == 0 {
+				return &query.Const{Value: false}
+			}
+		case *query.FileMetric:
+			if d.fileMetrics.sz == 0 {
+				return &query.Const{Value: false}
+			}
+		case *query.Generated:
+			if len(d.generated) == 0 {
+				return &query.Const{Value: false}
+			}
+		case *query.HasSecret:
+			if len(d.secretsIndex) == 0 {
+				return &query.Const{Value: false}
+			}
 		case *query.Language:
 			_, has := d.metaData.LanguageMap[r.Language]
+			if has && len(d.metaData.LanguageMap) == 1 {
+				// All documents are in the language.
+				return &query.Const{Value: true}
+			}
 			if !has && d.metaData.IndexFeatureVersion < 12 {
 				// For index files that haven't been re-indexed by go-enry,
 				// fall back to file-based matching and continue even if this
@@ -134,6 +118,16 @@ func (o *SearchOptions) SetDefaults() {
 	}
 }
 
+// ctxFlushReason returns the FlushReason of a search which stopped because
+// ctx is done. Searches which were canceled have none, since nobody waits for
+// their results.
+func ctxFlushReason(ctx context.Context) FlushReason {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return FlushReasonTimeout
+	}
+	return 0
+}
+
 func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions) (sr *SearchResult, err error) {
 	timer := newTimer()
 
@@ -141,6 +135,15 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	opts = &copyOpts
 	opts.SetDefaults()
 
+	// type:dir searches for files as usual, and then aggregates them into
+	// their directories.
+	groupByDir := false
+	if t, ok := q.(*query.Type); ok && t.Type == query.TypeDirectory {
+		q = t.Child
+		groupByDir = true
+		opts.Whole = false
+	}
+
 	var res SearchResult
 	if len(d.fileNameIndex) == 0 {
 		return &res, nil
@@ -149,10 +152,18 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	select {
 	case <-ctx.Done():
 		res.Stats.ShardsSkipped++
+		res.Stats.FlushReason = ctxFlushReason(ctx)
 		return &res, nil
 	default:
 	}
 
+	// The shards searcher replaces type:repo sub-queries by the repositories
+	// they list, but searchers of a single shard evaluate them themselves.
+	q, err = d.evalTypeRepo(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
 	q = d.simplify(q)
 	if c, ok := q.(*query.Const); ok && !c.Value {
 		return &res, nil
@@ -165,6 +176,19 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 
 	q = query.Map(q, query.ExpandFileContent)
 
+	span, ctx := trace.StartSpanFromContext(ctx, "zoekt.indexData.Search")
+	defer func() {
+		if sr != nil {
+			span.SetTag("matchtree.construction", sr.Stats.MatchTreeConstruction.String())
+			span.SetTag("matchtree.search", sr.Stats.MatchTreeSearch.String())
+			span.SetTag("ngram.matches", sr.Stats.NgramMatches)
+			span.SetTag("files.considered", sr.Stats.FilesConsidered)
+			span.SetTag("files.loaded", sr.Stats.FilesLoaded)
+			span.SetTag("match.count", sr.Stats.MatchCount)
+		}
+		span.Finish()
+	}()
+
 	mt, err := d.newMatchTree(q, matchTreeOpt{})
 	if err != nil {
 		return nil, err
@@ -177,6 +201,7 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	if err != nil {
 		return nil, err
 	}
+	d.planMatchTree(mt)
 	res.Stats.MatchTreeConstruction = timer.Elapsed()
 	if mt == nil {
 		res.Stats.ShardsSkippedFilter++
@@ -215,10 +240,9 @@ nextFileMatch:
 		default:
 		}
 
-		nextDoc := mt.nextDoc()
-		if int(nextDoc) <= lastDoc {
-			nextDoc = uint32(lastDoc + 1)
-		}
+		// Seeking lets conjunctions skip the documents where one of their
+		// children can't match, see docSeeker.
+		nextDoc := seekDoc(mt, uint32(lastDoc+1))
 
 		for ; nextDoc < docCount; nextDoc++ {
 			repoID := d.repos[nextDoc]
@@ -265,7 +289,20 @@ nextFileMatch:
 			repoMatchCount = 0
 		}
 
-		if canceled || (res.Stats.MatchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0) {
+		if canceled {
+			res.Stats.FlushReason = ctxFlushReason(ctx)
+			res.Stats.FilesSkipped += int(docCount - nextDoc)
+			break
+		}
+
+		if res.Stats.MatchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0 {
+			res.Stats.FlushReason = FlushReasonMatchLimit
+			res.Stats.FilesSkipped += int(docCount - nextDoc)
+			break
+		}
+
+		if opts.BudgetExceeded(&res.Stats) {
+			res.Stats.FlushReason = FlushReasonBudgetExceeded
 			res.Stats.FilesSkipped += int(docCount - nextDoc)
 			break
 		}
@@ -302,6 +339,22 @@ nextFileMatch:
 			Language:           d.languageMap[d.getLanguage(nextDoc)],
 		}
 
+		if opts.Secrets && d.hasSecrets(nextDoc) {
+			fs, err := d.readSecrets(nextDoc)
+			if err != nil {
+				log.Printf("error reading secrets for document %d on shard %s: %v", nextDoc, d.file.Name(), err)
+			}
+			for _, f := range fs {
+				fileMatch.Secrets = append(fileMatch.Secrets, SecretAnnotation{RuleID: f.RuleID, LineNumber: f.Line})
+			}
+		}
+
+		enc, err := d.readEncoding(nextDoc)
+		if err != nil {
+			log.Printf("error reading encoding for document %d on shard %s: %v", nextDoc, d.file.Name(), err)
+		}
+		fileMatch.Encoding = enc
+
 		if s := d.subRepos[nextDoc]; s > 0 {
 			if s >= uint32(len(d.subRepoPaths[d.repos[nextDoc]])) {
 				log.Panicf("corrupt index: subrepo %d beyond %v", s, d.subRepoPaths)
@@ -331,6 +384,16 @@ nextFileMatch:
 		} else {
 			fileMatch.LineMatches = cp.fillMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts.DebugScore)
 		}
+		for _, m := range fileMatch.LineMatches {
+			if m.SymbolRole == SymbolRoleDefinition {
+				fileMatch.SymbolRole = SymbolRoleDefinition
+			}
+		}
+		for _, m := range fileMatch.ChunkMatches {
+			if m.SymbolRole == SymbolRoleDefinition {
+				fileMatch.SymbolRole = SymbolRoleDefinition
+			}
+		}
 
 		var tf map[string]int
 		if opts.UseBM25Scoring {
@@ -382,6 +445,11 @@ nextFileMatch:
 		d.scoreFilesUsingBM25(res.Files, tfs, df, opts)
 	}
 
+	if groupByDir {
+		res.Directories = groupByDirectory(res.Files)
+		res.Files = nil
+	}
+
 	for _, md := range d.repoMetaData {
 		r := md
 		addRepo(&res, &r)
@@ -420,17 +488,8 @@ func addRepo(res *SearchResult, repo *Repository) {
 func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchTree]bool, merge bool) []*candidateMatch {
 	var cands []*candidateMatch
 	visitMatches(mt, known, 1, func(mt matchTree, scoreWeight float64) {
-		if smt, ok := mt.(*substrMatchTree); ok {
-			cands = append(cands, setScoreWeight(scoreWeight, smt.current)...)
-		}
-		if rmt, ok := mt.(*regexpMatchTree); ok {
-			cands = append(cands, setScoreWeight(scoreWeight, rmt.found)...)
-		}
-		if rmt, ok := mt.(*wordMatchTree); ok {
-			cands = append(cands, setScoreWeight(scoreWeight, rmt.found)...)
-		}
-		if smt, ok := mt.(*symbolRegexpMatchTree); ok {
-			cands = append(cands, setScoreWeight(scoreWeight, smt.found)...)
+		if found := atomMatches(mt); found != nil {
+			cands = append(cands, setScoreWeight(scoreWeight, *found)...)
 		}
 	})
 
@@ -575,6 +634,35 @@ func (d *indexData) gatherBranches(docID uint32, mt matchTree, known map[matchTr
 	return branches
 }
 
+// evalTypeRepo replaces the type:repo sub-queries of q by the set of
+// repositories of this shard they list.
+func (d *indexData) evalTypeRepo(ctx context.Context, q query.Q) (query.Q, error) {
+	var err error
+	q = query.Map(q, func(q query.Q) query.Q {
+		if err != nil {
+			return nil
+		}
+
+		rq, ok := q.(*query.Type)
+		if !ok || rq.Type != query.TypeRepo {
+			return q
+		}
+
+		var rl *RepoList
+		rl, err = d.List(ctx, rq.Child, nil)
+		if err != nil {
+			return nil
+		}
+
+		rs := &query.RepoSet{Set: make(map[string]bool, len(rl.Repos))}
+		for _, r := range rl.Repos {
+			rs.Set[r.Repository.Name] = true
+		}
+		return rs
+	})
+	return q, err
+}
+
 func (d *indexData) List(ctx context.Context, q query.Q, opts *ListOptions) (rl *RepoList, err error) {
 	var include func(rle *RepoListEntry) bool
 
@@ -668,12 +756,10 @@ func (d *indexData) List(ctx context.Context, q query.Q, opts *ListOptions) (rl
 // or a andMatchTree (singleLine = false).
 func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int, fileName bool, caseSensitive bool) (mt matchTree, isEqual bool, singleLine bool, err error) {
 	// TODO - we could perhaps transform Begin/EndText in '\n'?
-	// TODO - we could perhaps transform CharClass in (OrQuery )
-	// if there are just a few runes, and part of a OpConcat?
 	switch r.Op {
 	case syntax.OpLiteral:
 		s := string(r.Rune)
-		if len(s) >= minTextSize {
+		if len(r.Rune) >= minTextSize || d.cjkBigrams(s) != nil {
 			ignoreCase := syntax.FoldCase == (r.Flags & syntax.FoldCase)
 			mt, err := d.newSubstringMatchTree(&query.Substring{Pattern: s, FileName: fileName, CaseSensitive: !ignoreCase && caseSensitive})
 			return mt, true, !strings.Contains(s, "\n"), err
@@ -685,6 +771,18 @@ func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int
 		return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
 
 	case syntax.OpRepeat:
+		if r.Min > 1 {
+			// Every match contains Min repetitions, which may be long enough
+			// to look up even if a single one isn't.
+			atLeast := *r
+			atLeast.Max = r.Min
+			if set, ok := literalSetOf(&atLeast); ok {
+				mt, singleLine, err := d.literalSetMatchTree(set, minTextSize, fileName, caseSensitive)
+				if mt != nil || err != nil {
+					return mt, false, singleLine, err
+				}
+			}
+		}
 		if r.Min == 1 {
 			return d.regexpToMatchTreeRecursive(r.Sub[0], minTextSize, fileName, caseSensitive)
 		} else if r.Min > 1 {
@@ -696,7 +794,23 @@ func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int
 		var qs []matchTree
 		isEq := true
 		singleLine = true
-		for _, sr := range r.Sub {
+		for subs := r.Sub; len(subs) > 0; {
+			if r.Op == syntax.OpConcat {
+				n, sq, subSingleLine, err := d.literalRunMatchTree(subs, minTextSize, fileName, caseSensitive)
+				if err != nil {
+					return nil, false, false, err
+				}
+				if n > 0 {
+					isEq = false
+					singleLine = singleLine && subSingleLine
+					qs = append(qs, sq)
+					subs = subs[n:]
+					continue
+				}
+			}
+
+			sr := subs[0]
+			subs = subs[1:]
 			if sq, subIsEq, subSingleLine, err := d.regexpToMatchTreeRecursive(sr, minTextSize, fileName, caseSensitive); sq != nil {
 				if err != nil {
 					return nil, false, false, err
@@ -726,7 +840,7 @@ func (d *indexData) regexpToMatchTreeRecursive(r *syntax.Regexp, minTextSize int
 			if singleLine {
 				return &andLineMatchTree{andMatchTree{children: newQs}}, isEq, singleLine, nil
 			}
-			return &andMatchTree{newQs}, isEq, singleLine, nil
+			return &andMatchTree{children: newQs}, isEq, singleLine, nil
 		}
 		for _, q := range qs {
 			if _, ok := q.(*bruteForceMatchTree); ok {
@@ -88,17 +88,16 @@ func TestRegexpParse(t *testing.T) {
 	cases := []testcase{
 		{"(foo|)bar", substrMT("bar"), false, false},
 		{"(foo|)", &bruteForceMatchTree{}, false, false},
-		{"(foo|bar)baz.*bla", &andMatchTree{[]matchTree{
+		{"(foo|bar)baz.*bla", &andLineMatchTree{andMatchTree{children: []matchTree{
 			&orMatchTree{[]matchTree{
-				substrMT("foo"),
-				substrMT("bar"),
+				substrMT("barbaz"),
+				substrMT("foobaz"),
 			}},
-			substrMT("baz"),
 			substrMT("bla"),
-		}}, false, false},
+		}}}, false, false},
 		{
 			"^[a-z](People)+barrabas$",
-			&andMatchTree{[]matchTree{
+			&andMatchTree{children: []matchTree{
 				substrMT("People"),
 				substrMT("barrabas"),
 			}}, false, false,
@@ -108,21 +107,34 @@ func TestRegexpParse(t *testing.T) {
 		{"(?i)foo", substrMT("FOO"), true, false},
 		{"(?i)foo", substrMT("FOO"), true, true},
 		{"^foo", substrMT("foo"), false, false},
-		{"(foo) (bar)", &andMatchTree{[]matchTree{substrMT("foo"), substrMT("bar")}}, false, false},
+		{"(foo) (bar)", substrMT("foo bar"), false, false},
 		{"(thread|needle|haystack)", &orMatchTree{[]matchTree{
 			substrMT("thread"),
 			substrMT("needle"),
 			substrMT("haystack"),
 		}}, true, false},
-		{"(foo)(?-s:.)*?(bar)", &andLineMatchTree{andMatchTree{[]matchTree{
+		{"(foo)(?-s:.)*?(bar)", &andLineMatchTree{andMatchTree{children: []matchTree{
 			substrMT("foo"),
 			substrMT("bar"),
 		}}}, false, false},
-		{"(foo)(?-s:.)*?[[:space:]](?-s:.)*?(bar)", &andMatchTree{[]matchTree{
+		{"(foo)(?-s:.)*?[[:space:]](?-s:.)*?(bar)", &andMatchTree{children: []matchTree{
 			substrMT("foo"),
 			substrMT("bar"),
 		}}, false, false},
-		{"(foo){2,}", substrMT("foo"), false, false},
+		{"(foo){2,}", substrMT("foofoo"), false, false},
+		{"(ab){2,}", substrMT("abab"), false, false},
+		{"(foo|food)bar{2,3}", &orMatchTree{[]matchTree{
+			substrMT("foobarr"),
+			substrMT("foodbarr"),
+		}}, false, false},
+		{"x[ab]y[cd]", &orMatchTree{[]matchTree{
+			substrMT("xayc"),
+			substrMT("xayd"),
+			substrMT("xbyc"),
+			substrMT("xbyd"),
+		}}, false, false},
+		{"(get|set|has|is|can)Name", substrMT("Name"), false, false},
+		{"(ab|abc)", &bruteForceMatchTree{}, false, false},
 		{"(...)(...)", &bruteForceMatchTree{}, false, false},
 	}
 
@@ -182,7 +194,7 @@ func TestSearch_ShardRepoMaxMatchCountOpt(t *testing.T) {
 			ShardsScanned:      1,
 			MatchCount:         2,
 		}
-		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Stats{}, "MatchTreeConstruction", "MatchTreeSearch")); diff != "" {
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Stats{}, "MatchTreeConstruction", "MatchTreeSearch", "RegexpTime")); diff != "" {
 			t.Errorf("mismatch (-want, +got): %s", diff)
 		}
 	})
@@ -367,6 +379,58 @@ func TestSimplifyBranchesRepos(t *testing.T) {
 	}
 }
 
+func TestSimplifyNestedRepoFilters(t *testing.T) {
+	d := compoundReposShard(t, "foo", "bar")
+	repo := func(pat string) query.Q { return &query.Repo{Regexp: regexp.MustCompile(pat)} }
+	foo := &query.Substring{Pattern: "foo"}
+	bar := &query.Substring{Pattern: "bar"}
+
+	cases := []struct {
+		name string
+		q    query.Q
+		want query.Q
+	}{{
+		name: "or",
+		q:    query.NewOr(query.NewAnd(repo("banana"), foo), query.NewAnd(repo("^foo$"), bar)),
+		want: query.NewAnd(repo("^foo$"), bar),
+	}, {
+		name: "ruled out",
+		q:    query.NewOr(query.NewAnd(repo("banana"), foo), query.NewAnd(query.NewRepoSet("kiwi"), bar)),
+		want: &query.Const{Value: false},
+	}, {
+		name: "not",
+		q:    query.NewAnd(foo, &query.Not{Child: repo("banana")}),
+		want: foo,
+	}, {
+		// The repositories have no branches.
+		name: "branch",
+		q:    query.NewAnd(foo, query.NewOr(&query.Branch{Pattern: "main"}, repo("banana"))),
+		want: &query.Const{Value: false},
+	}}
+
+	for _, tc := range cases {
+		got := d.simplify(tc.q)
+		if d := cmp.Diff(tc.want.String(), got.String()); d != "" {
+			t.Errorf("%s: -want, +got:\n%s", tc.name, d)
+		}
+	}
+}
+
+func TestSimplifyLanguage(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1.go", Language: "Go", Content: []byte("package foo")},
+		Document{Name: "f2.go", Language: "Go", Content: []byte("package bar")},
+	)
+	d := searcherForTest(t, b).(*indexData)
+
+	if got := d.simplify(&query.Language{Language: "Go"}); got.String() != "TRUE" {
+		t.Errorf("got %s, want TRUE for a shard of Go files", got)
+	}
+	if got := d.simplify(&query.Language{Language: "Java"}); got.String() != "FALSE" {
+		t.Errorf("got %s, want FALSE for a shard of Go files", got)
+	}
+}
+
 func hash(name string) uint32 {
 	h := fnv.New32()
 	h.Write([]byte(name))
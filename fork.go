@@ -0,0 +1,100 @@
+package zoekt
+
+import (
+	"fmt"
+	"maps"
+)
+
+// forkSearcher searches the files a fork inherits from its parent, in a shard
+// of the parent. It shares the index data of the shard, which it doesn't own.
+type forkSearcher struct {
+	*indexData
+	fork string
+}
+
+// Close is a no-op: the shard of the parent is closed by its owner.
+func (s *forkSearcher) Close() {}
+
+func (s *forkSearcher) String() string {
+	return fmt.Sprintf("fork(%s, %s)", s.fork, s.indexData.String())
+}
+
+// NewForkSearcher returns a Searcher for the files which fork, a repository
+// indexed with ForkOf set, inherits from its parent. It searches the files of
+// fork.ForkOf in s, except for fork.ForkTombstones, and reports them as files
+// of fork. The other repositories in s are not searched, and List returns no
+// repositories, since fork is listed by its own shards.
+//
+// s must have been returned by NewSearcher, and must stay open while the fork
+// searcher is used. NewForkSearcher returns false if s doesn't contain the
+// parent of fork, see IsForkParent, or if fork and its parent have different
+// branches, since the inherited files carry the branches of the parent.
+//
+// Forks are indexed against the current index of their parent, so they must be
+// reindexed whenever their parent is to stay accurate.
+func NewForkSearcher(s Searcher, fork *Repository) (Searcher, bool) {
+	d, ok := s.(*indexData)
+	if !ok || fork.ForkOf == "" {
+		return nil, false
+	}
+
+	parent := -1
+	for i := range d.repoMetaData {
+		if md := &d.repoMetaData[i]; IsForkParent(md, fork) && !md.Tombstone {
+			parent = i
+			break
+		}
+	}
+	if parent < 0 || !sameBranchNames(fork.Branches, d.repoMetaData[parent].Branches) {
+		return nil, false
+	}
+
+	md := *fork
+	md.FileTombstones = maps.Clone(d.repoMetaData[parent].FileTombstones)
+	if md.FileTombstones == nil {
+		md.FileTombstones = make(map[string]struct{}, len(fork.ForkTombstones))
+	}
+	maps.Copy(md.FileTombstones, fork.ForkTombstones)
+
+	v := *d
+	v.repoMetaData = make([]Repository, len(d.repoMetaData))
+	v.rawConfigMasks = make([]uint8, len(d.rawConfigMasks))
+	copy(v.rawConfigMasks, d.rawConfigMasks)
+	for i := range d.repoMetaData {
+		v.repoMetaData[i] = d.repoMetaData[i]
+		v.repoMetaData[i].Tombstone = true
+	}
+	v.repoMetaData[parent] = md
+	if parent < len(v.rawConfigMasks) {
+		v.rawConfigMasks[parent] = encodeRawConfig(md.RawConfig)
+	}
+	v.repoListEntry = nil
+
+	return &forkSearcher{indexData: &v, fork: fork.Name}, true
+}
+
+// IsForkParent returns true if parent is the repository fork was indexed as a
+// fork of. They must belong to the same tenant, so a fork never sees the files
+// of another tenant. The parent is the repository with ID fork.ForkOfID, or
+// with name fork.ForkOf if the repositories don't have IDs.
+func IsForkParent(parent, fork *Repository) bool {
+	if fork.ForkOf == "" || parent.TenantID != fork.TenantID {
+		return false
+	}
+	if fork.ForkOfID != 0 {
+		return parent.ID == fork.ForkOfID
+	}
+	return parent.Name == fork.ForkOf
+}
+
+func sameBranchNames(a, b []RepositoryBranch) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package zoekt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"slices"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var (
+	// ErrInvalidFileRequest is returned by GetFile for requests without
+	// repository or path, and for byte ranges outside of the file.
+	ErrInvalidFileRequest = errors.New("invalid file request")
+
+	// ErrFileNotFound is returned by GetFile if no shard holds the file.
+	ErrFileNotFound = errors.New("file not found")
+
+	// ErrFileNotIndexed is returned by GetFile for files whose content was
+	// skipped at index time, eg. because they are too large or binary.
+	ErrFileNotIndexed = errors.New("file content not indexed")
+
+	// ErrChecksumMismatch is returned by GetFile if the content read from a
+	// shard doesn't match the checksum computed at index time.
+	ErrChecksumMismatch = errors.New("file content does not match its checksum")
+)
+
+// FileRequest identifies a file, and optionally a byte range of it, for
+// GetFile.
+type FileRequest struct {
+	Repository string
+
+	// Branch is the branch to read the file from. If it is empty and the file
+	// was indexed with different content on several branches, the content on
+	// HEAD is returned.
+	Branch string
+
+	// Path is the repository-relative path of the file.
+	Path string
+
+	// Start and End are the byte range of the content to return. End is
+	// exclusive, and 0 means the end of the file.
+	Start, End uint32
+}
+
+// File is the indexed content of a file, as returned by GetFile.
+type File struct {
+	Repository string
+	Path       string
+
+	// Branches are the branches the file has this content on.
+	Branches []string
+	Version  string
+	Language string
+
+	// Encoding is the encoding the content was transcoded to UTF-8 from at
+	// index time.
+	Encoding string
+
+	// Checksum is the CRC-64 (ISO) checksum of the whole content.
+	Checksum []byte
+
+	// Size is the size of the whole content in bytes.
+	Size uint32
+
+	// Start and End are the byte range of the file Content holds.
+	Start, End uint32
+	Content    []byte
+}
+
+var contentChecksumTable = crc64.MakeTable(crc64.ISO)
+
+// GetFile returns the content of a file as it was indexed, read from the
+// content section of the shards s searches. This lets UIs show whole files
+// without a parallel service serving the repositories.
+//
+// The content is validated against the checksum computed at index time, so
+// corrupt shards are reported as ErrChecksumMismatch instead of returning
+// wrong content. Errors wrap ErrInvalidFileRequest, ErrFileNotFound,
+// ErrFileNotIndexed or ErrChecksumMismatch, unless the search fails.
+func GetFile(ctx context.Context, s Searcher, req FileRequest) (*File, error) {
+	if req.Repository == "" || req.Path == "" {
+		return nil, fmt.Errorf("%w: repository and path are required", ErrInvalidFileRequest)
+	}
+	if req.End != 0 && req.End < req.Start {
+		return nil, fmt.Errorf("%w: range end %d before start %d", ErrInvalidFileRequest, req.End, req.Start)
+	}
+
+	qs := []query.Q{query.NewRepoSet(req.Repository), query.NewFileNameSet(req.Path)}
+	if req.Branch != "" {
+		qs = append(qs, &query.Branch{Pattern: req.Branch, Exact: true})
+	}
+	sr, err := s.Search(ctx, query.NewAnd(qs...), &SearchOptions{Whole: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var fm *FileMatch
+	for i := range sr.Files {
+		f := &sr.Files[i]
+		if f.Repository != req.Repository || f.FileName != req.Path {
+			continue
+		}
+		if fm == nil || (req.Branch == "" && slices.Contains(f.Branches, "HEAD")) {
+			fm = f
+		}
+	}
+	if fm == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrFileNotFound, req.Path, req.Repository)
+	}
+
+	content := fm.Content
+	if want := binary.BigEndian.AppendUint64(nil, crc64.Checksum(content, contentChecksumTable)); !bytes.Equal(fm.Checksum, want) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrChecksumMismatch, req.Path, req.Repository)
+	}
+	if reason, ok := bytes.CutPrefix(content, []byte(notIndexedMarker)); ok {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotIndexed, reason)
+	}
+
+	size := uint32(len(content))
+	start, end := req.Start, req.End
+	if end == 0 {
+		end = size
+	}
+	if start > size || end > size {
+		return nil, fmt.Errorf("%w: range [%d, %d) outside of file of %d bytes", ErrInvalidFileRequest, start, end, size)
+	}
+
+	return &File{
+		Repository: fm.Repository,
+		Path:       fm.FileName,
+		Branches:   fm.Branches,
+		Version:    fm.Version,
+		Language:   fm.Language,
+		Encoding:   fm.Encoding,
+		Checksum:   fm.Checksum,
+		Size:       size,
+		Start:      start,
+		End:        end,
+		Content:    content[start:end],
+	}, nil
+}
@@ -0,0 +1,90 @@
+package zoekt
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// corruptSearcher flips the first byte of the content it returns.
+type corruptSearcher struct {
+	Searcher
+}
+
+func (s corruptSearcher) Search(ctx context.Context, q query.Q, opts *SearchOptions) (*SearchResult, error) {
+	sr, err := s.Searcher.Search(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sr.Files {
+		if c := sr.Files[i].Content; len(c) > 0 {
+			sr.Files[i].Content = append([]byte{c[0] ^ 1}, c[1:]...)
+		}
+	}
+	return sr, nil
+}
+
+func TestGetFile(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{
+		Name:     "repo",
+		Branches: []RepositoryBranch{{Name: "HEAD", Version: "v1"}, {Name: "dev", Version: "v2"}},
+	},
+		Document{Name: "main.go", Content: []byte("package dev\n"), Branches: []string{"dev"}},
+		Document{Name: "main.go", Content: []byte("package main\n"), Branches: []string{"HEAD"}},
+		Document{Name: "big.bin", SkipReason: "too large", Branches: []string{"HEAD"}},
+	)
+	s := searcherForTest(t, b)
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		req  FileRequest
+		want string
+	}{
+		{"prefers HEAD", FileRequest{Repository: "repo", Path: "main.go"}, "package main\n"},
+		{"branch", FileRequest{Repository: "repo", Branch: "dev", Path: "main.go"}, "package dev\n"},
+		{"range", FileRequest{Repository: "repo", Path: "main.go", Start: 8, End: 12}, "main"},
+		{"open range", FileRequest{Repository: "repo", Path: "main.go", Start: 8}, "main\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := GetFile(ctx, s, tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(f.Content) != tc.want {
+				t.Fatalf("got content %q, want %q", f.Content, tc.want)
+			}
+			if f.Start != tc.req.Start || f.End != f.Start+uint32(len(tc.want)) {
+				t.Fatalf("got range [%d, %d) for %q", f.Start, f.End, tc.want)
+			}
+			if f.Size == 0 || len(f.Checksum) != 8 || len(f.Branches) != 1 {
+				t.Fatalf("unexpected file %+v", f)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		s    Searcher
+		req  FileRequest
+		want error
+	}{
+		{"missing path", s, FileRequest{Repository: "repo"}, ErrInvalidFileRequest},
+		{"range beyond end", s, FileRequest{Repository: "repo", Path: "main.go", Start: 2, End: 100}, ErrInvalidFileRequest},
+		{"inverted range", s, FileRequest{Repository: "repo", Path: "main.go", Start: 4, End: 2}, ErrInvalidFileRequest},
+		{"unknown path", s, FileRequest{Repository: "repo", Path: "other.go"}, ErrFileNotFound},
+		{"unknown repo", s, FileRequest{Repository: "other", Path: "main.go"}, ErrFileNotFound},
+		{"unknown branch", s, FileRequest{Repository: "repo", Branch: "main", Path: "main.go"}, ErrFileNotFound},
+		{"skipped", s, FileRequest{Repository: "repo", Path: "big.bin"}, ErrFileNotIndexed},
+		{"corrupt", corruptSearcher{s}, FileRequest{Repository: "repo", Path: "main.go"}, ErrChecksumMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := GetFile(ctx, tc.s, tc.req); !errors.Is(err, tc.want) {
+				t.Fatalf("got error %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/sourcegraph/zoekt/query"
@@ -110,3 +111,64 @@ func TestIgnore(t *testing.T) {
 		}
 	}
 }
+
+func TestZoektIgnore(t *testing.T) {
+	dir := t.TempDir()
+
+	script := `git init -b master
+mkdir gen
+echo acont > afile
+echo gen-cont > gen/gen-file
+echo app-cont > app.min.js
+printf 'gen/\n*.min.js\n' > .zoektignore
+git add .
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git commit -am amsg
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      dir,
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads",
+		Branches:     []string{"master"},
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	res, err := searcher.Search(context.Background(), &query.Substring{}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, match := range res.Files {
+		got = append(got, match.FileName)
+	}
+	sort.Strings(got)
+	want := []string{".zoektignore", "afile"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got files %v, want %v", got, want)
+	}
+}
@@ -143,6 +143,12 @@ func setTemplates(repo *zoekt.Repository, u *url.URL, typ string) error {
 		repo.CommitURLTemplate = urlJoinPath("commits", varVersion)
 		repo.FileURLTemplate = urlJoinPath(varPath) + "?at={{.Version}}"
 		repo.LineFragmentTemplate = "#{{.LineNumber}}"
+	case "bitbucket":
+		// https://bitbucket.org/<workspace>/<repo>/commits/5be7ca73b898bf17a08e607918accfdeafe1e0bc
+		// https://bitbucket.org/<workspace>/<repo>/src/5be7ca73b898bf17a08e607918accfdeafe1e0bc/<file>#lines-10
+		repo.CommitURLTemplate = urlJoinPath("commits", varVersion)
+		repo.FileURLTemplate = urlJoinPath("src", varVersion, varPath)
+		repo.LineFragmentTemplate = "#lines-{{.LineNumber}}"
 	case "gitlab":
 		// https://gitlab.com/gitlab-org/omnibus-gitlab/-/commit/b152c864303dae0e55377a1e2c53c9592380ffed
 		// https://gitlab.com/gitlab-org/omnibus-gitlab/-/blob/aad04155b3f6fc50ede88aedaee7fc624d481149/files/gitlab-config-template/gitlab.rb.template
@@ -248,6 +254,12 @@ func setTemplatesFromConfig(desc *zoekt.Repository, repoDir string) error {
 	id, _ := strconv.ParseUint(sec.Options.Get("repoid"), 10, 32)
 	desc.ID = uint32(id)
 
+	// The parent of a fork, see zoekt.Repository.ForkOf. -fork_of takes
+	// precedence.
+	if desc.ForkOf == "" {
+		desc.ForkOf = sec.Options.Get("fork-of")
+	}
+
 	if desc.RawConfig == nil {
 		desc.RawConfig = map[string]string{}
 	}
@@ -255,6 +267,18 @@ func setTemplatesFromConfig(desc *zoekt.Repository, repoDir string) error {
 		desc.RawConfig[o.Key] = o.Value
 	}
 
+	// Repository metadata, eg. `git config zoekt.meta.team payments`. Values
+	// set with -repo_meta take precedence. We always set a non-nil map, so that
+	// removing the last key is picked up as a metadata change.
+	if desc.Metadata == nil {
+		desc.Metadata = map[string]string{}
+	}
+	for _, o := range sec.Subsection("meta").Options {
+		if _, ok := desc.Metadata[o.Key]; !ok {
+			desc.Metadata[o.Key] = o.Value
+		}
+	}
+
 	// Ranking info.
 
 	// Github:
@@ -302,6 +326,13 @@ type Options struct {
 	// If set, follow submodule links. This requires RepoCacheDir to be set.
 	Submodules bool
 
+	// If set, index the content of Git LFS objects instead of their pointer
+	// files. The objects must be fetched into the repository, eg. with "git
+	// lfs fetch --all". Objects over the size limit are skipped like other
+	// large files, and pointers to missing objects are indexed as is.
+	// Submodules are not resolved.
+	ResolveLFS bool
+
 	// If set, skip indexing if the existing index shard is newer
 	// than the refs in the repository.
 	Incremental bool
@@ -542,12 +573,17 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 	sort.Strings(names)
 	names = uniq(names)
 
+	var lfs *lfsStore
+	if opts.ResolveLFS {
+		lfs = newLFSStore(gitDir(opts.RepoDir))
+	}
+
 	log.Printf("attempting to index %d total files", totalFiles)
 	for idx, name := range names {
 		keys := fileKeys[name]
 
 		for _, key := range keys {
-			doc, err := createDocument(key, repos, opts.BuildOptions)
+			doc, err := createDocument(key, repos, opts.BuildOptions, lfs)
 			if err != nil {
 				return false, err
 			}
@@ -564,6 +600,16 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 	return true, builder.Finish()
 }
 
+// gitDir returns the git directory of the repository at repoDir, which is
+// either a bare repository or a work tree.
+func gitDir(repoDir string) string {
+	dotGit := filepath.Join(repoDir, git.GitDirName)
+	if fi, err := os.Stat(dotGit); err == nil && fi.IsDir() {
+		return dotGit
+	}
+	return repoDir
+}
+
 // openRepo opens a git repository in a way that's optimized for indexing.
 //
 // It copies the relevant logic from git.PlainOpen, and tweaks certain filesystem options.
@@ -619,18 +665,42 @@ func (r repoPathRanks) rank(path string, content []byte) float64 {
 }
 
 func newIgnoreMatcher(tree *object.Tree) (*ignore.Matcher, error) {
-	ignoreFile, err := tree.File(ignore.IgnoreFile)
-	if err == object.ErrFileNotFound {
-		return &ignore.Matcher{}, nil
-	}
+	m := &ignore.Matcher{}
+	content, err := fileContents(tree, ignore.IgnoreFile)
 	if err != nil {
 		return nil, err
 	}
-	content, err := ignoreFile.Contents()
+	if content != "" {
+		m, err = ignore.ParseIgnoreFile(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// .zoektignore lets repository owners exclude files with gitignore syntax.
+	content, err = fileContents(tree, ignore.ZoektIgnoreFile)
 	if err != nil {
 		return nil, err
 	}
-	return ignore.ParseIgnoreFile(strings.NewReader(content))
+	if content != "" {
+		if err := m.ParseGitignore(strings.NewReader(content)); err != nil {
+			return nil, fmt.Errorf("%s: %w", ignore.ZoektIgnoreFile, err)
+		}
+	}
+	return m, nil
+}
+
+// fileContents returns the contents of the file at path in tree, or "" if
+// there is no such file.
+func fileContents(tree *object.Tree, path string) (string, error) {
+	f, err := tree.File(path)
+	if err == object.ErrFileNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return f.Contents()
 }
 
 // prepareDeltaBuildFunc is a function that calculates the necessary metadata for preparing
@@ -770,8 +840,8 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 				newFileRelativeRootPath := c.To.Name
 
 				// TODO@ggilmore: HACK - remove once ignore files are supported in delta builds
-				if newFileRelativeRootPath == ignore.IgnoreFile {
-					return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
+				if ignore.IsIgnoreFile(newFileRelativeRootPath) {
+					return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", newFileRelativeRootPath)
 				}
 
 				// either file is added or renamed, so we need to add the new version to the build
@@ -797,8 +867,8 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 			// change's "Name" field is the only way that ggilmore saw to get the full path relative to the root
 			oldFileRelativeRootPath := c.From.Name
 
-			if oldFileRelativeRootPath == ignore.IgnoreFile {
-				return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
+			if ignore.IsIgnoreFile(oldFileRelativeRootPath) {
+				return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", oldFileRelativeRootPath)
 			}
 
 			// The file is either modified or deleted. So, we need to add ALL versions
@@ -861,6 +931,9 @@ func prepareNormalBuild(options Options, repository *git.Repository) (repos map[
 	}
 
 	rw := NewRepoWalker(repository, options.BuildOptions.RepositoryDescription.URL, repoCache)
+	if options.Submodules {
+		rw.SetModulesDir(filepath.Join(gitDir(options.RepoDir), "modules"))
+	}
 	for _, b := range branches {
 		commit, err := getCommit(repository, options.BranchPrefix, b)
 		if err != nil {
@@ -895,6 +968,7 @@ func prepareNormalBuild(options Options, repository *git.Repository) (repos map[
 func createDocument(key fileKey,
 	repos map[fileKey]BlobLocation,
 	opts build.Options,
+	lfs *lfsStore,
 ) (zoekt.Document, error) {
 	repo := repos[key]
 	blob, err := repo.GitRepo.BlobObject(key.ID)
@@ -919,6 +993,19 @@ func createDocument(key fileKey,
 		return zoekt.Document{}, err
 	}
 
+	if lfs != nil && key.SubRepoPath == "" {
+		if p, ok := parseLFSPointer(contents); ok {
+			if p.Size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(keyFullPath) {
+				return skippedLargeDoc(key, branches, opts), nil
+			}
+			if lfsContents, err := lfs.read(p); err == nil {
+				contents = lfsContents
+			} else if !os.IsNotExist(err) {
+				log.Printf("%s: %v", keyFullPath, err)
+			}
+		}
+	}
+
 	return zoekt.Document{
 		SubRepositoryPath: key.SubRepoPath,
 		Name:              keyFullPath,
@@ -866,6 +866,26 @@ func TestSetTemplates_e2e(t *testing.T) {
 	}
 }
 
+func TestSetMetadataFromConfig(t *testing.T) {
+	repositoryDir := t.TempDir()
+
+	runScript(t, repositoryDir, "git init -b master")
+	runScript(t, repositoryDir, "git config zoekt.name repo")
+	runScript(t, repositoryDir, "git config zoekt.meta.team payments")
+	runScript(t, repositoryDir, "git config zoekt.meta.tier 1")
+
+	// Metadata set on the command line takes precedence.
+	desc := zoekt.Repository{Metadata: map[string]string{"tier": "2"}}
+	if err := setTemplatesFromConfig(&desc, repositoryDir); err != nil {
+		t.Fatalf("setTemplatesFromConfig: %v", err)
+	}
+
+	want := map[string]string{"team": "payments", "tier": "2"}
+	if d := cmp.Diff(want, desc.Metadata); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
 func TestSetTemplates(t *testing.T) {
 	base := "https://example.com/repo/name"
 	version := "VERSION"
@@ -906,6 +926,11 @@ func TestSetTemplates(t *testing.T) {
 		commit: "https://example.com/repo/name/commits/VERSION",
 		file:   "https://example.com/repo/name/dir/name.txt?at=VERSION",
 		line:   "#10",
+	}, {
+		typ:    "bitbucket",
+		commit: "https://example.com/repo/name/commits/VERSION",
+		file:   "https://example.com/repo/name/src/VERSION/dir/name.txt",
+		line:   "#lines-10",
 	}, {
 		typ:    "gitlab",
 		commit: "https://example.com/repo/name/-/commit/VERSION",
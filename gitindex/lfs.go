@@ -0,0 +1,87 @@
+package gitindex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Git LFS replaces large files by small pointer files, see
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md. The objects
+// themselves live in lfs/objects in the git directory once they are fetched,
+// eg. with "git lfs fetch --all".
+
+// lfsPointerMax is the maximum size of a pointer file.
+const lfsPointerMax = 1024
+
+var lfsVersionLine = []byte("version https://git-lfs.github.com/spec/v1\n")
+
+// lfsPointer is the object a pointer file refers to.
+type lfsPointer struct {
+	// OID is the hex encoded SHA-256 of the object.
+	OID  string
+	Size int64
+}
+
+// parseLFSPointer returns the object content refers to, if content is a
+// pointer file.
+func parseLFSPointer(content []byte) (lfsPointer, bool) {
+	if len(content) > lfsPointerMax || !bytes.HasPrefix(content, lfsVersionLine) {
+		return lfsPointer{}, false
+	}
+
+	var p lfsPointer
+	size := int64(-1)
+	for _, line := range bytes.Split(content[len(lfsVersionLine):], []byte("\n")) {
+		key, value, _ := bytes.Cut(line, []byte(" "))
+		switch string(key) {
+		case "oid":
+			oid, ok := bytes.CutPrefix(value, []byte("sha256:"))
+			if !ok || len(oid) != 2*sha256.Size {
+				return lfsPointer{}, false
+			}
+			if _, err := hex.DecodeString(string(oid)); err != nil {
+				return lfsPointer{}, false
+			}
+			p.OID = string(oid)
+		case "size":
+			n, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil || n < 0 {
+				return lfsPointer{}, false
+			}
+			size = n
+		}
+	}
+	if p.OID == "" || size < 0 {
+		return lfsPointer{}, false
+	}
+	p.Size = size
+	return p, true
+}
+
+// lfsStore reads objects from the LFS object directory of a repository.
+type lfsStore struct {
+	dir string
+}
+
+func newLFSStore(gitDir string) *lfsStore {
+	return &lfsStore{dir: filepath.Join(gitDir, "lfs", "objects")}
+}
+
+// read returns the content of the object p refers to. It returns an error
+// satisfying os.IsNotExist if the object wasn't fetched.
+func (s *lfsStore) read(p lfsPointer) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(s.dir, p.OID[0:2], p.OID[2:4], p.OID))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != p.OID {
+		return nil, fmt.Errorf("LFS object %s is corrupt", p.OID)
+	}
+	return content, nil
+}
@@ -0,0 +1,111 @@
+package gitindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func lfsPointerFile(content string) (string, string) {
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	return oid, fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, len(content))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid, pointer := lfsPointerFile("hello\n")
+
+	if p, ok := parseLFSPointer([]byte(pointer)); !ok || p != (lfsPointer{OID: oid, Size: 6}) {
+		t.Errorf("got %v, %v", p, ok)
+	}
+
+	for _, bad := range []string{
+		"hello\n",
+		"version https://git-lfs.github.com/spec/v1\nsize 6\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 6\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:" + oid + "\nsize -1\n",
+	} {
+		if p, ok := parseLFSPointer([]byte(bad)); ok {
+			t.Errorf("parseLFSPointer(%q) = %v, want not a pointer", bad, p)
+		}
+	}
+}
+
+func TestIndexLFS(t *testing.T) {
+	dir := t.TempDir()
+
+	fetchedOID, fetched := lfsPointerFile("fetched lfs content\n")
+	_, missing := lfsPointerFile("missing lfs content\n")
+	for name, content := range map[string]string{
+		"fetched.txt": fetched,
+		"missing.txt": missing,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := `git init -b master
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git add fetched.txt missing.txt
+git commit -m lfs
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	objDir := filepath.Join(dir, ".git", "lfs", "objects", fetchedOID[0:2], fetchedOID[2:4])
+	if err := os.MkdirAll(objDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objDir, fetchedOID), []byte("fetched lfs content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir: dir,
+		BuildOptions: build.Options{
+			IndexDir:              indexDir,
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+		},
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+		ResolveLFS:   true,
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for pattern, want := range map[string]string{
+		"fetched lfs content": "fetched.txt",
+		// Pointers to objects that weren't fetched are indexed as is.
+		"git-lfs.github.com": "missing.txt",
+	} {
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: pattern, Content: true}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 1 || res.Files[0].FileName != want {
+			t.Errorf("%q: got %v, want %s", pattern, res.Files, want)
+		}
+	}
+}
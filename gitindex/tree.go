@@ -19,6 +19,7 @@ import (
 	"io"
 	"log"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -33,7 +34,10 @@ import (
 
 // RepoWalker walks one or more commit trees, collecting the files to index in its Files map.
 //
-// It also recurses into submodules if Options.Submodules is enabled.
+// It also recurses into submodules if Options.Submodules is enabled. It looks
+// for them in the modules directory of the repository, as populated by "git
+// clone --recurse-submodules" or "git submodule update --init", and else in
+// the repository cache.
 type RepoWalker struct {
 	Files map[fileKey]BlobLocation
 
@@ -42,7 +46,14 @@ type RepoWalker struct {
 
 	// Path => SubmoduleEntry
 	submodules map[string]*SubmoduleEntry
-	repoCache  *RepoCache
+	// Path => submodule name, which names its directory in modulesDir.
+	submoduleNames map[string]string
+	repoCache      *RepoCache
+
+	// modulesDir is the directory holding the git directories of
+	// submodules, eg. ".git/modules". It is empty if we don't look for
+	// submodules there.
+	modulesDir string
 }
 
 // subURL returns the URL for a submodule.
@@ -70,9 +81,20 @@ func NewRepoWalker(r *git.Repository, repoURL string, repoCache *RepoCache) *Rep
 	}
 }
 
+// SetModulesDir makes rw look for submodules in dir, the "modules" directory
+// of the git directory of the repository.
+func (rw *RepoWalker) SetModulesDir(dir string) {
+	rw.modulesDir = dir
+}
+
+// followSubmodules returns true if we can find submodule repositories.
+func (rw *RepoWalker) followSubmodules() bool {
+	return rw.repoCache != nil || rw.modulesDir != ""
+}
+
 // parseModuleMap initializes rw.submodules.
 func (rw *RepoWalker) parseModuleMap(t *object.Tree) error {
-	if rw.repoCache == nil {
+	if !rw.followSubmodules() {
 		return nil
 	}
 	modEntry, _ := t.File(".gitmodules")
@@ -86,8 +108,10 @@ func (rw *RepoWalker) parseModuleMap(t *object.Tree) error {
 			return fmt.Errorf("ParseGitModules: %w", err)
 		}
 		rw.submodules = map[string]*SubmoduleEntry{}
-		for _, entry := range mods {
+		rw.submoduleNames = map[string]string{}
+		for name, entry := range mods {
 			rw.submodules[entry.Path] = entry
+			rw.submoduleNames[entry.Path] = name
 		}
 	}
 	return nil
@@ -138,12 +162,7 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 		return fmt.Errorf("no entry for submodule path %q", rw.repoURL)
 	}
 
-	subURL, err := rw.subURL(submod.URL)
-	if err != nil {
-		return err
-	}
-
-	subRepo, err := rw.repoCache.Open(subURL)
+	subRepo, subURL, subModulesDir, err := rw.openSubmodule(p, submod)
 	if err != nil {
 		return err
 	}
@@ -160,6 +179,7 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 	subRepoVersions[p] = *id
 
 	sw := NewRepoWalker(subRepo, subURL.String(), rw.repoCache)
+	sw.SetModulesDir(subModulesDir)
 	subVersions, err := sw.CollectFiles(tree, branch, ig)
 	if err != nil {
 		return err
@@ -177,8 +197,39 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 	return nil
 }
 
+// openSubmodule opens the repository of the submodule at path p. It returns
+// the repository, its URL and its modules directory, if it has one.
+func (rw *RepoWalker) openSubmodule(p string, submod *SubmoduleEntry) (*git.Repository, *url.URL, string, error) {
+	subURL, urlErr := rw.subURL(submod.URL)
+
+	if rw.modulesDir != "" {
+		gitDir := filepath.Join(rw.modulesDir, rw.submoduleNames[p])
+		if _, err := os.Stat(gitDir); err == nil {
+			repo, err := git.PlainOpen(gitDir)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			if urlErr != nil {
+				// We can't resolve relative URLs if the repository has no
+				// URL, so name the submodule after its path.
+				subURL = &url.URL{Path: p}
+			}
+			return repo, subURL, filepath.Join(gitDir, "modules"), nil
+		}
+	}
+
+	if rw.repoCache == nil {
+		return nil, nil, "", fmt.Errorf("submodule %q is not checked out", p)
+	}
+	if urlErr != nil {
+		return nil, nil, "", urlErr
+	}
+	repo, err := rw.repoCache.Open(subURL)
+	return repo, subURL, "", err
+}
+
 func (rw *RepoWalker) handleEntry(p string, e *object.TreeEntry, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher) error {
-	if e.Mode == filemode.Submodule && rw.repoCache != nil {
+	if e.Mode == filemode.Submodule && rw.followSubmodules() {
 		if err := rw.tryHandleSubmodule(p, &e.Hash, branch, subRepoVersions, ig); err != nil {
 			return fmt.Errorf("submodule %s: %v", p, err)
 		}
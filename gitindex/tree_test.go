@@ -258,6 +258,61 @@ func TestSubmoduleIndex(t *testing.T) {
 	}
 }
 
+func TestSubmoduleIndexWorkTree(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := createSubmoduleRepo(dir); err != nil {
+		t.Fatalf("createSubmoduleRepo: %v", err)
+	}
+
+	// adir is a work tree with the submodule checked out in .git/modules,
+	// so we don't need a repository cache.
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir: filepath.Join(dir, "adir"),
+		BuildOptions: build.Options{
+			IndexDir:              indexDir,
+			RepositoryDescription: zoekt.Repository{Name: "adir"},
+		},
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+		Submodules:   true,
+	}
+	if _, err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	results, err := searcher.Search(context.Background(), &query.Substring{Pattern: "bcont"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal("Search", err)
+	}
+	if len(results.Files) != 1 {
+		t.Fatalf("got search result %v, want 1 file", results.Files)
+	}
+
+	file := results.Files[0]
+	if got, want := file.FileName, "bname/bfile"; got != want {
+		t.Errorf("got file name %q, want %q", got, want)
+	}
+	if got, want := file.SubRepositoryPath, "bname"; got != want {
+		t.Errorf("got subrepo path %q, want %q", got, want)
+	}
+
+	out, err := exec.Command("git", "-C", filepath.Join(dir, "bdir"), "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file.Version, string(bytes.TrimSpace(out)); got != want {
+		t.Errorf("got version %q, want bdir commit %q", got, want)
+	}
+}
+
 func createSymlinkRepo(dir string) error {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return err
@@ -0,0 +1,744 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// maxFields limits the number of fields of a query, counting the fields of
+// fragments every time they are spread. It protects against queries which
+// are small, but expand to a huge number of fields.
+const maxFields = 2000
+
+// resolveFunc returns the value of a field. Fields without resolveFunc take
+// their value from the map[string]any of their parent.
+type resolveFunc func(ctx context.Context, p *params) (any, error)
+
+// params are the parameters of a resolveFunc.
+type params struct {
+	// source is the value of the parent object.
+	source any
+	args   map[string]any
+
+	e      *executor
+	fields []*field
+}
+
+// selects reports whether the selection set of the field contains the
+// field path, eg. selects("files", "content"). Resolvers use it to avoid
+// fetching data which isn't selected.
+func (p *params) selects(path ...string) bool {
+	for _, f := range p.fields {
+		if p.e.selects(f.sel, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// schema is an executable schema: the type definitions of its SDL, with
+// resolvers for the fields which need them.
+type schema struct {
+	types     map[string]*typeDef
+	resolvers map[string]resolveFunc // keyed by "Type.field"
+}
+
+var builtinScalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// newSchema returns the schema defined by sdl. The root type must be called
+// Query.
+func newSchema(sdl string, resolvers map[string]resolveFunc) (*schema, error) {
+	doc, err := parse(sdl)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.operations) > 0 || len(doc.fragments) > 0 {
+		return nil, errors.New("schema contains executable definitions")
+	}
+	if t := doc.types["Query"]; t == nil || t.kind != "type" {
+		return nil, errors.New("schema has no Query type")
+	}
+
+	s := &schema{types: doc.types, resolvers: resolvers}
+	known := func(t *typeRef) error {
+		for t.elem != nil {
+			t = t.elem
+		}
+		if !builtinScalars[t.name] && s.types[t.name] == nil {
+			return fmt.Errorf("unknown type %s", t.name)
+		}
+		return nil
+	}
+	for _, t := range s.types {
+		for _, fd := range t.fields {
+			if err := known(fd.typ); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.name, fd.name, err)
+			}
+			for _, arg := range fd.args {
+				if err := known(arg.typ); err != nil {
+					return nil, fmt.Errorf("%s.%s(%s): %w", t.name, fd.name, arg.name, err)
+				}
+			}
+		}
+		for _, iv := range t.inputFields {
+			if err := known(iv.typ); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.name, iv.name, err)
+			}
+		}
+	}
+	for key := range resolvers {
+		var found bool
+		for _, t := range s.types {
+			for _, fd := range t.fields {
+				found = found || t.name+"."+fd.name == key
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("resolver for unknown field %s", key)
+		}
+	}
+	return s, nil
+}
+
+// Error is a GraphQL error. Path is the response path of the field which
+// failed, if the error happened during execution.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// response is the result of executing a request. Data is missing if the
+// request failed before execution.
+type response struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []*Error        `json:"errors,omitempty"`
+}
+
+func requestError(err error) *response {
+	return &response{Errors: []*Error{{Message: err.Error()}}}
+}
+
+// execute runs the operation operationName of the document query.
+func (s *schema) execute(ctx context.Context, query, operationName string, variables map[string]any) *response {
+	doc, err := parse(query)
+	if err != nil {
+		return requestError(err)
+	}
+	if len(doc.types) > 0 {
+		return requestError(errors.New("document contains type definitions"))
+	}
+
+	var op *operation
+	for _, o := range doc.operations {
+		if o.name == operationName || (operationName == "" && len(doc.operations) == 1) {
+			op = o
+		}
+	}
+	switch {
+	case op == nil && operationName == "":
+		return requestError(errors.New("operationName is required for documents with several operations"))
+	case op == nil:
+		return requestError(fmt.Errorf("unknown operation %q", operationName))
+	case op.kind != "query":
+		return requestError(fmt.Errorf("%s operations are not supported", op.kind))
+	}
+
+	e := &executor{schema: s, doc: doc}
+	if err := e.validate(s.types["Query"], op.sel); err != nil {
+		return requestError(err)
+	}
+	if e.vars, err = e.coerceVariables(op.vars, variables); err != nil {
+		return requestError(err)
+	}
+
+	data, ok := e.selectionSet(ctx, s.types["Query"], nil, op.sel, nil)
+	resp := &response{Errors: e.errors, Data: json.RawMessage("null")}
+	if ok {
+		if resp.Data, err = json.Marshal(data); err != nil {
+			return requestError(err)
+		}
+	}
+	return resp
+}
+
+type executor struct {
+	schema *schema
+	doc    *document
+	vars   map[string]any
+	errors []*Error
+}
+
+func (e *executor) fieldError(path []any, err error) {
+	e.errors = append(e.errors, &Error{Message: err.Error(), Path: append([]any{}, path...)})
+}
+
+// validate checks that the selection set sel of an object of type t only
+// selects fields, arguments and fragments which exist.
+func (e *executor) validate(t *typeDef, sel []selection) error {
+	count := 0
+	visiting := map[string]bool{}
+
+	var check func(t *typeDef, sel []selection) error
+	check = func(t *typeDef, sel []selection) error {
+		for _, s := range sel {
+			if err := e.validateDirectives(s); err != nil {
+				return err
+			}
+			switch s := s.(type) {
+			case *field:
+				if count++; count > maxFields {
+					return fmt.Errorf("query selects more than %d fields", maxFields)
+				}
+				if s.name == "__typename" {
+					if s.sel != nil {
+						return errors.New("field __typename must not have a selection")
+					}
+					continue
+				}
+				fd := t.fields[s.name]
+				if fd == nil {
+					return fmt.Errorf("cannot query field %q on type %q", s.name, t.name)
+				}
+				given := map[string]bool{}
+				for _, a := range s.args {
+					if fd.args[a.name] == nil {
+						return fmt.Errorf("unknown argument %q on field %s.%s", a.name, t.name, s.name)
+					}
+					given[a.name] = true
+				}
+				for _, arg := range fd.args {
+					if arg.typ.nonNull && !arg.hasDef && !given[arg.name] {
+						return fmt.Errorf("argument %q of field %s.%s is required", arg.name, t.name, s.name)
+					}
+				}
+
+				named := fd.typ
+				for named.elem != nil {
+					named = named.elem
+				}
+				td := e.schema.types[named.name]
+				if td != nil && td.kind == "type" {
+					if s.sel == nil {
+						return fmt.Errorf("field %q of type %q must have a selection of subfields", s.name, fd.typ)
+					}
+					if err := check(td, s.sel); err != nil {
+						return err
+					}
+				} else if s.sel != nil {
+					return fmt.Errorf("field %q must not have a selection since type %q has no subfields", s.name, fd.typ)
+				}
+
+			case *fragmentSpread:
+				f := e.doc.fragments[s.name]
+				if f == nil {
+					return fmt.Errorf("unknown fragment %q", s.name)
+				}
+				if f.typeCond != t.name {
+					return fmt.Errorf("fragment %q on %q cannot be spread in type %q", s.name, f.typeCond, t.name)
+				}
+				if visiting[s.name] {
+					return fmt.Errorf("fragment %q spreads itself", s.name)
+				}
+				visiting[s.name] = true
+				err := check(t, f.sel)
+				delete(visiting, s.name)
+				if err != nil {
+					return err
+				}
+
+			case *inlineFragment:
+				if s.typeCond != "" && s.typeCond != t.name {
+					return fmt.Errorf("inline fragment on %q cannot be used in type %q", s.typeCond, t.name)
+				}
+				if err := check(t, s.sel); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return check(t, sel)
+}
+
+func (e *executor) validateDirectives(s selection) error {
+	for _, d := range directivesOf(s) {
+		if d.name != "skip" && d.name != "include" {
+			return fmt.Errorf("unknown directive @%s", d.name)
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			return fmt.Errorf("directive @%s requires exactly the argument \"if\"", d.name)
+		}
+	}
+	return nil
+}
+
+func directivesOf(s selection) []*directive {
+	switch s := s.(type) {
+	case *field:
+		return s.directives
+	case *fragmentSpread:
+		return s.directives
+	case *inlineFragment:
+		return s.directives
+	}
+	return nil
+}
+
+// included evaluates the @skip and @include directives of s.
+func (e *executor) included(s selection) (bool, error) {
+	for _, d := range directivesOf(s) {
+		v, err := e.coerce(&typeRef{name: "Boolean", nonNull: true}, d.args[0].val)
+		if err != nil {
+			return false, fmt.Errorf("@%s: %w", d.name, err)
+		}
+		if v.(bool) == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// collectFields returns the fields of sel which are included, by response
+// key, expanding fragments.
+func (e *executor) collectFields(sel []selection, keys []string, fields map[string][]*field) ([]string, error) {
+	for _, s := range sel {
+		if ok, err := e.included(s); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		var err error
+		switch s := s.(type) {
+		case *field:
+			key := s.responseKey()
+			if _, ok := fields[key]; !ok {
+				keys = append(keys, key)
+			}
+			fields[key] = append(fields[key], s)
+		case *fragmentSpread:
+			keys, err = e.collectFields(e.doc.fragments[s.name].sel, keys, fields)
+		case *inlineFragment:
+			keys, err = e.collectFields(s.sel, keys, fields)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (e *executor) selects(sel []selection, path []string) bool {
+	fields := map[string][]*field{}
+	keys, err := e.collectFields(sel, nil, fields)
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		for _, f := range fields[key] {
+			if f.name == path[0] && (len(path) == 1 || e.selects(f.sel, path[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectionSet executes sel on source, an object of type t. If ok is false,
+// a non-null field failed, so the object itself is null.
+func (e *executor) selectionSet(ctx context.Context, t *typeDef, source any, sel []selection, path []any) (result *orderedMap, ok bool) {
+	fields := map[string][]*field{}
+	keys, err := e.collectFields(sel, nil, fields)
+	if err != nil {
+		e.fieldError(path, err)
+		return nil, false
+	}
+
+	result = &orderedMap{values: map[string]any{}}
+	for _, key := range keys {
+		f := fields[key][0]
+		fieldPath := append(path[:len(path):len(path)], key)
+
+		if f.name == "__typename" {
+			result.set(key, t.name)
+			continue
+		}
+
+		fd := t.fields[f.name]
+		v, ok := e.field(ctx, t, fd, source, fields[key], fieldPath)
+		if !ok {
+			return nil, false
+		}
+		result.set(key, v)
+	}
+	return result, true
+}
+
+func (e *executor) field(ctx context.Context, t *typeDef, fd *fieldDef, source any, fields []*field, path []any) (any, bool) {
+	args, err := e.coerceArgs(fd.args, fields[0].args)
+	if err != nil {
+		e.fieldError(path, err)
+		return nil, !fd.typ.nonNull
+	}
+
+	var v any
+	if resolve := e.schema.resolvers[t.name+"."+fd.name]; resolve != nil {
+		v, err = resolve(ctx, &params{source: source, args: args, e: e, fields: fields})
+		if err != nil {
+			e.fieldError(path, err)
+			return nil, !fd.typ.nonNull
+		}
+	} else if m, ok := source.(map[string]any); ok {
+		v = m[fd.name]
+	}
+
+	return e.complete(ctx, fd.typ, fields, v, path)
+}
+
+// complete converts the value v of a field of type t to its result.
+func (e *executor) complete(ctx context.Context, t *typeRef, fields []*field, v any, path []any) (any, bool) {
+	result, ok := e.completeNullable(ctx, t, fields, v, path)
+	if t.nonNull && (!ok || result == nil) {
+		if ok {
+			e.fieldError(path, fmt.Errorf("cannot return null for non-null field of type %s", t))
+		}
+		return nil, false
+	}
+	if !ok {
+		return nil, true
+	}
+	return result, true
+}
+
+func (e *executor) completeNullable(ctx context.Context, t *typeRef, fields []*field, v any, path []any) (any, bool) {
+	if isNull(v) {
+		return nil, true
+	}
+
+	if t.elem != nil {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			e.fieldError(path, fmt.Errorf("expected list, got %T", v))
+			return nil, false
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			item, ok := e.complete(ctx, t.elem, fields, rv.Index(i).Interface(), append(path[:len(path):len(path)], i))
+			if !ok {
+				return nil, false
+			}
+			list[i] = item
+		}
+		return list, true
+	}
+
+	if td := e.schema.types[t.name]; td != nil && td.kind == "type" {
+		var sel []selection
+		for _, f := range fields {
+			sel = append(sel, f.sel...)
+		}
+		result, ok := e.selectionSet(ctx, td, v, sel, path)
+		if !ok {
+			return nil, false
+		}
+		return result, true
+	}
+
+	result, err := serialize(t.name, v)
+	if err != nil {
+		e.fieldError(path, err)
+		return nil, false
+	}
+	return result, true
+}
+
+func isNull(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// serialize converts v to a result of the scalar type name.
+func serialize(name string, v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	switch name {
+	case "Int":
+		switch {
+		case rv.CanInt():
+			return rv.Int(), nil
+		case rv.CanUint():
+			return rv.Uint(), nil
+		}
+	case "Float":
+		var f float64
+		switch {
+		case rv.CanFloat():
+			f = rv.Float()
+		case rv.CanInt():
+			f = float64(rv.Int())
+		case rv.CanUint():
+			f = float64(rv.Uint())
+		default:
+			return nil, fmt.Errorf("cannot serialize %T as Float", v)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("cannot serialize %v as Float", f)
+		}
+		return f, nil
+	case "String", "ID":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		case time.Time:
+			return v.Format(time.RFC3339), nil
+		case fmt.Stringer:
+			return v.String(), nil
+		}
+		if name == "ID" && rv.CanInt() {
+			return strconv.FormatInt(rv.Int(), 10), nil
+		}
+		if name == "ID" && rv.CanUint() {
+			return strconv.FormatUint(rv.Uint(), 10), nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		// Custom scalars are serialized as is.
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot serialize %T as %s", v, name)
+}
+
+// coerceVariables coerces the JSON values of the variables of an operation.
+func (e *executor) coerceVariables(defs []*inputValue, values map[string]any) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, def := range defs {
+		v, ok := values[def.name]
+		if !ok {
+			if !def.hasDef {
+				if def.typ.nonNull {
+					return nil, fmt.Errorf("variable $%s of type %s is required", def.name, def.typ)
+				}
+				continue
+			}
+			v = def.def
+		}
+		c, err := e.coerce(def.typ, v)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %w", def.name, err)
+		}
+		vars[def.name] = c
+	}
+	return vars, nil
+}
+
+// coerceArgs coerces the arguments of a field to the types of their
+// definitions, and adds the default values of missing arguments.
+func (e *executor) coerceArgs(defs map[string]*inputValue, args []*argument) (map[string]any, error) {
+	given := map[string]value{}
+	for _, a := range args {
+		given[a.name] = a.val
+	}
+
+	out := map[string]any{}
+	for name, def := range defs {
+		v, ok := given[name]
+		if vr, isVar := v.(variable); ok && isVar {
+			_, ok = e.vars[string(vr)]
+		}
+		if !ok {
+			if !def.hasDef {
+				if def.typ.nonNull {
+					return nil, fmt.Errorf("argument %q of type %s is required", name, def.typ)
+				}
+				continue
+			}
+			v = def.def
+		}
+		c, err := e.coerce(def.typ, v)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", name, err)
+		}
+		out[name] = c
+	}
+	return out, nil
+}
+
+// coerce converts the literal or JSON value v to the input type t. The
+// values of variables have been coerced already.
+func (e *executor) coerce(t *typeRef, v any) (any, error) {
+	if vr, ok := v.(variable); ok {
+		v, ok = e.vars[string(vr)]
+		if !ok {
+			v = nil
+		}
+		if v == nil && t.nonNull {
+			return nil, fmt.Errorf("variable $%s of type %s must not be null", vr, t)
+		}
+		return v, nil
+	}
+	if v == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("null for non-null type %s", t)
+		}
+		return nil, nil
+	}
+
+	if t.elem != nil {
+		items, ok := v.([]any)
+		if !ok {
+			// A single value is a list of one value.
+			items = []any{v}
+		}
+		list := make([]any, len(items))
+		for i, item := range items {
+			c, err := e.coerce(t.elem, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = c
+		}
+		return list, nil
+	}
+
+	switch t.name {
+	case "Int":
+		switch v := v.(type) {
+		case int:
+			return v, nil
+		case float64:
+			// JSON numbers of variables.
+			if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := v.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		td := e.schema.types[t.name]
+		if td.kind == "scalar" {
+			return v, nil
+		}
+		var obj map[string]any
+		switch v := v.(type) {
+		case objectValue:
+			obj = v
+		case map[string]any:
+			obj = v
+		default:
+			return nil, fmt.Errorf("expected input object %s, got %s", t.name, describeValue(v))
+		}
+		for name := range obj {
+			if td.inputFields[name] == nil {
+				return nil, fmt.Errorf("unknown field %q of input %s", name, t.name)
+			}
+		}
+		out := map[string]any{}
+		for name, def := range td.inputFields {
+			fv, ok := obj[name]
+			if !ok {
+				if !def.hasDef {
+					if def.typ.nonNull {
+						return nil, fmt.Errorf("field %q of input %s is required", name, t.name)
+					}
+					continue
+				}
+				fv = def.def
+			}
+			c, err := e.coerce(def.typ, fv)
+			if err != nil {
+				return nil, fmt.Errorf("field %q of input %s: %w", name, t.name, err)
+			}
+			out[name] = c
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %s", t.name, describeValue(v))
+}
+
+func describeValue(v any) string {
+	switch v := v.(type) {
+	case enumValue:
+		return string(v)
+	case string:
+		return strconv.Quote(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// orderedMap is a JSON object which keeps the order of its keys, since
+// GraphQL results have the order of the query.
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *orderedMap) set(key string, v any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,237 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testSchema = `
+type Query {
+  hello(name: String = "world"): String!
+  add(a: Int!, b: Int = 1): Int!
+  user(id: ID!): User
+  users(filter: Filter): [User!]!
+  fail: String
+  failNonNull: String!
+}
+
+input Filter {
+  prefix: String = ""
+  limit: Int
+}
+
+type User {
+  id: ID!
+  name: String!
+  friends: [User!]!
+  email: String
+}
+`
+
+func testExecutor(t *testing.T) (*schema, *[]string) {
+	t.Helper()
+
+	users := []map[string]any{
+		{"id": 1, "name": "alice", "email": "alice@example.com"},
+		{"id": 2, "name": "bob"},
+		{"id": 3, "name": "carol"},
+	}
+	var lookahead []string
+
+	s, err := newSchema(testSchema, map[string]resolveFunc{
+		"Query.hello": func(ctx context.Context, p *params) (any, error) {
+			return "hello " + p.args["name"].(string), nil
+		},
+		"Query.add": func(ctx context.Context, p *params) (any, error) {
+			return p.args["a"].(int) + p.args["b"].(int), nil
+		},
+		"Query.user": func(ctx context.Context, p *params) (any, error) {
+			if p.selects("friends", "email") {
+				lookahead = append(lookahead, "friends.email")
+			}
+			for _, u := range users {
+				if itoa(u["id"].(int)) == p.args["id"] {
+					return u, nil
+				}
+			}
+			return nil, nil
+		},
+		"Query.users": func(ctx context.Context, p *params) (any, error) {
+			var out []map[string]any
+			f, _ := p.args["filter"].(map[string]any)
+			for _, u := range users {
+				if f != nil && !strings.HasPrefix(u["name"].(string), f["prefix"].(string)) {
+					continue
+				}
+				out = append(out, u)
+			}
+			if f != nil && f["limit"] != nil && len(out) > f["limit"].(int) {
+				out = out[:f["limit"].(int)]
+			}
+			return out, nil
+		},
+		"Query.fail": func(ctx context.Context, p *params) (any, error) {
+			return nil, errors.New("boom")
+		},
+		"Query.failNonNull": func(ctx context.Context, p *params) (any, error) {
+			return nil, errors.New("boom")
+		},
+		"User.friends": func(ctx context.Context, p *params) (any, error) {
+			var out []map[string]any
+			for _, u := range users {
+				if u["id"] != p.source.(map[string]any)["id"] {
+					out = append(out, u)
+				}
+			}
+			return out, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &lookahead
+}
+
+func itoa(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func TestExecute(t *testing.T) {
+	s, _ := testExecutor(t)
+
+	cases := []struct {
+		name      string
+		query     string
+		operation string
+		vars      map[string]any
+		want      string
+	}{{
+		name:  "defaults",
+		query: `{ hello add(a: 1) }`,
+		want:  `{"data":{"hello":"hello world","add":2}}`,
+	}, {
+		name:  "aliases and arguments",
+		query: `query { a: hello(name: "zoekt") b: add(a: 2, b: 3), __typename }`,
+		want:  `{"data":{"a":"hello zoekt","b":5,"__typename":"Query"}}`,
+	}, {
+		name:  "variables",
+		query: `query Q($a: Int!, $name: String = "default") { add(a: $a, b: $a) hello(name: $name) }`,
+		vars:  map[string]any{"a": 21.0},
+		want:  `{"data":{"add":42,"hello":"hello default"}}`,
+	}, {
+		name:  "nested objects keep the order of the query",
+		query: `{ user(id: "1") { name id friends { name } } }`,
+		want:  `{"data":{"user":{"name":"alice","id":"1","friends":[{"name":"bob"},{"name":"carol"}]}}}`,
+	}, {
+		name:  "null object",
+		query: `{ user(id: "9") { name } }`,
+		want:  `{"data":{"user":null}}`,
+	}, {
+		name:  "input objects",
+		query: `query($f: Filter) { users(filter: $f) { name } limited: users(filter: {limit: 1}) { name } }`,
+		vars:  map[string]any{"f": map[string]any{"prefix": "c"}},
+		want:  `{"data":{"users":[{"name":"carol"}],"limited":[{"name":"alice"}]}}`,
+	}, {
+		name: "fragments",
+		query: `
+			query { user(id: "2") { ...userFields ... on User { email } } }
+			fragment userFields on User { id name }`,
+		want: `{"data":{"user":{"id":"2","name":"bob","email":null}}}`,
+	}, {
+		name:  "skip and include",
+		query: `query($yes: Boolean!) { hello @skip(if: $yes) add(a: 1) @include(if: $yes) ... @include(if: false) { fail } }`,
+		vars:  map[string]any{"yes": true},
+		want:  `{"data":{"add":2}}`,
+	}, {
+		name:      "operation name",
+		query:     `query A { hello } query B { add(a: 0) }`,
+		operation: "B",
+		want:      `{"data":{"add":1}}`,
+	}, {
+		name:  "field error",
+		query: `{ hello fail }`,
+		want:  `{"data":{"hello":"hello world","fail":null},"errors":[{"message":"boom","path":["fail"]}]}`,
+	}, {
+		name:  "non-null field error propagates",
+		query: `{ hello failNonNull }`,
+		want:  `{"data":null,"errors":[{"message":"boom","path":["failNonNull"]}]}`,
+	}, {
+		name:  "syntax error",
+		query: `{ hello(name: ) }`,
+		want:  `{"errors":[{"message":"syntax error at 1:15: expected value, got \")\""}]}`,
+	}, {
+		name:  "unknown field",
+		query: `{ user(id: "1") { password } }`,
+		want:  `{"errors":[{"message":"cannot query field \"password\" on type \"User\""}]}`,
+	}, {
+		name:  "missing selection",
+		query: `{ user(id: "1") }`,
+		want:  `{"errors":[{"message":"field \"user\" of type \"User\" must have a selection of subfields"}]}`,
+	}, {
+		name:  "missing argument",
+		query: `{ add }`,
+		want:  `{"errors":[{"message":"argument \"a\" of field Query.add is required"}]}`,
+	}, {
+		name:  "missing variable",
+		query: `query($a: Int!) { add(a: $a) }`,
+		want:  `{"errors":[{"message":"variable $a of type Int! is required"}]}`,
+	}, {
+		name:  "wrong variable type",
+		query: `query($a: Int!) { add(a: $a) }`,
+		vars:  map[string]any{"a": "one"},
+		want:  `{"errors":[{"message":"variable $a: expected Int, got \"one\""}]}`,
+	}, {
+		name:  "fragment cycle",
+		query: `{ user(id: "1") { ...a } } fragment a on User { friends { ...a } }`,
+		want:  `{"errors":[{"message":"fragment \"a\" spreads itself"}]}`,
+	}, {
+		name:  "mutations",
+		query: `mutation { hello }`,
+		want:  `{"errors":[{"message":"mutation operations are not supported"}]}`,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := s.execute(context.Background(), tc.query, tc.operation, tc.vars)
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExecuteLookahead(t *testing.T) {
+	s, lookahead := testExecutor(t)
+
+	s.execute(context.Background(), `{ user(id: "1") { friends { name } } }`, "", nil)
+	if len(*lookahead) != 0 {
+		t.Fatalf("got lookahead %v without selecting friends.email", *lookahead)
+	}
+
+	s.execute(context.Background(), `{ user(id: "1") { ...f } } fragment f on User { friends { email } }`, "", nil)
+	if len(*lookahead) != 1 {
+		t.Fatalf("got lookahead %v, want friends.email", *lookahead)
+	}
+}
+
+func TestExecuteMaxFields(t *testing.T) {
+	s, _ := testExecutor(t)
+
+	// Every level doubles the number of fields.
+	q := `{ user(id: "1") { ...f11 } } fragment f0 on User { name }`
+	for i := 1; i < 12; i++ {
+		q += " fragment f" + itoa(i) + " on User { friends { ...f" + itoa(i-1) + " } a: friends { ...f" + itoa(i-1) + " } }"
+	}
+	resp := s.execute(context.Background(), q, "", nil)
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "more than") {
+		t.Fatalf("got %+v, want error about too many fields", resp.Errors)
+	}
+}
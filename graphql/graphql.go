@@ -0,0 +1,472 @@
+// Package graphql serves a GraphQL API for searching, listing repositories
+// and fetching files. Clients select exactly the fields they render, and the
+// resolvers only fetch what is selected: the contents of whole files are only
+// loaded if a query selects them.
+//
+// The package implements the subset of GraphQL the schema needs: queries
+// with variables, aliases, fragments and the @skip and @include directives.
+// It doesn't support introspection; tools can use the schema served at
+// /graphql/schema.graphql instead.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp/syntax"
+	"sort"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	zjson "github.com/sourcegraph/zoekt/json"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Schema is the GraphQL schema of the API, in the schema definition language.
+const Schema = `"""
+Searches and browses the repositories indexed by zoekt.
+"""
+type Query {
+  "Searches for query, in zoekt query syntax."
+  search(query: String!, options: SearchOptions): SearchResult!
+  "Lists the repositories matching query, all repositories by default."
+  repositories(query: String = "", first: Int = 0, offset: Int = 0): [Repository!]!
+  "Returns the file at path in repository, or null if there is no such file."
+  file(repository: String!, path: String!, branch: String): File
+}
+
+input SearchOptions {
+  maxDocDisplayCount: Int = 0
+  maxMatchDisplayCount: Int = 0
+  shardMaxMatchCount: Int = 0
+  totalMaxMatchCount: Int = 0
+  numContextLines: Int = 0
+  chunkMatches: Boolean = false
+  useBM25Scoring: Boolean = false
+  "Aborts the search after this duration, like \"10s\". The default is 20s."
+  maxWallTime: String
+}
+
+type SearchResult {
+  stats: Stats!
+  files: [FileMatch!]!
+}
+
+type Stats {
+  fileCount: Int!
+  matchCount: Int!
+  filesConsidered: Int!
+  filesLoaded: Int!
+  filesSkipped: Int!
+  shardsScanned: Int!
+  shardsSkipped: Int!
+  "The time spent searching in milliseconds."
+  durationMs: Float!
+}
+
+type FileMatch {
+  repository: String!
+  fileName: String!
+  branches: [String!]!
+  version: String!
+  language: String!
+  score: Float!
+  "The content of the whole file. Selecting it loads every matching file."
+  content: String
+  lineMatches: [LineMatch!]!
+  chunkMatches: [ChunkMatch!]!
+}
+
+type LineMatch {
+  lineNumber: Int!
+  line: String!
+  before: String
+  after: String
+  fileName: Boolean!
+  fragments: [LineFragment!]!
+  "The syntax highlighting of line. Selecting it highlights every match."
+  tokens: [HighlightToken!]!
+}
+
+type LineFragment {
+  "The byte offset of the match in the line."
+  offset: Int!
+  length: Int!
+}
+
+type ChunkMatch {
+  content: String!
+  contentStart: Location!
+  fileName: Boolean!
+  ranges: [Range!]!
+  "The syntax highlighting of content. Selecting it highlights every match."
+  tokens: [HighlightToken!]!
+}
+
+type HighlightToken {
+  "The byte offset of the token."
+  start: Int!
+  "The byte offset after the token."
+  end: Int!
+  "The Pygments CSS class of the token, like \"k\" for keywords."
+  class: String!
+}
+
+type Range {
+  start: Location!
+  end: Location!
+}
+
+type Location {
+  byteOffset: Int!
+  lineNumber: Int!
+  column: Int!
+}
+
+type Repository {
+  id: Int!
+  name: String!
+  url: String!
+  branches: [Branch!]!
+  latestCommitDate: String
+  indexTime: String
+  documents: Int!
+  contentBytes: Int!
+  "The files whose path matches the regular expression path, at most first."
+  files(path: String = "", branch: String, first: Int = 100): [File!]!
+}
+
+type Branch {
+  name: String!
+  version: String!
+}
+
+type File {
+  repository: String!
+  path: String!
+  branches: [String!]!
+  version: String!
+  language: String!
+  "The content of the file. Selecting it loads the file."
+  content: String
+}
+`
+
+// defaultTimeout is the timeout of searches without maxWallTime, like in
+// the JSON API.
+const defaultTimeout = 20 * time.Second
+
+// Handler returns the handler of GraphQL requests. It accepts POST requests
+// with a JSON body and GET requests with the query in the URL.
+func Handler(searcher zoekt.Searcher) http.Handler {
+	r := &resolver{searcher: searcher}
+	s, err := newSchema(Schema, map[string]resolveFunc{
+		"Query.search":       r.search,
+		"Query.repositories": r.repositories,
+		"Query.file":         r.file,
+		"Repository.files":   r.repositoryFiles,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &handler{schema: s}
+}
+
+// ServeSchema serves Schema, eg. for code generators.
+func ServeSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(Schema))
+}
+
+type handler struct {
+	schema *schema
+}
+
+type request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req request
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeResponse(w, http.StatusBadRequest, requestError(fmt.Errorf("variables: %w", err)))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeResponse(w, http.StatusBadRequest, requestError(err))
+			return
+		}
+	default:
+		writeResponse(w, http.StatusMethodNotAllowed, requestError(fmt.Errorf("method %s is not supported", r.Method)))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, h.schema.execute(r.Context(), req.Query, req.OperationName, req.Variables))
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *response) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+type resolver struct {
+	searcher zoekt.Searcher
+}
+
+func (r *resolver) search(ctx context.Context, p *params) (any, error) {
+	q, err := query.Parse(p.args["query"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &zoekt.SearchOptions{}
+	if o, ok := p.args["options"].(map[string]any); ok {
+		opts.MaxDocDisplayCount = o["maxDocDisplayCount"].(int)
+		opts.MaxMatchDisplayCount = o["maxMatchDisplayCount"].(int)
+		opts.ShardMaxMatchCount = o["shardMaxMatchCount"].(int)
+		opts.TotalMaxMatchCount = o["totalMaxMatchCount"].(int)
+		opts.NumContextLines = o["numContextLines"].(int)
+		opts.ChunkMatches = o["chunkMatches"].(bool)
+		opts.UseBM25Scoring = o["useBM25Scoring"].(bool)
+		if d, ok := o["maxWallTime"].(string); ok {
+			if opts.MaxWallTime, err = time.ParseDuration(d); err != nil {
+				return nil, fmt.Errorf("maxWallTime: %w", err)
+			}
+		}
+	}
+	opts.Whole = p.selects("files", "content")
+	opts.Highlight = p.selects("files", "lineMatches", "tokens") || p.selects("files", "chunkMatches", "tokens")
+
+	if opts.MaxWallTime == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	if err := zjson.CalculateDefaultSearchLimits(ctx, q, r.searcher, opts); err != nil {
+		return nil, err
+	}
+
+	result, err := r.searcher.Search(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]map[string]any, 0, len(result.Files))
+	for i := range result.Files {
+		files = append(files, fileMatch(&result.Files[i]))
+	}
+	return map[string]any{
+		"stats": map[string]any{
+			"fileCount":       result.Stats.FileCount,
+			"matchCount":      result.Stats.MatchCount,
+			"filesConsidered": result.Stats.FilesConsidered,
+			"filesLoaded":     result.Stats.FilesLoaded,
+			"filesSkipped":    result.Stats.FilesSkipped,
+			"shardsScanned":   result.Stats.ShardsScanned,
+			"shardsSkipped":   result.Stats.ShardsSkipped,
+			"durationMs":      float64(result.Stats.Duration.Microseconds()) / 1000,
+		},
+		"files": files,
+	}, nil
+}
+
+func (r *resolver) repositories(ctx context.Context, p *params) (any, error) {
+	q, err := query.Parse(p.args["query"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := r.searcher.List(ctx, q, &zoekt.ListOptions{
+		Sort:    zoekt.RepoListSortName,
+		Offset:  p.args["offset"].(int),
+		Limit:   p.args["first"].(int),
+		Minimal: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]map[string]any, 0, len(list.Repos))
+	for _, e := range list.Repos {
+		branches := make([]map[string]any, 0, len(e.Repository.Branches))
+		for _, b := range e.Repository.Branches {
+			branches = append(branches, map[string]any{"name": b.Name, "version": b.Version})
+		}
+		repo := map[string]any{
+			"id":           e.Repository.ID,
+			"name":         e.Repository.Name,
+			"url":          e.Repository.URL,
+			"branches":     branches,
+			"documents":    e.Stats.Documents,
+			"contentBytes": e.Stats.ContentBytes,
+		}
+		if !e.Repository.LatestCommitDate.IsZero() {
+			repo["latestCommitDate"] = e.Repository.LatestCommitDate
+		}
+		if !e.IndexMetadata.IndexTime.IsZero() {
+			repo["indexTime"] = e.IndexMetadata.IndexTime
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+func (r *resolver) file(ctx context.Context, p *params) (any, error) {
+	path := "^" + regexp.QuoteMeta(p.args["path"].(string)) + "$"
+	branch, _ := p.args["branch"].(string)
+	files, err := r.files(ctx, p.args["repository"].(string), path, branch, 0, p.selects("content"))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	// A file whose content differs between branches is one document per
+	// version. Without a branch, we prefer the version on HEAD.
+	for _, f := range files {
+		for _, b := range f["branches"].([]string) {
+			if b == "HEAD" {
+				return f, nil
+			}
+		}
+	}
+	return files[0], nil
+}
+
+func (r *resolver) repositoryFiles(ctx context.Context, p *params) (any, error) {
+	repo := p.source.(map[string]any)["name"].(string)
+	branch, _ := p.args["branch"].(string)
+	first := p.args["first"].(int)
+	if first <= 0 {
+		return []map[string]any{}, nil
+	}
+	return r.files(ctx, repo, p.args["path"].(string), branch, first, p.selects("content"))
+}
+
+// files returns up to limit files of repo whose path matches the regular
+// expression path, sorted by path.
+func (r *resolver) files(ctx context.Context, repo, path, branch string, limit int, content bool) ([]map[string]any, error) {
+	qs := []query.Q{&query.Repo{Regexp: regexp.MustCompile("^" + regexp.QuoteMeta(repo) + "$")}}
+	if path != "" {
+		// Use the flags of file: in zoekt queries.
+		re, err := syntax.Parse(path, syntax.ClassNL|syntax.PerlX|syntax.UnicodeGroups)
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, &query.Regexp{Regexp: re, FileName: true, CaseSensitive: true})
+	}
+	if branch != "" {
+		qs = append(qs, &query.Branch{Pattern: branch, Exact: true})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	result, err := r.searcher.Search(ctx, query.NewAnd(qs...), &zoekt.SearchOptions{
+		Whole:              content,
+		MaxDocDisplayCount: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result.Files, func(i, j int) bool {
+		return result.Files[i].FileName < result.Files[j].FileName
+	})
+
+	files := make([]map[string]any, 0, len(result.Files))
+	for i := range result.Files {
+		f := &result.Files[i]
+		file := map[string]any{
+			"repository": f.Repository,
+			"path":       f.FileName,
+			"branches":   f.Branches,
+			"version":    f.Version,
+			"language":   f.Language,
+		}
+		if content {
+			file["content"] = f.Content
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func highlightTokens(tokens []zoekt.HighlightToken) []map[string]any {
+	ms := make([]map[string]any, 0, len(tokens))
+	for _, t := range tokens {
+		ms = append(ms, map[string]any{"start": t.Start, "end": t.End, "class": t.Class})
+	}
+	return ms
+}
+
+func fileMatch(f *zoekt.FileMatch) map[string]any {
+	lineMatches := make([]map[string]any, 0, len(f.LineMatches))
+	for _, lm := range f.LineMatches {
+		fragments := make([]map[string]any, 0, len(lm.LineFragments))
+		for _, lf := range lm.LineFragments {
+			fragments = append(fragments, map[string]any{"offset": lf.LineOffset, "length": lf.MatchLength})
+		}
+		m := map[string]any{
+			"lineNumber": lm.LineNumber,
+			"line":       lm.Line,
+			"fileName":   lm.FileName,
+			"fragments":  fragments,
+			"tokens":     highlightTokens(lm.Tokens),
+		}
+		if lm.Before != nil {
+			m["before"] = lm.Before
+		}
+		if lm.After != nil {
+			m["after"] = lm.After
+		}
+		lineMatches = append(lineMatches, m)
+	}
+
+	chunkMatches := make([]map[string]any, 0, len(f.ChunkMatches))
+	for _, cm := range f.ChunkMatches {
+		ranges := make([]map[string]any, 0, len(cm.Ranges))
+		for _, r := range cm.Ranges {
+			ranges = append(ranges, map[string]any{"start": location(r.Start), "end": location(r.End)})
+		}
+		chunkMatches = append(chunkMatches, map[string]any{
+			"content":      cm.Content,
+			"contentStart": location(cm.ContentStart),
+			"fileName":     cm.FileName,
+			"ranges":       ranges,
+			"tokens":       highlightTokens(cm.Tokens),
+		})
+	}
+
+	m := map[string]any{
+		"repository":   f.Repository,
+		"fileName":     f.FileName,
+		"branches":     f.Branches,
+		"version":      f.Version,
+		"language":     f.Language,
+		"score":        f.Score,
+		"lineMatches":  lineMatches,
+		"chunkMatches": chunkMatches,
+	}
+	if f.Content != nil {
+		m["content"] = f.Content
+	}
+	return m
+}
+
+func location(l zoekt.Location) map[string]any {
+	return map[string]any{"byteOffset": l.ByteOffset, "lineNumber": l.LineNumber, "column": l.Column}
+}
@@ -0,0 +1,168 @@
+package graphql_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/graphql"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// fakeSearcher returns the same results for every query, and records the
+// options of the last search.
+type fakeSearcher struct {
+	result *zoekt.SearchResult
+	list   *zoekt.RepoList
+
+	lastQuery query.Q
+	lastOpts  *zoekt.SearchOptions
+}
+
+func (s *fakeSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.lastQuery, s.lastOpts = q, opts
+	result := *s.result
+	result.Files = append([]zoekt.FileMatch{}, s.result.Files...)
+	if !opts.Whole {
+		for i := range result.Files {
+			result.Files[i].Content = nil
+		}
+	}
+	return &result, nil
+}
+
+func (s *fakeSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	s.lastQuery = q
+	return s.list, nil
+}
+
+func (*fakeSearcher) Close()         {}
+func (*fakeSearcher) String() string { return "fakeSearcher" }
+
+func TestHandler(t *testing.T) {
+	searcher := &fakeSearcher{
+		result: &zoekt.SearchResult{
+			Stats: zoekt.Stats{FileCount: 1, MatchCount: 1},
+			Files: []zoekt.FileMatch{{
+				Repository: "github.com/foo/bar",
+				FileName:   "main.go",
+				Branches:   []string{"HEAD"},
+				Content:    []byte("package main\n"),
+				LineMatches: []zoekt.LineMatch{{
+					Line:          []byte("package main"),
+					LineNumber:    1,
+					LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 8, MatchLength: 4}},
+					Tokens:        []zoekt.HighlightToken{{Start: 0, End: 7, Class: "kn"}},
+				}},
+			}},
+		},
+		list: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{
+				Repository: zoekt.Repository{
+					ID:       7,
+					Name:     "github.com/foo/bar",
+					Branches: []zoekt.RepositoryBranch{{Name: "HEAD", Version: "abc"}},
+				},
+			}},
+		},
+	}
+
+	ts := httptest.NewServer(graphql.Handler(searcher))
+	defer ts.Close()
+
+	post := func(body any) string {
+		t.Helper()
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	got := post(map[string]any{
+		"query":     `query($q: String!) { search(query: $q, options: {chunkMatches: true}) { stats { fileCount } files { fileName lineMatches { lineNumber fragments { offset length } } } } }`,
+		"variables": map[string]any{"q": "main"},
+	})
+	want := `{"data":{"search":{"stats":{"fileCount":1},"files":[{"fileName":"main.go","lineMatches":[{"lineNumber":1,"fragments":[{"offset":8,"length":4}]}]}]}}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if searcher.lastOpts.Whole || !searcher.lastOpts.ChunkMatches || searcher.lastOpts.Highlight {
+		t.Fatalf("unexpected options %s", searcher.lastOpts)
+	}
+
+	// Selecting tokens highlights the matches.
+	got = post(map[string]any{"query": `{ search(query: "main") { files { lineMatches { tokens { start end class } } } } }`})
+	want = `{"data":{"search":{"files":[{"lineMatches":[{"tokens":[{"start":0,"end":7,"class":"kn"}]}]}]}}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if !searcher.lastOpts.Highlight {
+		t.Fatalf("expected highlighted search, got %s", searcher.lastOpts)
+	}
+
+	// Selecting the content loads whole files.
+	got = post(map[string]any{"query": `{ search(query: "main") { files { content } } }`})
+	want = `{"data":{"search":{"files":[{"content":"package main\n"}]}}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if !searcher.lastOpts.Whole {
+		t.Fatalf("expected Whole search, got %s", searcher.lastOpts)
+	}
+
+	got = post(map[string]any{"query": `{ repositories { id name branches { name version } files { path } } }`})
+	want = `{"data":{"repositories":[{"id":7,"name":"github.com/foo/bar","branches":[{"name":"HEAD","version":"abc"}],"files":[{"path":"main.go"}]}]}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+
+	got = post(map[string]any{"query": `{ file(repository: "github.com/foo/bar", path: "main.go") { path branches content } }`})
+	want = `{"data":{"file":{"path":"main.go","branches":["HEAD"],"content":"package main\n"}}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if want := `(and repo:^github\.com/foo/bar$ case_file_regex:"(?m:^)main\\.go(?m:$)")`; searcher.lastQuery.String() != want {
+		t.Fatalf("got query %s, want %s", searcher.lastQuery, want)
+	}
+
+	got = post(map[string]any{"query": `{ search(query: "(") { stats { fileCount } } }`})
+	var resp struct {
+		Data   map[string]any
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal([]byte(got), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data != nil || len(resp.Errors) != 1 {
+		t.Fatalf("expected an error for an invalid zoekt query, got %s", got)
+	}
+
+	// GET requests work as well.
+	r, err := http.Get(ts.URL + "?query=" + url.QueryEscape(`{ repositories(first: 1) { name } }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	var getResp json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&getResp); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"data":{"repositories":[{"name":"github.com/foo/bar"}]}}`; string(getResp) != want {
+		t.Fatalf("got  %s\nwant %s", getResp, want)
+	}
+}
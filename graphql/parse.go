@@ -0,0 +1,606 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file parses the subset of GraphQL documents we need: operations and
+// fragments of executable documents, and the object, input and scalar
+// definitions of the schema. It doesn't support interfaces, unions, enums,
+// subscriptions or schema extensions.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+
+	// Type system definitions, only in the schema.
+	types map[string]*typeDef
+}
+
+type operation struct {
+	kind       string // "query", "mutation" or "subscription"
+	name       string
+	vars       []*inputValue
+	directives []*directive
+	sel        []selection
+}
+
+type fragment struct {
+	name     string
+	typeCond string
+	sel      []selection
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment.
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	sel        []selection
+}
+
+// responseKey is the key of the field in the response.
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	typeCond   string
+	directives []*directive
+	sel        []selection
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+type argument struct {
+	name string
+	val  value
+}
+
+// value is a literal: nil, bool, int, float64, string, enumValue, variable,
+// []value or objectValue.
+type value = any
+
+type (
+	enumValue   string
+	variable    string
+	objectValue map[string]value
+)
+
+// typeRef is a reference to a type, like "[String!]".
+type typeRef struct {
+	name    string   // for named types
+	elem    *typeRef // for list types
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// inputValue is an argument of a field, a field of an input object or a
+// variable definition.
+type inputValue struct {
+	name string
+	typ  *typeRef
+
+	// def is the default value, if hasDef.
+	def    value
+	hasDef bool
+}
+
+type typeDef struct {
+	kind string // "type", "input" or "scalar"
+	name string
+
+	// fields are the fields of object types.
+	fields map[string]*fieldDef
+
+	// inputFields are the fields of input types.
+	inputFields map[string]*inputValue
+}
+
+type fieldDef struct {
+	name string
+	args map[string]*inputValue
+	typ  *typeRef
+}
+
+// syntaxError is returned for documents which can't be parsed.
+type syntaxError struct {
+	line, col int
+	msg       string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.line, e.col, e.msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// parse parses a GraphQL document.
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, se
+		}
+	}()
+	p.next()
+	return p.document(), nil
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) {
+	line, col := 1, 1
+	for _, c := range p.src[:pos] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	panic(&syntaxError{line: line, col: col, msg: fmt.Sprintf(format, args...)})
+}
+
+// next advances to the next token, skipping whitespace, commas and comments.
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokPunct, val: "...", pos: start}
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokPunct, val: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, val: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		p.number()
+	case strings.HasPrefix(p.src[p.pos:], `"""`):
+		p.blockString()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.errorf(start, "unexpected character %q", r)
+	}
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+func (p *parser) number() {
+	start := p.pos
+	kind := tokInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		n := p.pos
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == n {
+			p.errorf(p.pos, "expected digit")
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok = token{kind: kind, val: p.src[start:p.pos], pos: start}
+}
+
+func (p *parser) string() {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.errorf(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.errorf(start, "unterminated string")
+		}
+		esc := p.src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.errorf(p.pos, "invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.errorf(p.pos, "invalid unicode escape")
+			}
+			b.WriteRune(rune(r))
+			p.pos += 4
+		default:
+			p.errorf(p.pos-2, "invalid escape \\%c", esc)
+		}
+	}
+	p.tok = token{kind: tokString, val: b.String(), pos: start}
+}
+
+// blockString parses a """block string""". Unlike the spec, it doesn't
+// remove the common indentation, since we only use block strings for
+// descriptions.
+func (p *parser) blockString() {
+	start := p.pos
+	p.pos += 3
+	end := strings.Index(p.src[p.pos:], `"""`)
+	if end < 0 {
+		p.errorf(start, "unterminated block string")
+	}
+	val := p.src[p.pos : p.pos+end]
+	p.pos += end + 3
+	p.tok = token{kind: tokString, val: strings.TrimSpace(val), pos: start}
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) skip(punct string) bool {
+	if p.peek(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.errorf(p.tok.pos, "expected %q, got %s", punct, p.describe())
+	}
+}
+
+func (p *parser) describe() string {
+	if p.tok.kind == tokEOF {
+		return "end of document"
+	}
+	return strconv.Quote(p.src[p.tok.pos:p.pos])
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.errorf(p.tok.pos, "expected name, got %s", p.describe())
+	}
+	n := p.tok.val
+	p.next()
+	return n
+}
+
+func (p *parser) document() *document {
+	doc := &document{fragments: map[string]*fragment{}, types: map[string]*typeDef{}}
+	for p.tok.kind != tokEOF {
+		// Descriptions only precede type system definitions.
+		if p.tok.kind == tokString {
+			p.next()
+		}
+		if p.peek("{") {
+			doc.operations = append(doc.operations, &operation{kind: "query", sel: p.selectionSet()})
+			continue
+		}
+		pos := p.tok.pos
+		switch kw := p.name(); kw {
+		case "query", "mutation", "subscription":
+			op := &operation{kind: kw}
+			if p.tok.kind == tokName {
+				op.name = p.name()
+			}
+			if p.skip("(") {
+				for !p.skip(")") {
+					op.vars = append(op.vars, p.inputValue(true))
+				}
+			}
+			op.directives = p.directives()
+			op.sel = p.selectionSet()
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			f := &fragment{name: p.name()}
+			if n := p.name(); n != "on" {
+				p.errorf(pos, "expected \"on\" in fragment %s", f.name)
+			}
+			f.typeCond = p.name()
+			p.directives()
+			f.sel = p.selectionSet()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.errorf(pos, "fragment %s defined twice", f.name)
+			}
+			doc.fragments[f.name] = f
+		case "type", "input", "scalar":
+			t := p.typeDef(kw)
+			if _, ok := doc.types[t.name]; ok {
+				p.errorf(pos, "type %s defined twice", t.name)
+			}
+			doc.types[t.name] = t
+		default:
+			p.errorf(pos, "unexpected %q", kw)
+		}
+	}
+	return doc
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	var sel []selection
+	for !p.skip("}") {
+		if p.skip("...") {
+			if p.tok.kind == tokName && p.tok.val != "on" {
+				sel = append(sel, &fragmentSpread{name: p.name(), directives: p.directives()})
+				continue
+			}
+			f := &inlineFragment{}
+			if p.tok.kind == tokName {
+				p.next() // "on"
+				f.typeCond = p.name()
+			}
+			f.directives = p.directives()
+			f.sel = p.selectionSet()
+			sel = append(sel, f)
+			continue
+		}
+
+		f := &field{name: p.name()}
+		if p.skip(":") {
+			f.alias, f.name = f.name, p.name()
+		}
+		f.args = p.arguments(false)
+		f.directives = p.directives()
+		if p.peek("{") {
+			f.sel = p.selectionSet()
+		}
+		sel = append(sel, f)
+	}
+	if len(sel) == 0 {
+		p.errorf(p.tok.pos, "empty selection set")
+	}
+	return sel
+}
+
+func (p *parser) arguments(constant bool) []*argument {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []*argument
+	for !p.skip(")") {
+		a := &argument{name: p.name()}
+		p.expect(":")
+		a.val = p.value(constant)
+		args = append(args, a)
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var ds []*directive
+	for p.skip("@") {
+		ds = append(ds, &directive{name: p.name(), args: p.arguments(false)})
+	}
+	return ds
+}
+
+// inputValue parses a variable definition if isVar, and an argument or
+// input field definition otherwise.
+func (p *parser) inputValue(isVar bool) *inputValue {
+	if p.tok.kind == tokString {
+		p.next() // description
+	}
+	if isVar {
+		p.expect("$")
+	}
+	iv := &inputValue{name: p.name()}
+	p.expect(":")
+	iv.typ = p.typeRef()
+	if p.skip("=") {
+		iv.def, iv.hasDef = p.value(true), true
+	}
+	p.directives()
+	return iv
+}
+
+func (p *parser) typeRef() *typeRef {
+	var t *typeRef
+	if p.skip("[") {
+		t = &typeRef{elem: p.typeRef()}
+		p.expect("]")
+	} else {
+		t = &typeRef{name: p.name()}
+	}
+	t.nonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) value(constant bool) value {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		p.next()
+		n, err := strconv.Atoi(tok.val)
+		if err != nil {
+			p.errorf(tok.pos, "invalid integer %s", tok.val)
+		}
+		return n
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			p.errorf(tok.pos, "invalid float %s", tok.val)
+		}
+		return f
+	case tokString:
+		p.next()
+		return tok.val
+	case tokName:
+		p.next()
+		switch tok.val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(tok.val)
+	}
+
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.errorf(tok.pos, "unexpected variable")
+		}
+		return variable(p.name())
+	case p.skip("["):
+		list := []value{}
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		obj := objectValue{}
+		for !p.skip("}") {
+			n := p.name()
+			p.expect(":")
+			obj[n] = p.value(constant)
+		}
+		return obj
+	}
+	p.errorf(tok.pos, "expected value, got %s", p.describe())
+	return nil
+}
+
+func (p *parser) typeDef(kind string) *typeDef {
+	t := &typeDef{kind: kind, name: p.name(), fields: map[string]*fieldDef{}, inputFields: map[string]*inputValue{}}
+	p.directives()
+	if kind == "scalar" || !p.skip("{") {
+		return t
+	}
+	for !p.skip("}") {
+		if p.tok.kind == tokString {
+			p.next() // description
+		}
+		if kind == "input" {
+			iv := p.inputValue(false)
+			t.inputFields[iv.name] = iv
+			continue
+		}
+		fd := &fieldDef{name: p.name(), args: map[string]*inputValue{}}
+		if p.skip("(") {
+			for !p.skip(")") {
+				iv := p.inputValue(false)
+				fd.args[iv.name] = iv
+			}
+		}
+		p.expect(":")
+		fd.typ = p.typeRef()
+		p.directives()
+		t.fields[fd.name] = fd
+	}
+	return t
+}
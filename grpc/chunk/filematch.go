@@ -0,0 +1,110 @@
+package chunk
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	webserverv1 "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+// fileMatchOverhead is the room left in a message besides a part of a file
+// match, for the stats and progress of the response.
+const fileMatchOverhead = 4 * 1024
+
+// SplitFileMatch splits a file match which doesn't fit into a message of a
+// Chunker into parts which do, see the continued field of FileMatch. File
+// matches which fit are returned as is. A single line or chunk match is
+// never split, so a part may still exceed the size if one of them does.
+func SplitFileMatch(fm *webserverv1.FileMatch) []*webserverv1.FileMatch {
+	return splitFileMatch(fm, maxMessageSize-fileMatchOverhead)
+}
+
+func splitFileMatch(fm *webserverv1.FileMatch, maxSize int) []*webserverv1.FileMatch {
+	if proto.Size(fm) <= maxSize {
+		return []*webserverv1.FileMatch{fm}
+	}
+
+	// The first part carries all fields but the matches and the content.
+	head := proto.Clone(fm).(*webserverv1.FileMatch)
+	head.LineMatches, head.ChunkMatches, head.Content = nil, nil, nil
+
+	// Room for continued.
+	maxSize -= protowire.SizeTag(19) + 1
+
+	parts := []*webserverv1.FileMatch{head}
+	cur, size := head, proto.Size(head)
+	next := func() {
+		cur.Continued = true
+		cur = &webserverv1.FileMatch{}
+		parts = append(parts, cur)
+		size = 0
+	}
+
+	// fieldSize is the encoded size of a field of n bytes.
+	fieldSize := func(num protowire.Number, n int) int {
+		return protowire.SizeTag(num) + protowire.SizeBytes(n)
+	}
+
+	for _, lm := range fm.GetLineMatches() {
+		n := fieldSize(6, proto.Size(lm))
+		if size > 0 && size+n > maxSize {
+			next()
+		}
+		cur.LineMatches = append(cur.LineMatches, lm)
+		size += n
+	}
+	for _, cm := range fm.GetChunkMatches() {
+		n := fieldSize(7, proto.Size(cm))
+		if size > 0 && size+n > maxSize {
+			next()
+		}
+		cur.ChunkMatches = append(cur.ChunkMatches, cm)
+		size += n
+	}
+
+	content := fm.GetContent()
+	for len(content) > 0 {
+		// Overestimate the length prefix, which takes at most 5 bytes
+		// for a message.
+		room := maxSize - size - protowire.SizeTag(10) - 5
+		if room <= 0 {
+			next()
+			continue
+		}
+		n := min(room, len(content))
+		cur.Content = content[:n:n]
+		size += fieldSize(10, n)
+		content = content[n:]
+	}
+
+	return parts
+}
+
+// FileMatchJoiner joins the parts of file matches split by SplitFileMatch.
+// Parts may span several responses, so a stream needs a single
+// FileMatchJoiner for all its responses.
+type FileMatchJoiner struct {
+	// pending is the file match whose next part is still to come.
+	pending *webserverv1.FileMatch
+}
+
+// Join returns files with the parts of split file matches joined. If the
+// last of files is continued, it is kept until the part which ends it.
+func (j *FileMatchJoiner) Join(files []*webserverv1.FileMatch) []*webserverv1.FileMatch {
+	joined := files[:0:0]
+	for _, f := range files {
+		if p := j.pending; p != nil {
+			p.LineMatches = append(p.LineMatches, f.GetLineMatches()...)
+			p.ChunkMatches = append(p.ChunkMatches, f.GetChunkMatches()...)
+			p.Content = append(p.Content, f.GetContent()...)
+			p.Continued = f.GetContinued()
+			f, j.pending = p, nil
+		}
+		if f.GetContinued() {
+			j.pending = f
+			continue
+		}
+		joined = append(joined, f)
+	}
+	return joined
+}
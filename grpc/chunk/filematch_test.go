@@ -0,0 +1,66 @@
+package chunk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	webserverv1 "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+func TestSplitFileMatch(t *testing.T) {
+	const maxSize = 200
+
+	small := &webserverv1.FileMatch{FileName: []byte("small.go"), Repository: "foo/bar"}
+	if parts := splitFileMatch(small, maxSize); len(parts) != 1 || parts[0] != small {
+		t.Fatalf("got %d parts for a small file match, want itself", len(parts))
+	}
+
+	var lineMatches []*webserverv1.LineMatch
+	for i := 0; i < 10; i++ {
+		lineMatches = append(lineMatches, &webserverv1.LineMatch{Line: bytes.Repeat([]byte("l"), 50), LineNumber: int64(i + 1)})
+	}
+	huge := &webserverv1.FileMatch{
+		FileName:    []byte("huge.go"),
+		Repository:  "foo/bar",
+		Branches:    []string{"main"},
+		LineMatches: lineMatches,
+		ChunkMatches: []*webserverv1.ChunkMatch{
+			{Content: bytes.Repeat([]byte("c"), 500)}, // larger than a part
+			{Content: bytes.Repeat([]byte("d"), 20)},
+		},
+		Content: bytes.Repeat([]byte("x"), 1000),
+	}
+	want := proto.Clone(huge)
+
+	parts := splitFileMatch(huge, maxSize)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want several", len(parts))
+	}
+	if !proto.Equal(huge, want) {
+		t.Fatal("splitFileMatch modified its input")
+	}
+	for i, p := range parts {
+		if got, want := p.GetContinued(), i < len(parts)-1; got != want {
+			t.Errorf("part %d: got continued %v, want %v", i, got, want)
+		}
+		// Only the oversized chunk match may exceed the size.
+		if size := proto.Size(p); size > maxSize && len(p.GetChunkMatches()) != 1 {
+			t.Errorf("part %d has %d bytes, want at most %d", i, size, maxSize)
+		}
+	}
+
+	// Parts can span several responses.
+	var j FileMatchJoiner
+	var got []*webserverv1.FileMatch
+	got = append(got, j.Join([]*webserverv1.FileMatch{small, parts[0]})...)
+	for _, p := range parts[1:] {
+		got = append(got, j.Join([]*webserverv1.FileMatch{p})...)
+	}
+	if d := cmp.Diff([]*webserverv1.FileMatch{small, want.(*webserverv1.FileMatch)}, got, protocmp.Transform()); d != "" {
+		t.Fatalf("-want +got:\n%s", d)
+	}
+}
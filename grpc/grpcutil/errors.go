@@ -0,0 +1,106 @@
+package grpcutil
+
+import (
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details which carry the reason
+// of a zoekt.SearchError.
+const ErrorDomain = "zoekt.webserver.v1"
+
+// SearchErrorStatus returns err as a status if it wraps a *zoekt.SearchError.
+// The status has the code matching its reason and an ErrorInfo detail whose
+// reason is the name of the FlushReason enum value, eg.
+// "FLUSH_REASON_TIMEOUT". A *zoekt.RateLimitError becomes RESOURCE_EXHAUSTED
+// with the reason zoekt.RateLimitReason and a RetryInfo detail. Other errors
+// are returned unchanged.
+func SearchErrorStatus(err error) error {
+	var rateLimitErr *zoekt.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitStatus(err, rateLimitErr)
+	}
+
+	var searchErr *zoekt.SearchError
+	if !errors.As(err, &searchErr) {
+		return err
+	}
+
+	code := codes.Internal
+	switch searchErr.Reason {
+	case zoekt.FlushReasonTimeout:
+		code = codes.DeadlineExceeded
+	case zoekt.FlushReasonShardUnavailable:
+		code = codes.Unavailable
+	case zoekt.FlushReasonBudgetExceeded, zoekt.FlushReasonMemoryExceeded, zoekt.FlushReasonMatchLimit:
+		code = codes.ResourceExhausted
+	}
+
+	s, detailsErr := status.New(code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: searchErr.Reason.ToProto().String(),
+		Domain: ErrorDomain,
+	})
+	if detailsErr != nil {
+		return status.Error(code, err.Error())
+	}
+	return s.Err()
+}
+
+func rateLimitStatus(err error, rateLimitErr *zoekt.RateLimitError) error {
+	s, detailsErr := status.New(codes.ResourceExhausted, err.Error()).WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   zoekt.RateLimitReason,
+			Domain:   ErrorDomain,
+			Metadata: map[string]string{"class": rateLimitErr.Class},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(rateLimitErr.RetryAfter)},
+	)
+	if detailsErr != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return s.Err()
+}
+
+// SearchErrorFromStatus is the inverse of SearchErrorStatus. It returns a
+// *zoekt.SearchError wrapping err if err is a status with a reason, a
+// *zoekt.RateLimitError if it is rate limited, and err otherwise.
+func SearchErrorFromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	var (
+		info  *errdetails.ErrorInfo
+		retry *errdetails.RetryInfo
+	)
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.GetDomain() == ErrorDomain {
+				info = d
+			}
+		case *errdetails.RetryInfo:
+			retry = d
+		}
+	}
+	if info == nil {
+		return err
+	}
+	if info.GetReason() == zoekt.RateLimitReason {
+		return &zoekt.RateLimitError{
+			Class:      info.GetMetadata()["class"],
+			RetryAfter: retry.GetRetryDelay().AsDuration(),
+		}
+	}
+	if reason := zoekt.FlushReasonFromProtoName(info.GetReason()); reason != 0 {
+		return &zoekt.SearchError{Reason: reason, Err: err}
+	}
+	return err
+}
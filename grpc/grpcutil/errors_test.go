@@ -0,0 +1,63 @@
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestSearchErrorStatus(t *testing.T) {
+	testCases := []struct {
+		reason   zoekt.FlushReason
+		wantCode codes.Code
+	}{
+		{reason: zoekt.FlushReasonTimeout, wantCode: codes.DeadlineExceeded},
+		{reason: zoekt.FlushReasonShardUnavailable, wantCode: codes.Unavailable},
+		{reason: zoekt.FlushReasonBudgetExceeded, wantCode: codes.ResourceExhausted},
+		{reason: zoekt.FlushReasonMatchLimit, wantCode: codes.ResourceExhausted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.reason.String(), func(t *testing.T) {
+			err := fmt.Errorf("search: %w", &zoekt.SearchError{Reason: tc.reason, Err: context.DeadlineExceeded})
+
+			s := status.Convert(SearchErrorStatus(err))
+			if s.Code() != tc.wantCode {
+				t.Errorf("got code %s, want %s", s.Code(), tc.wantCode)
+			}
+
+			var searchErr *zoekt.SearchError
+			if !errors.As(SearchErrorFromStatus(s.Err()), &searchErr) || searchErr.Reason != tc.reason {
+				t.Errorf("got %v back from the status, want a search error with reason %s", searchErr, tc.reason)
+			}
+		})
+	}
+
+	// Rate limited searches carry when to retry.
+	err := fmt.Errorf("search: %w", &zoekt.RateLimitError{Class: "batch", RetryAfter: 3 * time.Second})
+	s := status.Convert(SearchErrorStatus(err))
+	if s.Code() != codes.ResourceExhausted {
+		t.Errorf("got code %s for a rate limited search, want %s", s.Code(), codes.ResourceExhausted)
+	}
+	var rateLimitErr *zoekt.RateLimitError
+	if !errors.As(SearchErrorFromStatus(s.Err()), &rateLimitErr) || *rateLimitErr != (zoekt.RateLimitError{Class: "batch", RetryAfter: 3 * time.Second}) {
+		t.Errorf("got %v back from the status, want the rate limit error", rateLimitErr)
+	}
+
+	// Other errors pass through unchanged.
+	err = status.Error(codes.Unavailable, "shutting down")
+	if got := SearchErrorFromStatus(err); got != err {
+		t.Errorf("got %v, want %v", got, err)
+	}
+	err = errors.New("boom")
+	if got := SearchErrorStatus(err); got != err {
+		t.Errorf("got %v, want %v", got, err)
+	}
+}
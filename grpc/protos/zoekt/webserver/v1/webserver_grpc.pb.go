@@ -22,6 +22,7 @@ const (
 	WebserverService_Search_FullMethodName       = "/zoekt.webserver.v1.WebserverService/Search"
 	WebserverService_StreamSearch_FullMethodName = "/zoekt.webserver.v1.WebserverService/StreamSearch"
 	WebserverService_List_FullMethodName         = "/zoekt.webserver.v1.WebserverService/List"
+	WebserverService_GetFile_FullMethodName      = "/zoekt.webserver.v1.WebserverService/GetFile"
 )
 
 // WebserverServiceClient is the client API for WebserverService service.
@@ -33,6 +34,10 @@ type WebserverServiceClient interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// GetFile returns the content of a file, or a byte range of it, as it was
+	// indexed. The content is read from the shards and validated against its
+	// checksum.
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*GetFileResponse, error)
 }
 
 type webserverServiceClient struct {
@@ -93,6 +98,15 @@ func (c *webserverServiceClient) List(ctx context.Context, in *ListRequest, opts
 	return out, nil
 }
 
+func (c *webserverServiceClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*GetFileResponse, error) {
+	out := new(GetFileResponse)
+	err := c.cc.Invoke(ctx, WebserverService_GetFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // WebserverServiceServer is the server API for WebserverService service.
 // All implementations must embed UnimplementedWebserverServiceServer
 // for forward compatibility
@@ -102,6 +116,10 @@ type WebserverServiceServer interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(context.Context, *ListRequest) (*ListResponse, error)
+	// GetFile returns the content of a file, or a byte range of it, as it was
+	// indexed. The content is read from the shards and validated against its
+	// checksum.
+	GetFile(context.Context, *GetFileRequest) (*GetFileResponse, error)
 	mustEmbedUnimplementedWebserverServiceServer()
 }
 
@@ -118,6 +136,9 @@ func (UnimplementedWebserverServiceServer) StreamSearch(*StreamSearchRequest, We
 func (UnimplementedWebserverServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
+func (UnimplementedWebserverServiceServer) GetFile(context.Context, *GetFileRequest) (*GetFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFile not implemented")
+}
 func (UnimplementedWebserverServiceServer) mustEmbedUnimplementedWebserverServiceServer() {}
 
 // UnsafeWebserverServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -188,6 +209,24 @@ func _WebserverService_List_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WebserverService_GetFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).GetFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_GetFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).GetFile(ctx, req.(*GetFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // WebserverService_ServiceDesc is the grpc.ServiceDesc for WebserverService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -203,6 +242,10 @@ var WebserverService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _WebserverService_List_Handler,
 		},
+		{
+			MethodName: "GetFile",
+			Handler:    _WebserverService_GetFile_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,54 @@
+package zoekt
+
+import (
+	"github.com/sourcegraph/zoekt/internal/highlight"
+)
+
+// HighlightFiles sets the Tokens of the content matches of files, see
+// SearchOptions.Highlight. Tokenizing is expensive, so searchers call it on
+// the final result set, after it was truncated to the display limits.
+func HighlightFiles(files []FileMatch) {
+	for i := range files {
+		highlightMatches(&files[i])
+	}
+}
+
+// highlightMatches sets the Tokens of the content matches of fm. Filename
+// matches aren't highlighted.
+func highlightMatches(fm *FileMatch) {
+	for i := range fm.LineMatches {
+		lm := &fm.LineMatches[i]
+		if !lm.FileName {
+			lm.Tokens = highlightTokens(fm, lm.Line)
+		}
+	}
+	for i := range fm.ChunkMatches {
+		cm := &fm.ChunkMatches[i]
+		if !cm.FileName {
+			cm.Tokens = highlightTokens(fm, cm.Content)
+		}
+	}
+}
+
+func highlightTokens(fm *FileMatch, src []byte) []HighlightToken {
+	var tokens []HighlightToken
+	highlight.Tokenize(fm.Language, fm.FileName, src, func(start, end int, class string) {
+		tokens = append(tokens, HighlightToken{Start: uint32(start), End: uint32(end), Class: class})
+	})
+	return tokens
+}
+
+// truncateTokens drops the tokens beyond size bytes, and clips the token
+// spanning size.
+func truncateTokens(tokens []HighlightToken, size int) []HighlightToken {
+	for i := range tokens {
+		if int(tokens[i].Start) >= size {
+			return tokens[:i]
+		}
+		if int(tokens[i].End) > size {
+			tokens[i].End = uint32(size)
+			return tokens[:i+1]
+		}
+	}
+	return tokens
+}
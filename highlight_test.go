@@ -0,0 +1,83 @@
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSearchHighlight(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "main.go", Language: "Go", Content: []byte("package main\n\nfunc main() { return }\n")})
+
+	tokens := func(s string) []HighlightToken {
+		switch s {
+		case "func main() { return }\n", "func main() { return }":
+			return []HighlightToken{
+				{Start: 0, End: 4, Class: "kd"},
+				{Start: 5, End: 9, Class: "nf"},
+				{Start: 9, End: 11, Class: "p"},
+				{Start: 12, End: 13, Class: "p"},
+				{Start: 14, End: 20, Class: "k"},
+				{Start: 21, End: 22, Class: "p"},
+			}
+		}
+		t.Fatalf("no tokens for %q", s)
+		return nil
+	}
+
+	q := &query.Substring{Pattern: "return", Content: true}
+
+	t.Run("LineMatches", func(t *testing.T) {
+		sr := searchForTest(t, b, q, SearchOptions{Highlight: true})
+		HighlightFiles(sr.Files)
+		if len(sr.Files) != 1 || len(sr.Files[0].LineMatches) != 1 {
+			t.Fatalf("got %+v, want 1 line match", sr.Files)
+		}
+		lm := sr.Files[0].LineMatches[0]
+		if d := cmp.Diff(tokens(string(lm.Line)), lm.Tokens); d != "" {
+			t.Fatalf("-want, +got:\n%s", d)
+		}
+	})
+
+	t.Run("ChunkMatches", func(t *testing.T) {
+		sr := searchForTest(t, b, q, SearchOptions{Highlight: true, ChunkMatches: true})
+		HighlightFiles(sr.Files)
+		if len(sr.Files) != 1 || len(sr.Files[0].ChunkMatches) != 1 {
+			t.Fatalf("got %+v, want 1 chunk match", sr.Files)
+		}
+		cm := sr.Files[0].ChunkMatches[0]
+		if d := cmp.Diff(tokens(string(cm.Content)), cm.Tokens); d != "" {
+			t.Fatalf("-want, +got:\n%s", d)
+		}
+	})
+
+	t.Run("shard", func(t *testing.T) {
+		// Searchers of single shards leave highlighting to the caller,
+		// which knows the final result set.
+		sr := searchForTest(t, b, q, SearchOptions{Highlight: true, ChunkMatches: true})
+		if len(sr.Files) != 1 || sr.Files[0].ChunkMatches[0].Tokens != nil {
+			t.Fatalf("got %+v, want no tokens", sr.Files)
+		}
+	})
+}
+
+func TestTruncateTokens(t *testing.T) {
+	tokens := []HighlightToken{{Start: 0, End: 4}, {Start: 5, End: 9}, {Start: 10, End: 12}}
+	for _, tc := range []struct {
+		size int
+		want []HighlightToken
+	}{
+		{12, tokens},
+		{10, tokens[:2]},
+		{7, []HighlightToken{{Start: 0, End: 4}, {Start: 5, End: 7}}},
+		{0, []HighlightToken{}},
+	} {
+		got := truncateTokens(append([]HighlightToken{}, tokens...), tc.size)
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("size %d: -want, +got:\n%s", tc.size, d)
+		}
+	}
+}
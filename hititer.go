@@ -110,9 +110,10 @@ func (d *indexData) newDistanceTrigramIter(ng1, ng2 ngram, dist uint32, caseSens
 }
 
 func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (hitIterator, error) {
+	size := d.shardNgramSize()
 	variants := []ngram{ng}
 	if !caseSensitive {
-		variants = generateCaseNgrams(ng)
+		variants = generateCaseNgrams(ng, size)
 	}
 
 	iters := make([]hitIterator, 0, len(variants))
@@ -126,7 +127,7 @@ func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (
 			return nil, err
 		}
 		if len(blob) > 0 {
-			iters = append(iters, newCompressedPostingIterator(blob, v))
+			iters = append(iters, newCompressedPostingIterator(blob, v, size))
 		}
 	}
 
@@ -145,11 +146,10 @@ func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (
 // inMemoryIterator is hitIterator that goes over an in-memory uint32 posting list.
 type inMemoryIterator struct {
 	postings []uint32
-	what     ngram
 }
 
 func (i *inMemoryIterator) String() string {
-	return fmt.Sprintf("mem(%s):%v", i.what, i.postings)
+	return fmt.Sprintf("mem:%v", i.postings)
 }
 
 func (i *inMemoryIterator) first() uint32 {
@@ -180,20 +180,22 @@ type compressedPostingIterator struct {
 	ngramLookups     int
 	_first           uint32
 	what             ngram
+	whatSize         int
 }
 
-func newCompressedPostingIterator(b []byte, w ngram) *compressedPostingIterator {
+func newCompressedPostingIterator(b []byte, w ngram, size int) *compressedPostingIterator {
 	d, sz := binary.Uvarint(b)
 	return &compressedPostingIterator{
 		_first:           uint32(d),
 		blob:             b[sz:],
 		indexBytesLoaded: sz,
 		what:             w,
+		whatSize:         size,
 	}
 }
 
 func (i *compressedPostingIterator) String() string {
-	return fmt.Sprintf("compressed(%s, %d, [%d bytes])", i.what, i._first, len(i.blob))
+	return fmt.Sprintf("compressed(%s, %d, [%d bytes])", i.what.format(i.whatSize), i._first, len(i.blob))
 }
 
 func (i *compressedPostingIterator) first() uint32 {
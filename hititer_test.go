@@ -36,7 +36,7 @@ func TestCompressedPostingIterator_limit(t *testing.T) {
 
 		want := doHitIterator(&inMemoryIterator{postings: nums}, limits)
 
-		it := newCompressedPostingIterator(toDeltas(nums), stringToNGram("abc"))
+		it := newCompressedPostingIterator(toDeltas(nums), stringToNGram("abc"), ngramSize)
 		got := doHitIterator(it, limits)
 		if !reflect.DeepEqual(want, got) {
 			t.Log(cmp.Diff(want, got))
@@ -89,7 +89,7 @@ func benchmarkCompressedPostingIterator(b *testing.B, size, limitsSize int) {
 	b.ResetTimer()
 
 	for n := 0; n < b.N; n++ {
-		it := newCompressedPostingIterator(deltas, ng)
+		it := newCompressedPostingIterator(deltas, ng, ngramSize)
 		for _, limit := range limits {
 			it.next(limit)
 			_ = it.first()
@@ -6,16 +6,22 @@ import (
 	"io"
 	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/gobwas/glob"
 )
 
 var (
 	lineComment = "#"
 	IgnoreFile  = ".sourcegraph/ignore"
+
+	// ZoektIgnoreFile is an ignore-file with gitignore syntax at the root of
+	// the repository, see ParseGitignore.
+	ZoektIgnoreFile = ".zoektignore"
 )
 
 type Matcher struct {
 	ignoreList []glob.Glob
+	gitignore  gitignore.Matcher
 }
 
 // ParseIgnoreFile parses an ignore-file according to the following rules
@@ -52,15 +58,41 @@ func ParseIgnoreFile(r io.Reader) (matcher *Matcher, error error) {
 	return &Matcher{ignoreList: patterns}, scanner.Err()
 }
 
-// Match returns true if path has a prefix in common with any item in m.ignoreList
-func (m *Matcher) Match(path string) bool {
-	if len(m.ignoreList) == 0 {
-		return false
+// ParseGitignore parses r with the syntax of .gitignore files, see
+// https://git-scm.com/docs/gitignore, and adds its patterns to m. Patterns are
+// relative to the root of the repository.
+func (m *Matcher) ParseGitignore(r io.Reader) error {
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, lineComment) {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
+	m.gitignore = gitignore.NewMatcher(patterns)
+	return nil
+}
+
+// Match returns true if path has a prefix in common with any item in
+// m.ignoreList, or if it is excluded by the gitignore patterns of m.
+func (m *Matcher) Match(path string) bool {
 	for _, pattern := range m.ignoreList {
 		if pattern.Match(path) {
 			return true
 		}
 	}
+	if m.gitignore != nil {
+		return m.gitignore.Match(strings.Split(path, "/"), false)
+	}
 	return false
 }
+
+// IsIgnoreFile returns true if path is one of the ignore-files we read.
+func IsIgnoreFile(path string) bool {
+	return path == IgnoreFile || path == ZoektIgnoreFile
+}
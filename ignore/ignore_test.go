@@ -87,3 +87,43 @@ dir1/
 		})
 	}
 }
+
+func TestParseGitignore(t *testing.T) {
+	ignoreFile := `
+# generated code
+*.pb.go
+!keep.pb.go
+/vendor/
+testdata/fixtures/
+docs/**/*.png
+`
+	var ig Matcher
+	if err := ig.ParseGitignore(strings.NewReader(ignoreFile)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path      string
+		wantMatch bool
+	}{
+		{path: "api/api.pb.go", wantMatch: true},
+		{path: "api/keep.pb.go", wantMatch: false},
+		{path: "vendor/github.com/a/b.go", wantMatch: true},
+		{path: "cmd/vendor/a.go", wantMatch: false},
+		{path: "vendor", wantMatch: false},
+		{path: "testdata/fixtures/a.json", wantMatch: true},
+		{path: "testdata/golden.json", wantMatch: false},
+		// Patterns with a slash are relative to the root.
+		{path: "pkg/testdata/fixtures/a.json", wantMatch: false},
+		{path: "docs/img/logo.png", wantMatch: true},
+		{path: "docs/logo.svg", wantMatch: false},
+		{path: "# generated code", wantMatch: false},
+		{path: "main.go", wantMatch: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := ig.Match(tt.path); got != tt.wantMatch {
+				t.Errorf("got %t, expected %t", got, tt.wantMatch)
+			}
+		})
+	}
+}
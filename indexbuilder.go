@@ -29,13 +29,26 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/sourcegraph/zoekt/internal/charset"
+	"github.com/sourcegraph/zoekt/internal/filemetrics"
+	"github.com/sourcegraph/zoekt/internal/generated"
 	"github.com/sourcegraph/zoekt/internal/languages"
+	"github.com/sourcegraph/zoekt/internal/lockfile"
+	"github.com/sourcegraph/zoekt/internal/secrets"
 )
 
 var _ = log.Println
 
+// ngramSize is the default number of runes in the ngrams we index. Shards may
+// be built with any size between minNgramSize and maxNgramSize, see
+// IndexBuilder.SetNgramSize.
 const ngramSize = 3
 
+const (
+	minNgramSize = 2
+	maxNgramSize = 4
+)
+
 type searchableString struct {
 	data []byte
 }
@@ -61,6 +74,12 @@ type postingsBuilder struct {
 	postings    map[ngram][]byte
 	lastOffsets map[ngram]uint32
 
+	// postingsBytes approximates the memory used by postings.
+	postingsBytes int
+
+	// spilled holds the postings spilled to disk, see spill.
+	spilled *postingsSpill
+
 	// To support UTF-8 searching, we must map back runes to byte
 	// offsets. As a first attempt, we sample regularly. The
 	// precise offset can be found by walking from the recorded
@@ -70,6 +89,13 @@ type postingsBuilder struct {
 
 	isPlainASCII bool
 
+	// ngramSize is the number of runes per ngram.
+	ngramSize int
+
+	// hasCJKBigrams is true if we added postings for CJK bigrams, see
+	// cjkBigram.
+	hasCJKBigrams bool
+
 	endRunes []uint32
 	endByte  uint32
 }
@@ -79,18 +105,19 @@ func newPostingsBuilder() *postingsBuilder {
 		postings:     map[ngram][]byte{},
 		lastOffsets:  map[ngram]uint32{},
 		isPlainASCII: true,
+		ngramSize:    ngramSize,
 	}
 }
 
-// Store trigram offsets for the given UTF-8 data. The
+// Store ngram offsets for the given UTF-8 data. The
 // DocumentSections must correspond to rune boundaries in the UTF-8
 // data.
 func (s *postingsBuilder) newSearchableString(data []byte, byteSections []DocumentSection) (*searchableString, []DocumentSection, error) {
 	dest := searchableString{
 		data: data,
 	}
-	var buf [8]byte
-	var runeGram [3]rune
+	runeGram := make([]rune, s.ngramSize)
+	lastRune := uint32(s.ngramSize - 1)
 
 	var runeIndex uint32
 	byteCount := 0
@@ -110,7 +137,8 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 		}
 		data = data[sz:]
 
-		runeGram[0], runeGram[1], runeGram[2] = runeGram[1], runeGram[2], c
+		copy(runeGram, runeGram[1:])
+		runeGram[lastRune] = c
 
 		if idx := s.runeCount + runeIndex; idx%runeOffsetFrequency == 0 {
 			s.runeOffsets = append(s.runeOffsets, s.endByte+uint32(byteCount))
@@ -123,17 +151,19 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 
 		byteCount += sz
 
-		if runeIndex < 2 {
-			continue
+		// Words in CJK text are often only two runes long, which is shorter
+		// than our ngrams. Index adjacent CJK runes as bigrams too, so these
+		// words don't need a scan of all content.
+		if s.ngramSize > 2 && runeIndex > 0 && isCJK(c) && isCJK(runeGram[lastRune-1]) {
+			s.addPosting(cjkBigram(runeGram[lastRune-1], c, s.ngramSize), endRune+runeIndex-1)
+			s.hasCJKBigrams = true
 		}
 
-		ng := runesToNGram(runeGram)
-		lastOff := s.lastOffsets[ng]
-		newOff := endRune + uint32(runeIndex) - 2
+		if runeIndex < lastRune {
+			continue
+		}
 
-		m := binary.PutUvarint(buf[:], uint64(newOff-lastOff))
-		s.postings[ng] = append(s.postings[ng], buf[:m]...)
-		s.lastOffsets[ng] = newOff
+		s.addPosting(runesToNGramN(runeGram), endRune+runeIndex-lastRune)
 	}
 	s.runeCount += runeIndex
 
@@ -162,6 +192,21 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 	return &dest, runeSecs, nil
 }
 
+// addPosting records an occurrence of ng at rune offset off. Offsets must be
+// added in increasing order.
+func (s *postingsBuilder) addPosting(ng ngram, off uint32) {
+	var buf [8]byte
+	m := binary.PutUvarint(buf[:], uint64(off-s.lastOffsets[ng]))
+	p, ok := s.postings[ng]
+	if !ok {
+		// The map entry and slice header.
+		s.postingsBytes += 40
+	}
+	s.postings[ng] = append(p, buf[:m]...)
+	s.postingsBytes += m
+	s.lastOffsets[ng] = off
+}
+
 // IndexBuilder builds a single index shard.
 type IndexBuilder struct {
 	// The version we will write to disk. Sourcegraph Specific. This is to
@@ -205,6 +250,64 @@ type IndexBuilder struct {
 	// language codes, uint16 encoded as little-endian
 	languages []uint8
 
+	// dependencies holds the encoded dependencies of each document, see
+	// lockfile.Encode. It is only written if hasDependencies is set.
+	dependencies    [][]byte
+	hasDependencies bool
+
+	// lockfileErrors is the number of lockfiles whose dependencies could not
+	// be parsed.
+	lockfileErrors int
+
+	// FileMetrics enables computing the metrics of each document, see
+	// internal/filemetrics. They are written to an optional section and
+	// searched with loc:, nesting: and todos: queries.
+	FileMetrics bool
+
+	// fileMetrics holds the encoded metrics of each document.
+	fileMetrics []byte
+
+	// BloomFilter enables writing a bloom filter of the content n-grams, see
+	// internal/bloom. Searches for literals which aren't in the filter skip
+	// the shard without looking at its ngram index.
+	BloomFilter bool
+
+	// PostingsSpillBytes, if positive, bounds the memory used by the posting
+	// lists of the content. Beyond it, posting lists are spilled to a
+	// temporary file and merged back when the shard is written. This trades
+	// disk IO for memory when indexing large repositories. Write releases the
+	// spilled postings, so it must only be called once.
+	PostingsSpillBytes int
+
+	// SymbolsOnly reduces the content of documents to their symbols before
+	// indexing, see symbolsOnlyContent. Such shards are much smaller, and
+	// still serve file name and symbol searches, but content searches only
+	// find symbols.
+	SymbolsOnly bool
+
+	// generated is a bitmap of the documents that look generated, see
+	// internal/generated. It is only written if hasGenerated is set.
+	generated    []byte
+	hasGenerated bool
+
+	// DisableSecrets disables scanning documents for possible secrets, see
+	// internal/secrets.
+	DisableSecrets bool
+
+	// secrets holds the encoded possible secrets of each document, see
+	// secrets.Encode. It is only written if hasSecrets is set.
+	secrets    [][]byte
+	hasSecrets bool
+
+	// encodings holds the name of the encoding each document was transcoded
+	// from, see internal/charset. It is only written if hasEncodings is set.
+	encodings    [][]byte
+	hasEncodings bool
+
+	// carried holds the sections of an upgraded shard which the builder
+	// doesn't write itself, see Upgrade.
+	carried []carriedSection
+
 	// IndexTime will be used as the time if non-zero. Otherwise
 	// time.Now(). This is useful for doing reproducible builds in tests.
 	IndexTime time.Time
@@ -264,6 +367,28 @@ func (b *IndexBuilder) NumFiles() int {
 	return len(b.contentStrings)
 }
 
+// LockfileErrors returns the number of lockfiles added to this builder whose
+// dependencies could not be parsed. They are indexed as text only.
+func (b *IndexBuilder) LockfileErrors() int {
+	return b.lockfileErrors
+}
+
+// SetNgramSize sets the number of runes per ngram for the shard. It must be
+// called before adding documents. Smaller ngrams give better recall for
+// languages with large alphabets, like CJK, while larger ngrams are more
+// selective.
+func (b *IndexBuilder) SetNgramSize(n int) error {
+	if n < minNgramSize || n > maxNgramSize {
+		return fmt.Errorf("ngram size %d out of range [%d, %d]", n, minNgramSize, maxNgramSize)
+	}
+	if b.NumFiles() > 0 {
+		return fmt.Errorf("cannot change ngram size after adding documents")
+	}
+	b.contentPostings.ngramSize = n
+	b.namePostings.ngramSize = n
+	return nil
+}
+
 // NewIndexBuilder creates a fresh IndexBuilder. The passed in
 // Repository contains repo metadata, and may be set to nil.
 func NewIndexBuilder(r *Repository) (*IndexBuilder, error) {
@@ -332,6 +457,11 @@ type Document struct {
 	// is the reason it wasn't indexed.
 	SkipReason string
 
+	// Encoding is the name of the encoding Content was transcoded to UTF-8
+	// from, eg. "Shift_JIS", or empty if it was UTF-8. Add detects and
+	// transcodes content which isn't UTF-8, see internal/charset.
+	Encoding string
+
 	// Document sections for symbols. Offsets should use bytes.
 	Symbols         []DocumentSection
 	SymbolsMetaData []*Symbol
@@ -425,6 +555,10 @@ func DetermineLanguageIfUnknown(doc *Document) {
 func (b *IndexBuilder) Add(doc Document) error {
 	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
 
+	if doc.SkipReason == "" && doc.Encoding == "" {
+		doc.Content, doc.Encoding = charset.ToUTF8(doc.Content)
+	}
+
 	if idx := bytes.IndexByte(doc.Content, 0); idx >= 0 {
 		doc.SkipReason = fmt.Sprintf("binary content at byte offset %d", idx)
 		doc.Language = "binary"
@@ -432,6 +566,7 @@ func (b *IndexBuilder) Add(doc Document) error {
 
 	if doc.SkipReason != "" {
 		doc.Content = []byte(notIndexedMarker + doc.SkipReason)
+		doc.Encoding = ""
 		doc.Symbols = nil
 		doc.SymbolsMetaData = nil
 		if doc.Language == "" {
@@ -441,6 +576,19 @@ func (b *IndexBuilder) Add(doc Document) error {
 
 	DetermineLanguageIfUnknown(&doc)
 
+	var deps, secretAnnotations []byte
+	if doc.SkipReason == "" {
+		ds, err := lockfile.Parse(doc.Name, doc.Content)
+		if err != nil {
+			// A lockfile we can't parse is still searchable as text.
+			b.lockfileErrors++
+		}
+		deps = lockfile.Encode(ds)
+		if !b.DisableSecrets {
+			secretAnnotations = secrets.Encode(secrets.Scan(doc.Content))
+		}
+	}
+
 	sort.Sort(symbolSlice{doc.Symbols, doc.SymbolsMetaData})
 	var last DocumentSection
 	for i, s := range doc.Symbols {
@@ -455,6 +603,13 @@ func (b *IndexBuilder) Add(doc Document) error {
 		return fmt.Errorf("section goes past end of content")
 	}
 
+	// Tags which describe the whole file are computed before we drop
+	// everything but the symbols.
+	original := doc.Content
+	if b.SymbolsOnly && doc.SkipReason == "" {
+		doc.Content, doc.Symbols = symbolsOnlyContent(doc.Content, doc.Symbols)
+	}
+
 	if doc.SubRepositoryPath != "" {
 		rel, err := filepath.Rel(doc.SubRepositoryPath, doc.Name)
 		if err != nil || rel == doc.Name {
@@ -465,6 +620,11 @@ func (b *IndexBuilder) Add(doc Document) error {
 	if err != nil {
 		return err
 	}
+	if b.PostingsSpillBytes > 0 && b.contentPostings.postingsBytes > b.PostingsSpillBytes {
+		if err := b.contentPostings.spill(); err != nil {
+			return fmt.Errorf("spilling postings: %w", err)
+		}
+	}
 	nameStr, _, err := b.namePostings.newSearchableString([]byte(doc.Name), nil)
 	if err != nil {
 		return err
@@ -503,6 +663,28 @@ func (b *IndexBuilder) Add(doc Document) error {
 	b.fileEndSymbol = append(b.fileEndSymbol, uint32(len(b.runeDocSections)))
 	b.branchMasks = append(b.branchMasks, mask)
 	b.checksums = append(b.checksums, hasher.Sum(nil)...)
+	b.dependencies = append(b.dependencies, deps)
+	b.hasDependencies = b.hasDependencies || len(deps) > 0
+	b.secrets = append(b.secrets, secretAnnotations)
+	b.hasSecrets = b.hasSecrets || len(secretAnnotations) > 0
+	b.encodings = append(b.encodings, []byte(doc.Encoding))
+	b.hasEncodings = b.hasEncodings || doc.Encoding != ""
+	if b.FileMetrics {
+		var m filemetrics.Metrics
+		if doc.SkipReason == "" {
+			m = filemetrics.Compute(original)
+		}
+		b.fileMetrics = m.Append(b.fileMetrics)
+	}
+
+	docID := len(b.branchMasks) - 1
+	if docID%8 == 0 {
+		b.generated = append(b.generated, 0)
+	}
+	if doc.SkipReason == "" && generated.Is(doc.Name, original) {
+		b.generated[docID/8] |= 1 << (docID % 8)
+		b.hasGenerated = true
+	}
 
 	langCode, ok := b.languageMap[doc.Language]
 	if !ok {
@@ -517,6 +699,30 @@ func (b *IndexBuilder) Add(doc Document) error {
 	return nil
 }
 
+// symbolsOnlyContent returns content reduced to the given symbols, which must
+// be sorted and may not overlap, and the sections of the symbols in the
+// reduced content. Lines keep their numbers, so symbol matches point to the
+// right line of the original file, but only hold the symbols on them,
+// separated by spaces.
+func symbolsOnlyContent(content []byte, symbols []DocumentSection) ([]byte, []DocumentSection) {
+	var out []byte
+	sections := make([]DocumentSection, 0, len(symbols))
+	last := uint32(0)
+	for _, s := range symbols {
+		if n := bytes.Count(content[last:s.Start], []byte{'\n'}); n > 0 {
+			out = append(out, bytes.Repeat([]byte{'\n'}, n)...)
+		} else if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, ' ')
+		}
+		start := uint32(len(out))
+		out = append(out, content[s.Start:s.End]...)
+		sections = append(sections, DocumentSection{Start: start, End: uint32(len(out))})
+		last = s.End
+	}
+	out = append(out, bytes.Repeat([]byte{'\n'}, bytes.Count(content[last:], []byte{'\n'}))...)
+	return out, sections
+}
+
 func (b *IndexBuilder) branchMask(br string) uint64 {
 	for i, b := range b.repoList[len(b.repoList)-1].Branches {
 		if b.Name == br {
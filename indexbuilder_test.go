@@ -1,8 +1,14 @@
 package zoekt
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
 )
 
 func TestShardName(t *testing.T) {
@@ -47,3 +53,47 @@ func TestShardName(t *testing.T) {
 		})
 	}
 }
+
+func TestPostingsSpill(t *testing.T) {
+	var docs []Document
+	for i := 0; i < 50; i++ {
+		docs = append(docs, Document{
+			Name:    fmt.Sprintf("f%d.go", i),
+			Content: []byte(fmt.Sprintf("package p%d\n\nfunc Hello%d() string { return \"world %d\" }\n", i%7, i, i*i)),
+		})
+	}
+
+	want := testIndexBuilder(t, nil, docs...)
+
+	spilled := testIndexBuilder(t, nil)
+	spilled.PostingsSpillBytes = 256
+	for _, d := range docs {
+		if err := spilled.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if spilled.contentPostings.spilled == nil || len(spilled.contentPostings.spilled.runs) < 2 {
+		t.Fatal("expected postings to be spilled several times")
+	}
+
+	// Write releases the spilled postings, so we only write each builder once.
+	wantSearcher := searcherForTest(t, want)
+	gotSearcher := searcherForTest(t, spilled)
+	for _, pat := range []string{"package", "Hello4", "world 16", "p3\n", "string {"} {
+		q := &query.Substring{Pattern: pat, Content: true}
+		wantRes, err := wantSearcher.Search(context.Background(), q, &SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotRes, err := gotSearcher.Search(context.Background(), q, &SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wantRes.Files) == 0 {
+			t.Fatalf("%q: no matches", pat)
+		}
+		if diff := cmp.Diff(wantRes.Files, gotRes.Files); diff != "" {
+			t.Errorf("%q: mismatch (-want +got):\n%s", pat, diff)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"slices"
 	"unicode/utf8"
 
+	"github.com/sourcegraph/zoekt/internal/bloom"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -40,6 +41,38 @@ type indexData struct {
 	newlinesStart uint32
 	newlinesIndex []uint32
 
+	// dependencies of lockfiles, see internal/lockfile. dependenciesIndex is
+	// empty if the shard has no lockfiles.
+	dependenciesStart uint32
+	dependenciesIndex []uint32
+
+	// fileMetrics holds the metrics of each document, see
+	// internal/filemetrics. Its size is zero if the shard was built without
+	// file metrics.
+	fileMetrics simpleSection
+
+	// possible secrets of each document, see internal/secrets. secretsIndex
+	// is empty if the shard has no possible secrets.
+	secretsStart uint32
+	secretsIndex []uint32
+
+	// encodings the documents were transcoded from, see internal/charset.
+	// encodingsIndex is empty if all documents were UTF-8.
+	encodingsStart uint32
+	encodingsIndex []uint32
+
+	// contentBloom is the bloom filter of the content n-grams, see
+	// internal/bloom. It is nil if the shard was built without one.
+	contentBloom *bloom.Filter
+
+	// stats are the statistics the planner uses, see ShardStats.
+	stats ShardStats
+
+	// generated is a bitmap of the documents that look generated, see
+	// internal/generated. It is empty if the shard has no generated
+	// documents.
+	generated []byte
+
 	docSectionsStart uint32
 	docSectionsIndex []uint32
 
@@ -138,6 +171,17 @@ func (d *symbolData) kind(i uint32) []byte {
 	return d.symKindContent[d.symKindIndex[i]:d.symKindIndex[i+1]]
 }
 
+// kindOf returns the kind of the symbol at index i, or nil if there is no
+// metadata for it.
+func (d *symbolData) kindOf(i uint32) []byte {
+	size := uint32(4 * 4) // 4 uint32s
+	offset := i * size
+	if offset >= uint32(len(d.symMetaData)) {
+		return nil
+	}
+	return d.kind(uint32SliceAt(d.symMetaData[offset:offset+size], 1))
+}
+
 // data returns the symbol at index i
 func (d *symbolData) data(i uint32) *Symbol {
 	size := uint32(4 * 4) // 4 uint32s
@@ -162,6 +206,16 @@ func (d *indexData) getChecksum(idx uint32) []byte {
 	return d.checksums[start : start+crc64.Size]
 }
 
+// hasSecrets returns true if document idx has possible secrets.
+func (d *indexData) hasSecrets(idx uint32) bool {
+	return len(d.secretsIndex) > 0 && d.secretsIndex[idx+1] > d.secretsIndex[idx]
+}
+
+// isGenerated returns true if document idx looks generated.
+func (d *indexData) isGenerated(idx uint32) bool {
+	return len(d.generated) > 0 && d.generated[idx/8]&(1<<(idx%8)) != 0
+}
+
 func (d *indexData) getLanguage(idx uint32) uint16 {
 	if d.metaData.IndexFeatureVersion < 12 {
 		// older zoekt files had 8-bit language entries
@@ -222,6 +276,7 @@ func (d *indexData) calculateStats() error {
 			Repository:    md,
 			IndexMetadata: d.metaData,
 			Stats:         d.calculateStatsForFileRange(start, end),
+			Branches:      d.calculateBranchStats(&d.repoMetaData[repoID], start, end),
 		})
 		start = end
 	}
@@ -245,6 +300,32 @@ func (d *indexData) calculateStats() error {
 	return nil
 }
 
+// calculateBranchStats returns the index information of each branch of md,
+// whose documents are in the range [start, end).
+func (d *indexData) calculateBranchStats(md *Repository, start, end uint32) []BranchListEntry {
+	if len(md.Branches) == 0 {
+		return nil
+	}
+
+	branches := make([]BranchListEntry, len(md.Branches))
+	for i, b := range md.Branches {
+		branches[i] = BranchListEntry{
+			Name:      b.Name,
+			Version:   b.Version,
+			IndexTime: d.metaData.IndexTime,
+		}
+	}
+	for doc := start; doc < end; doc++ {
+		mask := d.fileBranchMasks[doc]
+		for i := range branches {
+			if mask&(uint64(1)<<uint(i)) != 0 {
+				branches[i].Documents++
+			}
+		}
+	}
+	return branches
+}
+
 // calculateNewLinesStats computes some Sourcegraph specific statistics for files
 // in the range [start, end). These are not as efficient to calculate as the
 // normal statistics. We experimentally measured about a 10% slower shard load
@@ -294,7 +375,8 @@ func (d *indexData) String() string {
 func (d *indexData) memoryUse() int {
 	sz := 0
 	for _, a := range [][]uint32{
-		d.newlinesIndex, d.docSectionsIndex,
+		d.newlinesIndex, d.docSectionsIndex, d.dependenciesIndex, d.secretsIndex,
+		d.encodingsIndex,
 		d.boundaries, d.fileNameIndex,
 		d.fileEndRunes, d.fileNameEndRunes,
 		d.fileEndSymbol, d.symbols.symKindIndex,
@@ -305,6 +387,7 @@ func (d *indexData) memoryUse() int {
 	sz += d.runeOffsets.sizeBytes()
 	sz += d.fileNameRuneOffsets.sizeBytes()
 	sz += len(d.languages)
+	sz += len(d.generated)
 	sz += len(d.checksums)
 	sz += 2 * len(d.repos)
 	sz += 8 * len(d.runeDocSections)
@@ -319,18 +402,18 @@ func (d *indexData) memoryUse() int {
 // overlapping trigrams to keep their intersection as small as possible.
 //
 // Invariant: first will always have a smaller index than last.
-func findSelectiveNgrams(ngramOffs []runeNgramOff, indexMap []int, frequencies []uint32) (first, last runeNgramOff) {
+func findSelectiveNgrams(ngramOffs []runeNgramOff, indexMap []int, frequencies []uint32, size int) (first, last runeNgramOff) {
 	first, last = minFrequencyNgramOffsets(ngramOffs, frequencies)
 
-	// If the trigrams are overlapping, then try to shift one to reduce overlap.
+	// If the ngrams are overlapping, then try to shift one to reduce overlap.
 	// This is guaranteed to produce a smaller intersection.
-	if last.index-first.index < ngramSize {
-		newFirstIndex := max(last.index-ngramSize, 0)
+	if last.index-first.index < size {
+		newFirstIndex := max(last.index-size, 0)
 		if newFirstIndex != first.index {
 			first = ngramOffs[indexMap[newFirstIndex]]
 		}
 
-		newLastIndex := min(first.index+ngramSize, len(ngramOffs)-1)
+		newLastIndex := min(first.index+size, len(ngramOffs)-1)
 		if newLastIndex != last.index {
 			last = ngramOffs[indexMap[newLastIndex]]
 		}
@@ -364,6 +447,25 @@ func minFrequencyNgramOffsets(ngramOffs []runeNgramOff, frequencies []uint32) (f
 	return
 }
 
+// shardNgramSize returns the number of runes per ngram in the ngram indexes of
+// the shard.
+func (d *indexData) shardNgramSize() int {
+	if n := d.metaData.NgramSize; n != 0 {
+		return n
+	}
+	return ngramSize
+}
+
+// cjkBigrams returns the CJK bigrams to look up for str, if str is too short
+// for the shard's ngrams but can be found through its CJK bigram postings.
+// Otherwise it returns nil.
+func (d *indexData) cjkBigrams(str string) []runeNgramOff {
+	if !d.metaData.CJKBigrams || utf8.RuneCountInString(str) >= d.shardNgramSize() {
+		return nil
+	}
+	return splitCJKBigrams(str, d.shardNgramSize())
+}
+
 func (data *indexData) ngrams(filename bool) btreeIndex {
 	if filename {
 		return data.fileNameNgrams
@@ -378,6 +480,15 @@ type ngramIterationResults struct {
 	fileName      bool
 	substrBytes   []byte
 	substrLowered []byte
+
+	// asciiFold is set if candidates can be compared with asciiEqualFold,
+	// see indexData.canFoldASCII.
+	asciiFold bool
+
+	// postingBytes is the size of the smaller posting list of the ngrams
+	// the iterator reads. The planner uses it to estimate the number of
+	// matches, see ShardStats.
+	postingBytes uint32
 }
 
 func (r *ngramIterationResults) String() string {
@@ -391,21 +502,45 @@ func (r *ngramIterationResults) candidates() []*candidateMatch {
 		c.fileName = r.fileName
 		c.substrBytes = r.substrBytes
 		c.substrLowered = r.substrLowered
+		c.asciiFold = r.asciiFold
 	}
 	return cs
 }
 
+// canFoldASCII returns true if pattern and all content and file names of the
+// shard are ASCII, so case insensitive comparisons only need to fold ASCII
+// letters.
+func (d *indexData) canFoldASCII(pattern []byte) bool {
+	return d.metaData.PlainASCII && isASCII(pattern)
+}
+
 func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResults, error) {
 	str := query.Pattern
 
 	// Find the 2 least common ngrams from the string.
-	ngramOffs := splitNGrams([]byte(str))
+	// size is the number of runes in the ngrams we look up, which differs from
+	// the shard's ngram size for CJK bigrams.
+	size := d.shardNgramSize()
+	ngramOffs := splitNGrams([]byte(str), size)
+	if len(ngramOffs) == 0 {
+		if ngramOffs = d.cjkBigrams(str); len(ngramOffs) > 0 {
+			size = 2
+		}
+	}
 
 	// protect against accidental searching of empty strings
 	if len(ngramOffs) == 0 {
 		return nil, errors.New("iterateNgrams needs non empty string")
 	}
 
+	// The bloom filter is cheaper to consult than the btree, and rejects
+	// patterns whose ngrams occur, but never next to each other.
+	if !query.FileName && d.contentBloom != nil && !d.contentBloom.MayContain(str) {
+		return &ngramIterationResults{
+			matchIterator: &noMatchTree{Why: "bloom"},
+		}, nil
+	}
+
 	// PERF: Sort to increase the chances adjacent checks are in the same btree
 	// bucket (which can cause disk IO).
 	slices.SortFunc(ngramOffs, runeNgramOff.Compare)
@@ -419,7 +554,7 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 			freq = ngrams.Get(o.ngram).sz
 			ngramLookups++
 		} else {
-			for _, v := range generateCaseNgrams(o.ngram) {
+			for _, v := range generateCaseNgrams(o.ngram, d.shardNgramSize()) {
 				freq += ngrams.Get(v).sz
 				ngramLookups++
 			}
@@ -440,7 +575,7 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 		indexMap[o.index] = i
 	}
 
-	first, last := findSelectiveNgrams(ngramOffs, indexMap, frequencies)
+	first, last := findSelectiveNgrams(ngramOffs, indexMap, frequencies, size)
 
 	iter := &ngramDocIterator{
 		leftPad:      uint32(first.index),
@@ -478,6 +613,8 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 		fileName:      query.FileName,
 		substrBytes:   patBytes,
 		substrLowered: lowerPatBytes,
+		asciiFold:     !query.CaseSensitive && d.canFoldASCII(patBytes),
+		postingBytes:  min(frequencies[indexMap[first.index]], frequencies[indexMap[last.index]]),
 	}, nil
 }
 
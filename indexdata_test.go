@@ -25,7 +25,7 @@ func genFrequencies(ngramOffs []runeNgramOff, max int) []uint32 {
 }
 
 func BenchmarkMinFrequencyNgramOffsets(b *testing.B) {
-	ngramOffs := splitNGrams([]byte(exampleQuery))
+	ngramOffs := splitNGrams([]byte(exampleQuery), ngramSize)
 	slices.SortFunc(ngramOffs, runeNgramOff.Compare)
 	frequencies := genFrequencies(ngramOffs, 100)
 	for i := 0; i < b.N; i++ {
@@ -45,7 +45,7 @@ func TestMinFrequencyNgramOffsets(t *testing.T) {
 		// Ensure maximum frequency is nonzero so that random sampling will work
 		maxFreq = max(maxFreq, 1)
 
-		ngramOffs := splitNGrams([]byte(s))
+		ngramOffs := splitNGrams([]byte(s), ngramSize)
 		if len(ngramOffs) == 0 {
 			return true
 		}
@@ -81,7 +81,7 @@ func TestFindSelectiveNGrams(t *testing.T) {
 		// Ensure maximum frequency is nonzero so that random sampling will work
 		maxFreq = max(maxFreq, 1)
 
-		ngramOffs := splitNGrams([]byte(s))
+		ngramOffs := splitNGrams([]byte(s), ngramSize)
 		if len(ngramOffs) == 0 {
 			return true
 		}
@@ -93,7 +93,7 @@ func TestFindSelectiveNGrams(t *testing.T) {
 		}
 
 		frequencies := genFrequencies(ngramOffs, int(maxFreq))
-		x0, x1 := findSelectiveNgrams(ngramOffs, indexMap, frequencies)
+		x0, x1 := findSelectiveNgrams(ngramOffs, indexMap, frequencies, ngramSize)
 
 		if len(ngramOffs) <= 1 {
 			return true
@@ -0,0 +1,190 @@
+package zoekt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// MmapAdvice is the advice given to the kernel about how a memory mapped
+// shard is accessed, see madvise(2).
+type MmapAdvice int
+
+const (
+	// MmapAdviceNormal leaves the kernel default readahead.
+	MmapAdviceNormal MmapAdvice = iota
+
+	// MmapAdviceRandom disables readahead. It suits sections which are
+	// read in small pieces, like postings, on storage where readahead is
+	// expensive, like network filesystems.
+	MmapAdviceRandom
+
+	// MmapAdviceSequential reads ahead aggressively.
+	MmapAdviceSequential
+
+	// MmapAdviceWillNeed starts reading the section into the page cache
+	// when the shard is loaded.
+	MmapAdviceWillNeed
+)
+
+var mmapAdviceNames = map[string]MmapAdvice{
+	"normal":     MmapAdviceNormal,
+	"random":     MmapAdviceRandom,
+	"sequential": MmapAdviceSequential,
+	"willneed":   MmapAdviceWillNeed,
+}
+
+func (a MmapAdvice) String() string {
+	for name, v := range mmapAdviceNames {
+		if v == a {
+			return name
+		}
+	}
+	return fmt.Sprintf("MmapAdvice(%d)", int(a))
+}
+
+// IndexFileOptions configures how NewIndexFileWithOptions reads a shard.
+type IndexFileOptions struct {
+	// Advice is the mmap advice for the whole shard.
+	Advice MmapAdvice
+
+	// SectionAdvice overrides Advice for the sections of the shard with the
+	// given names, like "postings" or "fileContents". It is applied once
+	// NewSearcher has read the table of contents.
+	SectionAdvice map[string]MmapAdvice
+
+	// Pread reads the shard with pread(2) instead of mapping it into memory.
+	// Every read allocates, but the process doesn't fault on pages of files
+	// on slow storage, and the advice is ignored. Platforms without mmap
+	// support always use pread.
+	Pread bool
+
+	// Direct, together with Pread, bypasses the page cache with O_DIRECT,
+	// for cold shards which would only evict hot ones from the cache. It is
+	// only supported on Linux.
+	Direct bool
+}
+
+// ParseAdvice sets the advice of o from spec, a comma separated list of
+// ADVICE, which sets Advice, or SECTION=ADVICE pairs, which set
+// SectionAdvice. ADVICE is one of normal, random, sequential and willneed.
+func (o *IndexFileOptions) ParseAdvice(spec string) error {
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		section, name, ok := strings.Cut(field, "=")
+		if !ok {
+			name = section
+		}
+		advice, known := mmapAdviceNames[strings.ToLower(name)]
+		if !known {
+			return fmt.Errorf("unknown mmap advice %q", name)
+		}
+		if !ok {
+			o.Advice = advice
+			continue
+		}
+		if o.SectionAdvice == nil {
+			o.SectionAdvice = map[string]MmapAdvice{}
+		}
+		o.SectionAdvice[section] = advice
+	}
+	return nil
+}
+
+// NewIndexFile returns a new index file. The index file takes
+// ownership of the passed in file, and may close it.
+func NewIndexFile(f *os.File) (IndexFile, error) {
+	return NewIndexFileWithOptions(f, IndexFileOptions{})
+}
+
+// sectionAdviser is implemented by index files which take advice per
+// section, see IndexFileOptions.SectionAdvice.
+type sectionAdviser interface {
+	adviseSection(tag string, off, sz uint32)
+}
+
+// adviseSections passes the ranges of the sections of toc to a.
+func (t *indexTOC) adviseSections(a sectionAdviser) {
+	list := append(t.sectionsTaggedList(), t.sectionsTaggedOptionalList()...)
+	for _, ent := range list {
+		switch s := ent.sec.(type) {
+		case *simpleSection:
+			a.adviseSection(ent.tag, s.off, s.sz)
+		case *compoundSection:
+			a.adviseSection(ent.tag, s.data.off, s.data.sz)
+			a.adviseSection(ent.tag, s.index.off, s.index.sz)
+		}
+	}
+}
+
+// indexFileFromOS reads a shard with pread(2).
+type indexFileFromOS struct {
+	f *os.File
+
+	// direct is set if f is opened with O_DIRECT, so reads must be
+	// aligned to directAlign.
+	direct bool
+}
+
+// directAlign is the alignment of offsets, sizes and buffers of O_DIRECT
+// reads. It is a multiple of the logical block size of common devices.
+const directAlign = 4096
+
+func (f *indexFileFromOS) Read(off, sz uint32) ([]byte, error) {
+	if !f.direct {
+		r := make([]byte, sz)
+		_, err := f.f.ReadAt(r, int64(off))
+		return r, err
+	}
+
+	start := int64(off) &^ (directAlign - 1)
+	end := (int64(off) + int64(sz) + directAlign - 1) &^ (directAlign - 1)
+	buf := alignedBuffer(int(end - start))
+	n, err := f.f.ReadAt(buf, start)
+	if n < int(int64(off)+int64(sz)-start) {
+		if err == nil {
+			err = fmt.Errorf("short read: %d bytes at %d in %s", n, start, f.f.Name())
+		}
+		return nil, err
+	}
+	// The last block of the file may be short.
+	skip := int(int64(off) - start)
+	return buf[skip : skip+int(sz)], nil
+}
+
+// alignedBuffer returns a buffer of n bytes aligned to directAlign.
+func alignedBuffer(n int) []byte {
+	buf := make([]byte, n+directAlign)
+	skip := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directAlign - 1)); rem != 0 {
+		skip = directAlign - rem
+	}
+	return buf[skip : skip+n]
+}
+
+func (f *indexFileFromOS) Size() (uint32, error) {
+	fi, err := f.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	sz := fi.Size()
+
+	if sz >= maxUInt32 {
+		return 0, fmt.Errorf("overflow")
+	}
+
+	return uint32(sz), nil
+}
+
+func (f *indexFileFromOS) Close() {
+	f.f.Close()
+}
+
+func (f *indexFileFromOS) Name() string {
+	return f.f.Name()
+}
@@ -0,0 +1,17 @@
+package zoekt
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDirectIO makes reads of f bypass the page cache.
+func setDirectIO(f *os.File) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags|unix.O_DIRECT)
+	return err
+}
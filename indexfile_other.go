@@ -22,41 +22,12 @@ import (
 	"os"
 )
 
-// NewIndexFile returns a new index file. The index file takes
-// ownership of the passed in file, and may close it.
-func NewIndexFile(f *os.File) (IndexFile, error) {
-	return &indexFileFromOS{f}, nil
-}
-
-type indexFileFromOS struct {
-	f *os.File
-}
-
-func (f *indexFileFromOS) Read(off, sz uint32) ([]byte, error) {
-	r := make([]byte, sz)
-	_, err := f.f.ReadAt(r, int64(off))
-	return r, err
-}
-
-func (f indexFileFromOS) Size() (uint32, error) {
-	fi, err := f.f.Stat()
-	if err != nil {
-		return 0, err
-	}
-
-	sz := fi.Size()
-
-	if sz >= maxUInt32 {
-		return 0, fmt.Errorf("overflow")
+// NewIndexFileWithOptions is like NewIndexFile, configured by opts. Shards
+// are always read with pread on this platform.
+func NewIndexFileWithOptions(f *os.File, opts IndexFileOptions) (IndexFile, error) {
+	if opts.Direct {
+		f.Close()
+		return nil, fmt.Errorf("%s: direct IO is not supported on this platform", f.Name())
 	}
-
-	return uint32(sz), nil
-}
-
-func (f indexFileFromOS) Close() {
-	f.f.Close()
-}
-
-func (f indexFileFromOS) Name() string {
-	return f.f.Name()
+	return &indexFileFromOS{f: f}, nil
 }
@@ -0,0 +1,152 @@
+package zoekt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var indexFileModes = map[string]IndexFileOptions{
+	"mmap":        {},
+	"mmap-advice": {Advice: MmapAdviceRandom, SectionAdvice: map[string]MmapAdvice{"postings": MmapAdviceWillNeed, "fileContents": MmapAdviceSequential}},
+	"pread":       {Pread: true},
+	"direct":      {Pread: true, Direct: true},
+}
+
+// writeTestShard writes a shard with n documents to a temporary file.
+func writeTestShard(t testing.TB, n int) string {
+	t.Helper()
+	b, err := NewIndexBuilder(&Repository{Name: "repo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := b.Add(Document{
+			Name:    fmt.Sprintf("dir%d/file%d.go", i%10, i),
+			Content: []byte(fmt.Sprintf("package dir%d\n\n// needle %d\nfunc F%d() int { return %d }\n", i%10, i, i, i*i)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fn := filepath.Join(t.TempDir(), "shard.zoekt")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func openTestShard(t testing.TB, fn string, opts IndexFileOptions) (Searcher, bool) {
+	t.Helper()
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iFile, err := NewIndexFileWithOptions(f, opts)
+	if err != nil {
+		if opts.Direct {
+			// Not every platform and filesystem supports O_DIRECT.
+			return nil, false
+		}
+		t.Fatal(err)
+	}
+	s, err := NewSearcher(iFile)
+	if err != nil {
+		iFile.Close()
+		t.Fatal(err)
+	}
+	return s, true
+}
+
+func TestIndexFileOptions(t *testing.T) {
+	fn := writeTestShard(t, 200)
+
+	queries := []query.Q{
+		&query.Substring{Pattern: "needle 1", Content: true},
+		&query.Substring{Pattern: "file17.go", FileName: true},
+		&query.Regexp{Regexp: mustParseRE("return [0-9]+1 }"), Content: true},
+	}
+	search := func(s Searcher) [][]FileMatch {
+		var all [][]FileMatch
+		for _, q := range queries {
+			sr, err := s.Search(context.Background(), q, &SearchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sr.Files) == 0 {
+				t.Fatalf("%s: no matches", q)
+			}
+			all = append(all, sr.Files)
+		}
+		return all
+	}
+
+	want, _ := openTestShard(t, fn, IndexFileOptions{})
+	defer want.Close()
+	wantFiles := search(want)
+
+	for name, opts := range indexFileModes {
+		t.Run(name, func(t *testing.T) {
+			s, ok := openTestShard(t, fn, opts)
+			if !ok {
+				t.Skip("direct IO is not supported")
+			}
+			defer s.Close()
+			if diff := cmp.Diff(wantFiles, search(s)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIndexFileOptions_ParseAdvice(t *testing.T) {
+	var opts IndexFileOptions
+	if err := opts.ParseAdvice("random, postings=willneed,fileContents=sequential"); err != nil {
+		t.Fatal(err)
+	}
+	want := IndexFileOptions{
+		Advice:        MmapAdviceRandom,
+		SectionAdvice: map[string]MmapAdvice{"postings": MmapAdviceWillNeed, "fileContents": MmapAdviceSequential},
+	}
+	if diff := cmp.Diff(want, opts); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := opts.ParseAdvice("postings=dontneed"); err == nil {
+		t.Error("expected error for unknown advice")
+	}
+}
+
+func BenchmarkIndexFile(b *testing.B) {
+	fn := writeTestShard(b, 5000)
+	q := &query.Substring{Pattern: "needle 42", Content: true}
+
+	for _, name := range []string{"mmap", "mmap-advice", "pread", "direct"} {
+		b.Run(name, func(b *testing.B) {
+			s, ok := openTestShard(b, fn, indexFileModes[name])
+			if !ok {
+				b.Skip("direct IO is not supported")
+			}
+			defer s.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Search(context.Background(), q, &SearchOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -28,6 +28,10 @@ type mmapedIndexFile struct {
 	name string
 	size uint32
 	data []byte
+
+	// sectionAdvice is the mmap advice for sections, see
+	// IndexFileOptions.SectionAdvice.
+	sectionAdvice map[string]MmapAdvice
 }
 
 func (f *mmapedIndexFile) Read(off, sz uint32) ([]byte, error) {
@@ -51,9 +55,54 @@ func (f *mmapedIndexFile) Close() {
 	}
 }
 
-// NewIndexFile returns a new index file. The index file takes
-// ownership of the passed in file, and may close it.
-func NewIndexFile(f *os.File) (IndexFile, error) {
+func (f *mmapedIndexFile) adviseSection(tag string, off, sz uint32) {
+	advice, ok := f.sectionAdvice[tag]
+	if !ok || sz == 0 {
+		return
+	}
+	f.advise(advice, off, sz)
+}
+
+// advise passes advice for the pages holding [off, off+sz) to madvise.
+func (f *mmapedIndexFile) advise(advice MmapAdvice, off, sz uint32) {
+	start := int(off) &^ (pageSize - 1)
+	end := min(int(off)+int(sz), len(f.data))
+	if start >= end {
+		return
+	}
+	if err := unix.Madvise(f.data[start:end], madviseFlag(advice)); err != nil {
+		log.Printf("WARN failed to madvise %s: %v", f.name, err)
+	}
+}
+
+// pageSize is the granularity of madvise.
+var pageSize = os.Getpagesize()
+
+func madviseFlag(a MmapAdvice) int {
+	switch a {
+	case MmapAdviceRandom:
+		return unix.MADV_RANDOM
+	case MmapAdviceSequential:
+		return unix.MADV_SEQUENTIAL
+	case MmapAdviceWillNeed:
+		return unix.MADV_WILLNEED
+	default:
+		return unix.MADV_NORMAL
+	}
+}
+
+// NewIndexFileWithOptions is like NewIndexFile, configured by opts.
+func NewIndexFileWithOptions(f *os.File, opts IndexFileOptions) (IndexFile, error) {
+	if opts.Pread {
+		if opts.Direct {
+			if err := setDirectIO(f); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: enabling direct IO: %w", f.Name(), err)
+			}
+		}
+		return &indexFileFromOS{f: f, direct: opts.Direct}, nil
+	}
+
 	defer f.Close()
 
 	fi, err := f.Stat()
@@ -66,8 +115,9 @@ func NewIndexFile(f *os.File) (IndexFile, error) {
 		return nil, fmt.Errorf("file %s too large: %d", f.Name(), sz)
 	}
 	r := &mmapedIndexFile{
-		name: f.Name(),
-		size: uint32(sz),
+		name:          f.Name(),
+		size:          uint32(sz),
+		sectionAdvice: opts.SectionAdvice,
 	}
 
 	rounded := (r.size + 4095) &^ 4095
@@ -76,5 +126,9 @@ func NewIndexFile(f *os.File) (IndexFile, error) {
 		return nil, err
 	}
 
+	if opts.Advice != MmapAdviceNormal {
+		r.advise(opts.Advice, 0, r.size)
+	}
+
 	return r, err
 }
@@ -0,0 +1,402 @@
+// Package accesslog writes an access log of searches in a structured, standard
+// format, for ingestion by log pipelines.
+//
+// Every search is one record. Records contain a hash of the query instead of
+// the query itself, so the log doesn't leak code or secrets people search
+// for, but repeated queries can still be correlated.
+//
+// The log is written in the W3C extended log file format
+// (https://www.w3.org/TR/WD-logfile.html) or as JSON lines, and files are
+// rotated once they reach a maximum size.
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Record is the access log entry of one search.
+type Record struct {
+	Time time.Time
+
+	// Method is "Search" or "StreamSearch".
+	Method string
+
+	// Tenant is the tenant ID of the search, "system" for internal searches
+	// or empty if there is no tenant.
+	Tenant string
+
+	QueryHash string
+
+	// Profile summarizes the search options, see OptionProfile.
+	Profile string
+
+	Latency time.Duration
+
+	FileCount     int
+	MatchCount    int
+	ShardsScanned int
+
+	// Truncated are the reasons results may be incomplete, see
+	// TruncationFlags.
+	Truncated []string
+
+	// Error is empty for successful searches, otherwise "canceled",
+	// "timeout" or "error".
+	Error string
+}
+
+// NewRecord returns the record of a search for q that started at start and
+// ended with st and err. st may be nil if the search failed.
+func NewRecord(ctx context.Context, method string, q query.Q, opts *zoekt.SearchOptions, start time.Time, st *zoekt.Stats, err error) Record {
+	r := Record{
+		Time:      start,
+		Method:    method,
+		Tenant:    tenantID(ctx),
+		QueryHash: QueryHash(q),
+		Profile:   OptionProfile(opts),
+		Latency:   time.Since(start),
+		Truncated: TruncationFlags(st, err),
+	}
+	if st != nil {
+		r.FileCount = st.FileCount
+		r.MatchCount = st.MatchCount
+		r.ShardsScanned = st.ShardsScanned
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		r.Error = "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		r.Error = "timeout"
+	default:
+		r.Error = "error"
+	}
+	return r
+}
+
+func tenantID(ctx context.Context) string {
+	if systemtenant.Is(ctx) {
+		return "system"
+	}
+	t, err := tenant.FromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	return strconv.Itoa(t.ID())
+}
+
+// QueryHash returns a short hash of q.
+func QueryHash(q query.Q) string {
+	sum := sha256.Sum256([]byte(q.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// OptionProfile returns a short description of the options which change how
+// a search is evaluated, eg. "chunk+bm25", or "default".
+func OptionProfile(opts *zoekt.SearchOptions) string {
+	var p []string
+	add := func(b bool, name string) {
+		if b {
+			p = append(p, name)
+		}
+	}
+	add(opts.ChunkMatches, "chunk")
+	add(opts.UseBM25Scoring, "bm25")
+	add(opts.Whole, "whole")
+	add(opts.EstimateDocCount, "estimate")
+	add(opts.NumContextLines > 0, "context")
+	add(opts.DebugScore, "debug")
+	add(opts.Trace, "trace")
+	add(opts.Profile, "profile")
+	add(opts.Highlight, "highlight")
+	if len(p) == 0 {
+		return "default"
+	}
+	return strings.Join(p, "+")
+}
+
+// LatencyBucket returns the bucket of d, one of "<10ms", "<100ms", "<1s",
+// "<10s" and ">=10s".
+func LatencyBucket(d time.Duration) string {
+	switch {
+	case d < 10*time.Millisecond:
+		return "<10ms"
+	case d < 100*time.Millisecond:
+		return "<100ms"
+	case d < time.Second:
+		return "<1s"
+	case d < 10*time.Second:
+		return "<10s"
+	default:
+		return ">=10s"
+	}
+}
+
+// TruncationFlags returns why the results of a search may be incomplete:
+//
+//   - "files_skipped" if match limits were hit;
+//   - "shards_skipped" if shards weren't searched, eg. because of limits;
+//   - "crashes" if searching a shard panicked;
+//   - "timeout" if the search ran out of time.
+func TruncationFlags(st *zoekt.Stats, err error) []string {
+	var flags []string
+	if st != nil {
+		if st.FilesSkipped > 0 {
+			flags = append(flags, "files_skipped")
+		}
+		if st.ShardsSkipped > 0 {
+			flags = append(flags, "shards_skipped")
+		}
+		if st.Crashes > 0 {
+			flags = append(flags, "crashes")
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		flags = append(flags, "timeout")
+	}
+	return flags
+}
+
+// Format is the format of the access log.
+type Format string
+
+const (
+	// W3C is the W3C extended log file format. Custom fields have the
+	// prefix "x-".
+	W3C Format = "w3c"
+
+	// JSON writes one JSON object per record and line.
+	JSON Format = "json"
+)
+
+// ParseFormat parses the name of a format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case W3C, JSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown access log format %q, must be %q or %q", s, W3C, JSON)
+	}
+}
+
+var w3cFields = []string{
+	"date", "time", "x-method", "x-tenant", "x-query-hash", "x-profile",
+	"time-taken", "x-latency-bucket", "x-file-count", "x-match-count",
+	"x-shards-scanned", "x-truncated", "x-error",
+}
+
+// header returns the directives at the start of every file.
+func (f Format) header(now time.Time) []byte {
+	if f != W3C {
+		return nil
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "#Version: 1.0\n")
+	fmt.Fprintf(&b, "#Software: zoekt-webserver %s\n", zoekt.Version)
+	fmt.Fprintf(&b, "#Date: %s\n", now.UTC().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "#Fields: %s\n", strings.Join(w3cFields, " "))
+	return b.Bytes()
+}
+
+// jsonRecord is the JSON encoding of Record.
+type jsonRecord struct {
+	Timestamp     string   `json:"timestamp"`
+	Method        string   `json:"method"`
+	Tenant        string   `json:"tenant,omitempty"`
+	QueryHash     string   `json:"query_hash"`
+	Profile       string   `json:"profile"`
+	LatencyMs     float64  `json:"latency_ms"`
+	LatencyBucket string   `json:"latency_bucket"`
+	FileCount     int      `json:"file_count"`
+	MatchCount    int      `json:"match_count"`
+	ShardsScanned int      `json:"shards_scanned"`
+	Truncated     []string `json:"truncated,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// line returns the encoding of r in f, including the newline.
+func (f Format) line(r Record) ([]byte, error) {
+	if f == JSON {
+		b, err := json.Marshal(jsonRecord{
+			Timestamp:     r.Time.UTC().Format(time.RFC3339Nano),
+			Method:        r.Method,
+			Tenant:        r.Tenant,
+			QueryHash:     r.QueryHash,
+			Profile:       r.Profile,
+			LatencyMs:     float64(r.Latency.Microseconds()) / 1000,
+			LatencyBucket: LatencyBucket(r.Latency),
+			FileCount:     r.FileCount,
+			MatchCount:    r.MatchCount,
+			ShardsScanned: r.ShardsScanned,
+			Truncated:     r.Truncated,
+			Error:         r.Error,
+		})
+		return append(b, '\n'), err
+	}
+
+	// W3C fields are separated by spaces, and "-" marks empty fields.
+	field := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return strings.ReplaceAll(s, " ", "+")
+	}
+	t := r.Time.UTC()
+	fields := []string{
+		t.Format("2006-01-02"),
+		t.Format("15:04:05.000"),
+		field(r.Method),
+		field(r.Tenant),
+		field(r.QueryHash),
+		field(r.Profile),
+		strconv.FormatFloat(r.Latency.Seconds(), 'f', 3, 64),
+		LatencyBucket(r.Latency),
+		strconv.Itoa(r.FileCount),
+		strconv.Itoa(r.MatchCount),
+		strconv.Itoa(r.ShardsScanned),
+		field(strings.Join(r.Truncated, ",")),
+		field(r.Error),
+	}
+	return []byte(strings.Join(fields, " ") + "\n"), nil
+}
+
+// Options configure a Logger which writes to a file.
+type Options struct {
+	Path   string
+	Format Format
+
+	// MaxSize is the size in bytes after which the file is rotated. Zero
+	// disables rotation.
+	MaxSize int64
+
+	// MaxFiles is the number of rotated files which are kept. Zero keeps all
+	// of them.
+	MaxFiles int
+}
+
+// Logger writes records. It is safe for concurrent use.
+type Logger struct {
+	format Format
+
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+	opts Options
+	size int64
+}
+
+// New returns a Logger which writes to w, without rotation. The header of
+// the format is written immediately.
+func New(w io.Writer, format Format) (*Logger, error) {
+	if _, err := w.Write(format.header(time.Now())); err != nil {
+		return nil, err
+	}
+	return &Logger{format: format, w: w}, nil
+}
+
+// Open returns a Logger which appends to the file opts.Path and rotates it.
+func Open(opts Options) (*Logger, error) {
+	l := &Logger{format: opts.Format, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.w, l.size = f, f, fi.Size()
+
+	// W3C directives apply until the next directives, so we repeat them
+	// when appending to an existing file too.
+	return l.write(l.format.header(time.Now()))
+}
+
+func (l *Logger) write(b []byte) error {
+	n, err := l.w.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// Log writes r.
+func (l *Logger) Log(r Record) error {
+	line, err := l.format.line(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.opts.MaxSize > 0 && l.size+int64(len(line)) > l.opts.MaxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating access log: %w", err)
+		}
+	}
+	return l.write(line)
+}
+
+// rotate renames the current file to Path.TIMESTAMP, removes the oldest
+// rotated files and opens a new file.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := l.opts.Path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(l.opts.Path, rotated); err != nil {
+		return err
+	}
+
+	if l.opts.MaxFiles > 0 {
+		old, err := filepath.Glob(l.opts.Path + ".*")
+		if err != nil {
+			return err
+		}
+		// The timestamps sort in chronological order.
+		sort.Strings(old)
+		for len(old) > l.opts.MaxFiles {
+			if err := os.Remove(old[0]); err != nil {
+				return err
+			}
+			old = old[1:]
+		}
+	}
+
+	return l.open()
+}
+
+// Close closes the file of the logger, if any.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,196 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestOptionProfile(t *testing.T) {
+	cases := []struct {
+		opts zoekt.SearchOptions
+		want string
+	}{
+		{zoekt.SearchOptions{}, "default"},
+		{zoekt.SearchOptions{ChunkMatches: true, UseBM25Scoring: true}, "chunk+bm25"},
+		{zoekt.SearchOptions{NumContextLines: 3, Trace: true}, "context+trace"},
+	}
+	for _, tc := range cases {
+		if got := OptionProfile(&tc.opts); got != tc.want {
+			t.Errorf("OptionProfile(%+v) = %q, want %q", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	q := &query.Substring{Pattern: "secret"}
+	start := time.Now()
+	st := &zoekt.Stats{FileCount: 2, MatchCount: 5, ShardsScanned: 3, FilesSkipped: 1}
+
+	r := NewRecord(context.Background(), "Search", q, &zoekt.SearchOptions{}, start, st, nil)
+	want := Record{
+		Time:          start,
+		Method:        "Search",
+		QueryHash:     QueryHash(q),
+		Profile:       "default",
+		FileCount:     2,
+		MatchCount:    5,
+		ShardsScanned: 3,
+		Truncated:     []string{"files_skipped"},
+	}
+	if d := cmp.Diff(want, r, cmp.FilterPath(func(p cmp.Path) bool {
+		return p.String() == "Latency"
+	}, cmp.Ignore())); d != "" {
+		t.Fatalf("-want, +got:\n%s", d)
+	}
+	if strings.Contains(r.QueryHash, "secret") {
+		t.Fatalf("query hash %q contains the query", r.QueryHash)
+	}
+
+	r = NewRecord(context.Background(), "StreamSearch", q, &zoekt.SearchOptions{}, start, nil, context.DeadlineExceeded)
+	if r.Error != "timeout" || !cmp.Equal(r.Truncated, []string{"timeout"}) {
+		t.Fatalf("got error %q and truncated %v for a timeout", r.Error, r.Truncated)
+	}
+}
+
+func testRecord() Record {
+	return Record{
+		Time:          time.Date(2024, 3, 1, 12, 30, 5, 0, time.UTC),
+		Method:        "Search",
+		QueryHash:     "0123456789abcdef",
+		Profile:       "chunk+bm25",
+		Latency:       150 * time.Millisecond,
+		FileCount:     2,
+		MatchCount:    5,
+		ShardsScanned: 3,
+		Truncated:     []string{"files_skipped", "crashes"},
+	}
+}
+
+func TestW3C(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, W3C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(testRecord()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 4 directives and 1 record:\n%s", len(lines), buf.String())
+	}
+	if lines[0] != "#Version: 1.0" {
+		t.Errorf("got first line %q", lines[0])
+	}
+	fields := strings.Fields(strings.TrimPrefix(lines[3], "#Fields: "))
+	values := strings.Fields(lines[4])
+	if len(fields) != len(values) {
+		t.Fatalf("got %d values for %d fields", len(values), len(fields))
+	}
+
+	want := "2024-03-01 12:30:05.000 Search - 0123456789abcdef chunk+bm25 0.150 <1s 2 5 3 files_skipped,crashes -"
+	if lines[4] != want {
+		t.Errorf("got record\n%s\nwant\n%s", lines[4], want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(testRecord()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	want := map[string]any{
+		"timestamp":      "2024-03-01T12:30:05Z",
+		"method":         "Search",
+		"query_hash":     "0123456789abcdef",
+		"profile":        "chunk+bm25",
+		"latency_ms":     150.0,
+		"latency_bucket": "<1s",
+		"file_count":     2.0,
+		"match_count":    5.0,
+		"shards_scanned": 3.0,
+		"truncated":      []any{"files_skipped", "crashes"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("-want, +got:\n%s", d)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	line, err := JSON.line(testRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file fits two records.
+	l, err := Open(Options{
+		Path:     path,
+		Format:   JSON,
+		MaxSize:  int64(2*len(line) + 1),
+		MaxFiles: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for range 7 {
+		if err := l.Log(testRecord()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("got rotated files %v, want 2", rotated)
+	}
+	for _, p := range append(rotated, path) {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 2
+		if p == path {
+			want = 1
+		}
+		if n := bytes.Count(b, []byte("\n")); n != want {
+			t.Errorf("%s: got %d records, want %d", p, n, want)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if _, err := ParseFormat("w3c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFormat("clf"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -78,13 +79,55 @@ func (a *zipArchive) Next() (*File, error) {
 	}, nil
 }
 
-func newZipArchive(r io.Reader, closer io.Closer) (*zipArchive, error) {
+// tempFile is a file which is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if err2 := os.Remove(f.Name()); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// multiCloser closes all of its closers.
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, closer := range c {
+		if err2 := closer.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+func newZipArchive(r io.Reader, closer io.Closer) (_ *zipArchive, err error) {
 	f, ok := r.(interface {
 		io.ReaderAt
 		Stat() (os.FileInfo, error)
 	})
 	if !ok {
-		return nil, fmt.Errorf("streaming zip files not supported")
+		// The zip directory is at the end, so we need random access to
+		// remote zip files. Download them to a temporary file.
+		tmp, tmpErr := os.CreateTemp("", "zoekt-archive-*.zip")
+		if tmpErr != nil {
+			return nil, tmpErr
+		}
+		t := tempFile{tmp}
+		defer func() {
+			if err != nil {
+				_ = t.Close()
+			}
+		}()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return nil, err
+		}
+		f = tmp
+		closer = multiCloser{closer, t}
 	}
 
 	fi, err := f.Stat()
@@ -119,6 +162,9 @@ func detectContentType(r io.Reader) (string, io.Reader, error) {
 	}
 
 	ct := http.DetectContentType(buf[:n])
+	if ct == "application/octet-stream" && bytes.HasPrefix(buf[:n], []byte("BZh")) {
+		ct = "application/x-bzip2"
+	}
 
 	// If we are a seeker, we can just undo our read
 	if s, ok := r.(io.Seeker); ok {
@@ -157,8 +203,8 @@ func OpenReader(u string) (io.ReadCloser, error) {
 	return os.Open(u)
 }
 
-// openArchive opens the tar at the URL or filepath u. Also supported is tgz
-// files over http.
+// openArchive opens the tar or zip at the URL or filepath u. Tars may be
+// compressed with gzip or bzip2.
 func openArchive(u string) (ar Archive, err error) {
 	readCloser, err := OpenReader(u)
 	if err != nil {
@@ -181,6 +227,9 @@ func openArchive(u string) (ar Archive, err error) {
 			return nil, err
 		}
 
+	case "application/x-bzip2":
+		r = bzip2.NewReader(r)
+
 	case "application/zip":
 		return newZipArchive(r, readCloser)
 	}
@@ -3,15 +3,21 @@ package archive
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -259,3 +265,67 @@ func testLatestCommitDate(t *testing.T, format string) {
 	require.Len(t, repos, 1)
 	require.True(t, repos[0].LatestCommitDate.Equal(modTime))
 }
+
+// TestReleaseArtifact tests indexing archives which are not from a code host,
+// like an SDK release downloaded from a web server.
+func TestReleaseArtifact(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeArchive(&buf, "zip", map[string]string{
+		"sdk-1.2.3/include/sdk.h": "int sdk_init(void);\n",
+		"sdk-1.2.3/lib/README":    "prebuilt libraries\n",
+		"LICENSE":                 "outside of the prefix\n",
+	})
+	require.NoError(t, err)
+
+	// Remote zips can't be streamed, since the directory is at the end.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	indexDir := t.TempDir()
+	opts := Options{
+		Archive:     srv.URL + "/sdk-1.2.3.zip",
+		Name:        "sdk",
+		RepoURL:     "https://sdk.example.com",
+		StripPrefix: "sdk-1.2.3/",
+	}
+	require.NoError(t, Index(opts, build.Options{IndexDir: indexDir}))
+
+	ss, err := shards.NewDirectorySearcher(indexDir)
+	require.NoError(t, err)
+	defer ss.Close()
+
+	result, err := ss.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, f := range result.Files {
+		names = append(names, f.FileName)
+		require.Equal(t, []string{"HEAD"}, f.Branches)
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"include/sdk.h", "lib/README"}, names)
+
+	repos, err := ss.List(context.Background(), &query.Const{Value: true}, nil)
+	require.NoError(t, err)
+	require.Len(t, repos.Repos, 1)
+	require.Equal(t, "https://sdk.example.com", repos.Repos[0].Repository.URL)
+
+	// Local archives without a commit are versioned by their checksum, so
+	// incremental indexing notices when they change.
+	path := filepath.Join(t.TempDir(), "sdk.zip")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	sum := sha256.Sum256(buf.Bytes())
+
+	indexDir = t.TempDir()
+	opts.Archive = path
+	opts.Incremental = true
+	require.NoError(t, Index(opts, build.Options{IndexDir: indexDir}))
+
+	shardPaths, err := filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+	require.NoError(t, err)
+	require.Len(t, shardPaths, 1)
+	indexed, _, err := zoekt.ReadMetadataPath(shardPaths[0])
+	require.NoError(t, err)
+	require.Equal(t, []zoekt.RepositoryBranch{{Name: "HEAD", Version: hex.EncodeToString(sum[:])}}, indexed[0].Branches)
+}
@@ -1,11 +1,15 @@
-// package archive provides indexing of archives from remote URLs.
+// package archive provides indexing of archives from remote URLs and local
+// files, like codeload tarballs or release artifacts.
 package archive
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
 
@@ -23,6 +27,10 @@ type Options struct {
 	Branch  string
 	Commit  string
 	Strip   int
+
+	// StripPrefix is removed from the path of each file after Strip, eg.
+	// "sdk-1.2.3/". Files outside of it are skipped.
+	StripPrefix string
 }
 
 func (o *Options) SetDefaults() {
@@ -81,12 +89,24 @@ func Index(opts Options, bopts build.Options) error {
 		return errors.New("-name or -url required")
 	}
 	if opts.Branch == "" {
-		return errors.New("-branch required")
+		opts.Branch = "HEAD"
+	}
+	if opts.Commit == "" && isLocalFile(opts.Archive) {
+		// Without a commit, incremental indexing would never pick up a new
+		// version of the archive. A checksum changes with the contents.
+		sum, err := checksum(opts.Archive)
+		if err != nil {
+			return err
+		}
+		opts.Commit = sum
 	}
 
 	if opts.Name != "" {
 		bopts.RepositoryDescription.Name = opts.Name
 	}
+	if opts.RepoURL != "" {
+		bopts.RepositoryDescription.URL = opts.RepoURL
+	}
 	// We do not use this functionality to avoid pulling in the transitive deps of gitindex
 	/*
 		if opts.RepoURL != "" {
@@ -136,7 +156,8 @@ func Index(opts Options, bopts build.Options) error {
 		}
 
 		name := stripComponents(f.Name, opts.Strip)
-		if name == "" {
+		name, ok := strings.CutPrefix(name, opts.StripPrefix)
+		if name == "" || !ok {
 			return nil
 		}
 
@@ -177,6 +198,26 @@ func stripComponents(path string, count int) string {
 	return path
 }
 
+// isLocalFile returns true if the archive location u is a file on disk.
+func isLocalFile(u string) bool {
+	return u != "-" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://")
+}
+
+// checksum returns the hex encoded SHA-256 checksum of the file at path.
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // isGitOID checks if the revision is a git OID SHA string.
 //
 // Note: This doesn't mean the SHA exists in a repository, nor does it mean it
@@ -0,0 +1,273 @@
+// Package audit records the searches run against zoekt for compliance: the
+// query text, who asked, how long it took and how many repositories matched.
+//
+// Unlike the access log, audit events contain the query itself. Redact
+// removes what must not be stored, like secrets people search for, before an
+// event reaches a sink. Events are written asynchronously, so slow sinks
+// don't slow down searches; events which don't fit into the buffer are
+// dropped and counted in zoekt_audit_events_dropped_total.
+package audit
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/regexp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sourcegraph/zoekt/grpc/propagator"
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var (
+	metricEventsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_audit_events_total",
+		Help: "The total number of audit events written to all sinks.",
+	})
+	metricEventsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_audit_events_dropped_total",
+		Help: "The total number of audit events dropped because the sinks could not keep up.",
+	})
+	metricSinkErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_audit_sink_errors_total",
+		Help: "The total number of failed writes of audit events to a sink.",
+	})
+)
+
+// Event is the audit record of one search.
+type Event struct {
+	Time time.Time
+
+	// Method is "Search" or "StreamSearch".
+	Method string
+
+	// Principal is the authenticated client which ran the search, see
+	// auth.PrincipalFromContext. It is empty without authentication.
+	Principal string
+
+	// OnBehalfOf is who the client claims to search for, eg. the user of a
+	// frontend, see WithOnBehalfOf. Clients can set it to anything, so it
+	// is only as trustworthy as Principal.
+	OnBehalfOf string
+
+	// Tenant is the tenant ID of the search, if any.
+	Tenant string
+
+	Query string
+
+	Latency time.Duration
+
+	// RepoCount is the number of repositories with results.
+	RepoCount int
+	FileCount int
+
+	// Error is empty for successful searches.
+	Error string
+}
+
+// NewEvent returns the event of a search for q that started at start and
+// ended with err, finding files in repos repositories.
+func NewEvent(ctx context.Context, method string, q query.Q, start time.Time, repos, files int, err error) Event {
+	e := Event{
+		Time:       start,
+		Method:     method,
+		OnBehalfOf: OnBehalfOfFromContext(ctx),
+		Query:      q.String(),
+		Latency:    time.Since(start),
+		RepoCount:  repos,
+		FileCount:  files,
+	}
+	if p := auth.PrincipalFromContext(ctx); p != nil {
+		e.Principal = p.Name
+	}
+	if systemtenant.Is(ctx) {
+		e.Tenant = "system"
+	} else if t, err := tenant.FromContext(ctx); err == nil {
+		e.Tenant = strconv.Itoa(t.ID())
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// Sink stores audit events. Sinks are only called by one goroutine at a
+// time.
+type Sink interface {
+	Write(ctx context.Context, e *Event) error
+	Close() error
+}
+
+// Options configure a Logger.
+type Options struct {
+	Sinks []Sink
+
+	// SampleRate is the fraction of events which are recorded. Values <= 0 or
+	// >= 1 record all events.
+	SampleRate float64
+
+	// Redact, if set, is called on every recorded event before it is
+	// written, eg. to remove secrets from Query.
+	Redact func(*Event)
+
+	// BufferSize is the number of events waiting for the sinks, 1024 if 0.
+	BufferSize int
+
+	// OnError, if set, is called for every failed write to a sink.
+	OnError func(error)
+}
+
+// Logger writes events to sinks in the background. It is safe for
+// concurrent use.
+type Logger struct {
+	opts   Options
+	events chan Event
+	done   chan struct{}
+
+	// mu guards closed, so Log doesn't send on events after Close closed
+	// it.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns a Logger writing to opts.Sinks. Call Close to flush the
+// buffered events.
+func New(opts Options) *Logger {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+	l := &Logger{
+		opts:   opts,
+		events: make(chan Event, opts.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log records e, unless it isn't sampled. It never blocks. Events logged
+// after Close are dropped.
+func (l *Logger) Log(e Event) {
+	if r := l.opts.SampleRate; r > 0 && r < 1 && rand.Float64() >= r {
+		return
+	}
+	if l.opts.Redact != nil {
+		l.opts.Redact(&e)
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		metricEventsDroppedTotal.Inc()
+		return
+	}
+	select {
+	case l.events <- e:
+	default:
+		metricEventsDroppedTotal.Inc()
+	}
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ctx := context.Background()
+	for e := range l.events {
+		for _, s := range l.opts.Sinks {
+			if err := s.Write(ctx, &e); err != nil {
+				metricSinkErrorsTotal.Inc()
+				if l.opts.OnError != nil {
+					l.opts.OnError(err)
+				}
+			}
+		}
+		metricEventsTotal.Inc()
+	}
+}
+
+// Close writes the buffered events and closes the sinks. It is safe to call
+// concurrently with Log.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+
+	<-l.done
+	var errs []error
+	for _, s := range l.opts.Sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// RedactPattern returns a Redact function which replaces all matches of re
+// in the query with "[REDACTED]".
+func RedactPattern(re *regexp.Regexp) func(*Event) {
+	return func(e *Event) {
+		e.Query = re.ReplaceAllLiteralString(e.Query, "[REDACTED]")
+	}
+}
+
+// headerKey is the HTTP header and gRPC metadata key for OnBehalfOf.
+const headerKey = "X-Zoekt-Principal"
+
+type contextKey struct{}
+
+// WithOnBehalfOf returns a context for a request made on behalf of user, eg.
+// the user of a frontend.
+func WithOnBehalfOf(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// OnBehalfOfFromContext returns who the request is made on behalf of, or "".
+func OnBehalfOfFromContext(ctx context.Context) string {
+	u, _ := ctx.Value(contextKey{}).(string)
+	return u
+}
+
+// Middleware records the X-Zoekt-Principal header of requests as who they
+// are made on behalf of. The header is not authenticated, so events record
+// it apart from the principal.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := r.Header.Get(headerKey); u != "" {
+			r = r.WithContext(WithOnBehalfOf(r.Context(), u))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Propagator implements the propagator.Propagator interface for propagating
+// who requests are made on behalf of across RPC calls, like Middleware does
+// for HTTP.
+type Propagator struct{}
+
+var _ propagator.Propagator = &Propagator{}
+
+func (Propagator) FromContext(ctx context.Context) metadata.MD {
+	md := make(metadata.MD)
+	if u := OnBehalfOfFromContext(ctx); u != "" {
+		md.Append(headerKey, u)
+	}
+	return md
+}
+
+func (Propagator) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	if vals := md.Get(headerKey); len(vals) > 0 && vals[0] != "" {
+		return WithOnBehalfOf(ctx, vals[0]), nil
+	}
+	return ctx, nil
+}
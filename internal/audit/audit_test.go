@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type memorySink struct {
+	events []Event
+}
+
+func (s *memorySink) Write(_ context.Context, e *Event) error {
+	s.events = append(s.events, *e)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestNewEvent(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "frontend"})
+	ctx = WithOnBehalfOf(ctx, "alice")
+	q := &query.Substring{Pattern: "foo"}
+	start := time.Now()
+
+	e := NewEvent(ctx, "Search", q, start, 2, 5, errors.New("boom"))
+	if e.Principal != "frontend" || e.OnBehalfOf != "alice" || e.Query != q.String() || e.RepoCount != 2 || e.FileCount != 5 || e.Error != "boom" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if !e.Time.Equal(start) || e.Latency < 0 {
+		t.Fatalf("unexpected time %v or latency %v", e.Time, e.Latency)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{}
+	l := New(Options{
+		Sinks:  []Sink{sink},
+		Redact: RedactPattern(regexp.MustCompile(`AKIA[A-Z0-9]+`)),
+	})
+	l.Log(Event{Method: "Search", Query: `substr:"AKIAXYZ123"`})
+	l.Log(Event{Method: "StreamSearch", Query: `substr:"foo"`})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(sink.events))
+	}
+	if got, want := sink.events[0].Query, `substr:"[REDACTED]"`; got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+	if got, want := sink.events[1].Query, `substr:"foo"`; got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+}
+
+func TestLoggerCloseConcurrently(t *testing.T) {
+	sink := &memorySink{}
+	l := New(Options{Sinks: []Sink{sink}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Log(Event{Method: "Search"})
+			}
+		}()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// Events logged after Close are dropped.
+	n := len(sink.events)
+	l.Log(Event{Method: "Search"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != n {
+		t.Fatalf("got %d events after Close, want %d", len(sink.events), n)
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	sink := &memorySink{}
+	l := New(Options{Sinks: []Sink{sink}, SampleRate: 1e-9})
+	for i := 0; i < 100; i++ {
+		l.Log(Event{Method: "Search"})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) > 1 {
+		t.Fatalf("got %d events at a sample rate of 1e-9", len(sink.events))
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, err := ParseSink("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Options{Sinks: []Sink{s}})
+	l.Log(Event{Method: "Search", Principal: "frontend", OnBehalfOf: "alice", Query: "foo", RepoCount: 3, Latency: 1500 * time.Microsecond})
+	l.Log(Event{Method: "Search", Principal: "bob", Query: "bar"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []jsonEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e jsonEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2", len(got))
+	}
+	if got[0].Principal != "frontend" || got[0].OnBehalfOf != "alice" || got[0].RepoCount != 3 || got[0].LatencyMs != 1.5 {
+		t.Errorf("unexpected first line %+v", got[0])
+	}
+	if got[1].Principal != "bob" || got[1].Query != "bar" {
+		t.Errorf("unexpected second line %+v", got[1])
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var (
+		gotAuth  string
+		gotEvent jsonEvent
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		gotAuth = r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &gotEvent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	t.Setenv("ZOEKT_AUDIT_AUTHORIZATION", "Bearer token")
+	s, err := ParseSink(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(context.Background(), &Event{Method: "Search", Query: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("got Authorization %q", gotAuth)
+	}
+	if gotEvent.Method != "Search" || gotEvent.Query != "foo" {
+		t.Errorf("unexpected event %+v", gotEvent)
+	}
+
+	failing := &HTTPSink{URL: ts.URL + "/missing"}
+	if err := failing.Write(context.Background(), &Event{}); err == nil {
+		t.Fatal("expected an error for a 404 response")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = OnBehalfOfFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.Header.Set("X-Zoekt-Principal", "alice")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "alice" {
+		t.Fatalf("got on behalf of %q, want alice", got)
+	}
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// jsonEvent is the JSON encoding of Event.
+type jsonEvent struct {
+	Timestamp  string  `json:"timestamp"`
+	Method     string  `json:"method"`
+	Principal  string  `json:"principal,omitempty"`
+	OnBehalfOf string  `json:"on_behalf_of,omitempty"`
+	Tenant     string  `json:"tenant,omitempty"`
+	Query      string  `json:"query"`
+	LatencyMs  float64 `json:"latency_ms"`
+	RepoCount  int     `json:"repo_count"`
+	FileCount  int     `json:"file_count"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// encode returns the JSON encoding of e, without a trailing newline.
+func encode(e *Event) ([]byte, error) {
+	return json.Marshal(jsonEvent{
+		Timestamp:  e.Time.UTC().Format(time.RFC3339Nano),
+		Method:     e.Method,
+		Principal:  e.Principal,
+		OnBehalfOf: e.OnBehalfOf,
+		Tenant:     e.Tenant,
+		Query:      e.Query,
+		LatencyMs:  float64(e.Latency.Microseconds()) / 1000,
+		RepoCount:  e.RepoCount,
+		FileCount:  e.FileCount,
+		Error:      e.Error,
+	})
+}
+
+// FileSink appends events as JSON lines to a file. It doesn't rotate the
+// file; use a tool like logrotate with copytruncate.
+type FileSink struct {
+	f *os.File
+}
+
+// OpenFile returns a FileSink appending to the file at path.
+func OpenFile(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Write(_ context.Context, e *Event) error {
+	b, err := encode(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink POSTs every event as JSON to URL, eg. the HTTP event collector of
+// a SIEM.
+type HTTPSink struct {
+	URL string
+
+	// Header is added to every request, eg. for authentication.
+	Header http.Header
+
+	// Client defaults to a client with a timeout of 10 seconds.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *HTTPSink) Write(ctx context.Context, e *Event) error {
+	b, err := encode(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, vs := range s.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// ParseSink returns the sink described by spec:
+//
+//   - "file:PATH", or just PATH, appends JSON lines to a file;
+//   - "syslog:" writes to the local syslog daemon, "syslog://HOST:PORT" to a
+//     remote one over UDP and "syslog+tcp://HOST:PORT" over TCP;
+//   - "http://..." and "https://..." POST every event as JSON. The
+//     environment variable ZOEKT_AUDIT_AUTHORIZATION, if set, is sent as
+//     Authorization header.
+func ParseSink(spec string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		s := &HTTPSink{URL: spec}
+		if auth := os.Getenv("ZOEKT_AUDIT_AUTHORIZATION"); auth != "" {
+			s.Header = http.Header{"Authorization": []string{auth}}
+		}
+		return s, nil
+
+	case spec == "syslog:":
+		return newSyslogSink("", "")
+
+	case strings.HasPrefix(spec, "syslog://"), strings.HasPrefix(spec, "syslog+tcp://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		return newSyslogSink(network, u.Host)
+
+	default:
+		return OpenFile(strings.TrimPrefix(spec, "file:"))
+	}
+}
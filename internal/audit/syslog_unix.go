@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"context"
+	"log/syslog"
+)
+
+// syslogSink writes events as JSON to syslog, with facility AUTH.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// newSyslogSink connects to the syslog daemon at addr over network, or to
+// the local one if network is empty.
+func newSyslogSink(network, addr string) (Sink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, "zoekt-audit")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(_ context.Context, e *Event) error {
+	b, err := encode(e)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(b))
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,384 @@
+// Package auth authenticates the clients of zoekt-webserver, so it can be
+// exposed without an authenticating proxy in front of it.
+//
+// Clients authenticate with a client certificate signed by one of the client
+// CAs (mutual TLS), or with one of the bearer tokens of the tokens file in
+// the Authorization header, or in the authorization metadata of gRPC
+// requests. Reload re-reads the certificates and tokens, so they can be
+// rotated without a restart: add the new token, reload, move the clients over
+// and remove the old token.
+//
+// The Principal of an authenticated request is on its context. Principals
+// listed in Options.Admins may use the endpoints wrapped with RequireAdmin.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var metricUnauthenticatedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_webserver_unauthenticated_requests_total",
+	Help: "The total number of requests rejected because their client didn't authenticate.",
+}, []string{"api"})
+
+// errUnauthenticated is returned for clients without valid credentials.
+var errUnauthenticated = errors.New("a client certificate or a valid bearer token is required")
+
+// Options configure an Authenticator. All files are PEM or text files which
+// are read again by Reload.
+type Options struct {
+	// CertFile and KeyFile, if set, are the certificate and key of the
+	// server for TLS.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile, if set, holds the certificates of the CAs which sign
+	// client certificates. It requires CertFile.
+	ClientCAFile string
+
+	// TokensFile, if set, holds the accepted bearer tokens, one per line,
+	// optionally followed by whitespace and the name of the principal. Empty
+	// lines and lines starting with # are ignored.
+	TokensFile string
+
+	// Admins are the names of the principals which may use the endpoints
+	// wrapped with RequireAdmin.
+	Admins []string
+}
+
+// Principal is the identity of an authenticated client.
+type Principal struct {
+	// Name is the common name (or else the subject) of the client
+	// certificate, or the name of the token. Tokens without a name are
+	// called "token-" followed by the start of their SHA-256 hash.
+	Name string
+
+	// Admin is true if Name is one of Options.Admins.
+	Admin bool
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context for a request of p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of an authenticated request, or
+// nil if the client didn't authenticate.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type unauthenticatedKey struct{}
+
+// IsUnauthenticated returns true for the health checks which Middleware let
+// through although the client didn't authenticate. Their replies must not
+// contain any indexed data.
+func IsUnauthenticated(ctx context.Context) bool {
+	v, _ := ctx.Value(unauthenticatedKey{}).(bool)
+	return v
+}
+
+// IsAdmin returns true if the request of ctx comes from one of the admins.
+func IsAdmin(ctx context.Context) bool {
+	p := PrincipalFromContext(ctx)
+	return p != nil && p.Admin
+}
+
+// RequireAdmin rejects requests of clients which aren't admins with 403
+// Forbidden. Without authentication, there are no admins, so h is never
+// called.
+func RequireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r.Context()) {
+			http.Error(w, "only admins may use this endpoint", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Authenticator checks the credentials of requests. It is safe for
+// concurrent use.
+type Authenticator struct {
+	opts  Options
+	creds atomic.Pointer[credentialSet]
+}
+
+// credentialSet is what Reload reads from the files.
+type credentialSet struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+
+	// tokens maps the SHA-256 hashes of the tokens to their names, so
+	// lookups don't leak the tokens through timing.
+	tokens map[[sha256.Size]byte]string
+}
+
+// New returns an Authenticator for opts, which reads its files.
+func New(opts Options) (*Authenticator, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("auth: a certificate requires a key and vice versa")
+	}
+	if opts.ClientCAFile != "" && opts.CertFile == "" {
+		return nil, errors.New("auth: client certificates require TLS, but there is no server certificate")
+	}
+	a := &Authenticator{opts: opts}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the files of the options again. If that fails, the
+// credentials read before stay in use.
+func (a *Authenticator) Reload() error {
+	var creds credentialSet
+
+	if a.opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(a.opts.CertFile, a.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("auth: loading the server certificate: %w", err)
+		}
+		creds.cert = &cert
+	}
+
+	if a.opts.ClientCAFile != "" {
+		b, err := os.ReadFile(a.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("auth: reading the client CAs: %w", err)
+		}
+		creds.clientCAs = x509.NewCertPool()
+		if !creds.clientCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("auth: no certificates in %s", a.opts.ClientCAFile)
+		}
+	}
+
+	if a.opts.TokensFile != "" {
+		b, err := os.ReadFile(a.opts.TokensFile)
+		if err != nil {
+			return fmt.Errorf("auth: reading the tokens: %w", err)
+		}
+		creds.tokens = map[[sha256.Size]byte]string{}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			sum := sha256.Sum256([]byte(fields[0]))
+			name := "token-" + hex.EncodeToString(sum[:4])
+			if len(fields) > 1 {
+				name = fields[1]
+			}
+			creds.tokens[sum] = name
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("auth: reading the tokens: %w", err)
+		}
+		if len(creds.tokens) == 0 {
+			// An empty file locks out all token clients, which is
+			// more likely a mistake than intended.
+			return fmt.Errorf("auth: no tokens in %s", a.opts.TokensFile)
+		}
+	}
+
+	a.creds.Store(&creds)
+	return nil
+}
+
+// Required returns true if clients must authenticate, which is the case if
+// there are client CAs or tokens.
+func (a *Authenticator) Required() bool {
+	return a.opts.ClientCAFile != "" || a.opts.TokensFile != ""
+}
+
+// TLSConfig returns the TLS configuration of the server, or nil without a
+// server certificate. Clients may present a client certificate; whether
+// they must authenticate is up to Middleware and the interceptors, so health
+// checks work without one.
+func (a *Authenticator) TLSConfig() *tls.Config {
+	if a.opts.CertFile == "" {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return a.creds.Load().cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			creds := a.creds.Load()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*creds.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if creds.clientCAs != nil {
+				cfg.ClientCAs = creds.clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// check returns the principal of the verified client certificate on state or
+// the valid bearer token in authorization. It returns a nil principal if
+// authentication isn't required and the client didn't authenticate, and an
+// error if it is required.
+func (a *Authenticator) check(state *tls.ConnectionState, authorization string) (*Principal, error) {
+	// The certificates are only verified if there are client CAs.
+	if state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		subject := state.VerifiedChains[0][0].Subject
+		name := subject.CommonName
+		if name == "" {
+			name = subject.String()
+		}
+		return a.principal(name), nil
+	}
+	creds := a.creds.Load()
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok && creds.tokens != nil {
+		if name, ok := creds.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]; ok {
+			return a.principal(name), nil
+		}
+	}
+	if !a.Required() {
+		return nil, nil
+	}
+	return nil, errUnauthenticated
+}
+
+func (a *Authenticator) principal(name string) *Principal {
+	return &Principal{Name: name, Admin: slices.Contains(a.opts.Admins, name)}
+}
+
+// withPrincipal is WithPrincipal for a principal which may be nil.
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return WithPrincipal(ctx, p)
+}
+
+// Middleware rejects HTTP requests of clients which didn't authenticate with
+// 401 Unauthorized, except for the health checks /healthz and /readyz, which
+// are marked with IsUnauthenticated instead. The principal of other requests
+// is on their context.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.check(r.TLS, r.Header.Get("Authorization"))
+		if err != nil && (r.URL.Path == "/healthz" || r.URL.Path == "/readyz") {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), unauthenticatedKey{}, true)))
+			return
+		}
+		if err != nil {
+			metricUnauthenticatedTotal.WithLabelValues("http").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zoekt"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
+
+// healthMethodPrefix is the prefix of the methods of the gRPC health service,
+// which don't require authentication, like /healthz.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// checkGRPC is check for the peer and metadata of a gRPC request. It returns
+// ctx with the principal of the request.
+func (a *Authenticator) checkGRPC(ctx context.Context) (context.Context, error) {
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	var authorization string
+	if vals := metadata.ValueFromIncomingContext(ctx, "authorization"); len(vals) > 0 {
+		authorization = vals[0]
+	}
+	p, err := a.check(state, authorization)
+	if err != nil {
+		metricUnauthenticatedTotal.WithLabelValues("grpc").Inc()
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return withPrincipal(ctx, p), nil
+}
+
+// UnaryServerInterceptor is a grpc.UnaryServerInterceptor which rejects the
+// requests of clients which didn't authenticate with UNAUTHENTICATED, except
+// for the health service. The principal of other requests is on their
+// context.
+func (a *Authenticator) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+		return handler(ctx, req)
+	}
+	ctx, err := a.checkGRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func (a *Authenticator) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+		return handler(srv, ss)
+	}
+	ctx, err := a.checkGRPC(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpc_middleware.WrappedServerStream{ServerStream: ss, WrappedContext: ctx})
+}
+
+// TokenTransport is an http.RoundTripper which authenticates requests with
+// the bearer token in File. The file is read for every request, so the token
+// can be rotated.
+type TokenTransport struct {
+	File string
+
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, err := os.ReadFile(t.File)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading the token: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testCA issues certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a server or client.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "server.pem"), serverCert)
+	writeFile(t, filepath.Join(dir, "server.key"), serverKey)
+	writeFile(t, filepath.Join(dir, "ca.pem"), ca.pem)
+	writeFile(t, filepath.Join(dir, "tokens"), []byte("# ci\nold-token\n\n"))
+
+	a, err := New(Options{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		TokensFile:   filepath.Join(dir, "tokens"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	ts.TLS = a.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+	}
+
+	get := func(client *http.Client, path, token string) int {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	client := newClient()
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{path: "/api/search", want: http.StatusUnauthorized},
+		{path: "/api/search", token: "wrong", want: http.StatusUnauthorized},
+		{path: "/api/search", token: "old-token", want: http.StatusOK},
+		{path: "/healthz", want: http.StatusOK},
+	} {
+		if got := get(client, tc.path, tc.token); got != tc.want {
+			t.Errorf("GET %s with token %q: got %d, want %d", tc.path, tc.token, got, tc.want)
+		}
+	}
+
+	// Clients with a certificate of the CA don't need a token.
+	clientCert, clientKey := ca.issue(t, "ci", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(newClient(cert), "/api/search", ""); got != http.StatusOK {
+		t.Errorf("got %d with a client certificate, want 200", got)
+	}
+
+	// Certificates of other CAs are rejected.
+	otherCert, otherKey := newTestCA(t).issue(t, "mallory", x509.ExtKeyUsageClientAuth)
+	cert, err = tls.X509KeyPair(otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newClient(cert).Get(ts.URL + "/api/search"); err == nil {
+		t.Error("a client certificate of another CA was accepted")
+	}
+
+	// Tokens are rotated by reloading.
+	writeFile(t, filepath.Join(dir, "tokens"), []byte("new-token\n"))
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(client, "/api/search", "old-token"); got != http.StatusUnauthorized {
+		t.Errorf("got %d for a removed token, want 401", got)
+	}
+	if got := get(client, "/api/search", "new-token"); got != http.StatusOK {
+		t.Errorf("got %d for a new token, want 200", got)
+	}
+
+	// A broken reload keeps the previous tokens.
+	writeFile(t, filepath.Join(dir, "tokens"), nil)
+	if err := a.Reload(); err == nil {
+		t.Fatal("reloading an empty tokens file succeeded")
+	}
+	if got := get(client, "/api/search", "new-token"); got != http.StatusOK {
+		t.Errorf("got %d after a failed reload, want 200", got)
+	}
+
+	// TokenTransport authenticates requests.
+	writeFile(t, filepath.Join(dir, "token"), []byte("new-token\n"))
+	client.Transport = &TokenTransport{File: filepath.Join(dir, "token"), Base: client.Transport}
+	if got := get(client, "/api/search", ""); got != http.StatusOK {
+		t.Errorf("got %d with TokenTransport, want 200", got)
+	}
+}
+
+func TestAuthenticatorGRPC(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tokens"), []byte("secret\nroot-secret root\n"))
+	a, err := New(Options{TokensFile: filepath.Join(dir, "tokens"), Admins: []string{"root"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		want          codes.Code
+		wantAdmin     bool
+	}{
+		{want: codes.Unauthenticated},
+		{authorization: "Bearer wrong", want: codes.Unauthenticated},
+		{authorization: "secret", want: codes.Unauthenticated},
+		{authorization: "Bearer secret", want: codes.OK},
+		{authorization: "Bearer root-secret", want: codes.OK, wantAdmin: true},
+	} {
+		ctx := context.Background()
+		if tc.authorization != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tc.authorization))
+		}
+		ctx, err := a.checkGRPC(ctx)
+		if got := status.Code(err); got != tc.want {
+			t.Errorf("authorization %q: got %s, want %s", tc.authorization, got, tc.want)
+		}
+		if err == nil && IsAdmin(ctx) != tc.wantAdmin {
+			t.Errorf("authorization %q: got admin %t, want %t", tc.authorization, IsAdmin(ctx), tc.wantAdmin)
+		}
+	}
+
+	// Health checks don't need to authenticate.
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if _, err := a.UnaryServerInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) { return nil, nil }); err != nil {
+		t.Errorf("got %v for a health check, want nil", err)
+	}
+	info = &grpc.UnaryServerInfo{FullMethod: "/zoekt.webserver.v1.WebserverService/Search"}
+	if _, err := a.UnaryServerInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) { return nil, nil }); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got %v for a search, want UNAUTHENTICATED", err)
+	}
+
+	// Without client CAs or tokens, everybody is let in.
+	a, err = New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.checkGRPC(context.Background()); err != nil {
+		t.Errorf("got %v without authentication, want nil", err)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tokens"), []byte("ci-secret ci\nroot-secret root\nanonymous\n"))
+	a, err := New(Options{TokensFile: filepath.Join(dir, "tokens"), Admins: []string{"root"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *Principal
+	h := a.Middleware(RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PrincipalFromContext(r.Context())
+	})))
+
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{token: "ci-secret", want: http.StatusForbidden},
+		{token: "anonymous", want: http.StatusForbidden},
+		{token: "root-secret", want: http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/api/contexts", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("token %q: got %d, want %d", tc.token, w.Code, tc.want)
+		}
+	}
+	if want := (&Principal{Name: "root", Admin: true}); got == nil || *got != *want {
+		t.Errorf("got principal %+v, want %+v", got, want)
+	}
+
+	// Tokens without a name are named after their hash.
+	p, err := a.check(nil, "Bearer anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p.Name, "token-") || p.Admin {
+		t.Errorf("got principal %+v for a token without a name", p)
+	}
+}
+
+func TestUnauthenticatedHealthChecks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tokens"), []byte("ci-secret ci\n"))
+	a, err := New(Options{TokensFile: filepath.Join(dir, "tokens")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unauthenticated bool
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unauthenticated = IsUnauthenticated(r.Context())
+	}))
+
+	for _, tc := range []struct {
+		token string
+		want  bool
+	}{
+		{token: "", want: true},
+		{token: "wrong", want: true},
+		{token: "ci-secret", want: false},
+	} {
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || unauthenticated != tc.want {
+			t.Errorf("token %q: got %d and unauthenticated %v, want 200 and %v", tc.token, w.Code, unauthenticated, tc.want)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Package backup snapshots index directories into a Store, and restores
+// them.
+//
+// Files are stored once under the SHA-256 of their content, so a backup only
+// uploads the shards which changed since the previous one. Each backup writes
+// a snapshot, a manifest of the files in the index directory with their
+// checksums and the versions of the repositories in each shard. Restoring a
+// snapshot makes the index directory look like it did at the time of the
+// backup.
+//
+// Layout of a store:
+//
+//	objects/SHA256          file contents
+//	snapshots/ID.json       a Snapshot
+//	snapshots.json          the IDs and times of all snapshots
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// Snapshot is the manifest of a backup of an index directory.
+type Snapshot struct {
+	ID    string
+	Time  time.Time
+	Files []File
+}
+
+// File is a file of a Snapshot.
+type File struct {
+	// Name is the base name of the file.
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// SHA256 is the hex encoded SHA-256 checksum of the contents, which is
+	// also the key of the contents in the store.
+	SHA256 string
+
+	// Repositories are the repositories in the file if it is a shard.
+	Repositories []Repository `json:",omitempty"`
+}
+
+// Repository is the version of a repository in a shard.
+type Repository struct {
+	ID        uint32
+	Name      string
+	Branches  []zoekt.RepositoryBranch
+	Tombstone bool `json:",omitempty"`
+}
+
+// SnapshotInfo identifies a snapshot in the list of snapshots of a store.
+type SnapshotInfo struct {
+	ID   string
+	Time time.Time
+}
+
+// backedUp returns true if name is a file we back up: shards, their metadata
+// overrides and the manifests of shard sets.
+func backedUp(name string) bool {
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta") || strings.HasSuffix(name, ".manifest")
+}
+
+func objectKey(sum string) string {
+	return "objects/" + sum
+}
+
+func snapshotKey(id string) string {
+	return "snapshots/" + id + ".json"
+}
+
+const snapshotsKey = "snapshots.json"
+
+// Backup snapshots the index directory dir into store, and returns the
+// snapshot. It only uploads files whose content isn't stored yet. Files
+// whose size and modification time are unchanged since the latest snapshot
+// aren't read again.
+//
+// Backups of the same store must not run concurrently.
+func Backup(ctx context.Context, store Store, dir string) (*Snapshot, error) {
+	infos, err := ListSnapshots(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	previous := map[string]File{}
+	if len(infos) > 0 {
+		last, err := ReadSnapshot(ctx, store, infos[len(infos)-1].ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range last.Files {
+			previous[f.Name] = f
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	snap := &Snapshot{ID: now.Format("20060102T150405.000000000Z"), Time: now}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !backedUp(e.Name()) {
+			continue
+		}
+		f, err := backupFile(ctx, store, filepath.Join(dir, e.Name()), previous[e.Name()])
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted while we were listing.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		snap.Files = append(snap.Files, f)
+	}
+
+	if err := putJSON(ctx, store, snapshotKey(snap.ID), snap); err != nil {
+		return nil, err
+	}
+	infos = append(infos, SnapshotInfo{ID: snap.ID, Time: snap.Time})
+	if err := putJSON(ctx, store, snapshotsKey, infos); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// backupFile uploads the file at path unless its content is stored already.
+// prev is the file of the same name in the previous snapshot.
+func backupFile(ctx context.Context, store Store, path string, prev File) (File, error) {
+	// Shards are replaced by renaming, so the open file stays the same while
+	// we hash and upload it.
+	fd, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	f := File{Name: filepath.Base(path), Size: fi.Size(), ModTime: fi.ModTime().UTC()}
+
+	if prev.SHA256 != "" && prev.Size == f.Size && prev.ModTime.Equal(f.ModTime) {
+		f.SHA256 = prev.SHA256
+		f.Repositories = prev.Repositories
+		return f, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return File{}, err
+	}
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if ok, err := store.Exists(ctx, objectKey(f.SHA256)); err != nil {
+		return File{}, err
+	} else if !ok {
+		if _, err := fd.Seek(0, io.SeekStart); err != nil {
+			return File{}, err
+		}
+		if err := store.Put(ctx, objectKey(f.SHA256), fd); err != nil {
+			return File{}, err
+		}
+	}
+
+	if strings.HasSuffix(f.Name, ".zoekt") {
+		if f.Repositories, err = shardRepositories(fd); err != nil {
+			return File{}, err
+		}
+	}
+	return f, nil
+}
+
+// shardRepositories returns the repositories of the shard fd. It closes fd.
+func shardRepositories(fd *os.File) ([]Repository, error) {
+	indexFile, err := zoekt.NewIndexFile(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+
+	repos, _, err := zoekt.ReadMetadata(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Repository, 0, len(repos))
+	for _, r := range repos {
+		out = append(out, Repository{ID: r.ID, Name: r.Name, Branches: r.Branches, Tombstone: r.Tombstone})
+	}
+	return out, nil
+}
+
+// ListSnapshots returns the snapshots in store, oldest first.
+func ListSnapshots(ctx context.Context, store Store) ([]SnapshotInfo, error) {
+	var infos []SnapshotInfo
+	if err := getJSON(ctx, store, snapshotsKey, &infos); errors.Is(err, ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Time.Before(infos[j].Time) })
+	return infos, nil
+}
+
+// ReadSnapshot returns the snapshot with the given ID.
+func ReadSnapshot(ctx context.Context, store Store, id string) (*Snapshot, error) {
+	var snap Snapshot
+	if err := getJSON(ctx, store, snapshotKey(id), &snap); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+// SnapshotAt returns the ID of the latest snapshot taken at or before t.
+func SnapshotAt(ctx context.Context, store Store, t time.Time) (string, error) {
+	infos, err := ListSnapshots(ctx, store)
+	if err != nil {
+		return "", err
+	}
+	for i := len(infos) - 1; i >= 0; i-- {
+		if !infos[i].Time.After(t) {
+			return infos[i].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no snapshot at or before %s", t.Format(time.RFC3339))
+}
+
+// Restore makes the index directory dir a copy of the snapshot with the
+// given ID. It only downloads files which differ from the files in dir, and
+// deletes the shards which are not in the snapshot. Files are verified
+// before they are moved into place, shards before their metadata overrides.
+func Restore(ctx context.Context, store Store, id, dir string) error {
+	snap, err := ReadSnapshot(ctx, store, id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	want := map[string]bool{}
+	files := append([]File(nil), snap.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	for _, f := range files {
+		want[f.Name] = true
+		if err := restoreFile(ctx, store, dir, f); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && backedUp(e.Name()) && !want[e.Name()] {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func restoreFile(ctx context.Context, store Store, dir string, f File) (err error) {
+	name := filepath.Base(f.Name)
+	path := filepath.Join(dir, name)
+	if sum, err := fileSHA256(path); err == nil && sum == f.SHA256 {
+		return nil
+	}
+
+	r, err := store.Get(ctx, objectKey(f.SHA256))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", sum, f.SHA256)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return os.Chtimes(path, f.ModTime, f.ModTime)
+}
+
+func fileSHA256(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func putJSON(ctx context.Context, store Store, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, strings.NewReader(string(b)))
+}
+
+func getJSON(ctx context.Context, store Store, key string, v any) error {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
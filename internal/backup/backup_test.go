@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingStore counts the uploaded objects.
+type countingStore struct {
+	Store
+	puts int
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if strings.HasPrefix(key, "objects/") {
+		s.puts++
+	}
+	return s.Store.Put(ctx, key, r)
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"repo_v16.00000.zoekt", "repo2_v16.00000.zoekt"} {
+		copyFile(t, filepath.Join("../../testdata/shards", name), filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "indexserver.sock"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{Store: DirStore(t.TempDir())}
+
+	first, err := Backup(ctx, store, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.puts != 2 || len(first.Files) != 2 {
+		t.Fatalf("got %d uploads of %d files, want 2 of 2", store.puts, len(first.Files))
+	}
+	if repos := first.Files[0].Repositories; len(repos) != 1 || repos[0].Name == "" {
+		t.Fatalf("got repositories %+v, want the repository of the shard", repos)
+	}
+	want := readDir(t, dir)
+	delete(want, "indexserver.sock")
+
+	// Only new content is uploaded.
+	if err := os.Remove(filepath.Join(dir, "repo2_v16.00000.zoekt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "repo_v16.00000.zoekt.meta"), []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := Backup(ctx, store, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.puts != 3 || len(second.Files) != 2 {
+		t.Fatalf("got %d uploads of %d files, want 3 of 2", store.puts, len(second.Files))
+	}
+
+	infos, err := ListSnapshots(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]SnapshotInfo{{first.ID, first.Time}, {second.ID, second.Time}}, infos); d != "" {
+		t.Fatalf("snapshots mismatch (-want +got):\n%s", d)
+	}
+	if id, err := SnapshotAt(ctx, store, first.Time); err != nil || id != first.ID {
+		t.Fatalf("SnapshotAt(first) = %q, %v", id, err)
+	}
+	if _, err := SnapshotAt(ctx, store, first.Time.Add(-time.Second)); err == nil {
+		t.Fatal("expected no snapshot before the first")
+	}
+
+	// Restoring the first snapshot removes the metadata overrides, restores
+	// the deleted shard and keeps other files.
+	if err := Restore(ctx, store, first.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+	got := readDir(t, dir)
+	delete(got, "indexserver.sock")
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("restored directory mismatch (-want +got):\n%s", d)
+	}
+
+	// Corrupt objects are not restored.
+	if err := os.WriteFile(filepath.Join(string(store.Store.(DirStore)), objectKey(first.Files[1].SHA256)), []byte("bad"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, store, first.ID, t.TempDir()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("got %v, want checksum mismatch", err)
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(b)
+		case http.MethodGet, http.MethodHead:
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = io.WriteString(w, b)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	s := &HTTPStore{URL: srv.URL + "/bucket/", Header: http.Header{"Authorization": []string{"Bearer token"}}}
+	if err := s.Put(ctx, "objects/a", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Exists(ctx, "objects/a"); err != nil || !ok {
+		t.Fatalf("Exists(objects/a) = %v, %v", ok, err)
+	}
+	if ok, err := s.Exists(ctx, "objects/b"); err != nil || ok {
+		t.Fatalf("Exists(objects/b) = %v, %v", ok, err)
+	}
+	if _, err := s.Get(ctx, "objects/b"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Get(objects/b) = %v, want ErrNotExist", err)
+	}
+	r, err := s.Get(ctx, "objects/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "content" {
+		t.Fatalf("got %q", b)
+	}
+
+	s.Header = nil
+	if err := s.Put(ctx, "objects/c", strings.NewReader("")); err == nil {
+		t.Fatal("expected unauthenticated PUT to fail")
+	}
+}
@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotExist is returned by Store.Get for keys which don't exist.
+var ErrNotExist = errors.New("backup: object does not exist")
+
+// Store is where backups are kept, like a bucket of an object storage. Keys
+// are slash separated paths. Objects are written once and never modified,
+// except for the list of snapshots.
+type Store interface {
+	// Put stores the content of r under key, replacing an existing object.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns the content stored under key, or ErrNotExist.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists returns true if an object is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// DirStore stores objects as files below a directory, for example a local
+// disk or a mounted bucket.
+type DirStore string
+
+func (d DirStore) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d DirStore) Put(ctx context.Context, key string, r io.Reader) (err error) {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d DirStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (d DirStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// HTTPStore stores objects with PUT, GET and HEAD requests below URL. This is
+// the API of most object storages, like the XML API of Google Cloud Storage,
+// given a way to authenticate, like a bearer token in Header.
+type HTTPStore struct {
+	URL string
+
+	// Header is added to every request.
+	Header http.Header
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (s *HTTPStore) do(ctx context.Context, method, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.URL, "/")+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	// Object storages reject uploads of unknown size, and the request only
+	// knows the size of in-memory bodies.
+	if f, ok := body.(*os.File); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() > 0 {
+			req.ContentLength = fi.Size()
+		}
+	}
+	for k, vs := range s.Header {
+		req.Header[k] = vs
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (s *HTTPStore) Put(ctx context.Context, key string, r io.Reader) error {
+	resp, err := s.do(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotExist
+	case resp.StatusCode/100 != 2:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", key, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *HTTPStore) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode/100 != 2:
+		return false, fmt.Errorf("HEAD %s: %s", key, resp.Status)
+	}
+	return true, nil
+}
+
+// NewStore returns the store at location: an HTTPStore for http and https
+// URLs, and a DirStore otherwise. HTTPStores send the value of the
+// environment variable ZOEKT_BACKUP_AUTHORIZATION, if set, as Authorization
+// header.
+func NewStore(location string) Store {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return DirStore(location)
+	}
+	s := &HTTPStore{URL: location}
+	if auth := os.Getenv("ZOEKT_BACKUP_AUTHORIZATION"); auth != "" {
+		s.Header = http.Header{"Authorization": []string{auth}}
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+// Package bloom implements the bloom filter of the content n-grams of a
+// shard. The query planner consults it before the ngram index, so searches
+// for rare literals skip shards which can't contain them with a few random
+// reads, instead of btree lookups.
+package bloom
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GramSize is the number of runes in the n-grams of a filter. It is larger
+// than the ngrams of the index, so the filter rejects literals whose ngrams
+// all occur in a shard, but never next to each other.
+const GramSize = 6
+
+const (
+	// bitsPerRune sizes a filter relative to the text it holds. Source code
+	// repeats a lot, so there are about half as many distinct grams as
+	// runes, which gives around 8 bits per gram and a false positive rate
+	// of a few percent with numHashes.
+	bitsPerRune = 4
+
+	numHashes = 3
+
+	// minBits avoids degenerate filters for tiny shards.
+	minBits = 512
+)
+
+// Filter is a bloom filter of the lowercased GramSize-grams of a text. It can
+// only say whether a pattern may occur in the text, regardless of case.
+type Filter struct {
+	bits []byte
+}
+
+// New returns an empty filter for a text of about runes runes.
+func New(runes int) *Filter {
+	n := max(runes*bitsPerRune, minBits)
+	return &Filter{bits: make([]byte, (n+7)/8)}
+}
+
+// Decode returns the filter encoded by Bytes. The filter shares b.
+func Decode(b []byte) (*Filter, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("bloom: empty filter")
+	}
+	return &Filter{bits: b}, nil
+}
+
+// Bytes returns the encoding of f.
+func (f *Filter) Bytes() []byte {
+	return f.bits
+}
+
+// Add adds the grams of text to f.
+func (f *Filter) Add(text []byte) {
+	forEachGram(text, func(h uint64) {
+		f.set(h)
+	})
+}
+
+// MayContain returns false if pattern occurs in none of the texts added to
+// f, regardless of case. Patterns shorter than GramSize may always be
+// contained.
+func (f *Filter) MayContain(pattern string) bool {
+	may := true
+	forEachGram([]byte(pattern), func(h uint64) {
+		may = may && f.test(h)
+	})
+	return may
+}
+
+func (f *Filter) set(h uint64) {
+	m := uint64(len(f.bits)) * 8
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < numHashes; i++ {
+		b := (h1 + i*h2) % m
+		f.bits[b/8] |= 1 << (b % 8)
+	}
+}
+
+func (f *Filter) test(h uint64) bool {
+	m := uint64(len(f.bits)) * 8
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < numHashes; i++ {
+		b := (h1 + i*h2) % m
+		if f.bits[b/8]&(1<<(b%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forEachGram calls fn with the hash of each lowercased GramSize-gram of
+// text. Text is lowercased like the case insensitive matching of the index
+// does, so invalid UTF-8 becomes utf8.RuneError.
+func forEachGram(text []byte, fn func(h uint64)) {
+	var window [GramSize]rune
+	n := 0
+	for len(text) > 0 {
+		r, sz := utf8.DecodeRune(text)
+		text = text[sz:]
+
+		copy(window[:], window[1:])
+		window[GramSize-1] = unicode.ToLower(r)
+		if n++; n >= GramSize {
+			fn(hashGram(&window))
+		}
+	}
+}
+
+// hashGram returns a 64-bit FNV-1a based hash of the runes of g. The hash is
+// part of the shard format, so it must not change.
+func hashGram(g *[GramSize]rune) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for _, r := range g {
+		for i := 0; i < 4; i++ {
+			h ^= uint64(byte(r >> (8 * i)))
+			h *= prime64
+		}
+	}
+
+	// FNV mixes the last bytes poorly into the high bits, which we use for
+	// the second hash, so finish with the mixer of MurmurHash3.
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
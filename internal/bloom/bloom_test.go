@@ -0,0 +1,63 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	text := []byte("func NewIndexBuilder(r *Repository) (*IndexBuilder, error) {\n\treturn nil, nil\n}\n")
+	f := New(len(text))
+	f.Add(text)
+
+	cases := []struct {
+		pattern string
+		want    bool
+	}{
+		{"IndexBuilder", true},
+		{"newindexbuilder", true},
+		{"RETURN NIL", true},
+		{"nil, nil\n}", true},
+
+		// Shorter than a gram.
+		{"xyz", true},
+		{"", true},
+
+		// All trigrams occur, but not next to each other.
+		{"IndexRepository", false},
+		{"nilnil", false},
+		{"zzzzzzzz", false},
+	}
+	for _, tc := range cases {
+		if got := f.MayContain(tc.pattern); got != tc.want {
+			t.Errorf("MayContain(%q) = %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+
+	g, err := Decode(f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.MayContain("IndexBuilder") || g.MayContain("IndexRepository") {
+		t.Error("decoded filter differs")
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	var text []byte
+	for i := 0; i < 20000; i++ {
+		text = fmt.Appendf(text, "line%d\n", i)
+	}
+	f := New(len(text))
+	f.Add(text)
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if f.MayContain(fmt.Sprintf("absent%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("got %d false positives out of 1000", falsePositives)
+	}
+}
@@ -0,0 +1,169 @@
+// Package canary continuously runs a small suite of known queries against the
+// live index and exports their latency and recall, so that regressions caused
+// by shard growth or page cache pressure show up on dashboards before users
+// complain about them.
+//
+// The suite uses the judgments of package quality: each query comes with the
+// documents it should find. Queries run one at a time at a fixed interval, so
+// the canary adds a small, constant load.
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/quality"
+)
+
+var (
+	metricDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoekt_canary_query_duration_seconds",
+		Help:    "The latency of canary queries against the live index.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"query"})
+	metricRecall = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zoekt_canary_recall",
+		Help: "The fraction of the relevant documents of a canary query found in the top K results of its last run.",
+	}, []string{"query"})
+	metricErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_canary_errors_total",
+		Help: "The number of canary queries which failed.",
+	}, []string{"query"})
+)
+
+// Result is the last run of a canary query.
+type Result struct {
+	Query string
+	Time  time.Time
+
+	Duration time.Duration
+
+	// Recall is the fraction of the relevant documents found in the top K
+	// results, and NDCG scores their ranking, see quality.QueryResult.
+	Recall float64
+	NDCG   float64
+
+	Error string `json:",omitempty"`
+}
+
+// Runner runs the canary queries. The zero value is not usable, set at least
+// Searcher and Judgments.
+type Runner struct {
+	Searcher  zoekt.Searcher
+	Judgments []quality.Judgment
+
+	// Interval is the time between two queries. The whole suite runs once
+	// every len(Judgments) * Interval. It defaults to a minute.
+	Interval time.Duration
+
+	// K is the number of results that are scored. It defaults to 10.
+	K int
+
+	// Timeout limits each query. It defaults to 10 seconds.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	results map[string]Result
+}
+
+// Run runs the queries in turn until ctx is done.
+func (r *Runner) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if len(r.Judgments) == 0 {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for i := 0; ; i = (i + 1) % len(r.Judgments) {
+		r.RunOnce(ctx, r.Judgments[i])
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce runs the query of j, records its result and returns it.
+func (r *Runner) RunOnce(ctx context.Context, j quality.Judgment) Result {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := Result{Query: j.Query, Time: time.Now()}
+	report, err := quality.Evaluate(ctx, r.Searcher, []quality.Judgment{j}, quality.Options{K: r.K})
+	res.Duration = time.Since(res.Time)
+	if err != nil {
+		res.Error = err.Error()
+		metricErrorsTotal.WithLabelValues(j.Query).Inc()
+	} else {
+		qr := report.Queries[0]
+		found := 0
+		for _, rank := range qr.Ranks {
+			if rank > 0 {
+				found++
+			}
+		}
+		if len(qr.Ranks) > 0 {
+			res.Recall = float64(found) / float64(len(qr.Ranks))
+		}
+		res.NDCG = qr.NDCG
+		metricDuration.WithLabelValues(j.Query).Observe(res.Duration.Seconds())
+		metricRecall.WithLabelValues(j.Query).Set(res.Recall)
+	}
+
+	r.mu.Lock()
+	if r.results == nil {
+		r.results = map[string]Result{}
+	}
+	r.results[j.Query] = res
+	r.mu.Unlock()
+	return res
+}
+
+// Results returns the last result of every query that ran, in the order of
+// Judgments.
+func (r *Runner) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var results []Result
+	for _, j := range r.Judgments {
+		if res, ok := r.results[j.Query]; ok {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// Handler serves the last results as JSON. A POST runs the whole suite first,
+// eg. to check a deployment right away.
+func (r *Runner) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			for _, j := range r.Judgments {
+				r.RunOnce(req.Context(), j)
+			}
+		default:
+			http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct{ Results []Result }{r.Results()})
+	})
+}
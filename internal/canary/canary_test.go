@@ -0,0 +1,59 @@
+package canary
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/mockSearcher"
+	"github.com/sourcegraph/zoekt/quality"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestRunner(t *testing.T) {
+	want, err := query.Parse("needle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{
+		Searcher: &mockSearcher.MockSearcher{
+			WantSearch: want,
+			SearchResult: &zoekt.SearchResult{Files: []zoekt.FileMatch{
+				{Repository: "r", FileName: "a.go"},
+				{Repository: "r", FileName: "b.go"},
+			}},
+		},
+		Judgments: []quality.Judgment{
+			{Query: "needle", Relevant: []quality.Relevant{
+				{Doc: quality.Doc{Repo: "r", FileName: "b.go"}},
+				{Doc: quality.Doc{Repo: "r", FileName: "c.go"}},
+			}},
+			{Query: "haystack", Relevant: []quality.Relevant{
+				{Doc: quality.Doc{Repo: "r", FileName: "a.go"}},
+			}},
+		},
+	}
+
+	res := r.RunOnce(context.Background(), r.Judgments[0])
+	if res.Error != "" || res.Recall != 0.5 || res.NDCG == 0 {
+		t.Errorf("got %+v, want half of the relevant documents", res)
+	}
+
+	// The mock only answers "needle".
+	if res := r.RunOnce(context.Background(), r.Judgments[1]); res.Error == "" {
+		t.Errorf("got %+v, want error", res)
+	}
+
+	if got := r.Results(); len(got) != 2 || got[0].Query != "needle" || got[1].Query != "haystack" {
+		t.Errorf("got results %+v", got)
+	}
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/debug/canary", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Recall":0.5`) {
+		t.Errorf("got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,167 @@
+// Package charset detects text in encodings other than UTF-8 and transcodes
+// it to UTF-8, so files in legacy encodings can be indexed like any other
+// source file instead of being skipped as binary.
+//
+// Detection is heuristic and deliberately conservative: content we are not
+// confident about is left alone, and is indexed or skipped as before.
+package charset
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Names of the encodings we detect, as registered with IANA.
+const (
+	UTF16LE     = "UTF-16LE"
+	UTF16BE     = "UTF-16BE"
+	ShiftJIS    = "Shift_JIS"
+	Windows1252 = "windows-1252"
+)
+
+// sniffLen is how many bytes at the start of the content we look at to guess
+// whether it is UTF-16 without a byte order mark.
+const sniffLen = 4096
+
+// Detect returns the name of the encoding of content, or "" if content is
+// UTF-8 or we can't tell. Latin-1 is reported as windows-1252, its superset
+// which browsers use for Latin-1 too.
+func Detect(content []byte) string {
+	if enc := detectUTF16(content); enc != "" {
+		return enc
+	}
+	if utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return ""
+	}
+	if hasControl(content) {
+		return ""
+	}
+	if isShiftJIS(content) {
+		return ShiftJIS
+	}
+	if isLatin1(content) {
+		return Windows1252
+	}
+	return ""
+}
+
+// ToUTF8 returns content transcoded to UTF-8 and the name of the encoding it
+// was detected in. If content is UTF-8 or its encoding is unknown, it is
+// returned unchanged with an empty name.
+func ToUTF8(content []byte) ([]byte, string) {
+	name := Detect(content)
+	if name == "" {
+		return content, ""
+	}
+	out, err := decoder(name).Bytes(content)
+	if err != nil || !utf8.Valid(out) || bytes.IndexByte(out, 0) >= 0 {
+		return content, ""
+	}
+	return out, name
+}
+
+func decoder(name string) *encoding.Decoder {
+	switch name {
+	case UTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case UTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case ShiftJIS:
+		return japanese.ShiftJIS.NewDecoder()
+	default:
+		return charmap.Windows1252.NewDecoder()
+	}
+}
+
+// detectUTF16 recognizes UTF-16 by its byte order mark or, without one, by
+// ASCII text having a zero byte in every other position.
+func detectUTF16(content []byte) string {
+	if len(content) < 2 || len(content)%2 != 0 {
+		return ""
+	}
+	switch {
+	case content[0] == 0xFF && content[1] == 0xFE:
+		return UTF16LE
+	case content[0] == 0xFE && content[1] == 0xFF:
+		return UTF16BE
+	}
+
+	sniff := content[:min(len(content), sniffLen)]
+	var zeros [2]int
+	for i, c := range sniff {
+		if c == 0 {
+			zeros[i%2]++
+		}
+	}
+	// Mostly ASCII text has zeros in nearly all high bytes, and none in the
+	// low bytes. Short ASCII content, eg. "AB", has no zeros at all.
+	need := max(1, len(sniff)/2*9/10)
+	switch {
+	case zeros[1] >= need && zeros[0] == 0:
+		return UTF16LE
+	case zeros[0] >= need && zeros[1] == 0:
+		return UTF16BE
+	}
+	return ""
+}
+
+// hasControl returns true if content contains ASCII control characters other
+// than whitespace and escape, which text files don't.
+func hasControl(content []byte) bool {
+	for _, c := range content {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != 0x1b {
+			return true
+		}
+	}
+	return false
+}
+
+// isShiftJIS returns true if content is well-formed Shift_JIS and contains
+// kana. Most byte sequences which are valid Shift_JIS are also valid
+// windows-1252, but Japanese text practically always has kana, while
+// windows-1252 text rarely has the bytes they are encoded with.
+func isShiftJIS(content []byte) bool {
+	kana := false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c < 0x80, c >= 0xA1 && c <= 0xDF:
+			// ASCII and half-width katakana. We don't count the latter as
+			// kana, since they are accented capitals in windows-1252.
+		case c >= 0x81 && c <= 0x9F, c >= 0xE0 && c <= 0xFC:
+			if i+1 == len(content) {
+				return false
+			}
+			t := content[i+1]
+			if t < 0x40 || t == 0x7F || t > 0xFC {
+				return false
+			}
+			// Hiragana are in row 0x82, katakana in row 0x83.
+			if (c == 0x82 && t >= 0x9F) || (c == 0x83 && t <= 0x96) {
+				kana = true
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return kana
+}
+
+// isLatin1 returns true if the bytes outside of ASCII are a minority of
+// content, as in text in western European languages. Every byte sequence
+// decodes in windows-1252, so this keeps us from transcoding binary data.
+func isLatin1(content []byte) bool {
+	high := 0
+	for _, c := range content {
+		if c >= 0x80 {
+			high++
+		}
+	}
+	return high*4 <= len(content)
+}
@@ -0,0 +1,34 @@
+package charset
+
+import (
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+	cases := []struct {
+		name    string
+		content []byte
+		want    string
+		wantEnc string
+	}{
+		{"utf-8", []byte("héllo wörld\n"), "héllo wörld\n", ""},
+		{"utf-16le bom", []byte("\xff\xfeh\x00i\x00\n\x00"), "hi\n", UTF16LE},
+		{"utf-16be bom", []byte("\xfe\xff\x00h\x00i\x00\n"), "hi\n", UTF16BE},
+		{"utf-16le", []byte("f\x00u\x00n\x00c\x00\n\x00"), "func\n", UTF16LE},
+		{"utf-16be", []byte("\x00f\x00u\x00n\x00c\x00\n"), "func\n", UTF16BE},
+		{"short ascii", []byte("AB"), "AB", ""},
+		{"shift_jis", []byte("// \x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\n"), "// こんにちは\n", ShiftJIS},
+		{"latin-1", []byte("caf\xe9 cr\xe8me br\xfbl\xe9e\n"), "café crème brûlée\n", Windows1252},
+		{"latin-1 capitals", []byte("// \xc4ndern der Gr\xf6\xdfe\n"), "// Ändern der Größe\n", Windows1252},
+		{"binary", []byte("\x7fELF\x02\x01\x01\x00\x00\x00"), "\x7fELF\x02\x01\x01\x00\x00\x00", ""},
+		{"mostly high bytes", []byte("\xe9\xe8\xfb\xe9a"), "\xe9\xe8\xfb\xe9a", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, enc := ToUTF8(tc.content)
+			if string(got) != tc.want || enc != tc.wantEnc {
+				t.Errorf("got %q, %q, want %q, %q", got, enc, tc.want, tc.wantEnc)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Package filemetrics computes simple size and complexity metrics of files at
+// index time, so they can be searched with loc:, nesting: and todos: queries.
+package filemetrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Metrics are the metrics of a single file.
+type Metrics struct {
+	// LOC is the number of non-blank lines.
+	LOC uint32
+	// MaxNesting is the deepest nesting of braces. For files without braces,
+	// like Python or YAML, it is the deepest indentation level instead.
+	MaxNesting uint32
+	// TODOs is the number of TODO and FIXME markers.
+	TODOs uint32
+}
+
+// Names are the metric names, as used by the query language.
+var Names = []string{"loc", "nesting", "todos"}
+
+// Get returns the metric with the given name.
+func (m Metrics) Get(name string) (uint32, bool) {
+	switch name {
+	case "loc":
+		return m.LOC, true
+	case "nesting":
+		return m.MaxNesting, true
+	case "todos":
+		return m.TODOs, true
+	}
+	return 0, false
+}
+
+// EncodedSize is the size of an encoded Metrics.
+const EncodedSize = 12
+
+// Append appends the encoding of m to buf.
+func (m Metrics) Append(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, m.LOC)
+	buf = binary.BigEndian.AppendUint32(buf, m.MaxNesting)
+	return binary.BigEndian.AppendUint32(buf, m.TODOs)
+}
+
+// Decode decodes Metrics encoded with Append.
+func Decode(b []byte) (Metrics, error) {
+	if len(b) != EncodedSize {
+		return Metrics{}, fmt.Errorf("filemetrics: got %d bytes, want %d", len(b), EncodedSize)
+	}
+	return Metrics{
+		LOC:        binary.BigEndian.Uint32(b),
+		MaxNesting: binary.BigEndian.Uint32(b[4:]),
+		TODOs:      binary.BigEndian.Uint32(b[8:]),
+	}, nil
+}
+
+// Compute returns the metrics of content. The nesting depth is approximate:
+// braces in strings and comments are counted too.
+func Compute(content []byte) Metrics {
+	var m Metrics
+	var depth, maxDepth uint32
+	hasBraces := false
+
+	// indents holds the indentation of each non-blank line, as a number of
+	// tabs and a number of spaces.
+	var tabs, spaces []uint32
+	minSpaces := uint32(0)
+
+	for len(content) > 0 {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(bytes.TrimSpace(trimmed)) == 0 {
+			continue
+		}
+		m.LOC++
+
+		var t, s uint32
+		for _, c := range line[:len(line)-len(trimmed)] {
+			if c == '\t' {
+				t++
+			} else {
+				s++
+			}
+		}
+		tabs, spaces = append(tabs, t), append(spaces, s)
+		if s > 0 && (minSpaces == 0 || s < minSpaces) {
+			minSpaces = s
+		}
+
+		for _, c := range trimmed {
+			switch c {
+			case '{':
+				hasBraces = true
+				depth++
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+			case '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+		}
+
+		m.TODOs += countMarker(trimmed, "TODO") + countMarker(trimmed, "FIXME")
+	}
+
+	if hasBraces {
+		m.MaxNesting = maxDepth
+		return m
+	}
+
+	// We take the smallest indentation with spaces as the unit of
+	// indentation, and count each tab as one level.
+	for i := range tabs {
+		level := tabs[i]
+		if minSpaces > 0 {
+			level += spaces[i] / minSpaces
+		}
+		if level > m.MaxNesting {
+			m.MaxNesting = level
+		}
+	}
+	return m
+}
+
+// countMarker counts the occurrences of marker in line that are not part of
+// a longer word, so TODOS or MYTODO don't count.
+func countMarker(line []byte, marker string) uint32 {
+	var n uint32
+	for off := 0; ; {
+		i := bytes.Index(line[off:], []byte(marker))
+		if i < 0 {
+			return n
+		}
+		start, end := off+i, off+i+len(marker)
+		if (start == 0 || !isWordByte(line[start-1])) && (end == len(line) || !isWordByte(line[end])) {
+			n++
+		}
+		off = end
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
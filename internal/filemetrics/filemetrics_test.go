@@ -0,0 +1,85 @@
+package filemetrics
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompute(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    Metrics
+	}{{
+		name:    "empty",
+		content: "",
+		want:    Metrics{},
+	}, {
+		name: "braces",
+		content: `package main
+
+func main() {
+	for {
+		if x { // TODO: fix
+		}
+	}
+}
+`,
+		want: Metrics{LOC: 7, MaxNesting: 3, TODOs: 1},
+	}, {
+		name: "unbalanced braces",
+		content: `}}
+{
+`,
+		want: Metrics{LOC: 2, MaxNesting: 1},
+	}, {
+		name: "indentation",
+		content: `def f():
+  if x:
+    # FIXME(someone): TODO TODOS MYTODO
+    return 1
+
+  return 2
+`,
+		want: Metrics{LOC: 5, MaxNesting: 2, TODOs: 2},
+	}, {
+		name:    "tabs",
+		content: "a:\n\tb:\n\t\tc\n",
+		want:    Metrics{LOC: 3, MaxNesting: 2},
+	}, {
+		name:    "no trailing newline",
+		content: "a\n  \nb",
+		want:    Metrics{LOC: 2},
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Compute([]byte(tc.content))
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m := Metrics{LOC: 1 << 20, MaxNesting: 7, TODOs: 3}
+	got, err := Decode(m.Append(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != m {
+		t.Errorf("got %v, want %v", got, m)
+	}
+
+	if _, err := Decode([]byte{1, 2, 3}); err == nil {
+		t.Error("Decode of short input succeeded")
+	}
+
+	for _, name := range Names {
+		if _, ok := m.Get(name); !ok {
+			t.Errorf("Get(%q) failed", name)
+		}
+	}
+}
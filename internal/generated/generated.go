@@ -0,0 +1,134 @@
+// Package generated guesses whether files are generated or minified, so we can
+// tag them at index time and rank them below handwritten code.
+package generated
+
+import (
+	"bytes"
+
+	"github.com/go-enry/go-enry/v2"
+)
+
+// headerLines is how many lines at the start of a file we search for
+// generated markers.
+const headerLines = 10
+
+// markers are strings in the header comment of a file which tools put there
+// to say the file is generated. go-enry knows many tool specific headers; these are
+// the generic ones.
+var markers = [][]byte{
+	[]byte("@generated"),
+	[]byte("DO NOT EDIT"),
+	[]byte("do not edit"),
+	[]byte("Do not edit"),
+	[]byte("auto-generated"),
+	[]byte("autogenerated"),
+	[]byte("Auto-generated"),
+	[]byte("Autogenerated"),
+}
+
+const (
+	// minifiedAvgLineLength is the average line length above which we
+	// consider a file minified, like linguist does for JavaScript and CSS.
+	minifiedAvgLineLength = 110
+
+	// minifiedMinSize avoids tagging small files with a few long lines.
+	minifiedMinSize = 1024
+)
+
+// Is returns true if the file at path with content looks generated: go-enry
+// says so, its header comment contains a marker like @generated or "DO NOT
+// EDIT", or it looks minified because its lines are very long on average.
+func Is(path string, content []byte) bool {
+	return enry.IsGenerated(path, content) || hasMarker(content) || isMinified(content)
+}
+
+func hasMarker(content []byte) bool {
+	h := header(content)
+	for _, m := range markers {
+		if bytes.Contains(h, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineComments start comment lines. "#" must be followed by a space or "!",
+// so that C preprocessor directives like #define don't count.
+var lineComments = [][]byte{
+	[]byte("//"),
+	[]byte("# "),
+	[]byte("#!"),
+	[]byte("--"),
+	[]byte(";"),
+	[]byte("%"),
+}
+
+// blockComments are the start and end of block comments.
+var blockComments = [][2][]byte{
+	{[]byte("/*"), []byte("*/")},
+	{[]byte("<!--"), []byte("-->")},
+}
+
+// header returns the comment block at the start of content: the leading
+// comment and blank lines, up to headerLines lines. Markers in code, like a
+// string constant "DO NOT EDIT", are not in the header.
+func header(content []byte) []byte {
+	end := 0
+	var blockEnd []byte // end of the block comment we are in, if any
+	for i := 0; i < headerLines && end < len(content); i++ {
+		next := len(content)
+		if j := bytes.IndexByte(content[end:], '\n'); j >= 0 {
+			next = end + j + 1
+		}
+		line := bytes.TrimSpace(content[end:next])
+
+		if blockEnd == nil {
+			switch {
+			case len(line) == 0 || bytes.Equal(line, []byte("#")):
+			case hasAnyPrefix(line, lineComments):
+			default:
+				for _, bc := range blockComments {
+					if bytes.HasPrefix(line, bc[0]) {
+						blockEnd, line = bc[1], line[len(bc[0]):]
+						break
+					}
+				}
+				if blockEnd == nil {
+					// Code starts here.
+					return content[:end]
+				}
+			}
+		}
+		if blockEnd != nil {
+			if k := bytes.Index(line, blockEnd); k >= 0 {
+				blockEnd = nil
+				if len(bytes.TrimSpace(line[k+len(blockEnd):])) > 0 {
+					// Code follows the comment on the same line.
+					return content[:next]
+				}
+			}
+		}
+		end = next
+	}
+	return content[:end]
+}
+
+func hasAnyPrefix(b []byte, prefixes [][]byte) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(b, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMinified(content []byte) bool {
+	if len(content) < minifiedMinSize {
+		return false
+	}
+	lines := bytes.Count(content, []byte{'\n'})
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return len(content)/lines > minifiedAvgLineLength
+}
@@ -0,0 +1,34 @@
+package generated
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIs(t *testing.T) {
+	minified := strings.Repeat("var a=1;", 200)
+	cases := []struct {
+		name    string
+		path    string
+		content string
+		want    bool
+	}{
+		{"plain", "main.go", "package main\n\nfunc main() {}\n", false},
+		{"go header", "api.pb.go", "// Code generated by protoc-gen-go. DO NOT EDIT.\npackage api\n", true},
+		{"@generated", "Schema.java", "/**\n * @generated SignedSource<<abc>>\n */\nclass Schema {}\n", true},
+		{"do not edit", "config.h", "/* This file is autogenerated, do not edit. */\n#define X 1\n", true},
+		{"marker after header", "notes.txt", strings.Repeat("line\n", 20) + "@generated\n", false},
+		{"minified", "bundle.mjs", minified, true},
+		{"minified css", "style.css", minified, true},
+		{"short long line", "README.md", strings.Repeat("x", 500), false},
+		{"long lines and many short ones", "data.txt", strings.Repeat("x", 2000) + strings.Repeat("\n", 100), false},
+		{"vendored lockfile", "package-lock.json", "{}\n", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Is(tc.path, []byte(tc.content)); got != tc.want {
+				t.Errorf("Is(%q) = %v, want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
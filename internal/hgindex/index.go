@@ -0,0 +1,244 @@
+// Package hgindex provides indexing of Mercurial repositories.
+//
+// It runs the hg command line client to resolve branches and bookmarks, and
+// reads files with "hg archive", so it needs no extensions on the server.
+// Files that are the same on several branches are indexed once, like in
+// gitindex.
+package hgindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// Options specify the Mercurial specific indexing options.
+type Options struct {
+	Incremental bool
+
+	// RepoDir is the directory of the repository.
+	RepoDir string
+
+	// Branches are the branches or bookmarks to index. It defaults to
+	// "default".
+	Branches []string
+
+	// Name is the repository name. It defaults to the host and path of the
+	// default path of the repository, or else the name of RepoDir.
+	Name    string
+	RepoURL string
+
+	// Hg is the hg binary. It defaults to "hg".
+	Hg string
+}
+
+func (o *Options) SetDefaults() {
+	if len(o.Branches) == 0 {
+		o.Branches = []string{"default"}
+	}
+	if o.Hg == "" {
+		o.Hg = "hg"
+	}
+}
+
+// revision is a resolved branch or bookmark.
+type revision struct {
+	branch string
+	node   string
+	date   time.Time
+}
+
+// file is one version of a file, which may be on several branches.
+type file struct {
+	name     string
+	content  []byte
+	branches []string
+}
+
+// IndexHgRepo indexes the repository specified in opts using bopts.
+func IndexHgRepo(opts Options, bopts build.Options) error {
+	opts.SetDefaults()
+
+	if opts.Name == "" {
+		name, err := defaultName(opts)
+		if err != nil {
+			return err
+		}
+		opts.Name = name
+	}
+
+	var revs []revision
+	for _, b := range opts.Branches {
+		rev, err := resolve(opts, b)
+		if err != nil {
+			return err
+		}
+		revs = append(revs, rev)
+	}
+
+	bopts.RepositoryDescription.Name = opts.Name
+	bopts.RepositoryDescription.URL = opts.RepoURL
+	bopts.RepositoryDescription.Source = opts.RepoDir
+	bopts.RepositoryDescription.Branches = nil
+	for _, rev := range revs {
+		bopts.RepositoryDescription.Branches = append(bopts.RepositoryDescription.Branches, zoekt.RepositoryBranch{Name: rev.branch, Version: rev.node})
+		if rev.date.After(bopts.RepositoryDescription.LatestCommitDate) {
+			bopts.RepositoryDescription.LatestCommitDate = rev.date
+		}
+	}
+	bopts.SetDefaults()
+
+	if opts.Incremental && bopts.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	// Collect the distinct versions of each file across branches.
+	var files []*file
+	versions := map[string]*file{}
+	for _, rev := range revs {
+		err := archive(opts, rev.node, func(name string, content []byte) {
+			key := fmt.Sprintf("%s\x00%x", name, sha1.Sum(content))
+			f, ok := versions[key]
+			if !ok {
+				f = &file{name: name, content: content}
+				versions[key] = f
+				files = append(files, f)
+			}
+			f.branches = append(f.branches, rev.branch)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	builder, err := build.NewBuilder(bopts)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := builder.Add(zoekt.Document{
+			Name:     f.name,
+			Content:  f.content,
+			Branches: f.branches,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return builder.Finish()
+}
+
+// defaultName returns the host and path of the default path of the
+// repository, or the name of its directory if it has none.
+func defaultName(opts Options) (string, error) {
+	out, err := hg(opts, "paths", "default")
+	if err == nil {
+		if u, err := url.Parse(strings.TrimSpace(string(out))); err == nil && u.Host != "" {
+			return filepath.Join(u.Host, strings.TrimSuffix(u.Path, "/")), nil
+		}
+	}
+
+	abs, err := filepath.Abs(opts.RepoDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(abs), nil
+}
+
+// resolve returns the revision that branch, a branch or bookmark name, points
+// to.
+func resolve(opts Options, branch string) (revision, error) {
+	out, err := hg(opts, "log", "-r", branch, "--template", "{node} {date|hgdate}\n")
+	if err != nil {
+		return revision{}, err
+	}
+
+	// hgdate is "UNIXTIME OFFSET".
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return revision{}, fmt.Errorf("hg log -r %s: unexpected output %q", branch, out)
+	}
+	secs, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return revision{}, fmt.Errorf("hg log -r %s: invalid date %q", branch, fields[1])
+	}
+	return revision{branch: branch, node: fields[0], date: time.Unix(secs, 0)}, nil
+}
+
+// archive calls f with the name and content of each file at node.
+func archive(opts Options, node string, f func(name string, content []byte)) error {
+	// Archives get a prefix directory, which we strip, and we leave out the
+	// .hg_archival.txt metadata file.
+	cmd := exec.Command(opts.Hg, "--config", "ui.archivemeta=false", "-R", opts.RepoDir,
+		"archive", "-r", node, "-t", "tar", "-p", "root", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = readTar(stdout, f)
+	if err != nil {
+		_ = cmd.Process.Kill()
+	}
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("hg archive -r %s: %w", node, err)
+	}
+	return nil
+}
+
+func readTar(r io.Reader, f func(name string, content []byte)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || name == "" {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		f(name, content)
+	}
+}
+
+func hg(opts Options, args ...string) ([]byte, error) {
+	cmd := exec.Command(opts.Hg, append([]string{"-R", opts.RepoDir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("hg %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
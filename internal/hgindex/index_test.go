@@ -0,0 +1,116 @@
+package hgindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func TestReadTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "root/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "root/a.go", Typeflag: tar.TypeReg, Mode: 0o644, Size: 10},
+		{Name: "root/link", Typeflag: tar.TypeSymlink, Linkname: "a.go"},
+		{Name: "root/dir/b.go", Typeflag: tar.TypeReg, Mode: 0o644, Size: 10},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			_, _ = tw.Write([]byte("package x\n"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := readTar(&buf, func(name string, content []byte) {
+		got = append(got, name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"a.go", "dir/b.go"}, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestIndexHgRepo(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg not found")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("hg", append([]string{"--config", "ui.username=test <test@example.com>"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "HGPLAIN=1", "HGRCPATH=")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("hg %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init")
+	write("common.txt", "shared needle\n")
+	write("main.go", "package main // needle v1\n")
+	run("commit", "-A", "-m", "first")
+	run("bookmark", "--inactive", "release")
+	write("main.go", "package main // needle v2\n")
+	run("commit", "-m", "second")
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir:  dir,
+		Branches: []string{"default", "release"},
+		Name:     "repo",
+	}
+	if err := IndexHgRepo(opts, build.Options{IndexDir: indexDir, DisableCTags: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		sort.Strings(f.Branches)
+		got = append(got, f.FileName+" "+string(f.LineMatches[0].Line)+" "+f.Branches[0]+","+f.Branches[len(f.Branches)-1])
+	}
+	sort.Strings(got)
+	want := []string{
+		"common.txt shared needle default,release",
+		"main.go package main // needle v1 release,release",
+		"main.go package main // needle v2 default,default",
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
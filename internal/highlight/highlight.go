@@ -0,0 +1,98 @@
+// Package highlight tokenizes source code with chroma, so search results can
+// carry syntax highlighting for clients which don't highlight themselves.
+//
+// Tokens are classified with the short CSS class names of Pygments, eg. "k"
+// for keywords or "s" for strings, so any Pygments or chroma stylesheet can
+// render them.
+package highlight
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// MaxBytes is the size of the largest snippet we tokenize. chroma lexers
+// are backtracking regular expressions, so we don't feed them arbitrary
+// amounts of text.
+const MaxBytes = 64 << 10
+
+// lexersByLanguage caches the lexer of a language, nil if chroma doesn't
+// know it. Looking lexers up by name falls back to matching file patterns
+// of all lexers, which is too slow to do for every snippet.
+var lexersByLanguage sync.Map // string -> chroma.Lexer
+
+// lexer returns the lexer for the language detected at index time, or the
+// lexer matching filename if chroma doesn't know the language.
+func lexer(language, filename string) chroma.Lexer {
+	if language != "" {
+		l, ok := lexersByLanguage.Load(language)
+		if !ok {
+			l, _ = lexersByLanguage.LoadOrStore(language, lexers.Get(language))
+		}
+		if l := l.(chroma.Lexer); l != nil {
+			return l
+		}
+	}
+	return lexers.Match(filename)
+}
+
+// Tokenize calls emit for the tokens of src, in order. Start and end are
+// byte offsets into src. Adjacent tokens of the same class are merged, and
+// plain text, whitespace and input the lexer couldn't tokenize are left out.
+//
+// The lexer is chosen by language, the language detected at index time, and
+// by filename. Nothing is emitted if there is no lexer for the file, or if src
+// is larger than MaxBytes or isn't valid UTF-8.
+//
+// src is tokenized on its own. Tokens of snippets which start inside of a
+// construct spanning several lines, like a block comment, may be
+// misclassified.
+func Tokenize(language, filename string, src []byte, emit func(start, end int, class string)) {
+	if len(src) == 0 || len(src) > MaxBytes || !utf8.Valid(src) {
+		return
+	}
+	l := lexer(language, filename)
+	if l == nil {
+		return
+	}
+	// chroma's default options rewrite \r\n to \n, which would shift the
+	// offsets of all tokens after the first CRLF line break.
+	it, err := chroma.Coalesce(l).Tokenise(&chroma.TokeniseOptions{State: "root"}, string(src))
+	if err != nil {
+		return
+	}
+
+	start, end, last := 0, 0, ""
+	for t := it(); t != chroma.EOF; t = it() {
+		tokStart := end
+		end += len(t.Value)
+		class := class(t.Type)
+		if class == last && class != "" {
+			continue
+		}
+		if last != "" {
+			emit(start, min(tokStart, len(src)), last)
+		}
+		start, last = tokStart, class
+	}
+	// Some lexers append a newline to src.
+	if last != "" && start < len(src) {
+		emit(start, min(end, len(src)), last)
+	}
+}
+
+// class returns the CSS class of t, or "" if t isn't highlighted.
+func class(t chroma.TokenType) string {
+	if t == chroma.Error || t.InCategory(chroma.Text) {
+		return ""
+	}
+	for ; t != 0; t = t.Parent() {
+		if c, ok := chroma.StandardTypes[t]; ok {
+			return c
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package highlight
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type token struct {
+	Text, Class string
+}
+
+func tokenize(language, filename, src string) []token {
+	var toks []token
+	Tokenize(language, filename, []byte(src), func(start, end int, class string) {
+		toks = append(toks, token{src[start:end], class})
+	})
+	return toks
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Go", "main.go", "func main() { return \"hi\" } // done")
+	want := []token{
+		{"func", "kd"},
+		{"main", "nf"},
+		{"()", "p"},
+		{"{", "p"},
+		{"return", "k"},
+		{`"hi"`, "s"},
+		{"}", "p"},
+		{"// done", "c1"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Without a known language, the lexer is chosen by file name.
+	if got := tokenize("", "x.py", "def f(): pass"); len(got) == 0 || got[0] != (token{"def", "k"}) {
+		t.Fatalf("got %v for python", got)
+	}
+
+	// Offsets of CRLF content are offsets into the original bytes.
+	got = tokenize("Go", "main.go", "x := 1\r\ny := 2\r\nreturn \"str\"\r\n")
+	want = []token{
+		{"x", "nx"},
+		{":=", "o"},
+		{"1", "mi"},
+		{"y", "nx"},
+		{":=", "o"},
+		{"2", "mi"},
+		{"return", "k"},
+		{`"str"`, "s"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("CRLF mismatch (-want +got):\n%s", diff)
+	}
+
+	for name, toks := range map[string][]token{
+		"unknown": tokenize("", "README", "func main() {}"),
+		"invalid": tokenize("Go", "main.go", "func \xff() {}"),
+		"empty":   tokenize("Go", "main.go", ""),
+	} {
+		if len(toks) != 0 {
+			t.Errorf("%s: got tokens %v", name, toks)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package lease
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTimeLayout is the layout of MicroTime fields of Kubernetes objects.
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesTimeout bounds a single request to the API server.
+const kubernetesTimeout = 10 * time.Second
+
+// kubernetesLeaser is a Leaser backed by Lease objects of the
+// coordination.k8s.io/v1 API. It speaks just enough of the API to get,
+// create, update and list leases in a single namespace. Updates carry the
+// resource version they were based on, so of two replicas racing for a
+// lease only one succeeds.
+type kubernetesLeaser struct {
+	client    *http.Client
+	baseURL   string
+	namespace string
+
+	// token returns the bearer token of requests. It is read for every
+	// request since Kubernetes rotates it.
+	token func() (string, error)
+
+	now func() time.Time
+}
+
+// NewKubernetesLeaser returns a Leaser which keeps leases as Lease objects in
+// namespace, using the service account of the pod it runs in. If namespace is
+// empty, it uses the namespace of the pod. The service account needs the
+// permissions to get, list, create and update leases.
+func NewKubernetesLeaser(namespace string) (Leaser, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	if namespace == "" {
+		b, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("reading the namespace of the pod: %w", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading the certificate of the API server: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in the service account ca.crt")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   kubernetesTimeout,
+	}
+	token := func() (string, error) {
+		b, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		return strings.TrimSpace(string(b)), err
+	}
+	return newKubernetesLeaser(client, "https://"+net.JoinHostPort(host, port), namespace, token), nil
+}
+
+func newKubernetesLeaser(client *http.Client, baseURL, namespace string, token func() (string, error)) *kubernetesLeaser {
+	return &kubernetesLeaser{
+		client:    client,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		namespace: namespace,
+		token:     token,
+		now:       time.Now,
+	}
+}
+
+// k8sLease is the subset of a coordination.k8s.io/v1 Lease we use.
+type k8sLease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+	Spec       k8sLeaseSpec  `json:"spec"`
+}
+
+type k8sObjectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type k8sLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+type k8sLeaseList struct {
+	Items []k8sLease `json:"items"`
+}
+
+// expired returns true if nobody holds l as of now.
+func (l *k8sLease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(microTimeLayout, l.Spec.RenewTime)
+	if err != nil {
+		// Without a valid renew time, we can't tell when the lease
+		// expires, so we treat it as expired.
+		return true
+	}
+	return !now.Before(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// k8sStatusError is the error of a request the API server rejected.
+type k8sStatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *k8sStatusError) Error() string {
+	return fmt.Sprintf("kubernetes API: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// isStatus returns true if err is a k8sStatusError with code.
+func isStatus(err error, code int) bool {
+	var statusErr *k8sStatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+func (k *kubernetesLeaser) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := k.now()
+	renewTime := now.UTC().Format(microTimeLayout)
+	seconds := int(math.Ceil(ttl.Seconds()))
+
+	var l k8sLease
+	err := k.do(ctx, http.MethodGet, k.leasesPath()+"/"+url.PathEscape(name), nil, &l)
+	if isStatus(err, http.StatusNotFound) {
+		l = k8sLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   k8sObjectMeta{Name: name},
+			Spec: k8sLeaseSpec{
+				HolderIdentity:       holder,
+				LeaseDurationSeconds: seconds,
+				AcquireTime:          renewTime,
+				RenewTime:            renewTime,
+			},
+		}
+		err := k.do(ctx, http.MethodPost, k.leasesPath(), &l, nil)
+		if isStatus(err, http.StatusConflict) {
+			// Another replica created it first.
+			return false, nil
+		}
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	if l.Spec.HolderIdentity != holder {
+		if !l.expired(now) {
+			return false, nil
+		}
+		l.Spec.HolderIdentity = holder
+		l.Spec.AcquireTime = renewTime
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.LeaseDurationSeconds = seconds
+	l.Spec.RenewTime = renewTime
+
+	err = k.do(ctx, http.MethodPut, k.leasesPath()+"/"+url.PathEscape(name), &l, nil)
+	if isStatus(err, http.StatusConflict) {
+		// The lease changed since we read it.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (k *kubernetesLeaser) Holders(ctx context.Context, prefix string) ([]string, error) {
+	var list k8sLeaseList
+	if err := k.do(ctx, http.MethodGet, k.leasesPath(), nil, &list); err != nil {
+		return nil, err
+	}
+
+	now := k.now()
+	var holders []string
+	for i := range list.Items {
+		l := &list.Items[i]
+		if strings.HasPrefix(l.Metadata.Name, prefix) && !l.expired(now) {
+			holders = append(holders, l.Spec.HolderIdentity)
+		}
+	}
+	slices.Sort(holders)
+	return slices.Compact(holders), nil
+}
+
+func (k *kubernetesLeaser) leasesPath() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(k.namespace) + "/leases"
+}
+
+// do sends a request with the JSON encoding of in, if not nil, to path and
+// decodes the response into out, if not nil.
+func (k *kubernetesLeaser) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, k.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := k.token()
+	if err != nil {
+		return fmt.Errorf("reading the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// The body is a Status object, whose message is all we need.
+		var status struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&status)
+		return &k8sStatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
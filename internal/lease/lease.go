@@ -0,0 +1,74 @@
+// Package lease lets replicas of a service find each other through leases:
+// named locks which expire unless their holder renews them. Each replica
+// holds a lease named after itself, and the holders of the unexpired leases
+// are the replicas which are alive.
+package lease
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Leaser grants leases. Leasers are used concurrently.
+type Leaser interface {
+	// Acquire takes the lease called name for holder, or renews it if
+	// holder already has it, until ttl from now. It returns false if another
+	// holder has the lease and it hasn't expired.
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+
+	// Holders returns the sorted holders of the unexpired leases whose
+	// names start with prefix.
+	Holders(ctx context.Context, prefix string) ([]string, error)
+}
+
+// memoryLeaser is a Leaser for the replicas within a process, mostly for
+// tests.
+type memoryLeaser struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	holder  string
+	expires time.Time
+}
+
+// NewMemoryLeaser returns a Leaser which keeps leases in memory.
+func NewMemoryLeaser() Leaser {
+	return &memoryLeaser{
+		now:    time.Now,
+		leases: map[string]memoryLease{},
+	}
+}
+
+func (m *memoryLeaser) Acquire(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if l, ok := m.leases[name]; ok && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLeaser) Holders(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var holders []string
+	for name, l := range m.leases {
+		if strings.HasPrefix(name, prefix) && now.Before(l.expires) {
+			holders = append(holders, l.holder)
+		}
+	}
+	slices.Sort(holders)
+	return slices.Compact(holders), nil
+}
@@ -0,0 +1,170 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLeaser runs the tests every Leaser must pass. advance moves the clock
+// of l forward.
+func testLeaser(t *testing.T, l Leaser, advance func(time.Duration)) {
+	t.Helper()
+	ctx := context.Background()
+
+	acquire := func(name, holder string, want bool) {
+		t.Helper()
+		got, err := l.Acquire(ctx, name, holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Acquire(%q, %q) = %v, want %v", name, holder, got, want)
+		}
+	}
+	holders := func(want ...string) {
+		t.Helper()
+		got, err := l.Holders(ctx, "zoekt-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("got holders %v, want %v", got, want)
+		}
+	}
+
+	acquire("zoekt-a", "a", true)
+	acquire("zoekt-b", "b", true)
+	acquire("other-c", "c", true)
+	holders("a", "b")
+
+	// A lease can be renewed by its holder, but not taken by others.
+	acquire("zoekt-a", "a", true)
+	acquire("zoekt-a", "b", false)
+
+	// Once a lease expires, it can be taken.
+	advance(45 * time.Second)
+	acquire("zoekt-a", "a", true)
+	advance(45 * time.Second)
+	holders("a")
+	acquire("zoekt-b", "a", true)
+	holders("a")
+}
+
+func TestMemoryLeaser(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewMemoryLeaser().(*memoryLeaser)
+	l.now = func() time.Time { return now }
+	testLeaser(t, l, func(d time.Duration) { now = now.Add(d) })
+}
+
+// fakeAPIServer serves the Lease API of a single namespace from memory.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	leases  map[string]k8sLease
+	version int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	const prefix = "/apis/coordination.k8s.io/v1/namespaces/zoekt/leases"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(code int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	status := func(code int, msg string) {
+		reply(code, map[string]string{"kind": "Status", "message": msg})
+	}
+	store := func(l k8sLease) k8sLease {
+		f.version++
+		l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.leases[l.Metadata.Name] = l
+		return l
+	}
+
+	var in k8sLease
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&in)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		var list k8sLeaseList
+		for _, l := range f.leases {
+			list.Items = append(list.Items, l)
+		}
+		reply(http.StatusOK, list)
+	case r.Method == http.MethodGet:
+		l, ok := f.leases[name]
+		if !ok {
+			status(http.StatusNotFound, "not found")
+			return
+		}
+		reply(http.StatusOK, l)
+	case r.Method == http.MethodPost && name == "":
+		if _, ok := f.leases[in.Metadata.Name]; ok {
+			status(http.StatusConflict, "already exists")
+			return
+		}
+		reply(http.StatusCreated, store(in))
+	case r.Method == http.MethodPut:
+		if l, ok := f.leases[name]; !ok || l.Metadata.ResourceVersion != in.Metadata.ResourceVersion {
+			status(http.StatusConflict, "the object has been modified")
+			return
+		}
+		reply(http.StatusOK, store(in))
+	default:
+		status(http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func TestKubernetesLeaser(t *testing.T) {
+	api := &fakeAPIServer{leases: map[string]k8sLease{}}
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newKubernetesLeaser(ts.Client(), ts.URL, "zoekt", func() (string, error) { return "secret", nil })
+	l.now = func() time.Time { return now }
+	testLeaser(t, l, func(d time.Duration) { now = now.Add(d) })
+
+	if got := api.leases["zoekt-b"].Spec.LeaseTransitions; got != 1 {
+		t.Errorf("got %d transitions of zoekt-b, want 1", got)
+	}
+
+	// Updates based on an old version of a lease fail.
+	stale := api.leases["zoekt-a"]
+	if _, err := l.Acquire(context.Background(), "zoekt-a", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	err := l.do(context.Background(), http.MethodPut, l.leasesPath()+"/zoekt-a", &stale, nil)
+	if !isStatus(err, http.StatusConflict) {
+		t.Errorf("got %v for a stale update, want a conflict", err)
+	}
+
+	// Errors of the API server are reported.
+	l.token = func() (string, error) { return "wrong", nil }
+	if _, err := l.Holders(context.Background(), "zoekt-"); !isStatus(err, http.StatusUnauthorized) {
+		t.Errorf("got %v with a wrong token, want unauthorized", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package listener creates the listeners of zoekt-webserver.
+//
+// Besides TCP addresses (including IPv6 addresses like "[::1]:6070") it
+// listens on unix domain sockets, for sidecars, and it can decode PROXY
+// protocol headers sent by L4 load balancers, so that requests see the
+// address of the original client instead of the load balancer.
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unixPrefix marks addresses which are paths of unix domain sockets.
+const unixPrefix = "unix:"
+
+// Split returns the network and address to listen on or dial for addr, which
+// is either a TCP address or "unix:" followed by a socket path.
+func Split(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// Listen listens on addr, see Split. A socket file left behind by a previous
+// process is removed first.
+func Listen(addr string) (net.Listener, error) {
+	network, address := Split(addr)
+	if network == "unix" {
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// Dial returns a DialContext function for http.Transport which connects to
+// addr, see Split, whatever address is requested. If proxyProtocol is true, it
+// sends a PROXY protocol header without addresses, so that listeners wrapped
+// with ProxyProtocol accept the connection. The webserver uses it for its
+// health checks.
+func Dial(addr string, proxyProtocol bool) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	network, address := Split(addr)
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil || !proxyProtocol {
+			return conn, err
+		}
+		if _, err := io.WriteString(conn, "PROXY UNKNOWN\r\n"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// ProxyProtocol wraps l so that every connection must start with a PROXY
+// protocol header, version 1 or 2. The RemoteAddr of a connection is the
+// source address of the header. Connections without a valid header within
+// timeout are closed on their first read.
+//
+// Only use this if all clients connect through a proxy, since anyone who can
+// connect directly can claim any source address.
+func ProxyProtocol(l net.Listener, timeout time.Duration) net.Listener {
+	return &proxyListener{Listener: l, timeout: timeout}
+}
+
+type proxyListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// We read the header on first use, so a slow client doesn't block the
+	// accept loop.
+	return &proxyConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readHeader(c.r)
+		if c.err != nil {
+			// Close the connection, so we don't answer clients which bypass
+			// the proxy.
+			c.err = fmt.Errorf("PROXY protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+			_ = c.Conn.Close()
+			return
+		}
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Time{})
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errNoHeader = errors.New("missing header")
+)
+
+// readHeader reads a PROXY protocol header from r and returns the source
+// address it contains. It returns a nil address for headers without one, like
+// health checks of the proxy.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	// The shortest v1 header is "PROXY UNKNOWN\r\n", so both versions are at
+	// least as long as the v2 signature.
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, errNoHeader
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, v1Prefix):
+		return readV1(r)
+	default:
+		return nil, errNoHeader
+	}
+}
+
+// readV1 reads a header like "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes, including the CRLF.
+	const maxLen = 107
+
+	var line []byte
+	for len(line) < maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", s)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid v1 source address %q", s)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 reads a binary header. See section 2.2 of
+// https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// The LOCAL command is used by the proxy itself, eg. for health checks.
+	if hdr[12]&0xf == 0 {
+		return nil, nil
+	}
+
+	// Addresses are followed by optional TLVs, which we ignore.
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		// Unspecified or unix sockets: keep the address of the connection.
+		return nil, nil
+	}
+}
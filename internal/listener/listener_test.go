@@ -0,0 +1,140 @@
+package listener
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, l net.Listener) string {
+	t.Helper()
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String()
+}
+
+// get sends header and then a request over a new connection to addr, and
+// returns the body of the response.
+func get(t *testing.T, network, addr string, header []byte) (string, error) {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(append(header, "GET / HTTP/1.0\r\n\r\n"...)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	_, body, _ := strings.Cut(string(resp), "\r\n\r\n")
+	return body, nil
+}
+
+func v2Header(cmd byte, fam byte, addrs []byte) []byte {
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, ProxyProtocol(l, time.Second))
+
+	ipv4 := []byte{
+		192, 0, 2, 1, // source
+		192, 0, 2, 2, // destination
+		0xdc, 0x04, // source port 56324
+		0x01, 0xbb, // destination port 443
+	}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[32:], 56324)
+
+	cases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"v1 IPv4", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", "192.0.2.1:56324"},
+		{"v1 IPv6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "127.0.0.1:"},
+		{"v2 IPv4", string(v2Header(1, 0x11, ipv4)), "192.0.2.1:56324"},
+		{"v2 IPv6 with TLV", string(v2Header(1, 0x21, append(ipv6, 0x04, 0, 1, 'x'))), "[2001:db8::1]:56324"},
+		{"v2 local", string(v2Header(0, 0x11, ipv4)), "127.0.0.1:"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := get(t, "tcp", addr, []byte(tc.header))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tc.want) {
+				t.Errorf("got remote address %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Connections without a header are closed without a response.
+	for _, header := range []string{"", "PROXY TCP4 bogus\r\n"} {
+		if got, _ := get(t, "tcp", addr, []byte(header)); got != "" {
+			t.Errorf("header %q: got response %q, want none", header, got)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	addr := unixPrefix + filepath.Join(t.TempDir(), "webserver.sock")
+
+	// A stale socket is replaced.
+	for i := 0; i < 2; i++ {
+		l, err := Listen(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Close the file descriptor without removing the socket, like a
+			// crashed process.
+			l.(*net.UnixListener).SetUnlinkOnClose(false)
+			l.Close()
+			continue
+		}
+		serve(t, ProxyProtocol(l, time.Second))
+	}
+
+	client := &http.Client{Transport: &http.Transport{DialContext: Dial(addr, true)}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+
+	_, address := Split(addr)
+	got, err := get(t, "unix", address, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "192.0.2.1:56324" {
+		t.Errorf("got remote address %q", got)
+	}
+
+}
@@ -0,0 +1,212 @@
+// Package lockfile extracts the resolved dependencies from dependency
+// lockfiles, so they can be indexed and searched with dependency: queries.
+package lockfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dependency is a resolved dependency. Name uses the naming conventions of
+// the package ecosystem, eg. a module path for Go.
+type Dependency struct {
+	Name    string
+	Version string
+}
+
+func (d Dependency) String() string {
+	return d.Name + "@" + d.Version
+}
+
+// parsers maps lockfile base names to their parsers.
+var parsers = map[string]func([]byte) ([]Dependency, error){
+	"go.sum":            parseGoSum,
+	"package-lock.json": parsePackageLock,
+	"poetry.lock":       parsePoetryLock,
+}
+
+// Parse returns the dependencies listed in the lockfile with the given path,
+// sorted and without duplicates. It returns nil for files that are not
+// lockfiles, and an error for lockfiles it cannot parse.
+func Parse(name string, content []byte) ([]Dependency, error) {
+	parse, ok := parsers[path.Base(name)]
+	if !ok {
+		return nil, nil
+	}
+	deps, err := parse(content)
+	if err != nil {
+		return nil, err
+	}
+	return sortAndDedup(deps), nil
+}
+
+func sortAndDedup(deps []Dependency) []Dependency {
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].Name != deps[j].Name {
+			return deps[i].Name < deps[j].Name
+		}
+		return deps[i].Version < deps[j].Version
+	})
+	out := deps[:0]
+	for i, d := range deps {
+		if i > 0 && d == deps[i-1] {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// parseGoSum parses go.sum files, which have lines of the form
+//
+//	MODULE VERSION[/go.mod] HASH
+func parseGoSum(content []byte) ([]Dependency, error) {
+	var deps []Dependency
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		deps = append(deps, Dependency{
+			Name:    fields[0],
+			Version: strings.TrimSuffix(fields[1], "/go.mod"),
+		})
+	}
+	return deps, sc.Err()
+}
+
+type packageLockDep struct {
+	Version      string                    `json:"version"`
+	Link         bool                      `json:"link"`
+	Dependencies map[string]packageLockDep `json:"dependencies"`
+}
+
+// parsePackageLock parses npm lockfiles. Lockfile version 2 and later list
+// every installed package under "packages", keyed by its path in
+// node_modules. Version 1 nests packages under "dependencies".
+func parsePackageLock(content []byte) ([]Dependency, error) {
+	var lock struct {
+		Packages     map[string]packageLockDep `json:"packages"`
+		Dependencies map[string]packageLockDep `json:"dependencies"`
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+
+	var deps []Dependency
+	if len(lock.Packages) > 0 {
+		for key, p := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || p.Link || p.Version == "" {
+				// The root package, or a workspace link.
+				continue
+			}
+			deps = append(deps, Dependency{Name: key[i+len("node_modules/"):], Version: p.Version})
+		}
+		return deps, nil
+	}
+
+	var walk func(map[string]packageLockDep)
+	walk = func(m map[string]packageLockDep) {
+		for name, p := range m {
+			if p.Version != "" && !strings.HasPrefix(p.Version, "file:") {
+				deps = append(deps, Dependency{Name: name, Version: p.Version})
+			}
+			walk(p.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return deps, nil
+}
+
+var pythonNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePythonName normalizes package names as described in PEP 503, so
+// "Foo_Bar" and "foo-bar" are the same dependency.
+func normalizePythonName(name string) string {
+	return pythonNameSeparators.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// parsePoetryLock parses poetry.lock files. They are TOML, but we only need
+// the name and version keys of the [[package]] tables, so we avoid a full
+// TOML parser.
+func parsePoetryLock(content []byte) ([]Dependency, error) {
+	var deps []Dependency
+	var cur Dependency
+	inPackage := false
+	flush := func() {
+		if inPackage && cur.Name != "" && cur.Version != "" {
+			deps = append(deps, Dependency{Name: normalizePythonName(cur.Name), Version: cur.Version})
+		}
+		cur = Dependency{}
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			inPackage = line == "[[package]]"
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.Unquote(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			cur.Name = value
+		case "version":
+			cur.Version = value
+		}
+	}
+	flush()
+	return deps, sc.Err()
+}
+
+// Encode encodes deps for storage in the index, one "name@version" per line.
+func Encode(deps []Dependency) []byte {
+	var buf bytes.Buffer
+	for _, d := range deps {
+		buf.WriteString(d.String())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Contains returns true if the encoded dependency list data contains a
+// dependency with the given name and, if version is non-empty, version.
+func Contains(data []byte, name, version string) bool {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		// Names may start with @ (npm scopes), so split at the last @.
+		i := bytes.LastIndexByte(line, '@')
+		if i <= 0 || string(line[:i]) != name {
+			continue
+		}
+		if version == "" || string(line[i+1:]) == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package lockfile
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []Dependency
+	}{{
+		name: "go.sum",
+		content: `github.com/google/go-cmp v0.6.0 h1:ofyhxvXcZhMsU5ulbFiLKl/XBFqE1GSq7atu8tAmTRI=
+github.com/google/go-cmp v0.6.0/go.mod h1:17dUlkBOakJ0+DkrSSNjCkIjxS6bF9zb3elmeNGIjoY=
+golang.org/x/sys v0.1.0/go.mod h1:oPkhp1MJrh7nUepCBck5+mAzfO9JrbApNNgaTdGDITg=
+`,
+		want: []Dependency{
+			{"github.com/google/go-cmp", "v0.6.0"},
+			{"golang.org/x/sys", "v0.1.0"},
+		},
+	}, {
+		name: "web/package-lock.json",
+		content: `{
+  "name": "web",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "web", "version": "1.0.0"},
+    "node_modules/@babel/core": {"version": "7.24.0"},
+    "node_modules/lodash": {"version": "4.17.21"},
+    "node_modules/foo/node_modules/lodash": {"version": "4.17.20"},
+    "node_modules/local": {"resolved": "packages/local", "link": true}
+  }
+}`,
+		want: []Dependency{
+			{"@babel/core", "7.24.0"},
+			{"lodash", "4.17.20"},
+			{"lodash", "4.17.21"},
+		},
+	}, {
+		name: "package-lock.json",
+		content: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "left-pad": {"version": "1.3.0"},
+    "foo": {"version": "2.0.0", "dependencies": {"bar": {"version": "0.1.0"}}},
+    "local": {"version": "file:../local"}
+  }
+}`,
+		want: []Dependency{
+			{"bar", "0.1.0"},
+			{"foo", "2.0.0"},
+			{"left-pad", "1.3.0"},
+		},
+	}, {
+		name: "poetry.lock",
+		content: `[[package]]
+name = "Django"
+version = "4.2.1"
+description = "A high-level Python web framework."
+
+[package.extras]
+argon2 = ["argon2-cffi (>=19.1.0)"]
+
+[[package]]
+name = "typing_extensions"
+version = "4.6.0"
+
+[metadata]
+lock-version = "2.0"
+`,
+		want: []Dependency{
+			{"django", "4.2.1"},
+			{"typing-extensions", "4.6.0"},
+		},
+	}, {
+		name:    "main.go",
+		content: "package main",
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.name, []byte(tc.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Fatalf("mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+
+	if _, err := Parse("package-lock.json", []byte("{")); err == nil {
+		t.Error("expected error for invalid package-lock.json")
+	}
+}
+
+func TestContains(t *testing.T) {
+	data := Encode([]Dependency{
+		{"@babel/core", "7.24.0"},
+		{"lodash", "4.17.21"},
+	})
+
+	cases := []struct {
+		name, version string
+		want          bool
+	}{
+		{"lodash", "", true},
+		{"lodash", "4.17.21", true},
+		{"lodash", "4.17.20", false},
+		{"@babel/core", "", true},
+		{"@babel/core", "7.24.0", true},
+		{"@babel", "", false},
+		{"core", "", false},
+	}
+	for _, tc := range cases {
+		if got := Contains(data, tc.name, tc.version); got != tc.want {
+			t.Errorf("Contains(%q, %q) = %t, want %t", tc.name, tc.version, got, tc.want)
+		}
+	}
+}
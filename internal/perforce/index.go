@@ -0,0 +1,214 @@
+// Package perforce provides indexing of Perforce (Helix Core) depot paths.
+//
+// It runs the p4 command line client, which takes its connection settings
+// (P4PORT, P4USER, P4TICKETS, ...) from the environment. A depot path is
+// indexed as a repository with a single branch, whose version is the number
+// of the indexed changelist.
+package perforce
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// Options specify the Perforce specific indexing options.
+type Options struct {
+	Incremental bool
+
+	// Depot is the depot path to index, eg. "//depot/project/...".
+	Depot   string
+	Name    string
+	RepoURL string
+	Branch  string
+
+	// Changelist is the changelist to index. Zero means the latest submitted
+	// changelist that affects Depot.
+	Changelist int
+
+	// P4 is the p4 binary. It defaults to "p4".
+	P4 string
+}
+
+func (o *Options) SetDefaults() {
+	if o.Branch == "" {
+		o.Branch = "HEAD"
+	}
+	if o.P4 == "" {
+		o.P4 = "p4"
+	}
+	if o.Name == "" {
+		o.Name = strings.TrimPrefix(strings.TrimSuffix(depotRoot(o.Depot), "/"), "//")
+	}
+}
+
+// depotRoot returns the directory of a depot path ending in "/...", including
+// the trailing slash. Documents are named relative to it.
+func depotRoot(depot string) string {
+	root, ok := strings.CutSuffix(depot, "...")
+	if !ok || !strings.HasSuffix(root, "/") {
+		return ""
+	}
+	return root
+}
+
+// Index the depot path specified in opts using bopts.
+func Index(opts Options, bopts build.Options) error {
+	opts.SetDefaults()
+
+	root := depotRoot(opts.Depot)
+	if !strings.HasPrefix(root, "//") {
+		return fmt.Errorf("depot path %q must be of the form //depot/path/...", opts.Depot)
+	}
+
+	change, changeTime, err := latestChange(opts)
+	if err != nil {
+		return err
+	}
+
+	bopts.RepositoryDescription.Name = opts.Name
+	bopts.RepositoryDescription.URL = opts.RepoURL
+	bopts.RepositoryDescription.Source = opts.Depot
+	bopts.RepositoryDescription.LatestCommitDate = changeTime
+	bopts.SetDefaults()
+	bopts.RepositoryDescription.Branches = []zoekt.RepositoryBranch{{Name: opts.Branch, Version: strconv.Itoa(change)}}
+	brs := []string{opts.Branch}
+
+	if opts.Incremental && bopts.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	builder, err := build.NewBuilder(bopts)
+	if err != nil {
+		return err
+	}
+
+	err = printFiles(opts, fmt.Sprintf("%s@%d", opts.Depot, change), func(depotFile string, content []byte) error {
+		return builder.Add(zoekt.Document{
+			Name:     strings.TrimPrefix(depotFile, root),
+			Content:  content,
+			Branches: brs,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return builder.Finish()
+}
+
+// latestChange returns the number and submit time of the latest submitted
+// changelist affecting opts.Depot, up to opts.Changelist if set.
+func latestChange(opts Options) (int, time.Time, error) {
+	path := opts.Depot
+	if opts.Changelist > 0 {
+		path = fmt.Sprintf("%s@%d", path, opts.Changelist)
+	}
+
+	var change record
+	err := run(opts, []string{"changes", "-m1", "-s", "submitted", path}, func(r record) error {
+		change = r
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if change == nil {
+		return 0, time.Time{}, fmt.Errorf("no submitted changelists for %s", path)
+	}
+
+	n, err := strconv.Atoi(change["change"])
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("p4 changes: invalid changelist %q", change["change"])
+	}
+	secs, _ := strconv.ParseInt(change["time"], 10, 64)
+	return n, time.Unix(secs, 0), nil
+}
+
+// printFiles calls f with the depot path and content of each file matching
+// path. p4 print skips files which are deleted at that revision.
+func printFiles(opts Options, path string, f func(depotFile string, content []byte) error) error {
+	var (
+		depotFile string
+		content   bytes.Buffer
+	)
+	flush := func() error {
+		if depotFile == "" {
+			return nil
+		}
+		err := f(depotFile, bytes.Clone(content.Bytes()))
+		depotFile = ""
+		content.Reset()
+		return err
+	}
+
+	err := run(opts, []string{"print", "-k", path}, func(r record) error {
+		if r["code"] == "stat" {
+			if err := flush(); err != nil {
+				return err
+			}
+			depotFile = r["depotFile"]
+			return nil
+		}
+		content.WriteString(r["data"])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// run runs "p4 -G args" and calls f with each record of the output.
+func run(opts Options, args []string, f func(record) error) error {
+	cmd := exec.Command(opts.P4, append([]string{"-G"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = readRecords(bufio.NewReader(stdout), f)
+	if err != nil {
+		// Stop p4 instead of waiting for it to write the remaining output.
+		_ = cmd.Process.Kill()
+	}
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("p4 %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func readRecords(r *bufio.Reader, f func(record) error) error {
+	for {
+		rec, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec["code"] == "error" {
+			return errors.New(strings.TrimSpace(rec["data"]))
+		}
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+}
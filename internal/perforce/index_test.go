@@ -0,0 +1,142 @@
+package perforce
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+// marshal encodes records like "p4 -G". Values of type int are written as
+// integers.
+func marshal(recs ...map[string]any) []byte {
+	var buf bytes.Buffer
+	str := func(s string) {
+		buf.WriteByte('s')
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	for _, rec := range recs {
+		buf.WriteByte('{')
+		for k, v := range rec {
+			str(k)
+			switch v := v.(type) {
+			case int:
+				buf.WriteByte('i')
+				_ = binary.Write(&buf, binary.LittleEndian, int32(v))
+			case string:
+				str(v)
+			}
+		}
+		buf.WriteByte('0')
+	}
+	return buf.Bytes()
+}
+
+func TestReadRecord(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader(marshal(
+		map[string]any{"code": "stat", "depotFile": "//depot/a.go", "rev": 3},
+		map[string]any{"code": "text", "data": "package a\n"},
+	)))
+
+	var got []record
+	if err := readRecords(r, func(rec record) error {
+		got = append(got, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0]["depotFile"] != "//depot/a.go" || got[0]["rev"] != "3" || got[1]["data"] != "package a\n" {
+		t.Fatalf("got %v", got)
+	}
+
+	err := readRecords(bufio.NewReader(bytes.NewReader(marshal(
+		map[string]any{"code": "error", "data": "//depot/nope/... - no such file(s).\n", "severity": 2},
+	))), func(record) error { return nil })
+	if err == nil || err.Error() != "//depot/nope/... - no such file(s)." {
+		t.Fatalf("got error %v", err)
+	}
+
+	if _, err := readRecord(bufio.NewReader(bytes.NewReader([]byte("{s\x03\x00\x00\x00co")))); err == nil {
+		t.Fatal("expected error for truncated record")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake p4 is a shell script")
+	}
+
+	// The fake p4 prints the output for each subcommand from a file.
+	dir := t.TempDir()
+	p4 := filepath.Join(dir, "p4")
+	if err := os.WriteFile(p4, []byte("#!/bin/sh\ncat \""+dir+"/$2\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	submitted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	outputs := map[string][]byte{
+		"changes": marshal(map[string]any{"code": "stat", "change": "1234", "time": "1714564800", "status": "submitted"}),
+		"print": marshal(
+			map[string]any{"code": "stat", "depotFile": "//depot/proj/main.c", "rev": "4", "change": "1234"},
+			map[string]any{"code": "text", "data": "int main() {\n"},
+			map[string]any{"code": "text", "data": "  return needle;\n}\n"},
+			map[string]any{"code": "stat", "depotFile": "//depot/proj/doc/README", "rev": "1", "change": "1000"},
+			map[string]any{"code": "text", "data": "no match here\n"},
+		),
+	}
+	for name, b := range outputs {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indexDir := t.TempDir()
+	opts := Options{
+		Incremental: true,
+		Depot:       "//depot/proj/...",
+		P4:          p4,
+	}
+	if err := Index(opts, build.Options{IndexDir: indexDir, DisableCTags: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	shardPaths, err := filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+	if err != nil || len(shardPaths) != 1 {
+		t.Fatalf("got shards %v, err %v", shardPaths, err)
+	}
+	repos, _, err := zoekt.ReadMetadataPath(shardPaths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repos[0]
+	if repo.Name != "depot/proj" || len(repo.Branches) != 1 || repo.Branches[0] != (zoekt.RepositoryBranch{Name: "HEAD", Version: "1234"}) {
+		t.Errorf("got repository %s with branches %v", repo.Name, repo.Branches)
+	}
+	if !repo.LatestCommitDate.Equal(submitted) {
+		t.Errorf("got latest commit date %v, want %v", repo.LatestCommitDate, submitted)
+	}
+
+	ss, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "main.c" {
+		t.Errorf("got files %v, want main.c", res.Files)
+	}
+}
@@ -0,0 +1,83 @@
+package perforce
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// record is one result of a "p4 -G" command. Integer values are converted to
+// their decimal representation.
+type record map[string]string
+
+// readRecord reads one record in the Python marshal format written by
+// "p4 -G". It returns io.EOF if there are no more records.
+func readRecord(r *bufio.Reader) (record, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != '{' {
+		return nil, fmt.Errorf("p4 -G: got type %q, want dictionary", typ)
+	}
+
+	rec := record{}
+	for {
+		key, end, err := readValue(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if end {
+			return rec, nil
+		}
+		val, end, err := readValue(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if end {
+			return nil, fmt.Errorf("p4 -G: missing value for %q", key)
+		}
+		rec[key] = val
+	}
+}
+
+// readValue reads a string or integer. end is true if it read the end of a
+// dictionary instead.
+func readValue(r *bufio.Reader) (val string, end bool, err error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return "", false, err
+	}
+
+	switch typ {
+	case '0':
+		return "", true, nil
+	case 'i':
+		var n int32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", false, err
+		}
+		return strconv.Itoa(int(n)), false, nil
+	case 's', 'u', 't':
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", false, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", false, err
+		}
+		return string(b), false, nil
+	default:
+		return "", false, fmt.Errorf("p4 -G: unsupported type %q", typ)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+// Package priority carries the priority of a search request in its context.
+// The shard scheduler shares the search slots between concurrent searches in
+// proportion to their priority, so batch clients can lower theirs to stay out
+// of the way of interactive users. Clients can't raise their priority above
+// Default, else one could starve all others.
+package priority
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/sourcegraph/zoekt/grpc/propagator"
+)
+
+// Default is the priority of requests which don't set one.
+const Default = 1.0
+
+// headerKey is the HTTP header and gRPC metadata key for the priority.
+const headerKey = "X-Zoekt-Priority"
+
+type contextKey struct{}
+
+// WithPriority returns a context for a request of the given priority, which
+// must be positive.
+func WithPriority(ctx context.Context, p float64) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the priority of the request, or Default.
+func FromContext(ctx context.Context) float64 {
+	if p, ok := ctx.Value(contextKey{}).(float64); ok {
+		return p
+	}
+	return Default
+}
+
+// parse parses a priority sent by a client. Priorities above Default are
+// clamped to Default.
+func parse(raw string) (float64, error) {
+	p, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !(p > 0) {
+		return 0, fmt.Errorf("bad priority %q, want a positive number", raw)
+	}
+	return min(p, Default), nil
+}
+
+// Middleware sets the priority of requests from the X-Zoekt-Priority header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if raw := r.Header.Get(headerKey); raw != "" {
+			p, err := parse(raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(WithPriority(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Propagator implements the propagator.Propagator interface for propagating
+// priorities across RPC calls, like Middleware does for HTTP.
+type Propagator struct{}
+
+var _ propagator.Propagator = &Propagator{}
+
+func (Propagator) FromContext(ctx context.Context) metadata.MD {
+	md := make(metadata.MD)
+	if p, ok := ctx.Value(contextKey{}).(float64); ok {
+		md.Append(headerKey, strconv.FormatFloat(p, 'g', -1, 64))
+	}
+	return md
+}
+
+func (Propagator) InjectContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	vals := md.Get(headerKey)
+	if len(vals) == 0 {
+		return ctx, nil
+	}
+	p, err := parse(vals[0])
+	if err != nil {
+		return ctx, status.New(codes.InvalidArgument, err.Error()).Err()
+	}
+	return WithPriority(ctx, p), nil
+}
@@ -0,0 +1,54 @@
+package priority
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParse(t *testing.T) {
+	for raw, want := range map[string]float64{
+		"0.1":   0.1,
+		"1":     Default,
+		"2":     Default,
+		"1e308": Default,
+		"+Inf":  Default,
+	} {
+		got, err := parse(raw)
+		if err != nil {
+			t.Errorf("%q: %v", raw, err)
+		} else if got != want {
+			t.Errorf("%q: got %v, want %v", raw, got, want)
+		}
+	}
+
+	for _, raw := range []string{"", "0", "-1", "NaN", "high"} {
+		if _, err := parse(raw); err == nil {
+			t.Errorf("%q: want error", raw)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	var got float64
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.Header.Set(headerKey, "1e308")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != Default {
+		t.Errorf("HTTP: got priority %v, want %v", got, Default)
+	}
+
+	ctx, err := Propagator{}.InjectContext(context.Background(), metadata.Pairs(headerKey, "1e308"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FromContext(ctx); got != Default {
+		t.Errorf("gRPC: got priority %v, want %v", got, Default)
+	}
+}
@@ -0,0 +1,140 @@
+// Package queryprofile captures CPU profiles and allocation statistics of
+// single searches, to investigate slow queries without profiling the server
+// for an arbitrary period of time.
+package queryprofile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Profile is the profile of a single search.
+type Profile struct {
+	ID       int64
+	Query    string
+	Start    time.Time
+	Duration time.Duration
+
+	// CPU is a pprof encoded CPU profile of the process while the search ran.
+	// Samples taken while running the search are labelled with zoekt_query=ID,
+	// so use "go tool pprof -tagfocus zoekt_query=ID" to exclude concurrent
+	// work. It is nil if another CPU profile was running.
+	CPU []byte
+
+	// TotalAlloc and Mallocs are the number of bytes and objects allocated by
+	// the process while the search ran.
+	TotalAlloc uint64
+	Mallocs    uint64
+}
+
+// Recorder captures profiles and keeps the most recent ones.
+type Recorder struct {
+	mu       sync.Mutex
+	nextID   int64
+	profiles []*Profile
+	size     int
+}
+
+// NewRecorder returns a Recorder that keeps the last size profiles.
+func NewRecorder(size int) *Recorder {
+	return &Recorder{size: size}
+}
+
+// Start starts profiling the search for query. Work must be done with the
+// returned context, so that the goroutines it starts are labelled. Call stop
+// once the search is done; it returns the profile, which Handler serves at
+// /debug/queryprofiles?id=ID.
+func (r *Recorder) Start(ctx context.Context, query string) (_ context.Context, stop func() *Profile) {
+	r.mu.Lock()
+	r.nextID++
+	p := &Profile{ID: r.nextID, Query: query, Start: time.Now()}
+	r.mu.Unlock()
+
+	parent := ctx
+	ctx = pprof.WithLabels(ctx, pprof.Labels("zoekt_query", strconv.FormatInt(p.ID, 10)))
+	pprof.SetGoroutineLabels(ctx)
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var cpu bytes.Buffer
+	cpuErr := pprof.StartCPUProfile(&cpu)
+
+	return ctx, func() *Profile {
+		if cpuErr == nil {
+			pprof.StopCPUProfile()
+			p.CPU = cpu.Bytes()
+		}
+		p.Duration = time.Since(p.Start)
+
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		p.TotalAlloc = after.TotalAlloc - before.TotalAlloc
+		p.Mallocs = after.Mallocs - before.Mallocs
+
+		pprof.SetGoroutineLabels(parent)
+
+		r.mu.Lock()
+		r.profiles = append(r.profiles, p)
+		if len(r.profiles) > r.size {
+			r.profiles = r.profiles[len(r.profiles)-r.size:]
+		}
+		r.mu.Unlock()
+		return p
+	}
+}
+
+func (r *Recorder) get(id int64) *Profile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.profiles {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Handler lists the recorded profiles. With ?id=ID it serves the CPU profile
+// of that search.
+func (r *Recorder) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if idStr := req.URL.Query().Get("id"); idStr != "" {
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			p := r.get(id)
+			if p == nil || p.CPU == nil {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="query-%d.pprof"`, id))
+			_, _ = w.Write(p.CPU)
+			return
+		}
+
+		r.mu.Lock()
+		profiles := append([]*Profile(nil), r.profiles...)
+		r.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID\tSTART\tDURATION\tALLOC BYTES\tMALLOCS\tCPU\tQUERY\n")
+		for i := len(profiles) - 1; i >= 0; i-- {
+			p := profiles[i]
+			cpu := "busy"
+			if p.CPU != nil {
+				cpu = "?id=" + strconv.FormatInt(p.ID, 10)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%s\t%s\n", p.ID, p.Start.Format(time.RFC3339), p.Duration, p.TotalAlloc, p.Mallocs, cpu, p.Query)
+		}
+		_ = tw.Flush()
+	})
+}
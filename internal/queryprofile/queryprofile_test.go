@@ -0,0 +1,69 @@
+package queryprofile
+
+import (
+	"context"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+var sink []byte
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(2)
+
+	ctx, stop := r.Start(context.Background(), "needle")
+	if v, ok := pprof.Label(ctx, "zoekt_query"); !ok || v != "1" {
+		t.Errorf("got label %q, want 1", v)
+	}
+	for i := 0; i < 100; i++ {
+		sink = make([]byte, 1024)
+	}
+	p := stop()
+
+	if p.ID != 1 || p.Query != "needle" {
+		t.Errorf("got profile %d for %q", p.ID, p.Query)
+	}
+	if len(p.CPU) == 0 {
+		t.Error("missing CPU profile")
+	}
+	if p.TotalAlloc < 100*1024 || p.Mallocs < 100 {
+		t.Errorf("got TotalAlloc=%d Mallocs=%d, want at least 100 allocations of 1KiB", p.TotalAlloc, p.Mallocs)
+	}
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/queryprofiles?id=1", nil))
+	if got := w.Body.String(); got != string(p.CPU) {
+		t.Error("served CPU profile differs")
+	}
+
+	// Only the most recent profiles are kept.
+	for _, q := range []string{"second", "third"} {
+		_, stop := r.Start(context.Background(), q)
+		stop()
+	}
+	w = httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/queryprofiles", nil))
+	list := w.Body.String()
+	if strings.Contains(list, "needle") || !strings.Contains(list, "second") || !strings.Contains(list, "third") {
+		t.Errorf("unexpected profile list:\n%s", list)
+	}
+	w = httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/queryprofiles?id=1", nil))
+	if w.Code != 404 {
+		t.Errorf("got status %d for evicted profile, want 404", w.Code)
+	}
+}
+
+func TestRecorderCPUBusy(t *testing.T) {
+	r := NewRecorder(1)
+	_, stop1 := r.Start(context.Background(), "first")
+	_, stop2 := r.Start(context.Background(), "second")
+	if p := stop2(); p.CPU != nil {
+		t.Error("got a CPU profile while another was running")
+	}
+	if p := stop1(); p.CPU == nil {
+		t.Error("missing CPU profile")
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/sourcegraph/zoekt/internal/auth"
+)
+
+type contextKey struct{}
+
+// WithIdentity returns a context for a request of the client identity.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the client of the request, or
+// "".
+func IdentityFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// identify returns the name of the authenticated principal of ctx, else the
+// value of the trusted header, else the IP address of addr.
+func identify(ctx context.Context, header, addr string) string {
+	if p := auth.PrincipalFromContext(ctx); p != nil {
+		return p.Name
+	}
+	if header != "" {
+		return header
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Middleware sets the identity of requests, see the package documentation.
+// It must run after auth.Authenticator.Middleware. If header is not empty,
+// its value, eg. X-Zoekt-Principal, identifies unauthenticated clients. It is
+// trusted, so the webserver must only be reachable through a frontend which
+// sets it.
+func Middleware(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var value string
+		if header != "" {
+			value = r.Header.Get(header)
+		}
+		id := identify(r.Context(), value, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// identifyGRPC is identify for gRPC requests, which carry header in their
+// metadata.
+func identifyGRPC(ctx context.Context, header string) context.Context {
+	var value, addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	if header != "" {
+		if vals := metadata.ValueFromIncomingContext(ctx, header); len(vals) > 0 {
+			value = vals[0]
+		}
+	}
+	return WithIdentity(ctx, identify(ctx, value, addr))
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor which sets the
+// identity of requests like Middleware. It must run after the interceptors
+// of auth.Authenticator.
+func UnaryServerInterceptor(header string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(identifyGRPC(ctx, header), req)
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor which sets
+// the identity of requests like Middleware. It must run after the
+// interceptors of auth.Authenticator.
+func StreamServerInterceptor(header string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &grpc_middleware.WrappedServerStream{
+			ServerStream:   ss,
+			WrappedContext: identifyGRPC(ss.Context(), header),
+		})
+	}
+}
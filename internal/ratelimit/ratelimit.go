@@ -0,0 +1,244 @@
+// Package ratelimit limits the rate of searches per client, so a runaway
+// script can't monopolize the webservers.
+//
+// Every client has a token bucket keyed by its identity: the name of its
+// authenticated principal (see package auth), the value of a trusted header
+// like X-Zoekt-Principal if one is configured, or else its IP address. Rules
+// assign identities to classes, and each class has its own rate and burst.
+// Searches of clients with an empty bucket fail with a *zoekt.RateLimitError,
+// which the APIs report as HTTP 429 and gRPC RESOURCE_EXHAUSTED.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var metricRateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_rate_limited_searches_total",
+	Help: "The total number of searches rejected because their client exceeded the rate of its class.",
+}, []string{"class"})
+
+// DefaultClass is the class of identities which no rule matches.
+const DefaultClass = "default"
+
+// Limit is the rate of searches of a single client.
+type Limit struct {
+	// Rate is the number of searches per second.
+	Rate float64
+
+	// Burst is the number of searches a client which was idle can run at
+	// once.
+	Burst int
+}
+
+// Rule assigns the identities matching Pattern, as in path.Match, to Class.
+type Rule struct {
+	Pattern string
+	Class   string
+}
+
+// Limits configure a Limiter.
+type Limits struct {
+	// Classes are the limits by class. The clients of classes without a
+	// limit are not limited.
+	Classes map[string]Limit
+
+	// Rules assign classes to identities. The first matching rule wins;
+	// identities which match none are in DefaultClass.
+	Rules []Rule
+}
+
+// ParseLimits parses the values of the --rate_limits and
+// --rate_limit_classes flags of zoekt-webserver. limits is a comma separated
+// list of CLASS=RATE[:BURST], where RATE is the number of searches per second
+// and BURST defaults to RATE rounded up. classes is a comma separated list of
+// PATTERN=CLASS rules.
+func ParseLimits(limits, classes string) (Limits, error) {
+	l := Limits{Classes: map[string]Limit{}}
+	for _, spec := range splitList(limits) {
+		class, value, ok := strings.Cut(spec, "=")
+		if !ok || class == "" {
+			return Limits{}, fmt.Errorf("bad rate limit %q, want CLASS=RATE[:BURST]", spec)
+		}
+		rateStr, burstStr, hasBurst := strings.Cut(value, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || !(rate > 0) || math.IsInf(rate, 0) {
+			return Limits{}, fmt.Errorf("bad rate in %q, want a positive number of searches per second", spec)
+		}
+		burst := int(math.Ceil(rate))
+		if hasBurst {
+			burst, err = strconv.Atoi(burstStr)
+			if err != nil || burst < 1 {
+				return Limits{}, fmt.Errorf("bad burst in %q, want a positive integer", spec)
+			}
+		}
+		l.Classes[class] = Limit{Rate: rate, Burst: burst}
+	}
+	for _, spec := range splitList(classes) {
+		pattern, class, ok := strings.Cut(spec, "=")
+		if !ok || pattern == "" || class == "" {
+			return Limits{}, fmt.Errorf("bad rate limit class %q, want PATTERN=CLASS", spec)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Limits{}, fmt.Errorf("bad pattern in %q: %w", spec, err)
+		}
+		l.Rules = append(l.Rules, Rule{Pattern: pattern, Class: class})
+	}
+	return l, nil
+}
+
+func splitList(s string) []string {
+	var specs []string
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// sweepInterval is how often a Limiter forgets the clients whose buckets
+// are full again, or which were idle for idleTimeout.
+const sweepInterval = time.Minute
+
+// idleTimeout bounds how long we keep the buckets of clients which stopped
+// searching, even if their class refills slowly. Without it, a client
+// changing its IP address on every search would grow the buckets for as
+// long as the bucket takes to refill.
+const idleTimeout = 10 * time.Minute
+
+// Limiter keeps a token bucket per identity. It is safe for concurrent use.
+type Limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+
+	// used is the time of the last search of the client.
+	used time.Time
+}
+
+// refill adds the tokens earned since the last call, up to the burst.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// New returns a Limiter enforcing limits.
+func New(limits Limits) *Limiter {
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Class returns the class of identity.
+func (l *Limiter) Class(identity string) string {
+	for _, r := range l.limits.Rules {
+		if ok, _ := path.Match(r.Pattern, identity); ok {
+			return r.Class
+		}
+	}
+	return DefaultClass
+}
+
+// Allow takes a token from the bucket of identity. It returns a
+// *zoekt.RateLimitError if the bucket is empty. Requests without an identity
+// are not limited.
+func (l *Limiter) Allow(identity string) error {
+	if identity == "" {
+		return nil
+	}
+	class := l.Class(identity)
+	limit, ok := l.limits.Classes[class]
+	if !ok {
+		return nil
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[identity]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[identity] = b
+	}
+	b.refill(now)
+	b.used = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+
+	metricRateLimitedTotal.WithLabelValues(class).Inc()
+	wait := (1 - b.tokens) / limit.Rate
+	return &zoekt.RateLimitError{
+		Class:      class,
+		RetryAfter: time.Duration(math.Ceil(wait * float64(time.Second))),
+	}
+}
+
+// sweep forgets the buckets which are full, since new buckets start full,
+// and the buckets of idle clients.
+func (l *Limiter) sweep(now time.Time) {
+	for identity, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) || now.Sub(b.used) >= idleTimeout {
+			delete(l.buckets, identity)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Searcher rejects the searches of clients which exceeded their rate, see
+// IdentityFromContext. List and the other methods are not limited.
+//
+// Estimates (SearchOptions.EstimateDocCount) count as searches, since
+// clients choose the options. The web UI and the JSON API estimate before
+// searching with default limits, so such searches take two tokens.
+type Searcher struct {
+	zoekt.Streamer
+	Limiter *Limiter
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if err := s.Limiter.Allow(IdentityFromContext(ctx)); err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	if err := s.Limiter.Allow(IdentityFromContext(ctx)); err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
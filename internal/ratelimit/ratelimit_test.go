@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestParseLimits(t *testing.T) {
+	got, err := ParseLimits("default=10, batch=0.5:5", "svc-*=batch,admin=unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Limits{
+		Classes: map[string]Limit{
+			"default": {Rate: 10, Burst: 10},
+			"batch":   {Rate: 0.5, Burst: 5},
+		},
+		Rules: []Rule{
+			{Pattern: "svc-*", Class: "batch"},
+			{Pattern: "admin", Class: "unlimited"},
+		},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("-want +got:\n%s", d)
+	}
+
+	for _, tc := range []struct{ limits, classes string }{
+		{limits: "default"},
+		{limits: "default=0"},
+		{limits: "default=-1"},
+		{limits: "default=1:0"},
+		{limits: "=1"},
+		{classes: "batch"},
+		{classes: "[=batch"},
+	} {
+		if _, err := ParseLimits(tc.limits, tc.classes); err == nil {
+			t.Errorf("ParseLimits(%q, %q) succeeded, want an error", tc.limits, tc.classes)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(Limits{
+		Classes: map[string]Limit{
+			DefaultClass: {Rate: 1, Burst: 2},
+			"batch":      {Rate: 0.1, Burst: 1},
+		},
+		Rules: []Rule{
+			{Pattern: "svc-*", Class: "batch"},
+			{Pattern: "admin", Class: "unlimited"},
+		},
+	})
+	l.now = func() time.Time { return now }
+
+	allow := func(identity string, want bool) *zoekt.RateLimitError {
+		t.Helper()
+		err := l.Allow(identity)
+		var rateLimitErr *zoekt.RateLimitError
+		if err != nil && !errors.As(err, &rateLimitErr) {
+			t.Fatalf("got error %v, want a *zoekt.RateLimitError", err)
+		}
+		if got := err == nil; got != want {
+			t.Fatalf("Allow(%q) = %v, want allowed %v", identity, err, want)
+		}
+		return rateLimitErr
+	}
+
+	// Clients start with a full bucket.
+	allow("alice", true)
+	allow("alice", true)
+	err := allow("alice", false)
+	if err.Class != DefaultClass || err.RetryAfter != time.Second {
+		t.Fatalf("got %+v, want to retry the default class after 1s", err)
+	}
+
+	// Buckets are per client.
+	allow("bob", true)
+
+	// Tokens are refilled at the rate of the class.
+	now = now.Add(500 * time.Millisecond)
+	if err := allow("alice", false); err.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("got %+v, want to retry after 500ms", err)
+	}
+	now = now.Add(500 * time.Millisecond)
+	allow("alice", true)
+
+	allow("svc-indexer", true)
+	if err := allow("svc-indexer", false); err.Class != "batch" || err.RetryAfter != 10*time.Second {
+		t.Fatalf("got %+v, want to retry the batch class after 10s", err)
+	}
+
+	// Classes without limits and requests without identity are not
+	// limited.
+	for i := 0; i < 10; i++ {
+		allow("admin", true)
+		allow("", true)
+	}
+
+	// Full buckets are forgotten.
+	now = now.Add(sweepInterval)
+	allow("alice", true)
+	if len(l.buckets) != 1 {
+		t.Fatalf("got %d buckets after the sweep, want 1", len(l.buckets))
+	}
+
+	// So are the buckets of idle clients, even if they aren't full yet.
+	allow("svc-indexer", true)
+	now = now.Add(idleTimeout)
+	allow("alice", true)
+	if _, ok := l.buckets["svc-indexer"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("got %d buckets after idling, want only alice", len(l.buckets))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		header    string // the configured header
+		value     string
+		principal *auth.Principal
+		want      string
+	}{
+		{name: "remote address", want: "192.0.2.1"},
+		{name: "untrusted header", value: "alice", want: "192.0.2.1"},
+		{name: "header", header: "X-Zoekt-Principal", value: "alice", want: "alice"},
+		{
+			name:      "principal",
+			header:    "X-Zoekt-Principal",
+			value:     "alice",
+			principal: &auth.Principal{Name: "ci"},
+			want:      "ci",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			h := Middleware(tc.header, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = IdentityFromContext(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/search", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if tc.principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), tc.principal))
+			}
+			if tc.value != "" {
+				r.Header.Set("X-Zoekt-Principal", tc.value)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tc.want {
+				t.Fatalf("got identity %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// nopStreamer finds nothing.
+type nopStreamer struct {
+	zoekt.Streamer
+}
+
+func (nopStreamer) Search(context.Context, query.Q, *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return &zoekt.SearchResult{}, nil
+}
+
+func (nopStreamer) StreamSearch(context.Context, query.Q, *zoekt.SearchOptions, zoekt.Sender) error {
+	return nil
+}
+
+func TestSearcher(t *testing.T) {
+	s := &Searcher{
+		Streamer: nopStreamer{},
+		Limiter:  New(Limits{Classes: map[string]Limit{DefaultClass: {Rate: 1, Burst: 2}}}),
+	}
+	ctx := WithIdentity(context.Background(), "alice")
+	q := &query.Const{Value: true}
+
+	// Estimates count like searches.
+	if _, err := s.Search(ctx, q, &zoekt.SearchOptions{EstimateDocCount: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(ctx, q, &zoekt.SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rateLimitErr *zoekt.RateLimitError
+	err := s.StreamSearch(ctx, q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(*zoekt.SearchResult) {}))
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("got %v, want a rate limit error", err)
+	}
+}
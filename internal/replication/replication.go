@@ -0,0 +1,432 @@
+// Package replication copies the shards of a webserver's index directory to a
+// warm standby webserver, which starts serving if the primary fails.
+//
+// The primary serves a manifest of its shard files with their checksums. The
+// standby polls it, downloads the files that changed, verifies their
+// checksums and atomically moves them into its own index directory, where
+// they are loaded like any other shard. Files the primary no longer has are
+// deleted. If the primary is unavailable for a number of consecutive syncs,
+// the standby stops replicating and starts answering searches. It keeps
+// probing the primary and hands back to it once it is available again.
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// File describes a file in the index directory of the primary.
+type File struct {
+	// Name is the base name of the file.
+	Name string
+	Size int64
+	// SHA256 is the hex encoded SHA-256 checksum of the contents.
+	SHA256 string
+}
+
+// replicated returns true if name is a file we replicate: shards and their
+// metadata overrides.
+func replicated(name string) bool {
+	return filepath.Base(name) == name && (strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta"))
+}
+
+// checksums caches the checksums of files, so we only hash files again after
+// they changed.
+type checksums struct {
+	mu    sync.Mutex
+	cache map[string]cachedChecksum
+}
+
+type cachedChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func (c *checksums) get(path string) (File, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+	f := File{Name: filepath.Base(path), Size: fi.Size()}
+
+	c.mu.Lock()
+	cached, ok := c.cache[path]
+	c.mu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		f.SHA256 = cached.sum
+		return f, nil
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return File{}, err
+	}
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[string]cachedChecksum{}
+	}
+	c.cache[path] = cachedChecksum{size: fi.Size(), modTime: fi.ModTime(), sum: f.SHA256}
+	c.mu.Unlock()
+	return f, nil
+}
+
+// list returns the replicated files in dir, sorted by name. A shard sorts
+// before its .meta file, so standbys load them in the same order.
+func (c *checksums) list(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !replicated(e.Name()) {
+			continue
+		}
+		f, err := c.get(filepath.Join(dir, e.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted while we were listing.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Handler serves the index directory dir to standbys. It serves the manifest
+// at /manifest and file contents at /files/NAME, relative to where it is
+// mounted.
+func Handler(dir string) http.Handler {
+	var sums checksums
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifest", func(w http.ResponseWriter, r *http.Request) {
+		files, err := sums.list(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(files)
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/files/")
+		if !replicated(name) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	})
+	return mux
+}
+
+// Standby replicates the index directory of a primary into Dir.
+type Standby struct {
+	// Primary is the URL under which the primary mounts Handler, eg.
+	// http://primary:6070/replication.
+	Primary string
+
+	// Dir is the local index directory.
+	Dir string
+
+	// Interval is the time between syncs.
+	Interval time.Duration
+
+	// MaxFailures is the number of consecutive syncs which failed because
+	// the primary was unavailable after which the standby takes over.
+	MaxFailures int
+
+	// Client is used to talk to the primary. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	sums   checksums
+	active atomic.Bool
+}
+
+// Active returns true while the standby has taken over from the primary.
+func (s *Standby) Active() bool {
+	return s.active.Load()
+}
+
+func (s *Standby) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *Standby) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(s.Primary, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{path: path, code: resp.StatusCode, status: resp.Status}
+	}
+	return resp, nil
+}
+
+// statusError is returned by get for responses other than 200 OK.
+type statusError struct {
+	path   string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("GET %s: %s", e.path, e.status)
+}
+
+// unavailable returns true if err means that the primary is down: it can't be
+// reached, or a proxy in front of it says it is unavailable. Other errors,
+// like a checksum mismatch or a full disk, aren't fixed by taking over.
+func unavailable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// probe returns nil if the primary serves its manifest.
+func (s *Standby) probe(ctx context.Context) error {
+	resp, err := s.get(ctx, "/manifest")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Sync makes Dir a copy of the index directory of the primary. It only
+// downloads files whose checksum differs from the local copy.
+func (s *Standby) Sync(ctx context.Context) error {
+	resp, err := s.get(ctx, "/manifest")
+	if err != nil {
+		return err
+	}
+	var files []File
+	err = json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	want := make(map[string]bool, len(files))
+	for _, f := range files {
+		if !replicated(f.Name) {
+			return fmt.Errorf("manifest contains unexpected file %q", f.Name)
+		}
+		want[f.Name] = true
+
+		local, err := s.sums.get(filepath.Join(s.Dir, f.Name))
+		if err == nil && local.Size == f.Size && local.SHA256 == f.SHA256 {
+			continue
+		}
+		if err := s.download(ctx, f); err != nil {
+			return err
+		}
+	}
+
+	local, err := s.sums.list(s.Dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range local {
+		if want[f.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, f.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Printf("replication: removed %s", f.Name)
+	}
+	return nil
+}
+
+// download fetches f into a temporary file, and renames it into place once its
+// checksum is verified. The temporary file does not end in .zoekt, so it is
+// not picked up by the shard watcher.
+func (s *Standby) download(ctx context.Context, f File) error {
+	resp, err := s.get(ctx, "/files/"+url.PathEscape(f.Name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(s.Dir, f.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", f.Name, err)
+	}
+	if err := verify(f, n, h); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.Dir, f.Name)); err != nil {
+		return err
+	}
+	log.Printf("replication: copied %s (%d bytes)", f.Name, n)
+	return nil
+}
+
+func verify(f File, n int64, h hash.Hash) error {
+	if n != f.Size {
+		return fmt.Errorf("%s: got %d bytes, want %d", f.Name, n, f.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return fmt.Errorf("%s: checksum mismatch: got %s, want %s", f.Name, sum, f.SHA256)
+	}
+	return nil
+}
+
+// Run syncs every Interval until ctx is done. Once the primary was
+// unavailable for MaxFailures consecutive syncs, the standby becomes active.
+// While active, it probes the primary every Interval, and steps down and
+// syncs again as soon as the primary is available.
+func (s *Standby) Run(ctx context.Context) {
+	failures := 0
+	for {
+		var err error
+		if s.Active() {
+			err = s.probe(ctx)
+		} else {
+			err = s.Sync(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil:
+			failures = 0
+			if s.Active() {
+				log.Printf("replication: primary %s is available again, stepping down", s.Primary)
+				s.active.Store(false)
+				continue
+			}
+		case s.Active():
+			// The primary is still down.
+		case unavailable(err):
+			failures++
+			log.Printf("replication: sync %d/%d failed: %v", failures, s.MaxFailures, err)
+			if failures >= s.MaxFailures {
+				log.Printf("replication: primary %s is unavailable, taking over", s.Primary)
+				s.active.Store(true)
+			}
+		default:
+			// The primary answered, so it is still serving.
+			failures = 0
+			log.Printf("replication: sync failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// standbyAllowed are the path prefixes served while the standby is not
+// active: health checks, metrics and debug pages.
+var standbyAllowed = []string{"/healthz", "/metrics", "/debug/"}
+
+// Middleware makes h respond with 503 Service Unavailable while the standby
+// isn't active, so load balancers only send searches to it once it took
+// over. gRPC requests must not go through it, see UnaryServerInterceptor.
+func (s *Standby) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Active() {
+			allowed := false
+			for _, p := range standbyAllowed {
+				allowed = allowed || strings.HasPrefix(r.URL.Path, p)
+			}
+			if !allowed {
+				http.Error(w, "standby: the primary is serving", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// healthMethodPrefix is the prefix of the methods of the gRPC health service,
+// which is served while the standby is not active.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+func (s *Standby) checkGRPC(method string) error {
+	if s.Active() || strings.HasPrefix(method, healthMethodPrefix) {
+		return nil
+	}
+	return status.Error(codes.Unavailable, "standby: the primary is serving")
+}
+
+// UnaryServerInterceptor is a grpc.UnaryServerInterceptor which rejects
+// requests with UNAVAILABLE while the standby isn't active, except for the
+// health service. It is Middleware for gRPC.
+func (s *Standby) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkGRPC(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func (s *Standby) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkGRPC(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
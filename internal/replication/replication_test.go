@@ -0,0 +1,261 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func TestSync(t *testing.T) {
+	primaryDir, standbyDir := t.TempDir(), t.TempDir()
+	writeFiles(t, primaryDir, map[string]string{
+		"repo_v16.00000.zoekt":      "shard",
+		"repo_v16.00000.zoekt.meta": "{}",
+		"other_v16.00000.zoekt":     "other",
+		"indexserver.sock":          "not replicated",
+	})
+	// Shards the primary doesn't have are removed, other files are kept.
+	writeFiles(t, standbyDir, map[string]string{
+		"stale_v16.00000.zoekt": "stale",
+		"notes.txt":             "local",
+	})
+
+	primary := httptest.NewServer(http.StripPrefix("/replication", Handler(primaryDir)))
+	defer primary.Close()
+
+	s := &Standby{Primary: primary.URL + "/replication", Dir: standbyDir}
+	sync := func(want map[string]string) {
+		t.Helper()
+		if err := s.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(want, readFiles(t, standbyDir)); d != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", d)
+		}
+	}
+
+	sync(map[string]string{
+		"repo_v16.00000.zoekt":      "shard",
+		"repo_v16.00000.zoekt.meta": "{}",
+		"other_v16.00000.zoekt":     "other",
+		"notes.txt":                 "local",
+	})
+
+	// Only changed files are copied. We detect copies by their new inode.
+	before, err := os.Stat(filepath.Join(standbyDir, "other_v16.00000.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, primaryDir, map[string]string{"repo_v16.00000.zoekt": "shard 2"})
+	os.Remove(filepath.Join(primaryDir, "repo_v16.00000.zoekt.meta"))
+	sync(map[string]string{
+		"repo_v16.00000.zoekt":  "shard 2",
+		"other_v16.00000.zoekt": "other",
+		"notes.txt":             "local",
+	})
+	after, err := os.Stat(filepath.Join(standbyDir, "other_v16.00000.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("unchanged file was copied again")
+	}
+}
+
+func TestSyncChecksumMismatch(t *testing.T) {
+	standbyDir := t.TempDir()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest" {
+			w.Write([]byte(`[{"Name":"repo_v16.00000.zoekt","Size":5,"SHA256":"0000"}]`))
+			return
+		}
+		w.Write([]byte("shard"))
+	}))
+	defer primary.Close()
+
+	s := &Standby{Primary: primary.URL, Dir: standbyDir}
+	if err := s.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("got %v, want checksum mismatch", err)
+	}
+	if files := readFiles(t, standbyDir); len(files) != 0 {
+		t.Fatalf("got files %v, want none", files)
+	}
+}
+
+func TestHandlerRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"secret.txt": "secret"})
+	h := Handler(dir)
+	for _, path := range []string{"/files/secret.txt", "/files/../secret.txt", "/files/sub/x.zoekt"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = path
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code == http.StatusOK {
+			t.Errorf("%s: got %d, want an error", path, w.Code)
+		}
+	}
+}
+
+// waitFor waits until cond is true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunTakesOver(t *testing.T) {
+	// up switches the primary between serving and a proxy in front of it
+	// answering 502.
+	var up atomic.Bool
+	up.Store(true)
+	h := Handler(t.TempDir())
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "no upstream", http.StatusBadGateway)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+	s := &Standby{Primary: primary.URL, Dir: t.TempDir(), Interval: time.Millisecond, MaxFailures: 2}
+
+	mw := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	code := func(path string) int {
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	var got []int
+	got = append(got, code("/search"), code("/healthz"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The standby keeps waiting while the primary is up.
+	time.Sleep(20 * time.Millisecond)
+	if s.Active() {
+		t.Fatal("standby took over from a healthy primary")
+	}
+
+	up.Store(false)
+	waitFor(t, "the standby takes over", s.Active)
+	got = append(got, code("/search"))
+
+	// The standby hands back once the primary is up again.
+	up.Store(true)
+	waitFor(t, "the standby steps down", func() bool { return !s.Active() })
+	got = append(got, code("/search"))
+
+	want := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK, http.StatusServiceUnavailable}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("status codes mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestRunOnlyTakesOverIfUnavailable(t *testing.T) {
+	// The primary is up, but its files are broken.
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest" {
+			w.Write([]byte(`[{"Name":"repo_v16.00000.zoekt","Size":5,"SHA256":"0000"}]`))
+			return
+		}
+		w.Write([]byte("shard"))
+	}))
+	defer primary.Close()
+	s := &Standby{Primary: primary.URL, Dir: t.TempDir(), Interval: time.Millisecond, MaxFailures: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+	if s.Active() {
+		t.Fatal("standby took over from a primary which is up")
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: &statusError{code: http.StatusServiceUnavailable}, want: true},
+		{err: fmt.Errorf("wrapped: %w", &statusError{code: http.StatusGatewayTimeout}), want: true},
+		{err: &statusError{code: http.StatusUnauthorized}},
+		{err: &statusError{code: http.StatusInternalServerError}},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{err: errors.New("checksum mismatch")},
+	} {
+		if got := unavailable(tc.err); got != tc.want {
+			t.Errorf("unavailable(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	s := &Standby{}
+	call := func(method string) codes.Code {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := s.UnaryServerInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) { return nil, nil })
+		return status.Code(err)
+	}
+
+	const search = "/zoekt.webserver.v1.WebserverService/Search"
+	got := []codes.Code{call(search), call("/grpc.health.v1.Health/Check")}
+	s.active.Store(true)
+	got = append(got, call(search))
+
+	want := []codes.Code{codes.Unavailable, codes.OK, codes.OK}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("codes mismatch (-want +got):\n%s", d)
+	}
+}
@@ -0,0 +1,173 @@
+// Package resultcache caches search results in the webserver, so that
+// repeated queries, like the ones of dashboards, are nearly free.
+//
+// Results are keyed on the query, the search options, the tenant and the
+// epoch of the shards the search uses, which changes whenever one of them is
+// added, removed or replaced. Stale results are never served, they just stop
+// being looked up and age out of the store.
+package resultcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var (
+	metricLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_result_cache_lookups_total",
+		Help: "The number of search result cache lookups, by whether they hit.",
+	}, []string{"result"})
+	metricHits   = metricLookupsTotal.WithLabelValues("hit")
+	metricMisses = metricLookupsTotal.WithLabelValues("miss")
+)
+
+// DefaultMaxEntryBytes is the default of Searcher.MaxEntryBytes.
+const DefaultMaxEntryBytes = 1 << 20
+
+// Searcher caches the results of Streamer in Store.
+type Searcher struct {
+	zoekt.Streamer
+	Store Store
+
+	// Epoch returns the epoch of the shards Streamer searches for q. Searches
+	// are not cached if it returns false.
+	Epoch func(ctx context.Context, q query.Q) (uint64, bool)
+
+	// MaxEntryBytes limits the size of a cached result. Results which are
+	// larger, or can't be encoded, are not cached. It defaults to
+	// DefaultMaxEntryBytes.
+	MaxEntryBytes int
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	key, ok := s.key(ctx, q, opts)
+	if !ok {
+		return s.Streamer.Search(ctx, q, opts)
+	}
+	if sr, ok := s.get(ctx, key); ok {
+		return sr, nil
+	}
+
+	sr, err := s.Streamer.Search(ctx, q, opts)
+	if err == nil && ctx.Err() == nil {
+		s.set(ctx, key, sr)
+	}
+	return sr, err
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	key, ok := s.key(ctx, q, opts)
+	if !ok {
+		return s.Streamer.StreamSearch(ctx, q, opts, sender)
+	}
+	if sr, ok := s.get(ctx, key); ok {
+		sender.Send(sr)
+		return nil
+	}
+
+	// Collect what we stream, until it gets too large to cache.
+	var (
+		mu       sync.Mutex
+		agg      zoekt.SearchResult
+		tooLarge bool
+	)
+	err := s.Streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		mu.Lock()
+		if !tooLarge {
+			agg.Stats.Add(sr.Stats)
+			agg.Files = append(agg.Files, sr.Files...)
+			agg.Directories = append(agg.Directories, sr.Directories...)
+			tooLarge = agg.SizeBytes() > uint64(s.maxEntryBytes())
+		}
+		mu.Unlock()
+		sender.Send(sr)
+	}))
+	if err == nil && ctx.Err() == nil && !tooLarge {
+		zoekt.SortFiles(agg.Files)
+		agg.Directories = zoekt.MergeDirectories(agg.Directories)
+		s.set(ctx, key, &agg)
+	}
+	return err
+}
+
+func (s *Searcher) maxEntryBytes() int {
+	if s.MaxEntryBytes > 0 {
+		return s.MaxEntryBytes
+	}
+	return DefaultMaxEntryBytes
+}
+
+// key returns the cache key of a search, or false if it must not be cached.
+func (s *Searcher) key(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (string, bool) {
+	if opts.Trace || opts.Profile {
+		return "", false
+	}
+	epoch, ok := s.Epoch(ctx, q)
+	if !ok {
+		return "", false
+	}
+
+	// 🚨 SECURITY: Results depend on the tenant, so it must be part of the
+	// key.
+	who := "none"
+	if systemtenant.Is(ctx) {
+		who = "system"
+	} else if tnt, err := tenant.FromContext(ctx); err == nil {
+		who = fmt.Sprint(tnt.ID())
+	}
+
+	// The String of a query abbreviates large sets, so we key on its JSON
+	// encoding.
+	qJSON, err := json.Marshal(query.Canonicalize(q))
+	if err != nil {
+		return "", false
+	}
+
+	keyOpts := *opts
+	keyOpts.SpanContext = nil
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s", epoch, who, qJSON, keyOpts.String())
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (s *Searcher) get(ctx context.Context, key string) (*zoekt.SearchResult, bool) {
+	b, ok := s.Store.Get(ctx, key)
+	if ok {
+		var sr zoekt.SearchResult
+		if err := json.NewDecoder(bytes.NewReader(b)).Decode(&sr); err == nil {
+			metricHits.Inc()
+			return &sr, true
+		}
+	}
+	metricMisses.Inc()
+	return nil, false
+}
+
+func (s *Searcher) set(ctx context.Context, key string, sr *zoekt.SearchResult) {
+	// Partial results depend on timing. Results cut short by the match
+	// limits are cached, since a search which hits a limit again doesn't
+	// return more.
+	if sr.Stats.Crashes > 0 || sr.Stats.ShardsSkipped > 0 || (sr.Stats.FlushReason.Partial() && sr.Stats.FlushReason != zoekt.FlushReasonMatchLimit) {
+		return
+	}
+
+	b, err := json.Marshal(sr)
+	if err != nil || len(b) > s.maxEntryBytes() {
+		return
+	}
+	s.Store.Set(ctx, key, b)
+}
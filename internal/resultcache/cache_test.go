@@ -0,0 +1,118 @@
+package resultcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant/tenanttest"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// countingStreamer returns a single file and counts the searches.
+type countingStreamer struct {
+	zoekt.Streamer
+	searches int
+}
+
+func (s *countingStreamer) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.searches++
+	return &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{FileName: "f", Repository: "r"}},
+		Stats: zoekt.Stats{FileCount: 1},
+	}, nil
+}
+
+func (s *countingStreamer) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sr, err := s.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(sr)
+	return nil
+}
+
+func TestSearcher(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingStreamer{}
+	epoch := uint64(1)
+	s := &Searcher{
+		Streamer: inner,
+		Store:    NewMemoryStore(1 << 20),
+		Epoch:    func(context.Context, query.Q) (uint64, bool) { return epoch, true },
+	}
+	q := &query.Substring{Pattern: "foo"}
+
+	search := func(opts *zoekt.SearchOptions) *zoekt.SearchResult {
+		t.Helper()
+		sr, err := s.Search(ctx, q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sr
+	}
+
+	search(&zoekt.SearchOptions{})
+	sr := search(&zoekt.SearchOptions{})
+	if inner.searches != 1 {
+		t.Fatalf("expected the second search to hit, got %d searches", inner.searches)
+	}
+	if len(sr.Files) != 1 || sr.Files[0].FileName != "f" {
+		t.Fatalf("unexpected cached result %+v", sr)
+	}
+
+	// Other options, traced searches and new epochs miss.
+	search(&zoekt.SearchOptions{ChunkMatches: true})
+	search(&zoekt.SearchOptions{Trace: true})
+	search(&zoekt.SearchOptions{Trace: true})
+	epoch = 2
+	search(&zoekt.SearchOptions{})
+	if inner.searches != 5 {
+		t.Fatalf("expected 5 searches, got %d", inner.searches)
+	}
+
+	// Streams share the cache.
+	var streamed int
+	err := s.StreamSearch(ctx, q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		streamed += len(sr.Files)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.searches != 5 || streamed != 1 {
+		t.Fatalf("expected stream to hit, got %d searches and %d files", inner.searches, streamed)
+	}
+
+	// Queries are keyed on their canonical form.
+	bar := &query.Substring{Pattern: "bar"}
+	for _, q := range []query.Q{query.NewAnd(q, bar), query.NewAnd(bar, q, bar)} {
+		if _, err := s.Search(ctx, q, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.searches != 6 {
+		t.Fatalf("expected reordered query to hit, got %d searches", inner.searches)
+	}
+}
+
+func TestSearcherTenants(t *testing.T) {
+	tenanttest.MockEnforce(t)
+
+	inner := &countingStreamer{}
+	s := &Searcher{
+		Streamer: inner,
+		Store:    NewMemoryStore(1 << 20),
+		Epoch:    func(context.Context, query.Q) (uint64, bool) { return 1, true },
+	}
+	q := &query.Substring{Pattern: "foo"}
+
+	ctx1, ctx2 := tenanttest.NewTestContext(), tenanttest.NewTestContext()
+	for _, ctx := range []context.Context{ctx1, ctx2, ctx1} {
+		if _, err := s.Search(ctx, q, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.searches != 2 {
+		t.Fatalf("expected one search per tenant, got %d", inner.searches)
+	}
+}
@@ -0,0 +1,185 @@
+package resultcache
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store stores encoded search results. Stores are used concurrently. Errors
+// are not reported: a failing store is a cache which misses.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte)
+}
+
+// memoryStore is a Store which keeps the most recently used values in memory.
+type memoryStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	lru   *list.List // of *memoryEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryStore returns a Store which keeps up to maxBytes of values in
+// memory, evicting the least recently used ones.
+func NewMemoryStore(maxBytes int64) Store {
+	return &memoryStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*memoryEntry).value, true
+}
+
+func (s *memoryStore) Set(_ context.Context, key string, value []byte) {
+	if int64(len(value)) > s.maxBytes {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+	s.items[key] = s.lru.PushFront(&memoryEntry{key: key, value: value})
+	s.bytes += int64(len(value))
+	for s.bytes > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *memoryStore) remove(e *list.Element) {
+	entry := s.lru.Remove(e).(*memoryEntry)
+	delete(s.items, entry.key)
+	s.bytes -= int64(len(entry.value))
+}
+
+// redisStore is a Store backed by Redis, so that replicas share results. It
+// speaks just enough of the Redis protocol for GET and SET over a single
+// connection.
+type redisStore struct {
+	addr string
+	ttl  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisTimeout bounds a single Redis command, so a slow Redis can't slow
+// down searches by much.
+const redisTimeout = time.Second
+
+// NewRedisStore returns a Store which keeps values in the Redis server at
+// addr (host:port) for ttl.
+func NewRedisStore(addr string, ttl time.Duration) Store {
+	return &redisStore{addr: addr, ttl: ttl}
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, bool) {
+	v, err := s.do(ctx, "GET", key)
+	if err != nil || v == nil {
+		return nil, false
+	}
+	return v, true
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, value []byte) {
+	_, _ = s.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+}
+
+// do sends a command and returns the value of a bulk string reply, or nil
+// for other replies.
+func (s *redisStore) do(ctx context.Context, args ...string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		var d net.Dialer
+		dialCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+		conn, err := d.DialContext(dialCtx, "tcp", s.addr)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		s.conn, s.r = conn, bufio.NewReader(conn)
+	}
+
+	v, err := s.roundTrip(args)
+	if err != nil {
+		// The connection is in an unknown state.
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+	}
+	return v, err
+}
+
+func (s *redisStore) roundTrip(args []string) ([]byte, error) {
+	if err := s.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(s.conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("redis: malformed reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		return nil, nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,133 @@
+package resultcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(10)
+
+	s.Set(ctx, "a", []byte("1234"))
+	s.Set(ctx, "b", []byte("1234"))
+	if _, ok := s.Get(ctx, "a"); !ok {
+		t.Fatal("expected a to be stored")
+	}
+
+	// b is the least recently used, so it makes room for c.
+	s.Set(ctx, "c", []byte("1234"))
+	if _, ok := s.Get(ctx, "b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if v, ok := s.Get(ctx, key); !ok || string(v) != "1234" {
+			t.Errorf("%s: got %q, %t", key, v, ok)
+		}
+	}
+
+	// Values larger than the store are not stored.
+	s.Set(ctx, "d", make([]byte, 11))
+	if _, ok := s.Get(ctx, "d"); ok {
+		t.Error("expected d to be too large")
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := fakeRedis(t)
+	ctx := context.Background()
+	s := NewRedisStore(addr, time.Minute)
+
+	if _, ok := s.Get(ctx, "a"); ok {
+		t.Fatal("expected miss")
+	}
+	s.Set(ctx, "a", []byte("foo\r\nbar"))
+	if v, ok := s.Get(ctx, "a"); !ok || string(v) != "foo\r\nbar" {
+		t.Fatalf("got %q, %t", v, ok)
+	}
+
+	// Unreachable servers miss.
+	s = NewRedisStore("127.0.0.1:1", time.Minute)
+	if _, ok := s.Get(ctx, "a"); ok {
+		t.Fatal("expected miss")
+	}
+}
+
+// fakeRedis serves GET and SET of the Redis protocol.
+func fakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var (
+		mu     sync.Mutex
+		values = map[string]string{}
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					switch args[0] {
+					case "GET":
+						if v, ok := values[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "SET":
+						values[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					default:
+						fmt.Fprint(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
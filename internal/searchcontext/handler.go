@@ -0,0 +1,94 @@
+package searchcontext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sourcegraph/zoekt/internal/auth"
+)
+
+// maxBodySize limits the size of contexts sent to Handler.
+const maxBodySize = 1 << 20
+
+type listReply struct {
+	Contexts []Context
+}
+
+// Handler serves the CRUD API of s, relative to where it is mounted:
+//
+//	GET    /contexts       lists the contexts
+//	GET    /contexts/NAME  returns a context
+//	PUT    /contexts/NAME  creates or replaces a context
+//	DELETE /contexts/NAME  deletes a context
+//
+// Contexts are encoded as JSON, as in Context. The server sets Name, Owner,
+// Created and Updated. Only admins may create, replace or delete contexts, see
+// auth.RequireAdmin.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /contexts", func(w http.ResponseWriter, r *http.Request) {
+		l := s.List(r.Context())
+		if l == nil {
+			l = []Context{}
+		}
+		writeJSON(w, http.StatusOK, listReply{Contexts: l})
+	})
+	mux.HandleFunc("GET /contexts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.Get(r.Context(), r.PathValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	})
+	mux.Handle("PUT /contexts/{name}", auth.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c Context
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&c); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorReply{Error: fmt.Sprintf("decoding search context: %v", err)})
+			return
+		}
+		name := r.PathValue("name")
+		if c.Name != "" && c.Name != name {
+			writeJSON(w, http.StatusBadRequest, errorReply{Error: fmt.Sprintf("search context name %q doesn't match URL %q", c.Name, name)})
+			return
+		}
+		c.Name = name
+		c, err := s.Put(r.Context(), c)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	})))
+	mux.Handle("DELETE /contexts/{name}", auth.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Delete(r.Context(), r.PathValue("name")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	return mux
+}
+
+type errorReply struct {
+	Error string
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrPermission):
+		code = http.StatusForbidden
+	}
+	writeJSON(w, code, errorReply{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
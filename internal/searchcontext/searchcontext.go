@@ -0,0 +1,313 @@
+// Package searchcontext stores named search contexts: queries, typically a
+// set of repositories and branches plus some filters, which searches refer to
+// as context:NAME instead of repeating them.
+//
+// Contexts are stored as JSON in the index directory and managed with the
+// HTTP API served by Handler. A context belongs to the tenant which created
+// it, and is only visible to other tenants if it is shared.
+package searchcontext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// FileName is the name of the file in the index directory which holds the
+// search contexts.
+const FileName = "search_contexts.json"
+
+var (
+	// ErrNotFound is returned for contexts which don't exist, or which the
+	// caller can't see.
+	ErrNotFound = errors.New("search context not found")
+
+	// ErrPermission is returned when changing a context of another tenant.
+	ErrPermission = errors.New("search context belongs to another tenant")
+)
+
+// validName matches the names of contexts. They appear in query strings and
+// URL paths, so we keep them simple.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._\-]*$`)
+
+// Context is a stored search context.
+type Context struct {
+	Name        string
+	Description string `json:",omitempty"`
+
+	// Repos maps repository names to the branches to search in them. An
+	// empty list of branches searches all branches of the repository.
+	Repos map[string][]string `json:",omitempty"`
+
+	// Query is a query fragment which restricts the context further, eg.
+	// `lang:go -file:_test\.go$`. It may not refer to other contexts.
+	Query string `json:",omitempty"`
+
+	// Owner is the ID of the tenant which created the context, or 0 without
+	// tenants.
+	Owner int `json:",omitempty"`
+
+	// Shared contexts can be used by all tenants. Only the owner can change
+	// them.
+	Shared bool `json:",omitempty"`
+
+	Created time.Time
+	Updated time.Time
+}
+
+// Q returns the query which replaces context:NAME in searches.
+func (c *Context) Q() (query.Q, error) {
+	var and []query.Q
+	if len(c.Repos) > 0 {
+		repos := make([]string, 0, len(c.Repos))
+		for repo := range c.Repos {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+
+		all := query.NewRepoSet()
+		var or []query.Q
+		for _, repo := range repos {
+			branches := c.Repos[repo]
+			if len(branches) == 0 {
+				all.Set[repo] = true
+				continue
+			}
+			var bs []query.Q
+			for _, b := range branches {
+				bs = append(bs, &query.Branch{Pattern: b, Exact: true})
+			}
+			or = append(or, query.NewAnd(query.NewRepoSet(repo), query.NewOr(bs...)))
+		}
+		if len(all.Set) > 0 {
+			or = append(or, all)
+		}
+		and = append(and, query.NewOr(or...))
+	}
+	if c.Query != "" {
+		q, err := query.Parse(c.Query)
+		if err != nil {
+			return nil, fmt.Errorf("search context %q: %w", c.Name, err)
+		}
+		and = append(and, q)
+	}
+	if len(and) == 0 {
+		return &query.Const{Value: true}, nil
+	}
+	return query.NewAnd(and...), nil
+}
+
+func (c *Context) validate() error {
+	if !validName.MatchString(c.Name) {
+		return fmt.Errorf("invalid search context name %q, must match %s", c.Name, validName)
+	}
+	for repo := range c.Repos {
+		if repo == "" {
+			return fmt.Errorf("search context %q has an empty repository name", c.Name)
+		}
+	}
+	q, err := c.Q()
+	if err != nil {
+		return err
+	}
+	nested := false
+	query.VisitAtoms(q, func(q query.Q) {
+		_, ok := q.(*query.SearchContext)
+		nested = nested || ok
+	})
+	if nested {
+		return fmt.Errorf("search context %q may not refer to other search contexts", c.Name)
+	}
+	return nil
+}
+
+// Store holds the search contexts of an index directory. It is safe for
+// concurrent use.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	contexts map[string]*Context
+}
+
+// Open returns the store of indexDir.
+func Open(indexDir string) (*Store, error) {
+	s := &Store{
+		path:     filepath.Join(indexDir, FileName),
+		contexts: map[string]*Context{},
+	}
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var contexts []*Context
+	if err := json.Unmarshal(b, &contexts); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	for _, c := range contexts {
+		s.contexts[c.Name] = c
+	}
+	return s, nil
+}
+
+func canRead(ctx context.Context, c *Context) bool {
+	return c.Shared || tenant.HasAccess(ctx, c.Owner)
+}
+
+// List returns the contexts the caller can see, ordered by name.
+func (s *Store) List(ctx context.Context) []Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var l []Context
+	for _, c := range s.contexts {
+		if canRead(ctx, c) {
+			l = append(l, *c)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// Get returns the context called name.
+func (s *Store) Get(ctx context.Context, name string) (Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.contexts[name]
+	if !ok || !canRead(ctx, c) {
+		return Context{}, ErrNotFound
+	}
+	return *c, nil
+}
+
+// Put creates or replaces the context called c.Name. The owner and creation
+// time of existing contexts are kept.
+func (s *Store) Put(ctx context.Context, c Context) (Context, error) {
+	if err := c.validate(); err != nil {
+		return Context{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if old, ok := s.contexts[c.Name]; ok {
+		if !tenant.HasAccess(ctx, old.Owner) {
+			if !old.Shared {
+				return Context{}, ErrNotFound
+			}
+			return Context{}, ErrPermission
+		}
+		c.Owner, c.Created = old.Owner, old.Created
+	} else {
+		c.Owner, c.Created = owner(ctx), now
+	}
+	c.Updated = now
+
+	old := s.contexts[c.Name]
+	s.contexts[c.Name] = &c
+	if err := s.write(); err != nil {
+		if old != nil {
+			s.contexts[c.Name] = old
+		} else {
+			delete(s.contexts, c.Name)
+		}
+		return Context{}, err
+	}
+	return c, nil
+}
+
+// Delete deletes the context called name.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.contexts[name]
+	if !ok || !canRead(ctx, c) {
+		return ErrNotFound
+	}
+	if !tenant.HasAccess(ctx, c.Owner) {
+		return ErrPermission
+	}
+
+	delete(s.contexts, name)
+	if err := s.write(); err != nil {
+		s.contexts[name] = c
+		return err
+	}
+	return nil
+}
+
+// owner returns the tenant ID of the caller, or 0 if there is none.
+func owner(ctx context.Context) int {
+	t, err := tenant.FromContext(ctx)
+	if err != nil {
+		return 0
+	}
+	return t.ID()
+}
+
+// write atomically replaces the file of the store. s.mu must be held.
+func (s *Store) write() error {
+	contexts := make([]*Context, 0, len(s.contexts))
+	for _, c := range s.contexts {
+		contexts = append(contexts, c)
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].Name < contexts[j].Name })
+	b, err := json.MarshalIndent(contexts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), FileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// Expand replaces the context:NAME atoms in q with the queries of the
+// contexts.
+func (s *Store) Expand(ctx context.Context, q query.Q) (query.Q, error) {
+	var err error
+	q = query.Map(q, func(q query.Q) query.Q {
+		sc, ok := q.(*query.SearchContext)
+		if !ok || err != nil {
+			return q
+		}
+		var c Context
+		if c, err = s.Get(ctx, sc.Name); err != nil {
+			err = fmt.Errorf("search context %q: %w", sc.Name, err)
+			return q
+		}
+		var expanded query.Q
+		if expanded, err = c.Q(); err != nil {
+			return q
+		}
+		return expanded
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
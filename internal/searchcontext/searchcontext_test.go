@@ -0,0 +1,188 @@
+package searchcontext
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/internal/auth"
+	"github.com/sourcegraph/zoekt/internal/tenant/tenanttest"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, Context{Name: "backend", Query: "lang:go"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, Context{Name: "bad name"}); err == nil {
+		t.Fatal("expected error for invalid name")
+	}
+	if _, err := s.Put(ctx, Context{Name: "nested", Query: "context:backend"}); err == nil {
+		t.Fatal("expected error for nested context")
+	}
+	if _, err := s.Put(ctx, Context{Name: "broken", Query: "("}); err == nil {
+		t.Fatal("expected error for unparsable query")
+	}
+
+	// Contexts survive reopening the store.
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Get(ctx, "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Query != "lang:go" || c.Created.IsZero() {
+		t.Fatalf("got %+v", c)
+	}
+
+	if err := s.Delete(ctx, "backend"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "backend"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Put(ctx, Context{
+		Name: "backend-services",
+		Repos: map[string][]string{
+			"github.com/org/api":  {"main", "release"},
+			"github.com/org/auth": nil,
+		},
+		Query: "-file:_test\\.go$",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := s.Expand(ctx, query.NewAnd(&query.SearchContext{Name: "backend-services"}, &query.Substring{Pattern: "foo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := query.Parse(`-file:_test\.go$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := query.NewAnd(
+		query.NewAnd(
+			query.NewOr(
+				query.NewAnd(query.NewRepoSet("github.com/org/api"), query.NewOr(
+					&query.Branch{Pattern: "main", Exact: true},
+					&query.Branch{Pattern: "release", Exact: true},
+				)),
+				query.NewRepoSet("github.com/org/auth"),
+			),
+			fragment,
+		),
+		&query.Substring{Pattern: "foo"},
+	)
+	if d := cmp.Diff(want.String(), q.String()); d != "" {
+		t.Fatalf("-want, +got:\n%s", d)
+	}
+
+	if _, err := s.Expand(ctx, &query.SearchContext{Name: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestSharing(t *testing.T) {
+	tenanttest.MockEnforce(t)
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, other := tenanttest.NewTestContext(), tenanttest.NewTestContext()
+	if _, err := s.Put(owner, Context{Name: "private", Query: "lang:go"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(owner, Context{Name: "shared", Query: "lang:go", Shared: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(other, "private"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for private context of another tenant, want ErrNotFound", err)
+	}
+	if _, err := s.Get(other, "shared"); err != nil {
+		t.Errorf("got %v for shared context", err)
+	}
+	if _, err := s.Put(other, Context{Name: "shared"}); !errors.Is(err, ErrPermission) {
+		t.Errorf("got %v updating shared context of another tenant, want ErrPermission", err)
+	}
+	if err := s.Delete(other, "shared"); !errors.Is(err, ErrPermission) {
+		t.Errorf("got %v deleting shared context of another tenant, want ErrPermission", err)
+	}
+	if l := s.List(other); len(l) != 1 || l[0].Name != "shared" {
+		t.Errorf("got %v, want only the shared context", l)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	admin := true
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Name: "root", Admin: admin}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("PUT", "/contexts/backend", `{"Query": "lang:go", "Shared": true}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT: got %d: %s", w.Code, w.Body)
+	}
+	if w := do("PUT", "/contexts/backend", `{"Name": "frontend"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with mismatched name: got %d", w.Code)
+	}
+	if w := do("GET", "/contexts/backend", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Query":"lang:go"`) {
+		t.Fatalf("GET: got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/contexts", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Name":"backend"`) {
+		t.Fatalf("GET list: got %d: %s", w.Code, w.Body)
+	}
+
+	// Only admins may change contexts.
+	admin = false
+	if w := do("PUT", "/contexts/backend", `{"Query": "lang:rust"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("PUT as non-admin: got %d: %s", w.Code, w.Body)
+	}
+	if w := do("DELETE", "/contexts/backend", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE as non-admin: got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/contexts/backend", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Query":"lang:go"`) {
+		t.Fatalf("GET as non-admin: got %d: %s", w.Code, w.Body)
+	}
+	admin = true
+
+	if w := do("DELETE", "/contexts/backend", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/contexts/backend", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted: got %d: %s", w.Code, w.Body)
+	}
+}
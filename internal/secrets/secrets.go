@@ -0,0 +1,145 @@
+// Package secrets finds strings that look like credentials, like API keys and
+// private keys, at index time. The findings are stored in the index as
+// annotations, so they can be searched with has:secret queries.
+//
+// The rules are deliberately few and specific: they run on every indexed
+// file, and a noisy rule would bury the real findings.
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/grafana/regexp"
+)
+
+// Rule detects one kind of secret.
+type Rule struct {
+	// ID identifies the rule in annotations, eg. "aws-access-key-id".
+	ID string
+
+	// Keywords are literals one of which every match contains. We only run
+	// Regexp on files which contain one, which keeps the cost of scanning
+	// low, so they must be rare in files without secrets.
+	Keywords [][]byte
+
+	Regexp *regexp.Regexp
+}
+
+// Rules are the rules we run on every file.
+var Rules = []Rule{
+	{
+		ID:       "aws-access-key-id",
+		Keywords: [][]byte{[]byte("AKIA")},
+		Regexp:   regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	},
+	{
+		ID: "github-token",
+		Keywords: [][]byte{
+			[]byte("ghp_"), []byte("gho_"), []byte("ghu_"), []byte("ghs_"), []byte("ghr_"),
+		},
+		Regexp: regexp.MustCompile(`\bgh[pousr]_[0-9A-Za-z]{36}\b`),
+	},
+	{
+		ID:       "gitlab-token",
+		Keywords: [][]byte{[]byte("glpat-")},
+		Regexp:   regexp.MustCompile(`\bglpat-[0-9A-Za-z_\-]{20}\b`),
+	},
+	{
+		ID:       "slack-token",
+		Keywords: [][]byte{[]byte("xox")},
+		Regexp:   regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z\-]{10,}`),
+	},
+	{
+		ID:       "google-api-key",
+		Keywords: [][]byte{[]byte("AIza")},
+		Regexp:   regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}`),
+	},
+	{
+		ID:       "stripe-secret-key",
+		Keywords: [][]byte{[]byte("_live_")},
+		Regexp:   regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}`),
+	},
+	{
+		ID:       "private-key",
+		Keywords: [][]byte{[]byte("PRIVATE KEY-----")},
+		Regexp:   regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY-----`),
+	},
+}
+
+// Finding is a possible secret.
+type Finding struct {
+	RuleID string
+
+	// Line is the 1-based line number of the start of the match.
+	Line int
+}
+
+// Scan returns the possible secrets in content, ordered by line. A line has at
+// most one finding per rule.
+func Scan(content []byte) []Finding {
+	var findings []Finding
+	for _, r := range Rules {
+		if !containsAny(content, r.Keywords) {
+			continue
+		}
+		line, lineStart, lastLine := 1, 0, 0
+		for _, m := range r.Regexp.FindAllIndex(content, -1) {
+			line += bytes.Count(content[lineStart:m[0]], []byte{'\n'})
+			lineStart = m[0]
+			if line == lastLine {
+				continue
+			}
+			findings = append(findings, Finding{RuleID: r.ID, Line: line})
+			lastLine = line
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+	return findings
+}
+
+func containsAny(content []byte, keywords [][]byte) bool {
+	for _, k := range keywords {
+		if bytes.Contains(content, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode encodes findings for storage in the index, one "rule:line" per line.
+func Encode(findings []Finding) []byte {
+	var buf bytes.Buffer
+	for _, f := range findings {
+		fmt.Fprintf(&buf, "%s:%d\n", f.RuleID, f.Line)
+	}
+	return buf.Bytes()
+}
+
+// Decode decodes findings encoded with Encode.
+func Decode(data []byte) ([]Finding, error) {
+	var findings []Finding
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		i := bytes.LastIndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("secrets: malformed annotation %q", line)
+		}
+		n, err := strconv.Atoi(string(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("secrets: malformed annotation %q: %w", line, err)
+		}
+		findings = append(findings, Finding{RuleID: string(line[:i]), Line: n})
+	}
+	return findings, nil
+}
@@ -97,7 +97,7 @@ func newOTelCollectorExporter(ctx context.Context, endpoint string) (oteltracesd
 		}
 		client = otlptracegrpc.NewClient(opts...)
 
-	case otlpenv.ProtocolHTTPJSON:
+	case otlpenv.ProtocolHTTPProto, otlpenv.ProtocolHTTPJSON:
 		opts := []otlptracehttp.Option{
 			otlptracehttp.WithEndpoint(trimmedEndpoint),
 		}
@@ -105,6 +105,9 @@ func newOTelCollectorExporter(ctx context.Context, endpoint string) (oteltracesd
 			opts = append(opts, otlptracehttp.WithInsecure())
 		}
 		client = otlptracehttp.NewClient(opts...)
+
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", protocol)
 	}
 
 	// Initialize the exporter
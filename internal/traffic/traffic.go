@@ -0,0 +1,177 @@
+// Package traffic keeps track of how often searches find results in each
+// repository. The webserver records searches and periodically writes the
+// statistics to the index directory, where indexing reads them to give hot
+// repositories small shards, which are searched in parallel, and to merge
+// cold repositories into compound shards.
+package traffic
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the statistics file in the index directory.
+const FileName = "traffic.json"
+
+// DefaultHalfLife is the default half-life of search rates.
+const DefaultHalfLife = 24 * time.Hour
+
+// minRate is the search rate below which we forget a repository.
+const minRate = 0.01
+
+// Stats holds the search rates of repositories.
+type Stats struct {
+	// Rates maps repository names to an exponentially decaying average of
+	// the number of searches per hour with results in the repository.
+	Rates map[string]float64
+
+	// Updated is when Rates were last updated.
+	Updated time.Time
+}
+
+// Hot returns true if repo has at least threshold searches per hour.
+func (s *Stats) Hot(repo string, threshold float64) bool {
+	return s != nil && s.Rates[repo] >= threshold
+}
+
+// Top returns the n repositories with the highest search rates, hottest
+// first.
+func (s *Stats) Top(n int) []string {
+	if s == nil {
+		return nil
+	}
+	repos := make([]string, 0, len(s.Rates))
+	for repo := range s.Rates {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if s.Rates[repos[i]] != s.Rates[repos[j]] {
+			return s.Rates[repos[i]] > s.Rates[repos[j]]
+		}
+		return repos[i] < repos[j]
+	})
+	if len(repos) > n {
+		repos = repos[:n]
+	}
+	return repos
+}
+
+// Read reads the statistics in indexDir. It returns empty statistics if
+// there are none.
+func Read(indexDir string) (*Stats, error) {
+	b, err := os.ReadFile(filepath.Join(indexDir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Stats{Rates: map[string]float64{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s Stats
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if s.Rates == nil {
+		s.Rates = map[string]float64{}
+	}
+	return &s, nil
+}
+
+// Write atomically replaces the statistics in indexDir with s.
+func (s *Stats) Write(indexDir string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(indexDir, FileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(indexDir, FileName))
+}
+
+// Recorder counts searches per repository and folds them into Stats.
+type Recorder struct {
+	// HalfLife is the time after which a search counts half as much towards
+	// the rate of a repository.
+	HalfLife time.Duration
+
+	mu     sync.Mutex
+	counts map[string]int
+	stats  Stats
+}
+
+// NewRecorder returns a recorder which continues from stats. If stats is
+// nil, it starts from scratch.
+func NewRecorder(stats *Stats, now time.Time) *Recorder {
+	r := &Recorder{
+		HalfLife: DefaultHalfLife,
+		counts:   map[string]int{},
+		stats:    Stats{Rates: map[string]float64{}, Updated: now},
+	}
+	if stats != nil {
+		for repo, rate := range stats.Rates {
+			r.stats.Rates[repo] = rate
+		}
+		if !stats.Updated.IsZero() {
+			r.stats.Updated = stats.Updated
+		}
+	}
+	return r
+}
+
+// Record counts one search with results in each of repos.
+func (r *Recorder) Record(repos ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, repo := range repos {
+		r.counts[repo]++
+	}
+}
+
+// Flush folds the searches recorded since the last call into the rates as of
+// now, and returns a copy of the updated statistics.
+func (r *Recorder) Flush(now time.Time) *Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elapsed := now.Sub(r.stats.Updated); elapsed > 0 {
+		// The weight of the rates so far decays exponentially. The searches
+		// since then make up for the rest, as a rate over the elapsed time.
+		decay := math.Exp2(-elapsed.Hours() / r.HalfLife.Hours())
+		for repo, rate := range r.stats.Rates {
+			r.stats.Rates[repo] = rate * decay
+		}
+		for repo, n := range r.counts {
+			r.stats.Rates[repo] += (1 - decay) * float64(n) / elapsed.Hours()
+		}
+		for repo, rate := range r.stats.Rates {
+			if rate < minRate {
+				delete(r.stats.Rates, repo)
+			}
+		}
+		r.stats.Updated = now
+		r.counts = map[string]int{}
+	}
+
+	s := &Stats{Rates: make(map[string]float64, len(r.stats.Rates)), Updated: r.stats.Updated}
+	for repo, rate := range r.stats.Rates {
+		s.Rates[repo] = rate
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package traffic
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRecorder(nil, start)
+	r.HalfLife = time.Hour
+
+	for i := 0; i < 10; i++ {
+		r.Record("hot", "warm")
+	}
+	r.Record("hot")
+
+	// After one half-life, half of the weight goes to the last hour.
+	s := r.Flush(start.Add(time.Hour))
+	if got, want := s.Rates["hot"], 5.5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got hot rate %f, want %f", got, want)
+	}
+	if got, want := s.Rates["warm"], 5.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got warm rate %f, want %f", got, want)
+	}
+	if !s.Hot("hot", 5.5) || s.Hot("warm", 5.5) || s.Hot("cold", 0.001) {
+		t.Errorf("unexpected hot repos in %v", s.Rates)
+	}
+
+	// Without searches, rates decay until we forget the repositories.
+	s = r.Flush(start.Add(2 * time.Hour))
+	if got, want := s.Rates["hot"], 2.75; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got hot rate %f, want %f", got, want)
+	}
+	s = r.Flush(start.Add(24 * time.Hour))
+	if len(s.Rates) != 0 {
+		t.Errorf("got rates %v, want none", s.Rates)
+	}
+}
+
+func TestTop(t *testing.T) {
+	s := &Stats{Rates: map[string]float64{"cold": 0.5, "hot": 10, "warm": 2, "tepid": 2}}
+
+	if got, want := s.Top(3), []string{"hot", "tepid", "warm"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := s.Top(10); len(got) != 4 {
+		t.Errorf("got %v, want all repositories", got)
+	}
+	if got := (*Stats)(nil).Top(1); got != nil {
+		t.Errorf("got %v for nil statistics", got)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Rates) != 0 {
+		t.Fatalf("got rates %v for missing file", s.Rates)
+	}
+
+	want := &Stats{
+		Rates:   map[string]float64{"repo": 12.5},
+		Updated: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := want.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rates["repo"] != 12.5 || !got.Updated.Equal(want.Updated) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A recorder continues where the statistics left off.
+	r := NewRecorder(got, time.Now())
+	if s := r.Flush(want.Updated); s.Rates["repo"] != 12.5 {
+		t.Errorf("got rates %v after restart", s.Rates)
+	}
+}
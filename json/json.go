@@ -3,10 +3,15 @@ package json
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/auth"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -19,6 +24,14 @@ func JSONServer(searcher zoekt.Searcher) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/search", s.jsonSearch)
 	mux.HandleFunc("/list", s.jsonList)
+	mux.Handle("/secrets", auth.RequireAdmin(http.HandlerFunc(s.jsonSecrets)))
+	mux.HandleFunc("/schema/query.json", jsonQuerySchema)
+
+	mux.HandleFunc("/v1/search", s.v1Search)
+	mux.HandleFunc("/v1/stream", s.v1StreamSearch)
+	mux.HandleFunc("/v1/list", s.v1List)
+	mux.HandleFunc("/v1/file", s.v1GetFile)
+	mux.HandleFunc("/schema/v1.json", v1Schema)
 	return mux
 }
 
@@ -27,7 +40,14 @@ type jsonSearcher struct {
 }
 
 type jsonSearchArgs struct {
-	Q       string
+	Q string
+	// Syntax is the syntax of Q, "zoekt" (the default) or "github" for
+	// GitHub code search syntax.
+	Syntax string
+	// Query is a query tree as described by /schema/query.json. It can be
+	// used instead of Q to avoid quoting issues when building queries
+	// programmatically.
+	Query   json.RawMessage
 	RepoIDs *[]uint32
 	Opts    *zoekt.SearchOptions
 }
@@ -37,14 +57,38 @@ type jsonSearchReply struct {
 }
 
 type jsonListArgs struct {
-	Q    string
-	Opts *zoekt.ListOptions
+	Q      string
+	Syntax string
+	Query  json.RawMessage
+	Opts   *zoekt.ListOptions
 }
 
 type jsonListReply struct {
 	List *zoekt.RepoList
 }
 
+// jsonSecretsArgs optionally restricts /secrets to the files matching Q or
+// Query, eg. "repo:^github\.com/org/".
+type jsonSecretsArgs struct {
+	Q      string
+	Syntax string
+	Query  json.RawMessage
+}
+
+// jsonSecret is a possible secret found at index time.
+type jsonSecret struct {
+	Repository string
+	FileName   string
+	Branches   []string `json:",omitempty"`
+	Version    string   `json:",omitempty"`
+	RuleID     string
+	LineNumber int
+}
+
+type jsonSecretsReply struct {
+	Secrets []jsonSecret
+}
+
 func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	w.Header().Add("Content-Type", "application/json")
@@ -60,7 +104,7 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if searchArgs.Q == "" {
+	if searchArgs.Q == "" && len(searchArgs.Query) == 0 {
 		jsonError(w, http.StatusBadRequest, "missing query")
 		return
 	}
@@ -68,7 +112,7 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 		searchArgs.Opts = &zoekt.SearchOptions{}
 	}
 
-	q, err := query.Parse(searchArgs.Q)
+	q, err := parseQuery(searchArgs.Q, searchArgs.Syntax, searchArgs.Query)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
@@ -92,7 +136,7 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 
 	searchResult, err := s.Searcher.Search(ctx, q, searchArgs.Opts)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		searchError(w, err)
 		return
 	}
 
@@ -103,9 +147,85 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// parseQuery returns the query given either as a string q in the given
+// syntax or as a JSON query tree.
+func parseQuery(q, syntax string, tree json.RawMessage) (query.Q, error) {
+	if len(tree) == 0 {
+		return query.ParseSyntax(syntax, q)
+	}
+	if q != "" {
+		return nil, errors.New("only one of Q and Query may be set")
+	}
+	return query.QFromJSON(tree)
+}
+
+func jsonQuerySchema(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/schema+json")
+	w.Write(query.JSONSchema)
+}
+
+// jsonErrorReply is the reply for failed requests. Reason is set for searches
+// which failed for a reason, to the name of its FlushReason in the v1 API, eg.
+// "FLUSH_REASON_TIMEOUT", or to zoekt.RateLimitReason. Class is the rate limit
+// class of rate limited searches.
+type jsonErrorReply struct {
+	Error  string
+	Reason string `json:",omitempty"`
+	Class  string `json:",omitempty"`
+}
+
 func jsonError(w http.ResponseWriter, statusCode int, err string) {
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(struct{ Error string }{Error: err})
+	json.NewEncoder(w).Encode(jsonErrorReply{Error: err})
+}
+
+// searchError replies with the error of a failed search. Rate limited
+// searches get a Retry-After header.
+func searchError(w http.ResponseWriter, err error) {
+	var rateLimitErr *zoekt.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(searchErrorStatus(err))
+	json.NewEncoder(w).Encode(searchErrorReply(err))
+}
+
+func searchErrorReply(err error) jsonErrorReply {
+	reply := jsonErrorReply{Error: err.Error()}
+	var (
+		searchErr    *zoekt.SearchError
+		rateLimitErr *zoekt.RateLimitError
+	)
+	if errors.As(err, &rateLimitErr) {
+		reply.Reason = zoekt.RateLimitReason
+		reply.Class = rateLimitErr.Class
+	} else if errors.As(err, &searchErr) {
+		reply.Reason = searchErr.Reason.ToProto().String()
+	}
+	return reply
+}
+
+// searchErrorStatus returns the HTTP status for an error of Search or
+// StreamSearch.
+func searchErrorStatus(err error) int {
+	var rateLimitErr *zoekt.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return http.StatusTooManyRequests
+	}
+	var searchErr *zoekt.SearchError
+	if !errors.As(err, &searchErr) {
+		return http.StatusInternalServerError
+	}
+	switch searchErr.Reason {
+	case zoekt.FlushReasonTimeout:
+		return http.StatusGatewayTimeout
+	case zoekt.FlushReasonShardUnavailable:
+		return http.StatusServiceUnavailable
+	case zoekt.FlushReasonBudgetExceeded, zoekt.FlushReasonMemoryExceeded, zoekt.FlushReasonMatchLimit:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 // Calculates and sets heuristic defaults on opts for various upper bounds on
@@ -160,7 +280,7 @@ func (s *jsonSearcher) jsonList(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	query, err := query.Parse(listArgs.Q)
+	query, err := parseQuery(listArgs.Q, listArgs.Syntax, listArgs.Query)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
@@ -178,3 +298,59 @@ func (s *jsonSearcher) jsonList(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 }
+
+// jsonSecrets lists the possible secrets found at index time, so security
+// teams can review them without searching for every kind of credential.
+func (s *jsonSearcher) jsonSecrets(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	args := jsonSecretsArgs{}
+	if err := json.NewDecoder(req.Body).Decode(&args); err != nil && !errors.Is(err, io.EOF) {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var q query.Q = &query.HasSecret{}
+	if args.Q != "" || len(args.Query) > 0 {
+		scope, err := parseQuery(args.Q, args.Syntax, args.Query)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q = query.NewAnd(scope, q)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	result, err := s.Searcher.Search(ctx, q, &zoekt.SearchOptions{Secrets: true})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	reply := jsonSecretsReply{Secrets: []jsonSecret{}}
+	for _, f := range result.Files {
+		for _, sec := range f.Secrets {
+			reply.Secrets = append(reply.Secrets, jsonSecret{
+				Repository: f.Repository,
+				FileName:   f.FileName,
+				Branches:   f.Branches,
+				Version:    f.Version,
+				RuleID:     sec.RuleID,
+				LineNumber: sec.LineNumber,
+			})
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
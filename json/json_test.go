@@ -2,15 +2,25 @@ package json_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"hash/crc64"
 	"io"
 	"math"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/sourcegraph/zoekt"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/auth"
 	"github.com/sourcegraph/zoekt/internal/mockSearcher"
 	zjson "github.com/sourcegraph/zoekt/json"
 	"github.com/sourcegraph/zoekt/query"
@@ -193,6 +203,117 @@ func TestProgressNotEncodedInSearch(t *testing.T) {
 	}
 }
 
+func TestClientServerWithQueryTree(t *testing.T) {
+	want := query.NewAnd(&query.Substring{Pattern: "hello world", Content: true}, &query.Language{Language: "Go"})
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: want,
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{FileName: "bin.go"},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	tree, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		body       any
+		wantStatus int
+	}{
+		{body: struct{ Query json.RawMessage }{Query: tree}, wantStatus: 200},
+		{body: struct {
+			Q     string
+			Query json.RawMessage
+		}{Q: "hello", Query: tree}, wantStatus: 400},
+		{body: struct{ Query string }{Query: "hello"}, wantStatus: 400},
+	} {
+		searchBody, err := json.Marshal(tc.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.Post(ts.URL+"/search", "application/json", bytes.NewBuffer(searchBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.StatusCode != tc.wantStatus {
+			body, _ := io.ReadAll(r.Body)
+			t.Fatalf("%s: got status code %d, want %d, body %s", searchBody, r.StatusCode, tc.wantStatus, string(body))
+		}
+	}
+
+	r, err := http.Get(ts.URL + "/schema/query.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r.Body)
+	if !bytes.Equal(body, query.JSONSchema) {
+		t.Fatalf("got schema %q", body)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(mustParse("repo:foo"), &query.HasSecret{}),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{{
+				Repository: "foo/bar",
+				FileName:   "config.go",
+				Secrets: []zoekt.SecretAnnotation{
+					{RuleID: "aws-access-key-id", LineNumber: 4},
+					{RuleID: "private-key", LineNumber: 10},
+				},
+			}},
+		},
+	}
+
+	var admin bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), &auth.Principal{Name: "root", Admin: admin})
+		zjson.JSONServer(mock).ServeHTTP(w, r.WithContext(ctx))
+	}))
+	defer ts.Close()
+
+	// Only admins may list secrets.
+	r, err := http.Post(ts.URL+"/secrets", "application/json", bytes.NewBufferString(`{"Q":"repo:foo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusForbidden {
+		t.Fatalf("got status code %d for a non-admin, want 403", r.StatusCode)
+	}
+
+	admin = true
+	r, err = http.Post(ts.URL+"/secrets", "application/json", bytes.NewBufferString(`{"Q":"repo:foo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+
+	var reply struct {
+		Secrets []struct {
+			Repository string
+			FileName   string
+			RuleID     string
+			LineNumber int
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Secrets) != 2 || reply.Secrets[1].RuleID != "private-key" || reply.Secrets[1].LineNumber != 10 || reply.Secrets[1].FileName != "config.go" {
+		t.Fatalf("got %+v", reply.Secrets)
+	}
+}
+
 func mustParse(s string) query.Q {
 	q, err := query.Parse(s)
 	if err != nil {
@@ -200,3 +321,178 @@ func mustParse(s string) query.Q {
 	}
 	return q
 }
+
+// mockStreamer sends the result of the MockSearcher in two events.
+type mockStreamer struct {
+	*mockSearcher.MockSearcher
+}
+
+func (s mockStreamer) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	if opts.NumContextLines != 3 || opts.FlushWallTime != 100*time.Millisecond {
+		return fmt.Errorf("unexpected options %s", opts)
+	}
+	result, err := s.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(&zoekt.SearchResult{Stats: zoekt.Stats{ShardsScanned: 1}})
+	sender.Send(result)
+	return nil
+}
+
+func TestV1(t *testing.T) {
+	want := &query.Substring{Pattern: "hello", Content: true}
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: want,
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{FileName: "bin.go", Repository: "foo/bar", Content: []byte("hello")},
+			},
+		},
+		WantList: want,
+		RepoList: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{ID: 2, Name: "foo/bar"}}},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mockStreamer{mock}))
+	defer ts.Close()
+
+	post := func(path, body string, wantStatus int) []byte {
+		t.Helper()
+		r, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		b, _ := io.ReadAll(r.Body)
+		if r.StatusCode != wantStatus {
+			t.Fatalf("%s %s: got status code %d, want %d, body %s", path, body, r.StatusCode, wantStatus, b)
+		}
+		return b
+	}
+
+	q := `{"substring":{"pattern":"hello","content":true}}`
+
+	var searchResp proto.SearchResponse
+	b := post("/v1/search", `{"query":`+q+`,"opts":{"numContextLines":3,"chunkMatches":true}}`, 200)
+	if err := protojson.Unmarshal(b, &searchResp); err != nil {
+		t.Fatal(err)
+	}
+	if len(searchResp.GetFiles()) != 1 || string(searchResp.GetFiles()[0].GetContent()) != "hello" {
+		t.Fatalf("unexpected search response %s", b)
+	}
+
+	b = post("/v1/stream", `{"request":{"query":`+q+`,"opts":{"numContextLines":3,"flushWallTime":"0.1s"}}}`, 200)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d events, want 2: %s", len(lines), b)
+	}
+	var event proto.StreamSearchResponse
+	if err := protojson.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if got := event.GetResponseChunk().GetFiles(); len(got) != 1 || got[0].GetRepository() != "foo/bar" {
+		t.Fatalf("unexpected last event %s", lines[1])
+	}
+
+	var listResp proto.ListResponse
+	b = post("/v1/list", `{"query":`+q+`}`, 200)
+	if err := protojson.Unmarshal(b, &listResp); err != nil {
+		t.Fatal(err)
+	}
+	if len(listResp.GetRepos()) != 1 || listResp.GetRepos()[0].GetRepository().GetName() != "foo/bar" {
+		t.Fatalf("unexpected list response %s", b)
+	}
+
+	post("/v1/search", `{}`, 400)
+	post("/v1/search", `{"query":{"substring":{"pattern":"a"}},"unknown":1}`, 400)
+	post("/v1/stream", `{"request":{"query":{"substring":{"pattern":"other"}},"opts":{"numContextLines":3,"flushWallTime":"0.1s"}}}`, 500)
+}
+
+func TestV1GetFile(t *testing.T) {
+	content := []byte("hello world\n")
+	checksum := binary.BigEndian.AppendUint64(nil, crc64.Checksum(content, crc64.MakeTable(crc64.ISO)))
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(query.NewRepoSet("foo/bar"), query.NewFileNameSet("bin.go")),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{FileName: "bin.go", Repository: "foo/bar", Branches: []string{"HEAD"}, Content: content, Checksum: checksum},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	post := func(body string, wantStatus int) []byte {
+		t.Helper()
+		r, err := http.Post(ts.URL+"/v1/file", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		b, _ := io.ReadAll(r.Body)
+		if r.StatusCode != wantStatus {
+			t.Fatalf("%s: got status code %d, want %d, body %s", body, r.StatusCode, wantStatus, b)
+		}
+		return b
+	}
+
+	// "YmluLmdv" is "bin.go", since paths are bytes.
+	var resp proto.GetFileResponse
+	b := post(`{"repository":"foo/bar","path":"YmluLmdv","range":{"start":6}}`, 200)
+	if err := protojson.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.GetContent()) != "world\n" || resp.GetSize() != 12 || resp.GetRange().GetEnd() != 12 {
+		t.Fatalf("unexpected response %s", b)
+	}
+
+	post(`{"repository":"foo/bar","path":"YmluLmdv","range":{"start":6,"end":20}}`, 400)
+	post(`{"repository":"foo/bar"}`, 400)
+
+	mock.SearchResult.Files[0].Checksum = []byte("corrupt!")
+	post(`{"repository":"foo/bar","path":"YmluLmdv"}`, 500)
+
+	mock.SearchResult.Files = nil
+	post(`{"repository":"foo/bar","path":"YmluLmdv"}`, 404)
+}
+
+func TestV1Schema(t *testing.T) {
+	ts := httptest.NewServer(zjson.JSONServer(&mockSearcher.MockSearcher{}))
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/schema/v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	var schema struct {
+		Defs map[string]struct {
+			Properties    map[string]json.RawMessage `json:"properties"`
+			MaxProperties int                        `json:"maxProperties"`
+		} `json:"$defs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every option of the gRPC API must be in the schema, under its JSON
+	// name.
+	opts := schema.Defs["zoekt.webserver.v1.SearchOptions"]
+	for _, name := range []string{"numContextLines", "chunkMatches", "flushWallTime", "maxWallTime"} {
+		if _, ok := opts.Properties[name]; !ok {
+			t.Errorf("SearchOptions is missing %s", name)
+		}
+	}
+	if got := schema.Defs["zoekt.webserver.v1.Q"].MaxProperties; got != 1 {
+		t.Errorf("got maxProperties %d for Q, want 1", got)
+	}
+	for _, name := range []string{"zoekt.webserver.v1.SearchRequest", "zoekt.webserver.v1.StreamSearchResponse", "zoekt.webserver.v1.ListResponse", "zoekt.webserver.v1.GetFileRequest", "zoekt.webserver.v1.FlushReason"} {
+		if _, ok := schema.Defs[name]; !ok {
+			t.Errorf("schema is missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package json
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+// v1SchemaJSON is the JSON schema of the requests and replies of the /v1
+// endpoints. It is generated from the proto definitions, so it can't get out
+// of sync with them.
+var v1SchemaJSON = mustMarshalSchema(protoSchema(
+	"https://github.com/sourcegraph/zoekt/api/schema/v1.json",
+	"Zoekt JSON API v1",
+	(&proto.SearchRequest{}).ProtoReflect().Descriptor(),
+	(&proto.SearchResponse{}).ProtoReflect().Descriptor(),
+	(&proto.StreamSearchRequest{}).ProtoReflect().Descriptor(),
+	(&proto.StreamSearchResponse{}).ProtoReflect().Descriptor(),
+	(&proto.ListRequest{}).ProtoReflect().Descriptor(),
+	(&proto.ListResponse{}).ProtoReflect().Descriptor(),
+	(&proto.GetFileRequest{}).ProtoReflect().Descriptor(),
+	(&proto.GetFileResponse{}).ProtoReflect().Descriptor(),
+))
+
+func mustMarshalSchema(schema map[string]any) []byte {
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// protoSchema returns a JSON schema of the protojson encoding of the messages
+// roots. Every message and enum they reference is defined in $defs under its
+// full proto name, eg. "zoekt.webserver.v1.SearchRequest".
+//
+// Properties use the JSON names of the fields. protojson also accepts the
+// proto names, and enum numbers instead of names, but emits neither.
+func protoSchema(id, title string, roots ...protoreflect.MessageDescriptor) map[string]any {
+	defs := map[string]any{}
+
+	var addMessage func(md protoreflect.MessageDescriptor)
+
+	ref := func(d protoreflect.Descriptor) map[string]any {
+		return map[string]any{"$ref": "#/$defs/" + string(d.FullName())}
+	}
+
+	addEnum := func(ed protoreflect.EnumDescriptor) {
+		name := string(ed.FullName())
+		if _, ok := defs[name]; ok {
+			return
+		}
+		var values []string
+		for i := 0; i < ed.Values().Len(); i++ {
+			values = append(values, string(ed.Values().Get(i).Name()))
+		}
+		defs[name] = map[string]any{"type": "string", "enum": values}
+	}
+
+	// singularSchema is the schema of a single value of fd, ignoring whether
+	// it is repeated.
+	singularSchema := func(fd protoreflect.FieldDescriptor) map[string]any {
+		switch fd.Kind() {
+		case protoreflect.BoolKind:
+			return map[string]any{"type": "boolean"}
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+			return map[string]any{"type": "integer"}
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+			protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			// 64 bit integers are emitted as strings, since JSON numbers lose
+			// precision beyond 2^53.
+			return map[string]any{"type": []string{"string", "integer"}}
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			// "NaN", "Infinity" and "-Infinity" are strings.
+			return map[string]any{"type": []string{"number", "string"}}
+		case protoreflect.StringKind:
+			return map[string]any{"type": "string"}
+		case protoreflect.BytesKind:
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		case protoreflect.EnumKind:
+			addEnum(fd.Enum())
+			return ref(fd.Enum())
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			switch fd.Message().FullName() {
+			case "google.protobuf.Duration":
+				return map[string]any{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]+)?s$`}
+			case "google.protobuf.Timestamp":
+				return map[string]any{"type": "string", "format": "date-time"}
+			}
+			addMessage(fd.Message())
+			return ref(fd.Message())
+		}
+		return map[string]any{}
+	}
+
+	fieldSchema := func(fd protoreflect.FieldDescriptor) map[string]any {
+		switch {
+		case fd.IsMap():
+			return map[string]any{"type": "object", "additionalProperties": singularSchema(fd.MapValue())}
+		case fd.IsList():
+			return map[string]any{"type": "array", "items": singularSchema(fd)}
+		}
+		return singularSchema(fd)
+	}
+
+	addMessage = func(md protoreflect.MessageDescriptor) {
+		name := string(md.FullName())
+		if _, ok := defs[name]; ok {
+			return
+		}
+		properties := map[string]any{}
+		def := map[string]any{"type": "object", "properties": properties}
+		// Define md before its fields, so recursive messages terminate.
+		defs[name] = def
+
+		for i := 0; i < md.Fields().Len(); i++ {
+			fd := md.Fields().Get(i)
+			properties[fd.JSONName()] = fieldSchema(fd)
+		}
+
+		// At most one field of a oneof may be set. We only express this for
+		// messages which consist of a single oneof, like Q.
+		if md.Oneofs().Len() == 1 {
+			if od := md.Oneofs().Get(0); !od.IsSynthetic() && od.Fields().Len() == md.Fields().Len() {
+				def["maxProperties"] = 1
+			}
+		}
+	}
+
+	var oneOf []any
+	for _, md := range roots {
+		addMessage(md)
+		oneOf = append(oneOf, ref(md))
+	}
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     id,
+		"title":   title,
+		"oneOf":   oneOf,
+		"$defs":   defs,
+	}
+}
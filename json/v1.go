@@ -0,0 +1,217 @@
+package json
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/sourcegraph/zoekt"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// The /v1 endpoints mirror the gRPC WebserverService. Requests and replies
+// are the protojson encoding of its messages, so they support every option
+// of the gRPC API and change only as compatibly as the proto definitions.
+// /schema/v1.json is the JSON schema of these messages.
+
+var v1Unmarshal = protojson.UnmarshalOptions{}
+
+var v1Marshal = protojson.MarshalOptions{}
+
+// v1Decode reads the protojson encoded request m from req.
+func v1Decode(w http.ResponseWriter, req *http.Request, m protobuf.Message) bool {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return false
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err := v1Unmarshal.Unmarshal(body, m); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// v1Encode writes m as reply.
+func v1Encode(w http.ResponseWriter, m protobuf.Message) {
+	b, err := v1Marshal.Marshal(m)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Write(append(b, '\n'))
+}
+
+// v1Query converts the query of a request.
+func v1Query(q *proto.Q) (query.Q, error) {
+	if q == nil {
+		return nil, errors.New("missing query")
+	}
+	return query.QFromProto(q)
+}
+
+func (s *jsonSearcher) v1Search(w http.ResponseWriter, req *http.Request) {
+	var searchReq proto.SearchRequest
+	if !v1Decode(w, req, &searchReq) {
+		return
+	}
+
+	q, err := v1Query(searchReq.GetQuery())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := req.Context()
+	opts := zoekt.SearchOptionsFromProto(searchReq.GetOpts())
+	if opts == nil {
+		opts = &zoekt.SearchOptions{}
+	}
+	// Set a timeout if the user hasn't specified one.
+	if opts.MaxWallTime == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	result, err := s.Searcher.Search(ctx, q, opts)
+	if err != nil {
+		searchError(w, err)
+		return
+	}
+	v1Encode(w, result.ToProto())
+}
+
+// v1StreamSearch replies with one StreamSearchResponse per line, as soon as
+// the searcher sends it. FlushWallTime in the options controls how long
+// results are collected and ranked before they are streamed.
+func (s *jsonSearcher) v1StreamSearch(w http.ResponseWriter, req *http.Request) {
+	var streamReq proto.StreamSearchRequest
+	if !v1Decode(w, req, &streamReq) {
+		return
+	}
+
+	q, err := v1Query(streamReq.GetRequest().GetQuery())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := req.Context()
+	opts := zoekt.SearchOptionsFromProto(streamReq.GetRequest().GetOpts())
+	if opts == nil {
+		opts = &zoekt.SearchOptions{}
+	}
+	if opts.MaxWallTime == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	streamer, ok := s.Searcher.(zoekt.Streamer)
+	if !ok {
+		// Send the whole result as the only event.
+		result, err := s.Searcher.Search(ctx, q, opts)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		v1Encode(w, result.ToStreamProto())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	// Once the first event is written, we can't change the status code
+	// anymore. Errors after that are sent as last line instead.
+	started := false
+	err = streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		b, err := v1Marshal.Marshal(result.ToStreamProto())
+		if err != nil {
+			return
+		}
+		started = true
+		w.Write(append(b, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}))
+	if err != nil {
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			searchError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(searchErrorReply(err))
+	}
+}
+
+func (s *jsonSearcher) v1List(w http.ResponseWriter, req *http.Request) {
+	var listReq proto.ListRequest
+	if !v1Decode(w, req, &listReq) {
+		return
+	}
+	q, err := v1Query(listReq.GetQuery())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := s.Searcher.List(req.Context(), q, zoekt.ListOptionsFromProto(listReq.GetOpts()))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	v1Encode(w, list.ToProto())
+}
+
+func (s *jsonSearcher) v1GetFile(w http.ResponseWriter, req *http.Request) {
+	var fileReq proto.GetFileRequest
+	if !v1Decode(w, req, &fileReq) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	f, err := zoekt.GetFile(ctx, s.Searcher, zoekt.FileRequestFromProto(&fileReq))
+	if err != nil {
+		jsonError(w, fileErrorStatus(err), err.Error())
+		return
+	}
+	v1Encode(w, f.ToProto())
+}
+
+// fileErrorStatus returns the HTTP status for an error of zoekt.GetFile.
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, zoekt.ErrInvalidFileRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, zoekt.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, zoekt.ErrFileNotIndexed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func v1Schema(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/schema+json")
+	w.Write(v1SchemaJSON)
+}
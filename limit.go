@@ -99,6 +99,7 @@ func limitChunkMatches(file *FileMatch, limit int) int {
 					}
 					if n == 0 {
 						cm.Content = cm.Content[:b]
+						cm.Tokens = truncateTokens(cm.Tokens, b)
 						break
 					}
 				}
@@ -0,0 +1,282 @@
+package zoekt
+
+import (
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	// maxLiteralSetSize bounds the number of strings in a literalSet.
+	maxLiteralSetSize = 16
+
+	// maxLiteralClassSize is the size of the largest character class
+	// literalSetOf expands. Larger classes turn a literal into many
+	// variants, which cost more to look up than they select.
+	maxLiteralClassSize = 4
+
+	// maxLiteralRepeat is the largest repeat count literalSetOf expands.
+	maxLiteralRepeat = 16
+
+	// maxLiteralOrSize is the number of strings above which
+	// literalSetMatchTree looks for the substring they share rather than
+	// matching each of them.
+	maxLiteralOrSize = 4
+)
+
+// literalSet is the finite set of strings a regular expression matches, as
+// computed by literalSetOf. Zero-width assertions match the empty string,
+// so the set may match in more places than the expression, which is fine for
+// finding candidate documents.
+type literalSet struct {
+	strs []string
+
+	// fold is set if some part of the strings must be matched ignoring case.
+	fold bool
+}
+
+// literalSetOf returns the literalSet of r, or false if r matches
+// infinitely many strings or too many to be useful.
+func literalSetOf(r *syntax.Regexp) (literalSet, bool) {
+	switch r.Op {
+	case syntax.OpLiteral:
+		return literalSet{strs: []string{string(r.Rune)}, fold: r.Flags&syntax.FoldCase != 0}, true
+
+	case syntax.OpCharClass:
+		var strs []string
+		for i := 0; i+1 < len(r.Rune); i += 2 {
+			for c := r.Rune[i]; c <= r.Rune[i+1]; c++ {
+				if len(strs) == maxLiteralClassSize {
+					return literalSet{}, false
+				}
+				strs = append(strs, string(c))
+			}
+		}
+		return literalSet{strs: strs}, len(strs) > 0
+
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return literalSet{strs: []string{""}}, true
+
+	case syntax.OpCapture:
+		return literalSetOf(r.Sub[0])
+
+	case syntax.OpConcat:
+		set := literalSet{strs: []string{""}}
+		for _, sub := range r.Sub {
+			s, ok := literalSetOf(sub)
+			if !ok {
+				return literalSet{}, false
+			}
+			if set, ok = set.concat(s); !ok {
+				return literalSet{}, false
+			}
+		}
+		return set, true
+
+	case syntax.OpAlternate:
+		var set literalSet
+		for _, sub := range r.Sub {
+			s, ok := literalSetOf(sub)
+			if !ok {
+				return literalSet{}, false
+			}
+			if set, ok = set.union(s); !ok {
+				return literalSet{}, false
+			}
+		}
+		return set, true
+
+	case syntax.OpQuest:
+		s, ok := literalSetOf(r.Sub[0])
+		if !ok {
+			return literalSet{}, false
+		}
+		return s.union(literalSet{strs: []string{""}})
+
+	case syntax.OpRepeat:
+		if r.Max < 0 || r.Max > maxLiteralRepeat {
+			return literalSet{}, false
+		}
+		s, ok := literalSetOf(r.Sub[0])
+		if !ok {
+			return literalSet{}, false
+		}
+		pow := literalSet{strs: []string{""}}
+		for i := 0; i < r.Min; i++ {
+			if pow, ok = pow.concat(s); !ok {
+				return literalSet{}, false
+			}
+		}
+		set := pow
+		for i := r.Min; i < r.Max; i++ {
+			if pow, ok = pow.concat(s); !ok {
+				return literalSet{}, false
+			}
+			if set, ok = set.union(pow); !ok {
+				return literalSet{}, false
+			}
+		}
+		return set, true
+	}
+	return literalSet{}, false
+}
+
+// concat returns the set of the strings of s followed by the strings of t.
+func (s literalSet) concat(t literalSet) (literalSet, bool) {
+	if len(s.strs)*len(t.strs) > maxLiteralSetSize {
+		return literalSet{}, false
+	}
+	out := literalSet{fold: s.fold || t.fold}
+	for _, a := range s.strs {
+		for _, b := range t.strs {
+			out.strs = append(out.strs, a+b)
+		}
+	}
+	out.dedup()
+	return out, true
+}
+
+// union returns the set of the strings of s and t.
+func (s literalSet) union(t literalSet) (literalSet, bool) {
+	out := literalSet{
+		strs: append(slices.Clone(s.strs), t.strs...),
+		fold: s.fold || t.fold,
+	}
+	out.dedup()
+	return out, len(out.strs) <= maxLiteralSetSize
+}
+
+func (s *literalSet) dedup() {
+	slices.Sort(s.strs)
+	s.strs = slices.Compact(s.strs)
+}
+
+// required returns the strings of s which don't contain another string of s.
+// A document which contains a string of s contains one of them.
+func (s literalSet) required() []string {
+	strs := s.strs
+	if s.fold {
+		strs = make([]string, 0, len(s.strs))
+		for _, str := range s.strs {
+			strs = append(strs, strings.ToLower(str))
+		}
+		slices.Sort(strs)
+		strs = slices.Compact(strs)
+	}
+
+	var out []string
+	for i, a := range strs {
+		contains := false
+		for j, b := range strs {
+			if i != j && strings.Contains(a, b) {
+				contains = true
+				break
+			}
+		}
+		if !contains {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// commonSubstring returns the longest string which is a substring of every
+// string in strs.
+func commonSubstring(strs []string) string {
+	shortest := strs[0]
+	for _, s := range strs[1:] {
+		if len(s) < len(shortest) {
+			shortest = s
+		}
+	}
+
+	best := ""
+	for i := range shortest {
+		for j := len(shortest); j-i > len(best); j-- {
+			if j < len(shortest) && !utf8.RuneStart(shortest[j]) {
+				continue
+			}
+			sub := shortest[i:j]
+			all := true
+			for _, s := range strs {
+				if !strings.Contains(s, sub) {
+					all = false
+					break
+				}
+			}
+			if all {
+				best = sub
+				break
+			}
+		}
+	}
+	return best
+}
+
+// literalSetMatchTree returns a matchTree for the documents which contain a
+// string of set, or nil if a string is too short to look up.
+func (d *indexData) literalSetMatchTree(set literalSet, minTextSize int, fileName bool, caseSensitive bool) (mt matchTree, singleLine bool, err error) {
+	long := func(s string) bool {
+		return utf8.RuneCountInString(s) >= minTextSize || d.cjkBigrams(s) != nil
+	}
+
+	strs := set.required()
+	if len(strs) > maxLiteralOrSize {
+		// Alternations often share a core, like the name in
+		// (get|set|has|is|can)Name, which is cheaper to look up once.
+		if core := commonSubstring(strs); long(core) {
+			strs = []string{core}
+		}
+	}
+
+	var qs []matchTree
+	singleLine = true
+	for _, s := range strs {
+		if !long(s) {
+			return nil, false, nil
+		}
+		q, err := d.newSubstringMatchTree(&query.Substring{Pattern: s, FileName: fileName, CaseSensitive: !set.fold && caseSensitive})
+		if err != nil {
+			return nil, false, err
+		}
+		singleLine = singleLine && !strings.Contains(s, "\n")
+		qs = append(qs, q)
+	}
+	if len(qs) == 1 {
+		return qs[0], singleLine, nil
+	}
+	return &orMatchTree{qs}, singleLine, nil
+}
+
+// literalRunMatchTree looks for a run of at least two leading subs of a
+// concatenation whose literalSets can be combined into strings long enough to
+// look up, like the parts of (foo|food)bar{2,3}. It returns the number of
+// subs in the run and their matchTree, or 0 if there is no such run.
+func (d *indexData) literalRunMatchTree(subs []*syntax.Regexp, minTextSize int, fileName bool, caseSensitive bool) (n int, mt matchTree, singleLine bool, err error) {
+	set := literalSet{strs: []string{""}}
+	for _, sub := range subs {
+		s, ok := literalSetOf(sub)
+		if !ok {
+			break
+		}
+		next, ok := set.concat(s)
+		if !ok {
+			break
+		}
+		set = next
+		n++
+	}
+	if n < 2 {
+		return 0, nil, false, nil
+	}
+
+	mt, singleLine, err = d.literalSetMatchTree(set, minTextSize, fileName, caseSensitive)
+	if mt == nil || err != nil {
+		return 0, nil, false, err
+	}
+	return n, mt, singleLine, nil
+}
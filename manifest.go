@@ -0,0 +1,133 @@
+package zoekt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ShardManifest describes the shards of a repository that is split across
+// several shards. The builder writes it before moving the new shards into
+// place, and the shard watcher only loads a new version of the shards once
+// all of them match the manifest. This way searches never see a mix of old and new
+// shards, or only some of the shards of a repository.
+type ShardManifest struct {
+	// Shards maps the base name of each shard to the ID in its index
+	// metadata. Shards written by the same build share an ID, but delta
+	// builds add shards to those of earlier builds.
+	Shards map[string]string
+
+	// Directories maps the base name of each shard to the top-level directory
+	// whose files it holds, if the repository is split along its top-level
+	// directories, see build.Options.ShardByDirectory. Shards holding the
+	// files at the root have the directory "".
+	Directories map[string]string `json:",omitempty"`
+}
+
+// ShardManifestPath returns the path of the manifest for the shard set that
+// the shard at shardPath belongs to, eg. "dir/repo_v16.manifest" for
+// "dir/repo_v16.00001.zoekt". It returns "" if shardPath is not named like
+// the shards written by ShardName.
+func ShardManifestPath(shardPath string) string {
+	base, ok := strings.CutSuffix(shardPath, ".zoekt")
+	if !ok {
+		return ""
+	}
+	dot := strings.LastIndexByte(base, '.')
+	if dot < 0 || len(base)-dot-1 != 5 || strings.ContainsRune(base[dot:], filepath.Separator) {
+		return ""
+	}
+	for _, c := range base[dot+1:] {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return base[:dot] + ".manifest"
+}
+
+// ReadShardManifest reads the manifest at path.
+func ReadShardManifest(path string) (*ShardManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m ShardManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// WriteShardManifest atomically replaces the manifest at path with m.
+func WriteShardManifest(path string, m *ShardManifest) (err error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err := f.Chmod(0o666 &^ umask); err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// PruneShardManifest updates the manifest of the shard set that the shard at
+// shardPath belongs to after shards were deleted, eg. because they were
+// merged into a compound shard or their repository was removed. It drops the
+// shards which no longer exist from the manifest, and removes the manifest
+// once none are left, or if it can't be read. It does nothing if the set has
+// no manifest.
+func PruneShardManifest(shardPath string) error {
+	path := ShardManifestPath(shardPath)
+	if path == "" {
+		return nil
+	}
+	m, err := ReadShardManifest(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return os.Remove(path)
+	}
+
+	changed := false
+	for name := range m.Shards {
+		_, err := os.Stat(filepath.Join(filepath.Dir(path), name))
+		if os.IsNotExist(err) {
+			delete(m.Shards, name)
+			delete(m.Directories, name)
+			changed = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(m.Shards) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return WriteShardManifest(path, m)
+}
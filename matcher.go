@@ -0,0 +1,86 @@
+package zoekt
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Matcher evaluates query.Custom atoms, for domain specific searches that
+// the built-in atoms can't express, like version ranges. Register it with
+// RegisterMatcher.
+type Matcher interface {
+	// Compile parses the argument of a query.Custom atom. It is called once
+	// per search, see CompileMatchers.
+	Compile(arg string) (CompiledMatcher, error)
+}
+
+// CompiledMatcher matches one query.Custom atom against file contents. It
+// must be safe for concurrent use.
+type CompiledMatcher interface {
+	// Candidates returns strings, at least one of which occurs (case
+	// sensitively) in the content of every matching file. We look them up in
+	// the ngram index, and only call Match for files which contain one of
+	// them. If Candidates returns nothing, Match is called for every file.
+	Candidates() []string
+
+	// Match returns the [start, end) byte offsets of the matches in content,
+	// in order and without overlaps.
+	Match(content []byte) [][2]int
+}
+
+var (
+	matchersMu sync.RWMutex
+	matchers   = map[string]Matcher{}
+)
+
+// RegisterMatcher makes m evaluate query.Custom atoms with the given name,
+// which are written as NAME:ARG in query strings. It panics if name is
+// already in use, either by a built-in atom or another matcher, so call it
+// from an init function.
+func RegisterMatcher(name string, m Matcher) {
+	if err := query.RegisterCustomAtom(name); err != nil {
+		panic(err)
+	}
+
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	matchers[name] = m
+}
+
+func compileMatcher(q *query.Custom) (CompiledMatcher, error) {
+	matchersMu.RLock()
+	m, ok := matchers[q.Name]
+	matchersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no matcher registered for %s:", q.Name)
+	}
+	return m.Compile(q.Arg)
+}
+
+// compiledCustom is a query.Custom atom with its compiled matcher, see
+// CompileMatchers.
+type compiledCustom struct {
+	*query.Custom
+	matcher CompiledMatcher
+}
+
+// CompileMatchers compiles the query.Custom atoms of q, so that searching
+// many shards with the result compiles each of them only once. Shards compile
+// the atoms which are left as is.
+func CompileMatchers(q query.Q) (query.Q, error) {
+	var err error
+	q = query.Map(q, func(q query.Q) query.Q {
+		c, ok := q.(*query.Custom)
+		if !ok || err != nil {
+			return q
+		}
+		var m CompiledMatcher
+		if m, err = compileMatcher(c); err != nil {
+			return q
+		}
+		return &compiledCustom{Custom: c, matcher: m}
+	})
+	return q, err
+}
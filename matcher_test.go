@@ -0,0 +1,129 @@
+package zoekt
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// minVersionMatcher matches "version N" for N at least the argument.
+type minVersionMatcher struct {
+	min int
+}
+
+var versionRE = regexp.MustCompile(`version (\d+)`)
+
+func (m minVersionMatcher) Compile(arg string) (CompiledMatcher, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, err
+	}
+	return minVersionMatcher{min: n}, nil
+}
+
+func (m minVersionMatcher) Candidates() []string {
+	return []string{"version"}
+}
+
+func (m minVersionMatcher) Match(content []byte) [][2]int {
+	var found [][2]int
+	for _, idx := range versionRE.FindAllSubmatchIndex(content, -1) {
+		if n, _ := strconv.Atoi(string(content[idx[2]:idx[3]])); n >= m.min {
+			found = append(found, [2]int{idx[0], idx[1]})
+		}
+	}
+	return found
+}
+
+// countingMatcher counts how often it is compiled.
+type countingMatcher struct {
+	minVersionMatcher
+	compiles *atomic.Int32
+}
+
+func (m countingMatcher) Compile(arg string) (CompiledMatcher, error) {
+	m.compiles.Add(1)
+	return m.minVersionMatcher.Compile(arg)
+}
+
+var countingCompiles atomic.Int32
+
+func init() {
+	RegisterMatcher("minversion", minVersionMatcher{})
+	RegisterMatcher("countversion", countingMatcher{compiles: &countingCompiles})
+}
+
+func TestCustomMatcher(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "old", Content: []byte("requires version 1\n")},
+		Document{Name: "new", Content: []byte("requires version 3\nand version 4\n")},
+		Document{Name: "none", Content: []byte("no versions here\n")},
+	)
+
+	cases := []struct {
+		q    string
+		want []string
+	}{
+		{"minversion:2", []string{"new"}},
+		{"minversion:1", []string{"new", "old"}},
+		{"minversion:9", nil},
+		{"-minversion:2 requires", []string{"old"}},
+	}
+	for _, tc := range cases {
+		q, err := query.Parse(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := searchForTest(t, b, q)
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		sort.Strings(got)
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.q, d)
+		}
+	}
+
+	// Only the matcher contributes matches, not the candidates.
+	res := searchForTest(t, b, &query.Custom{Name: "minversion", Arg: "4"})
+	if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 || string(res.Files[0].LineMatches[0].Line) != "and version 4\n" {
+		t.Errorf("got %v, want one match on the second line", res.Files)
+	}
+
+	_, err := searcherForTest(t, b).Search(context.Background(), &query.Custom{Name: "nope"}, &SearchOptions{})
+	if err == nil {
+		t.Error("expected error for unregistered matcher")
+	}
+}
+
+func TestCompileMatchers(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "new", Content: []byte("requires version 3\n")},
+	)
+
+	countingCompiles.Store(0)
+	q, err := CompileMatchers(query.NewAnd(&query.Substring{Pattern: "requires"}, &query.Custom{Name: "countversion", Arg: "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if res := searchForTest(t, b, q); len(res.Files) != 1 {
+			t.Fatalf("got %v, want a match", res.Files)
+		}
+	}
+	if got := countingCompiles.Load(); got != 1 {
+		t.Errorf("compiled %d times, want once", got)
+	}
+
+	if _, err := CompileMatchers(&query.Custom{Name: "nope"}); err == nil {
+		t.Error("expected error for unregistered matcher")
+	}
+}
@@ -41,6 +41,10 @@ type candidateMatch struct {
 	caseSensitive bool
 	fileName      bool
 	symbol        bool
+
+	// asciiFold is set if the match and the content are ASCII, so case
+	// insensitive matches can be compared with asciiEqualFold.
+	asciiFold bool
 }
 
 // Matches content against the substring, and populates byteMatchSz on success
@@ -50,6 +54,9 @@ func (m *candidateMatch) matchContent(content []byte) bool {
 
 		m.byteMatchSz = uint32(len(m.substrBytes))
 		return comp
+	} else if m.asciiFold {
+		m.byteMatchSz = uint32(len(m.substrLowered))
+		return asciiEqualFold(m.substrLowered, content[m.byteOffset:])
 	} else {
 		// It is tempting to try a simple ASCII based
 		// comparison if possible, but we need more
@@ -57,17 +64,50 @@ func (m *candidateMatch) matchContent(content []byte) bool {
 		// case variants (the ASCII 'k' has the Kelvin symbol
 		// as upper case variant). We can only degrade to
 		// ASCII if we are sure that both the corpus and the
-		// query is ASCII only
+		// query is ASCII only, see asciiFold.
 		sz, ok := caseFoldingEqualsRunes(m.substrLowered, content[m.byteOffset:])
 		m.byteMatchSz = uint32(sz)
 		return ok
 	}
 }
 
-// matchIterator is a docIterator that produces candidateMatches for a given document
-type matchIterator interface {
+// docSeeker is a docIterator which can skip documents cheaply, because it
+// iterates over posting lists.
+//
+// Conjunctions use it for a zig-zag join: they seek all children to the
+// largest next document of any child until the children agree. So an And of
+// one rare and several common atoms only considers the documents of the rare
+// atom that also contain the common ones, instead of every document of the
+// rare atom.
+type docSeeker interface {
 	docIterator
 
+	// seek advances to the first document >= doc where we may find something
+	// interesting and returns it, or maxUInt32 if there is none. Unlike
+	// nextDoc this mutates state, but it never moves backwards.
+	//
+	// Only seek past documents which can't match. prepare may still be called
+	// for documents before the position of seek, in which case there are no
+	// candidates.
+	seek(doc uint32) uint32
+}
+
+// seekDoc seeks it to doc if it is a docSeeker. For other docIterators it
+// returns the larger of doc and nextDoc, which is where they may match next.
+func seekDoc(it docIterator, doc uint32) uint32 {
+	if s, ok := it.(docSeeker); ok {
+		return s.seek(doc)
+	}
+	if next := it.nextDoc(); next > doc {
+		return next
+	}
+	return doc
+}
+
+// matchIterator is a docSeeker that produces candidateMatches for a given document
+type matchIterator interface {
+	docSeeker
+
 	candidates() []*candidateMatch
 
 	// updateStats is called twice. After matchtree construction and after
@@ -98,6 +138,10 @@ func (t *noMatchTree) nextDoc() uint32 {
 
 func (t *noMatchTree) prepare(uint32) {}
 
+func (t *noMatchTree) seek(uint32) uint32 {
+	return maxUInt32
+}
+
 func (t *noMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	return matchesNone
 }
@@ -166,6 +210,19 @@ func (i *ngramDocIterator) prepare(nextDoc uint32) {
 	i.fileIdx = nextDoc
 }
 
+func (i *ngramDocIterator) seek(doc uint32) uint32 {
+	if next := i.nextDoc(); next >= doc {
+		return next
+	}
+	if doc >= uint32(len(i.ends)) {
+		return maxUInt32
+	}
+	// prepare skips the hits before doc, but leaves the hits of doc for
+	// candidates.
+	i.prepare(doc)
+	return i.nextDoc()
+}
+
 func (i *ngramDocIterator) updateStats(s *Stats) {
 	i.iter.updateStats(s)
 	s.NgramMatches += i.matchCount
@@ -16,14 +16,18 @@ package zoekt
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"slices"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/grafana/regexp"
 
+	"github.com/sourcegraph/zoekt/internal/lockfile"
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
 	"github.com/sourcegraph/zoekt/query"
 )
@@ -156,6 +160,50 @@ type andLineMatchTree struct {
 
 type andMatchTree struct {
 	children []matchTree
+
+	// mutable
+	//
+	// order is the order in which matches evaluates the children. It starts
+	// out as the order of children, which planMatchTree sorts by estimated
+	// matches, and is adapted to the observed selectivity of the children
+	// every andReorderInterval decided documents, see andChildStats.
+	order   []int
+	stats   []andChildStats
+	decided int
+}
+
+// andReorderInterval is the number of documents an and node decides between
+// reordering its children.
+const andReorderInterval = 32
+
+// andChildStats are the outcomes of evaluating a child of an and node.
+type andChildStats struct {
+	// evals is the number of documents the child decided, and rejects the
+	// number of those it didn't match.
+	evals   uint32
+	rejects uint32
+
+	// cost is the sum of the costs at which the child decided documents.
+	cost uint32
+}
+
+// score estimates the fraction of documents the child rejects per unit of
+// cost. Children with a higher score are evaluated first, as they are the
+// cheapest way to reject a document.
+func (s *andChildStats) score() float64 {
+	// The priors keep children we know little about in the middle: one
+	// document out of two rejected, at the cost of loading content.
+	rejectRate := float64(s.rejects+1) / float64(s.evals+2)
+	avgCost := float64(s.cost+costContent) / float64(s.evals+1)
+	return rejectRate / (1 + avgCost)
+}
+
+// sameLineMatchTree matches documents with lines on which all children and
+// none of the negated children match. The embedded andMatchTree has the
+// children, which find the candidate documents.
+type sameLineMatchTree struct {
+	andMatchTree
+	negated []matchTree
 }
 
 type orMatchTree struct {
@@ -211,6 +259,27 @@ func newRegexpMatchTree(s *query.Regexp) *regexpMatchTree {
 	}
 }
 
+// literalMatchTree matches a literal by scanning the content with
+// bytes.Index, which uses the vectorized byte search of the runtime. It
+// replaces the regexp engine for literals which have to be searched in every
+// document, because they are too short to look up ngrams for.
+type literalMatchTree struct {
+	literal []byte
+
+	// fold is set for case insensitive ASCII literals, see
+	// indexData.canFoldASCII. literal is in lower case then.
+	fold bool
+
+	fileName bool
+
+	// mutable
+	evaluated bool
+	found     []*candidateMatch
+
+	// nextDoc, prepare.
+	bruteForceMatchTree
+}
+
 // \bLITERAL\b
 type wordMatchTree struct {
 	word string
@@ -225,6 +294,19 @@ type wordMatchTree struct {
 	bruteForceMatchTree
 }
 
+// customMatchTree evaluates a query.Custom atom with a registered Matcher.
+type customMatchTree struct {
+	name    string
+	matcher CompiledMatcher
+
+	// mutable
+	evaluated bool
+	found     []*candidateMatch
+
+	// nextDoc, prepare.
+	bruteForceMatchTree
+}
+
 type substrMatchTree struct {
 	matchIterator
 
@@ -256,6 +338,11 @@ type symbolRegexpMatchTree struct {
 	regexp *regexp.Regexp
 	all    bool // skips regex match if .*
 
+	// keepSymbol, if set, is called with the shard-wide index of each symbol
+	// before matching it. Symbols for which it returns false are skipped.
+	keepSymbol    func(symIdx uint32) bool
+	fileEndSymbol []uint32
+
 	reEvaluated bool
 	found       []*candidateMatch
 }
@@ -278,8 +365,15 @@ func (t *symbolRegexpMatchTree) matches(cp *contentProvider, cost int, known map
 	sections := cp.docSections()
 	content := cp.data(false)
 
+	start := time.Now()
+	defer func() { cp.stats.RegexpTime += time.Since(start) }()
+
 	found := t.found[:0]
 	for i, sec := range sections {
+		if t.keepSymbol != nil && !t.keepSymbol(t.fileEndSymbol[cp.idx]+uint32(i)) {
+			continue
+		}
+
 		var idx []int
 		if t.all {
 			idx = []int{0, int(sec.End - sec.Start)}
@@ -314,6 +408,10 @@ type symbolSubstrMatchTree struct {
 	doc      uint32
 	sections []DocumentSection
 
+	// keepSymbol, if set, is called with the shard-wide index of each matching
+	// symbol. Matches for which it returns false are dropped.
+	keepSymbol func(symIdx uint32) bool
+
 	secID uint32
 }
 
@@ -327,9 +425,11 @@ func (t *symbolSubstrMatchTree) prepare(doc uint32) {
 	}
 
 	var sections []DocumentSection
+	var symStart uint32
 	if len(t.sections) > 0 {
 		most := t.fileEndSymbol[len(t.fileEndSymbol)-1]
 		if most == uint32(len(t.sections)) {
+			symStart = t.fileEndSymbol[doc]
 			sections = t.sections[t.fileEndSymbol[doc]:t.fileEndSymbol[doc+1]]
 		} else {
 			for t.secID < uint32(len(t.sections)) && t.sections[t.secID].Start < fileStart {
@@ -341,6 +441,7 @@ func (t *symbolSubstrMatchTree) prepare(doc uint32) {
 				symbolEnd++
 			}
 
+			symStart = t.secID
 			sections = t.sections[t.secID:symbolEnd]
 		}
 	}
@@ -360,7 +461,7 @@ func (t *symbolSubstrMatchTree) prepare(doc uint32) {
 			continue
 		}
 
-		if end <= sections[secIdx].End {
+		if end <= sections[secIdx].End && (t.keepSymbol == nil || t.keepSymbol(symStart+uint32(secIdx))) {
 			t.current[0].symbol = true
 			t.current[0].symbolIdx = uint32(secIdx)
 			trimmed = append(trimmed, t.current[0])
@@ -395,12 +496,31 @@ func (t *regexpMatchTree) prepare(doc uint32) {
 	t.bruteForceMatchTree.prepare(doc)
 }
 
+func (t *literalMatchTree) prepare(doc uint32) {
+	t.found = t.found[:0]
+	t.evaluated = false
+	t.bruteForceMatchTree.prepare(doc)
+}
+
 func (t *wordMatchTree) prepare(doc uint32) {
 	t.found = t.found[:0]
 	t.evaluated = false
 	t.bruteForceMatchTree.prepare(doc)
 }
 
+func (t *customMatchTree) prepare(doc uint32) {
+	t.found = t.found[:0]
+	t.evaluated = false
+	t.bruteForceMatchTree.prepare(doc)
+}
+
+func (t *sameLineMatchTree) prepare(doc uint32) {
+	t.andMatchTree.prepare(doc)
+	for _, c := range t.negated {
+		c.prepare(doc)
+	}
+}
+
 func (t *orMatchTree) prepare(doc uint32) {
 	for _, c := range t.children {
 		c.prepare(doc)
@@ -500,6 +620,58 @@ func (t *branchQueryMatchTree) nextDoc() uint32 {
 	return maxUInt32
 }
 
+// all seek methods
+
+// seek does a zig-zag join of the children, see docSeeker.
+func (t *andMatchTree) seek(doc uint32) uint32 {
+	// Children which can't seek only need to be asked once.
+	for _, c := range t.children {
+		if _, ok := c.(docSeeker); !ok {
+			doc = seekDoc(c, doc)
+		}
+	}
+
+	for doc != maxUInt32 {
+		agreed := true
+		for _, c := range t.children {
+			s, ok := c.(docSeeker)
+			if !ok {
+				continue
+			}
+			if m := s.seek(doc); m > doc {
+				doc = m
+				agreed = false
+			}
+		}
+		if agreed {
+			break
+		}
+	}
+	return doc
+}
+
+func (t *orMatchTree) seek(doc uint32) uint32 {
+	min := uint32(maxUInt32)
+	for _, c := range t.children {
+		if m := seekDoc(c, doc); m < min {
+			min = m
+		}
+	}
+	return min
+}
+
+func (t *fileNameMatchTree) seek(doc uint32) uint32 {
+	return seekDoc(t.child, doc)
+}
+
+func (t *boostMatchTree) seek(doc uint32) uint32 {
+	return seekDoc(t.child, doc)
+}
+
+func (t *noVisitMatchTree) seek(doc uint32) uint32 {
+	return seekDoc(t.matchTree, doc)
+}
+
 // all String methods
 
 func (t *bruteForceMatchTree) String() string {
@@ -514,6 +686,10 @@ func (t *andMatchTree) String() string {
 	return fmt.Sprintf("and%v", t.children)
 }
 
+func (t *sameLineMatchTree) String() string {
+	return fmt.Sprintf("line(%v, not %v)", t.children, t.negated)
+}
+
 func (t *regexpMatchTree) String() string {
 	f := ""
 	if t.fileName {
@@ -522,6 +698,17 @@ func (t *regexpMatchTree) String() string {
 	return fmt.Sprintf("%sre(%s)", f, t.regexp)
 }
 
+func (t *literalMatchTree) String() string {
+	f := ""
+	if t.fileName {
+		f = "f"
+	}
+	if t.fold {
+		f += "i"
+	}
+	return fmt.Sprintf("%slit(%q)", f, t.literal)
+}
+
 func (t *wordMatchTree) String() string {
 	f := ""
 	if t.fileName {
@@ -530,6 +717,10 @@ func (t *wordMatchTree) String() string {
 	return fmt.Sprintf("%sword(%s)", f, t.word)
 }
 
+func (t *customMatchTree) String() string {
+	return fmt.Sprintf("custom(%s)", t.name)
+}
+
 func (t *orMatchTree) String() string {
 	return fmt.Sprintf("or%v", t.children)
 }
@@ -585,6 +776,11 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 		}
 	case *andLineMatchTree:
 		visitMatchTree(&s.andMatchTree, f)
+	case *sameLineMatchTree:
+		visitMatchTree(&s.andMatchTree, f)
+		for _, ch := range s.negated {
+			visitMatchTree(ch, f)
+		}
 	case *noVisitMatchTree:
 		visitMatchTree(s.matchTree, f)
 	case *notMatchTree:
@@ -602,6 +798,39 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 	}
 }
 
+// visitSymbolMatchTrees calls f on the symbol atoms in t, ie. the
+// symbolSubstrMatchTree and symbolRegexpMatchTree nodes, without descending
+// into them.
+func visitSymbolMatchTrees(t matchTree, f func(matchTree)) {
+	switch s := t.(type) {
+	case *symbolSubstrMatchTree, *symbolRegexpMatchTree:
+		f(t)
+	case *andMatchTree:
+		for _, ch := range s.children {
+			visitSymbolMatchTrees(ch, f)
+		}
+	case *orMatchTree:
+		for _, ch := range s.children {
+			visitSymbolMatchTrees(ch, f)
+		}
+	case *andLineMatchTree:
+		visitSymbolMatchTrees(&s.andMatchTree, f)
+	case *sameLineMatchTree:
+		visitSymbolMatchTrees(&s.andMatchTree, f)
+		for _, ch := range s.negated {
+			visitSymbolMatchTrees(ch, f)
+		}
+	case *noVisitMatchTree:
+		visitSymbolMatchTrees(s.matchTree, f)
+	case *notMatchTree:
+		visitSymbolMatchTrees(s.child, f)
+	case *fileNameMatchTree:
+		visitSymbolMatchTrees(s.child, f)
+	case *boostMatchTree:
+		visitSymbolMatchTrees(s.child, f)
+	}
+}
+
 // updateMatchTreeStats calls updateStats on all atoms in mt which have that
 // function defined.
 func updateMatchTreeStats(mt matchTree, stats *Stats) {
@@ -630,6 +859,9 @@ func visitMatches(t matchTree, known map[matchTree]bool, weight float64, f func(
 		}
 	case *andLineMatchTree:
 		visitMatches(&s.andMatchTree, known, weight, f)
+	case *sameLineMatchTree:
+		// The negated children only remove lines.
+		visitMatches(&s.andMatchTree, known, weight, f)
 	case *orMatchTree:
 		for _, ch := range s.children {
 			if known[ch] {
@@ -746,26 +978,144 @@ nextLine:
 	return matchesNone
 }
 
+func (t *sameLineMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if state := evalMatchTree(cp, cost, known, &t.andMatchTree); state != matchesFound {
+		return state
+	}
+	for _, ch := range t.negated {
+		if evalMatchTree(cp, cost, known, ch) == matchesRequiresHigherCost {
+			return matchesRequiresHigherCost
+		}
+	}
+
+	// lines of the matches of mt, if it matched.
+	lines := func(mt matchTree) map[int]bool {
+		found := map[int]bool{}
+		if !known[mt] {
+			return found
+		}
+		visitMatches(mt, known, 1, func(atom matchTree, _ float64) {
+			if ms := atomMatches(atom); ms != nil {
+				for _, m := range *ms {
+					found[cp.newlines().atOffset(m.byteOffset)] = true
+				}
+			}
+		})
+		return found
+	}
+
+	var same map[int]bool
+	for i, ch := range t.children {
+		chLines := lines(ch)
+		if i == 0 {
+			same = chLines
+			continue
+		}
+		for l := range same {
+			if !chLines[l] {
+				delete(same, l)
+			}
+		}
+	}
+	for _, ch := range t.negated {
+		for l := range lines(ch) {
+			delete(same, l)
+		}
+	}
+	if len(same) == 0 {
+		return matchesNone
+	}
+
+	// Only return the matches on the same lines.
+	visitMatches(&t.andMatchTree, known, 1, func(atom matchTree, _ float64) {
+		if ms := atomMatches(atom); ms != nil {
+			kept := (*ms)[:0]
+			for _, m := range *ms {
+				if same[cp.newlines().atOffset(m.byteOffset)] {
+					kept = append(kept, m)
+				}
+			}
+			*ms = kept
+		}
+	})
+	return matchesFound
+}
+
+// atomMatches returns the matches an atom found in the current document, or
+// nil if it doesn't return matches.
+func atomMatches(mt matchTree) *[]*candidateMatch {
+	switch t := mt.(type) {
+	case *substrMatchTree:
+		return &t.current
+	case *regexpMatchTree:
+		return &t.found
+	case *literalMatchTree:
+		return &t.found
+	case *wordMatchTree:
+		return &t.found
+	case *customMatchTree:
+		return &t.found
+	case *symbolRegexpMatchTree:
+		return &t.found
+	}
+	return nil
+}
+
 func (t *andMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.order == nil {
+		t.order = make([]int, len(t.children))
+		for i := range t.order {
+			t.order[i] = i
+		}
+		t.stats = make([]andChildStats, len(t.children))
+	}
+
 	// We have found matches unless a child needs to do more work or it hasn't
 	// found matches.
 	state := matchesFound
 
-	for _, ch := range t.children {
-		switch evalMatchTree(cp, cost, known, ch) {
+children:
+	for _, i := range t.order {
+		ch := t.children[i]
+		_, decided := known[ch]
+		ms := evalMatchTree(cp, cost, known, ch)
+		if !decided && ms != matchesRequiresHigherCost {
+			t.stats[i].evals++
+			t.stats[i].cost += uint32(cost)
+			if ms == matchesNone {
+				t.stats[i].rejects++
+			}
+		}
+
+		switch ms {
 		case matchesRequiresHigherCost:
 			// keep evaluating other children incase we come across matchesNone
 			state = matchesRequiresHigherCost
 		case matchesFound:
 			// will return this if every child has this value
 		case matchesNone:
-			return matchesNone
+			state = matchesNone
+			break children
 		}
 	}
 
+	if state != matchesRequiresHigherCost {
+		t.decided++
+		if t.decided%andReorderInterval == 0 {
+			t.reorder()
+		}
+	}
 	return state
 }
 
+// reorder sorts the evaluation order of the children by their score, highest
+// first. Children with the same score keep their order.
+func (t *andMatchTree) reorder() {
+	slices.SortStableFunc(t.order, func(a, b int) int {
+		return cmp.Compare(t.stats[b].score(), t.stats[a].score())
+	})
+}
+
 func (t *orMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	// we could short-circuit, but we want to use the other possibilities as a
 	// ranking signal. So we always return the most conservative state.
@@ -799,7 +1149,10 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 	}
 
 	cp.stats.RegexpsConsidered++
-	idxs := t.regexp.FindAllIndex(cp.data(t.fileName), -1)
+	data := cp.data(t.fileName)
+	start := time.Now()
+	idxs := t.regexp.FindAllIndex(data, -1)
+	cp.stats.RegexpTime += time.Since(start)
 	found := t.found[:0]
 	for _, idx := range idxs {
 		cm := &candidateMatch{
@@ -816,6 +1169,90 @@ func (t *regexpMatchTree) matches(cp *contentProvider, cost int, known map[match
 	return matchesStateForSlice(t.found)
 }
 
+func (t *literalMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.evaluated {
+		return matchesStateForSlice(t.found)
+	}
+
+	if cost < costContent {
+		return matchesRequiresHigherCost
+	}
+
+	if t.fold {
+		t.found = findLiteralFold(cp.data(t.fileName), t.literal, t.fileName, t.found[:0])
+	} else {
+		t.found = findLiteral(cp.data(t.fileName), t.literal, t.fileName, t.found[:0])
+	}
+	t.evaluated = true
+
+	return matchesStateForSlice(t.found)
+}
+
+// findLiteral appends the non-overlapping matches of literal in data to found.
+func findLiteral(data, literal []byte, fileName bool, found []*candidateMatch) []*candidateMatch {
+	offset := 0
+	for {
+		idx := bytes.Index(data[offset:], literal)
+		if idx < 0 {
+			return found
+		}
+		found = append(found, &candidateMatch{
+			byteOffset:  uint32(offset + idx),
+			byteMatchSz: uint32(len(literal)),
+			fileName:    fileName,
+		})
+		offset += idx + len(literal)
+	}
+}
+
+// findLiteralFold is findLiteral for a lower case ASCII literal, which also
+// matches upper case ASCII letters in data. It looks for both cases of the
+// first byte of the literal with bytes.IndexByte and compares the rest with
+// asciiEqualFold, so it doesn't copy data.
+func findLiteralFold(data, lower []byte, fileName bool, found []*candidateMatch) []*candidateMatch {
+	first := lower[0]
+	upper := first
+	if 'a' <= first && first <= 'z' {
+		upper -= 'a' - 'A'
+	}
+	indexFrom := func(c byte, off int) int {
+		if i := bytes.IndexByte(data[off:], c); i >= 0 {
+			return off + i
+		}
+		return -1
+	}
+
+	nextLower, nextUpper := indexFrom(first, 0), -1
+	if upper != first {
+		nextUpper = indexFrom(upper, 0)
+	}
+	for {
+		i := nextLower
+		if i < 0 || (nextUpper >= 0 && nextUpper < i) {
+			i = nextUpper
+		}
+		if i < 0 {
+			return found
+		}
+
+		off := i + 1
+		if asciiEqualFold(lower, data[i:]) {
+			found = append(found, &candidateMatch{
+				byteOffset:  uint32(i),
+				byteMatchSz: uint32(len(lower)),
+				fileName:    fileName,
+			})
+			off = i + len(lower)
+		}
+		if nextLower >= 0 && nextLower < off {
+			nextLower = indexFrom(first, off)
+		}
+		if nextUpper >= 0 && nextUpper < off {
+			nextUpper = indexFrom(upper, off)
+		}
+	}
+}
+
 func (t *wordMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	if t.evaluated {
 		return matchesStateForSlice(t.found)
@@ -855,6 +1292,28 @@ func (t *wordMatchTree) matches(cp *contentProvider, cost int, known map[matchTr
 	return matchesStateForSlice(t.found)
 }
 
+func (t *customMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.evaluated {
+		return matchesStateForSlice(t.found)
+	}
+
+	if cost < costRegexp {
+		return matchesRequiresHigherCost
+	}
+
+	found := t.found[:0]
+	for _, m := range t.matcher.Match(cp.data(false)) {
+		found = append(found, &candidateMatch{
+			byteOffset:  uint32(m[0]),
+			byteMatchSz: uint32(m[1] - m[0]),
+		})
+	}
+	t.found = found
+	t.evaluated = true
+
+	return matchesStateForSlice(t.found)
+}
+
 // breakMatchesOnNewlines returns matches resulting from breaking each element
 // of cms on newlines within text.
 func breakMatchesOnNewlines(cms []*candidateMatch, text []byte) []*candidateMatch {
@@ -963,6 +1422,16 @@ type matchTreeOpt struct {
 	// DisableWordMatchOptimization is used to disable the use of wordMatchTree.
 	// This was added since we do not support wordMatchTree with symbol search.
 	DisableWordMatchOptimization bool
+
+	// DisableLiteralMatchOptimization is used to disable the use of
+	// literalMatchTree, which symbol search doesn't support either.
+	DisableLiteralMatchOptimization bool
+
+	// DisableRegexpRewrite makes regexps always run the regexp engine, rather
+	// than an equivalent matchTree. The equivalent matchTree matches the same
+	// documents, but not necessarily in the same places, which matters for
+	// SameLine.
+	DisableRegexpRewrite bool
 }
 
 func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error) {
@@ -976,13 +1445,13 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 		// original regexp, it returns true. An equivalent matchTree has the same
 		// behaviour as the original regexp and can be used instead.
 		//
-		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(s.Regexp, ngramSize, s.FileName, s.CaseSensitive)
+		subMT, isEq, _, err := d.regexpToMatchTreeRecursive(s.Regexp, d.shardNgramSize(), s.FileName, s.CaseSensitive)
 		if err != nil {
 			return nil, err
 		}
 		// if the query can be used in place of the regexp
 		// return the subtree
-		if isEq {
+		if isEq && !opt.DisableRegexpRewrite {
 			return subMT, nil
 		}
 
@@ -991,6 +1460,8 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			// A common search we get is "\bLITERAL\b". Avoid the regex engine and
 			// provide something faster.
 			tr = wmt
+		} else if lmt, ok := d.regexpToLiteralMatchTree(s, opt); ok {
+			tr = lmt
 		} else {
 			tr = newRegexpMatchTree(s)
 		}
@@ -1009,7 +1480,7 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			}
 			r = append(r, ct)
 		}
-		return &andMatchTree{r}, nil
+		return &andMatchTree{children: r}, nil
 	case *query.Or:
 		var r []matchTree
 		for _, ch := range s.Children {
@@ -1020,6 +1491,44 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			r = append(r, ct)
 		}
 		return &orMatchTree{r}, nil
+	case *query.SameLine:
+		lineOpt := opt
+		lineOpt.DisableRegexpRewrite = true
+
+		t := &sameLineMatchTree{}
+		for _, ch := range s.Children {
+			neg, isNot := ch.(*query.Not)
+			if isNot {
+				ch = neg.Child
+			}
+			switch c := ch.(type) {
+			case *query.Substring:
+				if c.FileName || !c.Content {
+					return nil, fmt.Errorf("line: needs content patterns, got %s", c)
+				}
+			case *query.Regexp:
+				if c.FileName || !c.Content {
+					return nil, fmt.Errorf("line: needs content patterns, got %s", c)
+				}
+			default:
+				return nil, fmt.Errorf("line: unsupported child %s", ch)
+			}
+
+			ct, err := d.newMatchTree(ch, lineOpt)
+			if err != nil {
+				return nil, err
+			}
+			if isNot {
+				t.negated = append(t.negated, ct)
+			} else {
+				t.children = append(t.children, ct)
+			}
+		}
+		if len(t.children) == 0 {
+			return nil, fmt.Errorf("line: needs a pattern which is not negated")
+		}
+		return t, nil
+
 	case *query.Not:
 		ct, err := d.newMatchTree(s.Child, opt)
 		return &notMatchTree{
@@ -1027,6 +1536,11 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 		}, err
 
 	case *query.Type:
+		if s.Type == query.TypeDirectory {
+			// Grouping by directory only applies to the whole query, see
+			// indexData.Search. Below the root it matches like its child.
+			return d.newMatchTree(s.Child, opt)
+		}
 		if s.Type != query.TypeFileName {
 			break
 		}
@@ -1052,8 +1566,25 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 		}, nil
 
 	case *query.Substring:
+		if s.Pattern != "" && !opt.DisableLiteralMatchOptimization && !d.hasNgrams(s.Pattern) {
+			// Every document has to be searched for the pattern, which is
+			// cheaper without the regexp engine newSubstringMatchTree uses.
+			if lmt, ok := d.newLiteralMatchTree(s.Pattern, s.CaseSensitive, s.FileName); ok {
+				return lmt, nil
+			}
+		}
 		return d.newSubstringMatchTree(s)
 
+	case *query.Custom:
+		m, err := compileMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		return d.newCustomMatchTree(s.Name, m)
+
+	case *compiledCustom:
+		return d.newCustomMatchTree(s.Name, s.matcher)
+
 	case *query.Branch:
 		masks := make([]uint64, 0, len(d.repoMetaData))
 		if s.Pattern == "HEAD" {
@@ -1095,10 +1626,69 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			},
 		}, nil
 
+	case *query.Dependency:
+		if len(d.dependenciesIndex) == 0 {
+			return &noMatchTree{Why: "dependency"}, nil
+		}
+		return &docMatchTree{
+			reason:  "dependency",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				deps, err := d.readDependencies(docID)
+				if err != nil {
+					log.Printf("error reading dependencies for document %d on shard %s: %v", docID, d.file.Name(), err)
+					return false
+				}
+				return lockfile.Contains(deps, s.Name, s.Version)
+			},
+		}, nil
+
+	case *query.FileMetric:
+		if d.fileMetrics.sz == 0 {
+			return &noMatchTree{Why: "file metric"}, nil
+		}
+		return &docMatchTree{
+			reason:  "file metric",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				m, err := d.readFileMetrics(docID)
+				if err != nil {
+					log.Printf("error reading file metrics for document %d on shard %s: %v", docID, d.file.Name(), err)
+					return false
+				}
+				v, _ := m.Get(s.Metric)
+				return s.Match(v)
+			},
+		}, nil
+
+	case *query.Generated:
+		if len(d.generated) == 0 {
+			return &noMatchTree{Why: "generated"}, nil
+		}
+		return &docMatchTree{
+			reason:    "generated",
+			numDocs:   d.numDocs(),
+			predicate: d.isGenerated,
+		}, nil
+
+	case *query.HasSecret:
+		if len(d.secretsIndex) == 0 {
+			return &noMatchTree{Why: "secret"}, nil
+		}
+		return &docMatchTree{
+			reason:    "secret",
+			numDocs:   d.numDocs(),
+			predicate: d.hasSecrets,
+		}, nil
+
+	case *query.SearchContext:
+		return nil, fmt.Errorf("search context %q must be expanded by the server before searching", s.Name)
+
 	case *query.Symbol:
 		// Disable WordMatchTree since we don't support it in symbols yet.
 		optCopy := opt
 		optCopy.DisableWordMatchOptimization = true
+		optCopy.DisableLiteralMatchOptimization = true
 
 		subMT, err := d.newMatchTree(s.Expr, optCopy)
 		if err != nil {
@@ -1131,6 +1721,38 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			matchTree: subMT,
 		}, nil
 
+	case *query.SymbolKind:
+		subMT, err := d.newMatchTree(s.Expr, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		keep := func(symIdx uint32) bool {
+			return s.Match(string(d.symbols.kindOf(symIdx)))
+		}
+		chainKeep := func(prev func(uint32) bool) func(uint32) bool {
+			if prev == nil {
+				return keep
+			}
+			return func(symIdx uint32) bool {
+				return prev(symIdx) && keep(symIdx)
+			}
+		}
+
+		// The filter applies to every symbol atom in Expr, eg. to both sides
+		// of "sym.kind:function (sym:foo or sym:bar)". Other atoms don't
+		// match symbols, so there is nothing to filter.
+		visitSymbolMatchTrees(subMT, func(mt matchTree) {
+			switch t := mt.(type) {
+			case *symbolSubstrMatchTree:
+				t.keepSymbol = chainKeep(t.keepSymbol)
+			case *symbolRegexpMatchTree:
+				t.keepSymbol = chainKeep(t.keepSymbol)
+				t.fileEndSymbol = d.fileEndSymbol
+			}
+		})
+		return subMT, nil
+
 	case *query.FileNameSet:
 		return &docMatchTree{
 			reason:  "FileNameSet",
@@ -1176,6 +1798,21 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			},
 		}, nil
 
+	case *query.RepoMeta:
+		reposWant := make([]bool, len(d.repoMetaData))
+		for repoIdx, r := range d.repoMetaData {
+			if s.Match(r.Metadata) {
+				reposWant[repoIdx] = true
+			}
+		}
+		return &docMatchTree{
+			reason:  s.String(),
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return reposWant[d.repos[docID]]
+			},
+		}, nil
+
 	case *query.RepoIDs:
 		reposWant := make([]bool, len(d.repoMetaData))
 		for repoIdx, r := range d.repoMetaData {
@@ -1234,6 +1871,35 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 	return nil, nil
 }
 
+// newCustomMatchTree returns the matchTree of a query.Custom atom with the
+// given name, whose matcher is compiled already.
+func (d *indexData) newCustomMatchTree(name string, m CompiledMatcher) (matchTree, error) {
+	// Like for regexps, the candidates find the documents to verify, but
+	// only the matcher contributes matches.
+	var candidates []matchTree
+	for _, c := range m.Candidates() {
+		if c == "" {
+			candidates = nil
+			break
+		}
+		ct, err := d.newSubstringMatchTree(&query.Substring{Pattern: c, CaseSensitive: true, Content: true})
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, ct)
+	}
+
+	var sub matchTree = &bruteForceMatchTree{}
+	if len(candidates) > 0 {
+		sub = &orMatchTree{candidates}
+	}
+	return &andMatchTree{
+		children: []matchTree{
+			&customMatchTree{name: name, matcher: m}, &noVisitMatchTree{sub},
+		},
+	}, nil
+}
+
 func (d *indexData) newSubstringMatchTree(s *query.Substring) (matchTree, error) {
 	st := &substrMatchTree{
 		query:         s,
@@ -1241,7 +1907,7 @@ func (d *indexData) newSubstringMatchTree(s *query.Substring) (matchTree, error)
 		fileName:      s.FileName,
 	}
 
-	if utf8.RuneCountInString(s.Pattern) < ngramSize {
+	if !d.hasNgrams(s.Pattern) {
 		return newRegexpMatchTree(&query.Regexp{
 			Regexp:        &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune(s.Pattern)},
 			FileName:      s.FileName,
@@ -1258,12 +1924,40 @@ func (d *indexData) newSubstringMatchTree(s *query.Substring) (matchTree, error)
 	return st, nil
 }
 
+// hasNgrams returns true if the shard has ngrams to look up pattern.
+func (d *indexData) hasNgrams(pattern string) bool {
+	return utf8.RuneCountInString(pattern) >= d.shardNgramSize() || d.cjkBigrams(pattern) != nil
+}
+
+// newLiteralMatchTree returns a literalMatchTree for pattern, or false if it
+// is case insensitive and can't be folded as ASCII.
+func (d *indexData) newLiteralMatchTree(pattern string, caseSensitive, fileName bool) (_ *literalMatchTree, ok bool) {
+	literal := []byte(pattern)
+	if caseSensitive {
+		return &literalMatchTree{literal: literal, fileName: fileName}, true
+	}
+	if !d.canFoldASCII(literal) {
+		return nil, false
+	}
+	return &literalMatchTree{literal: toLower(literal), fold: true, fileName: fileName}, true
+}
+
+// regexpToLiteralMatchTree returns a literalMatchTree for a regexp which is a
+// plain literal.
+func (d *indexData) regexpToLiteralMatchTree(q *query.Regexp, opt matchTreeOpt) (_ *literalMatchTree, ok bool) {
+	if opt.DisableLiteralMatchOptimization || q.Regexp.Op != syntax.OpLiteral {
+		return nil, false
+	}
+	caseSensitive := q.CaseSensitive && q.Regexp.Flags&syntax.FoldCase == 0
+	return d.newLiteralMatchTree(string(q.Regexp.Rune), caseSensitive, q.FileName)
+}
+
 func regexpToWordMatchTree(q *query.Regexp, opt matchTreeOpt) (_ *wordMatchTree, ok bool) {
 	if opt.DisableWordMatchOptimization {
 		return nil, false
 	}
 	// Needs to be case sensitive
-	if !q.CaseSensitive || q.Regexp.Flags&syntax.FoldCase != 0 {
+	if !q.CaseSensitive {
 		return nil, false
 	}
 	// We want a regex that looks like Op.Concat[OpWordBoundary OpLiteral OpWordBoundary]
@@ -1274,6 +1968,10 @@ func regexpToWordMatchTree(q *query.Regexp, opt matchTreeOpt) (_ *wordMatchTree,
 	if sub[0].Op != syntax.OpWordBoundary || sub[1].Op != syntax.OpLiteral || sub[2].Op != syntax.OpWordBoundary {
 		return nil, false
 	}
+	// The literal carries the flag of an inline (?i), eg. in (?i:word).
+	if sub[1].Flags&syntax.FoldCase != 0 {
+		return nil, false
+	}
 
 	return &wordMatchTree{
 		word:     string(sub[1].Rune),
@@ -1352,6 +2050,30 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 		if mt.child == nil {
 			return nil, nil
 		}
+	case *sameLineMatchTree:
+		for i, child := range mt.children {
+			newChild, err := pruneMatchTree(child)
+			if err != nil {
+				return nil, err
+			}
+			if newChild == nil {
+				return nil, nil
+			}
+			mt.children[i] = newChild
+		}
+		// Negated children which can't match exclude no lines.
+		n := 0
+		for _, child := range mt.negated {
+			newChild, err := pruneMatchTree(child)
+			if err != nil {
+				return nil, err
+			}
+			if newChild != nil {
+				mt.negated[n] = newChild
+				n++
+			}
+		}
+		mt.negated = mt.negated[:n]
 	case *andLineMatchTree:
 		child, err := pruneMatchTree(&mt.andMatchTree)
 		if err != nil {
@@ -1380,7 +2102,9 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 	case *docMatchTree:
 	case *bruteForceMatchTree:
 	case *regexpMatchTree:
+	case *literalMatchTree:
 	case *wordMatchTree:
+	case *customMatchTree:
 	}
 	return mt, err
 }
@@ -15,6 +15,7 @@
 package zoekt
 
 import (
+	"fmt"
 	"reflect"
 	"regexp/syntax"
 	"testing"
@@ -421,3 +422,146 @@ func TestIsRegexpAll(t *testing.T) {
 		}
 	}
 }
+
+// funcMatchTree matches the documents for which match returns true, at cost.
+type funcMatchTree struct {
+	bruteForceMatchTree
+	cost  int
+	match func(doc uint32) bool
+	evals int
+}
+
+func (t *funcMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if cost < t.cost {
+		return matchesRequiresHigherCost
+	}
+	t.evals++
+	return matchesStatePred(t.match(t.docID))
+}
+
+func TestAndMatchTreeReorder(t *testing.T) {
+	broad := &funcMatchTree{cost: costRegexp, match: func(doc uint32) bool { return doc%10 != 0 }}
+	narrow := &funcMatchTree{cost: costRegexp, match: func(doc uint32) bool { return doc%10 == 0 }}
+	mt := &andMatchTree{children: []matchTree{broad, narrow}}
+
+	for doc := uint32(0); doc < 1000; doc++ {
+		mt.prepare(doc)
+		known := map[matchTree]bool{}
+		for cost := costMin; cost <= costMax; cost++ {
+			if evalMatchTree(nil, cost, known, mt) != matchesRequiresHigherCost {
+				break
+			}
+		}
+	}
+
+	if mt.order[0] != 1 {
+		t.Errorf("got order %v, want the narrow child first", mt.order)
+	}
+	// Once the narrow child is evaluated first, the broad one is only
+	// evaluated for the documents the narrow one matches.
+	if want := andReorderInterval + 1000/10; broad.evals > want {
+		t.Errorf("broad child evaluated %d times, want at most %d", broad.evals, want)
+	}
+}
+
+func TestLiteralMatchTree(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("ab Ab aB abab")},
+		Document{Name: "f2", Content: []byte("AB")},
+		Document{Name: "ab", Content: []byte("xyz")},
+	)
+
+	for _, q := range []query.Q{
+		&query.Substring{Pattern: "ab", CaseSensitive: true, Content: true},
+		&query.Regexp{Regexp: mustParseRE("ab"), CaseSensitive: true, Content: true},
+	} {
+		// Line matches merge adjacent matches, chunk matches don't.
+		res := searchForTest(t, b, q, SearchOptions{ChunkMatches: true})
+		if res.RegexpsConsidered != 0 {
+			t.Errorf("%s: got %d regexps considered, want 0", q, res.RegexpsConsidered)
+		}
+		if len(res.Files) != 1 || res.Files[0].FileName != "f1" {
+			t.Fatalf("%s: got %v, want f1", q, res.Files)
+		}
+
+		var got []uint32
+		for _, cm := range res.Files[0].ChunkMatches {
+			for _, r := range cm.Ranges {
+				got = append(got, r.Start.ByteOffset)
+			}
+		}
+		if want := []uint32{0, 9, 11}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got matches at %v, want %v", q, got, want)
+		}
+	}
+}
+
+func BenchmarkFindLiteral(b *testing.B) {
+	var data []byte
+	for i := 0; len(data) < 1<<20; i++ {
+		data = append(data, fmt.Sprintf("func f%d(x int) int {\n\treturn x * %d\n}\n\n", i, i)...)
+	}
+	literal := []byte("7(")
+
+	b.Run("regexp", func(b *testing.B) {
+		re := regexp.MustCompile(regexp.QuoteMeta(string(literal)))
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			re.FindAllIndex(data, -1)
+		}
+	})
+	b.Run("literal", func(b *testing.B) {
+		var found []*candidateMatch
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			found = findLiteral(data, literal, false, found[:0])
+		}
+	})
+}
+
+func TestLiteralMatchTreeFold(t *testing.T) {
+	fileOffsets := func(res *SearchResult) map[string][]uint32 {
+		got := map[string][]uint32{}
+		for _, f := range res.Files {
+			for _, cm := range f.ChunkMatches {
+				for _, r := range cm.Ranges {
+					got[f.FileName] = append(got[f.FileName], r.Start.ByteOffset)
+				}
+			}
+		}
+		return got
+	}
+	opts := SearchOptions{ChunkMatches: true}
+
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("ab Ab aB abab")},
+		Document{Name: "f2", Content: []byte("AB")},
+		Document{Name: "f3", Content: []byte("xyz")},
+	)
+	for _, q := range []query.Q{
+		&query.Substring{Pattern: "ab", Content: true},
+		&query.Regexp{Regexp: mustParseRE("(?i)ab"), CaseSensitive: true, Content: true},
+	} {
+		res := searchForTest(t, b, q, opts)
+		if res.RegexpsConsidered != 0 {
+			t.Errorf("%s: got %d regexps considered, want 0", q, res.RegexpsConsidered)
+		}
+		want := map[string][]uint32{"f1": {0, 3, 6, 9, 11}, "f2": {0}}
+		if got := fileOffsets(res); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", q, got, want)
+		}
+	}
+
+	// The Kelvin sign folds to k, so shards which aren't ASCII need the
+	// regexp engine.
+	b = testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("\u00e9 K")},
+	)
+	res := searchForTest(t, b, &query.Substring{Pattern: "k", Content: true}, opts)
+	if res.RegexpsConsidered == 0 {
+		t.Error("got no regexps considered for a shard which isn't ASCII")
+	}
+	if want := map[string][]uint32{"f1": {3}}; !reflect.DeepEqual(fileOffsets(res), want) {
+		t.Errorf("got %v, want %v", fileOffsets(res), want)
+	}
+}
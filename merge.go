@@ -98,8 +98,32 @@ func merge(ds ...*indexData) (*IndexBuilder, error) {
 		return ds[i].repoMetaData[0].priority > ds[j].repoMetaData[0].priority
 	})
 
+	// Ngram postings are copied by re-adding the documents, so all shards must
+	// agree on the ngram size.
+	size := ds[0].shardNgramSize()
+	for _, d := range ds[1:] {
+		if n := d.shardNgramSize(); n != size {
+			return nil, fmt.Errorf("cannot merge %s with ngram size %d into shards with ngram size %d", d.String(), n, size)
+		}
+		// Content of symbol-only shards can't be told apart from regular
+		// content once merged.
+		if d.metaData.SymbolsOnly != ds[0].metaData.SymbolsOnly {
+			return nil, fmt.Errorf("cannot merge %s: shards must all be symbol-only or all be full", d.String())
+		}
+	}
+
 	ib := newIndexBuilder()
 	ib.indexFormatVersion = NextIndexFormatVersion
+	if err := ib.SetNgramSize(size); err != nil {
+		return nil, err
+	}
+	ib.SymbolsOnly = ds[0].metaData.SymbolsOnly
+	// File metrics and bloom filters are recomputed when re-adding the
+	// documents.
+	for _, d := range ds {
+		ib.FileMetrics = ib.FileMetrics || d.fileMetrics.sz > 0
+		ib.BloomFilter = ib.BloomFilter || d.contentBloom != nil
+	}
 
 	for _, d := range ds {
 		lastRepoID := -1
@@ -198,6 +222,12 @@ func explode(dstDir string, f IndexFile, ibFuncs ...indexBuilderFunc) (map[strin
 
 			ib = newIndexBuilder()
 			ib.indexFormatVersion = IndexFormatVersion
+			if err := ib.SetNgramSize(d.shardNgramSize()); err != nil {
+				return shardNames, err
+			}
+			ib.FileMetrics = d.fileMetrics.sz > 0
+			ib.BloomFilter = d.contentBloom != nil
+			ib.SymbolsOnly = d.metaData.SymbolsOnly
 			if err := ib.setRepository(&d.repoMetaData[repoID]); err != nil {
 				return shardNames, err
 			}
@@ -237,6 +267,10 @@ func addDocument(d *indexData, ib *IndexBuilder, repoID int, docID uint32) error
 		return err
 	}
 
+	if doc.Encoding, err = d.readEncoding(docID); err != nil {
+		return err
+	}
+
 	doc.SymbolsMetaData = make([]*Symbol, len(doc.Symbols))
 	for i := range doc.SymbolsMetaData {
 		doc.SymbolsMetaData[i] = d.symbols.data(d.fileEndSymbol[docID] + uint32(i))
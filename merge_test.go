@@ -2,7 +2,6 @@ package zoekt
 
 import (
 	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -10,11 +9,12 @@ import (
 
 // We compare 2 simple shards before and after the transformation
 // explode(merge(shard1, shard2)). We expect the input and output shards to be
-// identical.
+// identical, so the shards have to be written by the current version, with
+// all sections.
 func TestExplode(t *testing.T) {
 	simpleShards := []string{
-		"./testdata/shards/repo_v16.00000.zoekt",
-		"./testdata/shards/repo2_v16.00000.zoekt",
+		"./testdata/shards/repo_checksums_v16.00000.zoekt",
+		"./testdata/shards/repo2_checksums_v16.00000.zoekt",
 	}
 
 	// repo name -> IndexMetadata
@@ -87,8 +87,13 @@ func TestExplode(t *testing.T) {
 		}
 	}
 
+	// Exploded shards are named after their repository.
 	for _, s := range simpleShards {
-		checkSameShards(t, s, filepath.Join(tmpDir, filepath.Base(s)))
+		repos, _, err := ReadMetadataPath(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSameShards(t, s, ShardName(tmpDir, repos[0].Name, IndexFormatVersion, 0))
 	}
 }
 
@@ -0,0 +1,181 @@
+package zoekt
+
+import (
+	"encoding/json"
+	"math/bits"
+	"slices"
+)
+
+// ShardStats are statistics about the content of a shard, stored in its
+// planStats section. The query planner uses them to estimate how many
+// documents the atoms of a query match, see planMatchTree.
+type ShardStats struct {
+	// Documents is the number of documents in the shard.
+	Documents int
+
+	// ContentBytes is the total size of the content of the documents.
+	ContentBytes int64
+
+	// Ngrams is the number of distinct content ngrams.
+	Ngrams int
+
+	// NgramOccurrences is the number of occurrences of content ngrams, ie.
+	// the total length of their posting lists.
+	NgramOccurrences int64
+
+	// PostingsBytes is the size of the encoded content posting lists.
+	PostingsBytes int64
+
+	// NgramHistogram[i] is the number of distinct content ngrams which occur
+	// between 2^i and 2^(i+1)-1 times.
+	NgramHistogram []int `json:",omitempty"`
+}
+
+// AvgFileSize returns the average size of the content of a document.
+func (s *ShardStats) AvgFileSize() float64 {
+	if s.Documents == 0 {
+		return 0
+	}
+	return float64(s.ContentBytes) / float64(s.Documents)
+}
+
+// addPostingList adds an encoded posting list of a content ngram to s.
+func (s *ShardStats) addPostingList(p []byte) {
+	// Every posting is a varint, so it has exactly one byte without the
+	// continuation bit.
+	n := 0
+	for _, b := range p {
+		if b < 0x80 {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	s.Ngrams++
+	s.NgramOccurrences += int64(n)
+	s.PostingsBytes += int64(len(p))
+
+	i := bits.Len(uint(n)) - 1
+	for len(s.NgramHistogram) <= i {
+		s.NgramHistogram = append(s.NgramHistogram, 0)
+	}
+	s.NgramHistogram[i]++
+}
+
+// estimateOccurrences estimates the number of occurrences of an ngram from
+// the size of its posting list.
+func (s *ShardStats) estimateOccurrences(postingBytes uint32) float64 {
+	if s.PostingsBytes == 0 {
+		// Shards written without stats. A posting is at least one byte.
+		return float64(postingBytes)
+	}
+	return float64(postingBytes) * float64(s.NgramOccurrences) / float64(s.PostingsBytes)
+}
+
+// Stats returns the statistics of the shard. Shards written before they were
+// stored only have Documents and ContentBytes.
+func (d *indexData) Stats() ShardStats {
+	return d.stats
+}
+
+// readStats reads the planStats section of the shard, if there is one.
+func (d *indexData) readStats(sec simpleSection) error {
+	n := len(d.boundaries) - 1
+	if sec.sz == 0 {
+		d.stats = ShardStats{Documents: n}
+		if n > 0 {
+			d.stats.ContentBytes = int64(d.boundaries[n])
+		}
+		return nil
+	}
+
+	blob, err := d.readSectionBlob(sec)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(blob, &d.stats)
+}
+
+// estimateMatches estimates the number of documents mt matches.
+func (d *indexData) estimateMatches(mt matchTree) float64 {
+	all := float64(d.stats.Documents)
+	switch t := mt.(type) {
+	case *substrMatchTree:
+		if r, ok := t.matchIterator.(*ngramIterationResults); ok && r.postingBytes > 0 {
+			return min(all, d.stats.estimateOccurrences(r.postingBytes))
+		}
+	case *symbolSubstrMatchTree:
+		return d.estimateMatches(t.substrMatchTree)
+	case *andMatchTree:
+		est := all
+		for _, ch := range t.children {
+			est = min(est, d.estimateMatches(ch))
+		}
+		return est
+	case *andLineMatchTree:
+		return d.estimateMatches(&t.andMatchTree)
+	case *orMatchTree:
+		var est float64
+		for _, ch := range t.children {
+			est += d.estimateMatches(ch)
+		}
+		return min(all, est)
+	case *fileNameMatchTree:
+		return d.estimateMatches(t.child)
+	case *boostMatchTree:
+		return d.estimateMatches(t.child)
+	case *noVisitMatchTree:
+		return d.estimateMatches(t.matchTree)
+	}
+	return all
+}
+
+// planMatchTree orders the children of the and nodes in mt by the number of
+// documents they are estimated to match, fewest first. An and node stops
+// evaluating its children at the first one which doesn't match a document,
+// so this saves loading and matching content for the other children.
+func (d *indexData) planMatchTree(mt matchTree) {
+	switch t := mt.(type) {
+	case *andMatchTree:
+		for _, ch := range t.children {
+			d.planMatchTree(ch)
+		}
+		d.orderChildren(t.children)
+	case *andLineMatchTree:
+		d.planMatchTree(&t.andMatchTree)
+	case *sameLineMatchTree:
+		d.planMatchTree(&t.andMatchTree)
+	case *orMatchTree:
+		for _, ch := range t.children {
+			d.planMatchTree(ch)
+		}
+	case *notMatchTree:
+		d.planMatchTree(t.child)
+	case *fileNameMatchTree:
+		d.planMatchTree(t.child)
+	case *boostMatchTree:
+		d.planMatchTree(t.child)
+	case *noVisitMatchTree:
+		d.planMatchTree(t.matchTree)
+	}
+}
+
+// orderChildren sorts the children of an and node by their estimated
+// matches. Children with the same estimate keep their order.
+func (d *indexData) orderChildren(children []matchTree) {
+	est := make(map[matchTree]float64, len(children))
+	for _, ch := range children {
+		est[ch] = d.estimateMatches(ch)
+	}
+	slices.SortStableFunc(children, func(a, b matchTree) int {
+		switch {
+		case est[a] < est[b]:
+			return -1
+		case est[a] > est[b]:
+			return 1
+		}
+		return 0
+	})
+}
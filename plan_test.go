@@ -0,0 +1,101 @@
+package zoekt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func planTestShard(t *testing.T) *indexData {
+	t.Helper()
+	var docs []Document
+	for i := 0; i < 50; i++ {
+		content := fmt.Sprintf("common line %d\n", i)
+		if i == 7 {
+			content += "rare needle\n"
+		}
+		docs = append(docs, Document{Name: fmt.Sprintf("f%d", i), Content: []byte(content)})
+	}
+	return searcherForTest(t, testIndexBuilder(t, nil, docs...)).(*indexData)
+}
+
+func TestShardStats(t *testing.T) {
+	d := planTestShard(t)
+	st := d.Stats()
+
+	if st.Documents != 50 {
+		t.Errorf("got %d documents, want 50", st.Documents)
+	}
+	if want := int64(d.boundaries[len(d.boundaries)-1]); st.ContentBytes != want {
+		t.Errorf("got %d content bytes, want %d", st.ContentBytes, want)
+	}
+	if got, want := st.AvgFileSize(), float64(st.ContentBytes)/50; got != want {
+		t.Errorf("got average file size %f, want %f", got, want)
+	}
+
+	sum := 0
+	for _, n := range st.NgramHistogram {
+		sum += n
+	}
+	if sum != st.Ngrams || st.Ngrams == 0 {
+		t.Errorf("histogram holds %d ngrams, want %d", sum, st.Ngrams)
+	}
+	if st.NgramOccurrences < int64(st.Ngrams) || st.PostingsBytes < st.NgramOccurrences {
+		t.Errorf("inconsistent stats %+v", st)
+	}
+}
+
+func TestPlanMatchTree(t *testing.T) {
+	d := planTestShard(t)
+
+	q := &query.And{Children: []query.Q{
+		&query.Substring{Pattern: "common", Content: true},
+		&query.Substring{Pattern: "needle", Content: true},
+	}}
+	mt, err := d.newMatchTree(q, matchTreeOpt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.planMatchTree(mt)
+
+	and, ok := mt.(*andMatchTree)
+	if !ok {
+		t.Fatalf("got %T, want *andMatchTree", mt)
+	}
+	if first := and.children[0].(*substrMatchTree); first.query.Pattern != "needle" {
+		t.Errorf("got %q first, want the rare substring", first.query.Pattern)
+	}
+}
+
+// Shards written before the planStats section plan with the document count
+// and content size, and take the size of a posting list as the number of
+// occurrences.
+func TestShardStatsWithoutSection(t *testing.T) {
+	stats := func(fn string) ShardStats {
+		t.Helper()
+		s, err := loadShard(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s.(*indexData).Stats()
+	}
+
+	// The same repository, written with the section.
+	want := stats("testdata/shards/repo_checksums_v16.00000.zoekt")
+	if want.Ngrams == 0 {
+		t.Fatal("repo_checksums_v16.00000.zoekt has no planStats")
+	}
+
+	got := stats("testdata/shards/repo_v16.00000.zoekt")
+	if got.Documents != want.Documents || got.ContentBytes != want.ContentBytes {
+		t.Errorf("got %d documents with %d bytes, want %d with %d", got.Documents, got.ContentBytes, want.Documents, want.ContentBytes)
+	}
+	if got.Ngrams != 0 || got.NgramHistogram != nil {
+		t.Errorf("got ngram stats %+v for a shard without them", got)
+	}
+	if est := got.estimateOccurrences(12); est != 12 {
+		t.Errorf("got %f estimated occurrences, want 12", est)
+	}
+}
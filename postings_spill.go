@@ -0,0 +1,193 @@
+package zoekt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+)
+
+// postingsSpill holds the posting lists which a postingsBuilder spilled to a
+// temporary file to bound its memory, see IndexBuilder.PostingsSpillBytes.
+//
+// Each spill appends a run of posting lists sorted by ngram to the file. The
+// delta encoding of a posting list continues across spills, so the posting
+// list of an ngram is the concatenation of its parts in the runs, in order,
+// followed by the part still in memory.
+type postingsSpill struct {
+	f       *os.File
+	removed bool
+	size    int64
+	runs    []spillRun
+}
+
+// spillRun is the section of the spill file holding one run.
+type spillRun struct {
+	off, size int64
+}
+
+// spill writes the posting lists in memory to a new run and clears them.
+func (s *postingsBuilder) spill() error {
+	if s.spilled == nil {
+		f, err := os.CreateTemp("", "zoekt-postings-*")
+		if err != nil {
+			return err
+		}
+		// We only access the file through f, so we can remove it right away
+		// on Unix. Otherwise we remove it in closeSpill.
+		s.spilled = &postingsSpill{f: f, removed: os.Remove(f.Name()) == nil}
+	}
+	sp := s.spilled
+
+	w := bufio.NewWriterSize(io.NewOffsetWriter(sp.f, sp.size), 1<<20)
+	var n int64
+	var hdr [8 + binary.MaxVarintLen64]byte
+	for _, k := range sortedNgrams(s.postings) {
+		p := s.postings[k]
+		binary.BigEndian.PutUint64(hdr[:], uint64(k))
+		m := 8 + binary.PutUvarint(hdr[8:], uint64(len(p)))
+		w.Write(hdr[:m])
+		w.Write(p)
+		n += int64(m + len(p))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	sp.runs = append(sp.runs, spillRun{off: sp.size, size: n})
+	sp.size += n
+	s.postings = map[ngram][]byte{}
+	s.postingsBytes = 0
+	return nil
+}
+
+// closeSpill closes and removes the spill file, if any.
+func (s *postingsBuilder) closeSpill() {
+	if s.spilled == nil {
+		return
+	}
+	s.spilled.f.Close()
+	if !s.spilled.removed {
+		os.Remove(s.spilled.f.Name())
+	}
+	s.spilled = nil
+}
+
+// ngrams returns the ngrams with postings, in memory or spilled, sorted.
+func (s *postingsBuilder) ngrams() (ngramSlice, error) {
+	keys := sortedNgrams(s.postings)
+	if s.spilled == nil {
+		return keys, nil
+	}
+
+	for _, run := range s.spilled.runs {
+		r := s.spilled.reader(run)
+		for {
+			ok, err := r.next(false)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			keys = append(keys, r.ng)
+		}
+	}
+	sort.Sort(keys)
+
+	// Remove duplicates.
+	uniq := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			uniq = append(uniq, k)
+		}
+	}
+	return uniq, nil
+}
+
+// mergePostings calls f with the posting list of each ngram in keys, which
+// must be the result of s.ngrams.
+func (s *postingsBuilder) mergePostings(keys ngramSlice, f func([]byte)) error {
+	if s.spilled == nil {
+		for _, k := range keys {
+			f(s.postings[k])
+		}
+		return nil
+	}
+
+	readers := make([]*spillReader, len(s.spilled.runs))
+	for i, run := range s.spilled.runs {
+		readers[i] = s.spilled.reader(run)
+		if _, err := readers[i].next(true); err != nil {
+			return err
+		}
+	}
+
+	var buf []byte
+	for _, k := range keys {
+		buf = buf[:0]
+		for _, r := range readers {
+			if r.done || r.ng != k {
+				continue
+			}
+			buf = append(buf, r.data...)
+			if _, err := r.next(true); err != nil {
+				return err
+			}
+		}
+		f(append(buf, s.postings[k]...))
+	}
+	return nil
+}
+
+// spillReader reads the posting lists of a run.
+type spillReader struct {
+	r    *bufio.Reader
+	done bool
+	ng   ngram
+	data []byte
+}
+
+func (sp *postingsSpill) reader(run spillRun) *spillReader {
+	return &spillReader{r: bufio.NewReaderSize(io.NewSectionReader(sp.f, run.off, run.size), 1<<16)}
+}
+
+// next advances to the next posting list of the run, and reads its data if
+// withData is set. It returns false at the end of the run.
+func (r *spillReader) next(withData bool) (bool, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err == io.EOF {
+		r.done = true
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r.ng = ngram(binary.BigEndian.Uint64(hdr[:]))
+
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return false, err
+	}
+	if !withData {
+		_, err := r.r.Discard(int(n))
+		return err == nil, err
+	}
+	if uint64(cap(r.data)) < n {
+		r.data = make([]byte, n)
+	}
+	r.data = r.data[:n]
+	if _, err := io.ReadFull(r.r, r.data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func sortedNgrams(postings map[ngram][]byte) ngramSlice {
+	keys := make(ngramSlice, 0, len(postings))
+	for k := range postings {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+	return keys
+}
@@ -0,0 +1,197 @@
+// Package quality evaluates search ranking against labeled judgments.
+//
+// A judgment is a query with the documents that are relevant for it, each with
+// a grade. We run each query against a searcher and score the ranked results
+// with NDCG (normalized discounted cumulative gain) and MRR (mean reciprocal
+// rank), so ranking changes can be compared before they are rolled out.
+package quality
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Doc identifies a document in the index.
+type Doc struct {
+	Repo     string `json:"repo"`
+	FileName string `json:"file"`
+}
+
+// Relevant is a relevant document for a query.
+type Relevant struct {
+	Doc
+
+	// Grade is the relevance of the document, higher is better. Zero means 1,
+	// so judgments without grades are binary.
+	Grade int `json:"grade,omitempty"`
+}
+
+// Judgment is a query with the documents that are relevant for it.
+type Judgment struct {
+	Query    string     `json:"query"`
+	Relevant []Relevant `json:"relevant"`
+}
+
+// ReadJudgments reads a JSON array of judgments, eg.
+//
+//	[{"query": "func main", "relevant": [{"repo": "github.com/a/b", "file": "main.go", "grade": 2}]}]
+func ReadJudgments(r io.Reader) ([]Judgment, error) {
+	var judgments []Judgment
+	if err := json.NewDecoder(r).Decode(&judgments); err != nil {
+		return nil, fmt.Errorf("reading judgments: %w", err)
+	}
+	for _, j := range judgments {
+		if len(j.Relevant) == 0 {
+			return nil, fmt.Errorf("judgment for query %q has no relevant documents", j.Query)
+		}
+	}
+	return judgments, nil
+}
+
+// Options control the evaluation.
+type Options struct {
+	// K is the number of results that are scored. It defaults to 10.
+	K int
+
+	// SearchOptions are used for every query. MaxDocDisplayCount is set to
+	// K if it is not set.
+	SearchOptions zoekt.SearchOptions
+}
+
+// QueryResult is the evaluation of a single judgment.
+type QueryResult struct {
+	Query string
+
+	// NDCG is the normalized discounted cumulative gain of the top K
+	// results, between 0 and 1.
+	NDCG float64
+
+	// ReciprocalRank is 1/rank of the first relevant result within the top
+	// K, or 0 if there is none.
+	ReciprocalRank float64
+
+	// Ranks are the 1-based ranks of the relevant documents, in the order of
+	// the judgment. It is 0 for relevant documents not in the top K.
+	Ranks []int
+}
+
+// Report is the evaluation of a set of judgments.
+type Report struct {
+	K       int
+	Queries []QueryResult
+
+	// NDCG and MRR are the means over all queries.
+	NDCG float64
+	MRR  float64
+}
+
+// Evaluate runs the queries of judgments against s and scores the results.
+func Evaluate(ctx context.Context, s zoekt.Searcher, judgments []Judgment, opts Options) (*Report, error) {
+	if opts.K <= 0 {
+		opts.K = 10
+	}
+	sOpts := opts.SearchOptions
+	if sOpts.MaxDocDisplayCount == 0 {
+		sOpts.MaxDocDisplayCount = opts.K
+	}
+
+	report := &Report{K: opts.K}
+	for _, j := range judgments {
+		q, err := query.Parse(j.Query)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", j.Query, err)
+		}
+		res, err := s.Search(ctx, q, &sOpts)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", j.Query, err)
+		}
+
+		var ranked []Doc
+		for _, f := range res.Files {
+			ranked = append(ranked, Doc{Repo: f.Repository, FileName: f.FileName})
+		}
+		report.Queries = append(report.Queries, score(j, ranked, opts.K))
+	}
+
+	for _, r := range report.Queries {
+		report.NDCG += r.NDCG
+		report.MRR += r.ReciprocalRank
+	}
+	if n := len(report.Queries); n > 0 {
+		report.NDCG /= float64(n)
+		report.MRR /= float64(n)
+	}
+	return report, nil
+}
+
+// score scores the ranked documents for j.
+func score(j Judgment, ranked []Doc, k int) QueryResult {
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+
+	grades := map[Doc]int{}
+	for _, r := range j.Relevant {
+		grades[r.Doc] = gain(r.Grade)
+	}
+
+	result := QueryResult{Query: j.Query}
+
+	rank := map[Doc]int{}
+	var dcg float64
+	for i, d := range ranked {
+		g, ok := grades[d]
+		if !ok {
+			continue
+		}
+		if _, seen := rank[d]; seen {
+			continue
+		}
+		rank[d] = i + 1
+		dcg += discounted(g, i)
+		if result.ReciprocalRank == 0 {
+			result.ReciprocalRank = 1 / float64(i+1)
+		}
+	}
+	for _, r := range j.Relevant {
+		result.Ranks = append(result.Ranks, rank[r.Doc])
+	}
+
+	// The ideal ranking has the relevant documents by decreasing grade.
+	ideal := make([]int, 0, len(grades))
+	for _, g := range grades {
+		ideal = append(ideal, g)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ideal)))
+	var idcg float64
+	for i, g := range ideal {
+		if i == k {
+			break
+		}
+		idcg += discounted(g, i)
+	}
+	if idcg > 0 {
+		result.NDCG = dcg / idcg
+	}
+	return result
+}
+
+func gain(grade int) int {
+	if grade == 0 {
+		return 1
+	}
+	return grade
+}
+
+// discounted returns the gain of a document with grade g at 0-based position
+// i, using the common exponential gain.
+func discounted(g, i int) float64 {
+	return (math.Pow(2, float64(g)) - 1) / math.Log2(float64(i+2))
+}
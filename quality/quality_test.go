@@ -0,0 +1,97 @@
+package quality
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/mockSearcher"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestScore(t *testing.T) {
+	a := Doc{Repo: "r", FileName: "a"}
+	b := Doc{Repo: "r", FileName: "b"}
+	c := Doc{Repo: "r", FileName: "c"}
+	x := Doc{Repo: "r", FileName: "x"}
+
+	j := Judgment{Query: "q", Relevant: []Relevant{{Doc: a, Grade: 2}, {Doc: b}}}
+
+	cases := []struct {
+		name   string
+		ranked []Doc
+		k      int
+		want   QueryResult
+	}{{
+		name:   "ideal",
+		ranked: []Doc{a, b, c},
+		k:      10,
+		want:   QueryResult{Query: "q", NDCG: 1, ReciprocalRank: 1, Ranks: []int{1, 2}},
+	}, {
+		name:   "swapped",
+		ranked: []Doc{b, a},
+		k:      10,
+		// dcg = 1/log2(2) + 3/log2(3), idcg = 3/log2(2) + 1/log2(3)
+		want: QueryResult{Query: "q", NDCG: (1 + 3/math.Log2(3)) / (3 + 1/math.Log2(3)), ReciprocalRank: 1, Ranks: []int{2, 1}},
+	}, {
+		name:   "second",
+		ranked: []Doc{x, a},
+		k:      10,
+		want:   QueryResult{Query: "q", NDCG: (3 / math.Log2(3)) / (3 + 1/math.Log2(3)), ReciprocalRank: 0.5, Ranks: []int{2, 0}},
+	}, {
+		name:   "outside k",
+		ranked: []Doc{x, c, a},
+		k:      2,
+		want:   QueryResult{Query: "q", Ranks: []int{0, 0}},
+	}, {
+		name: "no results",
+		k:    10,
+		want: QueryResult{Query: "q", Ranks: []int{0, 0}},
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := score(j, tc.ranked, tc.k)
+			if d := cmp.Diff(tc.want, got, cmpopts.EquateApprox(0, 1e-9)); d != "" {
+				t.Errorf("mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	judgments, err := ReadJudgments(strings.NewReader(`[
+		{"query": "needle", "relevant": [{"repo": "r", "file": "b.go"}]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := query.Parse("needle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mockSearcher.MockSearcher{
+		WantSearch: want,
+		SearchResult: &zoekt.SearchResult{Files: []zoekt.FileMatch{
+			{Repository: "r", FileName: "a.go"},
+			{Repository: "r", FileName: "b.go"},
+		}},
+	}
+
+	report, err := Evaluate(context.Background(), s, judgments, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.K != 10 || report.MRR != 0.5 || math.Abs(report.NDCG-1/math.Log2(3)) > 1e-9 {
+		t.Errorf("got report %+v", report)
+	}
+
+	if _, err := ReadJudgments(strings.NewReader(`[{"query": "needle"}]`)); err == nil {
+		t.Error("expected error for judgment without relevant documents")
+	}
+}
@@ -0,0 +1,191 @@
+package query
+
+import (
+	"encoding/json"
+	"regexp/syntax"
+	"sort"
+	"unicode"
+
+	"github.com/sourcegraph/zoekt/internal/syntaxutil"
+)
+
+// Canonicalize returns a canonical form of q, so that queries which only
+// differ in how they are written compare equal. Beyond Simplify, it
+//
+//   - sorts the children of And and Or, and drops duplicate children,
+//   - removes double negations,
+//   - sorts and dedupes the kinds of SymbolKind,
+//   - clears CaseSensitive on patterns without cased letters, and
+//   - reparses and simplifies regular expressions, so that eg. "a(b|c)d"
+//     and "a[cb]d" are the same.
+//
+// The result matches the same documents as q. q is not modified.
+func Canonicalize(q Q) Q {
+	return canonicalize(Simplify(q))
+}
+
+func canonicalize(q Q) Q {
+	switch s := q.(type) {
+	case *And:
+		return canonicalAndOr(s.Children, true)
+	case *Or:
+		return canonicalAndOr(s.Children, false)
+	case *SameLine:
+		children := make([]Q, len(s.Children))
+		for i, ch := range s.Children {
+			children[i] = canonicalize(ch)
+		}
+		return &SameLine{Children: sortChildren(children)}
+	case *Not:
+		ch := canonicalize(s.Child)
+		if not, ok := ch.(*Not); ok {
+			return not.Child
+		}
+		return &Not{Child: ch}
+	case *Type:
+		return &Type{Type: s.Type, Child: canonicalize(s.Child)}
+	case *Boost:
+		return &Boost{Boost: s.Boost, Child: canonicalize(s.Child)}
+	case *Symbol:
+		return &Symbol{Expr: canonicalize(s.Expr)}
+	case *SymbolKind:
+		kinds := append([]string(nil), s.Kinds...)
+		sort.Strings(kinds)
+		kinds = dedupeSorted(kinds, func(a, b string) bool { return a == b })
+		return &SymbolKind{Kinds: kinds, Exclude: s.Exclude, Expr: canonicalize(s.Expr)}
+	case *Substring:
+		c := *s
+		if c.CaseSensitive && !hasCasedRune(c.Pattern) {
+			c.CaseSensitive = false
+		}
+		return &c
+	case *Regexp:
+		c := *s
+		c.Regexp = canonicalRegexp(s.Regexp)
+		if c.CaseSensitive && !hasCasedRegexp(c.Regexp) {
+			c.CaseSensitive = false
+		}
+		return &c
+	}
+	return q
+}
+
+func canonicalAndOr(children []Q, isAnd bool) Q {
+	var flat []Q
+	for _, ch := range children {
+		ch = canonicalize(ch)
+
+		// Dropping duplicates can reduce a child to one of its own children,
+		// which may be of our type again.
+		var sub []Q
+		switch s := ch.(type) {
+		case *And:
+			if isAnd {
+				sub = s.Children
+			}
+		case *Or:
+			if !isAnd {
+				sub = s.Children
+			}
+		}
+		if sub == nil {
+			sub = []Q{ch}
+		}
+		flat = append(flat, sub...)
+	}
+
+	qs := sortChildren(flat)
+	if len(qs) == 1 {
+		return qs[0]
+	}
+	if isAnd {
+		return &And{Children: qs}
+	}
+	return &Or{Children: qs}
+}
+
+// sortChildren sorts the children of a commutative node, and drops
+// duplicates.
+func sortChildren(children []Q) []Q {
+	type keyed struct {
+		q   Q
+		key string
+	}
+	ks := make([]keyed, len(children))
+	for i, q := range children {
+		ks[i] = keyed{q: q, key: canonicalKey(q)}
+	}
+	sort.SliceStable(ks, func(i, j int) bool { return ks[i].key < ks[j].key })
+	ks = dedupeSorted(ks, func(a, b keyed) bool { return a.key == b.key })
+
+	qs := make([]Q, len(ks))
+	for i, k := range ks {
+		qs[i] = k.q
+	}
+	return qs
+}
+
+// canonicalKey returns a string which identifies q. Unlike String, which
+// abbreviates large sets, it is exact.
+func canonicalKey(q Q) string {
+	if b, err := json.Marshal(q); err == nil {
+		return string(b)
+	}
+	return q.String()
+}
+
+func dedupeSorted[T any](s []T, equal func(a, b T) bool) []T {
+	if len(s) == 0 {
+		return s
+	}
+	out := s[:1]
+	for _, v := range s[1:] {
+		if !equal(out[len(out)-1], v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// canonicalRegexp reparses r and simplifies the result. The parser merges
+// alternations of literals into character classes and factors out common
+// prefixes, so this normalizes different spellings of the same expression.
+func canonicalRegexp(r *syntax.Regexp) *syntax.Regexp {
+	parsed, err := syntax.Parse(syntaxutil.RegexpString(r), regexpFlags)
+	if err != nil {
+		return r
+	}
+	return OptimizeRegexp(parsed, regexpFlags)
+}
+
+func hasCasedRune(s string) bool {
+	for _, c := range s {
+		if unicode.SimpleFold(c) != c {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCasedRegexp returns true if r may match a letter which has another
+// case. It is conservative for character classes.
+func hasCasedRegexp(r *syntax.Regexp) bool {
+	switch r.Op {
+	case syntax.OpLiteral:
+		return hasCasedRune(string(r.Rune))
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(r.Rune); i += 2 {
+			lo, hi := r.Rune[i], r.Rune[i+1]
+			if hi > unicode.MaxASCII || (lo <= 'z' && hi >= 'a') || (lo <= 'Z' && hi >= 'A') {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range r.Sub {
+		if hasCasedRegexp(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package query
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		// Commutative children are sorted and deduped.
+		{in: "foo bar", want: `(and substr:"bar" substr:"foo")`},
+		{in: "bar foo foo", want: `(and substr:"bar" substr:"foo")`},
+		{in: "foo or bar or foo", want: `(or substr:"bar" substr:"foo")`},
+		{in: "foo foo", want: `substr:"foo"`},
+
+		// Deduping may leave an And inside an And.
+		{in: "(foo bar or bar foo) baz", want: `(and substr:"bar" substr:"baz" substr:"foo")`},
+
+		// Case is irrelevant without letters.
+		{in: "case:yes 123", want: `substr:"123"`},
+		{in: "case:yes Foo", want: `case_substr:"Foo"`},
+		{in: "case:yes 1[0-9]+", want: `regex:"1[0-9]+"`},
+		{in: "case:yes 1[a-z]+", want: `case_regex:"1[a-z]+"`},
+
+		// Regular expressions are normalized.
+		{in: "x(abc)y", want: `substr:"xabcy"`},
+		{in: "a(b|c)d", want: `regex:"a[b-c]d"`},
+		{in: "a[cb]d", want: `regex:"a[b-c]d"`},
+
+		// Symbol kinds are sorted.
+		{in: "sym.kind:method,function,method sym:foo", want: `(sym.kind:function,method sym:substr:"foo")`},
+	}
+
+	for _, c := range cases {
+		q, err := Parse(c.in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.in, err)
+		}
+		got := Canonicalize(q)
+		if got.String() != c.want {
+			t.Errorf("%s: got %s, want %s", c.in, got, c.want)
+		}
+		if again := Canonicalize(got); again.String() != got.String() {
+			t.Errorf("%s: not idempotent, got %s then %s", c.in, got, again)
+		}
+	}
+}
+
+func TestCanonicalizeNot(t *testing.T) {
+	q := NewAnd(&Not{&Not{&Substring{Pattern: "foo"}}}, &Substring{Pattern: "bar"})
+	want := `(and substr:"bar" substr:"foo")`
+	if got := Canonicalize(q); got.String() != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCanonicalizeOrder(t *testing.T) {
+	a := NewAnd(NewRepoSet("a", "b", "c", "d", "e", "f"), &Substring{Pattern: "x"})
+	b := NewAnd(&Substring{Pattern: "x"}, NewRepoSet("f", "e", "d", "c", "b", "a"))
+	if canonicalKey(Canonicalize(a)) != canonicalKey(Canonicalize(b)) {
+		t.Errorf("expected %s and %s to be canonically equal", a, b)
+	}
+
+	// Sets which only differ in their abbreviated String are not deduped.
+	c := NewOr(NewRepoSet("a", "b", "c", "d", "e", "f"), NewRepoSet("a", "b", "c", "d", "e", "g"))
+	if got := Canonicalize(c); len(got.(*Or).Children) != 2 {
+		t.Errorf("got %s, want both sets", got)
+	}
+}
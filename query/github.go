@@ -0,0 +1,412 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/regexp"
+	"github.com/sourcegraph/zoekt/internal/languages"
+)
+
+// Query syntaxes understood by ParseSyntax.
+const (
+	SyntaxZoekt  = "zoekt"
+	SyntaxGitHub = "github"
+)
+
+// ParseSyntax parses qStr in the given syntax. The empty syntax is the zoekt
+// syntax understood by Parse.
+func ParseSyntax(syntax, qStr string) (Q, error) {
+	switch syntax {
+	case "", SyntaxZoekt:
+		return Parse(qStr)
+	case SyntaxGitHub:
+		return ParseGitHub(qStr)
+	default:
+		return nil, fmt.Errorf("query: unknown syntax %q, want {%s,%s}", syntax, SyntaxZoekt, SyntaxGitHub)
+	}
+}
+
+// ParseGitHub translates a query in GitHub code search syntax into a query,
+// so saved GitHub searches keep working. It supports
+//
+//   - bare terms, "quoted strings" and /regular expressions/, which match
+//     file names and contents case-insensitively,
+//   - AND, OR, NOT and parentheses; terms next to each other are ANDed,
+//   - the qualifiers repo:, org:, user:, path:, language:, symbol:, content:
+//     and is:{archived,fork,generated}, optionally negated with a leading -.
+//
+// repo: values are matched exactly against the end of the repository name,
+// so repo:org/name finds "github.com/org/name". path: values are substrings of
+// the path, unless they are globs, like path:*.go or path:/src/**/*.js, where
+// a leading / anchors the glob at the root of the repository. Words with an
+// unknown qualifier are searched for as text, like GitHub does.
+func ParseGitHub(qStr string) (Q, error) {
+	toks, err := ghTokenize(qStr)
+	if err != nil {
+		return nil, err
+	}
+	p := &ghParser{toks: toks}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("query: unbalanced ')' in GitHub query")
+	}
+	if q == nil {
+		return nil, fmt.Errorf("query: empty GitHub query")
+	}
+	return Simplify(q), nil
+}
+
+type ghTokenKind int
+
+const (
+	ghWord ghTokenKind = iota
+	ghQuoted
+	ghRegex
+	ghParenOpen
+	ghParenClose
+)
+
+type ghToken struct {
+	kind ghTokenKind
+
+	// negated is set for terms with a leading -, as in -path:vendor.
+	negated bool
+
+	// qualifier is the part before the colon of qualified terms, eg. "path"
+	// in path:*.go.
+	qualifier string
+
+	// value is the term without qualifier, quotes or slashes.
+	value string
+}
+
+// ghTokenize splits a GitHub query into terms, parentheses and operators.
+// The operators AND, OR and NOT are returned as words.
+func ghTokenize(in string) ([]ghToken, error) {
+	var toks []ghToken
+	for len(in) > 0 {
+		switch c := in[0]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			in = in[1:]
+			continue
+		case c == '(':
+			toks = append(toks, ghToken{kind: ghParenOpen})
+			in = in[1:]
+			continue
+		case c == ')':
+			toks = append(toks, ghToken{kind: ghParenClose})
+			in = in[1:]
+			continue
+		}
+
+		var t ghToken
+		if in[0] == '-' && len(in) > 1 && in[1] != ' ' {
+			t.negated = true
+			in = in[1:]
+		}
+		if name, _, ok := strings.Cut(in, ":"); ok && isGitHubQualifierName(name) {
+			t.qualifier = strings.ToLower(name)
+			in = in[len(name)+1:]
+		}
+
+		var err error
+		switch {
+		case len(in) > 0 && in[0] == '"':
+			t.kind = ghQuoted
+			t.value, in, err = ghCut(in[1:], '"')
+			if err != nil {
+				return nil, err
+			}
+		case isGitHubRegex(in):
+			t.kind = ghRegex
+			t.value, in, _ = ghCut(in[1:], '/')
+		default:
+			end := strings.IndexAny(in, " \t\n()")
+			if end < 0 {
+				end = len(in)
+			}
+			t.value, in = in[:end], in[end:]
+		}
+		if t.qualifier != "" && t.value == "" {
+			return nil, fmt.Errorf("query: the %s: qualifier must have an argument", t.qualifier)
+		}
+		toks = append(toks, t)
+	}
+	return toks, nil
+}
+
+// isGitHubRegex returns true if in starts with a term delimited by slashes.
+// Other terms starting with a slash, like the glob /src/*.js, are words.
+func isGitHubRegex(in string) bool {
+	if len(in) == 0 || in[0] != '/' {
+		return false
+	}
+	_, rest, err := ghCut(in[1:], '/')
+	return err == nil && (rest == "" || strings.IndexByte(" \t\n)", rest[0]) >= 0)
+}
+
+// isGitHubQualifierName returns true if name looks like a qualifier. Unknown
+// qualifiers are passed on as text later.
+func isGitHubQualifierName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ghCut returns the text up to the unescaped delimiter and the input after
+// it. Escaped delimiters are unescaped for quotes. For regular expressions
+// only \/ is unescaped, other escapes belong to the expression.
+func ghCut(in string, delim byte) (string, string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; {
+		case c == delim:
+			return sb.String(), in[i+1:], nil
+		case c == '\\' && i+1 < len(in):
+			i++
+			if in[i] != delim && delim == '/' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(in[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("query: unterminated %c in GitHub query", delim)
+}
+
+// ghParser is a recursive descent parser over tokens. NOT binds tighter than
+// AND, which binds tighter than OR.
+type ghParser struct {
+	toks []ghToken
+	pos  int
+}
+
+func (p *ghParser) peekOperator(op string) bool {
+	if p.pos >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.pos]
+	return t.kind == ghWord && !t.negated && t.qualifier == "" && t.value == op
+}
+
+// peekOperand returns an error for operator if the next token can't start
+// its operand, like another operator, a ')' or the end of the query.
+func (p *ghParser) peekOperand(operator string) error {
+	if p.pos == len(p.toks) || p.toks[p.pos].kind == ghParenClose ||
+		p.peekOperator("AND") || p.peekOperator("OR") {
+		return fmt.Errorf("query: %s operator should have operand", operator)
+	}
+	return nil
+}
+
+func (p *ghParser) parseOr() (Q, error) {
+	var or []Q
+	for {
+		if p.peekOperator("OR") {
+			return nil, fmt.Errorf("query: OR operator should have operand")
+		}
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if q == nil {
+			if len(or) > 0 {
+				return nil, fmt.Errorf("query: OR operator should have operand")
+			}
+			return nil, nil
+		}
+		or = append(or, q)
+		if !p.peekOperator("OR") {
+			return NewOr(or...), nil
+		}
+		p.pos++
+	}
+}
+
+func (p *ghParser) parseAnd() (Q, error) {
+	var and []Q
+	for p.pos < len(p.toks) && p.toks[p.pos].kind != ghParenClose && !p.peekOperator("OR") {
+		if p.peekOperator("AND") {
+			if len(and) == 0 {
+				return nil, fmt.Errorf("query: AND operator should have operand")
+			}
+			p.pos++
+			if err := p.peekOperand("AND"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, q)
+	}
+	if len(and) == 0 {
+		return nil, nil
+	}
+	return NewAnd(and...), nil
+}
+
+func (p *ghParser) parseUnary() (Q, error) {
+	if p.peekOperator("NOT") {
+		p.pos++
+		if err := p.peekOperand("NOT"); err != nil {
+			return nil, err
+		}
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Child: q}, nil
+	}
+
+	t := p.toks[p.pos]
+	p.pos++
+	if t.kind == ghParenOpen {
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos == len(p.toks) || p.toks[p.pos].kind != ghParenClose {
+			return nil, fmt.Errorf("query: unbalanced '(' in GitHub query")
+		}
+		p.pos++
+		if q == nil {
+			return nil, fmt.Errorf("query: empty parentheses in GitHub query")
+		}
+		return q, nil
+	}
+
+	q, err := ghAtom(t)
+	if err != nil {
+		return nil, err
+	}
+	if t.negated {
+		q = &Not{Child: q}
+	}
+	return q, nil
+}
+
+// ghAtom translates a single term.
+func ghAtom(t ghToken) (Q, error) {
+	switch t.qualifier {
+	case "":
+		return ghPattern(t, false, false)
+	case "content":
+		return ghPattern(t, true, false)
+	case "symbol":
+		q, err := ghPattern(t, false, false)
+		if err != nil {
+			return nil, err
+		}
+		return &Symbol{Expr: q}, nil
+	case "path":
+		if t.kind == ghWord && strings.ContainsAny(t.value, "*?") {
+			return ghGlob(t.value)
+		}
+		return ghPattern(t, false, true)
+	case "repo":
+		if t.kind == ghRegex {
+			return ghRepo(t.value)
+		}
+		return ghRepo("(?i)(?:^|/)" + regexp.QuoteMeta(t.value) + "$")
+	case "org", "user":
+		return ghRepo("(?i)(?:^|/)" + regexp.QuoteMeta(t.value) + "/")
+	case "language":
+		if canonical, ok := languages.GetLanguageByAlias(strings.ToLower(t.value)); ok {
+			return &Language{Language: canonical}, nil
+		}
+		return &Const{Value: false}, nil
+	case "is":
+		switch t.value {
+		case "archived":
+			return RawConfig(RcOnlyArchived), nil
+		case "fork":
+			return RawConfig(RcOnlyForks), nil
+		case "generated":
+			return &Generated{}, nil
+		default:
+			return nil, fmt.Errorf("query: unsupported GitHub qualifier is:%s, want {archived,fork,generated}", t.value)
+		}
+	case "enterprise", "saved":
+		return nil, fmt.Errorf("query: unsupported GitHub qualifier %s:", t.qualifier)
+	default:
+		if t.kind != ghWord {
+			return nil, fmt.Errorf("query: unknown GitHub qualifier %s:", t.qualifier)
+		}
+		// Not a qualifier, eg. the "http" in http://example.com.
+		t.value = t.qualifier + ":" + t.value
+		t.qualifier = ""
+		return ghPattern(t, false, false)
+	}
+}
+
+// ghPattern returns a case-insensitive substring or regexp query for the
+// value of t. As on GitHub, (?-i) makes parts of regular expressions case
+// sensitive.
+func ghPattern(t ghToken, content, fileName bool) (Q, error) {
+	if t.kind != ghRegex {
+		return &Substring{Pattern: t.value, Content: content, FileName: fileName}, nil
+	}
+	q, err := RegexpQuery("(?i)"+t.value, content, fileName)
+	if err != nil {
+		return nil, err
+	}
+	// The case folding is in the expression.
+	q.(setCaser).setCase("yes")
+	return q, nil
+}
+
+// ghGlob translates a path glob. * and ? don't match slashes, ** matches
+// anything.
+func ghGlob(glob string) (Q, error) {
+	var sb strings.Builder
+	sb.WriteString("(?i)")
+	if strings.HasPrefix(glob, "/") {
+		sb.WriteString("^")
+		glob = glob[1:]
+	} else {
+		sb.WriteString("(?:^|/)")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				sb.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return RegexpQuery(sb.String(), false, true)
+}
+
+// ghRepo compiles expr into a Repo query.
+func ghRepo(expr string) (Q, error) {
+	r, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Repo{Regexp: r}, nil
+}
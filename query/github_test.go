@@ -0,0 +1,115 @@
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGitHub(t *testing.T) {
+	for _, c := range []struct {
+		in string
+		// want is the equivalent query in zoekt syntax.
+		want string
+	}{
+		{"foo", "case:no foo"},
+		{`"hello world"`, `case:no "hello world"`},
+		{"/foo.*bar/", "case:yes regex:(?i)foo.*bar"},
+		{`/a\/b/`, "case:yes regex:(?i)a/b"},
+		{"foo bar", "case:no foo bar"},
+		{"foo AND bar", "case:no foo bar"},
+		{"foo OR bar", "case:no foo or bar"},
+		{"(foo OR bar) baz", "case:no (foo or bar) baz"},
+		{"NOT foo", "case:no -foo"},
+		{"repo:org/name", `repo:(?i)(?:^|/)org/name$`},
+		{"org:sourcegraph foo", `repo:(?i)(?:^|/)sourcegraph/ case:no foo`},
+		{"path:vendor", "case:no file:vendor"},
+		{"-path:vendor", "case:no -file:vendor"},
+		{"path:*.go", `file:(?i)(?:^|/)[^/]*\.go$`},
+		{"path:/src/**/*.js", `file:(?i)^src/(?:.*/)?[^/]*\.js$`},
+		{"language:go", "lang:go"},
+		{"Language:Go", "lang:go"},
+		{"symbol:Foo", "case:no sym:Foo"},
+		{"content:foo", "case:no content:foo"},
+		{"is:archived", "archived:yes"},
+		{"NOT is:fork", "-fork:yes"},
+		{"is:generated", "generated:yes"},
+	} {
+		got, err := ParseGitHub(c.in)
+		if err != nil {
+			t.Errorf("ParseGitHub(%q): %v", c.in, err)
+			continue
+		}
+		want, err := Parse(c.want)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.want, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("ParseGitHub(%q): got %s, want %s", c.in, got, want)
+		}
+	}
+}
+
+func TestParseGitHubText(t *testing.T) {
+	// Unknown qualifiers are text.
+	got, err := ParseGitHub("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `substr:"http://example.com"`; got.String() != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseGitHubErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"foo OR",
+		"AND foo",
+		"NOT",
+		"(foo",
+		"foo)",
+		"()",
+		"repo:",
+		`"foo`,
+		"is:vendored",
+		"enterprise:acme",
+	} {
+		if q, err := ParseGitHub(in); err == nil {
+			t.Errorf("ParseGitHub(%q): got %s, want error", in, q)
+		}
+	}
+}
+
+func TestParseGitHubOperatorErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		wantErr string
+	}{
+		{"OR foo", "OR operator should have operand"},
+		{"(OR foo)", "OR operator should have operand"},
+		{"foo OR OR bar", "OR operator should have operand"},
+		{"foo AND OR bar", "AND operator should have operand"},
+		{"foo AND AND bar", "AND operator should have operand"},
+		{"foo AND", "AND operator should have operand"},
+		{"(foo AND) bar", "AND operator should have operand"},
+		{"NOT OR foo", "NOT operator should have operand"},
+		{"NOT)", "NOT operator should have operand"},
+	} {
+		_, err := ParseGitHub(tc.in)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("ParseGitHub(%q): got error %v, want %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParseSyntax(t *testing.T) {
+	if _, err := ParseSyntax(SyntaxGitHub, "language:go"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseSyntax("", "lang:go"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseSyntax("sql", "SELECT"); err == nil {
+		t.Error("expected error for unknown syntax")
+	}
+}
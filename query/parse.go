@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"strings"
 
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/internal/languages"
@@ -118,6 +119,12 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 		expr = &caseQ{text}
 	case tokRepo:
+		if pattern, ok := predicateArg(text, "has.file"); ok {
+			if expr, err = newRepoHasFile(pattern); err != nil {
+				return nil, 0, err
+			}
+			break
+		}
 		r, err := regexp.Compile(text)
 		if err != nil {
 			return nil, 0, err
@@ -142,6 +149,22 @@ func parseExpr(in []byte) (Q, int, error) {
 		default:
 			return nil, 0, fmt.Errorf("query: unknown fork argument %q, want {yes,no}", text)
 		}
+	case tokGenerated:
+		switch text {
+		case "yes":
+			expr = &Generated{}
+		case "no":
+			expr = &Not{Child: &Generated{}}
+		default:
+			return nil, 0, fmt.Errorf("query: unknown generated argument %q, want {yes,no}", text)
+		}
+	case tokHas:
+		switch text {
+		case "secret":
+			expr = &HasSecret{}
+		default:
+			return nil, 0, fmt.Errorf("query: unknown has argument %q, want {secret}", text)
+		}
 	case tokPublic:
 		switch text {
 		case "yes":
@@ -160,17 +183,51 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 		expr = q
 	case tokFile:
+		if pattern, ok := predicateArg(text, "has.content"); ok {
+			if pattern == "" {
+				return nil, 0, fmt.Errorf("the file:has.content() predicate must have a pattern")
+			}
+			q, err := RegexpQuery(pattern, true, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			// The file must match, but the matches aren't returned, like
+			// the matches of type:filename.
+			expr = &Type{Type: TypeFileName, Child: q}
+			break
+		}
 		q, err := RegexpQuery(text, false, true)
 		if err != nil {
 			return nil, 0, err
 		}
 		expr = q
+	case tokHasFile:
+		if expr, err = newRepoHasFile(text); err != nil {
+			return nil, 0, err
+		}
 	case tokContent:
 		q, err := RegexpQuery(text, true, false)
 		if err != nil {
 			return nil, 0, err
 		}
 		expr = q
+	case tokDirname, tokBasename:
+		// Path components are matched exactly, so dirname:util does not match
+		// utilities/. We rewrite into an anchored file regexp, which is
+		// evaluated against the filename ngram index like any file: atom.
+		atom, suffix := "dirname:", "/"
+		if tok.Type == tokBasename {
+			atom, suffix = "basename:", "$"
+		}
+		name := strings.Trim(text, "/")
+		if name == "" {
+			return nil, 0, fmt.Errorf("the %s atom must have an argument", atom)
+		}
+		q, err := RegexpQuery("(?:^|/)"+regexp.QuoteMeta(name)+suffix, false, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = q
 	case tokLang:
 		canonical, ok := languages.GetLanguageByAlias(text)
 		if !ok {
@@ -179,6 +236,36 @@ func parseExpr(in []byte) (Q, int, error) {
 			expr = &Language{Language: canonical}
 		}
 
+	case tokDependency:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the dependency: atom must have an argument")
+		}
+		expr = NewDependency(text)
+
+	case tokLOC, tokNesting, tokTodos:
+		metric := map[int]string{tokLOC: "loc", tokNesting: "nesting", tokTodos: "todos"}[tok.Type]
+		q, err := NewFileMetric(metric, text)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = q
+
+	case tokCustom:
+		name, _, _ := strings.Cut(string(tok.Input), ":")
+		expr = &Custom{Name: name, Arg: text}
+
+	case tokContext:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the context: atom must have a name")
+		}
+		expr = &SearchContext{Name: text}
+
+	case tokMeta:
+		if text == "" || strings.HasPrefix(text, "=") {
+			return nil, 0, fmt.Errorf("the meta: atom must have a key")
+		}
+		expr = NewRepoMeta(text)
+
 	case tokSym:
 		if text == "" {
 			return nil, 0, fmt.Errorf("the sym: atom must have an argument")
@@ -190,6 +277,18 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 
 		expr = &Symbol{q}
+	case tokSymKind:
+		var kinds []string
+		for _, k := range strings.Split(text, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				kinds = append(kinds, k)
+			}
+		}
+		if len(kinds) == 0 {
+			return nil, 0, fmt.Errorf("the sym.kind: atom must have an argument")
+		}
+		// Later we will lift this onto the symbol atoms, like we do for caseQ
+		expr = &symbolKindQ{Kinds: kinds}
 	case tokParenClose:
 		// Caller must consume paren.
 		expr = nil
@@ -214,6 +313,21 @@ func parseExpr(in []byte) (Q, int, error) {
 		if err != nil {
 			return nil, 0, err
 		}
+	case tokLine:
+		subQ, n, err := parseExpr(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		b = b[n:]
+
+		subQ = Simplify(subQ)
+		children := []Q{subQ}
+		if and, ok := subQ.(*And); ok {
+			children = and.Children
+		}
+		if expr, err = NewSameLine(children...); err != nil {
+			return nil, 0, err
+		}
 	case tokNegate:
 		subQ, n, err := parseExpr(b)
 		if err != nil {
@@ -234,8 +348,10 @@ func parseExpr(in []byte) (Q, int, error) {
 			t = TypeFileName
 		case "repo":
 			t = TypeRepo
+		case "dir", "directory":
+			t = TypeDirectory
 		default:
-			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo}", text)
+			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo,dir}", text)
 		}
 		// Later we will lift this into a root, like we do for caseQ
 		expr = &Type{Type: t, Child: nil}
@@ -251,6 +367,11 @@ const regexpFlags syntax.Flags = syntax.ClassNL | syntax.PerlX | syntax.UnicodeG
 func RegexpQuery(text string, content, file bool) (Q, error) {
 	var expr Q
 
+	text, err := rewriteLookarounds(text)
+	if err != nil {
+		return nil, err
+	}
+
 	r, err := syntax.Parse(text, regexpFlags)
 	if err != nil {
 		return nil, err
@@ -258,7 +379,10 @@ func RegexpQuery(text string, content, file bool) (Q, error) {
 
 	r = OptimizeRegexp(r, regexpFlags)
 
-	if r.Op == syntax.OpLiteral {
+	// A literal with an inline (?i) flag stays a Regexp. Substring has no
+	// place to keep the flag, so case:yes or case:auto would make it case
+	// sensitive.
+	if r.Op == syntax.OpLiteral && r.Flags&syntax.FoldCase == 0 {
 		expr = &Substring{
 			Pattern:  string(r.Rune),
 			FileName: file,
@@ -301,6 +425,32 @@ func parseOperators(in []Q) (Q, error) {
 	return top, nil
 }
 
+// predicateArg returns ARG if text is the predicate name(ARG), eg.
+// has.file(go\.mod).
+func predicateArg(text, name string) (string, bool) {
+	arg, ok := strings.CutPrefix(text, name+"(")
+	if !ok || !strings.HasSuffix(arg, ")") {
+		return "", false
+	}
+	return strings.TrimSuffix(arg, ")"), true
+}
+
+// newRepoHasFile returns the query for repo:has.file(PATTERN) and
+// repohasfile:PATTERN, which matches the repositories with a file whose name
+// matches pattern. It is a type:repo sub-query, which the shards searcher
+// replaces by the set of repositories it lists. Listing them only looks up
+// the file names, and stops at the first matching file of every repository.
+func newRepoHasFile(pattern string) (Q, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("the repo:has.file() predicate must have a pattern")
+	}
+	q, err := RegexpQuery(pattern, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Type{Type: TypeRepo, Child: q}, nil
+}
+
 // parseExprList parses a list of query expressions. It is the
 // workhorse of the Parse function.
 func parseExprList(in []byte) ([]Q, int, error) {
@@ -335,12 +485,25 @@ func parseExprList(in []byte) ([]Q, int, error) {
 	setCase := "auto"
 	newQS := qs[:0]
 	typeT := uint8(100)
+	var kinds []*SymbolKind
 	for _, q := range qs {
 		switch s := q.(type) {
 		case *caseQ:
 			setCase = s.Flavor
+		case *symbolKindQ:
+			kinds = append(kinds, &SymbolKind{Kinds: s.Kinds})
+		case *Not:
+			if sk, ok := s.Child.(*symbolKindQ); ok {
+				kinds = append(kinds, &SymbolKind{Kinds: sk.Kinds, Exclude: true})
+			} else {
+				newQS = append(newQS, q)
+			}
 		case *Type:
-			if s.Type < typeT {
+			// Predicates like repo:has.file() have a child, and are
+			// not a type: atom for the whole list.
+			if s.Child != nil {
+				newQS = append(newQS, q)
+			} else if s.Type < typeT {
 				typeT = s.Type
 			}
 		default:
@@ -353,12 +516,47 @@ func parseExprList(in []byte) ([]Q, int, error) {
 		}
 		return q
 	})
+	if len(kinds) > 0 {
+		var err error
+		if qs, err = withSymbolKinds(qs, kinds); err != nil {
+			return nil, 0, err
+		}
+	}
 	if typeT != 100 {
 		qs = []Q{&Type{Type: typeT, Child: NewAnd(qs...)}}
 	}
 	return qs, len(in) - len(b), nil
 }
 
+// withSymbolKinds wraps the symbol atoms in qs with the sym.kind: filters in
+// kinds. If qs has no symbol atoms, the filters select all symbols, so that
+// "sym.kind:function" on its own finds all functions.
+func withSymbolKinds(qs []Q, kinds []*SymbolKind) ([]Q, error) {
+	wrap := func(q Q) Q {
+		for _, k := range kinds {
+			q = &SymbolKind{Kinds: k.Kinds, Exclude: k.Exclude, Expr: q}
+		}
+		return q
+	}
+
+	found := false
+	qs = mapQueryList(qs, func(q Q) Q {
+		if _, ok := q.(*Symbol); !ok {
+			return q
+		}
+		found = true
+		return wrap(q)
+	})
+	if !found {
+		all, err := RegexpQuery(".*", false, false)
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, wrap(&Symbol{Expr: all}))
+	}
+	return qs, nil
+}
+
 type token struct {
 	Type int
 	// The value of the token
@@ -392,15 +590,36 @@ const (
 	tokArchived   = 15
 	tokPublic     = 16
 	tokFork       = 17
+	tokDirname    = 18
+	tokBasename   = 19
+	tokSymKind    = 20
+	tokDependency = 21
+	tokMeta       = 22
+	tokLOC        = 23
+	tokNesting    = 24
+	tokTodos      = 25
+	tokCustom     = 26
+	tokGenerated  = 27
+	tokHas        = 28
+	tokContext    = 29
+	tokLine       = 30
+	tokHasFile    = 31
 )
 
 var tokNames = map[int]string{
 	tokArchived:   "Archived",
+	tokBasename:   "Basename",
 	tokBranch:     "Branch",
 	tokCase:       "Case",
+	tokContext:    "Context",
+	tokCustom:     "Custom",
+	tokDependency: "Dependency",
+	tokDirname:    "Dirname",
 	tokError:      "Error",
 	tokFile:       "File",
 	tokFork:       "Fork",
+	tokGenerated:  "Generated",
+	tokHas:        "Has",
 	tokNegate:     "Negate",
 	tokOr:         "Or",
 	tokParenClose: "ParenClose",
@@ -408,30 +627,51 @@ var tokNames = map[int]string{
 	tokPublic:     "Public",
 	tokRegex:      "Regex",
 	tokRepo:       "Repo",
+	tokHasFile:    "RepoHasFile",
 	tokText:       "Text",
 	tokLang:       "Language",
+	tokLine:       "Line",
+	tokLOC:        "LOC",
+	tokMeta:       "Meta",
+	tokNesting:    "Nesting",
 	tokSym:        "Symbol",
+	tokSymKind:    "SymbolKind",
+	tokTodos:      "Todos",
 	tokType:       "Type",
 }
 
 var prefixes = map[string]int{
-	"archived:": tokArchived,
-	"b:":        tokBranch,
-	"branch:":   tokBranch,
-	"c:":        tokContent,
-	"case:":     tokCase,
-	"content:":  tokContent,
-	"f:":        tokFile,
-	"file:":     tokFile,
-	"fork:":     tokFork,
-	"public:":   tokPublic,
-	"r:":        tokRepo,
-	"regex:":    tokRegex,
-	"repo:":     tokRepo,
-	"lang:":     tokLang,
-	"sym:":      tokSym,
-	"t:":        tokType,
-	"type:":     tokType,
+	"archived:":    tokArchived,
+	"b:":           tokBranch,
+	"basename:":    tokBasename,
+	"branch:":      tokBranch,
+	"c:":           tokContent,
+	"case:":        tokCase,
+	"content:":     tokContent,
+	"context:":     tokContext,
+	"dep:":         tokDependency,
+	"dependency:":  tokDependency,
+	"dirname:":     tokDirname,
+	"f:":           tokFile,
+	"file:":        tokFile,
+	"fork:":        tokFork,
+	"generated:":   tokGenerated,
+	"has:":         tokHas,
+	"public:":      tokPublic,
+	"r:":           tokRepo,
+	"regex:":       tokRegex,
+	"repo:":        tokRepo,
+	"repohasfile:": tokHasFile,
+	"kind:":        tokSymKind,
+	"lang:":        tokLang,
+	"loc:":         tokLOC,
+	"meta:":        tokMeta,
+	"nesting:":     tokNesting,
+	"sym:":         tokSym,
+	"sym.kind:":    tokSymKind,
+	"t:":           tokType,
+	"todos:":       tokTodos,
+	"type:":        tokType,
 }
 
 var reservedWords = map[string]int{
@@ -463,10 +703,33 @@ func (t *token) setType() {
 
 		t.Text = t.Text[len(pref):]
 		t.Type = typ
-		break
+		return
+	}
+
+	if name, _, ok := bytes.Cut(t.Input, []byte{':'}); ok && t.Type == tokText && isCustomAtom(string(name)) {
+		t.Text = t.Text[len(name)+1:]
+		t.Type = tokCustom
 	}
 }
 
+// predicatePrefixes are the atoms which take a parenthesized predicate, eg.
+// repo:has.file(go\.mod).
+var predicatePrefixes = []string{
+	"f:has.content(",
+	"file:has.content(",
+	"r:has.file(",
+	"repo:has.file(",
+}
+
+func isPredicate(in []byte) bool {
+	for _, p := range predicatePrefixes {
+		if bytes.HasPrefix(in, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
 // nextToken returns the next token from the given input.
 func nextToken(in []byte) (*token, error) {
 	left := in[:]
@@ -484,6 +747,18 @@ func nextToken(in []byte) (*token, error) {
 		}, nil
 	}
 
+	// line: applies to the parenthesized expression which follows it.
+	if bytes.HasPrefix(left, []byte("line:(")) {
+		return &token{
+			Type:  tokLine,
+			Text:  []byte("line:"),
+			Input: in[:len("line:")],
+		}, nil
+	}
+
+	// The argument of predicates like file:has.content() may contain spaces,
+	// so we keep their balanced parentheses together.
+	inPredicate := isPredicate(in)
 	foundSpace := false
 
 loop:
@@ -524,6 +799,11 @@ loop:
 			left = left[1:]
 
 		case ' ', '\n', '\t':
+			if inPredicate && parenCount > 0 {
+				cur.Text = append(cur.Text, c)
+				left = left[1:]
+				continue
+			}
 			if parenCount > 0 {
 				foundSpace = true
 			}
@@ -81,6 +81,11 @@ func TestParseQuery(t *testing.T) {
 		{"regex:abc[p-q]", &Regexp{Regexp: mustParseRE("abc[p-q]")}},
 		{"aBc[p-q]", &Regexp{Regexp: mustParseRE("aBc[p-q]"), CaseSensitive: true}},
 		{"aBc[p-q] case:auto", &Regexp{Regexp: mustParseRE("aBc[p-q]"), CaseSensitive: true}},
+		{"(?i)abc", &Regexp{Regexp: mustParseRE("(?i)abc")}},
+		{"(?i)ABC case:yes", &Regexp{Regexp: mustParseRE("(?i)ABC"), CaseSensitive: true}},
+		{"abc(?i)def", &Regexp{Regexp: mustParseRE("abc(?i)def")}},
+		{"Abc(?i)def", &Regexp{Regexp: mustParseRE("Abc(?i)def"), CaseSensitive: true}},
+		{"(?i)[a-z]+", &Regexp{Regexp: mustParseRE("(?i)[a-z]+")}},
 		{"repo:go", &Repo{regexp.MustCompile("go")}},
 		{"repo:.*", &Repo{Regexp: regexp.MustCompile(".*")}},
 
@@ -89,15 +94,52 @@ func TestParseQuery(t *testing.T) {
 		{"abc\\.\\*def", &Substring{Pattern: "abc.*def"}},
 		{"(abc)", &Substring{Pattern: "abc"}},
 
+		{"dirname:util", &Regexp{Regexp: mustParseRE(`(?:^|/)util/`), FileName: true}},
+		{"dirname:/pkg/util/", &Regexp{Regexp: mustParseRE(`(?:^|/)pkg/util/`), FileName: true}},
+		{"basename:util.go", &Regexp{Regexp: mustParseRE(`(?:^|/)util\.go$`), FileName: true}},
+		{"basename:Util.go", &Regexp{Regexp: mustParseRE(`(?:^|/)Util\.go$`), FileName: true, CaseSensitive: true}},
+		{"dirname:", nil},
+		{"basename:/", nil},
+
 		{"c:abc", &Substring{Pattern: "abc", Content: true}},
 		{"content:abc", &Substring{Pattern: "abc", Content: true}},
 
 		{"lang:c++", &Language{"C++"}},
 		{"lang:cpp", &Language{"C++"}},
+		{"dependency:lodash", &Dependency{Name: "lodash"}},
+		{"dep:lodash@4.17.21", &Dependency{Name: "lodash", Version: "4.17.21"}},
+		{"dep:@babel/core", &Dependency{Name: "@babel/core"}},
+		{"dep:@babel/core@7.24.0", &Dependency{Name: "@babel/core", Version: "7.24.0"}},
+		{"dependency:", nil},
+		{"loc:>1000", &FileMetric{Metric: "loc", Op: ">", Value: 1000}},
+		{"nesting:<=3", &FileMetric{Metric: "nesting", Op: "<=", Value: 3}},
+		{"todos:0", &FileMetric{Metric: "todos", Op: "=", Value: 0}},
+		{"loc:>", nil},
+		{"loc:big", nil},
+		{"generated:yes", &Generated{}},
+		{"generated:no", &Not{Child: &Generated{}}},
+		{"generated:maybe", nil},
+		{"has:secret", &HasSecret{}},
+		{"has:password", nil},
+		{"context:backend-services", &SearchContext{Name: "backend-services"}},
+		{"context:", nil},
+		{"meta:team=payments", &RepoMeta{Key: "team", Value: "payments"}},
+		{"meta:tier", &RepoMeta{Key: "tier"}},
+		{"meta:", nil},
+		{"meta:=payments", nil},
 		{"sym:pqr", &Symbol{&Substring{Pattern: "pqr"}}},
 		{"sym:Pqr", &Symbol{&Substring{Pattern: "Pqr", CaseSensitive: true}}},
 		{"sym:.*", &Symbol{&Regexp{Regexp: mustParseRE(".*")}}},
 		{"sym:a(b|d)e", &Symbol{&Regexp{Regexp: mustParseRE("a[bd]e")}}},
+		{"sym:parse sym.kind:func", &SymbolKind{Kinds: []string{"func"}, Expr: &Symbol{&Substring{Pattern: "parse"}}}},
+		{"sym.kind:func,method sym:parse", &SymbolKind{Kinds: []string{"func", "method"}, Expr: &Symbol{&Substring{Pattern: "parse"}}}},
+		{"sym:parse -sym.kind:const", &SymbolKind{Kinds: []string{"const"}, Exclude: true, Expr: &Symbol{&Substring{Pattern: "parse"}}}},
+		{"sym.kind:class", &SymbolKind{Kinds: []string{"class"}, Expr: &Symbol{&Regexp{Regexp: mustParseRE(".*")}}}},
+		{"sym.kind:", nil},
+		{"sym:Parse kind:func", &SymbolKind{Kinds: []string{"func"}, Expr: &Symbol{&Substring{Pattern: "Parse", CaseSensitive: true}}}},
+		{"sym:parse -kind:const", &SymbolKind{Kinds: []string{"const"}, Exclude: true, Expr: &Symbol{&Substring{Pattern: "parse"}}}},
+		{"kind:", nil},
+		{`"kind:class"`, &Substring{Pattern: "kind:class"}},
 
 		// case
 		{"abc case:yes", &Substring{Pattern: "abc", CaseSensitive: true}},
@@ -113,12 +155,59 @@ func TestParseQuery(t *testing.T) {
 		{"type:repo abc", &Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}},
 		{"type:file abc def", &Type{Type: TypeFileName, Child: NewAnd(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
 		{"(type:repo abc) def", NewAnd(&Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}, &Substring{Pattern: "def"})},
+		{"type:dir abc", &Type{Type: TypeDirectory, Child: &Substring{Pattern: "abc"}}},
+
+		// predicates
+		{"repo:has.file(go.mod)", &Type{Type: TypeRepo, Child: &Regexp{Regexp: mustParseRE("go.mod"), FileName: true}}},
+		{`repohasfile:go\.mod abc`, NewAnd(
+			&Type{Type: TypeRepo, Child: &Substring{Pattern: "go.mod", FileName: true}},
+			&Substring{Pattern: "abc"},
+		)},
+		{"-repo:has.file(^vendor/) abc", NewAnd(
+			&Not{Child: &Type{Type: TypeRepo, Child: &Regexp{Regexp: mustParseRE("^vendor/"), FileName: true}}},
+			&Substring{Pattern: "abc"},
+		)},
+		{"file:has.content(TODO) lang:go", NewAnd(
+			&Type{Type: TypeFileName, Child: &Substring{Pattern: "TODO", Content: true, CaseSensitive: true}},
+			&Language{"Go"},
+		)},
+		{"Printf file:has.content(^package main$)", NewAnd(
+			&Substring{Pattern: "Printf", CaseSensitive: true},
+			&Type{Type: TypeFileName, Child: &Regexp{Regexp: mustParseRE("(?m)^package main$"), Content: true}},
+		)},
+		{"repo:has.file(^docs/(my notes)$) abc", NewAnd(
+			&Type{Type: TypeRepo, Child: &Regexp{Regexp: mustParseRE("^docs/my notes$"), FileName: true}},
+			&Substring{Pattern: "abc"},
+		)},
+		{"repo:has.file()", nil},
+		{"repohasfile:", nil},
+		{"file:has.content()", nil},
+
+		// line
+		{"line:(foo -bar) baz", NewAnd(
+			&SameLine{Children: []Q{
+				&Substring{Pattern: "foo", Content: true},
+				&Not{Child: &Substring{Pattern: "bar", Content: true}},
+			}},
+			&Substring{Pattern: "baz"})},
+		{"line:(Foo ba+r)", &SameLine{Children: []Q{
+			&Substring{Pattern: "Foo", Content: true, CaseSensitive: true},
+			&Regexp{Regexp: mustParseRE("ba+r"), Content: true},
+		}}},
+		{"line:(foo or bar)", nil},
+		{"line:(-foo -bar)", nil},
+		{"line:(file:foo bar)", nil},
+
+		// lookarounds
+		{"foo(?=bar)", &Substring{Pattern: "foobar"}},
+		{"(?<=bar)foo", &Substring{Pattern: "barfoo"}},
 
 		// errors.
 		{"--", nil},
 		{"\"abc", nil},
 		{"\"a\\", nil},
 		{"case:foo", nil},
+		{"foo(?!bar)baz", nil},
 
 		{"sym:", nil},
 		{"abc or", nil},
@@ -149,6 +238,8 @@ func TestTokenize(t *testing.T) {
 		{"file:bla", tokFile, "bla"},
 		{"file:bla ", tokFile, "bla"},
 		{"f:bla ", tokFile, "bla"},
+		{"dirname:bla", tokDirname, "bla"},
+		{"basename:bla", tokBasename, "bla"},
 		{"(abc def) ", tokParenOpen, "("},
 		{"(abcdef)", tokText, "(abcdef)"},
 		{"(abc)(de)", tokText, "(abc)(de)"},
@@ -179,3 +270,35 @@ func TestTokenize(t *testing.T) {
 		}
 	}
 }
+
+func init() {
+	if err := RegisterCustomAtom("semver"); err != nil {
+		panic(err)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	got, err := Parse(`semver:">=1.2 <2" foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewAnd(&Custom{Name: "semver", Arg: ">=1.2 <2"}, &Substring{Pattern: "foo"})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Unregistered names are still text.
+	got, err = Parse("unknown:12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Substring{Pattern: "unknown:12"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, name := range []string{"semver", "file", "", "a b"} {
+		if err := RegisterCustomAtom(name); err == nil {
+			t.Errorf("RegisterCustomAtom(%q): expected error", name)
+		}
+	}
+}
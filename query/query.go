@@ -20,12 +20,15 @@ import (
 	"log"
 	"reflect"
 	"regexp/syntax"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
+	"github.com/sourcegraph/zoekt/internal/filemetrics"
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
 )
 
@@ -128,6 +131,55 @@ func (s *Symbol) String() string {
 	return fmt.Sprintf("sym:%s", s.Expr)
 }
 
+// SymbolKind restricts the matches of the symbol atoms in Expr to symbols
+// whose kind, as reported by ctags, is one of Kinds. If Exclude is set,
+// symbols of those kinds are dropped instead. Other atoms in Expr are not
+// affected.
+type SymbolKind struct {
+	Kinds   []string
+	Exclude bool
+	Expr    Q
+}
+
+func (q *SymbolKind) String() string {
+	neg := ""
+	if q.Exclude {
+		neg = "-"
+	}
+	return fmt.Sprintf("(%ssym.kind:%s %s)", neg, strings.Join(q.Kinds, ","), q.Expr)
+}
+
+// symbolKindAliases maps the short kind names people tend to type onto the
+// names universal-ctags uses for them.
+var symbolKindAliases = map[string]string{
+	"fn":    "function",
+	"func":  "function",
+	"var":   "variable",
+	"const": "constant",
+	"iface": "interface",
+}
+
+func canonicalSymbolKind(kind string) string {
+	kind = strings.ToLower(kind)
+	if alias, ok := symbolKindAliases[kind]; ok {
+		return alias
+	}
+	return kind
+}
+
+// Match returns true if symbols of the given kind pass the filter.
+func (q *SymbolKind) Match(kind string) bool {
+	kind = canonicalSymbolKind(kind)
+	found := false
+	for _, k := range q.Kinds {
+		if canonicalSymbolKind(k) == kind {
+			found = true
+			break
+		}
+	}
+	return found != q.Exclude
+}
+
 type caseQ struct {
 	Flavor string
 }
@@ -136,6 +188,17 @@ func (c *caseQ) String() string {
 	return "case:" + c.Flavor
 }
 
+// symbolKindQ is the parsed form of a sym.kind: atom. Like caseQ it does not
+// survive parsing: it is turned into SymbolKind nodes wrapping the symbol
+// atoms next to it.
+type symbolKindQ struct {
+	Kinds []string
+}
+
+func (q *symbolKindQ) String() string {
+	return "sym.kind:" + strings.Join(q.Kinds, ",")
+}
+
 type Language struct {
 	Language string
 }
@@ -144,6 +207,160 @@ func (l *Language) String() string {
 	return "lang:" + l.Language
 }
 
+// Dependency matches lockfiles (go.sum, package-lock.json, poetry.lock) that
+// resolve the dependency Name. If Version is set, the resolved version must
+// be equal to it.
+type Dependency struct {
+	Name    string
+	Version string
+}
+
+// NewDependency parses the argument of a dependency: atom, "name" or
+// "name@version". Names may start with @, like scoped npm packages.
+func NewDependency(s string) *Dependency {
+	if i := strings.LastIndexByte(s, '@'); i > 0 {
+		return &Dependency{Name: s[:i], Version: s[i+1:]}
+	}
+	return &Dependency{Name: s}
+}
+
+func (q *Dependency) String() string {
+	if q.Version == "" {
+		return "dependency:" + q.Name
+	}
+	return "dependency:" + q.Name + "@" + q.Version
+}
+
+// FileMetric matches files by a metric computed at index time: "loc" (non-blank
+// lines), "nesting" (maximum nesting depth) or "todos" (TODO and FIXME
+// markers). The metric must compare to Value with Op, which is one of "<",
+// "<=", "=", ">=" and ">".
+type FileMetric struct {
+	Metric string
+	Op     string
+	Value  uint32
+}
+
+// fileMetricOps are the comparison operators of FileMetric. Longer operators
+// go first, so we parse "<=" before "<".
+var fileMetricOps = []string{"<=", ">=", "<", ">", "="}
+
+// NewFileMetric parses the argument of a metric atom, like ">1000" in
+// loc:>1000. Without an operator, the metric must be equal to the value.
+func NewFileMetric(metric, s string) (*FileMetric, error) {
+	op := "="
+	for _, o := range fileMetricOps {
+		if strings.HasPrefix(s, o) {
+			op, s = o, s[len(o):]
+			break
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("query: %s: wants a number with an optional comparison, like %s:>100", metric, metric)
+	}
+	q := &FileMetric{Metric: metric, Op: op, Value: uint32(v)}
+	return q, q.validate()
+}
+
+func (q *FileMetric) validate() error {
+	if !slices.Contains(filemetrics.Names, q.Metric) {
+		return fmt.Errorf("query: unknown file metric %q, want one of %s", q.Metric, strings.Join(filemetrics.Names, ", "))
+	}
+	if !slices.Contains(fileMetricOps, q.Op) {
+		return fmt.Errorf("query: unknown comparison %q for file metric %s", q.Op, q.Metric)
+	}
+	return nil
+}
+
+func (q *FileMetric) String() string {
+	return q.Metric + ":" + q.Op + strconv.FormatUint(uint64(q.Value), 10)
+}
+
+// Match returns true if the metric value v satisfies q.
+func (q *FileMetric) Match(v uint32) bool {
+	switch q.Op {
+	case "<":
+		return v < q.Value
+	case "<=":
+		return v <= q.Value
+	case ">=":
+		return v >= q.Value
+	case ">":
+		return v > q.Value
+	default:
+		return v == q.Value
+	}
+}
+
+// Generated matches files which look generated or minified, as determined at
+// index time. In query strings, it is written as generated:yes, and
+// generated:no is its negation.
+type Generated struct{}
+
+func (q *Generated) String() string {
+	return "generated:yes"
+}
+
+// HasSecret matches files in which a credential, like an API key, was found
+// at index time. In query strings, it is written as has:secret.
+type HasSecret struct{}
+
+func (q *HasSecret) String() string {
+	return "has:secret"
+}
+
+// SearchContext refers to a named query stored on the server, eg. the
+// repositories and branches of a team. The server replaces it with the stored
+// query before searching. In query strings, it is written as context:NAME.
+type SearchContext struct {
+	Name string
+}
+
+func (q *SearchContext) String() string {
+	return "context:" + q.Name
+}
+
+// Custom is an atom evaluated by a matcher that the embedding program
+// registered with zoekt.RegisterMatcher under Name. In query strings, it is
+// written as NAME:ARG.
+type Custom struct {
+	Name string
+	Arg  string
+}
+
+func (q *Custom) String() string {
+	return q.Name + ":" + q.Arg
+}
+
+var (
+	customAtomsMu sync.RWMutex
+	customAtoms   = map[string]bool{}
+)
+
+// RegisterCustomAtom makes Parse turn NAME:ARG into a Custom atom. It fails if
+// name is already in use. zoekt.RegisterMatcher calls it, so programs which
+// evaluate queries don't need to.
+func RegisterCustomAtom(name string) error {
+	if name == "" || strings.ContainsAny(name, ": \t\n()\"\\") {
+		return fmt.Errorf("query: invalid custom atom name %q", name)
+	}
+
+	customAtomsMu.Lock()
+	defer customAtomsMu.Unlock()
+	if _, ok := prefixes[name+":"]; ok || customAtoms[name] {
+		return fmt.Errorf("query: atom %q is already defined", name)
+	}
+	customAtoms[name] = true
+	return nil
+}
+
+func isCustomAtom(name string) bool {
+	customAtomsMu.RLock()
+	defer customAtomsMu.RUnlock()
+	return customAtoms[name]
+}
+
 type Const struct {
 	Value bool
 }
@@ -305,6 +522,32 @@ func NewRepoSet(repo ...string) *RepoSet {
 	return s
 }
 
+// RepoMeta matches repositories by the key/value metadata attached to them at
+// index time. An empty Value matches all repositories that have Key.
+type RepoMeta struct {
+	Key   string
+	Value string
+}
+
+// NewRepoMeta parses the argument of a meta: atom, "key" or "key=value".
+func NewRepoMeta(s string) *RepoMeta {
+	key, value, _ := strings.Cut(s, "=")
+	return &RepoMeta{Key: key, Value: value}
+}
+
+func (q *RepoMeta) String() string {
+	if q.Value == "" {
+		return "meta:" + q.Key
+	}
+	return "meta:" + q.Key + "=" + q.Value
+}
+
+// Match returns true if the repository metadata m matches q.
+func (q *RepoMeta) Match(m map[string]string) bool {
+	v, ok := m[q.Key]
+	return ok && (q.Value == "" || v == q.Value)
+}
+
 // FileNameSet is a list of file names to match. It is a Sourcegraph addition
 // and only used in the RPC interface for efficient checking of large file
 // lists.
@@ -352,6 +595,10 @@ const (
 	TypeFileMatch uint8 = iota
 	TypeFileName
 	TypeRepo
+
+	// TypeDirectory aggregates file matches into the directories containing
+	// them, see zoekt.DirectoryMatch.
+	TypeDirectory
 )
 
 // Type changes the result type returned.
@@ -368,6 +615,8 @@ func (q *Type) String() string {
 		return fmt.Sprintf("(type:filename %s)", q.Child)
 	case TypeRepo:
 		return fmt.Sprintf("(type:repo %s)", q.Child)
+	case TypeDirectory:
+		return fmt.Sprintf("(type:dir %s)", q.Child)
 	default:
 		return fmt.Sprintf("(type:UNKNOWN %s)", q.Child)
 	}
@@ -437,6 +686,12 @@ func (q *Symbol) setCase(k string) {
 	}
 }
 
+func (q *SymbolKind) setCase(k string) {
+	if sc, ok := q.Expr.(setCaser); ok {
+		sc.setCase(k)
+	}
+}
+
 func (q *Regexp) setCase(k string) {
 	switch k {
 	case "yes":
@@ -444,7 +699,7 @@ func (q *Regexp) setCase(k string) {
 	case "no":
 		q.CaseSensitive = false
 	case "auto":
-		q.CaseSensitive = !q.Regexp.Equal(LowerRegexp(q.Regexp))
+		q.CaseSensitive = hasCaseSensitiveUpper(q.Regexp)
 	}
 }
 
@@ -483,6 +738,65 @@ func (q *And) String() string {
 	return fmt.Sprintf("(and %s)", strings.Join(sub, " "))
 }
 
+// SameLine is matched by the lines which all its children match, rather than
+// just the same document. Its children are content patterns, ie. Substring
+// or Regexp, which may be negated to exclude the lines they match. Only the
+// matches on the matched lines are returned. Construct it with NewSameLine.
+type SameLine struct {
+	Children []Q
+}
+
+func (q *SameLine) String() string {
+	var sub []string
+	for _, ch := range q.Children {
+		sub = append(sub, ch.String())
+	}
+	return fmt.Sprintf("(line %s)", strings.Join(sub, " "))
+}
+
+// NewSameLine returns a SameLine of the given children. It fails unless they
+// are content patterns or their negation, and at least one is not negated.
+func NewSameLine(children ...Q) (*SameLine, error) {
+	q := &SameLine{}
+	positive := false
+	for _, ch := range children {
+		neg, isNot := ch.(*Not)
+		if isNot {
+			ch = neg.Child
+		}
+
+		switch s := ch.(type) {
+		case *Substring:
+			if s.FileName {
+				return nil, fmt.Errorf("query: line: does not support file name patterns, got %s", s)
+			}
+			c := *s
+			c.Content = true
+			ch = &c
+		case *Regexp:
+			if s.FileName {
+				return nil, fmt.Errorf("query: line: does not support file name patterns, got %s", s)
+			}
+			c := *s
+			c.Content = true
+			ch = &c
+		default:
+			return nil, fmt.Errorf("query: line: only supports patterns and their negation, got %s", ch)
+		}
+
+		if isNot {
+			ch = &Not{Child: ch}
+		} else {
+			positive = true
+		}
+		q.Children = append(q.Children, ch)
+	}
+	if !positive {
+		return nil, fmt.Errorf("query: line: needs a pattern which is not negated")
+	}
+	return q, nil
+}
+
 // NewAnd is syntactic sugar for constructing And queries.
 func NewAnd(qs ...Q) Q {
 	return &And{Children: qs}
@@ -694,12 +1008,16 @@ func Map(q Q, f func(q Q) Q) Q {
 		q = &And{Children: mapQueryList(s.Children, f)}
 	case *Or:
 		q = &Or{Children: mapQueryList(s.Children, f)}
+	case *SameLine:
+		q = &SameLine{Children: mapQueryList(s.Children, f)}
 	case *Not:
 		q = &Not{Child: Map(s.Child, f)}
 	case *Type:
 		q = &Type{Type: s.Type, Child: Map(s.Child, f)}
 	case *Boost:
 		q = &Boost{Boost: s.Boost, Child: Map(s.Child, f)}
+	case *SymbolKind:
+		q = &SymbolKind{Kinds: s.Kinds, Exclude: s.Exclude, Expr: Map(s.Expr, f)}
 	}
 	return f(q)
 }
@@ -738,9 +1056,11 @@ func VisitAtoms(q Q, v func(q Q)) {
 		switch iQ.(type) {
 		case *And:
 		case *Or:
+		case *SameLine:
 		case *Not:
 		case *Type:
 		case *Boost:
+		case *SymbolKind:
 		default:
 			v(iQ)
 		}
@@ -0,0 +1,476 @@
+package query
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt/internal/syntaxutil"
+)
+
+// JSONSchema is the JSON schema of the encoding produced by the MarshalJSON
+// methods of the query nodes and accepted by QFromJSON.
+//
+//go:embed query.schema.json
+var JSONSchema []byte
+
+// jsonQ is the JSON encoding of a query node. Type selects the node, and
+// determines which of the other fields are used. See query.schema.json.
+type jsonQ struct {
+	Type string `json:"type"`
+
+	Pattern       string            `json:"pattern,omitempty"`
+	Regexp        string            `json:"regexp,omitempty"`
+	CaseSensitive bool              `json:"caseSensitive,omitempty"`
+	FileName      bool              `json:"fileName,omitempty"`
+	Content       bool              `json:"content,omitempty"`
+	Exact         bool              `json:"exact,omitempty"`
+	Language      string            `json:"language,omitempty"`
+	Name          string            `json:"name,omitempty"`
+	Version       string            `json:"version,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	Equals        string            `json:"equals,omitempty"`
+	Metric        string            `json:"metric,omitempty"`
+	Op            string            `json:"op,omitempty"`
+	Threshold     uint32            `json:"threshold,omitempty"`
+	Arg           string            `json:"arg,omitempty"`
+	Value         bool              `json:"value,omitempty"`
+	Kinds         []string          `json:"kinds,omitempty"`
+	Exclude       bool              `json:"exclude,omitempty"`
+	ResultType    string            `json:"resultType,omitempty"`
+	Boost         float64           `json:"boost,omitempty"`
+	Flags         []string          `json:"flags,omitempty"`
+	Repos         []uint32          `json:"repos,omitempty"`
+	Set           []string          `json:"set,omitempty"`
+	List          []jsonBranchRepos `json:"list,omitempty"`
+	Expr          json.RawMessage   `json:"expr,omitempty"`
+	Child         json.RawMessage   `json:"child,omitempty"`
+	Children      []json.RawMessage `json:"children,omitempty"`
+}
+
+type jsonBranchRepos struct {
+	Branch string   `json:"branch"`
+	Repos  []uint32 `json:"repos"`
+}
+
+var resultTypeNames = map[uint8]string{
+	TypeFileMatch: "filematch",
+	TypeFileName:  "filename",
+	TypeRepo:      "repo",
+	TypeDirectory: "dir",
+}
+
+// marshalQ encodes q. Unlike json.Marshal, it fails for nodes that have no
+// JSON representation, such as the intermediate nodes used by the parser.
+func marshalQ(q Q) (json.RawMessage, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query: cannot marshal nil query")
+	}
+	m, ok := q.(json.Marshaler)
+	if !ok {
+		return nil, fmt.Errorf("query: %T has no JSON representation", q)
+	}
+	return m.MarshalJSON()
+}
+
+func marshalQList(qs []Q) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, 0, len(qs))
+	for _, q := range qs {
+		raw, err := marshalQ(q)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, raw)
+	}
+	return out, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Substring) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{
+		Type:          "substring",
+		Pattern:       q.Pattern,
+		CaseSensitive: q.CaseSensitive,
+		FileName:      q.FileName,
+		Content:       q.Content,
+	})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Regexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{
+		Type:          "regexp",
+		Regexp:        syntaxutil.RegexpString(q.Regexp),
+		CaseSensitive: q.CaseSensitive,
+		FileName:      q.FileName,
+		Content:       q.Content,
+	})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Symbol) MarshalJSON() ([]byte, error) {
+	expr, err := marshalQ(q.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "symbol", Expr: expr})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *SymbolKind) MarshalJSON() ([]byte, error) {
+	expr, err := marshalQ(q.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "symbolKind", Kinds: q.Kinds, Exclude: q.Exclude, Expr: expr})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Language) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "language", Language: q.Language})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Dependency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "dependency", Name: q.Name, Version: q.Version})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *FileMetric) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "fileMetric", Metric: q.Metric, Op: q.Op, Threshold: q.Value})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Generated) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "generated"})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *HasSecret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "hasSecret"})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *SearchContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "searchContext", Name: q.Name})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Custom) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "custom", Name: q.Name, Arg: q.Arg})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Const) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "const", Value: q.Value})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Repo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "repo", Regexp: q.Regexp.String()})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *RepoRegexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "repoRegexp", Regexp: q.Regexp.String()})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *BranchesRepos) MarshalJSON() ([]byte, error) {
+	list := make([]jsonBranchRepos, 0, len(q.List))
+	for _, br := range q.List {
+		list = append(list, jsonBranchRepos{Branch: br.Branch, Repos: br.Repos.ToArray()})
+	}
+	return json.Marshal(jsonQ{Type: "branchesRepos", List: list})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *RepoIDs) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "repoIds", Repos: q.Repos.ToArray()})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *RepoSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "repoSet", Set: sortedKeys(q.Set)})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *RepoMeta) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "repoMeta", Key: q.Key, Equals: q.Value})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *FileNameSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "fileNameSet", Set: sortedKeys(q.Set)})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Type) MarshalJSON() ([]byte, error) {
+	name, ok := resultTypeNames[q.Type]
+	if !ok {
+		return nil, fmt.Errorf("query: unknown type %d", q.Type)
+	}
+	child, err := marshalQ(q.Child)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "type", ResultType: name, Child: child})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Boost) MarshalJSON() ([]byte, error) {
+	child, err := marshalQ(q.Child)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "boost", Boost: q.Boost, Child: child})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *And) MarshalJSON() ([]byte, error) {
+	children, err := marshalQList(q.Children)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "and", Children: children})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Or) MarshalJSON() ([]byte, error) {
+	children, err := marshalQList(q.Children)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "or", Children: children})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *SameLine) MarshalJSON() ([]byte, error) {
+	children, err := marshalQList(q.Children)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "sameLine", Children: children})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Not) MarshalJSON() ([]byte, error) {
+	child, err := marshalQ(q.Child)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonQ{Type: "not", Child: child})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (q *Branch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQ{Type: "branch", Pattern: q.Pattern, Exact: q.Exact})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r RawConfig) MarshalJSON() ([]byte, error) {
+	var flags []string
+	for _, fn := range flagNames {
+		if r&fn.Mask != 0 {
+			flags = append(flags, fn.Label)
+		}
+	}
+	return json.Marshal(jsonQ{Type: "rawConfig", Flags: flags})
+}
+
+// QFromJSON decodes a query tree from its JSON encoding. Unknown node types
+// and fields are rejected.
+func QFromJSON(data []byte) (Q, error) {
+	var j jsonQ
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&j); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	switch j.Type {
+	case "substring":
+		return &Substring{
+			Pattern:       j.Pattern,
+			CaseSensitive: j.CaseSensitive,
+			FileName:      j.FileName,
+			Content:       j.Content,
+		}, nil
+	case "regexp":
+		r, err := syntax.Parse(j.Regexp, regexpFlags)
+		if err != nil {
+			return nil, err
+		}
+		return &Regexp{
+			Regexp:        r,
+			CaseSensitive: j.CaseSensitive,
+			FileName:      j.FileName,
+			Content:       j.Content,
+		}, nil
+	case "symbol":
+		expr, err := childFromJSON(j.Type, "expr", j.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &Symbol{Expr: expr}, nil
+	case "symbolKind":
+		expr, err := childFromJSON(j.Type, "expr", j.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &SymbolKind{Kinds: j.Kinds, Exclude: j.Exclude, Expr: expr}, nil
+	case "language":
+		return &Language{Language: j.Language}, nil
+	case "dependency":
+		return &Dependency{Name: j.Name, Version: j.Version}, nil
+	case "fileMetric":
+		q := &FileMetric{Metric: j.Metric, Op: j.Op, Value: j.Threshold}
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+		return q, nil
+	case "generated":
+		return &Generated{}, nil
+	case "hasSecret":
+		return &HasSecret{}, nil
+	case "searchContext":
+		if j.Name == "" {
+			return nil, fmt.Errorf("query: searchContext node must have a name")
+		}
+		return &SearchContext{Name: j.Name}, nil
+	case "custom":
+		if j.Name == "" {
+			return nil, fmt.Errorf("query: custom node must have a name")
+		}
+		return &Custom{Name: j.Name, Arg: j.Arg}, nil
+	case "const":
+		return &Const{Value: j.Value}, nil
+	case "repo":
+		r, err := regexp.Compile(j.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		return &Repo{Regexp: r}, nil
+	case "repoRegexp":
+		r, err := regexp.Compile(j.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		return &RepoRegexp{Regexp: r}, nil
+	case "branchesRepos":
+		list := make([]BranchRepos, 0, len(j.List))
+		for _, br := range j.List {
+			list = append(list, BranchRepos{Branch: br.Branch, Repos: roaring.BitmapOf(br.Repos...)})
+		}
+		return &BranchesRepos{List: list}, nil
+	case "repoIds":
+		return NewRepoIDs(j.Repos...), nil
+	case "repoSet":
+		return NewRepoSet(j.Set...), nil
+	case "repoMeta":
+		if j.Key == "" {
+			return nil, fmt.Errorf("query: repoMeta requires a key")
+		}
+		return &RepoMeta{Key: j.Key, Value: j.Equals}, nil
+	case "fileNameSet":
+		return NewFileNameSet(j.Set...), nil
+	case "type":
+		child, err := childFromJSON(j.Type, "child", j.Child)
+		if err != nil {
+			return nil, err
+		}
+		for t, name := range resultTypeNames {
+			if name == j.ResultType {
+				return &Type{Type: t, Child: child}, nil
+			}
+		}
+		return nil, fmt.Errorf("query: unknown resultType %q, want {filematch,filename,repo,dir}", j.ResultType)
+	case "boost":
+		child, err := childFromJSON(j.Type, "child", j.Child)
+		if err != nil {
+			return nil, err
+		}
+		return &Boost{Boost: j.Boost, Child: child}, nil
+	case "and":
+		children, err := childrenFromJSON(j.Children)
+		if err != nil {
+			return nil, err
+		}
+		return &And{Children: children}, nil
+	case "or":
+		children, err := childrenFromJSON(j.Children)
+		if err != nil {
+			return nil, err
+		}
+		return &Or{Children: children}, nil
+	case "sameLine":
+		children, err := childrenFromJSON(j.Children)
+		if err != nil {
+			return nil, err
+		}
+		q, err := NewSameLine(children...)
+		if err != nil {
+			return nil, err
+		}
+		return q, nil
+	case "not":
+		child, err := childFromJSON(j.Type, "child", j.Child)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Child: child}, nil
+	case "branch":
+		return &Branch{Pattern: j.Pattern, Exact: j.Exact}, nil
+	case "rawConfig":
+		var r RawConfig
+	flags:
+		for _, f := range j.Flags {
+			for _, fn := range flagNames {
+				if fn.Label == f {
+					r |= fn.Mask
+					continue flags
+				}
+			}
+			return nil, fmt.Errorf("query: unknown rawConfig flag %q", f)
+		}
+		return r, nil
+	case "":
+		return nil, fmt.Errorf("query: missing type")
+	default:
+		return nil, fmt.Errorf("query: unknown type %q", j.Type)
+	}
+}
+
+func childFromJSON(typ, field string, raw json.RawMessage) (Q, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("query: %s is missing %s", typ, field)
+	}
+	return QFromJSON(raw)
+}
+
+func childrenFromJSON(raws []json.RawMessage) ([]Q, error) {
+	qs := make([]Q, 0, len(raws))
+	for _, raw := range raws {
+		q, err := QFromJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
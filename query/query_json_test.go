@@ -0,0 +1,167 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/regexp"
+)
+
+func TestQueryJSONRoundtrip(t *testing.T) {
+	testCases := []Q{
+		&Substring{Pattern: "foo", CaseSensitive: true, Content: true},
+		&Regexp{Regexp: regexpMustParse("fo+[a-z]"), FileName: true},
+		&Symbol{Expr: &Substring{Pattern: "Parse"}},
+		&SymbolKind{Kinds: []string{"func", "method"}, Exclude: true, Expr: &Symbol{Expr: &Substring{Pattern: "Parse"}}},
+		&Language{Language: "Go"},
+		&Dependency{Name: "@babel/core", Version: "7.24.0"},
+		&FileMetric{Metric: "loc", Op: ">", Value: 1000},
+		&FileMetric{Metric: "todos", Op: "=", Value: 0},
+		&Generated{},
+		&HasSecret{},
+		&SearchContext{Name: "backend-services"},
+		&Custom{Name: "semver", Arg: ">=1.2 <2"},
+		&Const{Value: true},
+		&Const{Value: false},
+		&Repo{Regexp: regexp.MustCompile("github.com/foo/bar")},
+		&RepoRegexp{Regexp: regexp.MustCompile("github.com/foo.*")},
+		NewSingleBranchesRepos("HEAD", 3, 34),
+		NewRepoIDs(3, 4, 5),
+		NewRepoSet("test1", "test2"),
+		&RepoMeta{Key: "team", Value: "payments"},
+		&RepoMeta{Key: "tier"},
+		NewFileNameSet("test3", "test4"),
+		&Type{Type: TypeRepo, Child: &Substring{Pattern: "interface"}},
+		&Type{Type: TypeDirectory, Child: &Substring{Pattern: "interface"}},
+		&Boost{Boost: 20, Child: &Substring{Pattern: "foo bar"}},
+		&And{Children: []Q{&Language{Language: "Go"}, &Not{Child: &Branch{Pattern: "main", Exact: true}}}},
+		&Or{Children: []Q{&Substring{Pattern: "foo"}, &Substring{Pattern: "bar"}}},
+		&SameLine{Children: []Q{&Substring{Pattern: "foo", Content: true}, &Not{Child: &Regexp{Regexp: regexpMustParse("ba+r"), Content: true}}}},
+		RcOnlyPublic | RcNoForks,
+	}
+
+	for _, q := range testCases {
+		t.Run(q.String(), func(t *testing.T) {
+			b, err := json.Marshal(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q2, err := QFromJSON(b)
+			if err != nil {
+				t.Fatalf("%s: %v", b, err)
+			}
+			if diff := cmp.Diff(q.String(), q2.String()); diff != "" {
+				t.Fatalf("unexpected diff for %s: %s", b, diff)
+			}
+		})
+	}
+}
+
+func TestQueryJSONParsed(t *testing.T) {
+	// Queries from the parser must survive a roundtrip, so clients can parse
+	// once and store the tree.
+	for _, s := range []string{
+		`foo bar -file:\.go$ (lang:go or lang:java)`,
+		`sym:Parse sym.kind:func case:yes`,
+		`type:repo archived:no repo:^github\.com/`,
+		`meta:team=payments -meta:tier`,
+		`loc:>1000 todos:>=1 -nesting:<4`,
+		`foo generated:no`,
+		`has:secret -f:_test\.go$`,
+		`context:backend-services foo`,
+		`line:(foo -bar) baz`,
+	} {
+		q, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q2, err := QFromJSON(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(q.String(), q2.String()); diff != "" {
+			t.Errorf("%s: unexpected diff: %s", s, diff)
+		}
+	}
+}
+
+func TestQueryJSONErrors(t *testing.T) {
+	for _, in := range []string{
+		`{}`,
+		`{"type":"nope"}`,
+		`{"type":"substring","patern":"typo"}`,
+		`{"type":"not"}`,
+		`{"type":"and","children":[{"type":"symbol"}]}`,
+		`{"type":"type","resultType":"tree","child":{"type":"const"}}`,
+		`{"type":"rawConfig","flags":["RcOnlyFancy"]}`,
+		`{"type":"regexp","regexp":"("}`,
+		`{"type":"repoMeta","equals":"payments"}`,
+		`{"type":"fileMetric","metric":"size","op":">"}`,
+		`{"type":"fileMetric","metric":"loc","op":"!="}`,
+		`{"type":"custom","arg":"x"}`,
+		`{"type":"sameLine","children":[{"type":"not","child":{"type":"substring","pattern":"a"}}]}`,
+		`{"type":"sameLine","children":[{"type":"language","language":"Go"}]}`,
+	} {
+		if q, err := QFromJSON([]byte(in)); err == nil {
+			t.Errorf("%s: expected error, got %s", in, q)
+		}
+	}
+
+	if _, err := json.Marshal(&And{Children: []Q{&caseQ{"yes"}}}); err == nil {
+		t.Error("expected error marshalling an internal node")
+	}
+}
+
+func TestQueryJSONSchema(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Const string `json:"const"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(JSONSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node type in the schema must be accepted by QFromJSON, and be
+	// defined under its own name.
+	for name, def := range schema.Defs {
+		if name == "q" {
+			continue
+		}
+		typ := def.Properties["type"].Const
+		if typ != name {
+			t.Errorf("schema definition %s has type %q", name, typ)
+		}
+		in := map[string]any{"type": typ}
+		switch typ {
+		case "symbol", "symbolKind":
+			in["expr"] = map[string]any{"type": "substring", "pattern": "a"}
+		case "type":
+			in["resultType"] = "filematch"
+			in["child"] = map[string]any{"type": "const"}
+		case "repoMeta":
+			in["key"] = "team"
+		case "fileMetric":
+			in["metric"], in["op"] = "loc", ">"
+		case "searchContext":
+			in["name"] = "backend-services"
+		case "custom":
+			in["name"] = "semver"
+		case "boost", "not":
+			in["child"] = map[string]any{"type": "const"}
+		case "sameLine":
+			in["children"] = []any{map[string]any{"type": "substring", "pattern": "a"}}
+		}
+		b, _ := json.Marshal(in)
+		if _, err := QFromJSON(b); err != nil {
+			t.Errorf("schema type %s: %v", typ, err)
+		}
+	}
+}
@@ -32,6 +32,8 @@ func QToProto(q Q) *proto.Q {
 		return &proto.Q{Query: &proto.Q_RepoIds{RepoIds: v.ToProto()}}
 	case *RepoSet:
 		return &proto.Q{Query: &proto.Q_RepoSet{RepoSet: v.ToProto()}}
+	case *RepoMeta:
+		return &proto.Q{Query: &proto.Q_RepoMeta{RepoMeta: v.ToProto()}}
 	case *FileNameSet:
 		return &proto.Q{Query: &proto.Q_FileNameSet{FileNameSet: v.ToProto()}}
 	case *Type:
@@ -48,9 +50,26 @@ func QToProto(q Q) *proto.Q {
 		return &proto.Q{Query: &proto.Q_Branch{Branch: v.ToProto()}}
 	case *Boost:
 		return &proto.Q{Query: &proto.Q_Boost{Boost: v.ToProto()}}
+	case *SymbolKind:
+		return &proto.Q{Query: &proto.Q_SymbolKind{SymbolKind: v.ToProto()}}
+	case *Dependency:
+		return &proto.Q{Query: &proto.Q_Dependency{Dependency: v.ToProto()}}
+	case *FileMetric:
+		return &proto.Q{Query: &proto.Q_FileMetric{FileMetric: v.ToProto()}}
+	case *Custom:
+		return &proto.Q{Query: &proto.Q_Custom{Custom: v.ToProto()}}
+	case *Generated:
+		return &proto.Q{Query: &proto.Q_Generated{Generated: v.ToProto()}}
+	case *HasSecret:
+		return &proto.Q{Query: &proto.Q_HasSecret{HasSecret: v.ToProto()}}
+	case *SearchContext:
+		return &proto.Q{Query: &proto.Q_SearchContext{SearchContext: v.ToProto()}}
+	case *SameLine:
+		return &proto.Q{Query: &proto.Q_SameLine{SameLine: v.ToProto()}}
 	default:
 		// The following nodes do not have a proto representation:
 		// - caseQ: only used internally, not by the RPC layer
+		// - symbolKindQ: only used internally, not by the RPC layer
 		panic(fmt.Sprintf("unknown query node %T", v))
 	}
 }
@@ -77,6 +96,8 @@ func QFromProto(p *proto.Q) (Q, error) {
 		return RepoIDsFromProto(v.RepoIds)
 	case *proto.Q_RepoSet:
 		return RepoSetFromProto(v.RepoSet), nil
+	case *proto.Q_RepoMeta:
+		return RepoMetaFromProto(v.RepoMeta), nil
 	case *proto.Q_FileNameSet:
 		return FileNameSetFromProto(v.FileNameSet), nil
 	case *proto.Q_Type:
@@ -93,8 +114,24 @@ func QFromProto(p *proto.Q) (Q, error) {
 		return BranchFromProto(v.Branch), nil
 	case *proto.Q_Boost:
 		return BoostFromProto(v.Boost)
+	case *proto.Q_SymbolKind:
+		return SymbolKindFromProto(v.SymbolKind)
+	case *proto.Q_Dependency:
+		return DependencyFromProto(v.Dependency), nil
+	case *proto.Q_FileMetric:
+		return FileMetricFromProto(v.FileMetric)
+	case *proto.Q_Custom:
+		return CustomFromProto(v.Custom), nil
+	case *proto.Q_Generated:
+		return GeneratedFromProto(v.Generated), nil
+	case *proto.Q_HasSecret:
+		return HasSecretFromProto(v.HasSecret), nil
+	case *proto.Q_SearchContext:
+		return SearchContextFromProto(v.SearchContext), nil
+	case *proto.Q_SameLine:
+		return SameLineFromProto(v.SameLine)
 	default:
-		panic(fmt.Sprintf("unknown query node %T", p.Query))
+		return nil, fmt.Errorf("unknown query node %T", p.Query)
 	}
 }
 
@@ -137,6 +174,27 @@ func (s *Symbol) ToProto() *proto.Symbol {
 	}
 }
 
+func SymbolKindFromProto(p *proto.SymbolKind) (*SymbolKind, error) {
+	expr, err := QFromProto(p.GetExpr())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SymbolKind{
+		Kinds:   p.GetKinds(),
+		Exclude: p.GetExclude(),
+		Expr:    expr,
+	}, nil
+}
+
+func (q *SymbolKind) ToProto() *proto.SymbolKind {
+	return &proto.SymbolKind{
+		Kinds:   q.Kinds,
+		Exclude: q.Exclude,
+		Expr:    QToProto(q.Expr),
+	}
+}
+
 func LanguageFromProto(p *proto.Language) *Language {
 	return &Language{
 		Language: p.GetLanguage(),
@@ -262,6 +320,20 @@ func (q *RepoSet) ToProto() *proto.RepoSet {
 	}
 }
 
+func RepoMetaFromProto(p *proto.RepoMeta) *RepoMeta {
+	return &RepoMeta{
+		Key:   p.GetKey(),
+		Value: p.GetValue(),
+	}
+}
+
+func (q *RepoMeta) ToProto() *proto.RepoMeta {
+	return &proto.RepoMeta{
+		Key:   q.Key,
+		Value: q.Value,
+	}
+}
+
 func FileNameSetFromProto(p *proto.FileNameSet) *FileNameSet {
 	m := make(map[string]struct{}, len(p.GetSet()))
 	for _, name := range p.GetSet() {
@@ -296,6 +368,8 @@ func TypeFromProto(p *proto.Type) (*Type, error) {
 		kind = TypeFileName
 	case proto.Type_KIND_REPO:
 		kind = TypeRepo
+	case proto.Type_KIND_DIRECTORY:
+		kind = TypeDirectory
 	}
 
 	return &Type{
@@ -314,6 +388,8 @@ func (q *Type) ToProto() *proto.Type {
 		kind = proto.Type_KIND_FILE_NAME
 	case TypeRepo:
 		kind = proto.Type_KIND_REPO
+	case TypeDirectory:
+		kind = proto.Type_KIND_DIRECTORY
 	}
 
 	return &proto.Type{
@@ -478,3 +554,94 @@ func (r RawConfig) ToProto() *proto.RawConfig {
 	}
 	return &proto.RawConfig{Flags: flags}
 }
+
+func DependencyFromProto(p *proto.Dependency) *Dependency {
+	return &Dependency{
+		Name:    p.GetName(),
+		Version: p.GetVersion(),
+	}
+}
+
+func (q *Dependency) ToProto() *proto.Dependency {
+	return &proto.Dependency{
+		Name:    q.Name,
+		Version: q.Version,
+	}
+}
+
+func FileMetricFromProto(p *proto.FileMetric) (*FileMetric, error) {
+	q := &FileMetric{
+		Metric: p.GetMetric(),
+		Op:     p.GetOp(),
+		Value:  p.GetValue(),
+	}
+	return q, q.validate()
+}
+
+func (q *FileMetric) ToProto() *proto.FileMetric {
+	return &proto.FileMetric{
+		Metric: q.Metric,
+		Op:     q.Op,
+		Value:  q.Value,
+	}
+}
+
+func CustomFromProto(p *proto.Custom) *Custom {
+	return &Custom{
+		Name: p.GetName(),
+		Arg:  p.GetArg(),
+	}
+}
+
+func (q *Custom) ToProto() *proto.Custom {
+	return &proto.Custom{
+		Name: q.Name,
+		Arg:  q.Arg,
+	}
+}
+
+func GeneratedFromProto(p *proto.Generated) *Generated {
+	return &Generated{}
+}
+
+func (q *Generated) ToProto() *proto.Generated {
+	return &proto.Generated{}
+}
+
+func HasSecretFromProto(p *proto.HasSecret) *HasSecret {
+	return &HasSecret{}
+}
+
+func (q *HasSecret) ToProto() *proto.HasSecret {
+	return &proto.HasSecret{}
+}
+
+func SearchContextFromProto(p *proto.SearchContext) *SearchContext {
+	return &SearchContext{Name: p.GetName()}
+}
+
+func (q *SearchContext) ToProto() *proto.SearchContext {
+	return &proto.SearchContext{Name: q.Name}
+}
+
+func SameLineFromProto(p *proto.SameLine) (*SameLine, error) {
+	children := make([]Q, len(p.GetChildren()))
+	for i, child := range p.GetChildren() {
+		c, err := QFromProto(child)
+		if err != nil {
+			return nil, err
+		}
+		children[i] = c
+	}
+	return NewSameLine(children...)
+}
+
+func (q *SameLine) ToProto() *proto.SameLine {
+	children := make([]*proto.Q, len(q.Children))
+	for i, child := range q.Children {
+		children[i] = QToProto(child)
+	}
+	return &proto.SameLine{
+		Children: children,
+	}
+}
@@ -1,12 +1,18 @@
 package query
 
 import (
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"reflect"
 	"regexp/syntax"
 	"testing"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/google/go-cmp/cmp"
 	"github.com/grafana/regexp"
+
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 )
 
 func TestQueryRoundtrip(t *testing.T) {
@@ -22,6 +28,11 @@ func TestQueryRoundtrip(t *testing.T) {
 				Language: "go",
 			},
 		},
+		&SymbolKind{
+			Kinds:   []string{"function", "method"},
+			Exclude: true,
+			Expr:    &Symbol{Expr: &Substring{Pattern: "foo"}},
+		},
 		&Language{
 			Language: "typescript",
 		},
@@ -51,6 +62,18 @@ func TestQueryRoundtrip(t *testing.T) {
 			Exact:   true,
 		},
 		NewRepoSet("test1", "test2"),
+		&RepoMeta{Key: "team", Value: "payments"},
+		&Dependency{Name: "lodash", Version: "4.17.21"},
+		&FileMetric{Metric: "loc", Op: ">=", Value: 1000},
+		&Custom{Name: "semver", Arg: ">=1.2"},
+		&Not{Child: &Generated{}},
+		&HasSecret{},
+		&SearchContext{Name: "backend"},
+		&SameLine{Children: []Q{
+			&Substring{Pattern: "foo", Content: true},
+			&Not{Child: &Regexp{Regexp: regexpMustParse("ba+r"), Content: true}},
+		}},
+		RcOnlyPublic | RcNoForks,
 		NewFileNameSet("test3", "test4"),
 		&And{
 			Children: []Q{
@@ -89,6 +112,21 @@ func TestQueryRoundtrip(t *testing.T) {
 		},
 	}
 
+	// Every query node has a proto form, so the test cases must cover all
+	// of them.
+	covered := map[string]bool{}
+	for _, q := range testCases {
+		Map(q, func(q Q) Q {
+			covered[reflect.Indirect(reflect.ValueOf(q)).Type().Name()] = true
+			return q
+		})
+	}
+	for _, name := range queryNodes(t) {
+		if !covered[name] {
+			t.Errorf("no test case for %s", name)
+		}
+	}
+
 	for _, q := range testCases {
 		t.Run("", func(t *testing.T) {
 			protoQ := QToProto(q)
@@ -103,6 +141,46 @@ func TestQueryRoundtrip(t *testing.T) {
 	}
 }
 
+// queryNodes returns the names of the exported types of query.go which
+// implement Q.
+func queryNodes(t *testing.T) []string {
+	f, err := parser.ParseFile(gotoken.NewFileSet(), "query.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Name.Name != "String" {
+			continue
+		}
+		typ := fn.Recv.List[0].Type
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
+		}
+		if name := typ.(*ast.Ident).Name; ast.IsExported(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestQFromProtoUnknown(t *testing.T) {
+	if q, err := QFromProto(&proto.Q{}); err == nil {
+		t.Fatalf("got %v for an empty query, want error", q)
+	}
+}
+
+func TestSameLineFromProtoValidates(t *testing.T) {
+	// Servers validate SameLine like the parser does.
+	p := &proto.Q{Query: &proto.Q_SameLine{SameLine: &proto.SameLine{
+		Children: []*proto.Q{QToProto(&Language{Language: "go"})},
+	}}}
+	if q, err := QFromProto(p); err == nil {
+		t.Fatalf("got %s for a SameLine of a language, want error", q)
+	}
+}
+
 func regexpMustParse(s string) *syntax.Regexp {
 	re, err := syntax.Parse(s, syntax.Perl)
 	if err != nil {
@@ -114,6 +114,19 @@ func TestMap(t *testing.T) {
 	if !reflect.DeepEqual(got, out) {
 		t.Errorf("got %v, want %v", got, out)
 	}
+
+	// Map descends into the symbol query of sym.kind: filters.
+	in = &SymbolKind{Kinds: []string{"function"}, Expr: &Symbol{Expr: &Substring{Pattern: "foo"}}}
+	out = &SymbolKind{Kinds: []string{"function"}, Expr: &Const{false}}
+	got = Map(in, func(q Q) Q {
+		if _, ok := q.(*Symbol); ok {
+			return &Const{false}
+		}
+		return q
+	})
+	if !reflect.DeepEqual(got, out) {
+		t.Errorf("got %v, want %v", got, out)
+	}
 }
 
 func TestVisitAtoms(t *testing.T) {
@@ -178,3 +191,26 @@ func TestExpandFileContent(t *testing.T) {
 		})
 	}
 }
+
+func TestSymbolKindMatch(t *testing.T) {
+	cases := []struct {
+		q    *SymbolKind
+		kind string
+		want bool
+	}{
+		{q: &SymbolKind{Kinds: []string{"function"}}, kind: "function", want: true},
+		{q: &SymbolKind{Kinds: []string{"func"}}, kind: "function", want: true},
+		{q: &SymbolKind{Kinds: []string{"Func"}}, kind: "func", want: true},
+		{q: &SymbolKind{Kinds: []string{"func", "method"}}, kind: "method", want: true},
+		{q: &SymbolKind{Kinds: []string{"func"}}, kind: "constant", want: false},
+		{q: &SymbolKind{Kinds: []string{"const"}, Exclude: true}, kind: "constant", want: false},
+		{q: &SymbolKind{Kinds: []string{"const"}, Exclude: true}, kind: "field", want: true},
+		{q: &SymbolKind{Kinds: []string{"func"}}, kind: "", want: false},
+	}
+
+	for _, tt := range cases {
+		if got := tt.q.Match(tt.kind); got != tt.want {
+			t.Errorf("%s.Match(%q): got %v, want %v", tt.q, tt.kind, got, tt.want)
+		}
+	}
+}
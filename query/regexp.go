@@ -15,8 +15,12 @@
 package query
 
 import (
+	"fmt"
 	"log"
 	"regexp/syntax"
+	"strings"
+
+	"github.com/grafana/regexp"
 
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
 )
@@ -45,6 +49,30 @@ func LowerRegexp(r *syntax.Regexp) *syntax.Regexp {
 	return &newRE
 }
 
+// hasCaseSensitiveUpper returns true if r contains uppercase ASCII letters
+// outside of parts that are made case-insensitive with an inline (?i) flag.
+// It decides case:auto for regular expressions.
+func hasCaseSensitiveUpper(r *syntax.Regexp) bool {
+	switch r.Op {
+	case syntax.OpLiteral, syntax.OpCharClass:
+		if r.Flags&syntax.FoldCase != 0 {
+			return false
+		}
+		for _, c := range r.Rune {
+			if c >= 'A' && c <= 'Z' {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range r.Sub {
+		if hasCaseSensitiveUpper(s) {
+			return true
+		}
+	}
+	return false
+}
+
 // OptimizeRegexp converts capturing groups to non-capturing groups.
 // Returns original input if an error is encountered
 func OptimizeRegexp(re *syntax.Regexp, flags syntax.Flags) *syntax.Regexp {
@@ -104,3 +132,170 @@ func uncapture(r *syntax.Regexp) *syntax.Regexp {
 
 	return r
 }
+
+// lookarounds are the Perl lookaround groups, which RE2 does not support.
+var lookarounds = []string{"(?=", "(?!", "(?<=", "(?<!"}
+
+// rewriteLookarounds rewrites the lookarounds of the regular expression
+// text into plain regular expression syntax. It supports the common subset
+// of a literal lookahead at the end, and a literal lookbehind at the start
+// of the expression:
+//
+//	foo(?=bar)  => foobar
+//	foo(?!bar)  => foo(?:\z|[^b]|b(?:\z|[^a]|a(?:\z|[^r])))
+//	(?<=bar)foo => barfoo
+//	(?<!bar)foo => (?:\A|[^r]|(?:\A|[^a]|(?:\A|[^b])a)r)foo
+//
+// The rewritten expression matches the same lines, but the matches include
+// the text the lookarounds looked at. Other lookarounds are an error.
+func rewriteLookarounds(text string) (string, error) {
+	if _, _, ok := findLookaround(text); !ok {
+		return text, nil
+	}
+
+	// Keep leading flags like (?i), they apply to the lookarounds too.
+	flags := leadingFlags.FindString(text)
+	var before, after string
+	rest := text[len(flags):]
+
+	if start, end, ok := findLookaround(rest); ok && start == 0 && strings.HasPrefix(rest, "(?<") {
+		lit, err := lookaroundLiteral(rest[:end], len("(?<="))
+		if err != nil {
+			return "", err
+		}
+		if rest[3] == '=' {
+			before = quoteRunes(lit)
+		} else {
+			before = notEndingWith(lit)
+		}
+		rest = rest[end:]
+	}
+
+	if start, end, ok := findLookaround(rest); ok && end == len(rest) && !strings.HasPrefix(rest[start:], "(?<") {
+		lit, err := lookaroundLiteral(rest[start:], len("(?="))
+		if err != nil {
+			return "", err
+		}
+		if rest[start+2] == '=' {
+			after = quoteRunes(lit)
+		} else {
+			after = notStartingWith(lit)
+		}
+		rest = rest[:start]
+	}
+
+	if _, _, ok := findLookaround(rest); ok {
+		return "", fmt.Errorf("query: unsupported lookaround in %q: only literal lookaheads at the end and lookbehinds at the start of a regular expression are supported", text)
+	}
+	return flags + before + rest + after, nil
+}
+
+var leadingFlags = regexp.MustCompile(`^(?:\(\?[a-zA-Z]+\))*`)
+
+// findLookaround returns the position of the first lookaround group in
+// text, skipping escapes and character classes.
+func findLookaround(text string) (start, end int, ok bool) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			i = skipCharClass(text, i)
+		case '(':
+			for _, l := range lookarounds {
+				if strings.HasPrefix(text[i:], l) {
+					if end := closingParen(text, i); end > 0 {
+						return i, end, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// skipCharClass returns the position of the ] closing the class opened at
+// text[i].
+func skipCharClass(text string, i int) int {
+	i++
+	if i < len(text) && text[i] == '^' {
+		i++
+	}
+	if i < len(text) && text[i] == ']' {
+		i++
+	}
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+	return i
+}
+
+// closingParen returns the position after the ) closing the group opened
+// at text[i], or -1.
+func closingParen(text string, i int) int {
+	depth := 0
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			i = skipCharClass(text, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// lookaroundLiteral returns the literal a lookaround group looks for.
+func lookaroundLiteral(group string, prefix int) ([]rune, error) {
+	body := group[prefix : len(group)-1]
+	r, err := syntax.Parse(body, regexpFlags)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Simplify()
+	if r.Op != syntax.OpLiteral || r.Flags&syntax.FoldCase != 0 {
+		return nil, fmt.Errorf("query: unsupported lookaround %q: only literals are supported", group)
+	}
+	return r.Rune, nil
+}
+
+func quoteRunes(rs []rune) string {
+	var sb strings.Builder
+	for _, r := range rs {
+		fmt.Fprintf(&sb, `\x{%x}`, r)
+	}
+	return sb.String()
+}
+
+// notStartingWith returns an expression which matches the shortest text
+// which does not start with lit.
+func notStartingWith(lit []rune) string {
+	alt := fmt.Sprintf(`\z|[^\x{%x}]`, lit[0])
+	if len(lit) > 1 {
+		alt += "|" + quoteRunes(lit[:1]) + notStartingWith(lit[1:])
+	}
+	return "(?:" + alt + ")"
+}
+
+// notEndingWith returns an expression which matches the shortest text which
+// does not end with lit.
+func notEndingWith(lit []rune) string {
+	last := len(lit) - 1
+	alt := fmt.Sprintf(`\A|[^\x{%x}]`, lit[last])
+	if last > 0 {
+		alt += "|" + notEndingWith(lit[:last]) + quoteRunes(lit[last:])
+	}
+	return "(?:" + alt + ")"
+}
@@ -19,6 +19,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
 )
 
@@ -68,6 +69,24 @@ func TestLowerRegexp(t *testing.T) {
 	}
 }
 
+func TestHasCaseSensitiveUpper(t *testing.T) {
+	for in, want := range map[string]bool{
+		"foo":         false,
+		"Foo":         true,
+		"[A-Z]oo":     true,
+		"(?i)foo":     false,
+		"(?i)FOO":     false,
+		"(?i)[a-z]+":  false,
+		"foo(?i)bar":  false,
+		"Foo(?i)bar":  true,
+		"(?i:foo)Bar": true,
+	} {
+		if got := hasCaseSensitiveUpper(mustParseRE(in)); got != want {
+			t.Errorf("hasCaseSensitiveUpper(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
 func TestOptimize(t *testing.T) {
 	tests := []struct {
 		name string
@@ -101,3 +120,72 @@ func TestOptimize(t *testing.T) {
 		})
 	}
 }
+
+func TestRewriteLookarounds(t *testing.T) {
+	cases := []struct {
+		re      string
+		match   []string
+		noMatch []string
+	}{
+		{
+			re:      "foo(?!bar)",
+			match:   []string{"foo", "foob", "fooba", "foobaz", "foo bar", "foobar foo"},
+			noMatch: []string{"foobar", "bar"},
+		},
+		{
+			re:      "foo(?=bar)",
+			match:   []string{"foobar"},
+			noMatch: []string{"foo", "fooba"},
+		},
+		{
+			re:      "(?<!bar)foo",
+			match:   []string{"foo", "rfoo", "arfoo", "bazfoo", "barfoo foo"},
+			noMatch: []string{"barfoo", "bar"},
+		},
+		{
+			re:      "(?<=bar)foo",
+			match:   []string{"barfoo"},
+			noMatch: []string{"foo", "arfoo"},
+		},
+		{
+			re:      "(?i)(?<!a)b(?!c)",
+			match:   []string{"B", "xBx"},
+			noMatch: []string{"Ab", "bC"},
+		},
+		{
+			re:      `[(?!x)]foo\(?!x`,
+			match:   []string{"!foo(!x"},
+			noMatch: []string{"foo"},
+		},
+	}
+	for _, c := range cases {
+		text, err := rewriteLookarounds(c.re)
+		if err != nil {
+			t.Fatalf("%s: %v", c.re, err)
+		}
+		re := regexp.MustCompile(text)
+		for _, s := range c.match {
+			if !re.MatchString(s) {
+				t.Errorf("%s (%s) does not match %q", c.re, text, s)
+			}
+		}
+		for _, s := range c.noMatch {
+			if re.MatchString(s) {
+				t.Errorf("%s (%s) matches %q", c.re, text, s)
+			}
+		}
+	}
+
+	for _, re := range []string{
+		"foo(?!bar)baz",
+		"foo(?!bar)|baz",
+		"foo(?!ba+r)",
+		"foo(?<!bar)",
+		"(?=bar)foo",
+		"(?<!a)(?<!b)foo",
+	} {
+		if _, err := rewriteLookarounds(re); err == nil {
+			t.Errorf("%s: expected an error", re)
+		}
+	}
+}
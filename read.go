@@ -23,8 +23,13 @@ import (
 	"os"
 	"slices"
 	"sort"
+	"strings"
 
 	"github.com/rs/xid"
+
+	"github.com/sourcegraph/zoekt/internal/bloom"
+	"github.com/sourcegraph/zoekt/internal/filemetrics"
+	"github.com/sourcegraph/zoekt/internal/secrets"
 )
 
 // IndexFile is a file suitable for concurrent read access. For performance
@@ -124,6 +129,16 @@ func (r *reader) readTOCSections(toc *indexTOC, tags []string) error {
 
 			skipSection := len(tags) > 0 && !slices.Contains(tags, tag)
 			sec := secs[tag]
+			if sec == nil && sectionKind(kind) == sectionKindSimple && strings.HasPrefix(tag, backfillSectionPrefix) {
+				// Sections appended by Backfill are opaque to the reader, but we keep
+				// track of them so they can be looked up by name.
+				if toc.backfilled == nil {
+					toc.backfilled = map[string]*simpleSection{}
+				}
+				bs := &simpleSection{}
+				toc.backfilled[strings.TrimPrefix(tag, backfillSectionPrefix)] = bs
+				sec = bs
+			}
 			if sec == nil || sec.kind() != sectionKind(kind) {
 				// If we don't recognize the section, we may be reading a newer index than the current version. Use
 				// a "dummy section" struct to skip over it.
@@ -271,14 +286,28 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, fmt.Errorf("file is feature version %d, want feature version >= %d", d.metaData.IndexFeatureVersion, ReadMinFeatureVersion)
 	}
 
-	if d.metaData.IndexMinReaderVersion > FeatureVersion {
-		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, FeatureVersion)
+	if d.metaData.IndexMinReaderVersion > ReadFeatureVersion {
+		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, ReadFeatureVersion)
+	}
+
+	if n := d.metaData.NgramSize; n != 0 && (n < minNgramSize || n > maxNgramSize) {
+		return nil, fmt.Errorf("file has unsupported ngram size %d", n)
 	}
 
 	d.boundariesStart = toc.fileContents.data.off
 	d.boundaries = toc.fileContents.relativeIndex()
 	d.newlinesStart = toc.newlines.data.off
 	d.newlinesIndex = toc.newlines.relativeIndex()
+	d.dependenciesStart = toc.dependencies.data.off
+	d.dependenciesIndex = toc.dependencies.relativeIndex()
+	d.secretsStart = toc.secrets.data.off
+	d.secretsIndex = toc.secrets.relativeIndex()
+	d.encodingsStart = toc.encodings.data.off
+	d.encodingsIndex = toc.encodings.relativeIndex()
+	d.fileMetrics = toc.fileMetrics
+	if n := uint32(len(d.boundaries)) - 1; d.fileMetrics.sz != 0 && d.fileMetrics.sz != n*filemetrics.EncodedSize {
+		return nil, fmt.Errorf("fileMetrics section has size %d, want %d for %d documents", d.fileMetrics.sz, n*filemetrics.EncodedSize, n)
+	}
 	d.docSectionsStart = toc.fileSections.data.off
 	d.docSectionsIndex = toc.fileSections.relativeIndex()
 
@@ -311,6 +340,30 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	if toc.generated.sz > 0 {
+		d.generated, err = d.readSectionBlob(toc.generated)
+		if err != nil {
+			return nil, err
+		}
+		if n := uint32(len(d.boundaries)) - 1; uint32(len(d.generated)) != (n+7)/8 {
+			return nil, fmt.Errorf("generated section has size %d, want %d for %d documents", len(d.generated), (n+7)/8, n)
+		}
+	}
+
+	if toc.contentGramBloom.sz > 0 {
+		blob, err := d.readSectionBlob(toc.contentGramBloom)
+		if err != nil {
+			return nil, err
+		}
+		if d.contentBloom, err = bloom.Decode(blob); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = d.readStats(toc.planStats); err != nil {
+		return nil, err
+	}
+
 	d.contentNgrams, err = d.newBtreeIndex(toc.ngramText, toc.postings)
 	if err != nil {
 		return nil, err
@@ -536,6 +589,57 @@ func (d *indexData) readNewlines(i uint32, buf []uint32) ([]uint32, uint32, erro
 	return fromSizedDeltas(blob, buf), sec.sz, nil
 }
 
+// readDependencies returns the encoded dependencies of document i, see
+// lockfile.Encode.
+func (d *indexData) readDependencies(i uint32) ([]byte, error) {
+	if len(d.dependenciesIndex) == 0 {
+		return nil, nil
+	}
+	return d.readSectionBlob(simpleSection{
+		off: d.dependenciesStart + d.dependenciesIndex[i],
+		sz:  d.dependenciesIndex[i+1] - d.dependenciesIndex[i],
+	})
+}
+
+// readSecrets returns the possible secrets of document i. The shard must have
+// secrets.
+func (d *indexData) readSecrets(i uint32) ([]secrets.Finding, error) {
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.secretsStart + d.secretsIndex[i],
+		sz:  d.secretsIndex[i+1] - d.secretsIndex[i],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Decode(blob)
+}
+
+// readEncoding returns the name of the encoding document i was transcoded
+// from, or "" if it was UTF-8.
+func (d *indexData) readEncoding(i uint32) (string, error) {
+	if len(d.encodingsIndex) == 0 {
+		return "", nil
+	}
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.encodingsStart + d.encodingsIndex[i],
+		sz:  d.encodingsIndex[i+1] - d.encodingsIndex[i],
+	})
+	return string(blob), err
+}
+
+// readFileMetrics returns the metrics of document i. The shard must have file
+// metrics.
+func (d *indexData) readFileMetrics(i uint32) (filemetrics.Metrics, error) {
+	blob, err := d.readSectionBlob(simpleSection{
+		off: d.fileMetrics.off + i*filemetrics.EncodedSize,
+		sz:  filemetrics.EncodedSize,
+	})
+	if err != nil {
+		return filemetrics.Metrics{}, err
+	}
+	return filemetrics.Decode(blob)
+}
+
 func (d *indexData) readDocSections(i uint32, buf []DocumentSection) ([]DocumentSection, uint32, error) {
 	sec := simpleSection{
 		off: d.docSectionsStart + d.docSectionsIndex[i],
@@ -568,6 +672,9 @@ func NewSearcher(r IndexFile) (Searcher, error) {
 	if err := rd.readTOC(&toc); err != nil {
 		return nil, err
 	}
+	if a, ok := r.(sectionAdviser); ok {
+		toc.adviseSections(a)
+	}
 	indexData, err := rd.readIndexData(&toc)
 	if err != nil {
 		return nil, err
@@ -664,10 +771,9 @@ func PrintNgramStats(r IndexFile) error {
 		return err
 	}
 
-	var rNgram [3]rune
+	size := id.shardNgramSize()
 	for ngram, ss := range id.contentNgrams.DumpMap() {
-		rNgram = ngramToRunes(ngram)
-		fmt.Printf("%d\t%q\n", ss.sz, string(rNgram[:]))
+		fmt.Printf("%d\t%q\n", ss.sz, ngram.format(size))
 	}
 	return nil
 }
@@ -0,0 +1,125 @@
+package zoekt
+
+import (
+	"strings"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// EvalRepoFilters evaluates the repository level atoms of q, like repo: and
+// branch:, against repos, wherever they appear in q. An atom becomes true if
+// it matches all documents of repos, false if it matches none of them, and is
+// kept otherwise. The result is simplified, so it is false if no document of
+// repos can match q, and the shard holding repos can be skipped.
+//
+// Tombstoned repositories are ignored.
+func EvalRepoFilters(q query.Q, repos []*Repository) query.Q {
+	return query.Simplify(query.Map(q, func(q query.Q) query.Q {
+		filter := newRepoFilter(q)
+		if filter == nil {
+			return q
+		}
+		return evalRepoFilter(q, filter, func(visit func(*Repository)) {
+			for _, repo := range repos {
+				visit(repo)
+			}
+		})
+	}))
+}
+
+// HasRepoFilters returns true if q has atoms which EvalRepoFilters
+// evaluates.
+func HasRepoFilters(q query.Q) bool {
+	has := false
+	query.VisitAtoms(q, func(q query.Q) {
+		has = has || newRepoFilter(q) != nil
+	})
+	return has
+}
+
+// repoFilter decides a repository level atom for the documents of repo. some
+// is true if the atom matches some of them, and all if it matches all of
+// them.
+type repoFilter func(repo *Repository) (some, all bool)
+
+// newRepoFilter returns the repoFilter of q, or nil if q does not only depend
+// on the repository of a document.
+func newRepoFilter(q query.Q) repoFilter {
+	byRepo := func(match func(repo *Repository) bool) repoFilter {
+		return func(repo *Repository) (bool, bool) {
+			m := match(repo)
+			return m, m
+		}
+	}
+
+	switch s := q.(type) {
+	case *query.Repo:
+		return byRepo(func(repo *Repository) bool { return s.Regexp.MatchString(repo.Name) })
+	case *query.RepoRegexp:
+		return byRepo(func(repo *Repository) bool { return s.Regexp.MatchString(repo.Name) })
+	case *query.RepoSet:
+		return byRepo(func(repo *Repository) bool { return s.Set[repo.Name] })
+	case *query.RepoIDs:
+		return byRepo(func(repo *Repository) bool { return s.Repos.Contains(repo.ID) })
+	case *query.RepoMeta:
+		return byRepo(func(repo *Repository) bool { return s.Match(repo.Metadata) })
+	case query.RawConfig:
+		return byRepo(func(repo *Repository) bool { return uint8(s)&encodeRawConfig(repo.RawConfig) == uint8(s) })
+
+	// Documents need not be on any branch, so branch atoms never match all
+	// documents.
+	case *query.Branch:
+		if s.Pattern == "HEAD" {
+			// Like the matchtree, we assume every repository has a HEAD,
+			// even if its branches aren't listed.
+			return func(*Repository) (bool, bool) { return true, false }
+		}
+		return branchFilter(func(repo *Repository, i int) bool {
+			name := repo.Branches[i].Name
+			return (s.Exact && name == s.Pattern) || (!s.Exact && strings.Contains(name, s.Pattern))
+		})
+	case *query.BranchesRepos:
+		return func(repo *Repository) (bool, bool) {
+			for _, br := range s.List {
+				if br.Repos.Contains(repo.ID) {
+					return true, false
+				}
+			}
+			return false, false
+		}
+	}
+	return nil
+}
+
+func branchFilter(match func(repo *Repository, i int) bool) repoFilter {
+	return func(repo *Repository) (some, all bool) {
+		for i := range repo.Branches {
+			if match(repo, i) {
+				return true, false
+			}
+		}
+		return false, false
+	}
+}
+
+// evalRepoFilter returns true if filter matches all documents of the
+// repositories visited by each, false if it matches none of them, and q
+// otherwise.
+func evalRepoFilter(q query.Q, filter repoFilter, each func(visit func(*Repository))) query.Q {
+	some, all := false, true
+	each(func(repo *Repository) {
+		if repo.Tombstone {
+			return
+		}
+		s, a := filter(repo)
+		some = some || s
+		all = all && a
+	})
+	if all {
+		return &query.Const{Value: true}
+	}
+	if some {
+		return q
+	}
+	return &query.Const{Value: false}
+}
@@ -0,0 +1,283 @@
+// Package sarif converts search results to the Static Analysis Results
+// Interchange Format (SARIF) 2.1.0, so that matches of a query, eg. for a
+// known-bad pattern, can be uploaded to GitHub code scanning and other SARIF
+// consumers.
+//
+// Every repository with results becomes a run, so that artifact URIs are
+// relative to the root of their repository. Every match becomes a result of a
+// single rule describing the query.
+//
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+package sarif
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+const (
+	// Version and Schema identify the SARIF version of Log.
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// DefaultRuleID is the rule of the results if Options.RuleID is empty.
+	DefaultRuleID = "zoekt-query"
+
+	// fingerprintKey versions the partial fingerprints of results.
+	fingerprintKey = "zoekt/v1"
+)
+
+// Options configures the SARIF log.
+type Options struct {
+	// Query is the query which found the results. It is the description of
+	// the rule.
+	Query string
+
+	// RuleID identifies the rule, eg. "hardcoded-aws-key". Defaults to
+	// DefaultRuleID.
+	RuleID string
+
+	// Level is the SARIF level of the results: "error", "warning" (the
+	// default) or "note".
+	Level string
+
+	// Message is the text of every result. Defaults to a message naming the
+	// query.
+	Message string
+
+	// Repositories adds version control details of the repositories of the
+	// results to their runs, if available.
+	Repositories []*zoekt.Repository
+}
+
+// Log is a SARIF log.
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run holds the results of a repository.
+type Run struct {
+	Tool                     Tool                    `json:"tool"`
+	ColumnKind               string                  `json:"columnKind"`
+	VersionControlProvenance []VersionControlDetails `json:"versionControlProvenance,omitempty"`
+	Properties               map[string]any          `json:"properties,omitempty"`
+	Results                  []Result                `json:"results"`
+}
+
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+type Driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri"`
+	Rules          []Rule `json:"rules"`
+}
+
+type Rule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     Message            `json:"shortDescription"`
+	DefaultConfiguration *RuleConfiguration `json:"defaultConfiguration,omitempty"`
+}
+
+type RuleConfiguration struct {
+	Level string `json:"level"`
+}
+
+type VersionControlDetails struct {
+	RepositoryURI string `json:"repositoryUri"`
+	RevisionID    string `json:"revisionId,omitempty"`
+	Branch        string `json:"branch,omitempty"`
+}
+
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Result is a match of the query.
+type Result struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level,omitempty"`
+	Message             Message           `json:"message"`
+	Locations           []Location        `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           Region           `json:"region"`
+}
+
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// Region is a range of text. Columns count unicode code points, as declared
+// by the columnKind of the run.
+type Region struct {
+	StartLine   int      `json:"startLine"`
+	StartColumn int      `json:"startColumn"`
+	EndLine     int      `json:"endLine"`
+	EndColumn   int      `json:"endColumn"`
+	Snippet     *Message `json:"snippet,omitempty"`
+}
+
+// FromSearchResult converts the matches of sr to a SARIF log. It uses the
+// chunk matches of files, or their line matches if the search didn't ask for
+// chunk matches. Matches of file names are skipped, since they have no
+// region.
+func FromSearchResult(sr *zoekt.SearchResult, opts Options) *Log {
+	ruleID := opts.RuleID
+	if ruleID == "" {
+		ruleID = DefaultRuleID
+	}
+	level := opts.Level
+	if level == "" {
+		level = "warning"
+	}
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Match of %q", opts.Query)
+	}
+
+	repos := map[string]*zoekt.Repository{}
+	for _, r := range opts.Repositories {
+		repos[r.Name] = r
+	}
+
+	var names []string
+	results := map[string][]Result{}
+	// seen counts fingerprints, to tell apart identical matches in a file.
+	seen := map[string]int{}
+	for _, f := range sr.Files {
+		if _, ok := results[f.Repository]; !ok {
+			names = append(names, f.Repository)
+			results[f.Repository] = []Result{}
+		}
+		for _, region := range regions(&f) {
+			fp := fingerprint(ruleID, f.Repository, f.FileName, region.Snippet)
+			seen[fp]++
+			results[f.Repository] = append(results[f.Repository], Result{
+				RuleID:  ruleID,
+				Level:   level,
+				Message: Message{Text: message},
+				Locations: []Location{{PhysicalLocation: PhysicalLocation{
+					ArtifactLocation: ArtifactLocation{URI: f.FileName},
+					Region:           region,
+				}}},
+				PartialFingerprints: map[string]string{
+					fingerprintKey: fmt.Sprintf("%s:%d", fp, seen[fp]),
+				},
+			})
+		}
+	}
+	sort.Strings(names)
+
+	log := &Log{Schema: Schema, Version: Version, Runs: []Run{}}
+	for _, name := range names {
+		run := Run{
+			Tool: Tool{Driver: Driver{
+				Name:           "zoekt",
+				InformationURI: "https://github.com/sourcegraph/zoekt",
+				Rules: []Rule{{
+					ID:                   ruleID,
+					ShortDescription:     Message{Text: opts.Query},
+					DefaultConfiguration: &RuleConfiguration{Level: level},
+				}},
+			}},
+			ColumnKind: "unicodeCodePoints",
+			Properties: map[string]any{"repository": name},
+			Results:    results[name],
+		}
+		if r, ok := repos[name]; ok && r.URL != "" {
+			vcs := VersionControlDetails{RepositoryURI: r.URL}
+			if len(r.Branches) > 0 {
+				vcs.Branch = r.Branches[0].Name
+				vcs.RevisionID = r.Branches[0].Version
+			}
+			run.VersionControlProvenance = []VersionControlDetails{vcs}
+		}
+		log.Runs = append(log.Runs, run)
+	}
+	return log
+}
+
+// Write writes the SARIF log of sr to w.
+func Write(w io.Writer, sr *zoekt.SearchResult, opts Options) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(FromSearchResult(sr, opts))
+}
+
+// regions returns the regions of the content matches of f.
+func regions(f *zoekt.FileMatch) []Region {
+	var out []Region
+	for _, c := range f.ChunkMatches {
+		if c.FileName {
+			continue
+		}
+		for _, r := range c.Ranges {
+			out = append(out, Region{
+				StartLine:   int(r.Start.LineNumber),
+				StartColumn: int(r.Start.Column),
+				EndLine:     int(r.End.LineNumber),
+				EndColumn:   int(r.End.Column),
+				Snippet:     snippet(c.Content, int(r.Start.ByteOffset)-int(c.ContentStart.ByteOffset), int(r.End.ByteOffset)-int(c.ContentStart.ByteOffset)),
+			})
+		}
+	}
+	for _, l := range f.LineMatches {
+		if l.FileName {
+			continue
+		}
+		for _, m := range l.LineFragments {
+			start, end := m.LineOffset, m.LineOffset+m.MatchLength
+			if start < 0 || end > len(l.Line) {
+				continue
+			}
+			out = append(out, Region{
+				StartLine:   l.LineNumber,
+				StartColumn: utf8.RuneCount(l.Line[:start]) + 1,
+				EndLine:     l.LineNumber,
+				EndColumn:   utf8.RuneCount(l.Line[:end]) + 1,
+				Snippet:     snippet(l.Line, start, end),
+			})
+		}
+	}
+	return out
+}
+
+// snippet returns content[start:end] as message, if it is valid text.
+func snippet(content []byte, start, end int) *Message {
+	if start < 0 || end > len(content) || start > end || !utf8.Valid(content[start:end]) {
+		return nil
+	}
+	return &Message{Text: string(content[start:end])}
+}
+
+// fingerprint identifies a result independently of its line, so consumers
+// can track results across commits which move them.
+func fingerprint(ruleID, repo, path string, snippet *Message) string {
+	h := sha256.New()
+	for _, s := range []string{ruleID, repo, path} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	if snippet != nil {
+		h.Write([]byte(snippet.Text))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
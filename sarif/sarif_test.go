@@ -0,0 +1,104 @@
+package sarif
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestFromSearchResult(t *testing.T) {
+	sr := &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{
+			Repository: "github.com/foo/bar",
+			FileName:   "main.go",
+			ChunkMatches: []zoekt.ChunkMatch{{
+				FileName: true,
+				Content:  []byte("main.go"),
+				Ranges:   []zoekt.Range{{Start: zoekt.Location{LineNumber: 1, Column: 1}, End: zoekt.Location{LineNumber: 1, Column: 5}}},
+			}, {
+				// "é" is two bytes, but one column.
+				Content:      []byte("// é secret\nsecret := 1\n"),
+				ContentStart: zoekt.Location{ByteOffset: 100, LineNumber: 10, Column: 1},
+				Ranges: []zoekt.Range{{
+					Start: zoekt.Location{ByteOffset: 106, LineNumber: 10, Column: 6},
+					End:   zoekt.Location{ByteOffset: 112, LineNumber: 10, Column: 12},
+				}, {
+					Start: zoekt.Location{ByteOffset: 113, LineNumber: 11, Column: 1},
+					End:   zoekt.Location{ByteOffset: 119, LineNumber: 11, Column: 7},
+				}},
+			}},
+		}, {
+			Repository: "github.com/baz/qux",
+			FileName:   "README.md",
+			LineMatches: []zoekt.LineMatch{{
+				Line:          []byte("é secret"),
+				LineNumber:    3,
+				LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 3, MatchLength: 6}},
+			}},
+		}},
+	}
+
+	log := FromSearchResult(sr, Options{
+		Query: "secret",
+		Level: "error",
+		Repositories: []*zoekt.Repository{{
+			Name:     "github.com/foo/bar",
+			URL:      "https://github.com/foo/bar",
+			Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "abc"}},
+		}},
+	})
+
+	if len(log.Runs) != 2 {
+		t.Fatalf("got %d runs, want one per repository", len(log.Runs))
+	}
+
+	// Runs are sorted by repository.
+	qux, bar := log.Runs[0], log.Runs[1]
+	if qux.Properties["repository"] != "github.com/baz/qux" || qux.VersionControlProvenance != nil {
+		t.Fatalf("unexpected run %+v", qux)
+	}
+	if want := []VersionControlDetails{{RepositoryURI: "https://github.com/foo/bar", RevisionID: "abc", Branch: "main"}}; !cmp.Equal(bar.VersionControlProvenance, want) {
+		t.Fatalf("got provenance %+v, want %+v", bar.VersionControlProvenance, want)
+	}
+	if rule := bar.Tool.Driver.Rules[0]; rule.ID != DefaultRuleID || rule.ShortDescription.Text != "secret" || rule.DefaultConfiguration.Level != "error" {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+
+	var regions []Region
+	for _, r := range append(bar.Results, qux.Results...) {
+		if r.Level != "error" || len(r.Locations) != 1 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+		regions = append(regions, r.Locations[0].PhysicalLocation.Region)
+	}
+	snippet := &Message{Text: "secret"}
+	want := []Region{
+		{StartLine: 10, StartColumn: 6, EndLine: 10, EndColumn: 12, Snippet: snippet},
+		{StartLine: 11, StartColumn: 1, EndLine: 11, EndColumn: 7, Snippet: snippet},
+		{StartLine: 3, StartColumn: 3, EndLine: 3, EndColumn: 9, Snippet: snippet},
+	}
+	if diff := cmp.Diff(want, regions); diff != "" {
+		t.Fatalf("regions mismatch (-want +got):\n%s", diff)
+	}
+
+	// Identical matches in a file have distinct fingerprints.
+	if a, b := bar.Results[0].PartialFingerprints[fingerprintKey], bar.Results[1].PartialFingerprints[fingerprintKey]; a == b {
+		t.Fatalf("got identical fingerprints %q", a)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, sr, Options{Query: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["version"] != Version || decoded["$schema"] != Schema {
+		t.Fatalf("unexpected header %v", decoded)
+	}
+}
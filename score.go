@@ -84,6 +84,10 @@ func (d *indexData) scoreFile(fileMatch *FileMatch, doc uint32, mt matchTree, kn
 	// the matches.
 	addScore("fragment", maxFileScore)
 
+	if d.isGenerated(doc) {
+		addScore("generated", -scoreGeneratedPenalty)
+	}
+
 	// Add tiebreakers
 	//
 	// ScoreOffset shifts the score 7 digits to the left.
@@ -159,6 +163,10 @@ func (d *indexData) scoreFilesUsingBM25(fileMatches []FileMatch, tfs []termFrequ
 			score += idf(df[term], int(d.numDocs())) * tfScore
 		}
 
+		if d.isGenerated(doc) {
+			score *= bm25GeneratedFactor
+		}
+
 		fileMatches[i].Score = score
 
 		if opts.DebugScore {
@@ -16,6 +16,7 @@ package zoekt
 
 import (
 	"encoding/binary"
+	"hash/crc32"
 	"io"
 	"log"
 )
@@ -27,6 +28,12 @@ type writer struct {
 	err error
 	w   io.Writer
 	off uint32
+
+	// crc is the checksum of the data written since the last section start.
+	// sums holds the checksums of the sections written so far, see
+	// encodeSectionChecksums.
+	crc  uint32
+	sums map[simpleSection]uint32
 }
 
 func (w *writer) Write(b []byte) (int, error) {
@@ -37,6 +44,7 @@ func (w *writer) Write(b []byte) (int, error) {
 	var n int
 	n, w.err = w.w.Write(b)
 	w.off += uint32(n)
+	w.crc = crc32.Update(w.crc, castagnoliTable, b[:n])
 	return n, w.err
 }
 
@@ -73,10 +81,15 @@ func (w *writer) String(s string) {
 
 func (s *simpleSection) start(w *writer) {
 	s.off = w.Off()
+	w.crc = 0
 }
 
 func (s *simpleSection) end(w *writer) {
 	s.sz = w.Off() - s.off
+	if w.sums == nil {
+		w.sums = map[simpleSection]uint32{}
+	}
+	w.sums[*s] = w.crc
 }
 
 // section is a range of bytes in the index file.
@@ -43,10 +43,14 @@ func (c *collectSender) Send(r *zoekt.SearchResult) {
 
 	c.aggregate.Stats.Add(r.Stats)
 
-	if len(r.Files) > 0 {
-		c.aggregate.Files = append(c.aggregate.Files, r.Files...)
-
-		c.aggregate.Files = zoekt.SortAndTruncateFiles(c.aggregate.Files, c.opts)
+	if len(r.Files) > 0 || len(r.Directories) > 0 {
+		if len(r.Files) > 0 {
+			c.aggregate.Files = append(c.aggregate.Files, r.Files...)
+			c.aggregate.Files = zoekt.SortAndTruncateFiles(c.aggregate.Files, c.opts)
+		}
+		if len(r.Directories) > 0 {
+			c.aggregate.Directories = zoekt.MergeDirectories(append(c.aggregate.Directories, r.Directories...))
+		}
 
 		for k, v := range r.RepoURLs {
 			c.aggregate.RepoURLs[k] = v
@@ -111,7 +115,10 @@ func newFlushCollectSender(opts *zoekt.SearchOptions, sender zoekt.Sender) (zoek
 
 		if agg, ok := collectSender.Done(); ok {
 			metricFinalAggregateSize.WithLabelValues(reason.String()).Observe(float64(len(agg.Files)))
-			agg.FlushReason = reason
+			// Keep the reason the search was aborted for, if any.
+			if !agg.FlushReason.Partial() {
+				agg.FlushReason = reason
+			}
 			sender.Send(agg)
 		}
 
@@ -161,6 +168,15 @@ func limitSender(cancel context.CancelFunc, sender zoekt.Sender, truncator zoekt
 	})
 }
 
+// highlightSender highlights the files of the results it sends. It comes
+// after limitSender, so only the files we return are tokenized.
+func highlightSender(sender zoekt.Sender) zoekt.Sender {
+	return zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		zoekt.HighlightFiles(result.Files)
+		sender.Send(result)
+	})
+}
+
 func copyFileSender(sender zoekt.Sender) zoekt.Sender {
 	return zoekt.SenderFunc(func(result *zoekt.SearchResult) {
 		copyFiles(result)
@@ -0,0 +1,71 @@
+package shards
+
+import (
+	"context"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Authz decides which repositories a request may see. Integrators implement
+// it to enforce per-user repository permissions inside zoekt, instead of
+// post-filtering results which may be truncated by search limits.
+type Authz interface {
+	// FilterRepos returns the subset of repos the caller of ctx may search.
+	// It is called once per Search, StreamSearch and List request with the
+	// IDs of all repositories of the shards the request could search.
+	FilterRepos(ctx context.Context, repos []uint32) []uint32
+}
+
+// authorize restricts a request to the repositories authz allows. It drops
+// the shards without allowed repositories before they are scheduled. If a
+// remaining shard also contains repositories which are not allowed, the
+// returned query excludes their documents.
+//
+// Shards whose repositories we don't know are dropped, since we can't ask
+// authz about them.
+func authorize(ctx context.Context, authz Authz, shards []*rankedShard, q query.Q) ([]*rankedShard, query.Q) {
+	if authz == nil {
+		return shards, q
+	}
+
+	var ids []uint32
+	seen := roaring.New()
+	for _, s := range shards {
+		for _, r := range s.repos {
+			if seen.CheckedAdd(r.ID) {
+				ids = append(ids, r.ID)
+			}
+		}
+	}
+
+	allowed := roaring.BitmapOf(authz.FilterRepos(ctx, ids)...)
+
+	filtered := make([]*rankedShard, 0, len(shards))
+	filteredAll := true
+	for _, s := range shards {
+		any, all := false, true
+		for _, r := range s.repos {
+			ok := allowed.Contains(r.ID)
+			any = any || ok
+			all = all && ok
+		}
+		if any {
+			filtered = append(filtered, s)
+			filteredAll = filteredAll && all
+		}
+	}
+
+	if !filteredAll {
+		// Append, rather than prepend, so that selectRepoSet still uses the
+		// repository filters of the caller to select shards.
+		restrict := &query.RepoIDs{Repos: allowed}
+		if and, ok := q.(*query.And); ok {
+			q = query.NewAnd(append(append([]query.Q{}, and.Children...), restrict)...)
+		} else {
+			q = query.NewAnd(q, restrict)
+		}
+	}
+	return filtered, q
+}
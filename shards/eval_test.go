@@ -3,6 +3,8 @@ package shards
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"testing"
 
 	"github.com/sourcegraph/zoekt"
@@ -116,3 +118,62 @@ func TestSearchTypeRepo(t *testing.T) {
 		&query.Substring{Pattern: "file"}))
 	wantSingleMatch(res, "f2:8")
 }
+
+func TestSearchPredicates(t *testing.T) {
+	ss := newShardedSearcher(2)
+	for i, repo := range []struct {
+		name string
+		docs []zoekt.Document
+	}{
+		{"gomodule", []zoekt.Document{
+			{Name: "go.mod", Content: []byte("module example.com/gomodule")},
+			{Name: "main.go", Content: []byte("package main // TODO: needle")},
+		}},
+		{"scripts", []zoekt.Document{
+			{Name: "main.py", Content: []byte("import needle")},
+			{Name: "gomod.txt", Content: []byte("not a go.mod")},
+		}},
+	} {
+		b := testIndexBuilder(t, &zoekt.Repository{ID: uint32(i + 1), Name: repo.name}, repo.docs...)
+		ss.replace(map[string]zoekt.Searcher{repo.name: searcherForTest(t, b)})
+	}
+	searcher := &typeRepoSearcher{ss}
+
+	search := func(q string) []string {
+		t.Helper()
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		var files []string
+		for _, f := range res.Files {
+			for _, lm := range f.LineMatches {
+				for _, frag := range lm.LineFragments {
+					files = append(files, fmt.Sprintf("%s/%s:%d", f.Repository, f.FileName, frag.LineOffset))
+				}
+			}
+		}
+		sort.Strings(files)
+		return files
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{`needle repo:has.file(^go\.mod$)`, []string{"gomodule/main.go:22"}},
+		{`needle repohasfile:^go\.mod$`, []string{"gomodule/main.go:22"}},
+		{`needle -repo:has.file(^go\.mod$)`, []string{"scripts/main.py:7"}},
+		// Only the matches of needle are returned, not those of TODO.
+		{`needle file:has.content(TODO)`, []string{"gomodule/main.go:22"}},
+		{`needle -file:has.content(TODO)`, []string{"scripts/main.py:7"}},
+	} {
+		if got := search(tc.q); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package shards
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricResultMemoryBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_search_result_memory_bytes",
+		Help: "The estimated memory held by the results of running searches",
+	})
+	metricResultMemoryExceededTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_result_memory_exceeded_total",
+		Help: "The total number of searches which returned partial results because the results of running searches reached the memory limit",
+	})
+)
+
+// resultMemory accounts for the memory held by the results of all running
+// searches, see SearcherOptions.MaxResultBytes. A search reserves the size of
+// each shard result before passing it on, and releases its reservations once
+// it is done. A nil *resultMemory has no limit.
+type resultMemory struct {
+	limit int64
+	used  atomic.Int64
+}
+
+func newResultMemory(limit int64) *resultMemory {
+	if limit <= 0 {
+		return nil
+	}
+	return &resultMemory{limit: limit}
+}
+
+// reserve reserves n bytes. It returns false and reserves nothing if that
+// would exceed the limit.
+func (m *resultMemory) reserve(n int64) bool {
+	if m == nil {
+		return true
+	}
+	for {
+		used := m.used.Load()
+		if used+n > m.limit {
+			return false
+		}
+		if m.used.CompareAndSwap(used, used+n) {
+			metricResultMemoryBytes.Add(float64(n))
+			return true
+		}
+	}
+}
+
+// release releases n reserved bytes.
+func (m *resultMemory) release(n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.used.Add(-n)
+	metricResultMemoryBytes.Sub(float64(n))
+}
@@ -30,6 +30,11 @@ type scheduler interface {
 //
 //	disable: setting disable=1 will use the old zoekt scheduler.
 //
+//	multi: setting multi=1 will use multiScheduler instead of fairScheduler.
+//
+//	quantumms: setting quantumms=X will make searches yield to waiting
+//	searches every Xms. By default it is 10. Only used by fairScheduler.
+//
 //	batchdiv: settings batchDiv=X will make the batch queue size 1/X of the
 //	interactive queue size. By default it is 4.
 //
@@ -43,8 +48,8 @@ type scheduler interface {
 var zoektSched = parseTuneables(os.Getenv("ZOEKTSCHED"))
 
 // newScheduler returns a scheduler for use in searches. It will return a
-// multiScheduler unless that has been disabled with the environment variable
-// SCHED_DISABLE. If so it will an equivalent scheduler as upstream zoekt.
+// fairScheduler unless ZOEKTSCHED asks for the multiScheduler or for an
+// equivalent scheduler as upstream zoekt.
 func newScheduler(capacity int64) scheduler {
 	if zoektSched["disable"] == 1 {
 		log.Println("ZOEKTSCHED=disable=1 specified. Using old zoekt scheduler.")
@@ -53,7 +58,11 @@ func newScheduler(capacity int64) scheduler {
 			capacity: capacity,
 		}
 	}
-	return newMultiScheduler(capacity)
+	if zoektSched["multi"] == 1 {
+		log.Println("ZOEKTSCHED=multi=1 specified. Using multi scheduler.")
+		return newMultiScheduler(capacity)
+	}
+	return newFairScheduler(capacity)
 }
 
 // multiScheduler is for managing concurrent searches. Its goals are:
@@ -207,8 +216,9 @@ func (s *semaphoreScheduler) acquire(ctx context.Context, weight int64) (*proces
 type process struct {
 	// yieldTimer ensures we only call yieldFunc once after a deadline.
 	yieldTimer *deadlineTimer
-	// yieldFunc is called once by Yield.
+	// yieldFunc is called once by Yield, or every quantum if quantum is set.
 	yieldFunc func(context.Context) error
+	quantum   time.Duration
 
 	// releaseFunc is called once by Release
 	releaseFunc func()
@@ -246,9 +256,12 @@ func (p *process) Yield(ctx context.Context) error {
 	}
 
 	// We've successfully yielded. Second, stop our timer and mark it nil so we don't call
-	// yieldFunc again.
+	// yieldFunc again, unless we yield every quantum.
 	p.yieldTimer.Stop()
 	p.yieldTimer = nil
+	if p.quantum > 0 {
+		p.yieldTimer = newDeadlineTimer(time.Now().Add(p.quantum))
+	}
 
 	return nil
 }
@@ -0,0 +1,249 @@
+package shards
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sourcegraph/zoekt/internal/priority"
+)
+
+// fairScheduler interleaves the shard work of concurrent searches, so that a
+// huge batch search can't starve interactive ones.
+//
+// ## Design
+//
+// Like multiScheduler, at most capacity processes run at once. Unlike it, a
+// running process gives up its slot every quantum if another process is
+// waiting for one. Waiting processes get slots in the order of their virtual
+// time, which is the time they ran divided by their priority (see package
+// priority). New processes start at the virtual time of the last process
+// which got a slot, so they are next in line but don't jump ahead of
+// everyone else. This is start-time fair queuing: processes share the slots
+// in proportion to their priority.
+//
+// The granularity is a shard, since processes only yield between shards.
+type fairScheduler struct {
+	capacity int64
+	quantum  time.Duration
+
+	mu      sync.Mutex
+	running int64
+	queue   fairQueue
+	seq     uint64
+
+	// vclock is the virtual time of the last process which got a slot.
+	vclock float64
+
+	metricQueued        *gaugeCounter
+	metricRunning       *gaugeCounter
+	metricTimedoutTotal prometheus.Counter
+}
+
+func newFairScheduler(capacity int64) *fairScheduler {
+	quantum := 10 * time.Millisecond
+	if ms := zoektSched["quantumms"]; ms > 0 {
+		quantum = time.Duration(ms) * time.Millisecond
+	}
+	return &fairScheduler{
+		capacity: capacity,
+		quantum:  quantum,
+
+		metricQueued: &gaugeCounter{
+			gauge:   metricSched.WithLabelValues("fair", "queued"),
+			counter: metricSchedTotal.WithLabelValues("fair", "queued"),
+		},
+		metricRunning: &gaugeCounter{
+			gauge:   metricSched.WithLabelValues("fair", "running"),
+			counter: metricSchedTotal.WithLabelValues("fair", "running"),
+		},
+		metricTimedoutTotal: metricSchedTotal.WithLabelValues("fair", "timedout"),
+	}
+}
+
+// Acquire implements scheduler.Acquire.
+func (s *fairScheduler) Acquire(ctx context.Context) (*process, error) {
+	p := &fairProcess{
+		sched:  s,
+		weight: priority.FromContext(ctx),
+	}
+
+	s.mu.Lock()
+	p.vtime = s.vclock
+	s.mu.Unlock()
+
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return &process{
+		releaseFunc: p.release,
+		yieldTimer:  newDeadlineTimer(time.Now().Add(s.quantum)),
+		yieldFunc:   p.yield,
+		quantum:     s.quantum,
+	}, nil
+}
+
+// dispatch hands free slots to the waiting processes. s.mu must be held.
+func (s *fairScheduler) dispatch() {
+	for s.running < s.capacity && s.queue.Len() > 0 {
+		w := heap.Pop(&s.queue).(*fairWaiter)
+		s.grant(w.vtime)
+		close(w.ready)
+	}
+}
+
+// grant takes a slot for a process at vtime. s.mu must be held.
+func (s *fairScheduler) grant(vtime float64) {
+	s.running++
+	s.metricRunning.Inc()
+	if vtime > s.vclock {
+		s.vclock = vtime
+	}
+}
+
+// free gives up a slot. s.mu must be held.
+func (s *fairScheduler) free() {
+	s.running--
+	s.metricRunning.Dec()
+	s.dispatch()
+}
+
+// fairProcess is the state of a process of a fairScheduler. It is only used
+// by one goroutine at a time.
+type fairProcess struct {
+	sched  *fairScheduler
+	weight float64
+
+	// vtime is the time this process ran, divided by its weight.
+	vtime float64
+
+	// running is set while the process holds a slot. start is when it got it.
+	running bool
+	start   time.Time
+}
+
+// wait blocks until the process gets a slot or ctx expires.
+func (p *fairProcess) wait(ctx context.Context) error {
+	s := p.sched
+	s.mu.Lock()
+	if s.running < s.capacity && s.queue.Len() == 0 {
+		s.grant(p.vtime)
+		s.mu.Unlock()
+		p.running, p.start = true, time.Now()
+		return nil
+	}
+
+	s.seq++
+	w := &fairWaiter{vtime: p.vtime, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.queue, w)
+	s.mu.Unlock()
+
+	s.metricQueued.Inc()
+	defer s.metricQueued.Dec()
+
+	select {
+	case <-w.ready:
+		p.running, p.start = true, time.Now()
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	if w.index >= 0 {
+		heap.Remove(&s.queue, w.index)
+	} else {
+		// We got a slot while giving up, pass it on.
+		s.free()
+	}
+	s.mu.Unlock()
+	s.metricTimedoutTotal.Inc()
+	return ctx.Err()
+}
+
+// charge adds the time the process ran since it got its slot or last yielded.
+func (p *fairProcess) charge() {
+	now := time.Now()
+	p.vtime += now.Sub(p.start).Seconds() / p.weight
+	p.start = now
+}
+
+func (p *fairProcess) yield(ctx context.Context) error {
+	if !p.running {
+		// A previous yield failed. The search is stopping.
+		return ctx.Err()
+	}
+	p.charge()
+
+	s := p.sched
+	s.mu.Lock()
+	if s.queue.Len() == 0 || s.queue[0].vtime >= p.vtime {
+		// Nobody waiting is more deserving.
+		if p.vtime > s.vclock {
+			s.vclock = p.vtime
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	p.running = false
+	s.free()
+	s.mu.Unlock()
+
+	return p.wait(ctx)
+}
+
+func (p *fairProcess) release() {
+	if !p.running {
+		return
+	}
+	p.running = false
+	s := p.sched
+	s.mu.Lock()
+	s.free()
+	s.mu.Unlock()
+}
+
+type fairWaiter struct {
+	vtime float64
+	seq   uint64
+	ready chan struct{}
+
+	// index in the heap, -1 once popped.
+	index int
+}
+
+// fairQueue is a min-heap of waiters ordered by virtual time, then arrival.
+type fairQueue []*fairWaiter
+
+func (q fairQueue) Len() int { return len(q) }
+
+func (q fairQueue) Less(i, j int) bool {
+	if q[i].vtime != q[j].vtime {
+		return q[i].vtime < q[j].vtime
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q fairQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *fairQueue) Push(x any) {
+	w := x.(*fairWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *fairQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -176,6 +176,64 @@ func TestMultiScheduler(t *testing.T) {
 	procs = nil
 }
 
+func TestFairScheduler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sched := newFairScheduler(1)
+	batch, err := sched.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// We expect this to fail since the scheduler is at capacity
+	if _, err := sched.Acquire(quickCtx(t)); err == nil {
+		t.Fatal("expected acquire after cap to fail")
+	}
+	if sched.queue.Len() != 0 {
+		t.Fatal("expected failed acquire to leave the queue")
+	}
+
+	// An interactive search arrives while the batch search runs.
+	acquired := make(chan *process)
+	go func() {
+		proc, err := sched.Acquire(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- proc
+	}()
+	for queued := 0; queued == 0; {
+		time.Sleep(time.Millisecond)
+		sched.mu.Lock()
+		queued = sched.queue.Len()
+		sched.mu.Unlock()
+	}
+
+	// The batch search has run longer, so it yields to the interactive one
+	// and waits until that is done.
+	yielded := make(chan error)
+	go func() {
+		yielded <- batch.yieldFunc(ctx)
+	}()
+	interactive := <-acquired
+	select {
+	case <-yielded:
+		t.Fatal("batch search resumed while the interactive search runs")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	interactive.Release()
+	if err := <-yielded; err != nil {
+		t.Fatal(err)
+	}
+	batch.Release()
+
+	if sched.running != 0 {
+		t.Fatalf("expected no running processes, got %d", sched.running)
+	}
+}
+
 func quickCtx(t *testing.T) context.Context {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
 	t.Cleanup(cancel)
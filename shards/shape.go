@@ -0,0 +1,91 @@
+package shards
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var shapeLabels = []string{"pattern", "repo", "symbol", "atoms"}
+
+var (
+	metricSearchShapeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoekt_search_shape_duration_seconds",
+		Help:    "The duration a search request took in seconds, by the shape of the query.",
+		Buckets: prometheus.DefBuckets,
+	}, shapeLabels)
+	metricSearchShapeFileCount = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoekt_search_shape_file_count",
+		Help:    "The number of files a search request matched, by the shape of the query.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+	}, shapeLabels)
+)
+
+// queryShape classifies queries coarsely, so that latency regressions can be
+// attributed to a class of queries. The classes are few enough to be metric
+// labels.
+type queryShape struct {
+	// pattern is "regexp" if the query has a regular expression, "substring"
+	// if it has substrings but no regular expression, and "none" if it only
+	// filters, eg. on repositories or languages.
+	pattern string
+
+	// repo is set if the query filters on repositories.
+	repo bool
+
+	// symbol is set if the query searches symbols.
+	symbol bool
+
+	// atoms is the number of atoms in buckets "1", "2-3", "4-7" and "8+".
+	atoms string
+}
+
+func newQueryShape(q query.Q) queryShape {
+	s := queryShape{pattern: "none"}
+	atoms := 0
+	query.VisitAtoms(q, func(q query.Q) {
+		atoms++
+		s.visit(q)
+	})
+
+	switch {
+	case atoms <= 1:
+		s.atoms = "1"
+	case atoms <= 3:
+		s.atoms = "2-3"
+	case atoms <= 7:
+		s.atoms = "4-7"
+	default:
+		s.atoms = "8+"
+	}
+	return s
+}
+
+func (s *queryShape) visit(q query.Q) {
+	switch q := q.(type) {
+	case *query.Regexp:
+		s.pattern = "regexp"
+	case *query.Substring:
+		if s.pattern == "none" {
+			s.pattern = "substring"
+		}
+	case *query.Repo, *query.RepoRegexp, *query.RepoSet, *query.RepoIDs, *query.BranchesRepos:
+		s.repo = true
+	case *query.Symbol:
+		s.symbol = true
+		s.visit(q.Expr)
+	}
+}
+
+func (s queryShape) labels() []string {
+	return []string{s.pattern, strconv.FormatBool(s.repo), strconv.FormatBool(s.symbol), s.atoms}
+}
+
+func (s queryShape) observe(seconds float64, fileCount int) {
+	labels := s.labels()
+	metricSearchShapeDuration.WithLabelValues(labels...).Observe(seconds)
+	metricSearchShapeFileCount.WithLabelValues(labels...).Observe(float64(fileCount))
+}
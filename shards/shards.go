@@ -16,7 +16,9 @@ package shards
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"math"
 	"os"
@@ -30,11 +32,14 @@ import (
 
 	"golang.org/x/sync/semaphore"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/atomic"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
 	"github.com/sourcegraph/zoekt/query"
 	"github.com/sourcegraph/zoekt/trace"
@@ -184,6 +189,70 @@ type rankedShard struct {
 	//
 	// repos is nil only if that call failed.
 	repos []*zoekt.Repository
+
+	// version hashes the versions of repos, see Epoch.
+	version uint64
+
+	// refs is the number of shardSets holding the shard. The shard is closed
+	// once it drops to zero.
+	refs atomic.Int64
+}
+
+// release drops the reference of a shardSet to r and closes r if it was the
+// last one.
+func (r *rankedShard) release() {
+	if r.refs.Dec() == 0 {
+		r.Close()
+	}
+}
+
+// shardSet is a snapshot of the loaded shards. The searcher holds a reference
+// to its current set and every search holds one to the set it searches.
+// replace publishes a new set and then releases the old one, so a shard that
+// was replaced or dropped is only closed once the searches still using it
+// have finished. A fork searcher is in the same sets as its parent, so the
+// parent stays open while the fork searcher is in use.
+type shardSet struct {
+	// shards is sorted by decreasing rank and should not be mutated.
+	shards []*rankedShard
+
+	refs atomic.Int64
+}
+
+// newShardSet returns a set of shards which holds a reference to each of
+// them. The set starts with one reference, owned by the caller.
+func newShardSet(shards []*rankedShard) *shardSet {
+	set := &shardSet{shards: shards}
+	set.refs.Store(1)
+	for _, r := range shards {
+		r.refs.Inc()
+	}
+	return set
+}
+
+// acquire adds a reference to set. It returns false if the last reference
+// was already released, in which case the shards may be closed.
+func (set *shardSet) acquire() bool {
+	for {
+		n := set.refs.Load()
+		if n == 0 {
+			return false
+		}
+		if set.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference to set. Releasing the last reference releases
+// the shards of set.
+func (set *shardSet) release() {
+	if set.refs.Dec() > 0 {
+		return
+	}
+	for _, r := range set.shards {
+		r.release()
+	}
 }
 
 // loaded stores the state we compute when updating the state of shards from
@@ -196,6 +265,15 @@ type loaded struct {
 	// ready is true if sharded searcher has finished loading all initial
 	// shards on startup.
 	ready bool
+
+	// set holds the reference to shards, see release.
+	set *shardSet
+}
+
+// release drops the reference to the loaded shards. They may be closed
+// afterwards, so neither they nor results which point into them may be used.
+func (l loaded) release() {
+	l.set.release()
 }
 
 type shardedSearcher struct {
@@ -208,8 +286,23 @@ type shardedSearcher struct {
 	mu     sync.Mutex // protects writes to shards
 	shards map[string]*rankedShard
 
-	ready  atomic.Bool
-	ranked atomic.Value
+	ready atomic.Bool
+
+	// pending is the number of shards the loader is loading but hasn't
+	// passed to replace yet, and loadFailures the number of shards which
+	// failed to load since the searcher started. See Status.
+	pending      atomic.Int64
+	loadFailures atomic.Int64
+
+	// current is the set of loaded shards, see shardSet.
+	current atomic.Pointer[shardSet]
+
+	// authz, if set, restricts requests to the repositories it allows.
+	authz Authz
+
+	// resultMemory, if set, limits the memory held by the results of
+	// concurrent searches.
+	resultMemory *resultMemory
 }
 
 func newShardedSearcher(n int64) *shardedSearcher {
@@ -217,13 +310,14 @@ func newShardedSearcher(n int64) *shardedSearcher {
 		shards: make(map[string]*rankedShard),
 		sched:  newScheduler(n),
 	}
+	ss.current.Store(newShardSet(nil))
 	return ss
 }
 
 // NewDirectorySearcher returns a searcher instance that loads all
 // shards corresponding to a glob into memory.
 func NewDirectorySearcher(dir string) (zoekt.Streamer, error) {
-	return newDirectorySearcher(dir, true)
+	return newDirectorySearcher(dir, SearcherOptions{})
 }
 
 // NewDirectorySearcherFast is like NewDirectorySearcher, but does not block
@@ -233,20 +327,56 @@ func NewDirectorySearcher(dir string) (zoekt.Streamer, error) {
 // partial availability since that is better than no availability on large
 // instances.
 func NewDirectorySearcherFast(dir string) (zoekt.Streamer, error) {
-	return newDirectorySearcher(dir, false)
+	return newDirectorySearcher(dir, SearcherOptions{Fast: true})
+}
+
+// SearcherOptions configures NewDirectorySearcherWithOptions.
+type SearcherOptions struct {
+	// Fast does not block on the initial loading of shards, see
+	// NewDirectorySearcherFast.
+	Fast bool
+
+	// Authz, if set, restricts every request to the repositories it allows.
+	Authz Authz
+
+	// MaxResultBytes, if set, limits the estimated memory held by the
+	// results of all concurrent searches. Once a shard result would exceed
+	// it, the search stops and returns what it has found so far with
+	// zoekt.FlushReasonMemoryExceeded, rather than risking the process
+	// running out of memory under heavy load.
+	MaxResultBytes int64
+
+	// IndexFile configures how shards are read, like their mmap advice.
+	IndexFile zoekt.IndexFileOptions
+
+	// WarmRepos lists repositories whose shards are read into the page
+	// cache, see zoekt.Warm, once the shards are first loaded and before
+	// the searcher is marked ready. It is meant for the repositories which
+	// were searched most before a restart.
+	WarmRepos []string
 }
 
-func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, error) {
+// NewDirectorySearcherWithOptions is like NewDirectorySearcher, configured
+// by opts.
+func NewDirectorySearcherWithOptions(dir string, opts SearcherOptions) (zoekt.Streamer, error) {
+	return newDirectorySearcher(dir, opts)
+}
+
+func newDirectorySearcher(dir string, opts SearcherOptions) (zoekt.Streamer, error) {
 	ss := newShardedSearcher(int64(runtime.GOMAXPROCS(0)))
+	ss.authz = opts.Authz
+	ss.resultMemory = newResultMemory(opts.MaxResultBytes)
 	tl := &loader{
-		ss: ss,
+		ss:        ss,
+		fileOpts:  opts.IndexFile,
+		warmRepos: opts.WarmRepos,
 	}
 	dw, err := newDirectoryWatcher(dir, tl)
 	if err != nil {
 		return nil, err
 	}
 
-	if waitUntilReady {
+	if !opts.Fast {
 		if err := dw.WaitUntilReady(); err != nil {
 			return nil, err
 		}
@@ -275,6 +405,13 @@ func (s *directorySearcher) Close() {
 
 type loader struct {
 	ss *shardedSearcher
+
+	// fileOpts configures how shards are read.
+	fileOpts zoekt.IndexFileOptions
+
+	// warmRepos are the repositories whose shards are warmed before the
+	// searcher is marked ready.
+	warmRepos []string
 }
 
 func (tl *loader) load(keys ...string) {
@@ -301,9 +438,11 @@ func (tl *loader) load(keys ...string) {
 		loadedShards = make(map[string]zoekt.Searcher)
 		mu.Unlock()
 		tl.ss.replace(chunk)
+		tl.ss.pending.Sub(int64(len(chunk)))
 	}
 
 	log.Printf("[INFO] loading %d shard(s): %s", len(keys), humanTruncateList(keys, 5))
+	tl.ss.pending.Add(int64(len(keys)))
 
 	lastProgress := time.Now()
 	for i, key := range keys {
@@ -322,9 +461,11 @@ func (tl *loader) load(keys ...string) {
 			defer sem.Release(1)
 			defer wg.Done()
 
-			shard, err := loadShard(key)
+			shard, err := loadShard(key, tl.fileOpts)
 			if err != nil {
 				metricShardsLoadFailedTotal.Inc()
+				tl.ss.loadFailures.Inc()
+				tl.ss.pending.Dec()
 				log.Printf("[ERROR] reloading: %s, err %v ", key, err)
 				return
 			}
@@ -339,6 +480,107 @@ func (tl *loader) load(keys ...string) {
 	wg.Wait()
 
 	publishLoaded()
+
+	if !tl.ss.ready.Load() && len(tl.warmRepos) > 0 {
+		tl.warm()
+	}
+}
+
+// warm reads the index of the loaded shards holding a repository in
+// warmRepos into the page cache, see zoekt.Warm.
+func (tl *loader) warm() {
+	loaded := tl.ss.getLoaded()
+	defer loaded.release()
+
+	hot := make(map[string]bool, len(tl.warmRepos))
+	for _, name := range tl.warmRepos {
+		hot[name] = true
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = semaphore.NewWeighted(int64(runtime.GOMAXPROCS(0)))
+		shards atomic.Int64
+		bytes  atomic.Int64
+		start  = time.Now()
+	)
+	for _, s := range loaded.shards {
+		if !slices.ContainsFunc(s.repos, func(r *zoekt.Repository) bool { return hot[r.Name] }) {
+			continue
+		}
+
+		_ = sem.Acquire(context.Background(), 1)
+		wg.Add(1)
+
+		go func(s *rankedShard) {
+			defer sem.Release(1)
+			defer wg.Done()
+
+			n, err := zoekt.Warm(s.Searcher)
+			if err != nil {
+				log.Printf("[WARN] %v", err)
+				return
+			}
+			shards.Inc()
+			bytes.Add(n)
+		}(s)
+	}
+
+	wg.Wait()
+
+	log.Printf("[INFO] warmed %d shard(s) of %d repositories in %s, read %d bytes", shards.Load(), len(hot), time.Since(start).Round(time.Millisecond), bytes.Load())
+}
+
+// swap loads the shards in load and then replaces the shards in drop with
+// them in a single step. If any shard fails to load, we keep the old shards.
+func (tl *loader) swap(load, drop []string) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = semaphore.NewWeighted(int64(runtime.GOMAXPROCS(0)))
+		shards = make(map[string]zoekt.Searcher, len(load)+len(drop))
+		failed bool
+	)
+
+	tl.ss.pending.Add(int64(len(load)))
+	defer tl.ss.pending.Sub(int64(len(load)))
+
+	for _, key := range load {
+		_ = sem.Acquire(context.Background(), 1)
+		wg.Add(1)
+
+		go func(key string) {
+			defer sem.Release(1)
+			defer wg.Done()
+
+			shard, err := loadShard(key, tl.fileOpts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				metricShardsLoadFailedTotal.Inc()
+				tl.ss.loadFailures.Inc()
+				log.Printf("[ERROR] reloading: %s, err %v ", key, err)
+				failed = true
+				return
+			}
+			metricShardsLoadedTotal.Inc()
+			shards[key] = shard
+		}(key)
+	}
+
+	wg.Wait()
+
+	if failed {
+		for _, s := range shards {
+			s.Close()
+		}
+		return
+	}
+
+	for _, key := range drop {
+		shards[key] = nil
+	}
+	tl.ss.replace(shards)
 }
 
 func (tl *loader) drop(keys ...string) {
@@ -512,6 +754,22 @@ func doSelectRepoSet(shards []*rankedShard, and *query.And) ([]*rankedShard, que
 	return shards, and
 }
 
+// pushdownRepoFilters returns the shards which can match q, judging by the
+// repository filters in q.
+func pushdownRepoFilters(shards []*rankedShard, q query.Q) []*rankedShard {
+	filtered := make([]*rankedShard, 0, len(shards))
+	for _, s := range shards {
+		// repos is nil if we failed to List the shard, see doSelectRepoSet.
+		if s.repos != nil {
+			if c, ok := zoekt.EvalRepoFilters(q, s.repos).(*query.Const); ok && !c.Value {
+				continue
+			}
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
+
 func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (sr *zoekt.SearchResult, err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.Search", "")
 	tr.LazyLog(q, true)
@@ -535,7 +793,7 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	start := time.Now()
 	proc, err := ss.sched.Acquire(ctx)
 	if err != nil {
-		return nil, err
+		return nil, acquireError(err)
 	}
 	defer proc.Release()
 	tr.LazyPrintf("acquired process")
@@ -544,7 +802,8 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	start = time.Now()
 
 	loaded := ss.getLoaded()
-	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, collectSender)
+	defer loaded.release()
+	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, ss.authz, ss.resultMemory, collectSender)
 	defer done()
 	if err != nil {
 		return nil, err
@@ -559,10 +818,16 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	}
 
 	copyFiles(aggregate)
+	if opts != nil && opts.Highlight {
+		zoekt.HighlightFiles(aggregate.Files)
+	}
 
 	if !loaded.ready {
 		// We may have missed results due to not being fully loaded.
 		aggregate.Stats.Crashes++
+		if !aggregate.Stats.FlushReason.Partial() {
+			aggregate.Stats.FlushReason = zoekt.FlushReasonShardUnavailable
+		}
 	}
 
 	aggregate.Stats.Wait = wait
@@ -584,12 +849,13 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	start := time.Now()
 	proc, err := ss.sched.Acquire(ctx)
 	if err != nil {
-		return err
+		return acquireError(err)
 	}
 	defer proc.Release()
 	tr.LazyPrintf("acquired process")
 
 	loaded := ss.getLoaded()
+	defer loaded.release()
 	shards := loaded.shards
 
 	maxPendingPriority := math.Inf(-1)
@@ -598,15 +864,18 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	}
 
 	stillLoadingCrashes := 0
+	var flushReason zoekt.FlushReason
 	if !loaded.ready {
 		// We may have missed results due to not being fully loaded.
 		stillLoadingCrashes++
+		flushReason = zoekt.FlushReasonShardUnavailable
 	}
 
 	sender.Send(&zoekt.SearchResult{
 		Stats: zoekt.Stats{
-			Crashes: stillLoadingCrashes,
-			Wait:    time.Since(start),
+			Crashes:     stillLoadingCrashes,
+			Wait:        time.Since(start),
+			FlushReason: flushReason,
 		},
 		Progress: zoekt.Progress{
 			MaxPendingPriority: maxPendingPriority,
@@ -618,11 +887,16 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	// 1. Search shards
 	// 2. flushCollectSender (aggregate)
 	// 3. limitSender (limit)
-	// 4. copyFileSender (copy)
+	// 4. highlightSender (highlight)
+	// 5. copyFileSender (copy)
 	//
 	// For streaming, the wrapping has to happen in the inverted order.
 	sender = copyFileSender(sender)
 
+	if opts.Highlight {
+		sender = highlightSender(sender)
+	}
+
 	if truncator, hasLimits := zoekt.NewDisplayTruncator(opts); hasLimits {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithCancel(ctx)
@@ -632,7 +906,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 
 	sender, flush := newFlushCollectSender(opts, sender)
 
-	done, err := streamSearch(ctx, proc, q, opts, shards, sender)
+	done, err := streamSearch(ctx, proc, q, opts, shards, ss.authz, ss.resultMemory, sender)
 
 	// Even though streaming is done, we may have results sitting in a buffer we
 	// need to flush. So we need to send those before calling done.
@@ -645,18 +919,25 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 // streamSearch is an internal helper since both Search and StreamSearch are
 // largely similar.
 //
-// done must always be called, even if err is non-nil. The SearchResults sent
-// via sender contain references to the underlying mmap data that the garbage
-// collector can't see. Calling done informs the garbage collector it is free
-// to collect those shards. The caller must call copyFiles on any
-// SearchResults it returns/streams out before calling done.
-func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, sender zoekt.Sender) (done func(), err error) {
+// done must always be called, even if err is non-nil. It releases the memory
+// the results reserved in mem. The SearchResults sent via sender contain
+// references to the underlying mmap data, so the caller must hold a
+// reference to shards until it called copyFiles on any SearchResults it
+// returns/streams out, see shardSet.
+func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, authz Authz, mem *resultMemory, sender zoekt.Sender) (done func(), err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.streamSearch", "")
 	overallStart := time.Now()
 	metricSearchRunning.Inc()
+
+	// The shape is taken before selectRepoSet rewrites q.
+	shape := newQueryShape(q)
+	var fileCount int
+
 	defer func() {
 		metricSearchRunning.Dec()
+		observeTenantRequest(ctx, "search", time.Since(overallStart))
 		metricSearchDuration.Observe(time.Since(overallStart).Seconds())
+		shape.observe(time.Since(overallStart).Seconds(), fileCount)
 		if err != nil {
 			metricSearchFailedTotal.Inc()
 
@@ -666,6 +947,22 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		tr.Finish()
 	}()
 
+	// 🚨 SECURITY: Only search the shards of the tenant.
+	{
+		beforeLen := len(shards)
+		if shards, err = tenantFilter(ctx, shards); err != nil {
+			return func() {}, err
+		}
+		tr.LazyPrintf("tenantFilter shards=%d->%d", beforeLen, len(shards))
+	}
+
+	// 🚨 SECURITY: Only search the repositories the caller may see.
+	if authz != nil {
+		beforeLen := len(shards)
+		shards, q = authorize(ctx, authz, shards, q)
+		tr.LazyPrintf("authorize shards=%d->%d q=%s", beforeLen, len(shards), q)
+	}
+
 	// Select the subset of shards that we will search over for the given query.
 	{
 		beforeLen := len(shards)
@@ -674,10 +971,24 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		tr.LazyPrintf("selectRepoSet shards=%d->%d q=%s->%s", beforeLen, len(shards), beforeQ, q)
 	}
 
+	// selectRepoSet only looks at the top level of q. Repository filters
+	// elsewhere, eg. in (or (and repo:a foo) (and repo:b bar)), can rule out
+	// shards as well.
+	if zoekt.HasRepoFilters(q) {
+		beforeLen := len(shards)
+		shards = pushdownRepoFilters(shards, q)
+		tr.LazyPrintf("pushdownRepoFilters shards=%d->%d", beforeLen, len(shards))
+	}
+
 	if len(shards) == 0 {
 		return func() {}, nil
 	}
 
+	// Compile custom atoms once rather than for each shard.
+	if q, err = zoekt.CompileMatchers(q); err != nil {
+		return func() {}, err
+	}
+
 	var cancel context.CancelFunc
 	if opts.MaxWallTime == 0 {
 		ctx, cancel = context.WithCancel(ctx)
@@ -753,6 +1064,15 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 	// tracked so we can stop when we hit TotalMaxMatchCount
 	var totalMatchCount int
 
+	// tracked so we can stop when the search exceeds its budget
+	var used zoekt.Stats
+
+	// tracked so we can release the memory reserved for the results
+	var (
+		reserved       int64
+		memoryExceeded bool
+	)
+
 search:
 	for {
 		// At the top of each iteration, have the proc associated with this search yield its won "timeslice"
@@ -788,10 +1108,41 @@ search:
 			// Update the match count statistics and stop searching new shards if we've
 			// reached the limit set in the options.
 			totalMatchCount += r.SearchResult.Stats.MatchCount
+			fileCount += r.SearchResult.Stats.FileCount
 			if opts.TotalMaxMatchCount > 0 && totalMatchCount > opts.TotalMaxMatchCount {
+				// Only flag the search if there are shards left to skip.
+				if work != nil && r.SearchResult.Stats.FlushReason == 0 {
+					r.SearchResult.Stats.FlushReason = zoekt.FlushReasonMatchLimit
+				}
 				stop()
 			}
 
+			used.ContentBytesLoaded += r.SearchResult.Stats.ContentBytesLoaded
+			used.RegexpTime += r.SearchResult.Stats.RegexpTime
+			if opts.BudgetExceeded(&used) {
+				stop()
+				if r.SearchResult.Stats.FlushReason == 0 {
+					r.SearchResult.Stats.FlushReason = zoekt.FlushReasonBudgetExceeded
+				}
+			}
+
+			// Drop the files of results which don't fit in memory, but keep
+			// their stats so they still aggregate.
+			if size := int64(r.SearchResult.SizeBytes()); mem.reserve(size) {
+				reserved += size
+			} else {
+				stop()
+				r.SearchResult.Files = nil
+				r.SearchResult.Directories = nil
+				if r.SearchResult.Stats.FlushReason == 0 {
+					r.SearchResult.Stats.FlushReason = zoekt.FlushReasonMemoryExceeded
+				}
+				if !memoryExceeded {
+					memoryExceeded = true
+					metricResultMemoryExceededTotal.Inc()
+				}
+			}
+
 			observeMetrics(r.SearchResult)
 
 			r.Priority = r.priority
@@ -801,7 +1152,9 @@ search:
 		}
 	}
 
-	return func() { runtime.KeepAlive(shards) }, err
+	return func() {
+		mem.release(reserved)
+	}, err
 }
 
 // sendByRepository splits a zoekt.SearchResult by repository and calls
@@ -891,8 +1244,19 @@ func copyFiles(sr *zoekt.SearchResult) {
 	}
 }
 
+// acquireError returns the error for a search which couldn't acquire a
+// process. Searches which time out waiting for their turn fail with
+// zoekt.FlushReasonTimeout, like searches which time out while they run.
+func acquireError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &zoekt.SearchError{Reason: zoekt.FlushReasonTimeout, Err: err}
+	}
+	return err
+}
+
 func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.SearchOptions) (sr *zoekt.SearchResult, err error) {
 	metricSearchShardRunning.Inc()
+	span, ctx := trace.StartSpanFromContext(ctx, "zoekt.searchOneShard", opentracing.Tag{Key: "shard", Value: s.String()})
 	defer func() {
 		metricSearchShardRunning.Dec()
 		if e := recover(); e != nil {
@@ -902,7 +1266,17 @@ func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoek
 				sr = &zoekt.SearchResult{}
 			}
 			sr.Stats.Crashes = 1
+			sr.Stats.FlushReason = zoekt.FlushReasonShardUnavailable
 		}
+		if sr != nil {
+			span.SetTag("file.count", sr.Stats.FileCount)
+			span.SetTag("match.count", sr.Stats.MatchCount)
+			span.SetTag("crashes", sr.Stats.Crashes)
+		}
+		if err != nil {
+			ext.LogError(span, err)
+		}
+		span.Finish()
 	}()
 
 	return s.Search(ctx, q, opts)
@@ -931,9 +1305,11 @@ func listOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.
 
 func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (rl *zoekt.RepoList, err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.List", "")
+	start := time.Now()
 	metricListRunning.Inc()
 	defer func() {
 		metricListRunning.Dec()
+		observeTenantRequest(ctx, "list", time.Since(start))
 		if rl != nil {
 			tr.LazyPrintf("repos.size=%d reposmap.size=%d crashes=%d stats=%+v", len(rl.Repos), len(rl.ReposMap), rl.Crashes, rl.Stats)
 		}
@@ -958,8 +1334,15 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	tr.LazyPrintf("acquired process")
 
 	loaded := ss.getLoaded()
+	defer loaded.release()
 	shards := loaded.shards
 
+	// 🚨 SECURITY: Only list the shards of the tenant.
+	if shards, err = tenantFilter(ctx, shards); err != nil {
+		return nil, err
+	}
+	shards, q = authorize(ctx, ss.authz, shards, q)
+
 	// Setup what we return now, since we may short circuit if there are no
 	// shards to search.
 	stillLoadingCrashes := 0
@@ -1005,10 +1388,16 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 
 	uniq := map[string]*zoekt.RepoListEntry{}
 
+	// We wait for all shards, even after an error, since the shards are
+	// released when we return.
+	var listErr error
 	for range shards {
 		r := <-all
 		if r.err != nil {
-			return nil, r.err
+			if listErr == nil {
+				listErr = r.err
+			}
+			continue
 		}
 
 		agg.Crashes += r.rl.Crashes
@@ -1020,7 +1409,7 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 				cp := *r // We need to copy because we mutate r.Stats when merging duplicates
 				uniq[r.Repository.Name] = &cp
 			} else {
-				prev.Stats.Add(&r.Stats)
+				prev.Add(r)
 			}
 		}
 
@@ -1032,6 +1421,10 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 		}
 	}
 
+	if listErr != nil {
+		return nil, listErr
+	}
+
 	agg.Repos = make([]*zoekt.RepoListEntry, 0, len(uniq))
 	for _, r := range uniq {
 		agg.Repos = append(agg.Repos, r)
@@ -1044,10 +1437,15 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	// have multiple shards.
 	agg.Stats.Repos = len(uniq) + len(agg.ReposMap)
 
-	if isAll && len(agg.Repos) > 0 {
+	// The metrics describe the whole index, not the repositories of a tenant
+	// or a user.
+	if isAll && len(agg.Repos) > 0 && ss.authz == nil && (!tenant.EnforceTenant() || systemtenant.Is(ctx)) {
 		reportListAllMetrics(agg.Repos)
 	}
 
+	// Sorting and paging needs the repositories of all shards.
+	opts.Apply(&agg)
+
 	return &agg, nil
 }
 
@@ -1068,16 +1466,25 @@ func reportListAllMetrics(repos []*zoekt.RepoListEntry) {
 }
 
 // getLoaded returns the currently loaded shards. Shared so do not mutate.
+// The caller must release them once it no longer uses them or the results
+// pointing into them.
 func (s *shardedSearcher) getLoaded() loaded {
 	// next commit will store the true value of this, for now we keep the
 	// backwards compatible behaviour.
 	ready := s.ready.Load()
-	// ranked is loaded after ready to avoid a race were ready is true but
-	// ranked is still not the final set of shards.
-	ranked, _ := s.ranked.Load().([]*rankedShard)
-	return loaded{
-		shards: ranked,
-		ready:  ready,
+	// current is loaded after ready to avoid a race were ready is true but
+	// current is still not the final set of shards.
+	for {
+		set := s.current.Load()
+		// replace releases a set after it stored the next one, so we only
+		// miss the current set if another one was stored meanwhile.
+		if set.acquire() {
+			return loaded{
+				shards: set.shards,
+				ready:  ready,
+				set:    set,
+			}
+		}
 	}
 }
 
@@ -1099,19 +1506,81 @@ func mkRankedShard(s zoekt.Searcher) *rankedShard {
 	for i := range result.Repos {
 		repo := &result.Repos[i].Repository
 		repos = append(repos, repo)
-		if repo.RawConfig != nil {
-			priority, _ := strconv.ParseFloat(repo.RawConfig["priority"], 64)
-			if priority > maxPriority {
-				maxPriority = priority
-			}
-		}
+		maxPriority = max(maxPriority, repoPriority(repo))
 	}
 
 	return &rankedShard{
 		Searcher: s,
 		repos:    repos,
 		priority: maxPriority,
+		version:  reposVersion(repos),
+	}
+}
+
+func repoPriority(repo *zoekt.Repository) float64 {
+	if repo.RawConfig == nil {
+		return 0
+	}
+	priority, _ := strconv.ParseFloat(repo.RawConfig["priority"], 64)
+	return priority
+}
+
+// forkShards returns a searcher for each shard holding the parent of a fork
+// in shards. It searches the files the fork inherits from its parent, see
+// zoekt.NewForkSearcher.
+func forkShards(shards []*rankedShard) []*rankedShard {
+	// Repositories are found by ID and by name, since forks refer to their
+	// parent by name if the repositories don't have IDs.
+	byRepo := map[repoKey][]*rankedShard{}
+	var forks []*zoekt.Repository
+	for _, s := range shards {
+		for _, repo := range s.repos {
+			byID, byName := repoKey{tenant: repo.TenantID, id: repo.ID}, repoKey{tenant: repo.TenantID, name: repo.Name}
+			byRepo[byName] = append(byRepo[byName], s)
+			if repo.ID != 0 {
+				byRepo[byID] = append(byRepo[byID], s)
+			}
+			if repo.ForkOf != "" && !repo.Tombstone {
+				forks = append(forks, repo)
+			}
+		}
+	}
+
+	var views []*rankedShard
+	seen := map[repoKey]bool{}
+	for _, fork := range forks {
+		// Each shard of a fork holds the same metadata.
+		k := repoKey{tenant: fork.TenantID, id: fork.ID, name: fork.Name}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		parentKey := repoKey{tenant: fork.TenantID, name: fork.ForkOf}
+		if fork.ForkOfID != 0 {
+			parentKey = repoKey{tenant: fork.TenantID, id: fork.ForkOfID}
+		}
+		for _, parent := range byRepo[parentKey] {
+			fs, ok := zoekt.NewForkSearcher(parent.Searcher, fork)
+			if !ok {
+				continue
+			}
+			views = append(views, &rankedShard{
+				Searcher: fs,
+				repos:    []*zoekt.Repository{fork},
+				priority: repoPriority(fork),
+				version:  parent.version + reposVersion([]*zoekt.Repository{fork}),
+			})
+		}
 	}
+	return views
+}
+
+// repoKey identifies a repository across tenants.
+type repoKey struct {
+	tenant int
+	id     uint32
+	name   string
 }
 
 // markReady should be called once all shards have been passed into replace on
@@ -1133,44 +1602,13 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Replaced and dropped shards are closed once the last set holding them
+	// is released, see shardSet.
 	for key, shard := range shards {
-		var r *rankedShard
-		if shard != nil {
-			r = mkRankedShard(shard)
-		}
-
-		old := s.shards[key]
 		if shard == nil {
 			delete(s.shards, key)
 		} else {
-			s.shards[key] = r
-		}
-
-		if old != nil && old.Searcher != nil {
-			//                 _ ___                /^^\ /^\  /^^\_
-			//     _          _@)@) \            ,,/ '` ~ `'~~ ', `\.
-			//   _/o\_ _ _ _/~`.`...'~\        ./~~..,'`','',.,' '  ~:
-			//  / `,'.~,~.~  .   , . , ~|,   ,/ .,' , ,. .. ,,.   `,  ~\_
-			// ( ' _' _ '_` _  '  .    , `\_/ .' ..' '  `  `   `..  `,   \_
-			//  ~V~ V~ V~ V~ ~\ `   ' .  '    , ' .,.,''`.,.''`.,.``. ',   \_
-			//   _/\ /\ /\ /\_/, . ' ,   `_/~\_ .' .,. ,, , _/~\_ `. `. '.,  \_
-			//  < ~ ~ '~`'~'`, .,  .   `_: ::: \_ '      `_/ ::: \_ `.,' . ',  \_
-			//   \ ' `_  '`_    _    ',/ _::_::_ \ _    _/ _::_::_ \   `.,'.,`., \-,-,-,_,_,
-			//    `'~~ `'~~ `'~~ `'~~  \(_)(_)(_)/  `~~' \(_)(_)(_)/ ~'`\_.._,._,'_;_;_;_;_;
-			//
-			// We can't just call Close now, because there may be ongoing searches
-			// which have old in the shards list. Previously we used an exclusive
-			// lock to guarantee there were no concurrent searches. However, that
-			// led to blocking on the read path.
-			//
-			// We could introduce granular locking per rankedShard to know when
-			// there are no more references. However, this becomes tricky in
-			// practice. Instead we rely on the garbage collector noticing old is no
-			// longer used. We take care in our searchers to runtime.KeepAlive until
-			// we have stopped referencing the underling mmap data.
-			runtime.SetFinalizer(old, func(r *rankedShard) {
-				r.Close()
-			})
+			s.shards[key] = mkRankedShard(shard)
 		}
 	}
 
@@ -1178,6 +1616,7 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	for _, r := range s.shards {
 		ranked = append(ranked, r)
 	}
+	ranked = append(ranked, forkShards(ranked)...)
 
 	sort.Slice(ranked, func(i, j int) bool {
 		priorityDiff := ranked[i].priority - ranked[j].priority
@@ -1192,18 +1631,160 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 		return ranked[i].repos[0].Name < ranked[j].repos[0].Name
 	})
 
-	s.ranked.Store(ranked)
+	s.current.Swap(newShardSet(ranked)).release()
+
+	metricShardsLoaded.Set(float64(len(s.shards)))
+}
+
+// reposVersion hashes the versions of repos. It doesn't depend on the order
+// of repos or where they are stored, so servers which load the same index
+// agree on it.
+func reposVersion(repos []*zoekt.Repository) uint64 {
+	var version uint64
+	for _, repo := range repos {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d\x00%s\x00%s\x00%t\x00%d", repo.ID, repo.Name, repo.IndexOptions, repo.Tombstone, len(repo.FileTombstones))
+		for _, b := range repo.Branches {
+			fmt.Fprintf(h, "\x00%s\x00%s", b.Name, b.Version)
+		}
+		// A sum is independent of the order.
+		version += h.Sum64()
+	}
+	return version
+}
+
+// Epoch returns a number which changes whenever the shards a search for q in
+// ctx uses change, eg. to invalidate cached search results. Shards which the
+// search skips don't affect it. It returns false if s isn't a searcher of
+// this package or the result of the search depends on more than the index.
+func Epoch(ctx context.Context, s zoekt.Searcher, q query.Q) (uint64, bool) {
+	if e, ok := s.(epocher); ok {
+		return e.epoch(ctx, q)
+	}
+	return 0, false
+}
+
+// epocher is implemented by the searchers of this package, see Epoch.
+type epocher interface {
+	epoch(ctx context.Context, q query.Q) (uint64, bool)
+}
+
+// epoch selects the shards like streamSearch.
+func (s *shardedSearcher) epoch(ctx context.Context, q query.Q) (uint64, bool) {
+	// Results depend on the caller's permissions, which aren't part of the
+	// epoch.
+	if s.authz != nil {
+		return 0, false
+	}
+
+	loaded := s.getLoaded()
+	defer loaded.release()
+
+	shards, err := tenantFilter(ctx, loaded.shards)
+	if err != nil {
+		return 0, false
+	}
+	shards, q = selectRepoSet(shards, q)
+	if zoekt.HasRepoFilters(q) {
+		shards = pushdownRepoFilters(shards, q)
+	}
+
+	var epoch uint64
+	for _, r := range shards {
+		// We can't tell when a shard we failed to List changes.
+		if r.repos == nil {
+			return 0, false
+		}
+		epoch += r.version
+	}
+	return epoch, true
+}
+
+func (s *directorySearcher) epoch(ctx context.Context, q query.Q) (uint64, bool) {
+	return Epoch(ctx, s.Streamer, q)
+}
+
+// epoch doesn't know which repositories the type:repo sub-queries of q
+// match, so they depend on every shard.
+func (s *typeRepoSearcher) epoch(ctx context.Context, q query.Q) (uint64, bool) {
+	hasTypeRepo := false
+	query.Map(q, func(q query.Q) query.Q {
+		if t, ok := q.(*query.Type); ok && t.Type == query.TypeRepo {
+			hasTypeRepo = true
+		}
+		return q
+	})
+	if hasTypeRepo {
+		q = &query.Const{Value: true}
+	}
+	return Epoch(ctx, s.Streamer, q)
+}
+
+// LoadStatus describes how far a searcher of this package got loading the
+// shards on disk, see Status.
+type LoadStatus struct {
+	// Ready is true once the shards found on startup have been loaded.
+	Ready bool
+
+	// Loaded is the number of shards being searched, and Pending the number
+	// of shards which are being loaded.
+	Loaded  int
+	Pending int
+
+	// LoadFailures is the number of shards which failed to load since the
+	// searcher started.
+	LoadFailures int
+
+	// WatcherError is the last error of watching the index directory, and
+	// WatcherErrorTime when it happened. It is empty if there was none.
+	WatcherError     string `json:",omitempty"`
+	WatcherErrorTime time.Time
+}
+
+// Status returns the load status of s. It returns false if s isn't a
+// searcher of this package.
+func Status(s zoekt.Searcher) (LoadStatus, bool) {
+	if st, ok := s.(statuser); ok {
+		return st.status(), true
+	}
+	return LoadStatus{}, false
+}
+
+// statuser is implemented by the searchers of this package, see Status.
+type statuser interface {
+	status() LoadStatus
+}
+
+func (s *shardedSearcher) status() LoadStatus {
+	return LoadStatus{
+		Ready:        s.ready.Load(),
+		Loaded:       len(s.current.Load().shards),
+		Pending:      int(s.pending.Load()),
+		LoadFailures: int(s.loadFailures.Load()),
+	}
+}
+
+func (s *directorySearcher) status() LoadStatus {
+	st, _ := Status(s.Streamer)
+	if at, err := s.directoryWatcher.lastError(); err != nil {
+		st.WatcherError = err.Error()
+		st.WatcherErrorTime = at
+	}
+	return st
+}
 
-	metricShardsLoaded.Set(float64(len(ranked)))
+func (s *typeRepoSearcher) status() LoadStatus {
+	st, _ := Status(s.Streamer)
+	return st
 }
 
-func loadShard(fn string) (zoekt.Searcher, error) {
+func loadShard(fn string, opts zoekt.IndexFileOptions) (zoekt.Searcher, error) {
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil, err
 	}
 
-	iFile, err := zoekt.NewIndexFile(f)
+	iFile, err := zoekt.NewIndexFileWithOptions(f, opts)
 	if err != nil {
 		return nil, err
 	}
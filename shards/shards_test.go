@@ -17,6 +17,7 @@ package shards
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"hash/fnv"
@@ -24,10 +25,12 @@ import (
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"testing/quick"
 	"time"
@@ -38,6 +41,9 @@ import (
 	"github.com/grafana/regexp"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/tenanttest"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -74,7 +80,7 @@ func TestCrashResilience(t *testing.T) {
 	defer log.SetOutput(oldOut)
 
 	ss := newShardedSearcher(2)
-	ss.ranked.Store([]*rankedShard{{Searcher: &crashSearcher{}}})
+	ss.current.Store(newShardSet([]*rankedShard{{Searcher: &crashSearcher{}}}))
 
 	var wantCrashes int
 	test := func(t *testing.T) {
@@ -195,6 +201,108 @@ func TestOrderByShard(t *testing.T) {
 	}
 }
 
+func TestResultMemory(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	n := 10
+	for i := 0; i < n; i++ {
+		ss.replace(map[string]zoekt.Searcher{
+			fmt.Sprintf("shard%d", i): &rankSearcher{rank: uint16(i)},
+		})
+	}
+	ss.markReady()
+
+	// Room for the results of three shards.
+	one := (&zoekt.SearchResult{Files: []zoekt.FileMatch{{FileName: "f0"}}}).SizeBytes()
+	ss.resultMemory = newResultMemory(int64(3 * one))
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "bla"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) == 0 || len(res.Files) > 3 {
+		t.Errorf("got %d results, want between 1 and 3", len(res.Files))
+	}
+	if res.Stats.FlushReason != zoekt.FlushReasonMemoryExceeded {
+		t.Errorf("got flush reason %v, want %v", res.Stats.FlushReason, zoekt.FlushReasonMemoryExceeded)
+	}
+	if used := ss.resultMemory.used.Load(); used != 0 {
+		t.Errorf("%d bytes still reserved after the search", used)
+	}
+
+	// Searches which fit aren't affected.
+	ss.resultMemory = newResultMemory(int64(2 * n * int(one)))
+	res, err = ss.Search(context.Background(), &query.Substring{Pattern: "bla"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != n || res.Stats.FlushReason != 0 {
+		t.Errorf("got %d results with flush reason %v, want %d", len(res.Files), res.Stats.FlushReason, n)
+	}
+}
+
+// closeSearcher records whether it was closed and crashes if it is searched
+// afterwards. If started is set, Search closes it and waits for unblock.
+type closeSearcher struct {
+	rankSearcher
+	closed atomic.Bool
+
+	started, unblock chan struct{}
+}
+
+func (s *closeSearcher) Close() {
+	s.closed.Store(true)
+}
+
+func (s *closeSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if s.started != nil {
+		close(s.started)
+		<-s.unblock
+	}
+	if s.closed.Load() {
+		panic("search on a closed shard")
+	}
+	return s.rankSearcher.Search(ctx, q, opts)
+}
+
+func TestReplaceWaitsForSearches(t *testing.T) {
+	ss := newShardedSearcher(2)
+	ss.markReady()
+
+	old := &closeSearcher{started: make(chan struct{}), unblock: make(chan struct{})}
+	ss.replace(map[string]zoekt.Searcher{"key": old})
+
+	errC := make(chan error)
+	go func() {
+		res, err := ss.Search(context.Background(), &query.Substring{Pattern: "foo"}, &zoekt.SearchOptions{})
+		if err == nil && res.Stats.Crashes > 0 {
+			err = errors.New("search crashed")
+		}
+		errC <- err
+	}()
+	<-old.started
+
+	current := &closeSearcher{}
+	ss.replace(map[string]zoekt.Searcher{"key": current})
+	if old.closed.Load() {
+		t.Fatal("replace closed a shard which was being searched")
+	}
+
+	close(old.unblock)
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	if !old.closed.Load() {
+		t.Fatal("the replaced shard wasn't closed after the search finished")
+	}
+
+	// Shards nobody searches are closed right away.
+	ss.Close()
+	if !current.closed.Load() {
+		t.Fatal("Close didn't close the loaded shard")
+	}
+}
+
 func TestShardedSearcher_Ranking(t *testing.T) {
 	ss := newShardedSearcher(1)
 
@@ -225,8 +333,11 @@ func TestShardedSearcher_Ranking(t *testing.T) {
 		"weekend-project-2",
 	}
 
+	loaded := ss.getLoaded()
+	defer loaded.release()
+
 	var have []string
-	for _, s := range ss.getLoaded().shards {
+	for _, s := range loaded.shards {
 		for _, r := range s.repos {
 			have = append(have, r.Name)
 		}
@@ -385,6 +496,187 @@ func TestFilteringShardsByRepoSetOrBranchesReposOrRepoIDs(t *testing.T) {
 	}
 }
 
+func TestPushdownRepoFilters(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	namePrefix := [3]string{"foo", "bar", "baz"}
+	n := 3 * runtime.GOMAXPROCS(0)
+	for i := 0; i < n; i++ {
+		repoName := fmt.Sprintf("%s-repository%.3d", namePrefix[i%3], i)
+		ss.replace(map[string]zoekt.Searcher{
+			fmt.Sprintf("shard%d", i): &rankSearcher{
+				repo: &zoekt.Repository{ID: hash(repoName), Name: repoName},
+				rank: uint16(n - i),
+			},
+		})
+	}
+
+	repo := func(pat string) query.Q { return &query.Repo{Regexp: regexp.MustCompile(pat)} }
+	sub := &query.Substring{Pattern: "bla"}
+	cases := []struct {
+		q    query.Q
+		want int
+	}{
+		{query.NewOr(query.NewAnd(repo("^foo-"), sub), query.NewAnd(repo("^bar-"), sub)), 2 * n / 3},
+		{query.NewAnd(sub, &query.Not{Child: repo("^baz-")}), 2 * n / 3},
+		{query.NewAnd(sub, query.NewOr(repo("^foo-"), &query.Branch{Pattern: "main"})), n / 3},
+	}
+
+	for _, c := range cases {
+		// rankSearcher always returns a result, so we count the shards searched.
+		res, err := ss.Search(context.Background(), c.q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", c.q, err)
+		}
+		if len(res.Files) != c.want {
+			t.Errorf("%s: got %d results, want %d", c.q, len(res.Files), c.want)
+		}
+	}
+}
+
+func TestTenantFilter(t *testing.T) {
+	tenanttest.MockEnforce(t)
+
+	ctx1 := tenanttest.NewTestContext()
+	tnt1, err := tenant.FromContext(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx2 := tenanttest.NewTestContext()
+
+	ss := newShardedSearcher(1)
+	n := 2 * runtime.GOMAXPROCS(0)
+	for i := 0; i < n; i++ {
+		repoName := fmt.Sprintf("repository%.3d", i)
+		tenantID := tnt1.ID()
+		if i%2 == 1 {
+			tenantID = 1000
+		}
+		ss.replace(map[string]zoekt.Searcher{
+			fmt.Sprintf("shard%d", i): &rankSearcher{
+				repo: &zoekt.Repository{TenantID: tenantID, ID: hash(repoName), Name: repoName},
+				rank: uint16(n - i),
+			},
+		})
+	}
+
+	q := &query.Substring{Pattern: "bla"}
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{"tenant", ctx1, n / 2},
+		{"other tenant", ctx2, 0},
+		{"system tenant", systemtenant.WithUnsafeContext(context.Background()), n},
+	}
+	for _, c := range cases {
+		// rankSearcher always returns a result, so we count the shards searched.
+		res, err := ss.Search(c.ctx, q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(res.Files) != c.want {
+			t.Errorf("%s: got %d results, want %d", c.name, len(res.Files), c.want)
+		}
+
+		rl, err := ss.List(c.ctx, &query.Const{Value: true}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(rl.Repos) != c.want {
+			t.Errorf("%s: got %d repos, want %d", c.name, len(rl.Repos), c.want)
+		}
+	}
+
+	// The tenant is required.
+	if _, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{}); !errors.Is(err, tenant.ErrMissingTenant) {
+		t.Fatalf("got %v, want %v", err, tenant.ErrMissingTenant)
+	}
+	if _, err := ss.List(context.Background(), &query.Const{Value: true}, nil); !errors.Is(err, tenant.ErrMissingTenant) {
+		t.Fatalf("got %v, want %v", err, tenant.ErrMissingTenant)
+	}
+}
+
+type allowAuthz map[uint32]bool
+
+func (a allowAuthz) FilterRepos(ctx context.Context, repos []uint32) []uint32 {
+	var allowed []uint32
+	for _, id := range repos {
+		if a[id] {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed
+}
+
+func TestAuthz(t *testing.T) {
+	repo := func(id uint32) *zoekt.Repository {
+		return &zoekt.Repository{ID: id, Name: fmt.Sprintf("repo%d", id)}
+	}
+	doc := zoekt.Document{Name: "f", Content: []byte("needle")}
+
+	// repo1 and repo2 share a compound shard.
+	tmpName, dstName, err := zoekt.Merge(t.TempDir(),
+		&memSeeker{data: shardBytes(t, testIndexBuilder(t, repo(1), doc))},
+		&memSeeker{data: shardBytes(t, testIndexBuilder(t, repo(2), doc))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+	compound, err := loadShard(dstName, zoekt.IndexFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := newShardedSearcher(1)
+	ss.authz = allowAuthz{1: true, 3: true}
+	ss.replace(map[string]zoekt.Searcher{
+		"compound": compound,
+		"repo3":    searcherForTest(t, testIndexBuilder(t, repo(3), doc)),
+		"repo4":    searcherForTest(t, testIndexBuilder(t, repo(4), doc)),
+	})
+	defer ss.Close()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.Repository)
+	}
+	sort.Strings(got)
+	if want := []string{"repo1", "repo3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search: got %v, want %v", got, want)
+	}
+
+	rl, err := ss.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, r := range rl.Repos {
+		got = append(got, r.Repository.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"repo1", "repo3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List: got %v, want %v", got, want)
+	}
+}
+
+func shardBytes(t testing.TB, b *zoekt.IndexBuilder) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
 func hash(name string) uint32 {
 	h := fnv.New32()
 	h.Write([]byte(name))
@@ -497,6 +789,12 @@ func TestShardedSearcher_List(t *testing.T) {
 		OtherBranchesNewLinesCount: 1,
 	}
 
+	// Documents of both shards of a repository count for its branches.
+	branches := []zoekt.BranchListEntry{
+		{Name: "main", Documents: 1},
+		{Name: "dev", Documents: 1},
+	}
+
 	aggStats := stats
 	aggStats.Add(&aggStats) // since both repos have the exact same stats, this works
 	aggStats.Repos = 2      // Add doesn't populate Repos, this is done in Shards	based on the response sizes.
@@ -514,10 +812,12 @@ func TestShardedSearcher_List(t *testing.T) {
 					{
 						Repository: *repos[0],
 						Stats:      stats,
+						Branches:   branches,
 					},
 					{
 						Repository: *repos[1],
 						Stats:      stats,
+						Branches:   branches,
 					},
 				},
 				Stats: aggStats,
@@ -531,10 +831,12 @@ func TestShardedSearcher_List(t *testing.T) {
 					{
 						Repository: *repos[0],
 						Stats:      stats,
+						Branches:   branches,
 					},
 					{
 						Repository: *repos[1],
 						Stats:      stats,
+						Branches:   branches,
 					},
 				},
 				Stats: aggStats,
@@ -548,10 +850,12 @@ func TestShardedSearcher_List(t *testing.T) {
 					{
 						Repository: *repos[0],
 						Stats:      stats,
+						Branches:   branches,
 					},
 					{
 						Repository: *repos[1],
 						Stats:      stats,
+						Branches:   branches,
 					},
 				},
 				Stats: aggStats,
@@ -565,6 +869,7 @@ func TestShardedSearcher_List(t *testing.T) {
 					{
 						Repository: *repos[1],
 						Stats:      stats,
+						Branches:   branches,
 					},
 				},
 				ReposMap: zoekt.ReposMap{
@@ -596,6 +901,7 @@ func TestShardedSearcher_List(t *testing.T) {
 				cmpopts.EquateEmpty(),
 				cmpopts.IgnoreFields(zoekt.MinimalRepoListEntry{}, "IndexTimeUnix"),
 				cmpopts.IgnoreFields(zoekt.RepoListEntry{}, "IndexMetadata"),
+				cmpopts.IgnoreFields(zoekt.BranchListEntry{}, "IndexTime"),
 				cmpopts.IgnoreFields(zoekt.RepoStats{}, "IndexBytes"),
 				cmpopts.IgnoreFields(zoekt.Repository{}, "SubRepoMap"),
 				cmpopts.IgnoreFields(zoekt.Repository{}, "priority"),
@@ -622,6 +928,51 @@ func testIndexBuilder(t testing.TB, repo *zoekt.Repository, docs ...zoekt.Docume
 	return b
 }
 
+func TestEpoch(t *testing.T) {
+	ctx := context.Background()
+	shard := func(name, version string) zoekt.Searcher {
+		return searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{
+			Name:     name,
+			Branches: []zoekt.RepositoryBranch{{Name: "HEAD", Version: version}},
+		}, zoekt.Document{Name: "f", Content: []byte("needle")}))
+	}
+
+	ss := newShardedSearcher(1)
+	ss.replace(map[string]zoekt.Searcher{"a": shard("repo-a", "v1"), "b": shard("repo-b", "v1")})
+	ts := &typeRepoSearcher{Streamer: ss}
+
+	needle := &query.Substring{Pattern: "needle"}
+	queries := map[string]query.Q{
+		"all":      needle,
+		"repo-a":   query.NewAnd(&query.Repo{Regexp: regexp.MustCompile("^repo-a$")}, needle),
+		"nested":   query.NewOr(query.NewAnd(&query.Repo{Regexp: regexp.MustCompile("^repo-a$")}, needle), &query.Const{Value: false}),
+		"typeRepo": query.NewAnd(&query.Type{Type: query.TypeRepo, Child: &query.Repo{Regexp: regexp.MustCompile("^repo-b$")}}, needle),
+	}
+	epochs := func() map[string]uint64 {
+		t.Helper()
+		got := map[string]uint64{}
+		for name, q := range queries {
+			epoch, ok := Epoch(ctx, ts, q)
+			if !ok {
+				t.Fatalf("%s: got no epoch", name)
+			}
+			got[name] = epoch
+		}
+		return got
+	}
+
+	before := epochs()
+	ss.replace(map[string]zoekt.Searcher{"b": shard("repo-b", "v2")})
+	after := epochs()
+
+	// Searches which skip repo-b keep their cached results.
+	for name, changed := range map[string]bool{"all": true, "repo-a": false, "nested": false, "typeRepo": true} {
+		if got := before[name] != after[name]; got != changed {
+			t.Errorf("%s: got epoch changed %v after reindexing repo-b, want %v", name, got, changed)
+		}
+	}
+}
+
 func searcherForTest(t testing.TB, b *zoekt.IndexBuilder) zoekt.Searcher {
 	var buf bytes.Buffer
 	if err := b.Write(&buf); err != nil {
@@ -1016,6 +1367,40 @@ func TestFileBasedSearch(t *testing.T) {
 	}
 }
 
+func TestSearchHighlight(t *testing.T) {
+	ss := newShardedSearcher(1)
+	ss.replace(map[string]zoekt.Searcher{"r1": searcherForTest(t, testIndexBuilder(t, nil,
+		zoekt.Document{Name: "a.go", Language: "Go", Content: []byte("func a() { return }\n")},
+		zoekt.Document{Name: "b.go", Language: "Go", Content: []byte("func b() { return }\n")},
+	))})
+	q := &query.Substring{Pattern: "return", Content: true}
+	opts := &zoekt.SearchOptions{Highlight: true, ChunkMatches: true, MaxDocDisplayCount: 1}
+
+	check := func(files []zoekt.FileMatch) {
+		t.Helper()
+		if len(files) != 1 {
+			t.Fatalf("got %d files, want 1", len(files))
+		}
+		if len(files[0].ChunkMatches[0].Tokens) == 0 {
+			t.Fatalf("got no tokens for %s", files[0].FileName)
+		}
+	}
+
+	sr, err := ss.Search(context.Background(), q, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(sr.Files)
+
+	var files []zoekt.FileMatch
+	if err := ss.StreamSearch(context.Background(), q, opts, zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		files = append(files, result.Files...)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	check(files)
+}
+
 func TestWordBoundaryRanking(t *testing.T) {
 	cases := []struct {
 		name              string
@@ -1227,6 +1612,36 @@ func TestNewDirectorySearcher_empty(t *testing.T) {
 
 // testDeadline returns the deadline for t, but ensures it is no longer than
 // maxTimeout away.
+func TestStatus(t *testing.T) {
+	dir := t.TempDir()
+	shard := shardBytes(t, testIndexBuilder(t, &zoekt.Repository{Name: "repo"}, zoekt.Document{Name: "f", Content: []byte("bla")}))
+	if err := os.WriteFile(filepath.Join(dir, "repo_v16.00000.zoekt"), shard, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken_v16.00000.zoekt"), []byte("not a shard"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ss.Close)
+
+	got, ok := Status(ss)
+	if !ok {
+		t.Fatal("got no status for a directory searcher")
+	}
+	want := LoadStatus{Ready: true, Loaded: 1, LoadFailures: 1}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, ok := Status(searcherForTest(t, testIndexBuilder(t, nil))); ok {
+		t.Error("got a status for a single shard")
+	}
+}
+
 func testDeadline(t *testing.T, maxTimeout time.Duration) time.Time {
 	deadline := time.Now().Add(maxTimeout)
 	if d, ok := t.Deadline(); ok && d.Before(deadline) {
@@ -1247,3 +1662,24 @@ func waitForPredicate(deadline time.Time, tick time.Duration, pred func() bool)
 
 	return pred()
 }
+
+func TestQueryShape(t *testing.T) {
+	for _, c := range []struct {
+		q    string
+		want []string
+	}{
+		{"foo", []string{"substring", "false", "false", "1"}},
+		{"a.*b foo", []string{"regexp", "false", "false", "2-3"}},
+		{"repo:foo sym:bar", []string{"substring", "true", "true", "2-3"}},
+		{"lang:go", []string{"none", "false", "false", "1"}},
+		{"a b c d e f g h", []string{"substring", "false", "false", "8+"}},
+	} {
+		q, err := query.Parse(c.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := newQueryShape(q).labels(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.q, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package shards
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+)
+
+var (
+	metricTenantRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_tenant_requests_total",
+		Help: "The total number of search and list requests per tenant. Only recorded if tenants are enforced.",
+	}, []string{"tenant", "method"})
+	metricTenantRequestDurationSecondsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_tenant_request_duration_seconds_total",
+		Help: "The total time spent on search and list requests per tenant. Only recorded if tenants are enforced.",
+	}, []string{"tenant", "method"})
+)
+
+// tenantFilter returns the shards which contain a repository of the tenant in
+// ctx. Shards of other tenants are never searched, which is cheaper than
+// skipping their documents one by one, and the per-document checks stay in
+// place for compound shards with repositories of several tenants.
+//
+// The filter is required if tenants are enforced: a request without a tenant
+// fails with tenant.ErrMissingTenant instead of silently finding nothing. Only
+// requests with the system tenant see all shards.
+func tenantFilter(ctx context.Context, shards []*rankedShard) ([]*rankedShard, error) {
+	if !tenant.EnforceTenant() || systemtenant.Is(ctx) {
+		return shards, nil
+	}
+	tnt, err := tenant.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]*rankedShard, 0, len(shards))
+	for _, s := range shards {
+		// repos is nil if we failed to List the shard. Keep it and let the
+		// per-document checks decide.
+		if s.repos == nil {
+			filtered = append(filtered, s)
+			continue
+		}
+		for _, r := range s.repos {
+			if r.TenantID == tnt.ID() {
+				filtered = append(filtered, s)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// observeTenantRequest records a request of method which took d for the
+// tenant in ctx. Requests with the system tenant are not recorded.
+func observeTenantRequest(ctx context.Context, method string, d time.Duration) {
+	if !tenant.EnforceTenant() || systemtenant.Is(ctx) {
+		return
+	}
+	tnt, err := tenant.FromContext(ctx)
+	if err != nil {
+		return
+	}
+	label := strconv.Itoa(tnt.ID())
+	metricTenantRequestsTotal.WithLabelValues(label, method).Inc()
+	metricTenantRequestDurationSecondsTotal.WithLabelValues(label, method).Add(d.Seconds())
+}
@@ -33,6 +33,9 @@ type shardLoader interface {
 	// Load a new file.
 	load(filenames ...string)
 	drop(filenames ...string)
+	// swap loads and drops files in one step, so searches either see all
+	// of the old or all of the new files.
+	swap(load, drop []string)
 }
 
 type DirectoryWatcher struct {
@@ -40,10 +43,24 @@ type DirectoryWatcher struct {
 	timestamps map[string]time.Time
 	loader     shardLoader
 
+	// shardIDs caches the index metadata ID of shards that belong to a shard
+	// set with a manifest.
+	shardIDs map[string]shardID
+
+	// fallbacks are the manifests of the shard sets we load without them,
+	// so we only warn once about each.
+	fallbacks map[string]bool
+
 	// closed once ready
 	ready    chan struct{}
 	readyErr error
 
+	// errMu protects lastErr and lastErrTime, the last error of scanning
+	// or watching dir.
+	errMu       sync.Mutex
+	lastErr     error
+	lastErrTime time.Time
+
 	closeOnce sync.Once
 	// quit is closed by Close to signal the directory watcher to stop.
 	quit chan struct{}
@@ -63,6 +80,8 @@ func newDirectoryWatcher(dir string, loader shardLoader) (*DirectoryWatcher, err
 		dir:        dir,
 		timestamps: map[string]time.Time{},
 		loader:     loader,
+		shardIDs:   map[string]shardID{},
+		fallbacks:  map[string]bool{},
 		ready:      make(chan struct{}),
 		quit:       make(chan struct{}),
 		stopped:    make(chan struct{}),
@@ -72,11 +91,13 @@ func newDirectoryWatcher(dir string, loader shardLoader) (*DirectoryWatcher, err
 		defer close(sw.ready)
 
 		if err := sw.scan(); err != nil {
+			sw.setError(err)
 			sw.readyErr = err
 			return
 		}
 
 		if err := sw.watch(); err != nil {
+			sw.setError(err)
 			sw.readyErr = err
 			return
 		}
@@ -90,6 +111,22 @@ func (s *DirectoryWatcher) WaitUntilReady() error {
 	return s.readyErr
 }
 
+// setError records err as the last error of s.
+func (s *DirectoryWatcher) setError(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	s.lastErr = err
+	s.lastErrTime = time.Now()
+}
+
+// lastError returns when the last error of scanning or watching the
+// directory happened and the error, which is nil if there was none.
+func (s *DirectoryWatcher) lastError() (time.Time, error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.lastErrTime, s.lastErr
+}
+
 func (s *DirectoryWatcher) String() string {
 	return fmt.Sprintf("shardWatcher(%s)", s.dir)
 }
@@ -161,10 +198,24 @@ func (s *DirectoryWatcher) scan() error {
 		}
 	}
 
+	manifests, err := s.applyManifests(ts)
+	if err != nil {
+		return err
+	}
+
+	// Shards of a set with a manifest are swapped in together, keyed by the
+	// manifest path.
+	toLoadSet := map[string][]string{}
+	toDropSet := map[string][]string{}
+
 	var toLoad []string
 	for k, mtime := range ts {
 		if t, ok := s.timestamps[k]; !ok || t != mtime {
-			toLoad = append(toLoad, k)
+			if mp := zoekt.ShardManifestPath(k); manifests[mp] {
+				toLoadSet[mp] = append(toLoadSet[mp], k)
+			} else {
+				toLoad = append(toLoad, k)
+			}
 			s.timestamps[k] = mtime
 		}
 	}
@@ -173,8 +224,13 @@ func (s *DirectoryWatcher) scan() error {
 	// Unload deleted shards.
 	for k := range s.timestamps {
 		if _, ok := ts[k]; !ok {
-			toDrop = append(toDrop, k)
+			if mp := zoekt.ShardManifestPath(k); manifests[mp] {
+				toDropSet[mp] = append(toDropSet[mp], k)
+			} else {
+				toDrop = append(toDrop, k)
+			}
 			delete(s.timestamps, k)
+			delete(s.shardIDs, k)
 		}
 	}
 
@@ -183,11 +239,142 @@ func (s *DirectoryWatcher) scan() error {
 	}
 
 	s.loader.drop(toDrop...)
+
+	for mp := range manifests {
+		if len(toLoadSet[mp]) > 0 || len(toDropSet[mp]) > 0 {
+			log.Printf("[INFO] swapping shard set %s: loading %d, unloading %d shard(s)", filepath.Base(mp), len(toLoadSet[mp]), len(toDropSet[mp]))
+			s.loader.swap(toLoadSet[mp], toDropSet[mp])
+		}
+	}
+
+	// load marks the searcher as ready, so it goes last.
 	s.loader.load(toLoad...)
 
 	return nil
 }
 
+type shardID struct {
+	mtime time.Time
+	id    string
+}
+
+// manifestGrace is how long we hold back a shard set that doesn't match its
+// manifest. The builder renames the shards into place right after writing
+// the manifest, so a set that still doesn't match after that was changed by
+// something else, and we fall back to loading the shards on disk.
+const manifestGrace = time.Minute
+
+// applyManifests restricts the shards in ts, which maps shard paths to their
+// modification time, to the shards listed in their manifest, see
+// zoekt.ShardManifest. If a shard set does not match its manifest yet, we
+// keep the shards we have loaded for it, as if nothing changed on disk, but
+// drop the ones which were deleted. Sets whose manifest can't be read or
+// didn't match for manifestGrace are loaded like shards without a manifest.
+// It returns the paths of the manifests it found.
+func (s *DirectoryWatcher) applyManifests(ts map[string]time.Time) (map[string]bool, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.manifest"))
+	if err != nil {
+		return nil, err
+	}
+	manifests := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		manifests[p] = true
+	}
+	for mp := range s.fallbacks {
+		if !manifests[mp] {
+			delete(s.fallbacks, mp)
+		}
+	}
+
+	sets := map[string][]string{}
+	for fn := range ts {
+		if mp := zoekt.ShardManifestPath(fn); manifests[mp] {
+			sets[mp] = append(sets[mp], fn)
+		}
+	}
+
+	for mp, fns := range sets {
+		keep, modTime, err := s.checkManifest(mp, ts)
+		if err == nil {
+			delete(s.fallbacks, mp)
+			for _, fn := range fns {
+				if !keep[filepath.Base(fn)] {
+					delete(ts, fn)
+				}
+			}
+			continue
+		}
+
+		if time.Since(modTime) >= manifestGrace {
+			if !s.fallbacks[mp] {
+				log.Printf("[WARN] loading shard set %s without its manifest: %v", filepath.Base(mp), err)
+				s.fallbacks[mp] = true
+			}
+			continue
+		}
+
+		for _, fn := range fns {
+			if t, ok := s.timestamps[fn]; ok {
+				ts[fn] = t
+			} else {
+				delete(ts, fn)
+			}
+		}
+	}
+	return manifests, nil
+}
+
+// checkManifest returns the base names of the shards listed in the manifest
+// at mp and its modification time. It returns an error unless all of them
+// are present in ts with the listed IDs. The set is also incomplete if there
+// are shards that are newer than the manifest, since they belong to a build
+// that is still being published. The modification time is zero if the
+// manifest can't be read.
+func (s *DirectoryWatcher) checkManifest(mp string, ts map[string]time.Time) (map[string]bool, time.Time, error) {
+	fi, err := os.Stat(mp)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	m, err := zoekt.ReadShardManifest(mp)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	keep := make(map[string]bool, len(m.Shards))
+	for name, id := range m.Shards {
+		fn := filepath.Join(s.dir, name)
+		mtime, ok := ts[fn]
+		if !ok {
+			return nil, fi.ModTime(), fmt.Errorf("shard %s is missing", name)
+		}
+		if got := s.shardID(fn, mtime); got != id {
+			return nil, fi.ModTime(), fmt.Errorf("shard %s has ID %q, want %q", name, got, id)
+		}
+		keep[name] = true
+	}
+
+	for fn, mtime := range ts {
+		if zoekt.ShardManifestPath(fn) == mp && !keep[filepath.Base(fn)] && mtime.After(fi.ModTime()) {
+			return nil, fi.ModTime(), fmt.Errorf("shard %s is newer than the manifest", filepath.Base(fn))
+		}
+	}
+	return keep, fi.ModTime(), nil
+}
+
+// shardID returns the ID in the index metadata of the shard at fn, which was
+// modified at mtime.
+func (s *DirectoryWatcher) shardID(fn string, mtime time.Time) string {
+	if c, ok := s.shardIDs[fn]; ok && c.mtime.Equal(mtime) {
+		return c.id
+	}
+	_, md, err := zoekt.ReadMetadataPath(fn)
+	if err != nil {
+		return ""
+	}
+	s.shardIDs[fn] = shardID{mtime: mtime, id: md.ID}
+	return md.ID
+}
+
 func humanTruncateList(paths []string, max int) string {
 	sort.Strings(paths)
 	var b strings.Builder
@@ -232,7 +419,7 @@ func (s *DirectoryWatcher) watch() error {
 			case event := <-watcher.Events:
 				// Only notify if a file we read in has changed. This is important to
 				// avoid all the events writing to temporary files.
-				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") {
+				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") || strings.HasSuffix(event.Name, ".manifest") {
 					notify()
 				}
 
@@ -245,6 +432,7 @@ func (s *DirectoryWatcher) watch() error {
 				// safe to ignore.
 				if err != nil && err != fsnotify.ErrEventOverflow {
 					log.Println("[ERROR] watcher error:", err)
+					s.setError(err)
 				}
 
 			case <-s.quit:
@@ -261,6 +449,7 @@ func (s *DirectoryWatcher) watch() error {
 		for range signal {
 			if err := s.scan(); err != nil {
 				log.Println("[ERROR] watcher error:", err)
+				s.setError(err)
 			}
 		}
 	}()
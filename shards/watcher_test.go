@@ -15,12 +15,15 @@
 package shards
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/sourcegraph/zoekt"
 )
 
@@ -41,6 +44,11 @@ func (l *loggingLoader) drop(keys ...string) {
 	}
 }
 
+func (l *loggingLoader) swap(load, drop []string) {
+	l.load(load...)
+	l.drop(drop...)
+}
+
 func advanceFS() {
 	time.Sleep(10 * time.Millisecond)
 }
@@ -236,3 +244,177 @@ func TestHumanTruncateList(t *testing.T) {
 	assert(4, "1, 2, 3, 4")
 	assert(5, "1, 2, 3, 4")
 }
+
+func TestDirWatcherManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+
+	shard := func(i int) string {
+		return filepath.Join(dir, fmt.Sprintf("repo_v16.%05d.zoekt", i))
+	}
+	writeShard := func(i int, id string) {
+		b := testIndexBuilder(t, &zoekt.Repository{Name: "repo"}, zoekt.Document{Name: "f", Content: []byte(id)})
+		b.ID = id
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		// Write and rename, so the watcher never sees a partial shard.
+		tmp := shard(i) + ".tmp"
+		if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, shard(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeManifest := func(id string) {
+		m := &zoekt.ShardManifest{Shards: map[string]string{
+			filepath.Base(shard(0)): id,
+			filepath.Base(shard(1)): id,
+		}}
+		if err := zoekt.WriteShardManifest(zoekt.ShardManifestPath(shard(0)), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantLoads := func(want ...string) {
+		t.Helper()
+		got := map[string]bool{}
+		for range want {
+			got[<-logger.loads] = true
+		}
+		for _, fn := range want {
+			if !got[fn] {
+				t.Fatalf("got loads %v, want %v", got, want)
+			}
+		}
+	}
+	wantNothing := func() {
+		t.Helper()
+		select {
+		case k := <-logger.loads:
+			t.Fatalf("spurious load of %q", k)
+		case k := <-logger.drops:
+			t.Fatalf("spurious drop of %q", k)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// A stale shard from an earlier build which is older than the manifest.
+	writeShard(2, "old")
+	advanceFS()
+	writeShard(0, "a")
+	writeShard(1, "a")
+	advanceFS()
+	writeManifest("a")
+
+	dw, err := newDirectoryWatcher(dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Stop()
+
+	wantLoads(shard(0), shard(1))
+	wantNothing()
+
+	// The first shard of a new build is visible, but the set must only be
+	// swapped in once it matches the manifest.
+	advanceFS()
+	writeShard(0, "b")
+	wantNothing()
+
+	advanceFS()
+	writeShard(1, "b")
+	wantNothing()
+
+	advanceFS()
+	writeManifest("b")
+	wantLoads(shard(0), shard(1))
+
+	// A shard deleted behind the back of the builder is dropped, even
+	// though the set no longer matches its manifest.
+	if err := os.Remove(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-logger.drops; got != shard(1) {
+		t.Fatalf("got drop of %q, want %q", got, shard(1))
+	}
+	wantNothing()
+
+	// Once the manifest is too old to be waiting for a build, the shards on
+	// disk are loaded as if there was no manifest.
+	past := time.Now().Add(-2 * manifestGrace)
+	if err := os.Chtimes(zoekt.ShardManifestPath(shard(0)), past, past); err != nil {
+		t.Fatal(err)
+	}
+	wantLoads(shard(2))
+	wantNothing()
+}
+
+func TestPruneShardManifest(t *testing.T) {
+	dir := t.TempDir()
+	shard := func(i int) string {
+		return filepath.Join(dir, fmt.Sprintf("repo_v16.%05d.zoekt", i))
+	}
+	mp := zoekt.ShardManifestPath(shard(0))
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(shard(i), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := &zoekt.ShardManifest{
+		Shards:      map[string]string{filepath.Base(shard(0)): "a", filepath.Base(shard(1)): "a"},
+		Directories: map[string]string{filepath.Base(shard(0)): "", filepath.Base(shard(1)): "src"},
+	}
+	if err := zoekt.WriteShardManifest(mp, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zoekt.PruneShardManifest(shard(1)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := zoekt.ReadShardManifest(mp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.ShardManifest{
+		Shards:      map[string]string{filepath.Base(shard(0)): "a"},
+		Directories: map[string]string{filepath.Base(shard(0)): ""},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", d)
+	}
+
+	// The manifest goes with the last shard.
+	if err := os.Remove(shard(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zoekt.PruneShardManifest(shard(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(mp); !os.IsNotExist(err) {
+		t.Fatalf("manifest still exists: %v", err)
+	}
+}
+
+func TestShardManifestPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"dir/repo_v16.00000.zoekt":         "dir/repo_v16.manifest",
+		"dir/repo_v16.00012.zoekt":         "dir/repo_v16.manifest",
+		"dir/compound-abc_v16.00000.zoekt": "dir/compound-abc_v16.manifest",
+		"dir/repo_v16.zoekt":               "",
+		"dir/repo_v16.0000a.zoekt":         "",
+		"dir/repo_v16.00000.zoekt.meta":    "",
+	} {
+		if got := zoekt.ShardManifestPath(in); got != want {
+			t.Errorf("ShardManifestPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
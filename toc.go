@@ -45,6 +45,18 @@ const IndexFormatVersion = 16
 // 12: go-enry for identifying file languages
 const FeatureVersion = 12
 
+// ReadFeatureVersion is the highest IndexMinReaderVersion of files this reader
+// can load. It is increased instead of FeatureVersion for features which only
+// some files use, so that files without them aren't reindexed. It is never
+// below FeatureVersion.
+// 13: ngram sizes other than 3, see IndexMetadata.NgramSize
+const ReadFeatureVersion = 13
+
+// NgramSizeFeatureVersion is the minimum reader version of shards with an
+// ngram size other than 3. Older readers ignore IndexMetadata.NgramSize and
+// would look up trigrams in them.
+const NgramSizeFeatureVersion = 13
+
 // WriteMinFeatureVersion and ReadMinFeatureVersion constrain forwards and backwards
 // compatibility. For example, if a new way to encode filenameNgrams on disk is
 // added using a new section but the old one is retained, this would only bump
@@ -99,6 +111,33 @@ type indexTOC struct {
 	repos simpleSection
 
 	ranks simpleSection
+
+	dependencies compoundSection
+
+	fileMetrics simpleSection
+
+	generated simpleSection
+
+	secrets compoundSection
+
+	encodings compoundSection
+
+	contentGramBloom simpleSection
+
+	// planStats holds the ShardStats of the shard as JSON.
+	planStats simpleSection
+
+	// sectionChecksums holds the checksums of the other sections, see
+	// encodeSectionChecksums. It is written last.
+	sectionChecksums simpleSection
+
+	// backfilled holds the sections appended to the shard by Backfill, keyed
+	// by generator name. They are not part of sectionsTaggedList.
+	backfilled map[string]*simpleSection
+
+	// carried holds the sections copied from the shard being upgraded, see
+	// Upgrade. They are only written.
+	carried []taggedSection
 }
 
 func (t *indexTOC) sections() []section {
@@ -150,6 +189,9 @@ func (t *indexTOC) sectionsTagged() map[string]section {
 	for _, ent := range t.sectionsTaggedCompatibilityList() {
 		out[ent.tag] = ent.sec
 	}
+	for _, ent := range t.sectionsTaggedOptionalList() {
+		out[ent.tag] = ent.sec
+	}
 	return out
 }
 
@@ -190,6 +232,22 @@ func (t *indexTOC) sectionsTaggedList() []taggedSection {
 	}
 }
 
+// sectionsTaggedOptionalList returns sections which are only written if the
+// shard has data for them, so shards that don't use them stay identical to
+// shards written before the sections existed.
+func (t *indexTOC) sectionsTaggedOptionalList() []taggedSection {
+	return []taggedSection{
+		{"dependencies", &t.dependencies},
+		{"fileMetrics", &t.fileMetrics},
+		{"generated", &t.generated},
+		{"secrets", &t.secrets},
+		{"encodings", &t.encodings},
+		{"contentGramBloom", &t.contentGramBloom},
+		{"planStats", &t.planStats},
+		{"sectionChecksums", &t.sectionChecksums},
+	}
+}
+
 // sectionsTaggedCompatibilityList returns a list of sections that will be
 // handled or converted for backwards compatiblity, but aren't written by
 // the current iteration of the indexer.
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var mockRepos []*Repository
@@ -48,7 +49,111 @@ func setTombstone(shardPath string, repoID uint32, tombstone bool) error {
 		os.Remove(tempPath)
 	}
 
-	return nil
+	return err
+}
+
+// TombstoneRepo sets a tombstone for repoID in the compound shards in
+// indexDir which contain the repository. The shards are not rewritten, the
+// tombstone is stored in their .meta file, which searchers watching indexDir
+// pick up right away. It returns the paths of the shards it changed. The error
+// wraps os.ErrNotExist if no compound shard contains the repository.
+func TombstoneRepo(indexDir string, repoID uint32) ([]string, error) {
+	shards, err := compoundShardsWithRepo(indexDir, repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, s := range shards {
+		if s.repo.Tombstone {
+			continue
+		}
+		if err := SetTombstone(s.path, repoID); err != nil {
+			return changed, err
+		}
+		changed = append(changed, s.path)
+	}
+	return changed, nil
+}
+
+// ResurrectRepo removes the tombstone of repoID from a compound shard in
+// indexDir. If the repository is tombstoned in several compound shards, the
+// shard with the latest commit of the repository wins. Nothing is changed if
+// the repository is searchable already. It returns the paths of the shards it
+// changed. The error wraps os.ErrNotExist if no compound shard contains the
+// repository.
+func ResurrectRepo(indexDir string, repoID uint32) ([]string, error) {
+	shards, err := compoundShardsWithRepo(indexDir, repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *repoShard
+	for i, s := range shards {
+		if !s.repo.Tombstone {
+			return nil, nil
+		}
+		if latest == nil || s.repo.LatestCommitDate.After(latest.repo.LatestCommitDate) {
+			latest = &shards[i]
+		}
+	}
+
+	// A simple shard of the repository may have been written after it was
+	// tombstoned. Resurrecting it would duplicate the results.
+	simple, err := filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range simple {
+		if strings.HasPrefix(filepath.Base(p), "compound-") {
+			continue
+		}
+		repos, _, err := ReadMetadataPathAlive(p)
+		if err != nil {
+			continue
+		}
+		for _, r := range repos {
+			if r.ID == repoID {
+				return nil, nil
+			}
+		}
+	}
+
+	if err := UnsetTombstone(latest.path, repoID); err != nil {
+		return nil, err
+	}
+	return []string{latest.path}, nil
+}
+
+type repoShard struct {
+	path string
+	repo *Repository
+}
+
+// compoundShardsWithRepo returns the compound shards in indexDir which
+// contain repoID, tombstoned or not.
+func compoundShardsWithRepo(indexDir string, repoID uint32) ([]repoShard, error) {
+	paths, err := filepath.Glob(filepath.Join(indexDir, "compound-*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+
+	var shards []repoShard
+	for _, p := range paths {
+		repos, _, err := ReadMetadataPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		for _, r := range repos {
+			if r.ID == repoID {
+				shards = append(shards, repoShard{path: p, repo: r})
+			}
+		}
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("repository %d is not in a compound shard in %s: %w", repoID, indexDir, os.ErrNotExist)
+	}
+	return shards, nil
 }
 
 // JsonMarshalRepoMetaTemp writes the json encoding of the given repository metadata to a temporary file
@@ -1,9 +1,12 @@
 package zoekt
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -51,6 +54,73 @@ func TestSetTombstone(t *testing.T) {
 	isAlive([]bool{false, true, true})
 }
 
+func TestTombstoneRepo(t *testing.T) {
+	mockRepos = nil
+	dir := t.TempDir()
+
+	writeShard := func(fn string, b *IndexBuilder) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := Document{Name: "f", Content: []byte("needle")}
+	compound := filepath.Join(dir, "compound-test.zoekt")
+	writeShard(compound, testIndexBuilderCompound(t,
+		[]*Repository{{ID: 1, Name: "r1"}, {ID: 2, Name: "r2"}},
+		[][]Document{{doc}, {doc}}))
+
+	alive := func() map[uint32]bool {
+		t.Helper()
+		repos, _, err := ReadMetadataPath(compound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := map[uint32]bool{}
+		for _, r := range repos {
+			m[r.ID] = !r.Tombstone
+		}
+		return m
+	}
+	check := func(changed []string, err error, wantChanged int, want map[uint32]bool) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changed) != wantChanged {
+			t.Fatalf("changed %v, want %d shards", changed, wantChanged)
+		}
+		if got := alive(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got alive %v, want %v", got, want)
+		}
+	}
+
+	changed, err := TombstoneRepo(dir, 2)
+	check(changed, err, 1, map[uint32]bool{1: true, 2: false})
+
+	// Idempotent.
+	changed, err = TombstoneRepo(dir, 2)
+	check(changed, err, 0, map[uint32]bool{1: true, 2: false})
+
+	changed, err = ResurrectRepo(dir, 2)
+	check(changed, err, 1, map[uint32]bool{1: true, 2: true})
+
+	// A repository which was reindexed into a simple shard is not resurrected.
+	changed, err = TombstoneRepo(dir, 1)
+	check(changed, err, 1, map[uint32]bool{1: false, 2: true})
+	writeShard(filepath.Join(dir, "r1_v16.00000.zoekt"), testIndexBuilder(t, &Repository{ID: 1, Name: "r1"}, doc))
+	changed, err = ResurrectRepo(dir, 1)
+	check(changed, err, 0, map[uint32]bool{1: false, 2: true})
+
+	if _, err := TombstoneRepo(dir, 3); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want os.ErrNotExist for a repository which is not in a compound shard", err)
+	}
+}
+
 func mkRepos(repoNames ...string) []*Repository {
 	ret := make([]*Repository, 0, len(repoNames))
 	for i, n := range repoNames {
@@ -0,0 +1,207 @@
+package zoekt
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// reShardVersion matches the version and shard number in the name of a shard,
+// see ShardName.
+var reShardVersion = regexp.MustCompile(`_v[0-9]+\.([0-9]{5})\.zoekt$`)
+
+// NeedsUpgrade returns true if a shard with metadata md is not written in the
+// current index format, see Upgrade.
+func NeedsUpgrade(md *IndexMetadata) bool {
+	if md.IndexFormatVersion != IndexFormatVersion && md.IndexFormatVersion != NextIndexFormatVersion {
+		return true
+	}
+	return md.IndexFeatureVersion < FeatureVersion
+}
+
+// Upgrade rewrites the shard f in the current index format into dstDir,
+// without reindexing the content from the source. Documents, symbols,
+// branches and the metadata of repositories and of the shard are kept, in
+// the same order and including tombstoned repositories, so a ".meta" file
+// of f also fits the new shard. Sections the builder doesn't write, like
+// those appended by Backfill or written by a newer version, are copied
+// verbatim. Simple shards stay simple shards of version IndexFormatVersion,
+// and compound shards become compound shards of version
+// NextIndexFormatVersion.
+//
+// The name of the new shard is the name of f with the new version. Upgrade
+// returns tmpName and dstName. It is the responsibility of the caller to
+// rename tmpName to dstName, and to delete f if its name differs.
+func Upgrade(dstDir string, f IndexFile) (tmpName, dstName string, _ error) {
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		return "", "", err
+	}
+	d := searcher.(*indexData)
+
+	base := filepath.Base(f.Name())
+	m := reShardVersion.FindStringSubmatchIndex(base)
+	if m == nil {
+		return "", "", fmt.Errorf("%s: not a shard name", f.Name())
+	}
+
+	ib, err := upgrade(d)
+	if err != nil {
+		return "", "", err
+	}
+	if ib.carried, err = readCarriedSections(f); err != nil {
+		return "", "", err
+	}
+
+	dstName = filepath.Join(dstDir, fmt.Sprintf("%s_v%d.%s.zoekt", base[:m[0]], ib.indexFormatVersion, base[m[2]:m[3]]))
+	tmpName = dstName + ".tmp"
+	if err := builderWriteAll(tmpName, ib); err != nil {
+		return "", "", err
+	}
+	return tmpName, dstName, nil
+}
+
+func upgrade(d *indexData) (*IndexBuilder, error) {
+	ib := newIndexBuilder()
+	ib.indexFormatVersion = IndexFormatVersion
+	if d.metaData.IndexFormatVersion >= NextIndexFormatVersion {
+		ib.indexFormatVersion = NextIndexFormatVersion
+	}
+	if err := ib.SetNgramSize(d.shardNgramSize()); err != nil {
+		return nil, err
+	}
+	ib.FileMetrics = d.fileMetrics.sz > 0
+	ib.BloomFilter = d.contentBloom != nil
+	ib.SymbolsOnly = d.metaData.SymbolsOnly
+	// Shards built without scanning for secrets stay that way.
+	ib.DisableSecrets = len(d.secretsIndex) == 0
+	ib.IndexTime = d.metaData.IndexTime
+	ib.ID = d.metaData.ID
+
+	// Unlike merge, we also keep repositories without documents, so we add
+	// every repository up to the one of the next document.
+	lastRepoID := -1
+	addReposUpTo := func(repoID int) error {
+		for ; lastRepoID < repoID; lastRepoID++ {
+			if err := ib.setRepository(&d.repoMetaData[lastRepoID+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for docID := uint32(0); int(docID) < len(d.fileBranchMasks); docID++ {
+		repoID := int(d.repos[docID])
+		if repoID < lastRepoID {
+			return nil, fmt.Errorf("non-contiguous repo ids in %s for document %d: old=%d current=%d", d.String(), docID, lastRepoID, repoID)
+		}
+		if err := addReposUpTo(repoID); err != nil {
+			return nil, err
+		}
+		if err := addDocument(d, ib, repoID, docID); err != nil {
+			return nil, err
+		}
+	}
+	if err := addReposUpTo(len(d.repoMetaData) - 1); err != nil {
+		return nil, err
+	}
+	return ib, nil
+}
+
+// carriedSection is a section of a shard which IndexBuilder doesn't write
+// itself, see Upgrade.
+type carriedSection struct {
+	tag  string
+	kind sectionKind
+	// items holds the content of a simple section, or the items of a
+	// compound section.
+	items [][]byte
+}
+
+// readCarriedSections returns the sections of f whose tags we don't know,
+// including those appended by Backfill.
+func readCarriedSections(f IndexFile) ([]carriedSection, error) {
+	rd := &reader{r: f}
+	tocSection, sectionCount, err := rd.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if sectionCount != 0 {
+		// Shards without tagged sections only have the sections we know.
+		return nil, nil
+	}
+
+	known := (&indexTOC{}).sectionsTagged()
+	var carried []carriedSection
+	for rd.off < tocSection.off+tocSection.sz {
+		tag, err := rd.Str()
+		if err != nil {
+			return nil, err
+		}
+		kind, err := rd.Varint()
+		if err != nil {
+			return nil, err
+		}
+
+		// Compound sections, lazy or not, are a data and an index range.
+		var data, index simpleSection
+		switch sectionKind(kind) {
+		case sectionKindSimple:
+			err = data.read(rd)
+		case sectionKindCompound, sectionKindCompoundLazy:
+			if err = data.read(rd); err == nil {
+				err = index.read(rd)
+			}
+		default:
+			return nil, fmt.Errorf("unknown section kind %d", kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := known[tag]; ok {
+			continue
+		}
+
+		c := carriedSection{tag: tag, kind: sectionKind(kind)}
+		blob, err := f.Read(data.off, data.sz)
+		if err != nil {
+			return nil, err
+		}
+		if c.kind == sectionKindSimple {
+			c.items = [][]byte{blob}
+		} else {
+			sec := compoundSection{data: data, index: index}
+			if sec.offsets, err = readSectionU32(f, index); err != nil {
+				return nil, err
+			}
+			ri := sec.relativeIndex()
+			for i := 0; i+1 < len(ri); i++ {
+				c.items = append(c.items, blob[ri[i]:ri[i+1]])
+			}
+		}
+		carried = append(carried, c)
+	}
+	return carried, nil
+}
+
+// write writes the content of c and returns its section.
+func (c *carriedSection) write(w *writer) section {
+	if c.kind == sectionKindSimple {
+		var sec simpleSection
+		sec.start(w)
+		w.Write(c.items[0])
+		sec.end(w)
+		return &sec
+	}
+
+	var sec compoundSection
+	sec.start(w)
+	for _, item := range c.items {
+		sec.addItem(w, item)
+	}
+	sec.end(w)
+	if c.kind == sectionKindCompoundLazy {
+		return &lazyCompoundSection{compoundSection: sec}
+	}
+	return &sec
+}
@@ -0,0 +1,168 @@
+package zoekt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	old := openTestIndexFile(t, "testdata/backcompat/static_toc_v16.00000.zoekt")
+	_, oldMD, err := ReadMetadata(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsUpgrade(oldMD) {
+		t.Fatalf("feature version %d does not need an upgrade", oldMD.IndexFeatureVersion)
+	}
+
+	tmpName, dstName, err := Upgrade(dir, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "static_toc_v16.00000.zoekt"); dstName != want {
+		t.Fatalf("got dstName %q, want %q", dstName, want)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+
+	f := openTestIndexFile(t, dstName)
+	rd := &reader{r: f}
+	if _, sectionCount, err := rd.readHeader(); err != nil || sectionCount != 0 {
+		t.Fatalf("got %d sections and error %v, want a tagged TOC", sectionCount, err)
+	}
+	_, md, err := ReadMetadata(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsUpgrade(md) || !md.IndexTime.Equal(oldMD.IndexTime) || md.ID != oldMD.ID {
+		t.Errorf("got metadata %+v, want current version with the time and ID of %+v", md, oldMD)
+	}
+
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := searcher.(*indexData)
+	content, err := d.readContents(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.fileBranchMasks) != 1 || string(d.fileName(0)) != "filename" || string(content) != "abcde" {
+		t.Errorf("got %d documents, first is %q with content %q", len(d.fileBranchMasks), d.fileName(0), content)
+	}
+}
+
+func TestUpgradeCompound(t *testing.T) {
+	dir := t.TempDir()
+	tmpName, dstName, err := Merge(dir,
+		openTestIndexFile(t, "testdata/shards/repo_v16.00000.zoekt"),
+		openTestIndexFile(t, "testdata/shards/repo2_v16.00000.zoekt"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tombstoned repositories are kept, so the .meta file still fits.
+	repos, _, err := ReadMetadataPath(dstName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos[1].Tombstone = true
+	tmpMeta, meta, err := JsonMarshalRepoMetaTemp(dstName, repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpMeta, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	upgradeDir := t.TempDir()
+	tmpName, upgradedName, err := Upgrade(upgradeDir, openTestIndexFile(t, dstName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(upgradeDir, filepath.Base(dstName)); upgradedName != want {
+		t.Fatalf("got dstName %q, want %q", upgradedName, want)
+	}
+	if err := os.Rename(tmpName, upgradedName); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded, md, err := ReadMetadata(openTestIndexFile(t, upgradedName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.IndexFormatVersion != NextIndexFormatVersion || len(upgraded) != 2 || upgraded[0].Name != repos[0].Name || !upgraded[1].Tombstone {
+		t.Fatalf("got version %d with repos %+v, want a compound shard with %s and a tombstoned %s", md.IndexFormatVersion, upgraded, repos[0].Name, repos[1].Name)
+	}
+}
+
+func TestUpgradeCarriesSections(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "repo_v16.00000.zoekt")
+	b := testIndexBuilder(t, &Repository{Name: "repo"},
+		Document{Name: "f1", Content: []byte("needle haystack")},
+		Document{Name: "f2", Content: []byte("hay")},
+	)
+	// A compound section written by a newer version of zoekt.
+	b.carried = []carriedSection{{tag: "future", kind: sectionKindCompound, items: [][]byte{[]byte("a"), nil, []byte("bc")}}}
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpName, dstName, err := Backfill(openTestIndexFile(t, fn), docCountGenerator{name: "doccount"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+	old := openTestIndexFile(t, fn)
+	want, err := ReadBackfilledSection(old, "doccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgradeDir := t.TempDir()
+	tmpName, dstName, err = Upgrade(upgradeDir, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+
+	f := openTestIndexFile(t, dstName)
+	got, err := ReadBackfilledSection(f, "doccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 || !bytes.Equal(got, want) {
+		t.Errorf("got backfilled section %q, want %q", got, want)
+	}
+
+	carried, err := readCarriedSections(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCarried := []carriedSection{
+		{tag: "future", kind: sectionKindCompound, items: [][]byte{[]byte("a"), {}, []byte("bc")}},
+		{tag: backfillSectionPrefix + "doccount", kind: sectionKindSimple, items: [][]byte{want}},
+	}
+	if d := cmp.Diff(wantCarried, carried, cmp.AllowUnexported(carriedSection{})); d != "" {
+		t.Errorf("carried sections mismatch (-want +got):\n%s", d)
+	}
+}
@@ -0,0 +1,261 @@
+package zoekt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"os"
+	"strings"
+)
+
+// castagnoliTable is the table of the section checksums. CRC-32C is
+// computed in hardware on most CPUs, so checksumming adds little to the time
+// it takes to write a shard.
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// sectionRanges returns the byte ranges which make up sec.
+func sectionRanges(sec section) []simpleSection {
+	switch s := sec.(type) {
+	case *simpleSection:
+		return []simpleSection{*s}
+	case *compoundSection:
+		return []simpleSection{s.data, s.index}
+	case *lazyCompoundSection:
+		return []simpleSection{s.data, s.index}
+	}
+	return nil
+}
+
+// sectionChecksum holds the checksums of the byte ranges of a section, see
+// sectionRanges.
+type sectionChecksum struct {
+	tag  string
+	crcs []uint32
+}
+
+// encodeSectionChecksums returns the sectionChecksums section for secs. sums
+// holds the checksums of the byte ranges computed while writing them. For
+// every section with data, the encoding holds its tag, the number of its byte
+// ranges and their CRC-32C checksums.
+func encodeSectionChecksums(secs []taggedSection, sums map[simpleSection]uint32) []byte {
+	var buf []byte
+	for _, s := range secs {
+		ranges := sectionRanges(s.sec)
+		var sz uint32
+		for _, r := range ranges {
+			sz += r.sz
+		}
+		if sz == 0 {
+			continue
+		}
+
+		crcs := make([]uint32, 0, len(ranges))
+		for _, r := range ranges {
+			if crc, ok := sums[r]; ok {
+				crcs = append(crcs, crc)
+			}
+		}
+		if len(crcs) != len(ranges) {
+			continue
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(len(s.tag)))
+		buf = append(buf, s.tag...)
+		buf = binary.AppendUvarint(buf, uint64(len(crcs)))
+		for _, crc := range crcs {
+			buf = binary.BigEndian.AppendUint32(buf, crc)
+		}
+	}
+	return buf
+}
+
+func decodeSectionChecksums(b []byte) ([]sectionChecksum, error) {
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errors.New("sectionChecksums: bad varint")
+		}
+		b = b[n:]
+		return v, nil
+	}
+
+	var out []sectionChecksum
+	for len(b) > 0 {
+		n, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(b)) {
+			return nil, errors.New("sectionChecksums: tag out of bounds")
+		}
+		sum := sectionChecksum{tag: string(b[:n])}
+		b = b[n:]
+
+		if n, err = uvarint(); err != nil {
+			return nil, err
+		}
+		if n*4 > uint64(len(b)) {
+			return nil, fmt.Errorf("sectionChecksums: checksums of %s out of bounds", sum.tag)
+		}
+		for i := uint64(0); i < n; i++ {
+			sum.crcs = append(sum.crcs, binary.BigEndian.Uint32(b))
+			b = b[4:]
+		}
+		out = append(out, sum)
+	}
+	return out, nil
+}
+
+// VerifyOptions configures VerifyShard.
+type VerifyOptions struct {
+	// SourceContent, if set, returns the content of the file name on branch
+	// of repo in the source repository, or false if there is no such file.
+	// VerifyShard reports documents which differ from it. Documents which
+	// were skipped or transcoded at index time, and the documents of
+	// symbol-only shards, are not compared.
+	SourceContent func(repo *Repository, branch, name string) ([]byte, bool, error)
+}
+
+// VerifyShard checks the shard at path for corruption. It compares the
+// sections of the shard with their checksums, loads the shard, and checks
+// every document against its content checksum. Shards written before section
+// checksums existed only get the latter checks.
+//
+// It returns an error listing all problems found, or nil if there are none.
+func VerifyShard(path string, opts VerifyOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	indexFile, err := NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+
+	errs := verifySectionChecksums(indexFile)
+	errs = append(errs, verifyDocuments(indexFile, opts)...)
+	return errors.Join(errs...)
+}
+
+func verifySectionChecksums(f IndexFile) []error {
+	var toc indexTOC
+	rd := &reader{r: f}
+	if err := rd.readTOC(&toc); err != nil {
+		return []error{fmt.Errorf("reading TOC: %w", err)}
+	}
+	if toc.sectionChecksums.sz == 0 {
+		return nil
+	}
+
+	blob, err := f.Read(toc.sectionChecksums.off, toc.sectionChecksums.sz)
+	if err != nil {
+		return []error{err}
+	}
+	sums, err := decodeSectionChecksums(blob)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	secs := toc.sectionsTagged()
+	for _, sum := range sums {
+		sec, ok := secs[sum.tag]
+		if name, backfilled := strings.CutPrefix(sum.tag, backfillSectionPrefix); backfilled && !ok {
+			var bs *simpleSection
+			bs, ok = toc.backfilled[name]
+			sec = bs
+		}
+		if !ok {
+			// Written by a newer version.
+			continue
+		}
+		ranges := sectionRanges(sec)
+		if len(ranges) != len(sum.crcs) {
+			errs = append(errs, fmt.Errorf("section %s: got %d checksums, want %d", sum.tag, len(sum.crcs), len(ranges)))
+			continue
+		}
+		for i, r := range ranges {
+			b, err := f.Read(r.off, r.sz)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("section %s: %w", sum.tag, err))
+				break
+			}
+			if crc := crc32.Checksum(b, castagnoliTable); crc != sum.crcs[i] {
+				errs = append(errs, fmt.Errorf("section %s: checksum mismatch, got %08x, want %08x", sum.tag, crc, sum.crcs[i]))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func verifyDocuments(f IndexFile, opts VerifyOptions) (errs []error) {
+	// Corrupt shards can trip assumptions of the reader, which are not
+	// checked while searching.
+	defer func() {
+		if r := recover(); r != nil {
+			errs = append(errs, fmt.Errorf("reading documents: %v", r))
+		}
+	}()
+
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		return []error{fmt.Errorf("loading shard: %w", err)}
+	}
+	d := searcher.(*indexData)
+
+	n := uint32(len(d.fileBranchMasks))
+	if len(d.repos) != int(n) || len(d.checksums) != int(n)*crc64.Size {
+		return []error{fmt.Errorf("got %d repos and %d checksums for %d documents", len(d.repos), len(d.checksums)/crc64.Size, n)}
+	}
+
+	table := crc64.MakeTable(crc64.ISO)
+	for docID := uint32(0); docID < n; docID++ {
+		name := string(d.fileName(docID))
+		docErr := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("document %d (%s): %s", docID, name, fmt.Sprintf(format, args...)))
+		}
+
+		content, err := d.readContents(docID)
+		if err != nil {
+			docErr("%v", err)
+			continue
+		}
+		if crc := crc64.Checksum(content, table); binary.BigEndian.Uint64(d.getChecksum(docID)) != crc {
+			docErr("content checksum mismatch")
+		}
+
+		repoID := d.repos[docID]
+		if int(repoID) >= len(d.repoMetaData) {
+			docErr("unknown repository %d", repoID)
+			continue
+		}
+		repo := &d.repoMetaData[repoID]
+		mask := d.fileBranchMasks[docID]
+		if mask>>len(repo.Branches) != 0 {
+			docErr("branch mask %b has unknown branches", mask)
+			continue
+		}
+
+		if opts.SourceContent == nil || mask == 0 || d.metaData.SymbolsOnly || bytes.HasPrefix(content, []byte(notIndexedMarker)) {
+			continue
+		}
+		if enc, err := d.readEncoding(docID); err != nil || enc != "" {
+			continue
+		}
+		branch := d.branchNames[repoID][uint(mask&-mask)]
+		source, ok, err := opts.SourceContent(repo, branch, name)
+		if err != nil {
+			docErr("reading source: %v", err)
+		} else if !ok {
+			docErr("not found on branch %s of the source repository", branch)
+		} else if !bytes.Equal(source, content) {
+			docErr("differs from branch %s of the source repository", branch)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,89 @@
+package zoekt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyShard(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo", Branches: []RepositoryBranch{{Name: "main", Version: "v1"}}},
+		Document{Name: "f1", Content: []byte("needle haystack"), Branches: []string{"main"}},
+		Document{Name: "f2", Content: []byte("hay"), Branches: []string{"main"}},
+		Document{Name: "binary", Content: []byte("a\x00b"), Branches: []string{"main"}},
+	)
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	shard := buf.Bytes()
+
+	fn := filepath.Join(t.TempDir(), "repo_v16.00000.zoekt")
+	verify := func(shard []byte, opts VerifyOptions) error {
+		t.Helper()
+		if err := os.WriteFile(fn, shard, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return VerifyShard(fn, opts)
+	}
+
+	source := map[string]string{"f1": "needle haystack", "f2": "hay"}
+	opts := VerifyOptions{
+		SourceContent: func(repo *Repository, branch, name string) ([]byte, bool, error) {
+			if repo.Name != "repo" || branch != "main" {
+				t.Errorf("got repo %s and branch %s", repo.Name, branch)
+			}
+			c, ok := source[name]
+			return []byte(c), ok, nil
+		},
+	}
+	if err := verify(shard, opts); err != nil {
+		t.Fatalf("intact shard: %v", err)
+	}
+
+	// Documents which changed in the source repository are reported.
+	source["f2"] = "straw"
+	delete(source, "f1")
+	err := verify(shard, opts)
+	for _, want := range []string{"f1): not found on branch main", "f2): differs from branch main"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("changed source: got %v, want %q", err, want)
+		}
+	}
+
+	// Flip a bit of the content of f1, which is at the start of the shard.
+	corrupt := bytes.Clone(shard)
+	corrupt[0] ^= 1
+	err = verify(corrupt, VerifyOptions{})
+	for _, want := range []string{"section fileContents: checksum mismatch", "f1): content checksum mismatch"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("corrupt shard: got %v, want %q", err, want)
+		}
+	}
+}
+
+// The shards in testdata/shards verify, whether they were written before
+// section checksums existed, and have nothing to compare, or with them.
+func TestVerifyShardFixtures(t *testing.T) {
+	shards, err := filepath.Glob("testdata/shards/*.zoekt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range shards {
+		if err := VerifyShard(fn, VerifyOptions{}); err != nil {
+			t.Errorf("%s: %v", fn, err)
+		}
+	}
+
+	// Make sure the checksums are there to verify.
+	f := openTestIndexFile(t, "testdata/shards/repo_checksums_v16.00000.zoekt")
+	var toc indexTOC
+	if err := (&reader{r: f}).readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	if toc.sectionChecksums.sz == 0 {
+		t.Error("repo_checksums_v16.00000.zoekt has no section checksums")
+	}
+}
@@ -0,0 +1,69 @@
+package zoekt
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// warmSections are the sections Warm reads. They hold the content ngram
+// index and everything a search on file names reads. Of postings, only the
+// offsets of the posting lists are read, their data is too large.
+var warmSections = []string{"ngramText", "postings", "nameNgramText", "namePostings", "fileNames"}
+
+const (
+	// warmPageSize is the stride at which Warm touches mapped sections.
+	warmPageSize = 4096
+
+	// warmChunkSize is the size of the reads of Warm, which bounds the
+	// memory it allocates for shards read with pread.
+	warmChunkSize = 1 << 20
+)
+
+// Warm reads the ngram index and the file name sections of the shard s into
+// the page cache, so that the first searches after it is loaded don't wait
+// on storage. It returns the number of bytes read. Searchers which aren't
+// shards returned by NewSearcher are ignored.
+func Warm(s Searcher) (int64, error) {
+	d, ok := s.(*indexData)
+	if !ok || d.file == nil {
+		return 0, nil
+	}
+
+	rd := &reader{r: d.file}
+	var toc indexTOC
+	if err := rd.readTOCSections(&toc, warmSections); err != nil {
+		return 0, err
+	}
+
+	ranges := []simpleSection{
+		toc.ngramText,
+		toc.postings.index,
+		toc.nameNgramText,
+		toc.namePostings.data,
+		toc.namePostings.index,
+		toc.fileNames.data,
+		toc.fileNames.index,
+	}
+
+	var (
+		total int64
+		sink  byte
+	)
+	for _, sec := range ranges {
+		for start := uint32(0); start < sec.sz; start += warmChunkSize {
+			sz := min(sec.sz-start, warmChunkSize)
+			b, err := d.file.Read(sec.off+start, sz)
+			if err != nil {
+				return total, fmt.Errorf("warming %s: %w", d.file.Name(), err)
+			}
+			// Reads of mapped shards return the mapping, so we fault in
+			// its pages by touching one byte of each.
+			for i := 0; i < len(b); i += warmPageSize {
+				sink ^= b[i]
+			}
+			total += int64(sz)
+		}
+	}
+	runtime.KeepAlive(sink)
+	return total, nil
+}
@@ -0,0 +1,35 @@
+package zoekt
+
+import "testing"
+
+func TestWarm(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "dir/f2", Content: []byte("another needle")},
+	)
+	s := searcherForTest(t, b)
+	defer s.Close()
+
+	n, err := Warm(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := s.(*indexData)
+	rd := &reader{r: d.file}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	want := int64(toc.ngramText.sz + toc.postings.index.sz + toc.nameNgramText.sz +
+		toc.namePostings.data.sz + toc.namePostings.index.sz +
+		toc.fileNames.data.sz + toc.fileNames.index.sz)
+	if n != want || n == 0 {
+		t.Errorf("got %d bytes warmed, want %d", n, want)
+	}
+
+	// Other searchers are ignored.
+	if n, err := Warm(nil); n != 0 || err != nil {
+		t.Errorf("got %d, %v for a nil searcher", n, err)
+	}
+}
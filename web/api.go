@@ -30,11 +30,19 @@ type LastInput struct {
 	Num   int
 	Ctx   int
 
+	// If set, results are chunks of matching lines with Ctx lines of
+	// context, instead of single lines.
+	Chunks bool
+
 	// If set, focus on the search box.
 	AutoFocus bool
 
 	// If true, the next search will run in debug mode.
 	Debug bool
+
+	// Group is how the next search groups its results. The only grouping is
+	// "cluster", which groups results by repository cluster.
+	Group string
 }
 
 // Result holds the data provided to the search results template.
@@ -45,6 +53,19 @@ type ResultInput struct {
 	Stats       zoekt.Stats
 	Duration    time.Duration
 	FileMatches []*FileMatch
+
+	// Permalink is the canonical path and query of the search URL.
+	Permalink string
+
+	// Directories holds the results of type:dir queries.
+	Directories []zoekt.DirectoryMatch `json:",omitempty"`
+
+	// Clusters groups FileMatches by repository cluster, if requested with
+	// the group=cluster URL parameter.
+	Clusters []ResultCluster `json:",omitempty"`
+
+	// Facets counts FileMatches by language, repository and extension.
+	Facets *Facets `json:",omitempty"`
 }
 
 // FileMatch holds the per file data provided to search results template
@@ -61,6 +82,13 @@ type FileMatch struct {
 	Matches  []Match
 	URL      string
 
+	// Permalink is the canonical URL of the search, anchored at this file.
+	Permalink string
+
+	// Chunks holds the matches if the search asked for chunks. Matches is
+	// empty then.
+	Chunks []Chunk `json:",omitempty"`
+
 	// Don't expose to caller of JSON API
 	Score      float64 `json:"-"`
 	ScoreDebug string  `json:"-"`
@@ -81,6 +109,26 @@ type Match struct {
 	ScoreDebug string  `json:"-"`
 }
 
+// Chunk holds a range of lines with one or more matches for the results
+// template.
+type Chunk struct {
+	URL       string
+	FileName  string
+	StartLine int
+	Lines     []ChunkLine
+
+	// Don't expose to caller of JSON API
+	Score      float64 `json:"-"`
+	ScoreDebug string  `json:"-"`
+}
+
+// ChunkLine is a line of a chunk. Lines without matches have a single
+// fragment with just Post.
+type ChunkLine struct {
+	LineNum   int
+	Fragments []Fragment
+}
+
 // Fragment holds data of a single contiguous match within in a line
 // for the results template.
 type Fragment struct {
@@ -119,6 +167,9 @@ type Repository struct {
 	Branches  []Branch
 	Files     int64
 
+	// Metadata is the key/value metadata attached at index time.
+	Metadata map[string]string
+
 	// Total amount of content bytes.
 	Size int64
 	// Total resident RAM usage in bytes.
@@ -128,6 +179,44 @@ type Repository struct {
 // PrintInput is provided to the server.Print template.
 type PrintInput struct {
 	Repo, Name string
+	Branch     string
 	Lines      []string
 	Last       LastInput
+
+	// Crumbs are the directories containing the file.
+	Crumbs []Crumb
+}
+
+// BrowseInput is provided to the browse template.
+type BrowseInput struct {
+	Repo   string
+	Branch string
+
+	// Path is the browsed directory, empty for the root of the repository.
+	Path string
+
+	// Crumbs are the parent directories of Path.
+	Crumbs  []Crumb
+	Entries []BrowseEntry
+	Last    LastInput
+}
+
+// Crumb is a directory containing a browsed directory or file. The root of
+// the repository has an empty Name and Path.
+type Crumb struct {
+	Name string
+	Path string
+}
+
+// BrowseEntry is a file or subdirectory of a browsed directory.
+type BrowseEntry struct {
+	Name string
+	Path string
+	Dir  bool
+
+	// Files is the number of files below a directory.
+	Files int
+
+	// Language is the language of a file.
+	Language string
 }
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func (s *Server) serveBrowse(w http.ResponseWriter, r *http.Request) {
+	if err := s.serveBrowseErr(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+	}
+}
+
+// serveBrowseErr lists a directory of a repository, from the file names in
+// the index. The URL parameters are r for the repository, p for the directory
+// and optionally b for the branch.
+func (s *Server) serveBrowseErr(w http.ResponseWriter, r *http.Request) error {
+	qvals := r.URL.Query()
+	repoStr := qvals.Get("r")
+	branchStr := qvals.Get("b")
+	dir := strings.Trim(qvals.Get("p"), "/")
+
+	qs := []query.Q{query.NewRepoSet(repoStr)}
+	if dir != "" {
+		re, err := syntax.Parse("^"+regexp.QuoteMeta(dir+"/"), 0)
+		if err != nil {
+			return err
+		}
+		qs = append(qs, &query.Regexp{Regexp: re, FileName: true, CaseSensitive: true})
+	}
+	if branchStr != "" {
+		qs = append(qs, &query.Branch{Pattern: branchStr, Exact: true})
+	}
+
+	result, err := s.Searcher.Search(r.Context(), query.NewAnd(qs...), &zoekt.SearchOptions{})
+	if err != nil {
+		return err
+	}
+
+	d := BrowseInput{
+		Repo:    repoStr,
+		Branch:  branchStr,
+		Path:    dir,
+		Entries: listDirectory(result.Files, dir),
+	}
+	if dir != "" {
+		d.Crumbs = crumbs(path.Dir(dir))
+	}
+
+	var buf bytes.Buffer
+	if err := s.browse.Execute(&buf, &d); err != nil {
+		return err
+	}
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+// listDirectory returns the entries of dir, given the files below it.
+// Subdirectories come first, and both are sorted by name.
+func listDirectory(files []zoekt.FileMatch, dir string) []BrowseEntry {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	// A file with different content on several branches is one match per
+	// version.
+	seen := map[string]bool{}
+	entries := map[string]*BrowseEntry{}
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.FileName, prefix)
+		if !ok || rest == "" || seen[f.FileName] {
+			continue
+		}
+		seen[f.FileName] = true
+		name, _, isDir := strings.Cut(rest, "/")
+		e := entries[name]
+		if e == nil {
+			e = &BrowseEntry{Name: name, Path: prefix + name, Dir: isDir}
+			entries[name] = e
+		}
+		if isDir {
+			e.Files++
+		} else {
+			e.Language = f.Language
+		}
+	}
+
+	list := make([]BrowseEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Dir != list[j].Dir {
+			return list[i].Dir
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// crumbs returns the directory p and its parents, starting with the root of
+// the repository.
+func crumbs(p string) []Crumb {
+	var cs []Crumb
+	for ; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		cs = append(cs, Crumb{Name: path.Base(p), Path: p})
+	}
+	cs = append(cs, Crumb{})
+	for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+		cs[i], cs[j] = cs[j], cs[i]
+	}
+	return cs
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// clusterMetadataKey is the repository metadata key for operator supplied
+// clusters, eg. set with "zoekt-git-index -repo_meta cluster=payments".
+const clusterMetadataKey = "cluster"
+
+// ResultCluster is a group of related repositories in the search results.
+type ResultCluster struct {
+	Name string
+	// Repos are the repositories of the cluster with results, in the order of
+	// their best result.
+	Repos       []string
+	FileMatches []*FileMatch
+}
+
+// clusterRepos returns the cluster of each repository. A repository is in
+//
+//   - the cluster in its "cluster" metadata, if set;
+//   - else, for forks, the cluster of a non-fork repository with the same
+//     base name, which is presumably its upstream;
+//   - else the cluster named by the path of the repository, eg.
+//     "github.com/sourcegraph" for "github.com/sourcegraph/zoekt".
+func clusterRepos(repos []*zoekt.Repository) map[string]string {
+	clusters := make(map[string]string, len(repos))
+	ownCluster := func(r *zoekt.Repository) string {
+		if c := r.Metadata[clusterMetadataKey]; c != "" {
+			return c
+		}
+		if dir := path.Dir(r.Name); dir != "." {
+			return dir
+		}
+		return r.Name
+	}
+
+	// Sort for determinism if forks have several candidate upstreams.
+	sorted := append([]*zoekt.Repository{}, repos...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	upstreams := map[string]string{}
+	for _, r := range sorted {
+		if r.RawConfig["fork"] == "1" {
+			continue
+		}
+		if _, ok := upstreams[path.Base(r.Name)]; !ok {
+			upstreams[path.Base(r.Name)] = ownCluster(r)
+		}
+	}
+
+	for _, r := range sorted {
+		c := ownCluster(r)
+		if r.Metadata[clusterMetadataKey] == "" && r.RawConfig["fork"] == "1" {
+			if up, ok := upstreams[path.Base(r.Name)]; ok {
+				c = up
+			}
+		}
+		clusters[r.Name] = c
+	}
+	return clusters
+}
+
+// clusterResults groups fileMatches by the cluster of their repository.
+// Clusters are in the order of their best result.
+func (s *Server) clusterResults(ctx context.Context, fileMatches []*FileMatch) ([]ResultCluster, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, f := range fileMatches {
+		if !seen[f.Repo] {
+			seen[f.Repo] = true
+			names = append(names, f.Repo)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	rl, err := s.Searcher.List(ctx, query.NewRepoSet(names...), nil)
+	if err != nil {
+		return nil, err
+	}
+	var repos []*zoekt.Repository
+	for _, e := range rl.Repos {
+		repos = append(repos, &e.Repository)
+	}
+	repoCluster := clusterRepos(repos)
+
+	var clusters []ResultCluster
+	index := map[string]int{}
+	added := map[string]bool{}
+	for _, f := range fileMatches {
+		name, ok := repoCluster[f.Repo]
+		if !ok {
+			name = f.Repo
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(clusters)
+			index[name] = i
+			clusters = append(clusters, ResultCluster{Name: name})
+		}
+		c := &clusters[i]
+		if !added[f.Repo] {
+			added[f.Repo] = true
+			c.Repos = append(c.Repos, f.Repo)
+		}
+		c.FileMatches = append(c.FileMatches, f)
+	}
+	return clusters, nil
+}
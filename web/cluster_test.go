@@ -0,0 +1,84 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestClusterRepos(t *testing.T) {
+	repos := []*zoekt.Repository{
+		{Name: "github.com/sourcegraph/zoekt"},
+		{Name: "github.com/sourcegraph/sourcegraph"},
+		{Name: "github.com/alice/zoekt", RawConfig: map[string]string{"fork": "1"}},
+		{Name: "github.com/alice/dotfiles"},
+		{Name: "github.com/bob/tool", RawConfig: map[string]string{"fork": "1"}},
+		{Name: "github.com/acme/billing", Metadata: map[string]string{"cluster": "payments"}},
+		{Name: "gitlab.com/acme/ledger", Metadata: map[string]string{"cluster": "payments"}},
+		{Name: "standalone"},
+	}
+	want := map[string]string{
+		"github.com/sourcegraph/zoekt":       "github.com/sourcegraph",
+		"github.com/sourcegraph/sourcegraph": "github.com/sourcegraph",
+		"github.com/alice/zoekt":             "github.com/sourcegraph",
+		"github.com/alice/dotfiles":          "github.com/alice",
+		"github.com/bob/tool":                "github.com/bob",
+		"github.com/acme/billing":            "payments",
+		"gitlab.com/acme/ledger":             "payments",
+		"standalone":                         "standalone",
+	}
+	if d := cmp.Diff(want, clusterRepos(repos)); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestGroupByCluster(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name:     "github.com/acme/billing",
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+		Metadata: map[string]string{"cluster": "payments"},
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := b.Add(zoekt.Document{Name: name, Content: []byte("charge the card"), Branches: []string{"master"}}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	mux, err := NewMux(&Server{Searcher: searcherForTest(t, b), Top: Top, HTML: true})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/search?q=charge&format=json&group=cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var result ApiSearchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	clusters := result.Result.Clusters
+	if len(clusters) != 1 || clusters[0].Name != "payments" || len(clusters[0].FileMatches) != 2 ||
+		!cmp.Equal(clusters[0].Repos, []string{"github.com/acme/billing"}) {
+		t.Errorf("got clusters %+v", clusters)
+	}
+
+	// The HTML page shows the cluster.
+	checkNeedles(t, ts, "/search?q=charge&group=cluster", []string{"<h4>payments", `name="group" type="hidden" value="cluster"`})
+
+	if code := getHttpStatusCode(t, ts, "/search?q=charge&group=bogus"); code != http.StatusTeapot {
+		t.Errorf("got status %d for unknown grouping", code)
+	}
+}
@@ -23,6 +23,9 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -30,9 +33,12 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/auth"
 	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
 )
 
 // TODO(hanwen): cut & paste from ../ . Should create internal test
@@ -126,12 +132,15 @@ func TestBasic(t *testing.T) {
 		"/search?q=water": {
 			`href="https://github.com/org/repo/blob/1234/foo/bar%2Bbaz"`,
 			"carry <b>water</b>",
+			`<a href="/search?q=water" title="link to these results">`,
+			`zoektHistoryAdd("water", "/search?q=water")`,
 		},
 		"/search?q=r:": {
 			"1234\">master",
 			"Found 1 repositories",
 			nowStr,
-			"repo-url\">name",
+			"browse?r=name\">name",
+			"repo-url\">source",
 			"1 files (45B)",
 		},
 		"/search?q=magic": {
@@ -196,6 +205,66 @@ func TestPrint(t *testing.T) {
 		"/print?q=bla&r=name&f=f2": {
 			`pre id="l1" class="inline-pre"><span class="noselect"><a href="#l1">`,
 		},
+		"/print?r=name&f=dir/f2": {
+			`<a href="browse?r=name">name</a>`,
+			`<a href="browse?r=name&p=dir">dir</a>`,
+			`<li class="active"><b>f2</b></li>`,
+		},
+	} {
+		checkNeedles(t, ts, req, needles)
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	for _, name := range []string{"README.md", "cmd/main.go", "cmd/util/util.go", "lib/lib.go"} {
+		if err := b.Add(zoekt.Document{
+			Name:     name,
+			Content:  []byte("package main"),
+			Branches: []string{"master"},
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for req, needles := range map[string][]string{
+		"/browse?r=name": {
+			`<li class="active">name</li>`,
+			`<a href="browse?r=name&p=cmd">cmd/</a>`,
+			`2 files`,
+			`<a href="browse?r=name&p=lib">lib/</a>`,
+			`<a href="print?r=name&f=README.md">README.md</a>`,
+		},
+		"/browse?r=name&b=master&p=cmd/": {
+			`<a href="browse?r=name&b=master">name</a>`,
+			`<li class="active">cmd</li>`,
+			`<a href="browse?r=name&b=master&p=cmd%2futil">util/</a>`,
+			`<a href="print?r=name&b=master&f=cmd%2fmain.go">main.go</a>`,
+		},
+		"/browse?r=other": {
+			"No files.",
+		},
 	} {
 		checkNeedles(t, ts, req, needles)
 	}
@@ -696,6 +765,37 @@ func TestContextLinesMustBeValid(t *testing.T) {
 	}
 }
 
+func TestProfileRequiresAdmin(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{Name: "f2", Content: []byte("to carry water")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	mux, err := NewMux(&Server{Searcher: searcherForTest(t, b), Top: Top, HTML: true})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	var admin bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if admin {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Name: "root", Admin: true}))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	if code := getHttpStatusCode(t, ts, "/search?q=water&profile=1"); code != 418 {
+		t.Errorf("got %d for a profile of a client, want 418", code)
+	}
+	admin = true
+	if code := getHttpStatusCode(t, ts, "/search?q=water&profile=1"); code != 200 {
+		t.Errorf("got %d for a profile of an admin, want 200", code)
+	}
+}
+
 func getHttpStatusCode(t *testing.T, ts *httptest.Server, req string) int {
 	res, err := http.Get(ts.URL + req)
 	if err != nil {
@@ -960,6 +1060,85 @@ func TestHealthz(t *testing.T) {
 	if reflect.DeepEqual(result, zoekt.SearchResult{}) {
 		t.Fatal("empty result in response")
 	}
+
+	// Probes which didn't authenticate only get the status.
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokens, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := auth.New(auth.Options{TokensFile: tokens})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authTS := httptest.NewServer(a.Middleware(mux))
+	t.Cleanup(authTS.Close)
+
+	res, err = http.Get(authTS.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("json.Decode: %v", err)
+	}
+	if _, ok := body["Status"]; res.StatusCode != http.StatusOK || !ok || len(body) != 1 {
+		t.Errorf("got %d with fields %v for an unauthenticated probe, want 200 with only Status", res.StatusCode, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	status := shards.LoadStatus{Loaded: 1, Pending: 2}
+	srv := Server{
+		Searcher:   searcherForTest(t, b),
+		Top:        Top,
+		LoadStatus: func() shards.LoadStatus { return status },
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	readyz := func() (int, serverStatus) {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var got serverStatus
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("json.Decode: %v", err)
+		}
+		return res.StatusCode, got
+	}
+
+	// Not ready while the shards found on startup are loading.
+	code, got := readyz()
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d while loading, want %d", code, http.StatusServiceUnavailable)
+	}
+	if got.Shards == nil || *got.Shards != status {
+		t.Errorf("got shard status %+v, want %+v", got.Shards, status)
+	}
+	if got.Memory.TotalBytes == 0 {
+		t.Error("got no memory usage")
+	}
+
+	status = shards.LoadStatus{Ready: true, Loaded: 3, WatcherError: "boom"}
+	code, got = readyz()
+	if code != http.StatusOK {
+		t.Errorf("got status code %d once ready, want %d", code, http.StatusOK)
+	}
+	if got.Shards == nil || got.Shards.WatcherError != "boom" {
+		t.Errorf("got shard status %+v, want the watcher error", got.Shards)
+	}
 }
 
 func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
@@ -979,3 +1158,151 @@ func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
 		t.Fatalf("unexpected results (-want, +got):\n%s", d)
 	}
 }
+
+func TestOpenSearch(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name: "github.com/org/zoekt",
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	for _, name := range []string{"cmd/main.go", "README.md"} {
+		if err := b.Add(zoekt.Document{Name: name, Content: []byte("content")}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/opensearch.xml", []string{
+		`<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">`,
+		`template="` + ts.URL + `/search?q={searchTerms}"`,
+		`template="` + ts.URL + `/suggest?q={searchTerms}"`,
+	})
+	checkNeedles(t, ts, "/", []string{`<link rel="search" type="application/opensearchdescription+xml" href="/opensearch.xml"`})
+
+	suggest := func(ts *httptest.Server, q string, want []string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/suggest?q=" + url.QueryEscape(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []any
+		err = json.NewDecoder(res.Body).Decode(&got)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var completions []string
+		for _, c := range got[1].([]any) {
+			completions = append(completions, c.(string))
+		}
+		if got[0] != q || !cmp.Equal(completions, want, cmpopts.EquateEmpty()) {
+			t.Errorf("suggest(%q): got %v, want [%q %q]", q, got, q, want)
+		}
+	}
+
+	for q, want := range map[string][]string{
+		"ZOE":         {`r:github\.com/org/zoekt`},
+		"foo r:zoe":   {`foo r:github\.com/org/zoekt`},
+		"file:main":   {`file:cmd/main\.go`},
+		"README":      {`f:README\.md`},
+		"lang:go":     {},
+		"r:z":         {},
+		"nonexistent": {},
+	} {
+		suggest(ts, q, want)
+	}
+
+	// Repository names are quoted like file names.
+	b, err = zoekt.NewIndexBuilder(&zoekt.Repository{Name: "github.com/org/c++"})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	mux, err = NewMux(&Server{Searcher: searcherForTest(t, b), Top: Top, HTML: true})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	cppTS := httptest.NewServer(mux)
+	defer cppTS.Close()
+	suggest(cppTS, "c++", []string{`r:github\.com/org/c\+\+`})
+}
+
+func TestChunks(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{
+		Name:     "f1",
+		Content:  []byte("one\ntwo needle\nthree\nfour needle needle\nfive\nsix\nseven"),
+		Branches: []string{"master"},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/search?q=needle&format=json&chunks=true&ctx=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var result ApiSearchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Result.FileMatches) != 1 {
+		t.Fatalf("got %d file matches, want 1", len(result.Result.FileMatches))
+	}
+	f := result.Result.FileMatches[0]
+	if len(f.Matches) != 0 {
+		t.Fatalf("got line matches %+v for a chunk search", f.Matches)
+	}
+
+	// The matches and their context lines merge into one chunk.
+	want := []Chunk{{
+		URL:       "print?b=master&f=f1&q=needle&r=name#l1",
+		FileName:  "f1",
+		StartLine: 1,
+		Lines: []ChunkLine{
+			{LineNum: 1, Fragments: []Fragment{{Post: "one"}}},
+			{LineNum: 2, Fragments: []Fragment{{Pre: "two ", Match: "needle"}}},
+			{LineNum: 3, Fragments: []Fragment{{Post: "three"}}},
+			{LineNum: 4, Fragments: []Fragment{{Pre: "four ", Match: "needle"}, {Pre: " ", Match: "needle"}}},
+			{LineNum: 5, Fragments: []Fragment{{Post: "five"}}},
+		},
+	}}
+	if diff := cmp.Diff(want, f.Chunks, cmpopts.IgnoreFields(Chunk{}, "Score", "ScoreDebug")); diff != "" {
+		t.Fatalf("chunks mismatch (-want +got):\n%s", diff)
+	}
+
+	checkNeedles(t, ts, "/search?q=needle&chunks=true&ctx=1", []string{
+		"<u>3</u>: </span>three\n",
+		"four <b>needle</b> <b>needle</b>\n",
+		`<input id="chunks" name="chunks" type="hidden" value="true">`,
+	})
+}
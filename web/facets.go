@@ -0,0 +1,96 @@
+package web
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// maxFacetValues is the number of values we show per facet.
+const maxFacetValues = 10
+
+// Facets counts the result files by language, repository and file
+// extension, so the results page can offer to narrow the search down.
+type Facets struct {
+	Languages    []Facet
+	Repositories []Facet
+	Extensions   []Facet
+}
+
+// Facet is a value of a facet and the number of result files with it.
+type Facet struct {
+	Value string
+	Count int
+
+	// URL is the search refined to files with this value.
+	URL string
+}
+
+// computeFacets aggregates files into facets. Each facet holds its
+// maxFacetValues most frequent values. The URLs of the values add a filter
+// to the search described by last, which must be in zoekt query syntax.
+func computeFacets(files []zoekt.FileMatch, last LastInput) *Facets {
+	if len(files) == 0 {
+		return nil
+	}
+
+	langs := map[string]int{}
+	repos := map[string]int{}
+	exts := map[string]int{}
+	for _, f := range files {
+		if f.Language != "" {
+			langs[f.Language]++
+		}
+		repos[f.Repository]++
+		if ext := path.Ext(f.FileName); ext != "" {
+			exts[ext]++
+		}
+	}
+
+	refine := func(counts map[string]int, atom func(string) string) []Facet {
+		facets := make([]Facet, 0, len(counts))
+		for v, n := range counts {
+			facets = append(facets, Facet{Value: v, Count: n})
+		}
+		sort.Slice(facets, func(i, j int) bool {
+			if facets[i].Count != facets[j].Count {
+				return facets[i].Count > facets[j].Count
+			}
+			return facets[i].Value < facets[j].Value
+		})
+		if len(facets) > maxFacetValues {
+			facets = facets[:maxFacetValues]
+		}
+		for i := range facets {
+			refined := last
+			refined.Query = strings.TrimSpace(last.Query) + " " + atom(facets[i].Value)
+			facets[i].URL = permalink(refined, "")
+		}
+		return facets
+	}
+
+	return &Facets{
+		Languages: refine(langs, func(v string) string {
+			return "lang:" + quoteAtom(v)
+		}),
+		Repositories: refine(repos, func(v string) string {
+			return "r:" + quoteAtom("^"+regexp.QuoteMeta(v)+"$")
+		}),
+		Extensions: refine(exts, func(v string) string {
+			return "f:" + quoteAtom(regexp.QuoteMeta(v)+"$")
+		}),
+	}
+}
+
+// quoteAtom quotes the value of a query atom if the query parser would split
+// it otherwise.
+func quoteAtom(v string) string {
+	if strings.ContainsAny(v, " \t\"()") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	return v
+}
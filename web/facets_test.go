@@ -0,0 +1,82 @@
+package web
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestComputeFacets(t *testing.T) {
+	files := []zoekt.FileMatch{
+		{Repository: "github.com/a/b", FileName: "main.go", Language: "Go"},
+		{Repository: "github.com/a/b", FileName: "lib.go", Language: "Go"},
+		{Repository: "my repo", FileName: "Makefile", Language: "Makefile"},
+		{Repository: "my repo", FileName: "x.c", Language: "C"},
+	}
+	facets := computeFacets(files, LastInput{Query: "needle ", Num: defaultNumResults})
+
+	type value struct {
+		Value string
+		Count int
+		Query string
+	}
+	values := func(fs []Facet) []value {
+		var vs []value
+		for _, f := range fs {
+			u, err := url.Parse(f.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query().Get("q")
+			if _, err := query.Parse(q); err != nil {
+				t.Errorf("refined query %q: %v", q, err)
+			}
+			vs = append(vs, value{f.Value, f.Count, q})
+		}
+		return vs
+	}
+
+	want := map[string][]value{
+		"languages": {
+			{"Go", 2, `needle lang:Go`},
+			{"C", 1, `needle lang:C`},
+			{"Makefile", 1, `needle lang:Makefile`},
+		},
+		"repositories": {
+			{"github.com/a/b", 2, `needle r:^github\.com/a/b$`},
+			{"my repo", 2, `needle r:"^my repo$"`},
+		},
+		"extensions": {
+			{".go", 2, `needle f:\.go$`},
+			{".c", 1, `needle f:\.c$`},
+		},
+	}
+	got := map[string][]value{
+		"languages":    values(facets.Languages),
+		"repositories": values(facets.Repositories),
+		"extensions":   values(facets.Extensions),
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	if facets := computeFacets(nil, LastInput{Query: "needle"}); facets != nil {
+		t.Errorf("got facets %+v for no files", facets)
+	}
+}
+
+func TestQuoteAtom(t *testing.T) {
+	for in, want := range map[string]string{
+		`^a\.b$`:    `^a\.b$`,
+		`^my repo$`: `"^my repo$"`,
+		`a"b\.c`:    `"a\"b\\.c"`,
+	} {
+		if got := quoteAtom(in); got != want {
+			t.Errorf("quoteAtom(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	// maxSuggestions is the maximum number of completions returned by
+	// /suggest.
+	maxSuggestions = 10
+
+	// suggestTimeout bounds the time spent computing suggestions. Browsers
+	// ask for suggestions on every keystroke, so this should be short.
+	suggestTimeout = 500 * time.Millisecond
+)
+
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr,omitempty"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// baseURL returns the scheme and host under which the client reached us.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host
+}
+
+// serveOpenSearch serves an OpenSearch description document, which lets
+// browsers register zoekt as a search engine.
+func (s *Server) serveOpenSearch(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	desc := openSearchDescription{
+		ShortName:     "Zoekt",
+		Description:   "Code search on " + r.Host,
+		InputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: "text/html", Method: "get", Template: base + "/search?q={searchTerms}"},
+			{Type: "application/x-suggestions+json", Method: "get", Template: base + "/suggest?q={searchTerms}"},
+			{Type: "application/opensearchdescription+xml", Rel: "self", Template: base + "/opensearch.xml"},
+		},
+	}
+
+	out, err := xml.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+// serveSuggest returns completions for the last term of the query in the
+// OpenSearch suggestions format, ie. ["query", ["completion", ...]].
+func (s *Server) serveSuggest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	ctx, cancel := context.WithTimeout(r.Context(), suggestTimeout)
+	defer cancel()
+
+	suggestions, err := s.suggest(ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	_ = json.NewEncoder(w).Encode([]any{q, suggestions})
+}
+
+// suggest completes the last whitespace separated term of q. Terms with a
+// repo: or file: prefix complete to repository and file names respectively,
+// other terms to both.
+func (s *Server) suggest(ctx context.Context, q string) ([]string, error) {
+	suggestions := []string{}
+
+	i := strings.LastIndexAny(q, " \t") + 1
+	head, last := q[:i], q[i:]
+
+	field, term := "", last
+	if j := strings.Index(last, ":"); j >= 0 {
+		field, term = last[:j+1], last[j+1:]
+	}
+
+	var repos, files bool
+	switch field {
+	case "r:", "repo:":
+		repos = true
+	case "f:", "file:":
+		files = true
+	case "":
+		repos, files = true, true
+	default:
+		return suggestions, nil
+	}
+	// Single characters match too much to be useful.
+	if len(term) < 2 {
+		return suggestions, nil
+	}
+
+	if repos {
+		rl, err := s.Searcher.List(ctx, &query.Repo{Regexp: regexp.MustCompile("(?i)" + regexp.QuoteMeta(term))}, &zoekt.ListOptions{
+			Sort:    zoekt.RepoListSortName,
+			Limit:   maxSuggestions,
+			Minimal: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prefix := field
+		if prefix == "" {
+			prefix = "r:"
+		}
+		for _, e := range rl.Repos {
+			suggestions = append(suggestions, head+prefix+regexp.QuoteMeta(e.Repository.Name))
+		}
+	}
+
+	if files && len(suggestions) < maxSuggestions {
+		sr, err := s.Searcher.Search(ctx, &query.Substring{Pattern: term, FileName: true}, &zoekt.SearchOptions{
+			ShardMaxMatchCount: maxSuggestions,
+			TotalMaxMatchCount: 10 * maxSuggestions,
+			MaxDocDisplayCount: maxSuggestions,
+			MaxWallTime:        suggestTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prefix := field
+		if prefix == "" {
+			prefix = "f:"
+		}
+		seen := map[string]bool{}
+		for _, f := range sr.Files {
+			if seen[f.FileName] || len(suggestions) >= maxSuggestions {
+				continue
+			}
+			seen[f.FileName] = true
+			suggestions = append(suggestions, head+prefix+regexp.QuoteMeta(f.FileName))
+		}
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,32 @@
+package web
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// permalink returns the canonical URL of the search last describes: the
+// parameters which determine its results, without defaults, in a fixed
+// order. Searches which only differ in parameters like debug or the order of
+// the parameters share a permalink.
+func permalink(last LastInput, syntax string) string {
+	v := url.Values{}
+	v.Set("q", last.Query)
+	if last.Num != defaultNumResults {
+		v.Set("num", strconv.Itoa(last.Num))
+	}
+	if last.Ctx > 0 {
+		v.Set("ctx", strconv.Itoa(last.Ctx))
+	}
+	if last.Chunks {
+		v.Set("chunks", "true")
+	}
+	if last.Group != "" {
+		v.Set("group", last.Group)
+	}
+	if syntax != "" {
+		v.Set("syntax", syntax)
+	}
+	// Encode sorts by key.
+	return "/search?" + v.Encode()
+}
@@ -0,0 +1,20 @@
+package web
+
+import "testing"
+
+func TestPermalink(t *testing.T) {
+	for _, tc := range []struct {
+		last   LastInput
+		syntax string
+		want   string
+	}{
+		{LastInput{Query: "needle", Num: defaultNumResults}, "", "/search?q=needle"},
+		{LastInput{Query: "a b", Num: 10, Debug: true, AutoFocus: true}, "", "/search?num=10&q=a+b"},
+		{LastInput{Query: "x", Num: defaultNumResults, Ctx: 3, Chunks: true, Group: "cluster"}, "", "/search?chunks=true&ctx=3&group=cluster&q=x"},
+		{LastInput{Query: "x", Num: defaultNumResults}, "github", "/search?q=x&syntax=github"},
+	} {
+		if got := permalink(tc.last, tc.syntax); got != tc.want {
+			t.Errorf("permalink(%+v, %q) = %q, want %q", tc.last, tc.syntax, got, tc.want)
+		}
+	}
+}
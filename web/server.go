@@ -23,7 +23,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"path"
 	"regexp/syntax"
+	"runtime/metrics"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,9 +36,12 @@ import (
 	"github.com/grafana/regexp"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/graphql"
+	"github.com/sourcegraph/zoekt/internal/auth"
 	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
 	zjson "github.com/sourcegraph/zoekt/json"
 	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
 )
 
 var Funcmap = template.FuncMap{
@@ -77,6 +82,7 @@ var Funcmap = template.FuncMap{
 	"TrimTrailingNewline": func(s string) string {
 		return strings.TrimSuffix(s, "\n")
 	},
+	"Base": path.Base,
 }
 
 const defaultNumResults = 50
@@ -90,12 +96,19 @@ type Server struct {
 	// Serve RPC
 	RPC bool
 
+	// Serve the GraphQL API
+	GraphQL bool
+
 	// If set, show files from the index.
 	Print bool
 
 	// Version string for this server.
 	Version string
 
+	// LoadStatus, if set, reports how far loading the shards searched by
+	// Searcher got. /readyz fails until they are ready.
+	LoadStatus func() shards.LoadStatus
+
 	// Depending on the Host header, add a query to the entry
 	// page. For example, when serving on "search.myproject.org"
 	// we could add "r:myproject" automatically.  This allows a
@@ -106,14 +119,16 @@ type Server struct {
 	// This should contain the following templates: "repolist"
 	// (for the repo search result page), "result" for
 	// the search results, "search" (for the opening page),
-	// "box" for the search query input element and
-	// "print" for the show file functionality.
+	// "box" for the search query input element,
+	// "print" for the show file functionality and "browse" for
+	// the directory listing.
 	Top *template.Template
 
 	repolist *template.Template
 	search   *template.Template
 	result   *template.Template
 	print    *template.Template
+	browse   *template.Template
 	about    *template.Template
 	robots   *template.Template
 
@@ -171,6 +186,7 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	for k, v := range map[string]**template.Template{
 		"results":  &s.result,
 		"print":    &s.print,
+		"browse":   &s.browse,
 		"search":   &s.search,
 		"repolist": &s.repolist,
 		"about":    &s.about,
@@ -194,12 +210,20 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 		mux.HandleFunc("/", s.serveSearchBox)
 		mux.HandleFunc("/about", s.serveAbout)
 		mux.HandleFunc("/print", s.servePrint)
+		mux.HandleFunc("/browse", s.serveBrowse)
+		mux.HandleFunc("/opensearch.xml", s.serveOpenSearch)
+		mux.HandleFunc("/suggest", s.serveSuggest)
 	}
 	if s.RPC {
 		mux.Handle("/api/", http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher})))
 	}
+	if s.GraphQL {
+		mux.Handle("/graphql", graphql.Handler(traceAwareSearcher{s.Searcher}))
+		mux.HandleFunc("/graphql/schema.graphql", graphql.ServeSchema)
+	}
 
 	mux.HandleFunc("/healthz", s.serveHealthz)
+	mux.HandleFunc("/readyz", s.serveReadyz)
 
 	return mux, nil
 }
@@ -219,7 +243,75 @@ func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	_ = json.NewEncoder(w).Encode(result)
+	// The result holds repository and file names of any tenant, so probes
+	// which didn't authenticate only get the status.
+	if auth.IsUnauthenticated(r.Context()) {
+		_ = json.NewEncoder(w).Encode(struct{ Status serverStatus }{s.status()})
+		return
+	}
+
+	// The status is added next to the fields of the result, so clients which
+	// decode the body as a zoekt.SearchResult keep working.
+	_ = json.NewEncoder(w).Encode(struct {
+		*zoekt.SearchResult
+		Status serverStatus
+	}{result, s.status()})
+}
+
+// serverStatus is the body of /readyz, and is included in the body of
+// /healthz.
+type serverStatus struct {
+	// Shards is nil if the server has no LoadStatus.
+	Shards *shards.LoadStatus `json:",omitempty"`
+
+	Memory memoryStatus
+}
+
+// memoryStatus is the memory usage of the process, see runtime/metrics.
+type memoryStatus struct {
+	HeapObjectsBytes uint64
+	TotalBytes       uint64
+}
+
+// readMemoryStatus uses runtime/metrics since it runs on every health check,
+// and unlike runtime.ReadMemStats it doesn't stop the world.
+func readMemoryStatus() memoryStatus {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/memory/classes/total:bytes"},
+	}
+	metrics.Read(samples)
+
+	var ms memoryStatus
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		ms.HeapObjectsBytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		ms.TotalBytes = samples[1].Value.Uint64()
+	}
+	return ms
+}
+
+func (s *Server) status() serverStatus {
+	st := serverStatus{Memory: readMemoryStatus()}
+	if s.LoadStatus != nil {
+		loadStatus := s.LoadStatus()
+		st.Shards = &loadStatus
+	}
+	return st
+}
+
+// serveReadyz reports whether the server should get traffic, which is once
+// the shards found on startup are loaded. Unlike /healthz it doesn't search,
+// so a server which is still loading is alive but not ready.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	st := s.status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if st.Shards != nil && !st.Shards.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(st)
 }
 
 func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
@@ -254,13 +346,23 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 	qvals := r.URL.Query()
 
 	debugScore, _ := strconv.ParseBool(qvals.Get("debug"))
+	profile, _ := strconv.ParseBool(qvals.Get("profile"))
+	if profile && !auth.IsAdmin(r.Context()) {
+		return nil, fmt.Errorf("only admins may profile searches")
+	}
+	chunks, _ := strconv.ParseBool(qvals.Get("chunks"))
+
+	group := qvals.Get("group")
+	if group != "" && group != "cluster" {
+		return nil, fmt.Errorf("got unknown grouping %q, allowed cluster", group)
+	}
 
 	queryStr := qvals.Get("q")
 	if queryStr == "" {
 		return nil, fmt.Errorf("no query found")
 	}
 
-	q, err := query.Parse(queryStr)
+	q, err := query.ParseSyntax(qvals.Get("syntax"), queryStr)
 	if err != nil {
 		return nil, err
 	}
@@ -305,10 +407,12 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 		}
 	}
 	sOpts.NumContextLines = numCtxLines
+	sOpts.ChunkMatches = chunks
 
 	sOpts.SetDefaults()
 	sOpts.MaxDocDisplayCount = num
 	sOpts.DebugScore = debugScore
+	sOpts.Profile = profile
 
 	ctx := r.Context()
 	if err := zjson.CalculateDefaultSearchLimits(ctx, q, s.Searcher, &sOpts); err != nil {
@@ -330,19 +434,37 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 			Query:     queryStr,
 			Num:       num,
 			Ctx:       numCtxLines,
+			Chunks:    chunks,
 			AutoFocus: true,
 		},
 		Stats:       result.Stats,
 		Query:       q.String(),
 		QueryStr:    queryStr,
 		FileMatches: fileMatches,
+		Directories: result.Directories,
 	}
 	if res.Stats.Wait < res.Stats.Duration/10 {
 		// Suppress queueing stats if they are neglible.
 		res.Stats.Wait = 0
 	}
 
+	if group == "cluster" {
+		res.Clusters, err = s.clusterResults(ctx, fileMatches)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	res.Last.Debug = debugScore
+	res.Last.Group = group
+	res.Permalink = permalink(res.Last, qvals.Get("syntax"))
+	if syntax := qvals.Get("syntax"); syntax == "" || syntax == query.SyntaxZoekt {
+		// The facets refine the query with atoms in zoekt syntax.
+		res.Facets = computeFacets(result.Files, res.Last)
+	}
+	for _, fm := range fileMatches {
+		fm.Permalink = res.Permalink + "#" + fm.ResultID
+	}
 	return &ApiSearchResult{Result: &res}, nil
 }
 
@@ -544,6 +666,7 @@ func (s *Server) serveListReposErr(q query.Q, qStr string, r *http.Request) (*Re
 			Size:       r.Stats.ContentBytes,
 			MemorySize: r.Stats.IndexBytes,
 			Files:      int64(r.Stats.Documents),
+			Metadata:   r.Repository.Metadata,
 		}
 		for _, b := range r.Repository.Branches {
 			var buf bytes.Buffer
@@ -621,9 +744,11 @@ func (s *Server) servePrintErr(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	d := PrintInput{
-		Name:  f.FileName,
-		Repo:  f.Repository,
-		Lines: strLines,
+		Name:   f.FileName,
+		Repo:   f.Repository,
+		Branch: qvals.Get("b"),
+		Lines:  strLines,
+		Crumbs: crumbs(path.Dir(f.FileName)),
 		Last: LastInput{
 			Query:     queryStr,
 			Num:       num,
@@ -56,7 +56,12 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			log.Printf("fragment template: %v", err)
 			return ""
 		}
-		return buf.String()
+		fragment := buf.String()
+		if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
+			// TODO - remove this is backward compatibility glue.
+			fragment = "#" + fragment
+		}
+		return fragment
 	}
 	getURL := func(repo, filename string, branches []string, version string) string {
 		tpl := templateMap[repo]
@@ -116,10 +121,6 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 
 		for _, m := range f.LineMatches {
 			fragment := getFragment(f.Repository, m.LineNumber)
-			if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
-				// TODO - remove this is backward compatibility glue.
-				fragment = "#" + fragment
-			}
 			md := Match{
 				FileName: f.FileName,
 				LineNum:  m.LineNumber,
@@ -150,7 +151,63 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			}
 			fMatch.Matches = append(fMatch.Matches, md)
 		}
+
+		for _, c := range f.ChunkMatches {
+			if c.FileName {
+				continue
+			}
+			line := int(c.ContentStart.LineNumber)
+			fMatch.Chunks = append(fMatch.Chunks, Chunk{
+				URL:       fMatch.URL + getFragment(f.Repository, line),
+				FileName:  f.FileName,
+				StartLine: line,
+				Lines:     chunkLines(&c),
+
+				Score:      c.Score,
+				ScoreDebug: c.DebugScore,
+			})
+		}
 		fmatches = append(fmatches, &fMatch)
 	}
 	return fmatches, nil
 }
+
+// chunkLines splits the content of c into lines, with the ranges of c as
+// matches.
+func chunkLines(c *zoekt.ChunkMatch) []ChunkLine {
+	var lines []ChunkLine
+	base := int(c.ContentStart.ByteOffset)
+	off := 0
+	for i, l := range bytes.SplitAfter(c.Content, []byte{'\n'}) {
+		if len(l) == 0 {
+			continue
+		}
+		end := off + len(bytes.TrimSuffix(l, []byte{'\n'}))
+
+		cl := ChunkLine{LineNum: int(c.ContentStart.LineNumber) + i}
+		last := off
+		for _, r := range c.Ranges {
+			// Ranges may span several lines, so clip them to this one.
+			s := max(int(r.Start.ByteOffset)-base, last)
+			e := min(int(r.End.ByteOffset)-base, end)
+			if s >= e {
+				continue
+			}
+			cl.Fragments = append(cl.Fragments, Fragment{
+				Pre:   string(c.Content[last:s]),
+				Match: string(c.Content[s:e]),
+			})
+			last = e
+		}
+		rest := string(c.Content[last:end])
+		if n := len(cl.Fragments); n > 0 {
+			cl.Fragments[n-1].Post = rest
+		} else {
+			cl.Fragments = []Fragment{{Post: rest}}
+		}
+
+		lines = append(lines, cl)
+		off += len(l)
+	}
+	return lines
+}
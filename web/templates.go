@@ -29,6 +29,7 @@ var TemplateText = map[string]string{
 <meta charset="utf-8">
 <meta http-equiv="X-UA-Compatible" content="IE=edge">
 <meta name="viewport" content="width=device-width, initial-scale=1">
+<link rel="search" type="application/opensearchdescription+xml" href="/opensearch.xml" title="Zoekt">
 <!-- Licensed under MIT (https://github.com/twbs/bootstrap/blob/master/LICENSE) -->
 <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css" integrity="sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
 <style>
@@ -69,6 +70,55 @@ var TemplateText = map[string]string{
 </head>
   `,
 
+	// search history, kept in the local storage of the browser.
+	"history": `
+<script>
+var zoektHistoryKey = "zoekt-history";
+var zoektHistoryMax = 50;
+function zoektHistory() {
+  try {
+    return JSON.parse(localStorage.getItem(zoektHistoryKey)) || [];
+  } catch (e) {
+    return [];
+  }
+}
+function zoektHistorySave(h) {
+  try {
+    localStorage.setItem(zoektHistoryKey, JSON.stringify(h.slice(0, zoektHistoryMax)));
+  } catch (e) {
+    // Storage is disabled or full.
+  }
+}
+function zoektHistoryAdd(query, url) {
+  var h = zoektHistory().filter(function(e) { return e.url !== url; });
+  h.unshift({query: query, url: url, time: Date.now()});
+  zoektHistorySave(h);
+}
+function zoektHistoryClear() {
+  zoektHistorySave([]);
+  document.getElementById("history").style.display = "none";
+  return false;
+}
+function zoektHistoryShow() {
+  var h = zoektHistory();
+  var list = document.getElementById("history-list");
+  if (h.length === 0 || !list) {
+    return;
+  }
+  h.forEach(function(e) {
+    var a = document.createElement("a");
+    a.href = e.url;
+    a.textContent = e.query;
+    var li = document.createElement("li");
+    li.title = new Date(e.time).toLocaleString();
+    li.appendChild(a);
+    list.appendChild(li);
+  });
+  document.getElementById("history").style.display = "";
+}
+</script>
+`,
+
 	"jsdep": `
 <script src="https://ajax.googleapis.com/ajax/libs/jquery/1.12.4/jquery.min.js"></script>
 <script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/js/bootstrap.min.js" integrity="sha384-Tc5IQib027qvyjSMfHjOMaLkfuWVxZxUPnCJA7l2mCWNIpG9mGCD8wGNIcPD7Txa" crossorigin="anonymous"></script>
@@ -120,6 +170,9 @@ var TemplateText = map[string]string{
           <button class="btn btn-primary">Search</button>
           <!--Hack: we use a hidden form field to keep track of the debug flag across searches-->
           {{if .Debug}}<input id="debug" name="debug" type="hidden" value="{{.Debug}}">{{end}}
+          {{if .Group}}<input id="group" name="group" type="hidden" value="{{.Group}}">{{end}}
+          {{if .Chunks}}<input id="chunks" name="chunks" type="hidden" value="{{.Chunks}}">{{end}}
+          {{if .Ctx}}<input id="ctx" name="ctx" type="hidden" value="{{.Ctx}}">{{end}}
         </div>
       </form>
     </div>
@@ -184,6 +237,10 @@ document.onkeydown=function(e){
           <dt><a href="search?q=r:droid">r:droid</a></dt><dd>list repositories whose name contains "droid".</dd>
           <dt><a href="search?q=r:go+-r:google">r:go -r:google</a></dt><dd>list repositories whose name contains "go" but not "google".</dd>
         </dl>
+        <div id="history" style="display: none">
+          <h3>Recent searches <small><a href="#" onclick="return zoektHistoryClear()">clear</a></small></h3>
+          <ul id="history-list" class="list-unstyled"></ul>
+        </div>
       </div>
     </div>
   </div>
@@ -197,6 +254,8 @@ document.onkeydown=function(e){
       </p>
     </div>
   </nav>
+  {{template "history"}}
+  <script>zoektHistoryShow();</script>
 </body>
 </html>
 `,
@@ -217,12 +276,14 @@ document.onkeydown=function(e){
     <h5>
       {{if .Stats.Crashes}}<br><b>{{.Stats.Crashes}} shards crashed</b><br>{{end}}
       {{ $fileCount := len .FileMatches }}
-      Found {{.Stats.MatchCount}} results in {{.Stats.FileCount}} files{{if or (lt $fileCount .Stats.FileCount) (or (gt .Stats.ShardsSkipped 0) (gt .Stats.FilesSkipped 0)) }},
+      Found {{.Stats.MatchCount}} results in {{.Stats.FileCount}} files{{if .Directories}} in {{len .Directories}} directories.
+      {{else if or (lt $fileCount .Stats.FileCount) (or (gt .Stats.ShardsSkipped 0) (gt .Stats.FilesSkipped 0)) }},
         showing top {{ $fileCount }} files (<a rel="nofollow"
-           href="search?q={{.Last.Query}}&num={{More .Last.Num}}">show more</a>).
+           href="search?q={{.Last.Query}}&num={{More .Last.Num}}{{if .Last.Group}}&group={{.Last.Group}}{{end}}{{if .Last.Chunks}}&chunks=true{{end}}{{if .Last.Ctx}}&ctx={{.Last.Ctx}}{{end}}">show more</a>).
       {{else}}.{{end}}
+      <a href="{{.Permalink}}" title="link to these results">permalink</a>
     </h5>
-    {{range .FileMatches}}
+    {{- define "fileMatch"}}
     <table class="table table-hover table-condensed">
       <thead>
         <tr>
@@ -235,6 +296,7 @@ document.onkeydown=function(e){
                    title="restrict search to files written in {{.Language}}"
                    onclick="zoektAddQ('lang:&quot;{{.Language}}&quot;')" class="label label-primary">language {{.Language}}</button></span>{{end}}
               {{if .DuplicateID}}<a class="label label-dup" href="#{{.DuplicateID}}">Duplicate result</a>{{end}}
+              {{if .Permalink}}<a class="label label-default" href="{{.Permalink}}" title="link to this result">#</a>{{end}}
             </small>
           </th>
         </tr>
@@ -249,11 +311,57 @@ document.onkeydown=function(e){
           </td>
         </tr>
         {{end}}
+        {{end}}
+        {{range .Chunks}}
+        <tr>
+          <td style="background-color: rgba(238, 238, 255, 0.6);">
+            <pre class="inline-pre">{{$url := .URL}}{{range $i, $line := .Lines}}<span class="noselect">{{if and $url (eq $i 0)}}<a href="{{$url}}">{{end}}<u>{{$line.LineNum}}</u>{{if and $url (eq $i 0)}}</a>{{end}}: </span>{{range $line.Fragments}}{{LimitPre 100 .Pre}}{{if .Match}}<b>{{.Match}}</b>{{end}}{{LimitPost 100 .Post}}{{end}}
+{{end}}{{if .ScoreDebug}}<i>({{.ScoreDebug}})</i>{{end}}</pre>
+          </td>
+        </tr>
+        {{end}}
       </tbody>
       {{end}}
+    </table>
+    {{- end}}
+    {{- define "facet"}}
+    <div class="list-group">
+      {{range .}}
+      <a class="list-group-item" href="{{.URL}}" title="restrict search to {{.Value}}"><span class="badge">{{.Count}}</span>{{.Value}}</a>
       {{end}}
+    </div>
+    {{- end}}
+    <div class="row">
+    <div class="{{if .Facets}}col-md-10{{else}}col-md-12{{end}}">
+    {{if .Clusters}}
+    {{range .Clusters}}
+    <h4>{{.Name}} <small>{{range .Repos}}<span class="label label-default">{{.}}</span> {{end}}</small></h4>
+    {{range .FileMatches}}{{template "fileMatch" .}}{{end}}
+    {{end}}
+    {{else}}
+    {{range .FileMatches}}{{template "fileMatch" .}}{{end}}
+    {{end}}
+    {{if .Directories}}
+    <table class="table table-hover table-condensed">
+      <thead>
+        <tr><th>Directory</th><th>Files</th><th>Results</th></tr>
+      </thead>
+      <tbody>
+        {{range .Directories}}
+        <tr><td>{{.Repository}}:{{.Directory}}</td><td>{{.FileCount}}</td><td>{{.MatchCount}}</td></tr>
+        {{end}}
+      </tbody>
     </table>
     {{end}}
+    </div>
+    {{with .Facets}}
+    <div class="col-md-2">
+      {{if gt (len .Languages) 1}}<h5>Languages</h5>{{template "facet" .Languages}}{{end}}
+      {{if gt (len .Repositories) 1}}<h5>Repositories</h5>{{template "facet" .Repositories}}{{end}}
+      {{if gt (len .Extensions) 1}}<h5>Extensions</h5>{{template "facet" .Extensions}}{{end}}
+    </div>
+    {{end}}
+    </div>
 
   <nav class="navbar navbar-default navbar-bottom">
     <div class="container">
@@ -275,6 +383,8 @@ document.onkeydown=function(e){
   </nav>
   </div>
   {{ template "jsdep"}}
+  {{template "history"}}
+  <script>zoektHistoryAdd({{.QueryStr}}, {{.Permalink}});</script>
 </body>
 </html>
 `,
@@ -302,7 +412,8 @@ document.onkeydown=function(e){
       <tbody>
 	{{range .Repos -}}
 	<tr>
-	  <td>{{if .URL}}<a href="{{.URL}}">{{end}}{{.Name}}{{if .URL}}</a>{{end}}</td>
+	  <td><a href="browse?r={{.Name}}">{{.Name}}</a>{{if .URL}} <a class="small" href="{{.URL}}">source</a>{{end}}
+	    {{- range $k, $v := .Metadata}} <span class="label label-info small">{{$k}}={{$v}}</span>{{end}}</td>
 	  <td><small>{{.IndexTime.Format "Jan 02, 2006 15:04"}}</small></td>
 	  <td style="vertical-align: middle;">
 	    {{- range .Branches -}}
@@ -328,6 +439,57 @@ document.onkeydown=function(e){
   {{ template "jsdep"}}
 </body>
 </html>
+`,
+
+	// links to the directories in .Crumbs, for the breadcrumbs of the print
+	// and browse templates.
+	"crumbs": `
+  {{- $repo := .Repo}}{{$branch := .Branch}}
+  {{- range .Crumbs}}
+  <li><a href="browse?r={{$repo}}{{if $branch}}&b={{$branch}}{{end}}{{if .Path}}&p={{.Path}}{{end}}">{{if .Name}}{{.Name}}{{else}}{{$repo}}{{end}}</a></li>
+  {{- end}}
+`,
+
+	"browse": `
+<html>
+  {{template "head"}}
+  <title>{{.Repo}}:{{.Path}}</title>
+<body id="results">
+  {{template "navbar" .Last}}
+  <div class="container-fluid container-results">
+    <ol class="breadcrumb">
+      {{- template "crumbs" .}}
+      <li class="active">{{if .Path}}{{Base .Path}}{{else}}{{.Repo}}{{end}}</li>
+    </ol>
+    {{$repo := .Repo}}{{$branch := .Branch}}
+    <table class="table table-hover table-condensed">
+      <tbody>
+        {{range .Entries}}
+        <tr>
+          {{if .Dir}}
+          <td><a href="browse?r={{$repo}}{{if $branch}}&b={{$branch}}{{end}}&p={{.Path}}">{{.Name}}/</a></td>
+          <td><small>{{.Files}} files</small></td>
+          {{else}}
+          <td><a href="print?r={{$repo}}{{if $branch}}&b={{$branch}}{{end}}&f={{.Path}}">{{.Name}}</a></td>
+          <td><small>{{.Language}}</small></td>
+          {{end}}
+        </tr>
+        {{else}}
+        <tr><td>No files.</td></tr>
+        {{end}}
+      </tbody>
+    </table>
+  <nav class="navbar navbar-default navbar-bottom">
+    <div class="container">
+      {{template "footerBoilerplate"}}
+      <p class="navbar-text navbar-right">
+      </p>
+    </div>
+  </nav>
+  </div>
+ {{ template "jsdep"}}
+</body>
+</html>
 `,
 
 	"print": `
@@ -337,7 +499,10 @@ document.onkeydown=function(e){
 <body id="results">
   {{template "navbar" .Last}}
   <div class="container-fluid container-results" >
-     <div><b>{{.Name}}</b></div>
+     <ol class="breadcrumb">
+       {{- template "crumbs" .}}
+       <li class="active"><b>{{Base .Name}}</b></li>
+     </ol>
      <div class="table table-hover table-condensed" style="overflow:auto; background: #eef;">
        {{ range $index, $ln := .Lines}}
 	 <pre id="l{{Inc $index}}" class="inline-pre"><span class="noselect"><a href="#l{{Inc $index}}">{{Inc $index}}</a>: </span>{{$ln}}</pre>
@@ -26,10 +26,9 @@ func (s traceAwareSearcher) Search(
 	opts *zoekt.SearchOptions,
 ) (*zoekt.SearchResult, error) {
 	ctx = trace.WithOpenTracingEnabled(ctx, opts.Trace)
-	spanContext := trace.SpanContextFromContext(ctx)
-	if opts.Trace && spanContext != nil {
+	if opts.Trace {
 		var span opentracing.Span
-		span, ctx = opentracing.StartSpanFromContext(ctx, "zoekt.traceAwareSearcher.Search", opentracing.ChildOf(spanContext))
+		span, ctx = opentracing.StartSpanFromContext(ctx, "zoekt.traceAwareSearcher.Search", childOf(ctx)...)
 		defer span.Finish()
 	}
 	return s.Searcher.Search(ctx, q, opts)
@@ -42,15 +41,23 @@ func (s traceAwareSearcher) StreamSearch(
 	sender zoekt.Sender,
 ) error {
 	ctx = trace.WithOpenTracingEnabled(ctx, opts.Trace)
-	spanContext := trace.SpanContextFromContext(ctx)
-	if opts.Trace && spanContext != nil {
+	if opts.Trace {
 		var span opentracing.Span
-		span, ctx = opentracing.StartSpanFromContext(ctx, "zoekt.traceAwareSearcher.StreamSearch", opentracing.ChildOf(spanContext))
+		span, ctx = opentracing.StartSpanFromContext(ctx, "zoekt.traceAwareSearcher.StreamSearch", childOf(ctx)...)
 		defer span.Finish()
 	}
 	return s.Searcher.StreamSearch(ctx, q, opts, sender)
 }
 
+// childOf returns the options to continue the trace of the caller, if the
+// request carried one. Otherwise the search starts a new trace.
+func childOf(ctx context.Context) []opentracing.StartSpanOption {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext != nil {
+		return []opentracing.StartSpanOption{opentracing.ChildOf(spanContext)}
+	}
+	return nil
+}
+
 func (s traceAwareSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
 	return s.Searcher.List(ctx, q, opts)
 }
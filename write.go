@@ -23,6 +23,8 @@ import (
 	"io"
 	"sort"
 	"time"
+
+	"github.com/sourcegraph/zoekt/internal/bloom"
 )
 
 func (w *writer) writeTOC(toc *indexTOC) {
@@ -43,6 +45,30 @@ func (w *writer) writeTOC(toc *indexTOC) {
 		w.Varint(uint32(s.sec.kind()))
 		s.sec.write(w)
 	}
+	for _, s := range toc.sectionsTaggedOptionalList() {
+		if !sectionWritten(s.sec) {
+			continue
+		}
+		w.String(s.tag)
+		w.Varint(uint32(s.sec.kind()))
+		s.sec.write(w)
+	}
+	for _, s := range toc.carried {
+		w.String(s.tag)
+		w.Varint(uint32(s.sec.kind()))
+		s.sec.write(w)
+	}
+}
+
+// sectionWritten returns true if data was written for sec.
+func sectionWritten(sec section) bool {
+	switch s := sec.(type) {
+	case *simpleSection:
+		return s.off != 0
+	case *compoundSection:
+		return s.index.off != 0
+	}
+	return true
 }
 
 func (s *compoundSection) writeStrings(w *writer, strs []*searchableString) {
@@ -66,14 +92,16 @@ func (s *compoundSection) writeMap(w *writer, m map[string]uint32) {
 	s.writeStrings(w, keys)
 }
 
+// writePostings writes the ngrams and posting lists of s. If stats is not
+// nil, the posting lists are added to it.
 func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 	charOffsets *simpleSection, postings *compoundSection, endRunes *simpleSection,
+	stats *ShardStats,
 ) {
-	keys := make(ngramSlice, 0, len(s.postings))
-	for k := range s.postings {
-		keys = append(keys, k)
+	keys, err := s.ngrams()
+	if err != nil && w.err == nil {
+		w.err = err
 	}
-	sort.Sort(keys)
 
 	ngramText.start(w)
 	for _, k := range keys {
@@ -84,8 +112,14 @@ func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 	ngramText.end(w)
 
 	postings.start(w)
-	for _, k := range keys {
-		postings.addItem(w, s.postings[k])
+	err = s.mergePostings(keys, func(p []byte) {
+		postings.addItem(w, p)
+		if stats != nil {
+			stats.addPostingList(p)
+		}
+	})
+	if err != nil && w.err == nil {
+		w.err = err
 	}
 	postings.end(w)
 
@@ -100,6 +134,7 @@ func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 
 func (b *IndexBuilder) Write(out io.Writer) error {
 	next := b.indexFormatVersion == NextIndexFormatVersion
+	defer b.contentPostings.closeSpill()
 
 	buffered := bufio.NewWriterSize(out, 1<<20)
 	defer buffered.Flush()
@@ -140,12 +175,16 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	}
 	toc.fileSections.end(w)
 
-	writePostings(w, b.contentPostings, &toc.ngramText, &toc.runeOffsets, &toc.postings, &toc.fileEndRunes)
+	stats := ShardStats{
+		Documents:    len(b.contentStrings),
+		ContentBytes: int64(b.contentPostings.endByte),
+	}
+	writePostings(w, b.contentPostings, &toc.ngramText, &toc.runeOffsets, &toc.postings, &toc.fileEndRunes, &stats)
 
 	// names.
 	toc.fileNames.writeStrings(w, b.nameStrings)
 
-	writePostings(w, b.namePostings, &toc.nameNgramText, &toc.nameRuneOffsets, &toc.namePostings, &toc.nameEndRunes)
+	writePostings(w, b.namePostings, &toc.nameNgramText, &toc.nameRuneOffsets, &toc.namePostings, &toc.nameEndRunes, nil)
 
 	toc.subRepos.start(w)
 	w.Write(toSizedDeltas(b.subRepos))
@@ -163,6 +202,56 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	w.Write(marshalDocSections(b.runeDocSections))
 	toc.runeDocSections.end(w)
 
+	if b.hasDependencies {
+		toc.dependencies.start(w)
+		for _, d := range b.dependencies {
+			toc.dependencies.addItem(w, d)
+		}
+		toc.dependencies.end(w)
+	}
+
+	if b.FileMetrics {
+		toc.fileMetrics.start(w)
+		w.Write(b.fileMetrics)
+		toc.fileMetrics.end(w)
+	}
+
+	if b.BloomFilter {
+		f := bloom.New(int(b.contentPostings.runeCount))
+		for _, s := range b.contentStrings {
+			f.Add(s.data)
+		}
+		toc.contentGramBloom.start(w)
+		w.Write(f.Bytes())
+		toc.contentGramBloom.end(w)
+	}
+
+	if b.hasSecrets {
+		toc.secrets.start(w)
+		for _, s := range b.secrets {
+			toc.secrets.addItem(w, s)
+		}
+		toc.secrets.end(w)
+	}
+
+	if b.hasEncodings {
+		toc.encodings.start(w)
+		for _, e := range b.encodings {
+			toc.encodings.addItem(w, e)
+		}
+		toc.encodings.end(w)
+	}
+
+	if b.hasGenerated {
+		toc.generated.start(w)
+		w.Write(b.generated)
+		toc.generated.end(w)
+	}
+
+	for _, c := range b.carried {
+		toc.carried = append(toc.carried, taggedSection{tag: c.tag, sec: c.write(w)})
+	}
+
 	if next {
 		toc.repos.start(w)
 		w.Write(toSizedDeltas16(b.repos))
@@ -174,7 +263,7 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 		indexTime = time.Now().UTC()
 	}
 
-	if err := b.writeJSON(&IndexMetadata{
+	metaData := &IndexMetadata{
 		IndexFormatVersion:    b.indexFormatVersion,
 		IndexTime:             indexTime,
 		IndexFeatureVersion:   b.featureVersion,
@@ -183,7 +272,15 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 		LanguageMap:           b.languageMap,
 		ZoektVersion:          Version,
 		ID:                    b.ID,
-	}, &toc.metaData, w); err != nil {
+		CJKBigrams:            b.contentPostings.hasCJKBigrams || b.namePostings.hasCJKBigrams,
+		SymbolsOnly:           b.SymbolsOnly,
+	}
+	if n := b.contentPostings.ngramSize; n != ngramSize {
+		metaData.NgramSize = n
+		metaData.IndexMinReaderVersion = NgramSizeFeatureVersion
+	}
+
+	if err := b.writeJSON(metaData, &toc.metaData, w); err != nil {
 		return err
 	}
 
@@ -200,6 +297,15 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 		}
 	}
 
+	if err := b.writeJSON(&stats, &toc.planStats, w); err != nil {
+		return err
+	}
+
+	sums := encodeSectionChecksums(append(toc.sectionsTaggedList(), toc.sectionsTaggedOptionalList()...), w.sums)
+	toc.sectionChecksums.start(w)
+	w.Write(sums)
+	toc.sectionChecksums.end(w)
+
 	var tocSection simpleSection
 
 	tocSection.start(w)